@@ -0,0 +1,366 @@
+// Package bench measures the performance of the send path from an RTP flow
+// through a transport to a receiver on the loopback interface, and compares
+// the results to a baseline to detect regressions.
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/histogram"
+	"github.com/Willi-42/rtp-over-quic/options"
+	"github.com/Willi-42/rtp-over-quic/quic"
+	"github.com/Willi-42/rtp-over-quic/tcp"
+	"github.com/Willi-42/rtp-over-quic/udp"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	ssrc = 0x62656e63
+
+	connectAttempts = 20
+	connectInterval = 100 * time.Millisecond
+
+	// latencyPackets are sent at latencyInterval after the throughput
+	// benchmark, so that the latency is measured without queueing.
+	latencyPackets  = 500
+	latencyInterval = time.Millisecond
+	// drainTimeout is the time to wait for the last latency packets.
+	drainTimeout = time.Second
+)
+
+var errInvalidMode = errors.New("invalid benchmark mode")
+
+// Modes are the transport modes benchmarked by default.
+var Modes = []string{"quic-dgram", "quic-stream", "udp", "tcp"}
+
+// Result is the performance of one transport mode. Latencies are measured
+// from writing a packet to the flow until the receiver reads it.
+type Result struct {
+	Mode          string  `json:"mode"`
+	PacketsPerSec float64 `json:"packets_per_sec"`
+	NsPerOp       int64   `json:"ns_per_op"`
+	AllocsPerOp   int64   `json:"allocs_per_op"`
+	BytesPerOp    int64   `json:"bytes_per_op"`
+	LatencyP50    int64   `json:"latency_p50_us"`
+	LatencyP99    int64   `json:"latency_p99_us"`
+}
+
+type receiver struct {
+	lock     sync.Mutex
+	latency  *histogram.Histogram
+	received uint64
+}
+
+func (r *receiver) Read(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+	var header rtp.Header
+	n, err := header.Unmarshal(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.received++
+	if len(b)-n < 8 {
+		return len(b), a, nil
+	}
+	sent := int64(binary.BigEndian.Uint64(b[n:]))
+	if sent > 0 {
+		r.latency.Record(time.Since(time.Unix(0, sent)).Microseconds())
+	}
+	return len(b), a, nil
+}
+
+func (r *receiver) reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.latency = histogram.New()
+	r.received = 0
+}
+
+func (r *receiver) count() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.received
+}
+
+type rtpReaderSetter interface {
+	SetRTPReader(interceptor.RTPReader)
+}
+
+// session is a flow of a transport mode to a receiver on the loopback
+// interface.
+type session struct {
+	mode      string
+	cancel    context.CancelFunc
+	serverErr chan error
+	writer    interceptor.RTPWriter
+	r         *receiver
+	header    *rtp.Header
+	payload   []byte
+	writeErr  error
+}
+
+// newSession starts a server for mode and connects a flow sending packets of
+// size bytes including the RTP header to it.
+func newSession(ctx context.Context, mode string, size int) (*session, error) {
+	if size < 12+8 {
+		return nil, fmt.Errorf("%w: packet size must be at least 20 bytes, got %v", errInvalidMode, size)
+	}
+	addr, err := freeAddress(mode)
+	if err != nil {
+		return nil, err
+	}
+	t := &options.Transport{
+		Transport: mode,
+		Addr:      addr,
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidMode, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &session{
+		mode:      mode,
+		cancel:    cancel,
+		serverErr: make(chan error, 1),
+		r:         &receiver{latency: histogram.New()},
+		header: &rtp.Header{
+			Version:     2,
+			PayloadType: 96,
+			SSRC:        ssrc,
+		},
+		payload: make([]byte, size-12),
+	}
+	go func() {
+		s.serverErr <- startServer(ctx, t, func(h rtpReaderSetter) {
+			h.SetRTPReader(s.r)
+		})
+	}()
+	s.writer, err = connect(ctx, t)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+// benchmarkWrite writes b.N packets to the flow, which is the throughput of
+// the send path.
+func (s *session) benchmarkWrite(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(s.header.MarshalSize() + len(s.payload)))
+	for i := 0; i < b.N; i++ {
+		s.header.SequenceNumber++
+		// no send time, the throughput phase is not used for the latency
+		if _, err := s.writer.Write(s.header, s.payload, interceptor.Attributes{}); err != nil {
+			s.writeErr = err
+			b.Fatal(err)
+		}
+	}
+}
+
+// measureLatency sends latencyPackets at latencyInterval, after the packets
+// queued by previous writes drained, and records their latency.
+func (s *session) measureLatency() error {
+	time.Sleep(drainTimeout)
+	s.r.reset()
+	ticker := time.NewTicker(latencyInterval)
+	defer ticker.Stop()
+	for i := 0; i < latencyPackets; i++ {
+		<-ticker.C
+		s.header.SequenceNumber++
+		binary.BigEndian.PutUint64(s.payload, uint64(time.Now().UnixNano()))
+		if _, err := s.writer.Write(s.header, s.payload, interceptor.Attributes{}); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(drainTimeout)
+	for s.r.count() < latencyPackets && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if received := s.r.count(); received < latencyPackets {
+		log.Printf("%v: received %v of %v latency packets", s.mode, received, latencyPackets)
+	}
+	return nil
+}
+
+// close stops the server and returns its error, if any.
+func (s *session) close() error {
+	s.cancel()
+	return <-s.serverErr
+}
+
+// Run benchmarks mode with packets of size bytes including the RTP header.
+// The throughput is measured by the write benchmark also run by 'go test
+// -bench', the latency afterwards.
+func Run(ctx context.Context, mode string, size int) (Result, error) {
+	s, err := newSession(ctx, mode, size)
+	if err != nil {
+		return Result{}, err
+	}
+	br := testing.Benchmark(s.benchmarkWrite)
+	if s.writeErr != nil {
+		s.close()
+		return Result{}, s.writeErr
+	}
+	if err := s.measureLatency(); err != nil {
+		s.close()
+		return Result{}, err
+	}
+	if err := s.close(); err != nil {
+		return Result{}, err
+	}
+
+	s.r.lock.Lock()
+	defer s.r.lock.Unlock()
+	return Result{
+		Mode:          mode,
+		PacketsPerSec: float64(br.N) / br.T.Seconds(),
+		NsPerOp:       br.NsPerOp(),
+		AllocsPerOp:   br.AllocsPerOp(),
+		BytesPerOp:    br.AllocedBytesPerOp(),
+		LatencyP50:    s.r.latency.ValueAtQuantile(0.5),
+		LatencyP99:    s.r.latency.ValueAtQuantile(0.99),
+	}, nil
+}
+
+func startServer(ctx context.Context, t *options.Transport, onHandler func(rtpReaderSetter)) error {
+	switch {
+	case options.IsQUIC(t.Transport):
+		opts, err := t.QUICServerOptions()
+		if err != nil {
+			return err
+		}
+		server, err := quic.NewServer(opts...)
+		if err != nil {
+			return err
+		}
+		server.OnNewHandler(func(h *quic.Handler) error {
+			onHandler(h)
+			return nil
+		})
+		return server.Start(ctx)
+	case t.Transport == "udp":
+		opts, err := t.UDPServerOptions()
+		if err != nil {
+			return err
+		}
+		server, err := udp.NewServer(opts...)
+		if err != nil {
+			return err
+		}
+		server.OnNewHandler(func(h *udp.Handler) error {
+			onHandler(h)
+			return nil
+		})
+		return server.Start(ctx)
+	case t.Transport == "tcp":
+		opts, err := t.TCPServerOptions()
+		if err != nil {
+			return err
+		}
+		server, err := tcp.NewServer(opts...)
+		if err != nil {
+			return err
+		}
+		server.OnNewHandler(func(h *tcp.Handler) error {
+			onHandler(h)
+			return nil
+		})
+		return server.Start(ctx)
+	}
+	return fmt.Errorf("%w: %v", errInvalidMode, t.Transport)
+}
+
+// connect connects a sender to the server, which may not be listening yet,
+// and returns the writer of a new flow.
+func connect(ctx context.Context, t *options.Transport) (interceptor.RTPWriter, error) {
+	var err error
+	for i := 0; i < connectAttempts; i++ {
+		var writer interceptor.RTPWriter
+		writer, err = newFlow(ctx, t)
+		if err == nil {
+			return writer, nil
+		}
+		select {
+		case <-time.After(connectInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf("failed to connect to benchmark server: %w", err)
+}
+
+func newFlow(ctx context.Context, t *options.Transport) (interceptor.RTPWriter, error) {
+	registry := &interceptor.Registry{}
+	switch {
+	case options.IsQUIC(t.Transport):
+		opts, err := t.QUICSenderOptions()
+		if err != nil {
+			return nil, err
+		}
+		sender, err := quic.NewSender(registry, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if err := sender.Connect(ctx); err != nil {
+			return nil, err
+		}
+		return sender.NewMediaStream()
+	case t.Transport == "udp":
+		opts, err := t.UDPSenderOptions()
+		if err != nil {
+			return nil, err
+		}
+		sender, err := udp.NewSender(registry, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if err := sender.Connect(ctx); err != nil {
+			return nil, err
+		}
+		return sender.NewMediaStream(), nil
+	case t.Transport == "tcp":
+		opts, err := t.TCPSenderOptions()
+		if err != nil {
+			return nil, err
+		}
+		sender, err := tcp.NewSender(registry, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if err := sender.Connect(ctx); err != nil {
+			return nil, err
+		}
+		return sender.NewMediaStream(), nil
+	}
+	return nil, fmt.Errorf("%w: %v", errInvalidMode, t.Transport)
+}
+
+// freeAddress returns a loopback address with a port which is currently
+// unused by the network of mode.
+func freeAddress(mode string) (string, error) {
+	if mode == "tcp" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		defer l.Close()
+		return l.Addr().String(), nil
+	}
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	return c.LocalAddr().String(), nil
+}
@@ -0,0 +1,89 @@
+package bench
+
+import (
+	"context"
+	"testing"
+)
+
+// BenchmarkWrite benchmarks writing packets to a flow of each mode, which is
+// the throughput reported by the bench command.
+func BenchmarkWrite(b *testing.B) {
+	for _, mode := range Modes {
+		b.Run(mode, func(b *testing.B) {
+			s, err := newSession(context.Background(), mode, 1200)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			s.benchmarkWrite(b)
+			b.StopTimer()
+			if err := s.close(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRunInvalidMode(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		mode string
+		size int
+	}{
+		{name: "unknown mode", mode: "sctp", size: 1200},
+		{name: "packet too small", mode: "udp", size: 19},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Run(context.Background(), tc.mode, tc.size); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := []Result{{
+		Mode:          "udp",
+		PacketsPerSec: 1000,
+		AllocsPerOp:   10,
+		LatencyP50:    100,
+		LatencyP99:    1000,
+	}}
+	thresholds := Thresholds{Throughput: 0.1, Allocs: 0.1, Latency: 0.25}
+	for _, tc := range []struct {
+		name    string
+		current Result
+		metrics []string
+	}{
+		{
+			name:    "within thresholds",
+			current: Result{Mode: "udp", PacketsPerSec: 901, AllocsPerOp: 12, LatencyP50: 125, LatencyP99: 1250},
+		},
+		{
+			name:    "throughput",
+			current: Result{Mode: "udp", PacketsPerSec: 899, AllocsPerOp: 10, LatencyP50: 100, LatencyP99: 1000},
+			metrics: []string{"packets/s"},
+		},
+		{
+			name:    "allocations and latency",
+			current: Result{Mode: "udp", PacketsPerSec: 1000, AllocsPerOp: 13, LatencyP50: 126, LatencyP99: 1251},
+			metrics: []string{"allocs/op", "p50 latency (us)", "p99 latency (us)"},
+		},
+		{
+			name:    "mode missing in baseline",
+			current: Result{Mode: "tcp"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			regressions := Compare(baseline, []Result{tc.current}, thresholds)
+			if len(regressions) != len(tc.metrics) {
+				t.Fatalf("got regressions %v, want %v", regressions, tc.metrics)
+			}
+			for i, r := range regressions {
+				if r.Metric != tc.metrics[i] {
+					t.Errorf("got regression %v, want %v", r, tc.metrics[i])
+				}
+			}
+		})
+	}
+}
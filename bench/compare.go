@@ -0,0 +1,85 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Thresholds are the relative changes to a baseline which are tolerated,
+// e.g., 0.1 allows 10% fewer packets per second.
+type Thresholds struct {
+	Throughput float64
+	Allocs     float64
+	Latency    float64
+}
+
+// Regression is a metric of a mode which exceeds its threshold.
+type Regression struct {
+	Mode     string
+	Metric   string
+	Baseline float64
+	Current  float64
+}
+
+func (r Regression) String() string {
+	change := 0.0
+	if r.Baseline != 0 {
+		change = 100 * (r.Current - r.Baseline) / r.Baseline
+	}
+	return fmt.Sprintf("%v: %v %.2f -> %.2f (%+.1f%%)", r.Mode, r.Metric, r.Baseline, r.Current, change)
+}
+
+// Compare returns the regressions of current compared to baseline. Modes
+// missing in baseline are not compared.
+func Compare(baseline, current []Result, t Thresholds) []Regression {
+	base := map[string]Result{}
+	for _, r := range baseline {
+		base[r.Mode] = r
+	}
+	regressions := []Regression{}
+	for _, c := range current {
+		b, ok := base[c.Mode]
+		if !ok {
+			continue
+		}
+		if c.PacketsPerSec < b.PacketsPerSec*(1-t.Throughput) {
+			regressions = append(regressions, Regression{c.Mode, "packets/s", b.PacketsPerSec, c.PacketsPerSec})
+		}
+		// allow one more allocation, so that small counts don't fail on
+		// any change
+		if float64(c.AllocsPerOp) > float64(b.AllocsPerOp)*(1+t.Allocs)+1 {
+			regressions = append(regressions, Regression{c.Mode, "allocs/op", float64(b.AllocsPerOp), float64(c.AllocsPerOp)})
+		}
+		if float64(c.LatencyP50) > float64(b.LatencyP50)*(1+t.Latency) {
+			regressions = append(regressions, Regression{c.Mode, "p50 latency (us)", float64(b.LatencyP50), float64(c.LatencyP50)})
+		}
+		if float64(c.LatencyP99) > float64(b.LatencyP99)*(1+t.Latency) {
+			regressions = append(regressions, Regression{c.Mode, "p99 latency (us)", float64(b.LatencyP99), float64(c.LatencyP99)})
+		}
+	}
+	return regressions
+}
+
+// ReadResults reads results written by WriteResults.
+func ReadResults(file string) ([]Result, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	if err := json.Unmarshal(buf, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse benchmark results %v: %w", file, err)
+	}
+	return results, nil
+}
+
+// WriteResults writes results as JSON to file, which can be used as
+// baseline for Compare.
+func WriteResults(file string, results []Result) error {
+	buf, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(buf, '\n'), 0o644)
+}
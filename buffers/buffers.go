@@ -0,0 +1,63 @@
+// Package buffers pools the byte slices frames and packets are copied into
+// on their way from the media pipelines to the transports, so that sending
+// at high rates doesn't allocate per frame and packet. A Buffer is owned by
+// the code which got it until it calls Release, after which neither the
+// Buffer nor slices of B must be used.
+package buffers
+
+import "sync"
+
+// classes are the capacities of the pooled buffers: packets, small and
+// large encoded frames. Larger buffers are not pooled.
+var classes = []int{2 * 1024, 16 * 1024, 128 * 1024, 1024 * 1024}
+
+var pools = func() []*sync.Pool {
+	pools := make([]*sync.Pool, len(classes))
+	for i, size := range classes {
+		size := size
+		pools[i] = &sync.Pool{
+			New: func() interface{} {
+				return &Buffer{B: make([]byte, 0, size)}
+			},
+		}
+	}
+	return pools
+}()
+
+// Buffer is a pooled byte slice.
+type Buffer struct {
+	B []byte
+}
+
+// Get returns a buffer of length size.
+func Get(size int) *Buffer {
+	for i, c := range classes {
+		if size <= c {
+			b := pools[i].Get().(*Buffer)
+			b.B = b.B[:size]
+			return b
+		}
+	}
+	return &Buffer{B: make([]byte, size)}
+}
+
+// Copy returns a buffer holding a copy of p.
+func Copy(p []byte) *Buffer {
+	b := Get(len(p))
+	copy(b.B, p)
+	return b
+}
+
+// Release returns b to its pool. Releasing a nil Buffer does nothing.
+func (b *Buffer) Release() {
+	if b == nil {
+		return
+	}
+	for i, c := range classes {
+		if cap(b.B) == c {
+			b.B = b.B[:0]
+			pools[i].Put(b)
+			return
+		}
+	}
+}
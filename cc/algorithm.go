@@ -8,8 +8,10 @@ const (
 	Reno Algorithm = iota
 	Cubic
 	BBR
+	Copa
 	SCReAM
 	GCC
+	NADA
 	NONE
 )
 
@@ -21,10 +23,14 @@ func AlgorithmFromString(a string) Algorithm {
 		return Cubic
 	case "bbr":
 		return BBR
+	case "copa":
+		return Copa
 	case "scream":
 		return SCReAM
 	case "gcc":
 		return GCC
+	case "nada":
+		return NADA
 	case "none":
 		return NONE
 	default:
@@ -41,10 +47,14 @@ func (a Algorithm) String() string {
 		return "cubic"
 	case BBR:
 		return "bbr"
+	case Copa:
+		return "copa"
 	case SCReAM:
 		return "scream"
 	case GCC:
 		return "gcc"
+	case NADA:
+		return "nada"
 	case NONE:
 		return "none"
 	default:
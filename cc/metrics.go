@@ -0,0 +1,25 @@
+package cc
+
+import "time"
+
+// Metrics is a snapshot of the internal state of a congestion controller.
+// Values a controller does not track are zero.
+type Metrics struct {
+	// TargetBitrate is the estimated available bandwidth in bit/s.
+	TargetBitrate int
+	// PacingRate is the rate in bit/s packets are paced at.
+	PacingRate int
+	// Cwnd is the congestion window in bytes.
+	Cwnd int
+
+	RTT        time.Duration
+	QueueDelay time.Duration
+	LossRate   float64
+}
+
+// MetricsSource is implemented by congestion controllers which expose their
+// internal state. Estimators of algorithms added using Register may implement
+// it, too.
+type MetricsSource interface {
+	Metrics() Metrics
+}
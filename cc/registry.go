@@ -0,0 +1,96 @@
+package cc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pion/interceptor"
+)
+
+var (
+	errAlgorithmExists = errors.New("congestion control algorithm already registered")
+	errInvalidFactory  = errors.New("invalid congestion control factory")
+)
+
+// BandwidthEstimator is a sender side RTP congestion controller. The target
+// bitrate is polled periodically and passed to the media source, the stats are
+// written to the congestion control log.
+type BandwidthEstimator interface {
+	GetTargetBitrate() int
+	GetStats() map[string]interface{}
+}
+
+type NewEstimatorCallback func(id string, estimator BandwidthEstimator)
+
+// Config is passed to a Factory when the sender sets up its interceptors.
+type Config struct {
+	InitialBitrate int
+	// MinBitrate and MaxBitrate bound the target bitrate in bit/s.
+	MinBitrate int
+	MaxBitrate int
+
+	// OnNewEstimator has to be called for every estimator created by the
+	// interceptors returned by the Factory.
+	OnNewEstimator NewEstimatorCallback
+}
+
+// Factory creates the interceptors of an RTP congestion controller. The
+// interceptors are registered in order after SRTP and the packet log and
+// before RED. Feedback generation at the receiver is not part of the Factory,
+// an algorithm has to use one of the existing feedback formats.
+type Factory func(Config) ([]interceptor.Factory, error)
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]Factory{}
+)
+
+// Register makes an RTP congestion controller available under name, e.g.,
+// for the --rtp-cc flag of the sender. It is typically called from an init
+// function. Names of the built-in algorithms can't be registered.
+func Register(name string, f Factory) error {
+	if f == nil {
+		return fmt.Errorf("%w: nil factory for %v", errInvalidFactory, name)
+	}
+	if isBuiltin(name) {
+		return fmt.Errorf("%w: %v is a built-in algorithm", errAlgorithmExists, name)
+	}
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[name]; ok {
+		return fmt.Errorf("%w: %v", errAlgorithmExists, name)
+	}
+	registry[name] = f
+	return nil
+}
+
+// Lookup returns the Factory registered for name.
+func Lookup(name string) (Factory, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	f, ok := registry[name]
+	return f, ok
+}
+
+// Registered returns the sorted names of all registered algorithms.
+func Registered() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isBuiltin(name string) bool {
+	for a := Reno; a <= NONE; a++ {
+		if a.String() == name {
+			return true
+		}
+	}
+	return false
+}
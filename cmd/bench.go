@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Willi-42/rtp-over-quic/bench"
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchModes      []string
+	benchPacketSize int
+	benchOutput     string
+	benchBaseline   string
+
+	maxThroughputRegression float64
+	maxAllocRegression      float64
+	maxLatencyRegression    float64
+)
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+
+	benchCmd.Flags().StringSliceVar(&benchModes, "modes", bench.Modes, "Transport modes to benchmark")
+	benchCmd.Flags().IntVar(&benchPacketSize, "packet-size", 1200, "Size of the benchmark RTP packets in bytes including the RTP header")
+	benchCmd.Flags().StringVar(&benchOutput, "output", "", "JSON file to write the results to, which can be used as baseline for --compare")
+	benchCmd.Flags().StringVar(&benchBaseline, "compare", "", "JSON file of baseline results to compare against, fails if a regression exceeds its threshold")
+	benchCmd.Flags().Float64Var(&maxThroughputRegression, "max-throughput-regression", 0.1, "Tolerated relative decrease of packets per second")
+	benchCmd.Flags().Float64Var(&maxAllocRegression, "max-alloc-regression", 0.1, "Tolerated relative increase of allocations per packet")
+	benchCmd.Flags().Float64Var(&maxLatencyRegression, "max-latency-regression", 0.25, "Tolerated relative increase of the p50 and p99 latency")
+}
+
+var benchCmd = &cobra.Command{
+	Use: "bench",
+	Run: func(cmd *cobra.Command, _ []string) {
+		if err := runBench(cmd); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func runBench(cmd *cobra.Command) error {
+	var baseline []bench.Result
+	if len(benchBaseline) > 0 {
+		var err error
+		baseline, err = bench.ReadResults(benchBaseline)
+		if err != nil {
+			return err
+		}
+	}
+	results := []bench.Result{}
+	for _, mode := range benchModes {
+		r, err := bench.Run(cmd.Context(), mode, benchPacketSize)
+		if err != nil {
+			return fmt.Errorf("benchmark %v failed: %w", mode, err)
+		}
+		fmt.Printf(
+			"%-12v %12.0f packets/s %8v ns/op %4v allocs/op %6v B/op %6v us p50 %6v us p99\n",
+			r.Mode, r.PacketsPerSec, r.NsPerOp, r.AllocsPerOp, r.BytesPerOp, r.LatencyP50, r.LatencyP99,
+		)
+		results = append(results, r)
+	}
+	if len(benchOutput) > 0 {
+		if err := bench.WriteResults(benchOutput, results); err != nil {
+			return err
+		}
+	}
+	if baseline == nil {
+		return nil
+	}
+	regressions := bench.Compare(baseline, results, bench.Thresholds{
+		Throughput: maxThroughputRegression,
+		Allocs:     maxAllocRegression,
+		Latency:    maxLatencyRegression,
+	})
+	if len(regressions) == 0 {
+		log.Printf("no regressions compared to %v", benchBaseline)
+		return nil
+	}
+	lines := make([]string, 0, len(regressions))
+	for _, r := range regressions {
+		lines = append(lines, r.String())
+	}
+	return fmt.Errorf("%w: regressions compared to %v:\n%v", errBenchRegression, benchBaseline, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,478 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/media"
+	"github.com/Willi-42/rtp-over-quic/metrics"
+	"github.com/Willi-42/rtp-over-quic/options"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/pion/interceptor"
+	pionrtp "github.com/pion/rtp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+}
+
+var checkCmd = &cobra.Command{
+	Use: "check",
+	Run: func(cmd *cobra.Command, _ []string) {
+		if err := check(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// checker collects all problems of a configuration instead of stopping at
+// the first one.
+type checker struct {
+	problems []string
+	notes    []string
+}
+
+func (c *checker) check(err error) {
+	if err != nil {
+		c.problems = append(c.problems, err.Error())
+	}
+}
+
+func (c *checker) fail(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+func (c *checker) note(format string, args ...interface{}) {
+	c.notes = append(c.notes, fmt.Sprintf(format, args...))
+}
+
+// check validates the configuration given by --config for the command named
+// in the configuration and prints the effective configuration.
+func check() error {
+	if len(configFile) == 0 {
+		return fmt.Errorf("%w: check requires --config", errInvalidConfig)
+	}
+	conf, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	var cmd *cobra.Command
+	switch conf.command {
+	case sendCmd.Name():
+		cmd = sendCmd
+	case receiveCmd.Name():
+		cmd = receiveCmd
+	default:
+		return fmt.Errorf("%w: %v must set 'command' to 'send' or 'receive', got '%v'", errInvalidConfig, configFile, conf.command)
+	}
+	if err := applyEnv(cmd); err != nil {
+		return err
+	}
+	if err := conf.apply(cmd); err != nil {
+		return err
+	}
+
+	c := &checker{}
+	c.checkCommon()
+	if cmd == sendCmd {
+		c.checkSender()
+	} else {
+		c.checkReceiver()
+	}
+
+	printEffectiveConfig(cmd)
+	for _, n := range c.notes {
+		fmt.Printf("note: %v\n", n)
+	}
+	if len(c.problems) > 0 {
+		for _, p := range c.problems {
+			fmt.Printf("error: %v\n", p)
+		}
+		return fmt.Errorf("%w: found %v problems in %v", errInvalidConfig, len(c.problems), configFile)
+	}
+	fmt.Printf("%v is a valid configuration for %v\n", configFile, cmd.Name())
+	return nil
+}
+
+func (c *checker) checkCommon() {
+	if latencyLog < 0 {
+		c.fail("%v: --latency-interval must not be negative", errInvalidConfig)
+	}
+	if logDrops < 0 {
+		c.fail("%v: --log-drops must not be negative", errInvalidConfig)
+	}
+	if pipelineRestarts < 0 {
+		c.fail("%v: --pipeline-restarts must not be negative", errInvalidConfig)
+	}
+	for _, f := range []string{rtpDumpFile, rtcpDumpFile, keyLogFile, latencyFile} {
+		c.checkOutputFile(f)
+	}
+	if dumpFormat != rtp.PacketLogText && dumpFormat != rtp.PacketLogRTPDump {
+		c.fail("%v: unknown --dump-format %v", errInvalidConfig, dumpFormat)
+	}
+	if showDashboard && (rtpDumpFile == "stdout" || rtcpDumpFile == "stdout" || qlogDir == "stdout") {
+		c.note("--dashboard is overwritten by logs written to stdout")
+	}
+	if len(qlogDir) > 0 && qlogDir != "stdout" {
+		if info, err := os.Stat(qlogDir); err == nil && !info.IsDir() {
+			c.fail("%v: --qlog %v is not a directory", errInvalidConfig, qlogDir)
+		}
+	}
+	c.checkOutputFile(statsFile)
+	c.checkOutputFile(pcapFile)
+	if statsFormat != metrics.StatsCSV && statsFormat != metrics.StatsNDJSON {
+		c.fail("%v: --stats-format must be 'csv' or 'ndjson', got %v", errInvalidConfig, statsFormat)
+	}
+	if statsInterval <= 0 {
+		c.fail("%v: --stats-interval must be positive, got %v", errInvalidConfig, statsInterval)
+	}
+	if len(otlpEndpoint) > 0 {
+		if u, err := url.Parse(otlpEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			c.fail("%v: --otlp-endpoint must be an http or https URL, got %v", errInvalidConfig, otlpEndpoint)
+		}
+	}
+	if len(resultsDir) > 0 {
+		if info, err := os.Stat(resultsDir); err != nil {
+			c.fail("%v: --results-dir: %v", errInvalidConfig, err)
+		} else if !info.IsDir() {
+			c.fail("%v: --results-dir %v is not a directory", errInvalidConfig, resultsDir)
+		}
+	}
+	if len(influxURL) > 0 {
+		if u, err := url.Parse(influxURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			c.fail("%v: --influx-url must be an http or https URL, got %v", errInvalidConfig, influxURL)
+		}
+		if influxInterval <= 0 {
+			c.fail("%v: --influx-interval must be positive, got %v", errInvalidConfig, influxInterval)
+		}
+	}
+	if len(uploadURL) > 0 {
+		if len(resultsDir) == 0 {
+			c.fail("%v: --upload requires --results-dir", errInvalidConfig)
+		}
+		u, err := url.Parse(uploadURL)
+		switch {
+		case err != nil:
+			c.fail("%v: --upload: %v", errInvalidConfig, err)
+		case u.Scheme == "s3":
+			if len(os.Getenv("AWS_ACCESS_KEY_ID")) == 0 || len(os.Getenv("AWS_SECRET_ACCESS_KEY")) == 0 {
+				c.fail("%v: --upload to S3 requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", errInvalidConfig)
+			}
+		case u.Scheme != "http" && u.Scheme != "https":
+			c.fail("%v: --upload must be an http, https or s3 URL, got %v", errInvalidConfig, uploadURL)
+		}
+	}
+}
+
+func (c *checker) checkSender() {
+	conf, err := senderConfig()
+	c.check(err)
+	// the pacer is created on start, so it is checked separately
+	for _, err := range conf.Problems() {
+		c.check(err)
+	}
+	for _, s := range conf.CCSwitches {
+		if (s.Algorithm == cc.GCC.String()) != (rtpCC == cc.GCC.String()) {
+			c.note("--rtp-cc-switch to %v changes the required --rtcp-feedback of the receiver", s.Algorithm)
+		}
+	}
+	if len(bweEvalTrace) > 0 {
+		_, err := rtp.LoadCapacityTrace(bweEvalTrace)
+		c.check(err)
+	}
+	_, err = media.NewRateController(nil, media.MinTargetBitrate(encoderMinBitrate), media.MaxTargetBitrate(encoderMaxBitrate), media.Headroom(encoderHeadroom))
+	c.check(err)
+	if probe && rtpCC == cc.NONE.String() {
+		c.fail("%v: --probe requires --rtp-cc", errInvalidCCConfig)
+	}
+	if freezeAppLimited && rtpCC == cc.NONE.String() {
+		c.fail("%v: --freeze-app-limited requires --rtp-cc", errInvalidCCConfig)
+	}
+	if fsePriority <= 0 {
+		c.fail("%v: --priority must be positive, got %v", errInvalidCCConfig, fsePriority)
+	}
+	if transport == options.Auto && autoTimeout <= 0 {
+		c.fail("%v: --auto-timeout must be positive, got %v", errInvalidConfig, autoTimeout)
+	}
+	if !options.IsQUIC(transport) {
+		if quicCCTarget {
+			c.fail("%v: --quic-cc-target requires a QUIC transport", errInvalidCCConfig)
+		}
+		if pacingInterval > 0 {
+			c.fail("%v: --pacing-interval requires a QUIC transport", errInvalidConfig)
+		}
+	}
+	_, err = rtp.ReliabilityPolicyFromString(reliabilityPolicy)
+	c.check(err)
+	if fecGroupSize < 0 || fecGroupSize > math.MaxUint8 {
+		c.fail("%v: --fec-group must be in [0, %v], got %v", errInvalidConfig, math.MaxUint8, fecGroupSize)
+	}
+	if aggregationDelay < 0 {
+		c.fail("%v: invalid --aggregation-delay %v", errInvalidConfig, aggregationDelay)
+	}
+	if udpGSO && runtime.GOOS != "linux" {
+		c.fail("%v: --udp-gso is only supported on Linux", errInvalidConfig)
+	}
+	if backpressureTimeout < 0 {
+		c.fail("%v: invalid --backpressure-timeout %v", errInvalidConfig, backpressureTimeout)
+	}
+	if sendQueueBudget < 0 {
+		c.fail("%v: invalid --send-queue-budget %v", errInvalidConfig, sendQueueBudget)
+	}
+	if sendQueuePolicy != "packets" && sendQueuePolicy != "frames" {
+		c.fail("%v: --send-queue-policy must be 'packets' or 'frames', got %v", errInvalidConfig, sendQueuePolicy)
+	}
+	if pacingInterval < 0 || pacingBurst <= 0 {
+		c.fail("%v: invalid --pacing-interval %v or --pacing-burst %v", errInvalidConfig, pacingInterval, pacingBurst)
+	}
+	if playoutDelay < 0 {
+		c.fail("%v: invalid --playout-delay %v", errInvalidConfig, playoutDelay)
+	}
+	if playoutDelay > 0 {
+		if !localRFC8888 {
+			c.note("--playout-delay requires the receiver to run with --rtcp-feedback 'rfc8888' or 'rfc8888-pion'")
+		}
+		if pacingInterval == 0 {
+			c.note("--playout-delay only logs the estimate without --pacing-interval")
+		}
+	}
+	if pacingInterval > 0 && rtpCC == cc.NONE.String() && !quicCCTarget {
+		c.note("--pacing-interval paces at the fixed --start-bitrate without a congestion controller driving it")
+	}
+	switch rtpCC {
+	case cc.SCReAM.String(), cc.NADA.String():
+		if !localRFC8888 {
+			c.note("--rtp-cc %v requires the receiver to run with --rtcp-feedback 'rfc8888' or 'rfc8888-pion'", rtpCC)
+		}
+	case cc.GCC.String():
+		c.note("--rtp-cc gcc requires the receiver to run with --rtcp-feedback 'twcc'")
+	}
+	if localRFC8888 && rtpCC != cc.SCReAM.String() && rtpCC != cc.NADA.String() {
+		c.note("--local-rfc8888 feedback is only used by --rtp-cc 'scream' and 'nada'")
+	}
+	if ecn && rtpCC != cc.SCReAM.String() && rtpCC != cc.NADA.String() {
+		c.note("--ecn marks are only reacted to by --rtp-cc 'scream' and 'nada'")
+	}
+	if controlStdin && !probe && rtpCC != cc.NONE.String() {
+		c.note("--control-stdin pauses keep the congestion controller warm only with --probe")
+	}
+
+	c.checkResolvable(addr)
+	if len(backupAddr) > 0 {
+		c.checkResolvable(backupAddr)
+	}
+	if metricsInterval <= 0 {
+		c.fail("%v: --metrics-interval must be positive, got %v", errInvalidConfig, metricsInterval)
+	}
+	for _, f := range []string{ccDump, metricsLog, bweEvalLog, pathCacheFile, bufferHealthLog, syncodecStatsLog} {
+		c.checkOutputFile(f)
+	}
+
+	switch source {
+	case "syncodec":
+	case media.TestSourceName:
+		if codec != media.H264 && codec != media.VP8 {
+			c.fail("%v: --source %v supports the codecs %v and %v only, got %v", errInvalidConfig, source, media.H264, media.VP8, codec)
+		}
+	case "videotestsrc", media.ScreenSourceX11, media.ScreenSourcePipewire:
+		c.checkSource()
+	default:
+		path := media.FilePath(source)
+		if media.IsCamera(source) {
+			path = media.CameraDevice(source)
+		}
+		if _, err := os.Stat(path); err != nil {
+			c.fail("%v: --source: %v", errInvalidConfig, err)
+			return
+		}
+		c.checkSource()
+	}
+}
+
+func (c *checker) checkReceiver() {
+	conf := receiverConfig()
+	for _, err := range conf.Problems() {
+		c.check(err)
+	}
+	switch rtcpFeedback {
+	case "none", "rfc8888", "rfc8888-pion", "twcc":
+	default:
+		c.fail("%v: unknown --rtcp-feedback %v", errInvalidCCConfig, rtcpFeedback)
+	}
+	if ecn && rtcpFeedback != "rfc8888" {
+		c.note("--ecn marks are only reported with --rtcp-feedback 'rfc8888'")
+	}
+	if feedbackSuppression < 0 {
+		c.fail("%v: invalid --feedback-suppression %v", errInvalidConfig, feedbackSuppression)
+	} else if feedbackSuppression > 0 {
+		if rtcpFeedback == "none" {
+			c.note("--feedback-suppression has no effect with --rtcp-feedback none")
+		}
+		if !strings.HasPrefix(transport, "quic") {
+			c.note("--feedback-suppression can't measure the RTT of the feedback with --transport %v", transport)
+		}
+	}
+	if jitterBufferDelay < 0 || jitterBufferMaxDelay < jitterBufferDelay {
+		c.fail("%v: invalid jitter buffer delays %v and %v", errInvalidConfig, jitterBufferDelay, jitterBufferMaxDelay)
+	}
+	if jitterBufferDrift && jitterBufferDelay == 0 {
+		c.note("--jitter-buffer-drift has no effect without --jitter-buffer")
+	}
+	c.checkOutputFile(clockDriftLog)
+	c.checkBindable(addr)
+	if len(qualityReference) > 0 {
+		if _, err := os.Stat(qualityReference); err != nil {
+			c.fail("%v: --quality-reference: %v", errInvalidConfig, err)
+		}
+		c.checkOutputFile(qualityLog)
+	} else if len(qualityLog) > 0 {
+		c.note("--quality-log has no effect without --quality-reference")
+	}
+
+	if sink == "syncodec" {
+		c.checkOutputFile(syncodecSinkStats)
+		return
+	}
+	codecs := []string{codec}
+	if codec == "auto" {
+		m, err := media.ParseCodecMap(codecMap)
+		if err != nil {
+			// reported by Problems
+			return
+		}
+		codecs = []string{"h264"}
+		for _, v := range m {
+			codecs = append(codecs, v)
+		}
+	}
+	for _, codec := range codecs {
+		c.checkSink(codec)
+	}
+}
+
+// checkSource creates and closes the source pipeline, which fails if an
+// element is not available.
+func (c *checker) checkSource() {
+	// an invalid resolution is reported by senderConfig
+	width, height, _ := parseResolution(resolution)
+	w := interceptor.RTPWriterFunc(func(*pionrtp.Header, []byte, interceptor.Attributes) (int, error) {
+		return 0, nil
+	})
+	opts := []media.ConfigOption{
+		media.Codec(codec),
+		media.PayloadType(uint8(payloadType)),
+		media.InitialTargetBitrate(initialTargetBitrate),
+		media.SSRC(ssrc),
+		media.Resolution(width, height),
+		media.Framerate(framerate),
+		media.Encoder(encoder),
+		media.Adaptation(adaptation),
+	}
+	var ms interface{ Stop() error }
+	var err error
+	if mediaBackend == media.BackendFFmpeg {
+		ms, err = media.NewFFmpegSource(w, source, opts...)
+	} else {
+		gstPacketizer := packetizer == "gstreamer" || packetizer != "go" && transport != "quic-prio"
+		ms, err = media.NewGstreamerSource(w, source, gstPacketizer, opts...)
+	}
+	if err != nil {
+		c.fail("media source: %v", err)
+		return
+	}
+	c.check(ms.Stop())
+}
+
+func (c *checker) checkSink(codec string) {
+	if sink != "autovideosink" {
+		c.checkOutputFile(media.FilePath(sink))
+	}
+	ms, err := media.NewSink(sink, media.Codec(codec), media.PayloadType(uint8(payloadType)), media.Backend(mediaBackend))
+	if err != nil {
+		c.fail("media sink for codec %v: %v", codec, err)
+		return
+	}
+	c.check(ms.Stop())
+}
+
+func (c *checker) checkResolvable(address string) {
+	var err error
+	if transport == "tcp" {
+		_, err = net.ResolveTCPAddr("tcp", address)
+	} else {
+		_, err = net.ResolveUDPAddr("udp", address)
+	}
+	if err != nil {
+		c.fail("%v: can't resolve address: %v", errInvalidConfig, err)
+	}
+}
+
+// checkBindable binds and immediately releases the local address.
+func (c *checker) checkBindable(address string) {
+	if transport == "tcp" {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			c.fail("%v: can't listen on %v: %v", errInvalidConfig, address, err)
+			return
+		}
+		c.check(l.Close())
+		return
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		c.fail("%v: can't listen on %v: %v", errInvalidConfig, address, err)
+		return
+	}
+	c.check(conn.Close())
+}
+
+// checkOutputFile checks that the directory of a log or output file exists.
+func (c *checker) checkOutputFile(file string) {
+	if len(file) == 0 || file == "stdout" {
+		return
+	}
+	dir := filepath.Dir(file)
+	info, err := os.Stat(dir)
+	if err != nil {
+		c.fail("%v: output file %v: %v", errInvalidConfig, file, err)
+		return
+	}
+	if !info.IsDir() {
+		c.fail("%v: output file %v: %v is not a directory", errInvalidConfig, file, dir)
+	}
+}
+
+// printEffectiveConfig prints all flags of cmd in the configuration file
+// format, so that the output can be used as a configuration file.
+func printEffectiveConfig(cmd *cobra.Command) {
+	values := map[string]string{}
+	visit := func(f *pflag.Flag) {
+		if f.Name == "config" || f.Name == "help" {
+			return
+		}
+		values[f.Name] = f.Value.String()
+	}
+	cmd.Flags().VisitAll(visit)
+	cmd.InheritedFlags().VisitAll(visit)
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("command: %v\n", cmd.Name())
+	for _, name := range names {
+		fmt.Printf("%v: %q\n", name, values[name])
+	}
+}
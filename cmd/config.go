@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// configEntry is a flag value read from a configuration file.
+type configEntry struct {
+	key string
+	// values are the items of a list, or the single value of a scalar.
+	values []string
+	line   int
+}
+
+// config is a YAML mapping of flag names to values, e.g.,
+//
+//	command: send
+//	transport: quic
+//	rtp-cc: scream
+//	reconnect:
+//	  attempts: 5
+//	  backoff: 1s
+//	enable-experimental:
+//	  - moq
+//	streams:
+//	  - source: videotestsrc
+//	  - source: file:foreman_cif.y4m
+//
+// The 'command' key selects the command the configuration is meant for. Keys
+// of a section are prefixed with the name of the section, 'attempts' above
+// sets --reconnect-attempts. List items are set one by one, so that items
+// containing commas are not split. A list of streams sets --streams and the
+// settings of each stream.
+type config struct {
+	file    string
+	command string
+	entries []configEntry
+	seen    map[string]int
+}
+
+// loadConfig reads a configuration file.
+func loadConfig(file string) (*config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", errInvalidConfig, file, err)
+	}
+	c := &config{
+		file: file,
+		seen: map[string]int{},
+	}
+	if len(doc.Content) == 0 {
+		return c, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%w: %v:%v: expected a mapping of flag names to values", errInvalidConfig, file, root.Line)
+	}
+	if err := c.addMapping("", root); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// addMapping adds the values of the mapping m, whose keys are prefixed with
+// prefix.
+func (c *config) addMapping(prefix string, m *yaml.Node) error {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, value := m.Content[i], m.Content[i+1]
+		name := prefix + key.Value
+		switch {
+		case len(prefix) == 0 && name == "command":
+			if value.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%w: %v:%v: command must be a string", errInvalidConfig, c.file, value.Line)
+			}
+			c.command = value.Value
+		case len(prefix) == 0 && name == "streams" && value.Kind == yaml.SequenceNode:
+			if err := c.addStreams(value); err != nil {
+				return err
+			}
+		case value.Kind == yaml.MappingNode:
+			if len(prefix) > 0 {
+				return fmt.Errorf("%w: %v:%v: sections nested more than one level are not supported", errInvalidConfig, c.file, value.Line)
+			}
+			if err := c.addMapping(name+"-", value); err != nil {
+				return err
+			}
+		case value.Kind == yaml.SequenceNode:
+			items, err := c.scalars(value)
+			if err != nil {
+				return err
+			}
+			if err := c.add(name, items, key.Line); err != nil {
+				return err
+			}
+		case value.Kind == yaml.ScalarNode:
+			if err := c.add(name, []string{value.Value}, key.Line); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: %v:%v: unsupported value for %v", errInvalidConfig, c.file, value.Line, name)
+		}
+	}
+	return nil
+}
+
+// streamSettings maps the keys of the entries of a list of streams to the
+// flags holding the setting of each stream.
+var streamSettings = map[string]string{
+	"source": "stream-sources",
+}
+
+// addStreams adds the number of streams and the settings of each stream of
+// the list l. Streams without a setting use the flag of all streams, e.g.,
+// --source.
+func (c *config) addStreams(l *yaml.Node) error {
+	settings := map[string][]string{}
+	for i, s := range l.Content {
+		if s.Kind != yaml.MappingNode {
+			return fmt.Errorf("%w: %v:%v: expected the settings of stream %v", errInvalidConfig, c.file, s.Line, i)
+		}
+		for j := 0; j+1 < len(s.Content); j += 2 {
+			key, value := s.Content[j], s.Content[j+1]
+			flag, ok := streamSettings[key.Value]
+			if !ok || value.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%w: %v:%v: unknown setting %v of stream %v", errInvalidConfig, c.file, key.Line, key.Value, i)
+			}
+			for len(settings[flag]) < i {
+				settings[flag] = append(settings[flag], "")
+			}
+			settings[flag] = append(settings[flag], value.Value)
+		}
+	}
+	if err := c.add("streams", []string{fmt.Sprint(len(l.Content))}, l.Line); err != nil {
+		return err
+	}
+	for flag, values := range settings {
+		if err := c.add(flag, values, l.Line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scalars returns the items of the list l.
+func (c *config) scalars(l *yaml.Node) ([]string, error) {
+	items := make([]string, 0, len(l.Content))
+	for _, item := range l.Content {
+		if item.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%w: %v:%v: nested lists are not supported", errInvalidConfig, c.file, item.Line)
+		}
+		items = append(items, item.Value)
+	}
+	return items, nil
+}
+
+func (c *config) add(key string, values []string, line int) error {
+	if prev, ok := c.seen[key]; ok {
+		return fmt.Errorf("%w: %v:%v: duplicate key %v, first set in line %v", errInvalidConfig, c.file, line, key, prev)
+	}
+	c.seen[key] = line
+	c.entries = append(c.entries, configEntry{
+		key:    key,
+		values: values,
+		line:   line,
+	})
+	return nil
+}
+
+// apply sets the flags of cmd to the values of the configuration. Flags set on
+// the command line take precedence over the configuration file.
+func (c *config) apply(cmd *cobra.Command) error {
+	if len(c.command) > 0 && c.command != cmd.Name() {
+		return fmt.Errorf("%w: %v is a configuration for command %v, not %v", errInvalidConfig, c.file, c.command, cmd.Name())
+	}
+	for _, e := range c.entries {
+		flags := cmd.Flags()
+		f := flags.Lookup(e.key)
+		if f == nil {
+			flags = cmd.InheritedFlags()
+			f = flags.Lookup(e.key)
+		}
+		if f == nil || f.Name == "config" {
+			return fmt.Errorf("%w: %v:%v: unknown flag %v for command %v", errInvalidConfig, c.file, e.line, e.key, cmd.Name())
+		}
+		if f.Changed {
+			continue
+		}
+		values := []string{strings.Join(e.values, ",")}
+		if _, ok := f.Value.(pflag.SliceValue); ok && len(e.values) > 0 {
+			values = make([]string, 0, len(e.values))
+			for _, v := range e.values {
+				values = append(values, quoteCSV(v))
+			}
+		}
+		for _, v := range values {
+			if err := flags.Set(e.key, v); err != nil {
+				return fmt.Errorf("%w: %v:%v: invalid value for %v: %v", errInvalidConfig, c.file, e.line, e.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// quoteCSV quotes v if needed, so that a slice flag, which reads its values
+// as CSV, sets a single item v. Empty items are quoted since slice flags
+// ignore empty values.
+func quoteCSV(v string) string {
+	if len(v) > 0 && !strings.ContainsAny(v, ",\"\r\n") && strings.TrimSpace(v) == v {
+		return v
+	}
+	return `"` + strings.ReplaceAll(v, `"`, `""`) + `"`
+}
+
+// applyConfigFile applies the file given by --config to cmd, if any.
+func applyConfigFile(cmd *cobra.Command) error {
+	if len(configFile) == 0 {
+		return nil
+	}
+	c, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	return c.apply(cmd)
+}
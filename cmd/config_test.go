@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// testCommand returns a command named send with flags of the kinds set by
+// configuration files and environment variables.
+func testCommand() *cobra.Command {
+	root := &cobra.Command{Use: "roq"}
+	root.PersistentFlags().StringSlice("enable-experimental", nil, "")
+	root.PersistentFlags().String("config", "", "")
+	cmd := &cobra.Command{Use: "send", Run: func(*cobra.Command, []string) {}}
+	cmd.Flags().String("transport", "quic", "")
+	cmd.Flags().Int("streams", 1, "")
+	cmd.Flags().Int("reconnect-attempts", 0, "")
+	cmd.Flags().Duration("reconnect-backoff", time.Second, "")
+	cmd.Flags().String("source", "videotestsrc", "")
+	cmd.Flags().StringSlice("stream-sources", nil, "")
+	root.AddCommand(cmd)
+	return cmd
+}
+
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestConfigApply(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		args   []string
+		want   map[string]string
+		err    error
+	}{
+		{
+			name:   "scalars and sections",
+			config: "command: send\ntransport: tcp\nreconnect:\n  attempts: 5\n  backoff: 2s\n",
+			want: map[string]string{
+				"transport":          "tcp",
+				"reconnect-attempts": "5",
+				"reconnect-backoff":  "2s",
+			},
+		},
+		{
+			name:   "command line takes precedence",
+			config: "transport: tcp\n",
+			args:   []string{"--transport", "quic-stream"},
+			want:   map[string]string{"transport": "quic-stream"},
+		},
+		{
+			name:   "inherited list",
+			config: "enable-experimental:\n  - moq\n",
+			want:   map[string]string{"enable-experimental": "[moq]"},
+		},
+		{
+			name:   "streams with commas and empty settings",
+			config: "streams:\n  - source: 'videotestsrc ! video/x-raw,width=640'\n  - {}\n  - source: file:a.y4m\n",
+			want: map[string]string{
+				"streams":        "3",
+				"stream-sources": `["videotestsrc ! video/x-raw,width=640",,file:a.y4m]`,
+			},
+		},
+		{
+			name:   "wrong command",
+			config: "command: receive\n",
+			err:    errInvalidConfig,
+		},
+		{
+			name:   "unknown flag",
+			config: "unknown: 1\n",
+			err:    errInvalidConfig,
+		},
+		{
+			name:   "config flag",
+			config: "config: other.yaml\n",
+			err:    errInvalidConfig,
+		},
+		{
+			name:   "invalid value",
+			config: "streams: two\n",
+			err:    errInvalidConfig,
+		},
+		{
+			name:   "duplicate key",
+			config: "reconnect-attempts: 1\nreconnect:\n  attempts: 2\n",
+			err:    errInvalidConfig,
+		},
+		{
+			name:   "nested sections",
+			config: "reconnect:\n  backoff:\n    min: 1s\n",
+			err:    errInvalidConfig,
+		},
+		{
+			name:   "not a mapping",
+			config: "- transport\n",
+			err:    errInvalidConfig,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := testCommand()
+			if err := cmd.ParseFlags(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			c, err := loadConfig(writeConfig(t, tc.config))
+			if err == nil {
+				err = c.apply(cmd)
+			}
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			for name, want := range tc.want {
+				f := cmd.Flags().Lookup(name)
+				if got := f.Value.String(); got != want {
+					t.Errorf("got %v=%v, want %v", name, got, want)
+				}
+				if !f.Changed {
+					t.Errorf("%v not marked as changed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestQuoteCSV(t *testing.T) {
+	cmd := testCommand()
+	items := []string{"a", "", "b,c", `say "hi"`, " padded "}
+	for _, item := range items {
+		if err := cmd.Flags().Set("stream-sources", quoteCSV(item)); err != nil {
+			t.Fatalf("failed to set %q: %v", item, err)
+		}
+	}
+	got, err := cmd.Flags().GetStringSlice("stream-sources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, items) {
+		t.Fatalf("got %q, want %q", got, items)
+	}
+}
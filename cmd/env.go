@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix is the prefix of the environment variables mirroring the flags.
+const envPrefix = "ROQ_"
+
+// envName returns the environment variable of the flag name, e.g.,
+// ROQ_RTP_CC for --rtp-cc.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// applyEnv sets the flags of cmd which are not set on the command line to the
+// values of their environment variables. Environment variables take
+// precedence over the configuration file.
+func applyEnv(cmd *cobra.Command) error {
+	known := map[string]bool{}
+	var err error
+	visit := func(f *pflag.Flag) {
+		name := envName(f.Name)
+		if known[name] {
+			return
+		}
+		known[name] = true
+		value, ok := os.LookupEnv(name)
+		if !ok || f.Changed || err != nil {
+			return
+		}
+		if e := f.Value.Set(value); e != nil {
+			err = fmt.Errorf("%w: invalid value for %v: %v", errInvalidConfig, name, e)
+			return
+		}
+		f.Changed = true
+	}
+	cmd.Flags().VisitAll(visit)
+	cmd.InheritedFlags().VisitAll(visit)
+	if err != nil {
+		return err
+	}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(name, envPrefix) && !known[name] {
+			log.Printf("ignoring environment variable %v which is not a flag of %v", name, cmd.Name())
+		}
+	}
+	return nil
+}
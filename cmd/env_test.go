@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	for flag, want := range map[string]string{
+		"rtp-cc":              "ROQ_RTP_CC",
+		"transport":           "ROQ_TRANSPORT",
+		"enable-experimental": "ROQ_ENABLE_EXPERIMENTAL",
+	} {
+		if got := envName(flag); got != want {
+			t.Errorf("envName(%v) = %v, want %v", flag, got, want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		args []string
+		want map[string]string
+		err  error
+	}{
+		{
+			name: "flags",
+			env: map[string]string{
+				"ROQ_TRANSPORT":          "tcp",
+				"ROQ_RECONNECT_ATTEMPTS": "3",
+			},
+			want: map[string]string{
+				"transport":          "tcp",
+				"reconnect-attempts": "3",
+			},
+		},
+		{
+			name: "inherited flag",
+			env:  map[string]string{"ROQ_ENABLE_EXPERIMENTAL": "moq"},
+			want: map[string]string{"enable-experimental": "[moq]"},
+		},
+		{
+			name: "command line takes precedence",
+			env:  map[string]string{"ROQ_TRANSPORT": "tcp"},
+			args: []string{"--transport", "quic-stream"},
+			want: map[string]string{"transport": "quic-stream"},
+		},
+		{
+			name: "unknown variable",
+			env:  map[string]string{"ROQ_UNKNOWN": "1"},
+		},
+		{
+			name: "invalid value",
+			env:  map[string]string{"ROQ_STREAMS": "two"},
+			err:  errInvalidConfig,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			cmd := testCommand()
+			if err := cmd.ParseFlags(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyEnv(cmd); !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			for name, want := range tc.want {
+				f := cmd.Flags().Lookup(name)
+				if got := f.Value.String(); got != want {
+					t.Errorf("got %v=%v, want %v", name, got, want)
+				}
+				if !f.Changed {
+					t.Errorf("%v not marked as changed", name)
+				}
+			}
+		})
+	}
+}
+
+// TestApplyEnvBeforeConfig checks that environment variables take precedence
+// over the configuration file, as applied by the root command.
+func TestApplyEnvBeforeConfig(t *testing.T) {
+	t.Setenv("ROQ_TRANSPORT", "tcp")
+	cmd := testCommand()
+	if err := applyEnv(cmd); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(writeConfig(t, "transport: quic-stream\nstreams: 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.apply(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if got := cmd.Flags().Lookup("transport").Value.String(); got != "tcp" {
+		t.Fatalf("got transport %v, want tcp", got)
+	}
+	if got := cmd.Flags().Lookup("streams").Value.String(); got != "2" {
+		t.Fatalf("got streams %v, want 2", got)
+	}
+}
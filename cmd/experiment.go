@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Willi-42/rtp-over-quic/experiment"
+	"github.com/spf13/cobra"
+)
+
+var (
+	experimentMatrix string
+	experimentDryRun bool
+)
+
+func init() {
+	rootCmd.AddCommand(experimentCmd)
+
+	experimentCmd.Flags().StringVar(&experimentMatrix, "matrix", "", "JSON file defining the runs: 'transports', 'cc', 'feedback' and 'durations' (e.g., '30s') are combined, each combination is run 'repetitions' times with 'sender_args' and 'receiver_args' added to the flags of the run")
+	experimentCmd.Flags().BoolVar(&experimentDryRun, "dry-run", false, "Print the runs without running them")
+}
+
+var experimentCmd = &cobra.Command{
+	Use: "experiment",
+	Run: func(cmd *cobra.Command, _ []string) {
+		if err := runExperiment(cmd); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func runExperiment(cmd *cobra.Command) error {
+	if len(experimentMatrix) == 0 {
+		return fmt.Errorf("%w: experiment requires --matrix", errInvalidConfig)
+	}
+	m, err := experiment.ReadMatrix(experimentMatrix)
+	if err != nil {
+		return err
+	}
+	if experimentDryRun {
+		for _, r := range m.Runs() {
+			fmt.Println(r.Name)
+		}
+		return nil
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r := &experiment.Runner{
+		Binary: binary,
+	}
+	results, err := r.Execute(cmd.Context(), m)
+	log.Printf("experiment %v: finished %v runs, results in %v", m.Name, len(results), m.Output)
+	return err
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/Willi-42/rtp-over-quic/roq"
+	"github.com/spf13/cobra"
+)
+
+var gatewayWebRTCAddr string
+
+func init() {
+	rootCmd.AddCommand(gatewayCmd)
+
+	gatewayCmd.Flags().StringVar(&gatewayWebRTCAddr, "webrtc-addr", ":8081", "Address to serve WHEP viewers at '/whep' and WHIP publishers at '/whip' on")
+	gatewayCmd.Flags().StringSliceVar(&iceServers, "ice-server", nil, "STUN or TURN URL passed to WebRTC clients, e.g., 'stun:stun.l.google.com:19302'")
+	gatewayCmd.Flags().StringVar(&rtcpFeedback, "rtcp-feedback", "none", "RTCP Congestion Control Feedback to send to RoQ senders ('none', 'rfc8888', 'rfc8888-pion', 'twcc')")
+	gatewayCmd.Flags().DurationVar(&jitterBufferDelay, "jitter-buffer", 0, "Maximum time to hold back packets for reordering before forwarding them to WebRTC viewers, 0 disables the jitter buffer")
+	gatewayCmd.Flags().IntVar(&maxConnections, "max-connections", 0, "Maximum number of senders connected at the same time, further connections are refused (QUIC only), 0 means unlimited")
+}
+
+// gatewayCmd receives RoQ like the receive command, but forwards the media
+// to WebRTC viewers instead of playing it.
+var gatewayCmd = &cobra.Command{
+	Use: "gateway",
+	Run: func(cmd *cobra.Command, _ []string) {
+		c := receiverConfig()
+		c.Sink = "none"
+		c.WebRTCAddr = gatewayWebRTCAddr
+		r, err := roq.NewReceiver(roq.SetReceiverConfig(c))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := r.Start(cmd.Context()); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/spf13/cobra"
+)
+
+var (
+	clientQLOG string
+	serverQLOG string
+	rtpLogFile string
+	ccLogFile  string
+	outputFile string
+	trimQLOG   bool
+)
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.Flags().StringVar(&clientQLOG, "qlog-client", "", "QLOG file of the sender")
+	inspectCmd.Flags().StringVar(&serverQLOG, "qlog-server", "", "QLOG file of the receiver")
+	inspectCmd.Flags().StringVar(&rtpLogFile, "rtp-log", "", "RTP dump file of the sender to add as custom events")
+	inspectCmd.Flags().StringVar(&ccLogFile, "cc-log", "", "Congestion Control log file of the sender to add as custom events")
+	inspectCmd.Flags().StringVar(&outputFile, "output", "merged.qlog", "Output file for the merged QLOG")
+	inspectCmd.Flags().BoolVar(&trimQLOG, "trim", true, "Trim the QLOG to the media phase")
+}
+
+var inspectCmd = &cobra.Command{
+	Use: "inspect",
+	Run: func(cmd *cobra.Command, _ []string) {
+		if err := logging.MergeQLOGs(clientQLOG, serverQLOG, rtpLogFile, ccLogFile, outputFile, trimQLOG); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
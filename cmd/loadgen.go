@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/roq"
+	"github.com/spf13/cobra"
+)
+
+var (
+	loadConnections int
+	loadRamp        time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(loadgenCmd)
+
+	loadgenCmd.Flags().IntVar(&loadConnections, "connections", 10, "Number of QUIC connections to open, each sending synthetic media with its own congestion controller")
+	loadgenCmd.Flags().DurationVar(&loadRamp, "ramp", 0, "Delay between opening two connections, 0 opens all connections at once")
+	loadgenCmd.Flags().Uint32Var(&ssrc, "ssrc", 0, "SSRC of the first media stream, the streams of further connections use the following SSRCs")
+	loadgenCmd.Flags().IntVar(&streams, "streams", 1, "Number of media streams sent on each connection")
+	loadgenCmd.Flags().StringVar(&rtpCC, "rtp-cc", "none", "RTP congestion control algorithm of each connection. ('none', 'scream', 'gcc', 'nada' or an algorithm added using cc.Register)")
+	loadgenCmd.Flags().UintVar(&initialTargetBitrate, "start-bitrate", 100_000, "Initial target bitrate in bit/s of each connection")
+	loadgenCmd.Flags().UintVar(&ccMinBitrate, "min-bitrate", 100_000, "Lowest target bitrate in bit/s of each connection")
+	loadgenCmd.Flags().UintVar(&ccMaxBitrate, "max-bitrate", 100_000_000, "Highest target bitrate in bit/s of each connection")
+	loadgenCmd.Flags().DurationVar(&pacingInterval, "pacing-interval", 0, "Interval in which the pacer of each connection releases packets at the congestion control target bitrate, 0 disables the pacer")
+}
+
+// loadgenCmd stress-tests a receiver on --addr with many senders of
+// synthetic media.
+var loadgenCmd = &cobra.Command{
+	Use: "loadgen",
+	Run: func(cmd *cobra.Command, _ []string) {
+		sc, err := senderConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		l, err := roq.NewLoadGenerator(roq.LoadGenConfig{
+			SenderConfig: sc,
+			Connections:  loadConnections,
+			Ramp:         loadRamp,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := l.Start(cmd.Context()); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
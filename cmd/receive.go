@@ -1,212 +1,100 @@
 package cmd
 
 import (
-	"context"
-	"fmt"
 	"log"
+	"time"
 
-	"github.com/Willi-42/rtp-over-quic/media"
-	"github.com/Willi-42/rtp-over-quic/quic"
-	"github.com/Willi-42/rtp-over-quic/rtp"
-	"github.com/Willi-42/rtp-over-quic/tcp"
-	"github.com/Willi-42/rtp-over-quic/udp"
-	"github.com/pion/interceptor"
-	"github.com/pion/rtcp"
+	"github.com/Willi-42/rtp-over-quic/roq"
 	"github.com/spf13/cobra"
 )
 
-const transportCCURI = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+var (
+	sink         string
+	audioSink    string
+	rtcpFeedback string
 
-type RTCPFeedback int
+	syncodecSinkStats string
 
-type handler interface {
-	WriteRTCP(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error)
-	SetRTPReader(r interceptor.RTPReader)
-}
+	qualityReference string
+	qualityLog       string
 
-const (
-	RTCP_NONE RTCPFeedback = iota
-	RTCP_RFC8888
-	RTCP_RFC8888_PION
-	RTCP_TWCC
-)
+	jitterBufferDelay    time.Duration
+	jitterBufferMaxDelay time.Duration
+	jitterBufferAdaptive bool
+	jitterBufferDrift    bool
+	clockDriftLog        string
 
-var (
-	sink         string
-	rtcpFeedback string
+	codecMap    string
+	detectCodec bool
+
+	feedbackSuppression time.Duration
+
+	maxConnections int
+
+	signalingAddr string
+
+	webrtcAddr string
+	iceServers []string
 )
 
 func init() {
 	rootCmd.AddCommand(receiveCmd)
 
-	receiveCmd.Flags().StringVar(&sink, "sink", "autovideosink", "Media sink")
+	receiveCmd.Flags().StringVar(&sink, "sink", "autovideosink", "Media sink: 'autovideosink', 'none', 'syncodec' (discards the media of --source 'syncodec' after logging the frames to --syncodec-stats) or a file. 'file:<path>.ivf' (VP8, VP9, AV1) and 'file:<path>.mp4' (H.264, H.265, VP9, AV1) store the received stream without decoding, 'file:<path>.y4m' and plain paths the decoded video, e.g., for scoring with --quality-reference")
+	receiveCmd.Flags().StringVar(&syncodecSinkStats, "syncodec-stats", "", "Log file for the frames received with --sink 'syncodec': time in ms, SSRC, RTP timestamp, bytes, received and lost packets. Use 'stdout' for Stdout")
+	receiveCmd.Flags().StringVar(&qualityReference, "quality-reference", "", "Y4M file the decoded video is compared with, e.g., the file sent by the sender, to log PSNR and SSIM once per second to --quality-log. Sender and receiver have to start at the same frame. Disabled if empty")
+	receiveCmd.Flags().StringVar(&qualityLog, "quality-log", "", "Log file for the quality scores of --quality-reference: time in ms, scored frames, PSNR in dB and SSIM of the last second. Use 'stdout' for Stdout")
+	receiveCmd.Flags().StringVar(&audioSink, "audio-sink", "", "Sink of the Opus stream sent alongside the video using --audio-source: 'autoaudiosink' or a file, e.g., 'file:<path>.ogg'. The audio is discarded if empty")
 	receiveCmd.Flags().StringVar(&rtcpFeedback, "rtcp-feedback", "none", "RTCP Congestion Control Feedback to send ('none', 'rfc8888', 'rfc8888-pion', 'twcc')")
+	receiveCmd.Flags().StringVar(&codecMap, "codec-map", "", "Payload type to codec mapping used with --codec 'auto', e.g., '96=h264,97=vp8'")
+	receiveCmd.Flags().BoolVar(&detectCodec, "detect-codec", false, "Detect the codec from the RTP payload if --codec is 'auto' and the payload type is not in --codec-map (h264 and vp8 only)")
+	receiveCmd.Flags().DurationVar(&jitterBufferDelay, "jitter-buffer", 0, "Maximum time to hold back packets for reordering before passing them to the media sink, 0 disables the jitter buffer")
+	receiveCmd.Flags().DurationVar(&jitterBufferMaxDelay, "jitter-buffer-max", 500*time.Millisecond, "Upper bound of the jitter buffer delay in adaptive mode")
+	receiveCmd.Flags().DurationVar(&feedbackSuppression, "feedback-suppression", 0, "Coalesce RTCP feedback while the feedback path is congested, flushing it in intervals starting at this duration, 0 disables suppression")
+	receiveCmd.Flags().BoolVar(&jitterBufferAdaptive, "jitter-buffer-adaptive", false, "Adapt the jitter buffer delay to the measured interarrival jitter")
+	receiveCmd.Flags().BoolVar(&jitterBufferDrift, "jitter-buffer-drift", false, "Schedule the playout by RTP timestamps, following the clock rate of the sender estimated from timestamps and arrival times")
+	receiveCmd.Flags().BoolVar(&controlStdin, "control-stdin", false, "Read control commands from Stdin, one per line: 'subscribe <ssrc> <spatial> <temporal> [max-height] [max-fps]' selects the layers the sender sends of a flow")
+	receiveCmd.Flags().IntVar(&maxConnections, "max-connections", 0, "Maximum number of senders connected at the same time, further connections are refused (QUIC only), 0 means unlimited")
+	receiveCmd.Flags().StringVar(&signalingAddr, "signaling-addr", "", "Address to answer SDP offers of senders on at '/offer', e.g., ':8080'. With --codec 'auto', the offered codecs are used. Disabled if empty")
+	receiveCmd.Flags().StringVar(&webrtcAddr, "webrtc-addr", "", "Address to serve WHIP publishers at '/whip' and WHEP viewers at '/whep' on, e.g., ':8081'. Publishers are handled like senders, viewers get the received media. Disabled if empty")
+	receiveCmd.Flags().StringSliceVar(&iceServers, "ice-server", nil, "STUN or TURN URL passed to WebRTC clients, e.g., 'stun:stun.l.google.com:19302'")
+	receiveCmd.Flags().StringVar(&clockDriftLog, "clock-drift-log", "", "Log file for the estimated sender clock drift (ppm) and clock rate, use 'stdout' for Stdout")
 }
 
 var receiveCmd = &cobra.Command{
 	Use: "receive",
 	Run: func(cmd *cobra.Command, _ []string) {
-		if err := start(cmd.Context()); err != nil {
+		r, err := roq.NewReceiver(roq.SetReceiverConfig(receiverConfig()))
+		if err != nil {
 			log.Fatal(err)
 		}
-	},
-}
-
-func start(ctx context.Context) error {
-	rc := newReceiverController()
-
-	switch transport {
-	case "quic", "quic-dgram", "quic-stream", "quic-prio":
-		return startQUIC(ctx, rc)
-	case "udp":
-		return startUDP(ctx, rc)
-	case "tcp":
-		return startTCP(ctx, rc)
-	}
-	return fmt.Errorf("%w: %v", errInvalidTransport, transport)
-}
-
-func startTCP(ctx context.Context, rc *receiverController) error {
-	server, err := tcp.NewServer(
-		tcp.LocalAddress(addr),
-	)
-	if err != nil {
-		return err
-	}
-	server.OnNewHandler(func(h *tcp.Handler) {
-		rc.handle(h)
-	})
-	return server.Start(ctx)
-}
-
-func startQUIC(ctx context.Context, rc *receiverController) error {
-	server, err := quic.NewServer(
-		quic.LocalAddress(addr),
-		quic.SetServerQLOGDirName(qlogDir),
-		quic.SetServerSSLKeyLogFileName(keyLogFile),
-	)
-	if err != nil {
-		return err
-	}
-	server.OnNewHandler(func(h *quic.Handler) {
-		rc.handle(h)
-	})
-	return server.Start(ctx)
-}
-
-func startUDP(ctx context.Context, rc *receiverController) error {
-	server, err := udp.NewServer()
-	if err != nil {
-		return err
-	}
-	server.OnNewHandler(func(h *udp.Handler) {
-		rc.handle(h)
-	})
-	return server.Start(ctx)
-}
-
-type receiverController struct {
-	mediaOptions []media.ConfigOption
-	rtpOptions   []rtp.Option
-}
-
-func newReceiverController() *receiverController {
-	mediaOptions := []media.ConfigOption{
-		media.Codec(codec),
-	}
-	rtpOptions := []rtp.Option{
-		rtp.RegisterReceiverPacketLog(rtpDumpFile, rtcpDumpFile),
-	}
-	switch getRTCP(rtcpFeedback) {
-	case RTCP_RFC8888:
-		rtpOptions = append(rtpOptions, rtp.RegisterRFC8888())
-	case RTCP_RFC8888_PION:
-		rtpOptions = append(rtpOptions, rtp.RegisterRFC8888Pion())
-	case RTCP_TWCC:
-		rtpOptions = append(rtpOptions, rtp.RegisterTWCC())
-	}
-	return &receiverController{
-		mediaOptions: mediaOptions,
-		rtpOptions:   rtpOptions,
-	}
-}
-
-func (c *receiverController) handle(h handler) {
-	reader := c.addStream(interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
-		return h.WriteRTCP(pkts, attributes)
-	}))
-
-	h.SetRTPReader(interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
-		// TODO: Demultiplex flow ID or otherwise use attributes?
-		return reader.Read(b, a)
-	}))
-}
-
-func (c *receiverController) addStream(rtcpWriter interceptor.RTCPWriter) interceptor.RTPReader {
-	// setup media pipeline
-	ms, err := media.NewGstreamerSink(sink, c.mediaOptions...)
-	if err != nil {
-		panic("TODO") // TODO
-	}
-	// build interceptor
-	r, err := rtp.New(c.rtpOptions...)
-	if err != nil {
-		panic("TODO") // TODO
-	}
-	i, err := r.Build("")
-	if err != nil {
-		panic("TODO") // TODO
-	}
-
-	go func() {
-		if err := ms.Play(); err != nil {
-			log.Printf("media sink failed to play: %v", err)
-		}
-	}()
-
-	i.BindRTCPWriter(rtcpWriter)
-
-	return i.BindRemoteStream(&interceptor.StreamInfo{
-		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: transportCCURI, ID: 1}},
-		RTCPFeedback:        []interceptor.RTCPFeedback{{Type: "ack", Parameter: "ccfb"}},
-	}, interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
-		n, err := ms.Write(b)
-		if err != nil {
-			return 0, nil, err
+		if err := r.Start(cmd.Context()); err != nil {
+			log.Fatal(err)
 		}
-
-		return n, a, nil
-	}))
-}
-
-func (f RTCPFeedback) String() string {
-	switch f {
-	case RTCP_NONE:
-		return "none"
-	case RTCP_RFC8888:
-		return "rfc8888"
-	case RTCP_RFC8888_PION:
-		return "rfc8888-pion"
-	case RTCP_TWCC:
-		return "twcc"
-	default:
-		log.Printf("WARNING: unknown RTCP Congestion Control Feedback type: %v, using default ('none')\n", int(f))
-		return "none"
-	}
+	},
 }
 
-func getRTCP(choice string) RTCPFeedback {
-	switch choice {
-	case "none":
-		return RTCP_NONE
-	case "rfc8888":
-		return RTCP_RFC8888
-	case "rfc8888-pion":
-		return RTCP_RFC8888_PION
-	case "twcc":
-		return RTCP_TWCC
-	default:
-		log.Printf("WARNING: unknown RTCP Congestion Control Feedback type: %v, using default ('none')\n", choice)
-		return RTCP_NONE
+// receiverConfig returns the receiver configuration given by the flags.
+func receiverConfig() roq.ReceiverConfig {
+	return roq.ReceiverConfig{
+		Config:               commonConfig(),
+		Sink:                 sink,
+		SyncodecStatsLog:     syncodecSinkStats,
+		QualityReference:     qualityReference,
+		QualityLog:           qualityLog,
+		AudioSink:            audioSink,
+		RTCPFeedback:         roq.ParseRTCPFeedback(rtcpFeedback),
+		FeedbackSuppression:  feedbackSuppression,
+		CodecMap:             codecMap,
+		DetectCodec:          detectCodec,
+		JitterBufferDelay:    jitterBufferDelay,
+		JitterBufferMaxDelay: jitterBufferMaxDelay,
+		JitterBufferAdaptive: jitterBufferAdaptive,
+		JitterBufferDrift:    jitterBufferDrift,
+		ClockDriftLog:        clockDriftLog,
+		MaxConnections:       maxConnections,
+		SignalingAddr:        signalingAddr,
+		WebRTCAddr:           webrtcAddr,
+		ICEServers:           iceServers,
 	}
 }
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/Willi-42/rtp-over-quic/roq"
+	"github.com/spf13/cobra"
+)
+
+var (
+	downstreams   []string
+	layerDropping bool
+)
+
+func init() {
+	rootCmd.AddCommand(relayCmd)
+
+	relayCmd.Flags().StringSliceVar(&downstreams, "downstream", nil, "Receivers to forward to, '<addr>[@<spatial>:<temporal>]', e.g., '10.0.0.2:4242@0:1' forwards the base spatial layer and the lowest two temporal layers of VP8 and VP9 streams only")
+	relayCmd.Flags().StringVar(&rtcpFeedback, "rtcp-feedback", "none", "RTCP Congestion Control Feedback to send to the sender ('none', 'rfc8888', 'rfc8888-pion', 'twcc')")
+	relayCmd.Flags().StringVar(&rtpCC, "rtp-cc", "none", "RTP congestion control algorithm estimating the bitrate to each receiver, the sender is capped at the aggregate by RTCP REMB. ('none', 'scream', 'gcc', 'nada' or an algorithm added using cc.Register)")
+	relayCmd.Flags().UintVar(&initialTargetBitrate, "start-bitrate", 100_000, "Initial target bitrate in bit/s of the congestion controllers")
+	relayCmd.Flags().UintVar(&ccMinBitrate, "min-bitrate", 100_000, "Lowest target bitrate in bit/s of the congestion controllers")
+	relayCmd.Flags().UintVar(&ccMaxBitrate, "max-bitrate", 100_000_000, "Highest target bitrate in bit/s of the congestion controllers")
+	relayCmd.Flags().BoolVar(&layerDropping, "layer-dropping", false, "Cap the sender at the estimate of the fastest receiver instead of the slowest one, use with layers per --downstream so that slower receivers get fewer layers")
+}
+
+// relayCmd accepts a sender on --addr and forwards its packets to the
+// --downstream receivers.
+var relayCmd = &cobra.Command{
+	Use: "relay",
+	Run: func(cmd *cobra.Command, _ []string) {
+		c, err := relayConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		r, err := roq.NewRelay(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := r.Start(cmd.Context()); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// relayConfig returns the relay configuration given by the flags.
+func relayConfig() (roq.RelayConfig, error) {
+	ds := make([]roq.Downstream, 0, len(downstreams))
+	for _, d := range downstreams {
+		parsed, err := roq.ParseDownstream(d)
+		if err != nil {
+			return roq.RelayConfig{}, err
+		}
+		ds = append(ds, parsed)
+	}
+	return roq.RelayConfig{
+		Config:        commonConfig(),
+		Downstreams:   ds,
+		RTCPFeedback:  roq.ParseRTCPFeedback(rtcpFeedback),
+		RTPCC:         rtpCC,
+		StartBitrate:  initialTargetBitrate,
+		MinBitrate:    ccMinBitrate,
+		MaxBitrate:    ccMaxBitrate,
+		LayerDropping: layerDropping,
+	}, nil
+}
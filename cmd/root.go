@@ -1,27 +1,75 @@
 package cmd
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime/pprof"
+	"syscall"
+	"time"
 
+	"github.com/Willi-42/rtp-over-quic/experimental"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/media"
+	"github.com/Willi-42/rtp-over-quic/roq"
+	"github.com/Willi-42/rtp-over-quic/tracing"
 	"github.com/spf13/cobra"
 )
 
 var (
 	transport string
 	addr      string
+	ecn       bool
+	udpBatch  int
+	udpMTU    int
 
 	tcpCongAlg string
 	quicCC     string
 
-	codec string
+	codec          string
+	payloadType    uint
+	redPayloadType uint
+	mediaBackend   string
+	audioPT        uint
+
+	pipelineRestarts int
 
 	rtpDumpFile  string
 	rtcpDumpFile string
+	dumpFormat   string
 	qlogDir      string
 	keyLogFile   string
+	srtpKey      string
+	logDrops     time.Duration
+	latencyFile  string
+	latencyLog   time.Duration
+	metricsAddr  string
+	controlAddr  string
+	configFile   string
+	duration     time.Duration
+
+	showDashboard bool
+
+	resultsDir string
+	uploadURL  string
+
+	otlpEndpoint string
+
+	pcapFile string
+
+	statsFile     string
+	statsFormat   string
+	statsInterval time.Duration
+
+	experimentalFeatures []string
+
+	influxURL      string
+	influxInterval time.Duration
+	experimentName string
 
 	cpuProfile       string
 	goroutineProfile string
@@ -31,21 +79,57 @@ var (
 	mutexProfile     string
 )
 
-var errInvalidTransport = errors.New("unknown transport protocol")
+var (
+	errInvalidCCConfig = errors.New("invalid congestion control configuration")
+	errInvalidConfig   = errors.New("invalid configuration")
+	errBenchRegression = errors.New("performance regression")
+)
+
+// uploadTimeout bounds the upload of the results at the end of a session.
+const uploadTimeout = 5 * time.Minute
 
 func init() {
-	rootCmd.PersistentFlags().StringVar(&transport, "transport", "quic", "Transport protocol to use: quic, udp or tcp")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "YAML configuration file mapping flag names to values, flags with a common prefix can be grouped in sections, e.g., 'reconnect:' with 'attempts: 5', and slice flags given as lists. Flags given on the command line take precedence")
+	rootCmd.PersistentFlags().StringVar(&transport, "transport", "quic", "Transport protocol to use: quic, quic-dgram, quic-stream, quic-prio, moq, udp, tcp or auto. The sender tries quic-dgram, quic-stream and tcp in order with auto, the receiver listens on QUIC and TCP. moq (Media over QUIC objects, a stream per group of pictures) requires --enable-experimental moq")
 	rootCmd.PersistentFlags().StringVarP(&addr, "addr", "a", ":4242", "QUIC server address")
+	rootCmd.PersistentFlags().DurationVar(&duration, "duration", 0, "Stop the session gracefully after this time, sending RTCP BYE and writing the final stats and logs. 0 runs until interrupted")
+	rootCmd.PersistentFlags().BoolVar(&ecn, "ecn", false, "Mark sent packets as ECN capable and report CE marks in RFC 8888 feedback (UDP only)")
+	rootCmd.PersistentFlags().IntVar(&udpBatch, "udp-batch", 0, "Number of packets sent (sendmmsg) or received (recvmmsg) per system call, 0 or 1 disables batching (UDP only)")
+	rootCmd.PersistentFlags().IntVar(&udpMTU, "udp-mtu", 0, "Size of the buffers packets are received into, larger packets are truncated. 0 uses 1500 bytes (UDP only)")
 
 	rootCmd.PersistentFlags().StringVar(&tcpCongAlg, "tcp-congestion", "reno", "TCP Congestion control algorithm to use, only when --transport is tcp")
-	rootCmd.PersistentFlags().StringVar(&quicCC, "quic-cc", "none", "QUIC congestion control algorithm. ('none', 'newreno')")
+	rootCmd.PersistentFlags().StringVar(&quicCC, "quic-cc", "none", "QUIC congestion control algorithm. ('none', 'newreno', 'bbr', 'copa')")
 
-	rootCmd.PersistentFlags().StringVarP(&codec, "codec", "c", "h264", "Media codec")
+	rootCmd.PersistentFlags().StringVarP(&codec, "codec", "c", "h264", "Media codec: 'h264', 'h265', 'vp8', 'vp9', 'av1' or 'opus' for audio, e.g., from 'autoaudiosrc'. Use 'auto' on the receiver to select the codec by payload type")
+	rootCmd.PersistentFlags().UintVar(&payloadType, "payload-type", 96, "RTP payload type of the media stream")
+	rootCmd.PersistentFlags().UintVar(&redPayloadType, "red-pt", 63, "RTP payload type used for RED (RFC 2198) encapsulation")
+	rootCmd.PersistentFlags().UintVar(&audioPT, "audio-pt", media.OpusPayloadType, "RTP payload type of the Opus stream sent alongside the video")
+	rootCmd.PersistentFlags().StringVar(&mediaBackend, "media-backend", media.BackendGstreamer, fmt.Sprintf("Media backend encoding and playing the video, one of %v. 'ffmpeg' runs the ffmpeg and ffplay binaries instead of Gstreamer, sources are scaled to --resolution or 1280x720 at 30 fps", media.Backends))
+	rootCmd.PersistentFlags().IntVar(&pipelineRestarts, "pipeline-restarts", 5, "Number of consecutive attempts to rebuild a failed or stalled media source or playing sink without dropping the connection, 0 disables restarts")
 
 	rootCmd.PersistentFlags().StringVar(&rtpDumpFile, "rtp-dump", "", "RTP dump file, 'stdout' for Stdout")
 	rootCmd.PersistentFlags().StringVar(&rtcpDumpFile, "rtcp-dump", "", "RTCP dump file, 'stdout' for Stdout")
+	rootCmd.PersistentFlags().StringVar(&dumpFormat, "dump-format", "text", "Format of the --rtp-dump and --rtcp-dump files: 'text' or 'rtpdump' (binary format of rtptools, read by rtpplay and Wireshark)")
 	rootCmd.PersistentFlags().StringVar(&qlogDir, "qlog", "", "QLOG directory. No logs if empty. Use 'sdtout' for Stdout or '<directory>' for a QLOG file named '<directory>/<connection-id>.qlog'")
 	rootCmd.PersistentFlags().StringVar(&keyLogFile, "keylogfile", "", "TLS keys for decrypting traffic e.g. using wireshark")
+	rootCmd.PersistentFlags().DurationVar(&logDrops, "log-drops", 0, "Log dropped packets with the drop reason, at most one line per reason and interval. 0 disables logging, drop counts are always logged on exit")
+	rootCmd.PersistentFlags().StringVar(&latencyFile, "latency-histograms", "", "File to write latency percentiles (one-way delay, RTT, frame completion, glass-to-glass) to on exit, use 'stdout' for Stdout")
+	rootCmd.PersistentFlags().DurationVar(&latencyLog, "latency-interval", 0, "Interval at which the latency percentiles (p50, p90, p99, p99.9) since the start are logged, 0 disables logging")
+	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics (flow bitrates, RTT, loss, target bitrate, drops) on under /metrics, e.g., ':9090'. Disabled if empty")
+	rootCmd.PersistentFlags().StringVar(&controlAddr, "control-addr", "", "Address to serve the HTTP control service on: POST /start-stream, /stop-stream and /set-bitrate with a JSON body, e.g., '{\"ssrc\": 0}', and GET /stats. Disabled if empty")
+	rootCmd.PersistentFlags().BoolVar(&showDashboard, "dashboard", false, "Show a live view of the bitrate, loss and queue depth per flow and the congestion control state, refreshed every second. Log output is shown below")
+	rootCmd.PersistentFlags().StringVar(&resultsDir, "results-dir", "", "Directory of the log files of this run, compressed to '<dir>-<host>-<time>.tar.gz' next to it on exit. Disabled if empty")
+	rootCmd.PersistentFlags().StringVar(&uploadURL, "upload", "", "Upload the compressed --results-dir on exit to an HTTP(S) URL using PUT or to 's3://<bucket>/<key>' (credentials from the AWS_* environment variables). A URL ending in '/' gets the archive name appended")
+	rootCmd.PersistentFlags().StringVar(&pcapFile, "pcap", "", "pcapng file to write the plaintext RTP and RTCP packets to as if they were sent over UDP between 10.0.0.1 (sender) and 10.0.0.2 (receiver), port 5004")
+	rootCmd.PersistentFlags().StringVar(&statsFile, "stats", "", "File to write periodic stats (bitrate, packets, loss and the target bitrate, RTT, queue delay and loss rate of the congestion controllers) to, one row per --stats-interval, use 'stdout' for Stdout")
+	rootCmd.PersistentFlags().StringVar(&statsFormat, "stats-format", "csv", "Format of the --stats file: 'csv' or 'ndjson'")
+	rootCmd.PersistentFlags().DurationVar(&statsInterval, "stats-interval", 100*time.Millisecond, "Interval of the rows written to --stats")
+	rootCmd.PersistentFlags().StringVar(&influxURL, "influx-url", "", "InfluxDB (or other line protocol) write endpoint to push metrics to every --influx-interval, e.g., 'http://localhost:8086/api/v2/write?org=org&bucket=bucket', the token is read from INFLUX_TOKEN. Disabled if empty")
+	rootCmd.PersistentFlags().DurationVar(&influxInterval, "influx-interval", time.Second, "Interval at which metrics are pushed to --influx-url")
+	rootCmd.PersistentFlags().StringVar(&experimentName, "experiment", "", "Name of the experiment, added as tag to the metrics pushed to --influx-url")
+	rootCmd.PersistentFlags().StringVar(&otlpEndpoint, "otlp-endpoint", "", "OpenTelemetry collector endpoint to export spans of the frame and packet stages (capture, packetize, interceptor, transport, sink) to using OTLP/HTTP, e.g., 'http://localhost:4318'. Disabled if empty")
+	rootCmd.PersistentFlags().StringSliceVar(&experimentalFeatures, "enable-experimental", nil, fmt.Sprintf("Experimental features to enable, e.g., 'moq'. Known features: %v. The enabled features are recorded in the manifest.json of --results-dir", experimental.Features))
+	rootCmd.PersistentFlags().StringVar(&srtpKey, "srtp-key", "", "Hex encoded pre-shared SRTP master key and salt (30 bytes, AES_CM_128_HMAC_SHA1_80). SRTP is disabled if empty")
 
 	rootCmd.PersistentFlags().StringVar(&cpuProfile, "pprof-cpu", "", "Create pprof CPU profile with given filename")
 	rootCmd.PersistentFlags().StringVar(&goroutineProfile, "pprof-goroutine", "", "Create pprof 'goroutine' profile with given filename")
@@ -55,7 +139,46 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&mutexProfile, "pprof-mutex", "", "Create pprof 'mutex' profile with given filename")
 }
 
-var rootCmd = &cobra.Command{}
+var rootCmd = &cobra.Command{
+	PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+		// the environment and the configuration file set flags, so they
+		// have to be applied before any flag is read
+		if configurable(cmd) {
+			if err := applyEnv(cmd); err != nil {
+				return err
+			}
+			if err := applyConfigFile(cmd); err != nil {
+				return err
+			}
+		}
+		logging.SetDropLogInterval(logDrops)
+		if err := experimental.Enable(experimentalFeatures...); err != nil {
+			return err
+		}
+		if active := experimental.Active(); len(active) > 0 {
+			log.Printf("enabled experimental features: %v", active)
+		}
+		if len(resultsDir) > 0 {
+			if err := writeManifest(cmd, resultsDir); err != nil {
+				log.Printf("failed to write manifest: %v", err)
+			}
+		}
+		if len(otlpEndpoint) > 0 {
+			tracing.SetTracer(tracing.NewTracer(otlpEndpoint, "rtp-over-quic-"+cmd.Name()))
+		}
+		return nil
+	},
+}
+
+// configurable returns whether cmd is configured by the environment and
+// --config. The check command reads --config itself.
+func configurable(cmd *cobra.Command) bool {
+	switch cmd {
+	case sendCmd, receiveCmd, relayCmd, gatewayCmd, loadgenCmd:
+		return true
+	}
+	return false
+}
 
 func Execute() {
 	done, err := setupProfiling(
@@ -74,9 +197,78 @@ func Execute() {
 			log.Fatal(err)
 		}
 	}()
-	if err := rootCmd.Execute(); err != nil {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		log.Fatal(err)
 	}
+	closeTracer()
+	logging.LogDropCounts()
+	if len(latencyFile) > 0 {
+		if err := logging.WriteLatencyHistograms(latencyFile); err != nil {
+			log.Printf("failed to write latency histograms: %v", err)
+		}
+	}
+	if len(resultsDir) > 0 {
+		if err := archiveResults(resultsDir, uploadURL); err != nil {
+			log.Printf("failed to archive results: %v", err)
+		}
+	}
+}
+
+// closeTracer exports the remaining spans, if tracing is enabled.
+func closeTracer() {
+	t := tracing.GetTracer()
+	if t == nil {
+		return
+	}
+	tracing.SetTracer(nil)
+	if err := t.Close(); err != nil {
+		log.Printf("failed to export spans: %v", err)
+	}
+}
+
+// writeManifest records the command line and the experimental features of
+// this run in dir.
+func writeManifest(cmd *cobra.Command, dir string) error {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return logging.WriteManifest(filepath.Join(dir, "manifest.json"), logging.Manifest{
+		Command:      cmd.Name(),
+		Args:         os.Args[1:],
+		Host:         host,
+		Start:        time.Now(),
+		Experimental: experimental.Active(),
+	})
+}
+
+// archiveResults compresses dir and uploads the archive to target, if it is
+// not empty. The archive is named after the host, so that the results of
+// all hosts of a testbed can be uploaded to the same location.
+func archiveResults(dir, target string) error {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	dir = filepath.Clean(dir)
+	name := fmt.Sprintf("%v-%v-%v.tar.gz", filepath.Base(dir), host, time.Now().Format("20060102T150405"))
+	archive := filepath.Join(filepath.Dir(dir), name)
+	if err := logging.ArchiveDir(dir, archive); err != nil {
+		return err
+	}
+	log.Printf("archived results to %v", archive)
+	if len(target) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	if err := logging.Upload(ctx, archive, target); err != nil {
+		return fmt.Errorf("failed to upload %v to %v: %w", archive, target, err)
+	}
+	log.Printf("uploaded results to %v", target)
+	return nil
 }
 
 func setupProfiling(cpu, goroutine, heap, allocs, block, mutex string) (func() error, error) {
@@ -123,3 +315,46 @@ func setupProfiling(cpu, goroutine, heap, allocs, block, mutex string) (func() e
 	}
 	return concatDoneFns(doneFns), nil
 }
+
+// commonConfig returns the configuration shared by sender and receiver given
+// by the flags.
+func commonConfig() roq.Config {
+	c := roq.Config{
+		Transport:        transport,
+		Addr:             addr,
+		ECN:              ecn,
+		UDPBatch:         udpBatch,
+		UDPMTU:           udpMTU,
+		QLOGDir:          qlogDir,
+		KeyLogFile:       keyLogFile,
+		QUICCC:           quicCC,
+		TCPCC:            tcpCongAlg,
+		Codec:            codec,
+		PayloadType:      payloadType,
+		REDPayloadType:   redPayloadType,
+		AudioPayloadType: audioPT,
+		MediaBackend:     mediaBackend,
+		PipelineRestarts: pipelineRestarts,
+		SRTPKey:          srtpKey,
+		RTPDumpFile:      rtpDumpFile,
+		RTCPDumpFile:     rtcpDumpFile,
+		DumpFormat:       dumpFormat,
+		PcapFile:         pcapFile,
+		MetricsAddr:      metricsAddr,
+		ControlAddr:      controlAddr,
+		StatsFile:        statsFile,
+		StatsFormat:      statsFormat,
+		StatsInterval:    statsInterval,
+		InfluxURL:        influxURL,
+		InfluxInterval:   influxInterval,
+		Experiment:       experimentName,
+		RecordLatency:    len(latencyFile) > 0 || latencyLog > 0,
+		LatencyInterval:  latencyLog,
+		Dashboard:        showDashboard,
+		Duration:         duration,
+	}
+	if controlStdin {
+		c.Control = os.Stdin
+	}
+	return c
+}
@@ -1,217 +1,285 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"log"
-	"math/rand"
 	"time"
 
-	"github.com/Willi-42/rtp-over-quic/cc"
 	"github.com/Willi-42/rtp-over-quic/media"
-	"github.com/Willi-42/rtp-over-quic/quic"
-	"github.com/Willi-42/rtp-over-quic/rtp"
-	"github.com/Willi-42/rtp-over-quic/tcp"
-	"github.com/Willi-42/rtp-over-quic/udp"
-	"github.com/pion/interceptor"
+	"github.com/Willi-42/rtp-over-quic/roq"
 	"github.com/spf13/cobra"
 )
 
 var (
-	source string
-	ccDump string
-	rtpCC  string
+	source     string
+	resolution string
+	ssrc       uint32
+	ccDump     string
+	rtpCC      string
+
+	streams       int
+	streamSources []string
+
+	rtpCCSwitch []string
+
+	metricsLog      string
+	metricsInterval time.Duration
 
 	sendStream           bool
 	localRFC8888         bool
 	initialTargetBitrate uint
+	ccMinBitrate         uint
+	ccMaxBitrate         uint
+	redDistance          uint
+	reliabilityPolicy    string
+	fecGroupSize         int
+	aggregationDelay     time.Duration
+	sendQueueBudget      time.Duration
+	sendQueuePolicy      string
+	backpressureTimeout  time.Duration
+	udpGSO               bool
+	pacingInterval       time.Duration
+	pacingBurst          int
+	playoutDelay         time.Duration
+	bufferHealthLog      string
+	absCaptureTime       bool
+	fsePriority          float64
+	quicCCTarget         bool
+	probe                bool
+	freezeAppLimited     bool
+
+	encoderMinBitrate uint
+	encoderMaxBitrate uint
+	encoderHeadroom   float64
+
+	backupAddr      string
+	failoverTimeout time.Duration
+
+	reconnect           bool
+	reconnectAttempts   int
+	reconnectBackoff    time.Duration
+	reconnectMaxBackoff time.Duration
+
+	autoTimeout time.Duration
+
+	pathCacheFile      string
+	reusePathEstimates bool
+
+	contentHint string
+	framerate   uint
+	encoder     string
+	packetizer  string
+	adaptation  bool
+
+	bweEvalCapacity uint
+	bweEvalTrace    string
+	bweEvalLog      string
+
+	controlStdin  bool
+	controlSocket string
+
+	signalingURL string
+
+	rtspLatency time.Duration
+	rtspTCP     bool
+
+	audioSource  string
+	audioBitrate uint
+
+	syncodecFrameInterval    time.Duration
+	syncodecBurstiness       float64
+	syncodecKeyFrameInterval uint
+	syncodecAdaptationDelay  time.Duration
+	syncodecStatsLog         string
 )
 
 func init() {
 	rootCmd.AddCommand(sendCmd)
 
-	sendCmd.Flags().StringVar(&source, "source", "videotestsrc", "Media source")
+	sendCmd.Flags().StringVar(&source, "source", "videotestsrc", "Media source: 'videotestsrc', 'syncodec', 'gotestsrc' (fake H.264 or VP8 frames generated without an encoder, receive with --sink none), 'ximagesrc' or 'pipewiresrc' to capture the screen, a camera, e.g., 'camera:/dev/video0', an RTSP URL, e.g., of an IP camera, or a video file, optionally prefixed by 'file:', e.g., 'file:foreman_cif.y4m'")
+	sendCmd.Flags().DurationVar(&syncodecFrameInterval, "syncodec-frame-interval", time.Second/30, "Mean time between two frames of --source 'syncodec'")
+	sendCmd.Flags().Float64Var(&syncodecBurstiness, "syncodec-burstiness", 0.15, "Scale of the Laplacian noise of the frame sizes and intervals of --source 'syncodec' relative to their means, 0 sends frames of constant size at a constant interval")
+	sendCmd.Flags().UintVar(&syncodecKeyFrameInterval, "syncodec-keyframe-interval", 0, "Frames from one key frame of --source 'syncodec' to the next, key frames are 5 times the mean frame size. 0 disables key frames")
+	sendCmd.Flags().DurationVar(&syncodecAdaptationDelay, "syncodec-adaptation-delay", 0, "Time --source 'syncodec' takes to apply a new target bitrate")
+	sendCmd.Flags().StringVar(&syncodecStatsLog, "syncodec-stats", "", "Log file for the frames of --source 'syncodec': time in ms, SSRC, frame number, bytes, duration in ms, key frame and target bitrate. Use 'stdout' for Stdout")
+	sendCmd.Flags().StringVar(&contentHint, "content-hint", "auto", "Content of the video: 'camera', 'screen' or 'auto' ('screen' for 'ximagesrc' and 'pipewiresrc'). Screen content uses low-latency screen encoder presets, is sent reliably unless --reliability selects a policy and freezes the target bitrate while application limited")
+	sendCmd.Flags().DurationVar(&rtspLatency, "rtsp-latency", 200*time.Millisecond, "Buffer of RTSP sources")
+	sendCmd.Flags().BoolVar(&rtspTCP, "rtsp-tcp", false, "Receive RTSP sources interleaved in the RTSP connection instead of over UDP, e.g., to pass firewalls")
+	sendCmd.Flags().StringVar(&resolution, "resolution", "", "Resolution the video is scaled to before encoding, e.g., '1280x720', the resolution of the source is kept if empty")
+	sendCmd.Flags().StringVar(&encoder, "encoder", "software", "Video encoder: 'software', or the hardware encoders 'vaapi' (Intel and AMD GPUs) and 'nvenc' (NVIDIA GPUs) for the codecs h264 and h265, tuned for low latency")
+	sendCmd.Flags().StringVar(&packetizer, "packetizer", "auto", "Packetizer of Gstreamer sources: 'gstreamer', 'go' or 'auto' ('go' for --transport 'quic-prio'). 'go' packetizes the encoded frames of the pipeline with the pion payloaders, so that the transport knows the frame boundaries, sizes and key frames")
+	sendCmd.Flags().BoolVar(&adaptation, "adapt-resolution", false, "Let the congestion control target bitrate step the resolution and frame rate of Gstreamer sources down from --resolution and --framerate (1280x720 at 30 fps if unset) when the bits per pixel get too low, and back up with hysteresis. Every switch is logged")
+	sendCmd.Flags().UintVar(&framerate, "framerate", 0, "Frame rate the video is converted to before encoding, e.g., 30, the frame rate of the source is kept if 0")
+	sendCmd.Flags().Uint32Var(&ssrc, "ssrc", 0, "SSRC of the media stream")
+	sendCmd.Flags().IntVar(&streams, "streams", 1, "Number of media streams sent on the connection, each with its own SSRC (--ssrc plus the index of the stream) and flow ID, sharing the target bitrate equally")
+	sendCmd.Flags().StringSliceVar(&streamSources, "stream-sources", nil, "Sources of the --streams in order, streams without an entry or with an empty entry use --source")
+	sendCmd.Flags().StringVar(&audioSource, "audio-source", "", "Source of an Opus stream sent alongside the video with its own SSRC and flow ID: 'autoaudiosrc', 'pulsesrc', 'alsasrc', 'audiotestsrc' or a file. Disabled if empty")
+	sendCmd.Flags().UintVar(&audioBitrate, "audio-bitrate", 32_000, "Bitrate in bit/s of the --audio-source stream, which is not adapted by congestion control")
 	sendCmd.Flags().StringVar(&ccDump, "cc-dump", "", "Congestion Control log file, use 'stdout' for Stdout")
-	sendCmd.Flags().StringVar(&rtpCC, "rtp-cc", "none", "RTP congestion control algorithm. ('none', 'scream', 'gcc')")
+	sendCmd.Flags().StringVar(&metricsLog, "metrics-log", "", "Log file for the metrics (target, pacing rate, cwnd, RTT, queue delay, loss rate) of the RTP and QUIC congestion controllers, use 'stdout' for Stdout")
+	sendCmd.Flags().DurationVar(&metricsInterval, "metrics-interval", 100*time.Millisecond, "Interval at which the congestion control metrics are sampled")
+	sendCmd.Flags().StringVar(&rtpCC, "rtp-cc", "none", "RTP congestion control algorithm. ('none', 'scream', 'gcc', 'nada' or an algorithm added using cc.Register)")
+	sendCmd.Flags().StringSliceVar(&rtpCCSwitch, "rtp-cc-switch", nil, "Switch the RTP congestion control algorithm during the session, e.g., '30s=gcc' to switch to GCC after 30 seconds, the new algorithm starts at the current target bitrate")
+	sendCmd.Flags().UintVar(&initialTargetBitrate, "start-bitrate", 100_000, "Initial target bitrate in bit/s of the congestion controller and the media")
 	sendCmd.Flags().UintVar(&initialTargetBitrate, "target", 100_000, "Initial media target bitrate")
+	if err := sendCmd.Flags().MarkDeprecated("target", "use --start-bitrate instead"); err != nil {
+		log.Fatal(err)
+	}
+	sendCmd.Flags().UintVar(&ccMinBitrate, "min-bitrate", 100_000, "Lowest target bitrate in bit/s of the congestion controller and the media")
+	sendCmd.Flags().UintVar(&ccMaxBitrate, "max-bitrate", 100_000_000, "Highest target bitrate in bit/s of the congestion controller and the media")
+	sendCmd.Flags().UintVar(&encoderMinBitrate, "encoder-min", 0, "Lowest bitrate in bit/s the encoder is configured with")
+	sendCmd.Flags().UintVar(&encoderMaxBitrate, "encoder-max", 0, "Highest bitrate in bit/s the encoder is configured with, 0 means no limit")
+	sendCmd.Flags().Float64Var(&encoderHeadroom, "encoder-headroom", 0, "Fraction of the congestion control target bitrate kept as headroom when configuring the encoder, e.g., 0.1 for 10%")
+	sendCmd.Flags().BoolVar(&quicCCTarget, "quic-cc-target", false, "Use the rate of the QUIC congestion controller ('bbr', 'copa') as media target bitrate, requires --rtp-cc 'none'")
+	sendCmd.Flags().Float64Var(&fsePriority, "priority", 1, "Priority of the media stream when sharing the rate with other streams of the connection (RFC 8699)")
+	sendCmd.Flags().BoolVar(&probe, "probe", false, "Probe for capacity above the send rate using RTP padding while the media is application limited, requires --rtp-cc")
+	sendCmd.Flags().BoolVar(&freezeAppLimited, "freeze-app-limited", false, "Do not increase the target bitrate while the media sends less than 80% of it, requires --rtp-cc")
 	sendCmd.Flags().BoolVar(&localRFC8888, "local-rfc8888", false, "Generate local RFC 8888 feedback")
 	sendCmd.Flags().BoolVar(&sendStream, "stream", false, "Send random data on a stream")
+	sendCmd.Flags().StringVar(&backupAddr, "backup-addr", "", "Address of a backup receiver to fail over to if the connection to the receiver fails (QUIC only)")
+	sendCmd.Flags().DurationVar(&failoverTimeout, "failover-timeout", 2*time.Second, "Time without traffic from the receiver after which the sender fails over to the backup receiver")
+	sendCmd.Flags().BoolVar(&reconnect, "reconnect", false, "Reconnect to the receiver with exponential backoff if the connection is lost, keeping the media pipeline running (QUIC only)")
+	sendCmd.Flags().IntVar(&reconnectAttempts, "reconnect-attempts", 0, "Number of failed reconnection attempts after which the sender stops, 0 means unlimited")
+	sendCmd.Flags().DurationVar(&reconnectBackoff, "reconnect-backoff", 500*time.Millisecond, "Wait after the first failed reconnection attempt, doubled after each further attempt")
+	sendCmd.Flags().DurationVar(&reconnectMaxBackoff, "reconnect-max-backoff", 10*time.Second, "Upper bound of the wait between reconnection attempts")
+	sendCmd.Flags().DurationVar(&autoTimeout, "auto-timeout", 3*time.Second, "Time to connect using each transport tried by --transport 'auto' before falling back to the next one")
+	sendCmd.Flags().StringVar(&pathCacheFile, "path-cache", "", "File to cache measured path properties per server address in, disabled if empty")
+	sendCmd.Flags().BoolVar(&reusePathEstimates, "reuse-path-estimates", false, "Use the target bitrate cached in --path-cache as initial target bitrate")
+	sendCmd.Flags().UintVar(&bweEvalCapacity, "bwe-eval-capacity", 0, "Known bottleneck capacity in bit/s to evaluate the bandwidth estimation against, 0 disables the evaluation")
+	sendCmd.Flags().StringVar(&bweEvalTrace, "bwe-eval-trace", "", "Capacity trace file ('<offset ms>, <bit/s>' per line) to evaluate the bandwidth estimation against")
+	sendCmd.Flags().StringVar(&bweEvalLog, "bwe-eval-log", "", "Bandwidth estimation error log file, use 'stdout' for Stdout")
+	sendCmd.Flags().UintVar(&redDistance, "red-distance", 0, "Number of previous Opus payloads to repeat in RED (RFC 2198) packets, 0 disables RED")
+	sendCmd.Flags().StringVar(&reliabilityPolicy, "reliability", "none", "Policy selecting the RTP packets sent on QUIC streams instead of datagrams: 'none', 'keyframes' or 'h264-headers' (parameter sets and the first packet of IDR slices), requires --transport 'quic' or 'quic-prio'")
+	sendCmd.Flags().IntVar(&fecGroupSize, "fec-group", 0, "Number of QUIC datagrams protected by one XOR repair datagram, 0 disables FEC (QUIC only)")
+	sendCmd.Flags().DurationVar(&aggregationDelay, "aggregation-delay", 0, "Maximum time small QUIC datagrams are held back to be sent together in one datagram, 0 disables aggregation (QUIC only)")
+	sendCmd.Flags().DurationVar(&sendQueueBudget, "send-queue-budget", 0, "Maximum time packets wait in the send queue before the congestion controller or pacer lets them through, late packets of frames other than key frames are dropped instead of adding latency, 0 disables the queue (QUIC only)")
+	sendCmd.Flags().StringVar(&sendQueuePolicy, "send-queue-policy", "frames", "What the send queue drops when packets exceed the budget: 'packets' drops the late packets, 'frames' drops whole frames")
+	sendCmd.Flags().DurationVar(&backpressureTimeout, "backpressure-timeout", 0, "Maximum time a packet waits for the pacer, the congestion controller or the stream flow control before it is dropped and the target bitrate of the media is throttled, 0 blocks the media pipeline until the packet is sent (QUIC only)")
+	sendCmd.Flags().BoolVar(&udpGSO, "udp-gso", false, "Let the kernel segment batches of packets of the same size (UDP GSO), batches up to 64 packets without --udp-batch (Linux and UDP only)")
+	sendCmd.Flags().DurationVar(&pacingInterval, "pacing-interval", 0, "Interval in which the pacer releases packets at the congestion control target bitrate, 0 disables the pacer (QUIC only)")
+	sendCmd.Flags().IntVar(&pacingBurst, "pacing-burst", 4800, "Maximum number of bytes the pacer releases at once")
+	sendCmd.Flags().DurationVar(&playoutDelay, "playout-delay", 0, "Playout delay of the receiver used to estimate its buffer occupancy from RFC 8888 feedback, the pacer sends ahead while the buffer runs low, 0 disables the estimation")
+	sendCmd.Flags().StringVar(&bufferHealthLog, "buffer-health-log", "", "Log file for the estimated buffer occupancy of the receiver, use 'stdout' for Stdout")
+	sendCmd.Flags().BoolVar(&absCaptureTime, "abs-capture-time", false, "Stamp the capture time of each frame into the abs-capture-time RTP header extension, from which a receiver with --latency-histograms or --latency-interval measures the glass-to-glass latency. Requires synchronized clocks")
+	sendCmd.Flags().StringVar(&signalingURL, "signaling-url", "", "URL of the signaling endpoint of the receiver (--signaling-addr), e.g., 'http://receiver:8080/offer', to offer the codec, SSRCs, header extensions and flow IDs to before connecting. Disabled if empty")
+	sendCmd.Flags().StringVar(&controlSocket, "control-socket", "", "Address of a JSON-RPC 2.0 control socket, 'unix:<path>' for a Unix socket or a TCP address, with the methods 'pause', 'resume', 'set-bitrate-cap', 'force-keyframe', 'switch-cc' and 'dump-stats'. Disabled if empty")
+	sendCmd.Flags().BoolVar(&controlStdin, "control-stdin", false, "Read control commands from Stdin, one per line: 'pause [ssrc]' and 'resume [ssrc]' stop and continue sending a flow or all flows")
 }
 
 var sendCmd = &cobra.Command{
 	Use: "send",
 	Run: func(cmd *cobra.Command, _ []string) {
-		sc := senderController{}
-		if err := sc.start(cmd.Context()); err != nil {
+		c, err := senderConfig()
+		if err != nil {
 			log.Fatal(err)
 		}
-	},
-}
-
-type MediaSource interface {
-	Play() error
-	Stop() error
-	SetTargetBitsPerSecond(uint)
-}
-
-type BandwidthEstimator interface {
-	SetMedia(rtp.Media)
-}
-
-type senderController struct {
-	bwe BandwidthEstimator
-}
-
-func (c *senderController) setupInterceptor(ctx context.Context) (*interceptor.Registry, error) {
-	rtpOptions := []rtp.Option{
-		rtp.RegisterSenderPacketLog(rtpDumpFile, rtcpDumpFile),
-	}
-
-	if rtpCC == cc.SCReAM.String() {
-		bwe, err := rtp.NewBandwidthEstimator(ccDump)
+		s, err := roq.NewSender(roq.SetSenderConfig(c))
 		if err != nil {
-			return nil, err
+			log.Fatal(err)
 		}
-		c.bwe = bwe
-		go func() {
-			if err := bwe.RunSCReAM(ctx); err != nil {
-				log.Printf("bwe.RunSCReAM returned error: %v", err)
-			}
-		}()
-		rtpOptions = append(rtpOptions, rtp.RegisterSCReAM(bwe.OnNewSCReAMEstimator, int(initialTargetBitrate)))
-	}
-	if rtpCC == cc.GCC.String() {
-		bwe, err := rtp.NewBandwidthEstimator(ccDump)
-		if err != nil {
-			return nil, err
+		go handleSignals(cmd.Context(), s)
+		if err := s.Start(cmd.Context()); err != nil {
+			log.Fatal(err)
 		}
-		c.bwe = bwe
-		go func() {
-			if err := bwe.RunGCC(ctx); err != nil {
-				log.Printf("bwe.RunSCReAM returned error: %v", err)
-			}
-		}()
-		rtpOptions = append(rtpOptions, rtp.RegisterTWCCHeaderExtension())
-		rtpOptions = append(rtpOptions, rtp.RegisterGCC(bwe.OnNewGCCEstimator))
-	}
-	return rtp.New(rtpOptions...)
+	},
 }
 
-func (c *senderController) start(ctx context.Context) error {
-	in, err := c.setupInterceptor(ctx)
-	if err != nil {
-		return err
-	}
-	senderFactory, err := transportFactory(transport)
+// senderConfig returns the sender configuration given by the flags.
+func senderConfig() (roq.SenderConfig, error) {
+	switches, err := roq.ParseCCSwitches(rtpCCSwitch)
 	if err != nil {
-		return err
+		return roq.SenderConfig{}, err
 	}
-	sender, err := senderFactory(ctx, in)
+	width, height, err := parseResolution(resolution)
 	if err != nil {
-		return err
+		return roq.SenderConfig{}, err
 	}
-	return c.startMedia(sender)
-}
+	return roq.SenderConfig{
+		Config:              commonConfig(),
+		Source:              source,
+		SSRC:                ssrc,
+		Width:               width,
+		Height:              height,
+		RTPCC:               rtpCC,
+		CCSwitches:          switches,
+		CCDump:              ccDump,
+		QUICCCTarget:        quicCCTarget,
+		StartBitrate:        initialTargetBitrate,
+		MinBitrate:          ccMinBitrate,
+		MaxBitrate:          ccMaxBitrate,
+		EncoderMinBitrate:   encoderMinBitrate,
+		EncoderMaxBitrate:   encoderMaxBitrate,
+		EncoderHeadroom:     encoderHeadroom,
+		Priority:            fsePriority,
+		Probe:               probe,
+		FreezeAppLimited:    freezeAppLimited,
+		LocalRFC8888:        localRFC8888,
+		MetricsLog:          metricsLog,
+		MetricsInterval:     metricsInterval,
+		REDDistance:         redDistance,
+		Reliability:         reliabilityPolicy,
+		FECGroupSize:        fecGroupSize,
+		AggregationDelay:    aggregationDelay,
+		SendQueueBudget:     sendQueueBudget,
+		SendQueuePolicy:     sendQueuePolicy,
+		BackpressureTimeout: backpressureTimeout,
+		UDPGSO:              udpGSO,
+		PacingInterval:      pacingInterval,
+		PacingBurst:         pacingBurst,
+		PlayoutDelay:        playoutDelay,
+		BufferHealthLog:     bufferHealthLog,
+		AbsCaptureTime:      absCaptureTime,
+		DataStream:          sendStream,
+		BackupAddr:          backupAddr,
+		FailoverTimeout:     failoverTimeout,
+		AutoTimeout:         autoTimeout,
+		PathCacheFile:       pathCacheFile,
+		ReusePathEstimates:  reusePathEstimates,
+		BWEEvalCapacity:     bweEvalCapacity,
+		BWEEvalTrace:        bweEvalTrace,
+		BWEEvalLog:          bweEvalLog,
 
-func transportFactory(transport string) (func(context.Context, *interceptor.Registry) (interceptor.RTPWriter, error), error) {
-	switch transport {
-	case "quic", "quic-dgram", "quic-stream", "quic-prio":
-		return startQUICSender, nil
-	case "udp":
-		return startUDPSender, nil
-	case "tcp":
-		return startTCPSender, nil
-	}
-	return nil, fmt.Errorf("%w: %v", errInvalidTransport, transport)
-}
+		Reconnect:           reconnect,
+		ReconnectAttempts:   reconnectAttempts,
+		ReconnectBackoff:    reconnectBackoff,
+		ReconnectMaxBackoff: reconnectMaxBackoff,
 
-func startQUICSender(ctx context.Context, ir *interceptor.Registry) (interceptor.RTPWriter, error) {
-	sender, err := quic.NewSender(
-		ir,
-		quic.SetTransportMode(quic.TransportModeFromString(transport)),
-		quic.RemoteAddress(addr),
-		quic.SetSenderQLOGDirName(qlogDir),
-		quic.SetSenderSSLKeyLogFileName(keyLogFile),
-		quic.SetSenderQUICCongestionControlAlgorithm(cc.AlgorithmFromString(quicCC)),
-		quic.SetLocalRFC8888(localRFC8888),
-	)
-	if err != nil {
-		return nil, err
-	}
-	if err := sender.Connect(ctx); err != nil {
-		return nil, err
-	}
-	if sendStream {
-		ds, err := sender.NewDataStreamWithDefaultFlowID(ctx)
-		if err != nil {
-			return nil, err
-		}
-		go func() {
-			rand.Seed(time.Now().UnixNano())
-			buf := make([]byte, 1200)
-			for {
-				_, err := rand.Read(buf)
-				if err != nil {
-					log.Printf("failed to read random data, exiting data stream sender: %v", err)
-					return
-				}
-				_, err = ds.Write(buf)
-				if err != nil {
-					log.Printf("failed to send random data, exiting data stream sender: %v", err)
-					return
-				}
-			}
-		}()
-	}
-	return sender.NewMediaStream()
-}
+		Streams:       streams,
+		StreamSources: streamSources,
+		ControlSocket: controlSocket,
+		SignalingURL:  signalingURL,
 
-func startUDPSender(ctx context.Context, ir *interceptor.Registry) (interceptor.RTPWriter, error) {
-	sender, err := udp.NewSender(
-		ir,
-		udp.RemoteAddress(addr),
-	)
-	if err != nil {
-		return nil, err
-	}
-	if err := sender.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return sender.NewMediaStream(), nil
-}
+		RTSPLatency: rtspLatency,
+		RTSPOverTCP: rtspTCP,
 
-func startTCPSender(ctx context.Context, ir *interceptor.Registry) (interceptor.RTPWriter, error) {
-	sender, err := tcp.NewSender(
-		ir,
-		tcp.RemoteAddress(addr),
-	)
-	if err != nil {
-		return nil, err
-	}
-	if err := sender.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return sender.NewMediaStream(), nil
+		AudioSource:  audioSource,
+		AudioBitrate: audioBitrate,
+
+		ContentHint: contentHint,
+		Framerate:   framerate,
+		Encoder:     encoder,
+		Packetizer:  packetizer,
+		Adaptation:  adaptation,
+
+		Syncodec: media.SyncodecModel{
+			FrameInterval:    syncodecFrameInterval,
+			Burstiness:       syncodecBurstiness,
+			KeyFrameInterval: syncodecKeyFrameInterval,
+			AdaptationDelay:  syncodecAdaptationDelay,
+		},
+		SyncodecStatsLog: syncodecStatsLog,
+	}, nil
 }
 
-func (c *senderController) startMedia(writer interceptor.RTPWriter) error {
-	mediaOptions := []media.ConfigOption{
-		media.Codec(codec),
-		media.InitialTargetBitrate(initialTargetBitrate),
-	}
-	var ms MediaSource
-	var err error
-	switch source {
-	case "syncodec":
-		ms, err = media.NewSyncodecSource(writer, mediaOptions...)
-	default:
-		ms, err = media.NewGstreamerSource(writer, source, transport != "quic-prio", mediaOptions...)
-	}
-	if err != nil {
-		return err
+// parseResolution parses a resolution of the form '<width>x<height>', an
+// empty resolution is 0x0.
+func parseResolution(r string) (uint, uint, error) {
+	if len(r) == 0 {
+		return 0, 0, nil
 	}
-	if c.bwe != nil {
-		c.bwe.SetMedia(ms)
+	var width, height uint
+	if _, err := fmt.Sscanf(r, "%dx%d", &width, &height); err != nil || width == 0 || height == 0 {
+		return 0, 0, fmt.Errorf("%w: invalid --resolution %v, expected '<width>x<height>'", errInvalidConfig, r)
 	}
-	return ms.Play()
+	return width, height, nil
 }
@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Willi-42/rtp-over-quic/roq"
+)
+
+// handleSignals toggles sending the media of s on SIGUSR1 and caps the target
+// bitrate at half of its current value on SIGUSR2 until the next SIGUSR2,
+// until ctx is done.
+func handleSignals(ctx context.Context, s *roq.Sender) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+	paused := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			switch sig {
+			case syscall.SIGUSR1:
+				var err error
+				if paused {
+					err = s.Resume(0)
+				} else {
+					err = s.Pause(0)
+				}
+				if err != nil {
+					log.Printf("SIGUSR1: %v", err)
+					continue
+				}
+				paused = !paused
+			case syscall.SIGUSR2:
+				if s.BitrateCap() > 0 {
+					s.SetBitrateCap(0)
+					log.Printf("SIGUSR2: removed target bitrate cap")
+					continue
+				}
+				limit := s.TargetBitrate() / 2
+				if limit == 0 {
+					log.Printf("SIGUSR2: no target bitrate to cap yet")
+					continue
+				}
+				s.SetBitrateCap(limit)
+				log.Printf("SIGUSR2: capped target bitrate at %v bit/s", limit)
+			}
+		}
+	}
+}
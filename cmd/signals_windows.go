@@ -0,0 +1,10 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/Willi-42/rtp-over-quic/roq"
+)
+
+// handleSignals does nothing, there are no SIGUSR1 and SIGUSR2 on Windows.
+func handleSignals(_ context.Context, _ *roq.Sender) {}
@@ -0,0 +1,195 @@
+// Package dashboard renders a live view of the flows and congestion
+// controllers of a sender or receiver to a terminal.
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+)
+
+const (
+	refreshInterval = time.Second
+	logLines        = 8
+
+	clearScreen = "\033[H\033[2J"
+)
+
+// QueueSource is implemented by components which queue packets per flow,
+// e.g., congestion controllers and jitter buffers.
+type QueueSource interface {
+	QueueDepths() map[uint32]int
+}
+
+type flowKey struct {
+	ssrc      uint32
+	direction rtp.Direction
+}
+
+// Dashboard periodically redraws the bitrate, loss and queue depth of all
+// flows counted by a TrafficCounter and the state of the congestion
+// controllers. Log output written to the dashboard is shown below the
+// tables, so that it does not scroll the view.
+type Dashboard struct {
+	out     io.Writer
+	title   string
+	traffic *rtp.TrafficCounter
+
+	lock      sync.Mutex
+	sources   map[string]cc.MetricsSource
+	queues    []QueueSource
+	logs      []string
+	lastBytes map[flowKey]uint64
+	lastDraw  time.Time
+}
+
+func New(out io.Writer, title string, traffic *rtp.TrafficCounter) *Dashboard {
+	return &Dashboard{
+		out:       out,
+		title:     title,
+		traffic:   traffic,
+		sources:   map[string]cc.MetricsSource{},
+		queues:    []QueueSource{},
+		logs:      []string{},
+		lastBytes: map[flowKey]uint64{},
+	}
+}
+
+// AddSource adds a congestion controller shown as name.
+func (d *Dashboard) AddSource(name string, s cc.MetricsSource) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.sources[name] = s
+}
+
+// AddQueue adds a source of per flow queue depths. Depths of multiple
+// sources for the same flow are summed up.
+func (d *Dashboard) AddQueue(q QueueSource) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.queues = append(d.queues, q)
+}
+
+// Write keeps the last lines of log output to show them on the next
+// refresh. Use it as output of the log package while the dashboard runs.
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		d.logs = append(d.logs, line)
+	}
+	if len(d.logs) > logLines {
+		d.logs = d.logs[len(d.logs)-logLines:]
+	}
+	return len(p), nil
+}
+
+// Run redraws the dashboard every second until ctx is done.
+func (d *Dashboard) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			d.draw(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dashboard) draw(now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, clearScreen)
+	fmt.Fprintf(&buf, "%v - %v\n\n", d.title, now.Format("15:04:05"))
+
+	depths := map[uint32]int{}
+	hasDepths := map[uint32]bool{}
+	for _, q := range d.queues {
+		for ssrc, depth := range q.QueueDepths() {
+			depths[ssrc] += depth
+			hasDepths[ssrc] = true
+		}
+	}
+
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FLOW\tDIRECTION\tBITRATE\tPACKETS\tLOST\tQUEUE")
+	if d.traffic != nil {
+		interval := now.Sub(d.lastDraw).Seconds()
+		for _, f := range d.traffic.Flows() {
+			key := flowKey{ssrc: f.SSRC, direction: f.Direction}
+			bitrate := "-"
+			if last, ok := d.lastBytes[key]; ok && interval > 0 {
+				bitrate = formatBitrate(float64(f.Bytes-last) * 8 / interval)
+			}
+			d.lastBytes[key] = f.Bytes
+			lost := "-"
+			if f.Direction == rtp.Received {
+				lost = fmt.Sprint(f.Lost)
+			}
+			queue := "-"
+			if hasDepths[f.SSRC] {
+				queue = fmt.Sprint(depths[f.SSRC])
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", f.SSRC, f.Direction, bitrate, f.Packets, lost, queue)
+		}
+	}
+	w.Flush()
+	d.lastDraw = now
+
+	if len(d.sources) > 0 {
+		fmt.Fprintln(&buf)
+		names := make([]string, 0, len(d.sources))
+		for name := range d.sources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CC\tTARGET\tPACING\tCWND\tRTT\tQUEUE DELAY\tLOSS")
+		for _, name := range names {
+			m := d.sources[name].Metrics()
+			fmt.Fprintf(
+				w, "%v\t%v\t%v\t%v\t%v\t%v\t%.2f%%\n",
+				name,
+				formatBitrate(float64(m.TargetBitrate)),
+				formatBitrate(float64(m.PacingRate)),
+				m.Cwnd,
+				m.RTT.Round(100*time.Microsecond),
+				m.QueueDelay.Round(100*time.Microsecond),
+				100*m.LossRate,
+			)
+		}
+		w.Flush()
+	}
+
+	if len(d.logs) > 0 {
+		fmt.Fprintln(&buf)
+		for _, line := range d.logs {
+			fmt.Fprintln(&buf, line)
+		}
+	}
+	// write at once to avoid flickering
+	_, _ = d.out.Write(buf.Bytes())
+}
+
+func formatBitrate(r float64) string {
+	switch {
+	case r >= 1e6:
+		return fmt.Sprintf("%.2f Mbit/s", r/1e6)
+	case r >= 1e3:
+		return fmt.Sprintf("%.1f kbit/s", r/1e3)
+	}
+	return fmt.Sprintf("%.0f bit/s", r)
+}
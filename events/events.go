@@ -0,0 +1,133 @@
+// Package events publishes typed events of senders and receivers to
+// subscribers, so that programs embedding rtp-over-quic can react to rate
+// changes, acknowledgments, losses and connection state without parsing log
+// files.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is one of RateChanged, PacketAcked, PacketLost, StreamReset,
+// ConnectionClosed, PipelineRestarted and Backpressure.
+type Event interface {
+	At() time.Time
+}
+
+// RateChanged is published when the congestion controller changes the
+// target bitrate.
+type RateChanged struct {
+	Time    time.Time
+	Bitrate int
+}
+
+// PacketAcked is published when a datagram carrying an RTP packet is
+// acknowledged. OneWayDelay is 0 if the transport does not report it.
+type PacketAcked struct {
+	Time           time.Time
+	SSRC           uint32
+	SequenceNumber uint16
+	Size           int
+	OneWayDelay    time.Duration
+}
+
+// PacketLost is published when a datagram carrying an RTP packet is
+// declared lost.
+type PacketLost struct {
+	Time           time.Time
+	SSRC           uint32
+	SequenceNumber uint16
+	Size           int
+}
+
+// StreamReset is published when the peer resets a stream carrying media.
+type StreamReset struct {
+	Time     time.Time
+	StreamID int64
+	Err      error
+}
+
+// ConnectionClosed is published when the connection to the peer is closed
+// or timed out.
+type ConnectionClosed struct {
+	Time       time.Time
+	RemoteAddr string
+	Err        error
+}
+
+// PipelineRestarted is published when a failed or stalled media pipeline is
+// torn down to be rebuilt. Pipeline names the source or sink, Attempt counts
+// the consecutive restarts starting at 1.
+type PipelineRestarted struct {
+	Time     time.Time
+	Pipeline string
+	Err      error
+	Attempt  int
+}
+
+// Backpressure is published when the transport drops a packet of Size bytes
+// because the pacer, the congestion controller or the flow control held it
+// back longer than the backpressure timeout. Transport is 'datagram' or
+// 'stream'.
+type Backpressure struct {
+	Time      time.Time
+	Transport string
+	Size      int
+}
+
+func (e RateChanged) At() time.Time       { return e.Time }
+func (e PacketAcked) At() time.Time       { return e.Time }
+func (e PacketLost) At() time.Time        { return e.Time }
+func (e StreamReset) At() time.Time       { return e.Time }
+func (e ConnectionClosed) At() time.Time  { return e.Time }
+func (e PipelineRestarted) At() time.Time { return e.Time }
+func (e Backpressure) At() time.Time      { return e.Time }
+
+// Bus passes published events to all subscribers. A nil Bus drops all
+// events, so that publishers don't have to check whether events are used.
+type Bus struct {
+	lock        sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Subscribe returns a channel receiving the events published after the call
+// and a function cancelling the subscription, which closes the channel. The
+// channel buffers size events, further events are dropped until the
+// subscriber catches up, so that slow subscribers never block the media.
+func (b *Bus) Subscribe(size int) (<-chan Event, func()) {
+	c := make(chan Event, size)
+	b.lock.Lock()
+	b.subscribers[c] = struct{}{}
+	b.lock.Unlock()
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subscribers, c)
+			b.lock.Unlock()
+			close(c)
+		})
+	}
+}
+
+// Publish passes e to all subscribers with free buffer space.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for c := range b.subscribers {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package events
+
+// ConnectionInfo describes a connection passed to Hooks.
+type ConnectionInfo struct {
+	// Transport is 'quic' or 'tcp'.
+	Transport  string
+	LocalAddr  string
+	RemoteAddr string
+	// ALPN is the negotiated application protocol, empty for TCP.
+	ALPN string
+	// Datagrams is set if both peers negotiated QUIC datagrams.
+	Datagrams bool
+}
+
+// FlowInfo describes a media or data flow passed to Hooks.
+type FlowInfo struct {
+	Connection ConnectionInfo
+	FlowID     uint64
+}
+
+// Hooks are called synchronously by the QUIC and TCP servers and clients on
+// connection and flow lifecycle events, so that programs embedding them can
+// do admission control and cleanup. Unlike events published on a Bus, the
+// hooks may block the transport and should return quickly. A nil Hooks and
+// nil functions are not called.
+type Hooks struct {
+	// OnConnect is called when a connection is established. If it returns
+	// an error, the connection is closed: servers reject the peer, clients
+	// fail to connect.
+	OnConnect func(ConnectionInfo) error
+	// OnDisconnect is called once a connection accepted by OnConnect is
+	// closed. err is the reason if the connection was closed by the peer or
+	// timed out.
+	OnDisconnect func(c ConnectionInfo, err error)
+	// OnStreamOpen is called when the first packet of a flow is received or
+	// a flow is opened for sending (QUIC only).
+	OnStreamOpen func(FlowInfo)
+}
+
+// Connect calls OnConnect if set.
+func (h *Hooks) Connect(c ConnectionInfo) error {
+	if h == nil || h.OnConnect == nil {
+		return nil
+	}
+	return h.OnConnect(c)
+}
+
+// Disconnect calls OnDisconnect if set.
+func (h *Hooks) Disconnect(c ConnectionInfo, err error) {
+	if h == nil || h.OnDisconnect == nil {
+		return
+	}
+	h.OnDisconnect(c, err)
+}
+
+// StreamOpen calls OnStreamOpen if set.
+func (h *Hooks) StreamOpen(f FlowInfo) {
+	if h == nil || h.OnStreamOpen == nil {
+		return
+	}
+	h.OnStreamOpen(f)
+}
@@ -0,0 +1,139 @@
+// Package experiment runs a matrix of sender and receiver pairs one after
+// another and records where the logs of each run are written to.
+package experiment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var errInvalidMatrix = errors.New("invalid experiment matrix")
+
+// Duration is a time.Duration which is read from and written to JSON as
+// string, e.g., "30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Matrix defines the runs of an experiment: one run per combination of
+// transport, congestion control algorithm, feedback mode and duration,
+// repeated Repetitions times.
+type Matrix struct {
+	Name string `json:"name"`
+	// Output is the directory the run directories and the index are
+	// written to.
+	Output string `json:"output"`
+	// Addr is the address the receiver listens on and the sender connects
+	// to.
+	Addr string `json:"addr"`
+
+	Transports  []string   `json:"transports"`
+	CC          []string   `json:"cc"`
+	Feedback    []string   `json:"feedback"`
+	Durations   []Duration `json:"durations"`
+	Repetitions int        `json:"repetitions"`
+
+	// SenderArgs and ReceiverArgs are passed to every sender and receiver
+	// in addition to the flags set by the run.
+	SenderArgs   []string `json:"sender_args"`
+	ReceiverArgs []string `json:"receiver_args"`
+}
+
+// ReadMatrix reads a matrix from a JSON file and sets the defaults of empty
+// fields.
+func ReadMatrix(file string) (*Matrix, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &Matrix{}
+	if err := json.Unmarshal(buf, m); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse %v: %v", errInvalidMatrix, file, err)
+	}
+	if len(m.Name) == 0 {
+		m.Name = "experiment"
+	}
+	if len(m.Output) == 0 {
+		m.Output = m.Name
+	}
+	if len(m.Addr) == 0 {
+		m.Addr = "localhost:4242"
+	}
+	if len(m.Transports) == 0 {
+		m.Transports = []string{"quic"}
+	}
+	if len(m.CC) == 0 {
+		m.CC = []string{"none"}
+	}
+	if len(m.Feedback) == 0 {
+		m.Feedback = []string{"none"}
+	}
+	if len(m.Durations) == 0 {
+		m.Durations = []Duration{Duration(30 * time.Second)}
+	}
+	if m.Repetitions == 0 {
+		m.Repetitions = 1
+	}
+	if m.Repetitions < 0 {
+		return nil, fmt.Errorf("%w: negative number of repetitions: %v", errInvalidMatrix, m.Repetitions)
+	}
+	for _, d := range m.Durations {
+		if d <= 0 {
+			return nil, fmt.Errorf("%w: duration must be positive, got %v", errInvalidMatrix, time.Duration(d))
+		}
+	}
+	return m, nil
+}
+
+// Run is one sender and receiver pair of an experiment.
+type Run struct {
+	// Name is the name of the directory of the run in Matrix.Output.
+	Name       string   `json:"name"`
+	Transport  string   `json:"transport"`
+	CC         string   `json:"cc"`
+	Feedback   string   `json:"feedback"`
+	Duration   Duration `json:"duration"`
+	Repetition int      `json:"repetition"`
+}
+
+// Runs returns the runs of m in the order they are run.
+func (m *Matrix) Runs() []Run {
+	runs := []Run{}
+	for _, t := range m.Transports {
+		for _, cc := range m.CC {
+			for _, f := range m.Feedback {
+				for _, d := range m.Durations {
+					for i := 1; i <= m.Repetitions; i++ {
+						runs = append(runs, Run{
+							Name:       fmt.Sprintf("%03d-%v-%v-%v-%v-%v", len(runs)+1, t, cc, f, time.Duration(d), i),
+							Transport:  t,
+							CC:         cc,
+							Feedback:   f,
+							Duration:   d,
+							Repetition: i,
+						})
+					}
+				}
+			}
+		}
+	}
+	return runs
+}
@@ -0,0 +1,231 @@
+package experiment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// startupDelay is the time the receiver gets to listen before the
+	// sender is started.
+	startupDelay = time.Second
+	// stopTimeout is the time a process gets to shut down gracefully after
+	// SIGINT before it is killed.
+	stopTimeout = 10 * time.Second
+)
+
+// Result is a finished run and the files it wrote, relative to the
+// directory of the run.
+type Result struct {
+	Run
+	Dir           string    `json:"dir"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	SenderError   string    `json:"sender_error,omitempty"`
+	ReceiverError string    `json:"receiver_error,omitempty"`
+	Files         []string  `json:"files"`
+}
+
+// Runner runs the sender and receiver of each run as child processes of
+// Binary, which is the rtp-over-quic executable.
+type Runner struct {
+	Binary string
+}
+
+// Execute runs all runs of m one after another and writes the index of the
+// results to index.json in m.Output after each run, so that the results of
+// finished runs are kept if the experiment is interrupted.
+func (r *Runner) Execute(ctx context.Context, m *Matrix) ([]Result, error) {
+	if err := os.MkdirAll(m.Output, 0o755); err != nil {
+		return nil, err
+	}
+	runs := m.Runs()
+	results := []Result{}
+	for i, run := range runs {
+		if ctx.Err() != nil {
+			break
+		}
+		log.Printf("experiment %v: starting run %v/%v: %v", m.Name, i+1, len(runs), run.Name)
+		res, err := r.run(ctx, m, run)
+		if err != nil {
+			return results, fmt.Errorf("run %v failed: %w", run.Name, err)
+		}
+		results = append(results, res)
+		if err := writeIndex(filepath.Join(m.Output, "index.json"), m, results); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func (r *Runner) run(ctx context.Context, m *Matrix, run Run) (Result, error) {
+	dir := filepath.Join(m.Output, run.Name)
+	res := Result{
+		Run: run,
+		Dir: dir,
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return res, err
+	}
+	receiver, err := r.start(dir, "receiver", receiverArgs(m, run, dir))
+	if err != nil {
+		return res, err
+	}
+	res.Start = time.Now()
+	select {
+	case <-time.After(startupDelay):
+	case <-ctx.Done():
+	}
+	sender, err := r.start(dir, "sender", senderArgs(m, run, dir))
+	if err != nil {
+		stop(receiver)
+		return res, err
+	}
+	select {
+	case <-time.After(time.Duration(run.Duration)):
+	case <-ctx.Done():
+	}
+	if err := stop(sender); err != nil {
+		res.SenderError = err.Error()
+	}
+	if err := stop(receiver); err != nil {
+		res.ReceiverError = err.Error()
+	}
+	res.End = time.Now()
+	res.Files, err = listFiles(dir)
+	return res, err
+}
+
+// process is a running child process and a channel receiving its exit
+// error.
+type process struct {
+	cmd  *exec.Cmd
+	done chan error
+	log  *os.File
+}
+
+// start runs the binary with args and writes its output to '<name>.log' in
+// dir.
+func (r *Runner) start(dir, name string, args []string) (*process, error) {
+	f, err := os.Create(filepath.Join(dir, name+".log"))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(f, "# %v %v\n", r.Binary, strings.Join(args, " "))
+	cmd := exec.Command(r.Binary, args...)
+	cmd.Stdout = f
+	cmd.Stderr = f
+	if err := cmd.Start(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to start %v: %w", name, err)
+	}
+	p := &process{
+		cmd:  cmd,
+		done: make(chan error, 1),
+		log:  f,
+	}
+	go func() {
+		p.done <- cmd.Wait()
+	}()
+	return p, nil
+}
+
+// stop interrupts p, so that it shuts down gracefully and writes its final
+// stats, and kills it if it did not exit after stopTimeout.
+func stop(p *process) error {
+	defer p.log.Close()
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		// The process exited already.
+		return <-p.done
+	}
+	select {
+	case err := <-p.done:
+		return err
+	case <-time.After(stopTimeout):
+		if err := p.cmd.Process.Kill(); err != nil {
+			log.Printf("failed to kill process %v: %v", p.cmd.Process.Pid, err)
+		}
+		<-p.done
+		return fmt.Errorf("killed after not stopping within %v", stopTimeout)
+	}
+}
+
+func receiverArgs(m *Matrix, run Run, dir string) []string {
+	args := []string{
+		"receive",
+		"--transport", run.Transport,
+		"--addr", m.Addr,
+		"--rtcp-feedback", run.Feedback,
+		"--stats", filepath.Join(dir, "receiver-stats.csv"),
+		"--rtp-dump", filepath.Join(dir, "receiver-rtp.log"),
+		"--rtcp-dump", filepath.Join(dir, "receiver-rtcp.log"),
+	}
+	if strings.HasPrefix(run.Transport, "quic") {
+		args = append(args, "--qlog", dir)
+	}
+	return append(args, m.ReceiverArgs...)
+}
+
+func senderArgs(m *Matrix, run Run, dir string) []string {
+	args := []string{
+		"send",
+		"--transport", run.Transport,
+		"--addr", m.Addr,
+		"--rtp-cc", run.CC,
+		"--stats", filepath.Join(dir, "sender-stats.csv"),
+		"--rtp-dump", filepath.Join(dir, "sender-rtp.log"),
+		"--rtcp-dump", filepath.Join(dir, "sender-rtcp.log"),
+	}
+	if run.CC != "none" {
+		args = append(args, "--cc-dump", filepath.Join(dir, "cc.log"))
+	}
+	if strings.HasPrefix(run.Transport, "quic") {
+		args = append(args, "--qlog", dir)
+	}
+	return append(args, m.SenderArgs...)
+}
+
+func listFiles(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, err
+}
+
+// index is the machine readable summary of an experiment.
+type index struct {
+	Name   string   `json:"name"`
+	Matrix *Matrix  `json:"matrix"`
+	Runs   []Result `json:"runs"`
+}
+
+func writeIndex(file string, m *Matrix, results []Result) error {
+	buf, err := json.MarshalIndent(index{
+		Name:   m.Name,
+		Matrix: m,
+		Runs:   results,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(buf, '\n'), 0o644)
+}
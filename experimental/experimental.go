@@ -0,0 +1,82 @@
+// Package experimental gates unstable code paths behind feature flags, so
+// that experimental features can land incrementally without changing the
+// default behavior. Features are enabled once at startup using Enable and
+// queried with Enabled.
+package experimental
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Feature is an experimental subsystem which is disabled by default.
+type Feature string
+
+// Only features which gate code are known, so that enabling a feature
+// without an implementation fails instead of silently changing nothing.
+const (
+	// MoQ enables the transport 'moq', which sends frames as Media over QUIC
+	// objects.
+	MoQ Feature = "moq"
+)
+
+// Features are all known experimental features.
+var Features = []Feature{MoQ}
+
+var errUnknownFeature = errors.New("unknown experimental feature")
+
+var (
+	lock    sync.RWMutex
+	enabled = map[Feature]bool{}
+)
+
+// Enable enables the features with the given names in addition to the
+// already enabled ones. It enables none of them if a name is unknown.
+func Enable(names ...string) error {
+	features := make([]Feature, 0, len(names))
+	for _, name := range names {
+		f, err := parse(name)
+		if err != nil {
+			return err
+		}
+		features = append(features, f)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, f := range features {
+		enabled[f] = true
+	}
+	return nil
+}
+
+func parse(name string) (Feature, error) {
+	name = strings.TrimSpace(name)
+	for _, f := range Features {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q, known features: %v", errUnknownFeature, name, Features)
+}
+
+// Enabled returns whether f was enabled.
+func Enabled(f Feature) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return enabled[f]
+}
+
+// Active returns the names of the enabled features in alphabetical order.
+func Active() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	names := make([]string, 0, len(enabled))
+	for f := range enabled {
+		names = append(names, string(f))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,171 @@
+// Package fse implements the Flow State Exchange (FSE) of RFC 8699 to couple
+// the congestion controllers of RTP flows sharing a bottleneck, e.g., flows
+// multiplexed on one QUIC connection.
+package fse
+
+import (
+	"sync"
+)
+
+// RateCallback is called with the rate in bits per second allocated to a
+// flow.
+type RateCallback func(rate int)
+
+// FSE shares the sum of the rates calculated by the congestion controllers of
+// all registered flows between the flows according to their priorities. It
+// implements the active FSE algorithm of RFC 8699, Section 5.2.
+type FSE struct {
+	lock  sync.Mutex
+	flows map[*Flow]struct{}
+
+	// sumRate is S_CR, the sum of the calculated rates of all flows.
+	sumRate float64
+}
+
+func New() *FSE {
+	return &FSE{
+		flows: map[*Flow]struct{}{},
+	}
+}
+
+// Flow is a flow registered at an FSE.
+type Flow struct {
+	fse *FSE
+
+	priority    float64
+	desiredRate float64
+	rate        float64
+	onRate      RateCallback
+}
+
+// Register adds a flow with the given priority and initial rate. The
+// priority is relative to the priorities of the other flows, a flow with
+// priority 2 gets twice the rate of a flow with priority 1. A desired rate of
+// 0 means the flow can use any rate. onRate is called whenever the rate
+// allocated to the flow changes, including updates of other flows.
+func (f *FSE) Register(priority float64, initialRate, desiredRate int, onRate RateCallback) *Flow {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if priority <= 0 {
+		priority = 1
+	}
+	flow := &Flow{
+		fse:         f,
+		priority:    priority,
+		desiredRate: float64(desiredRate),
+		rate:        float64(initialRate),
+		onRate:      onRate,
+	}
+	f.flows[flow] = struct{}{}
+	f.sumRate += flow.rate
+	return flow
+}
+
+// Update passes a new rate calculated by the congestion controller of the
+// flow to the FSE and returns the rate allocated to the flow.
+func (fl *Flow) Update(calculatedRate int) int {
+	f := fl.fse
+	f.lock.Lock()
+	updates := f.update(fl, float64(calculatedRate))
+	rate := int(fl.rate)
+	f.lock.Unlock()
+
+	for _, u := range updates {
+		if u.flow != fl && u.flow.onRate != nil {
+			u.flow.onRate(u.rate)
+		}
+	}
+	return rate
+}
+
+// SetDesiredRate updates the maximum rate the flow can use. The new value
+// takes effect with the next update.
+func (fl *Flow) SetDesiredRate(rate int) {
+	fl.fse.lock.Lock()
+	defer fl.fse.lock.Unlock()
+	fl.desiredRate = float64(rate)
+}
+
+// Leave removes the flow from the FSE. Its share is distributed among the
+// remaining flows with their next update.
+func (fl *Flow) Leave() {
+	f := fl.fse
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.flows[fl]; !ok {
+		return
+	}
+	delete(f.flows, fl)
+	f.sumRate -= fl.rate
+	if len(f.flows) == 0 {
+		f.sumRate = 0
+	}
+}
+
+type rateUpdate struct {
+	flow *Flow
+	rate int
+}
+
+// update implements steps (a) to (d) of the active FSE algorithm. f.lock must
+// be held, the returned updates have to be passed to the flows without
+// holding the lock.
+func (f *FSE) update(fl *Flow, calculatedRate float64) []rateUpdate {
+	if _, ok := f.flows[fl]; !ok {
+		fl.rate = calculatedRate
+		return nil
+	}
+
+	// (a) The aggregate changes by the difference between the new calculated
+	// rate and the rate the flow was allocated before.
+	f.sumRate += calculatedRate - fl.rate
+	if f.sumRate < 0 {
+		f.sumRate = 0
+	}
+
+	// (b) Assign the aggregate by priority, flows which can't use their share
+	// leave the remainder to the others.
+	unlimited := make(map[*Flow]struct{}, len(f.flows))
+	for flow := range f.flows {
+		unlimited[flow] = struct{}{}
+	}
+	leftover := f.sumRate
+	for len(unlimited) > 0 {
+		sumPriority := 0.0
+		for flow := range unlimited {
+			sumPriority += flow.priority
+		}
+		available := leftover
+		capped := false
+		for flow := range unlimited {
+			share := available * flow.priority / sumPriority
+			if flow.desiredRate > 0 && share >= flow.desiredRate {
+				flow.rate = flow.desiredRate
+				leftover -= flow.desiredRate
+				delete(unlimited, flow)
+				capped = true
+			}
+		}
+		if capped {
+			continue
+		}
+		// (c) Distribute the leftover of capped flows among the remaining
+		// flows.
+		for flow := range unlimited {
+			flow.rate = leftover * flow.priority / sumPriority
+		}
+		break
+	}
+
+	// (d) Send the new rates to all flows.
+	updates := make([]rateUpdate, 0, len(f.flows))
+	for flow := range f.flows {
+		updates = append(updates, rateUpdate{
+			flow: flow,
+			rate: int(flow.rate),
+		})
+	}
+	return updates
+}
@@ -0,0 +1,61 @@
+// Package gateway connects WebRTC peers to RoQ: WHIP clients publish media
+// into a receiver and WHEP clients play the media a receiver gets. ICE and
+// DTLS-SRTP end at the gateway, which passes plain RTP to and from the RoQ
+// pipelines.
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+)
+
+var errUnknownCodec = errors.New("unknown codec")
+
+// Codec returns the WebRTC codec of a RoQ codec name sent as payload type pt.
+// 'auto' uses H264.
+func Codec(name string, pt uint8) (webrtc.RTPCodecParameters, error) {
+	c := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{ClockRate: 90000},
+		PayloadType:        webrtc.PayloadType(pt),
+	}
+	switch strings.ToLower(name) {
+	case "h264", "auto":
+		c.MimeType = webrtc.MimeTypeH264
+		c.SDPFmtpLine = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"
+	case "vp8":
+		c.MimeType = webrtc.MimeTypeVP8
+	case "vp9":
+		c.MimeType = webrtc.MimeTypeVP9
+		c.SDPFmtpLine = "profile-id=0"
+	case "av1":
+		c.MimeType = webrtc.MimeTypeAV1
+	default:
+		return c, fmt.Errorf("%w: %v", errUnknownCodec, name)
+	}
+	return c, nil
+}
+
+// newAPI returns a WebRTC API which negotiates codec only, with the default
+// NACK, RTCP report and TWCC interceptors.
+func newAPI(codec webrtc.RTPCodecParameters, settings webrtc.SettingEngine) (*webrtc.API, error) {
+	m := &webrtc.MediaEngine{}
+	codec.RTCPFeedback = []webrtc.RTCPFeedback{
+		{Type: "nack"}, {Type: "nack", Parameter: "pli"}, {Type: "transport-cc"},
+	}
+	if err := m.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+		return nil, err
+	}
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, ir); err != nil {
+		return nil, err
+	}
+	return webrtc.NewAPI(
+		webrtc.WithMediaEngine(m),
+		webrtc.WithInterceptorRegistry(ir),
+		webrtc.WithSettingEngine(settings),
+	), nil
+}
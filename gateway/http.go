@@ -0,0 +1,285 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// ContentType is the media type of WHIP and WHEP offers and answers.
+const ContentType = "application/sdp"
+
+const maxDescriptionSize = 64 * 1024
+
+var (
+	errNoPublishing   = errors.New("publishing not supported")
+	errNoViewing      = errors.New("viewing not supported")
+	errUnknownSession = errors.New("unknown session")
+)
+
+// Config configures a Server.
+type Config struct {
+	// Codec and PayloadType are the RoQ codec and payload type, e.g., 'h264'
+	// and 96. Publishers and viewers negotiate this codec only.
+	Codec       string
+	PayloadType uint8
+	// ICEServers are the STUN and TURN URLs passed to the peers.
+	ICEServers []string
+	// Publish is called with each new WHIP publisher. Publishing is
+	// rejected if nil.
+	Publish func(*Session) error
+	// Hub is the source of the media sent to WHEP viewers. Viewing is
+	// rejected if nil.
+	Hub *Hub
+}
+
+// Server serves WHIP publishers at '/whip' and WHEP viewers at '/whep'.
+// Sessions are ended by DELETE on the URL returned in the Location header.
+// ICE candidates are gathered before answering, trickle ICE is not
+// supported.
+type Server struct {
+	config Config
+	api    *webrtc.API
+
+	lock     sync.Mutex
+	sessions map[string]*webrtc.PeerConnection
+}
+
+// NewServer creates a server for c.
+func NewServer(c Config) (*Server, error) {
+	codec, err := Codec(c.Codec, c.PayloadType)
+	if err != nil {
+		return nil, err
+	}
+	api, err := newAPI(codec, webrtc.SettingEngine{})
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		config:   c,
+		api:      api,
+		sessions: map[string]*webrtc.PeerConnection{},
+	}, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/whip" && r.Method == http.MethodPost:
+		s.answer(w, r, s.publish)
+	case r.URL.Path == "/whep" && r.Method == http.MethodPost:
+		s.answer(w, r, s.view)
+	case strings.HasPrefix(r.URL.Path, "/session/") && r.Method == http.MethodDelete:
+		if err := s.end(strings.TrimPrefix(r.URL.Path, "/session/")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodOptions:
+		w.Header().Set("Allow", "OPTIONS, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Serve serves s on addr until ctx is done.
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+		s.closeAll()
+	}()
+	log.Printf("gateway: serving WHIP and WHEP on %v", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// answer creates a peer connection for the offer in the body of r, sets it
+// up using setup and responds with the answer.
+func (s *Server) answer(w http.ResponseWriter, r *http.Request, setup func(*webrtc.PeerConnection) (func(), error)) {
+	offer, err := io.ReadAll(io.LimitReader(r.Body, maxDescriptionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var conf webrtc.Configuration
+	if len(s.config.ICEServers) > 0 {
+		conf.ICEServers = []webrtc.ICEServer{{URLs: s.config.ICEServers}}
+	}
+	pc, err := s.api.NewPeerConnection(conf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cleanup, err := setup(pc)
+	if err != nil {
+		pc.Close()
+		log.Printf("gateway: rejecting %v from %v: %v", r.URL.Path, r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	id := sessionID()
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("gateway: session %v %v", id, state)
+		switch state {
+		case webrtc.PeerConnectionStateFailed:
+			pc.Close()
+		case webrtc.PeerConnectionStateClosed:
+			s.lock.Lock()
+			delete(s.sessions, id)
+			s.lock.Unlock()
+			cleanup()
+		}
+	})
+	desc, err := s.negotiate(pc, string(offer))
+	if err != nil {
+		pc.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	s.sessions[id] = pc
+	s.lock.Unlock()
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Location", "/session/"+id)
+	w.WriteHeader(http.StatusCreated)
+	if _, err := io.WriteString(w, desc); err != nil {
+		log.Printf("gateway: failed to write answer: %v", err)
+	}
+}
+
+func (s *Server) negotiate(pc *webrtc.PeerConnection, offer string) (string, error) {
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	}); err != nil {
+		return "", err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	<-gathered
+	return pc.LocalDescription().SDP, nil
+}
+
+// publish passes the video track of a WHIP publisher to Config.Publish.
+func (s *Server) publish(pc *webrtc.PeerConnection) (func(), error) {
+	if s.config.Publish == nil {
+		return nil, errNoPublishing
+	}
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		return nil, err
+	}
+	session := &Session{pc: pc, pt: s.config.PayloadType}
+	pc.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if t.Kind() != webrtc.RTPCodecTypeVideo {
+			return
+		}
+		log.Printf("gateway: publishing track %v (%v, SSRC %v)", t.ID(), t.Codec().MimeType, t.SSRC())
+		if err := s.config.Publish(session); err != nil {
+			log.Printf("gateway: failed to set up pipeline of publisher: %v", err)
+			pc.Close()
+			return
+		}
+		go session.readTrack(t)
+	})
+	return session.closed, nil
+}
+
+// view adds a track fed by Config.Hub for a WHEP viewer.
+func (s *Server) view(pc *webrtc.PeerConnection) (func(), error) {
+	if s.config.Hub == nil {
+		return nil, errNoViewing
+	}
+	codec, err := Codec(s.config.Codec, s.config.PayloadType)
+	if err != nil {
+		return nil, err
+	}
+	track, err := webrtc.NewTrackLocalStaticRTP(codec.RTPCodecCapability, "video", "roq")
+	if err != nil {
+		return nil, err
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		return nil, err
+	}
+	// Read RTCP so that the interceptors process it and pass keyframe
+	// requests to the sources.
+	go func() {
+		for {
+			pkts, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, pkt := range pkts {
+				switch pkt.(type) {
+				case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+					s.config.Hub.requestKeyframe()
+				}
+			}
+		}
+	}()
+	s.config.Hub.add(track)
+	return func() {
+		s.config.Hub.remove(track)
+	}, nil
+}
+
+func (s *Server) end(id string) error {
+	s.lock.Lock()
+	pc, ok := s.sessions[id]
+	s.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %v", errUnknownSession, id)
+	}
+	return pc.Close()
+}
+
+func (s *Server) closeAll() {
+	s.lock.Lock()
+	pcs := make([]*webrtc.PeerConnection, 0, len(s.sessions))
+	for _, pc := range s.sessions {
+		pcs = append(pcs, pc)
+	}
+	s.lock.Unlock()
+	for _, pc := range pcs {
+		if err := pc.Close(); err != nil {
+			log.Printf("gateway: failed to close session: %v", err)
+		}
+	}
+}
+
+func sessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
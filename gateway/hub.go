@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// sourceTimeout is the time after which a silent source is replaced by the
+// next one sending.
+const sourceTimeout = time.Second
+
+// timestampGap is added to the last timestamp when switching sources, one
+// frame at 30 fps and 90 kHz.
+const timestampGap = 3000
+
+// Hub forwards RTP packets to the tracks of the connected WHEP viewers. It
+// forwards one source at a time, the first one sending, and rewrites sequence
+// numbers and timestamps so that viewers see one continuous stream when the
+// source changes. Keyframe requests of viewers are sent to the sources as
+// PLI.
+type Hub struct {
+	lock   sync.Mutex
+	tracks map[*webrtc.TrackLocalStaticRTP]struct{}
+
+	upstream map[int]interceptor.RTCPWriter
+	nextID   int
+
+	ssrc       uint32
+	lastPacket time.Time
+	started    bool
+	seqOffset  uint16
+	tsOffset   uint32
+	lastSeq    uint16
+	lastTS     uint32
+}
+
+// NewHub returns a Hub without viewers.
+func NewHub() *Hub {
+	return &Hub{
+		tracks:   map[*webrtc.TrackLocalStaticRTP]struct{}{},
+		upstream: map[int]interceptor.RTCPWriter{},
+	}
+}
+
+func (h *Hub) add(t *webrtc.TrackLocalStaticRTP) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.tracks[t] = struct{}{}
+}
+
+func (h *Hub) remove(t *webrtc.TrackLocalStaticRTP) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.tracks, t)
+}
+
+// AddUpstream adds w to the writers keyframe requests are sent to. The
+// returned function removes it again.
+func (h *Hub) AddUpstream(w interceptor.RTCPWriter) func() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	id := h.nextID
+	h.nextID++
+	h.upstream[id] = w
+	return func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		delete(h.upstream, id)
+	}
+}
+
+// Viewers returns the number of connected viewers.
+func (h *Hub) Viewers() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.tracks)
+}
+
+// Write forwards the RTP packet pkt to all viewers. The SSRC and payload type
+// are rewritten to the ones negotiated with each viewer.
+func (h *Hub) Write(pkt []byte) (int, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.tracks) == 0 {
+		return len(pkt), nil
+	}
+	p := &rtp.Packet{}
+	if err := p.Unmarshal(pkt); err != nil {
+		return 0, err
+	}
+	if !h.rewrite(p, time.Now()) {
+		return len(pkt), nil
+	}
+	for t := range h.tracks {
+		// WriteRTP modifies the header, so every track gets a copy.
+		c := *p
+		if err := t.WriteRTP(&c); err != nil {
+			log.Printf("gateway: failed to forward packet to viewer: %v", err)
+		}
+	}
+	return len(pkt), nil
+}
+
+// rewrite maps the sequence number and timestamp of p to the outgoing
+// stream. It returns false if p is not from the forwarded source.
+func (h *Hub) rewrite(p *rtp.Packet, now time.Time) bool {
+	if p.SSRC != h.ssrc {
+		if h.started && now.Sub(h.lastPacket) < sourceTimeout {
+			return false
+		}
+		if h.started {
+			log.Printf("gateway: switching source from SSRC %v to %v", h.ssrc, p.SSRC)
+			h.seqOffset = h.lastSeq + 1 - p.SequenceNumber
+			h.tsOffset = h.lastTS + timestampGap - p.Timestamp
+		}
+		h.ssrc = p.SSRC
+		h.started = true
+	}
+	h.lastPacket = now
+	p.SequenceNumber += h.seqOffset
+	p.Timestamp += h.tsOffset
+	h.lastSeq = p.SequenceNumber
+	h.lastTS = p.Timestamp
+	return true
+}
+
+// requestKeyframe sends a PLI for the forwarded source upstream.
+func (h *Hub) requestKeyframe() {
+	h.lock.Lock()
+	pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: h.ssrc}}
+	ws := make([]interceptor.RTCPWriter, 0, len(h.upstream))
+	for _, w := range h.upstream {
+		ws = append(ws, w)
+	}
+	h.lock.Unlock()
+	for _, w := range ws {
+		if _, err := w.Write(pli, interceptor.Attributes{}); err != nil {
+			log.Printf("gateway: failed to forward keyframe request: %v", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"log"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Session is a WHIP publisher. Like the handlers of the RoQ transports, it
+// passes the RTP packets it receives to the reader set by SetRTPReader and
+// sends RTCP to the publisher.
+type Session struct {
+	pc *webrtc.PeerConnection
+	pt uint8
+
+	lock    sync.Mutex
+	reader  interceptor.RTPReader
+	onClose func()
+	once    sync.Once
+}
+
+// SetRTPReader sets the reader the received packets are passed to.
+func (s *Session) SetRTPReader(r interceptor.RTPReader) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reader = r
+}
+
+// WriteRTCP sends pkts to the publisher.
+func (s *Session) WriteRTCP(pkts []rtcp.Packet, _ interceptor.Attributes) (int, error) {
+	if err := s.pc.WriteRTCP(pkts); err != nil {
+		return 0, err
+	}
+	return len(pkts), nil
+}
+
+// OnClose sets f to be called once the peer connection closed.
+func (s *Session) OnClose(f func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onClose = f
+}
+
+// Close closes the peer connection.
+func (s *Session) Close(reason string) error {
+	log.Printf("gateway: closing WHIP session: %v", reason)
+	return s.pc.Close()
+}
+
+func (s *Session) closed() {
+	s.once.Do(func() {
+		s.lock.Lock()
+		f := s.onClose
+		s.lock.Unlock()
+		if f != nil {
+			f()
+		}
+	})
+}
+
+// readTrack passes the packets of t to the reader. Payload types are
+// rewritten to the one the RoQ pipeline expects, since the publisher may use
+// another one for the same codec.
+func (s *Session) readTrack(t *webrtc.TrackRemote) {
+	for {
+		// The pipeline may hold on to packets, e.g., in the jitter buffer.
+		buf := make([]byte, 1500)
+		n, a, err := t.Read(buf)
+		if err != nil {
+			return
+		}
+		if n < 12 {
+			continue
+		}
+		buf[1] = buf[1]&0x80 | s.pt&0x7f
+		s.lock.Lock()
+		r := s.reader
+		s.lock.Unlock()
+		if r == nil {
+			continue
+		}
+		if a == nil {
+			a = interceptor.Attributes{}
+		}
+		if _, _, err := r.Read(buf[:n], a); err != nil {
+			log.Printf("gateway: failed to process packet of WHIP session: %v", err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+#include "gst.h"
+#include <gst/app/gstappsrc.h>
+
+GMainLoop* create_mainloop() {
+    return g_main_loop_new(NULL, FALSE);
+}
+
+void start_mainloop(GMainLoop* main_loop) {
+    g_main_loop_run(main_loop);
+}
+
+void stop_mainloop(GMainLoop* main_loop) {
+    g_main_loop_quit(main_loop);
+}
+
+static long long clock_time(GstClockTime t) {
+    if (!GST_CLOCK_TIME_IS_VALID(t)) {
+        return -1;
+    }
+    return (long long) t;
+}
+
+static GstFlowReturn new_sample_handler(GstElement* object, gpointer user_data) {
+    GstSample* sample = NULL;
+    GstBuffer* buffer = NULL;
+    gpointer copy = NULL;
+    gsize copy_size = 0;
+    PipelineUserData* s = (PipelineUserData*) user_data;
+
+    g_signal_emit_by_name(object, "pull-sample", &sample);
+
+    if (sample) {
+        buffer = gst_sample_get_buffer(sample);
+        if (buffer) {
+            int key_frame = !GST_BUFFER_FLAG_IS_SET(buffer, GST_BUFFER_FLAG_DELTA_UNIT);
+            gst_buffer_extract_dup(buffer, 0, gst_buffer_get_size(buffer), &copy, &copy_size);
+            goHandlePipelineBuffer(
+                copy,
+                copy_size,
+                clock_time(GST_BUFFER_PTS(buffer)),
+                clock_time(GST_BUFFER_DTS(buffer)),
+                clock_time(GST_BUFFER_DURATION(buffer)),
+                key_frame,
+                s->pipelineId
+            );
+        }
+        gst_sample_unref(sample);
+    }
+    return GST_FLOW_OK;
+}
+
+static gboolean bus_call(GstBus* bus, GstMessage* msg, gpointer user_data) {
+    PipelineUserData* s = (PipelineUserData*) user_data;
+
+    switch (GST_MESSAGE_TYPE(msg)) {
+    case GST_MESSAGE_EOS: {
+        goHandleBusCall(s->pipelineId, 0, NULL);
+        break;
+    }
+
+    case GST_MESSAGE_ERROR: {
+        gchar* debug;
+        GError* error;
+
+        gst_message_parse_error(msg, &error, &debug);
+        goHandleBusCall(s->pipelineId, 1, error->message);
+        g_free(debug);
+        g_error_free(error);
+        break;
+    }
+
+    default:
+        break;
+    }
+    return TRUE;
+}
+
+GstElement* create_pipeline(char* pipelineStr, char** errorMsg) {
+    GError* error = NULL;
+    GstElement* pipeline = NULL;
+
+    gst_init(NULL, NULL);
+    pipeline = gst_parse_launch(pipelineStr, &error);
+    if (error != NULL) {
+        *errorMsg = g_strdup(error->message);
+        g_error_free(error);
+    }
+    return pipeline;
+}
+
+static void free_user_data(gpointer user_data, GClosure* closure) {
+    g_free(user_data);
+}
+
+void start_pipeline(GstElement* pipeline, int pipelineId) {
+    PipelineUserData* s = g_new(PipelineUserData, 1);
+    s->pipelineId = pipelineId;
+
+    // the watch is removed and s freed in destroy_pipeline
+    GstBus* bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
+    gst_bus_add_watch_full(bus, G_PRIORITY_DEFAULT, bus_call, s, g_free);
+    gst_object_unref(bus);
+
+    gst_element_set_state(pipeline, GST_STATE_PLAYING);
+}
+
+int link_appsink(GstElement* pipeline, int pipelineId) {
+    GstElement* appsink = gst_bin_get_by_name(GST_BIN(pipeline), "appsink");
+    if (appsink == NULL) {
+        return -1;
+    }
+    PipelineUserData* s = g_new(PipelineUserData, 1);
+    s->pipelineId = pipelineId;
+
+    // s is freed with the appsink when the pipeline is destroyed
+    g_object_set(appsink, "emit-signals", TRUE, NULL);
+    g_signal_connect_data(appsink, "new-sample", G_CALLBACK(new_sample_handler), s, free_user_data, 0);
+    gst_object_unref(appsink);
+    return 0;
+}
+
+int push_buffer(GstElement* pipeline, void* buffer, int len, long long pts, long long dts, long long duration, int keyFrame) {
+    GstElement* src = gst_bin_get_by_name(GST_BIN(pipeline), "src");
+    if (src == NULL) {
+        return -1;
+    }
+    GstBuffer* b = gst_buffer_new_allocate(NULL, len, NULL);
+    gst_buffer_fill(b, 0, buffer, len);
+    GST_BUFFER_PTS(b) = pts < 0 ? GST_CLOCK_TIME_NONE : (GstClockTime) pts;
+    GST_BUFFER_DTS(b) = dts < 0 ? GST_CLOCK_TIME_NONE : (GstClockTime) dts;
+    GST_BUFFER_DURATION(b) = duration < 0 ? GST_CLOCK_TIME_NONE : (GstClockTime) duration;
+    if (!keyFrame) {
+        GST_BUFFER_FLAG_SET(b, GST_BUFFER_FLAG_DELTA_UNIT);
+    }
+    GstFlowReturn ret = gst_app_src_push_buffer(GST_APP_SRC(src), b);
+    gst_object_unref(src);
+    return ret == GST_FLOW_OK ? 0 : -1;
+}
+
+void stop_pipeline(GstElement* pipeline) {
+    gst_element_send_event(pipeline, gst_event_new_eos());
+}
+
+void destroy_pipeline(GstElement* pipeline) {
+    GstBus* bus = gst_element_get_bus(pipeline);
+    gst_bus_remove_watch(bus);
+    gst_object_unref(bus);
+
+    gst_element_set_state(pipeline, GST_STATE_NULL);
+    gst_object_unref(pipeline);
+}
+
+unsigned int get_property_uint(GstElement* pipeline, char* name, char* prop) {
+    GstElement* element = gst_bin_get_by_name(GST_BIN(pipeline), name);
+    unsigned int value = 0;
+
+    if (element) {
+        g_object_get(element, prop, &value, NULL);
+        gst_object_unref(element);
+    }
+    return value;
+}
+
+void set_property_uint(GstElement* pipeline, char* name, char* prop, unsigned int value) {
+    GstElement* element = gst_bin_get_by_name(GST_BIN(pipeline), name);
+
+    if (element) {
+        g_object_set(element, prop, value, NULL);
+        gst_object_unref(element);
+    }
+}
+
+int set_caps(GstElement* pipeline, char* name, char* capsStr) {
+    GstElement* element = gst_bin_get_by_name(GST_BIN(pipeline), name);
+    if (!element) {
+        return -1;
+    }
+    GstCaps* caps = gst_caps_from_string(capsStr);
+    if (!caps) {
+        gst_object_unref(element);
+        return -1;
+    }
+    g_object_set(element, "caps", caps, NULL);
+    gst_caps_unref(caps);
+    gst_object_unref(element);
+    return 0;
+}
+
+int force_key_unit(GstElement* pipeline) {
+    // sent to the sinks of the pipeline, the event travels upstream to the
+    // encoder
+    GstEvent* event = gst_video_event_new_upstream_force_key_unit(GST_CLOCK_TIME_NONE, TRUE, 0);
+    return gst_element_send_event(pipeline, event) ? 0 : -1;
+}
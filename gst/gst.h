@@ -0,0 +1,31 @@
+#ifndef GST_H
+#define GST_H
+
+#include <gst/gst.h>
+#include <gst/video/video.h>
+
+typedef struct PipelineUserData {
+    int pipelineId;
+} PipelineUserData;
+
+extern void goHandlePipelineBuffer(void* buffer, int bufferLen, long long pts, long long dts, long long duration, int keyFrame, int pipelineId);
+extern void goHandleBusCall(int pipelineId, int signal, char* message);
+
+GMainLoop* create_mainloop();
+void start_mainloop(GMainLoop* main_loop);
+void stop_mainloop(GMainLoop* main_loop);
+
+GstElement* create_pipeline(char* pipelineStr, char** errorMsg);
+void start_pipeline(GstElement* pipeline, int pipelineId);
+void stop_pipeline(GstElement* pipeline);
+void destroy_pipeline(GstElement* pipeline);
+
+int link_appsink(GstElement* pipeline, int pipelineId);
+int push_buffer(GstElement* pipeline, void* buffer, int len, long long pts, long long dts, long long duration, int keyFrame);
+
+unsigned int get_property_uint(GstElement* pipeline, char* name, char* prop);
+void set_property_uint(GstElement* pipeline, char* name, char* prop, unsigned int value);
+int set_caps(GstElement* pipeline, char* name, char* capsStr);
+int force_key_unit(GstElement* pipeline);
+
+#endif /* #ifndef GST_H */
@@ -0,0 +1,300 @@
+// Package gst bridges Gstreamer pipelines and Go via appsrc and appsink
+// elements. In contrast to opaque byte writes, buffers keep their timestamps,
+// duration and key frame flag on both sides of the bridge.
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0 gstreamer-app-1.0 gstreamer-video-1.0
+
+#include <stdlib.h>
+#include "gst.h"
+
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/Willi-42/rtp-over-quic/buffers"
+)
+
+// NoTimestamp marks an unset timestamp or duration of a Frame.
+const NoTimestamp time.Duration = -1
+
+var (
+	errPipeline = errors.New("gstreamer pipeline error")
+	errNoAppSrc = errors.New("pipeline has no appsrc named 'src'")
+)
+
+// Frame is a buffer passed between Go and a pipeline with its metadata.
+type Frame struct {
+	Bytes []byte
+
+	// PTS and DTS are the presentation and decoding timestamps in running
+	// time of the pipeline or NoTimestamp.
+	PTS time.Duration
+	DTS time.Duration
+
+	// Duration of the frame or NoTimestamp.
+	Duration time.Duration
+
+	// KeyFrame is false if the buffer can't be decoded independently of
+	// previous buffers.
+	KeyFrame bool
+
+	// buffer holds Bytes of frames read from the appsink.
+	buffer *buffers.Buffer
+}
+
+// Release returns the memory of a frame passed to a FrameHandler to the
+// buffer pool once Bytes is no longer used. Handlers which keep Bytes don't
+// call it.
+func (f Frame) Release() {
+	f.buffer.Release()
+}
+
+type FrameHandler func(Frame)
+type EOSHandler func()
+type ErrorHandler func(error)
+
+var (
+	pipelines     = map[int]*Pipeline{}
+	pipelinesLock sync.Mutex
+	nextID        int
+)
+
+// Pipeline is a Gstreamer pipeline created from a launch string. Frames
+// written to the pipeline are pushed to the appsrc element named "src",
+// frames arriving at the appsink element named "appsink" are passed to the
+// FrameHandler.
+type Pipeline struct {
+	launch     string
+	id         int
+	gstElement *C.GstElement
+	gMainLoop  *C.GMainLoop
+	closeOnce  sync.Once
+	closed     chan struct{}
+
+	handlerLock sync.RWMutex
+	frameCB     FrameHandler
+	eosCB       EOSHandler
+	errCB       ErrorHandler
+}
+
+func NewPipeline(launch string) (*Pipeline, error) {
+	launchStrC := C.CString(launch)
+	defer C.free(unsafe.Pointer(launchStrC))
+
+	var errMsg *C.char
+	element := C.create_pipeline(launchStrC, &errMsg)
+	if errMsg != nil {
+		defer C.free(unsafe.Pointer(errMsg))
+		if element != nil {
+			C.destroy_pipeline(element)
+		}
+		return nil, fmt.Errorf("%w: failed to parse pipeline '%v': %v", errPipeline, launch, C.GoString(errMsg))
+	}
+	if element == nil {
+		return nil, fmt.Errorf("%w: failed to parse pipeline '%v'", errPipeline, launch)
+	}
+
+	pipelinesLock.Lock()
+	defer pipelinesLock.Unlock()
+
+	p := &Pipeline{
+		launch:     launch,
+		id:         nextID,
+		gstElement: element,
+		gMainLoop:  C.create_mainloop(),
+		closed:     make(chan struct{}),
+		frameCB:    func(Frame) {},
+		eosCB:      func() {},
+		errCB:      func(error) {},
+	}
+	nextID++
+	pipelines[p.id] = p
+	if strings.Contains(launch, "appsink") {
+		if C.link_appsink(p.gstElement, C.int(p.id)) != 0 {
+			log.Printf("pipeline contains appsink but no element named 'appsink', buffers will not be passed to Go")
+		}
+	}
+	return p, nil
+}
+
+func (p *Pipeline) SetFrameHandler(h FrameHandler) {
+	p.handlerLock.Lock()
+	defer p.handlerLock.Unlock()
+	p.frameCB = h
+}
+
+func (p *Pipeline) SetEOSHandler(h EOSHandler) {
+	p.handlerLock.Lock()
+	defer p.handlerLock.Unlock()
+	p.eosCB = h
+}
+
+func (p *Pipeline) SetErrorHandler(h ErrorHandler) {
+	p.handlerLock.Lock()
+	defer p.handlerLock.Unlock()
+	p.errCB = h
+}
+
+// Write pushes buf to the appsrc without timestamps. Use appsrc's
+// do-timestamp property to stamp buffers on arrival.
+func (p *Pipeline) Write(buf []byte) (int, error) {
+	if err := p.WriteFrame(Frame{
+		Bytes:    buf,
+		PTS:      NoTimestamp,
+		DTS:      NoTimestamp,
+		Duration: NoTimestamp,
+		KeyFrame: true,
+	}); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// WriteFrame pushes f including its metadata to the appsrc.
+func (p *Pipeline) WriteFrame(f Frame) error {
+	if len(f.Bytes) == 0 {
+		return nil
+	}
+	keyFrame := 0
+	if f.KeyFrame {
+		keyFrame = 1
+	}
+	// push_buffer copies the bytes into a Gstreamer buffer and doesn't keep
+	// the pointer
+	b := unsafe.Pointer(&f.Bytes[0])
+	if C.push_buffer(p.gstElement, b, C.int(len(f.Bytes)), C.longlong(f.PTS), C.longlong(f.DTS), C.longlong(f.Duration), C.int(keyFrame)) != 0 {
+		select {
+		case <-p.closed:
+			return nil
+		default:
+		}
+		return errNoAppSrc
+	}
+	return nil
+}
+
+func (p *Pipeline) String() string {
+	return p.launch
+}
+
+func (p *Pipeline) Start() {
+	go C.start_mainloop(p.gMainLoop)
+	C.start_pipeline(p.gstElement, C.int(p.id))
+}
+
+func (p *Pipeline) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		C.stop_pipeline(p.gstElement)
+		C.destroy_pipeline(p.gstElement)
+		C.stop_mainloop(p.gMainLoop)
+
+		pipelinesLock.Lock()
+		delete(pipelines, p.id)
+		pipelinesLock.Unlock()
+	})
+	return nil
+}
+
+func (p *Pipeline) SetPropertyUint(name string, prop string, value uint) {
+	cName := C.CString(name)
+	cProp := C.CString(prop)
+	defer C.free(unsafe.Pointer(cName))
+	defer C.free(unsafe.Pointer(cProp))
+
+	C.set_property_uint(p.gstElement, cName, cProp, C.uint(value))
+}
+
+func (p *Pipeline) GetPropertyUint(name string, prop string) uint {
+	cName := C.CString(name)
+	cProp := C.CString(prop)
+	defer C.free(unsafe.Pointer(cName))
+	defer C.free(unsafe.Pointer(cProp))
+
+	return uint(C.get_property_uint(p.gstElement, cName, cProp))
+}
+
+// SetCaps sets the caps of the capsfilter name, which renegotiates the
+// format of the elements around it.
+func (p *Pipeline) SetCaps(name string, caps string) error {
+	cName := C.CString(name)
+	cCaps := C.CString(caps)
+	defer C.free(unsafe.Pointer(cName))
+	defer C.free(unsafe.Pointer(cCaps))
+
+	if C.set_caps(p.gstElement, cName, cCaps) != 0 {
+		return fmt.Errorf("failed to set caps %v of %v", caps, name)
+	}
+	return nil
+}
+
+// ForceKeyUnit sends an upstream force-key-unit event, which makes the
+// encoder encode the next frame as keyframe with all headers.
+func (p *Pipeline) ForceKeyUnit() error {
+	if C.force_key_unit(p.gstElement) != 0 {
+		return fmt.Errorf("%w: no element handled the force-key-unit event", errPipeline)
+	}
+	return nil
+}
+
+func lookupPipeline(id C.int) (*Pipeline, bool) {
+	pipelinesLock.Lock()
+	defer pipelinesLock.Unlock()
+	p, ok := pipelines[int(id)]
+	return p, ok
+}
+
+//export goHandlePipelineBuffer
+func goHandlePipelineBuffer(buffer unsafe.Pointer, bufferLen C.int, pts, dts, duration C.longlong, keyFrame C.int, pipelineID C.int) {
+	defer C.free(buffer)
+
+	pipeline, ok := lookupPipeline(pipelineID)
+	if !ok {
+		log.Printf("no pipeline with ID %v, discarding buffer", int(pipelineID))
+		return
+	}
+	select {
+	case <-pipeline.closed:
+		return
+	default:
+	}
+
+	pipeline.handlerLock.RLock()
+	cb := pipeline.frameCB
+	pipeline.handlerLock.RUnlock()
+	b := buffers.Copy(unsafe.Slice((*byte)(buffer), int(bufferLen)))
+	cb(Frame{
+		Bytes:    b.B,
+		PTS:      time.Duration(pts),
+		DTS:      time.Duration(dts),
+		Duration: time.Duration(duration),
+		KeyFrame: keyFrame != 0,
+		buffer:   b,
+	})
+}
+
+//export goHandleBusCall
+func goHandleBusCall(pipelineID C.int, signal C.int, message *C.char) {
+	pipeline, ok := lookupPipeline(pipelineID)
+	if !ok {
+		log.Printf("no pipeline with ID %v, discarding bus message", int(pipelineID))
+		return
+	}
+	pipeline.handlerLock.RLock()
+	defer pipeline.handlerLock.RUnlock()
+	switch signal {
+	case 0:
+		pipeline.eosCB()
+	case 1:
+		pipeline.errCB(fmt.Errorf("%w: %v", errPipeline, C.GoString(message)))
+	}
+}
@@ -0,0 +1,192 @@
+// Package histogram provides a high dynamic range (HDR) histogram which
+// records values with a bounded relative error, so that tail percentiles can
+// be reported without storing all samples.
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// precision is the number of significant bits of recorded values. Values are
+// rounded down to a bucket whose width is less than 1/2^(precision-1) of the
+// value, i.e., the relative error is below 1%.
+const precision = 8
+
+// Histogram counts non-negative int64 values in logarithmic buckets which are
+// linearly subdivided. It is safe for concurrent use.
+type Histogram struct {
+	lock   sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+func New() *Histogram {
+	return &Histogram{}
+}
+
+func bucket(v int64) int {
+	if v < 1<<precision {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - precision
+	mantissa := int(v >> shift)
+	return 1<<precision + (shift-1)<<(precision-1) + mantissa - 1<<(precision-1)
+}
+
+// lowestValue returns the lowest value counted in bucket i.
+func lowestValue(i int) int64 {
+	if i < 1<<precision {
+		return int64(i)
+	}
+	i -= 1 << precision
+	shift := i>>(precision-1) + 1
+	mantissa := int64(i&(1<<(precision-1)-1)) + 1<<(precision-1)
+	return mantissa << shift
+}
+
+// Record adds v to the histogram, negative values are counted as 0.
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	i := bucket(v)
+	if i >= len(h.counts) {
+		counts := make([]uint64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += float64(v)
+}
+
+// Merge adds all values recorded in o.
+func (h *Histogram) Merge(o *Histogram) {
+	o.lock.Lock()
+	counts := append([]uint64{}, o.counts...)
+	count, sum, min, max := o.count, o.sum, o.min, o.max
+	o.lock.Unlock()
+
+	if count == 0 {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(counts) > len(h.counts) {
+		c := make([]uint64, len(counts))
+		copy(c, h.counts)
+		h.counts = c
+	}
+	for i, c := range counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || min < h.min {
+		h.min = min
+	}
+	if max > h.max {
+		h.max = max
+	}
+	h.count += count
+	h.sum += sum
+}
+
+// CumulativeCounts returns the number of recorded values less than or equal
+// to each of the ascending bounds. Values are counted by the lowest value of
+// their bucket, like in ValueAtQuantile.
+func (h *Histogram) CumulativeCounts(bounds []int64) []uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	counts := make([]uint64, len(bounds))
+	var seen uint64
+	b := 0
+	for i, c := range h.counts {
+		for b < len(bounds) && lowestValue(i) > bounds[b] {
+			counts[b] = seen
+			b++
+		}
+		seen += c
+	}
+	for ; b < len(bounds); b++ {
+		counts[b] = seen
+	}
+	return counts
+}
+
+// Sum returns the sum of all recorded values.
+func (h *Histogram) Sum() float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.sum
+}
+
+func (h *Histogram) Count() uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.count
+}
+
+func (h *Histogram) Min() int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.min
+}
+
+func (h *Histogram) Max() int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.max
+}
+
+func (h *Histogram) Mean() float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / float64(h.count)
+}
+
+// ValueAtQuantile returns the lowest value of the bucket containing the q-th
+// quantile, q in [0, 1], or 0 if the histogram is empty. The result is
+// limited to the recorded min and max.
+func (h *Histogram) ValueAtQuantile(q float64) int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	q = math.Max(0, math.Min(1, q))
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := lowestValue(i)
+			if v < h.min {
+				v = h.min
+			}
+			if v > h.max {
+				v = h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
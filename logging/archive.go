@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ArchiveDir writes all regular files in dir and its subdirectories as a
+// gzip compressed tar archive to output. Paths in the archive are relative to
+// the parent of dir, so that the archive unpacks into a directory named like
+// dir.
+func ArchiveDir(dir, output string) error {
+	fd, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := writeArchive(dir, fd); err != nil {
+		fd.Close()
+		return fmt.Errorf("failed to archive %v: %w", dir, err)
+	}
+	return fd.Close()
+}
+
+func writeArchive(dir string, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	base := filepath.Dir(filepath.Clean(dir))
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if d.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// files may still grow while they are archived, copy only the size
+		// given in the header
+		_, err = io.CopyN(tw, f, header.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
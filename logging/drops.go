@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DropReason classifies packets which are dropped or ignored instead of being
+// passed on.
+type DropReason string
+
+const (
+	DropUnknownFlow   DropReason = "unknown-flow"
+	DropParseError    DropReason = "parse-error"
+	DropLate          DropReason = "late"
+	DropDuplicate     DropReason = "duplicate"
+	DropQueueOverflow DropReason = "queue-overflow"
+	DropStreamReset   DropReason = "stream-reset"
+	// DropSendQueueDelay counts packets which waited longer than the delay
+	// budget of the send queue.
+	DropSendQueueDelay DropReason = "send-queue-delay"
+	// DropBackpressure counts packets which the transport couldn't send
+	// within the backpressure timeout.
+	DropBackpressure DropReason = "backpressure"
+	// DropRelayCongestion counts packets a relay didn't forward to a
+	// receiver, because they exceeded the bitrate estimate of the receiver.
+	DropRelayCongestion DropReason = "relay-congestion"
+)
+
+type dropCounter struct {
+	count      uint64
+	suppressed uint64
+	lastLog    time.Time
+}
+
+var (
+	dropsLock       sync.Mutex
+	drops           = map[DropReason]*dropCounter{}
+	dropLogInterval time.Duration
+)
+
+// SetDropLogInterval enables logging dropped packets. At most one line per
+// reason is logged per interval, the number of suppressed lines is appended to
+// the next line. An interval <= 0 disables logging, the counters are always
+// updated.
+func SetDropLogInterval(interval time.Duration) {
+	dropsLock.Lock()
+	defer dropsLock.Unlock()
+	dropLogInterval = interval
+}
+
+// Drop counts a dropped packet and logs the message if drop logging is enabled
+// and no other message was logged for the same reason within the interval.
+func Drop(reason DropReason, format string, args ...interface{}) {
+	dropsLock.Lock()
+	defer dropsLock.Unlock()
+
+	c, ok := drops[reason]
+	if !ok {
+		c = &dropCounter{}
+		drops[reason] = c
+	}
+	c.count++
+	if dropLogInterval <= 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(c.lastLog) < dropLogInterval {
+		c.suppressed++
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if c.suppressed > 0 {
+		log.Printf("dropped packet (%v): %v (%v similar messages suppressed)", reason, msg, c.suppressed)
+	} else {
+		log.Printf("dropped packet (%v): %v", reason, msg)
+	}
+	c.lastLog = now
+	c.suppressed = 0
+}
+
+// DropCounts returns the number of dropped packets per reason.
+func DropCounts() map[DropReason]uint64 {
+	dropsLock.Lock()
+	defer dropsLock.Unlock()
+	res := make(map[DropReason]uint64, len(drops))
+	for reason, c := range drops {
+		res[reason] = c.count
+	}
+	return res
+}
+
+// LogDropCounts logs the number of dropped packets per reason, if any packets
+// were dropped.
+func LogDropCounts() {
+	counts := DropCounts()
+	if len(counts) == 0 {
+		return
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		log.Printf("dropped packets (%v): %v", reason, counts[DropReason(reason)])
+	}
+}
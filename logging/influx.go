@@ -0,0 +1,223 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+)
+
+// influxTimeout bounds a single write to the line protocol endpoint.
+const influxTimeout = 5 * time.Second
+
+var errInfluxWrite = errors.New("failed to write points")
+
+// Point is a point in the InfluxDB line protocol.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+}
+
+// InfluxExporter pushes metrics in the InfluxDB line protocol to an HTTP
+// endpoint every interval. Each point carries the tags of the exporter,
+// e.g., the experiment, transport and congestion control algorithm, so that
+// runs can be told apart in Grafana. Exported are the metrics of the
+// congestion controllers (measurement 'cc'), dropped packets per reason
+// ('drops'), the latency percentiles ('latency') and the points returned by
+// the added collectors. Counters are cumulative, use derivative() to get
+// rates.
+type InfluxExporter struct {
+	lock       sync.Mutex
+	url        string
+	token      string
+	tags       map[string]string
+	sources    map[string]cc.MetricsSource
+	collectors []func() []Point
+}
+
+// NewInfluxExporter creates an exporter writing to url, e.g.,
+// 'http://localhost:8086/api/v2/write?org=org&bucket=bucket'. The token in
+// the environment variable INFLUX_TOKEN is sent if it is set.
+func NewInfluxExporter(url string, tags map[string]string) *InfluxExporter {
+	return &InfluxExporter{
+		url:     url,
+		token:   os.Getenv("INFLUX_TOKEN"),
+		tags:    tags,
+		sources: map[string]cc.MetricsSource{},
+	}
+}
+
+// AddSource adds a congestion controller exported with the tag
+// source=name.
+func (e *InfluxExporter) AddSource(name string, s cc.MetricsSource) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.sources[name] = s
+}
+
+// AddCollector adds a function returning further points on every interval.
+func (e *InfluxExporter) AddCollector(c func() []Point) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.collectors = append(e.collectors, c)
+}
+
+// Run pushes the metrics every interval until ctx is done. Failed writes are
+// logged and the points are dropped.
+func (e *InfluxExporter) Run(ctx context.Context, interval time.Duration) {
+	client := &http.Client{Timeout: influxTimeout}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			var body bytes.Buffer
+			for _, p := range e.collect() {
+				e.writePoint(&body, p, now)
+			}
+			if err := e.push(ctx, client, &body); err != nil {
+				log.Printf("failed to push metrics to %v: %v", e.url, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *InfluxExporter) collect() []Point {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	points := []Point{}
+	names := make([]string, 0, len(e.sources))
+	for name := range e.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := e.sources[name].Metrics()
+		points = append(points, Point{
+			Measurement: "cc",
+			Tags:        map[string]string{"source": name},
+			Fields: map[string]float64{
+				"target_bitrate": float64(m.TargetBitrate),
+				"pacing_rate":    float64(m.PacingRate),
+				"cwnd":           float64(m.Cwnd),
+				"rtt_ms":         float64(m.RTT) / float64(time.Millisecond),
+				"queue_delay_ms": float64(m.QueueDelay) / float64(time.Millisecond),
+				"loss_rate":      m.LossRate,
+			},
+		})
+	}
+	for reason, count := range DropCounts() {
+		points = append(points, Point{
+			Measurement: "drops",
+			Tags:        map[string]string{"reason": string(reason)},
+			Fields:      map[string]float64{"count": float64(count)},
+		})
+	}
+	for _, name := range LatencyNames() {
+		h := Latency(name)
+		if h.Count() == 0 {
+			continue
+		}
+		ms := func(us int64) float64 {
+			return float64(us) / 1000
+		}
+		points = append(points, Point{
+			Measurement: "latency",
+			Tags:        map[string]string{"name": name},
+			Fields: map[string]float64{
+				"count":    float64(h.Count()),
+				"p50_ms":   ms(h.ValueAtQuantile(0.5)),
+				"p90_ms":   ms(h.ValueAtQuantile(0.9)),
+				"p99_ms":   ms(h.ValueAtQuantile(0.99)),
+				"p99.9_ms": ms(h.ValueAtQuantile(0.999)),
+			},
+		})
+	}
+	for _, c := range e.collectors {
+		points = append(points, c()...)
+	}
+	return points
+}
+
+// writePoint writes p with the tags of the exporter as one line.
+func (e *InfluxExporter) writePoint(b *bytes.Buffer, p Point, now time.Time) {
+	if len(p.Fields) == 0 {
+		return
+	}
+	tags := map[string]string{}
+	for k, v := range e.tags {
+		tags[k] = v
+	}
+	for k, v := range p.Tags {
+		tags[k] = v
+	}
+	b.WriteString(influxEscaper.Replace(p.Measurement))
+	for _, k := range sortedKeys(tags) {
+		if len(tags[k]) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, ",%v=%v", influxEscaper.Replace(k), influxEscaper.Replace(tags[k]))
+	}
+	fields := make([]string, 0, len(p.Fields))
+	for k := range p.Fields {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	for i, k := range fields {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		fmt.Fprintf(b, "%v%v=%v", sep, influxEscaper.Replace(k), strconv.FormatFloat(p.Fields[k], 'f', -1, 64))
+	}
+	fmt.Fprintf(b, " %v\n", now.UnixNano())
+}
+
+func (e *InfluxExporter) push(ctx context.Context, client *http.Client, body *bytes.Buffer) error {
+	if body.Len() == 0 {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(e.token) > 0 {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%w: %v", errInfluxWrite, res.Status)
+	}
+	return nil
+}
+
+// influxEscaper escapes measurements, tag keys, tag values and field keys.
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
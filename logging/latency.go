@@ -0,0 +1,123 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/histogram"
+)
+
+// Names of the recorded latencies
+const (
+	LatencyOWD             = "owd"
+	LatencyRTT             = "rtt"
+	LatencyFrameCompletion = "frame-completion"
+	LatencyGlassToGlass    = "glass-to-glass"
+)
+
+var (
+	latencyLock sync.Mutex
+	latencies   = map[string]*histogram.Histogram{}
+)
+
+// Latency returns the histogram of the latency name, which records
+// microseconds.
+func Latency(name string) *histogram.Histogram {
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+	h, ok := latencies[name]
+	if !ok {
+		h = histogram.New()
+		latencies[name] = h
+	}
+	return h
+}
+
+// RecordLatency records d in the histogram of the latency name.
+func RecordLatency(name string, d time.Duration) {
+	Latency(name).Record(d.Microseconds())
+}
+
+// WriteLatencyHistograms writes one line per recorded latency to file in the
+// format 'name, count, min, p50, p90, p99, p99.9, max, mean' with all values
+// in milliseconds.
+func WriteLatencyHistograms(file string) error {
+	names := LatencyNames()
+	w, err := GetLogFile(file)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	ms := func(us int64) float64 {
+		return float64(us) / 1000
+	}
+	for _, name := range names {
+		h := Latency(name)
+		if h.Count() == 0 {
+			continue
+		}
+		fmt.Fprintf(
+			w, "%v, %v, %.3f, %.3f, %.3f, %.3f, %.3f, %.3f, %.3f\n",
+			name,
+			h.Count(),
+			ms(h.Min()),
+			ms(h.ValueAtQuantile(0.5)),
+			ms(h.ValueAtQuantile(0.9)),
+			ms(h.ValueAtQuantile(0.99)),
+			ms(h.ValueAtQuantile(0.999)),
+			ms(h.Max()),
+			h.Mean()/1000,
+		)
+	}
+	return nil
+}
+
+// LogLatencyPercentiles logs the percentiles of all recorded latencies since
+// the start every interval until ctx is done.
+func LogLatencyPercentiles(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	ms := func(us int64) float64 {
+		return float64(us) / 1000
+	}
+	for {
+		select {
+		case <-ticker.C:
+			for _, name := range LatencyNames() {
+				h := Latency(name)
+				if h.Count() == 0 {
+					continue
+				}
+				log.Printf(
+					"latency %v: count=%v, p50=%.3fms, p90=%.3fms, p99=%.3fms, p99.9=%.3fms, max=%.3fms",
+					name,
+					h.Count(),
+					ms(h.ValueAtQuantile(0.5)),
+					ms(h.ValueAtQuantile(0.9)),
+					ms(h.ValueAtQuantile(0.99)),
+					ms(h.ValueAtQuantile(0.999)),
+					ms(h.Max()),
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// LatencyNames returns the names of the recorded latencies in alphabetical
+// order.
+func LatencyNames() []string {
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+	names := make([]string, 0, len(latencies))
+	for name := range latencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
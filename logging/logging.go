@@ -31,13 +31,15 @@ func GetLogFile(file string) (io.WriteCloser, error) {
 	}, nil
 }
 
+// GetQLOGTracer returns a tracer writing a qlog file per connection to path,
+// which includes the events added by QLOGEvent.
 func GetQLOGTracer(path string) (logging.Tracer, error) {
 	if len(path) == 0 {
 		return nil, nil
 	}
 	if path == "stdout" {
 		return qlog.NewTracer(func(p logging.Perspective, connectionID []byte) io.WriteCloser {
-			return nopCloser{os.Stdout}
+			return newQLOGWriter(nopCloser{os.Stdout})
 		}), nil
 	}
 	_, err := os.Stat(path)
@@ -58,7 +60,7 @@ func GetQLOGTracer(path string) (logging.Tracer, error) {
 			return nil
 		}
 		log.Printf("created qlog file: %s\n", path)
-		return w
+		return newQLOGWriter(w)
 	}), nil
 }
 
@@ -0,0 +1,26 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Manifest describes a run, so that its results can be related to the
+// configuration which produced them.
+type Manifest struct {
+	Command      string    `json:"command"`
+	Args         []string  `json:"args"`
+	Host         string    `json:"host"`
+	Start        time.Time `json:"start"`
+	Experimental []string  `json:"experimental"`
+}
+
+// WriteManifest writes m as JSON to file.
+func WriteManifest(file string, m Manifest) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(buf, '\n'), 0o644)
+}
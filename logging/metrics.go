@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+)
+
+// LogMetrics samples the metrics of all sources every interval and writes one
+// line per source to file until ctx is done. Lines have the format
+// 'ts, source, target, pacing rate, cwnd, rtt ms, queue delay ms, loss rate'.
+func LogMetrics(ctx context.Context, file string, interval time.Duration, sources map[string]cc.MetricsSource) error {
+	w, err := GetLogFile(file)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, name := range names {
+				m := sources[name].Metrics()
+				fmt.Fprintf(
+					w, "%v, %v, %v, %v, %v, %v, %v, %v\n",
+					now.UnixMilli(),
+					name,
+					m.TargetBitrate,
+					m.PacingRate,
+					m.Cwnd,
+					m.RTT.Milliseconds(),
+					m.QueueDelay.Milliseconds(),
+					m.LossRate,
+				)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	pcapngSectionHeaderBlock    = 0x0a0d0d0a
+	pcapngInterfaceBlock        = 0x00000001
+	pcapngEnhancedPacketBlock   = 0x00000006
+	pcapngByteOrderMagic        = 0x1a2b3c4d
+	pcapngLinkTypeRaw           = 101
+	pcapngEnhancedPacketHeader  = 28
+	pcapngEnhancedPacketTrailer = 4
+
+	ipv4HeaderSize = 20
+	udpHeaderSize  = 8
+	ipProtocolUDP  = 17
+)
+
+// UDPEndpoint is a fake address of a packet written to a PcapngWriter.
+type UDPEndpoint struct {
+	IP   net.IP
+	Port uint16
+}
+
+// PcapngWriter writes packets as if they were sent over UDP/IPv4 to a pcapng
+// file, so that packets of encrypted transports can be analyzed in
+// Wireshark. Timestamps have microsecond resolution.
+type PcapngWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+	id   uint16
+}
+
+// NewPcapngWriter writes the section header and a raw IP interface to w.
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngSectionHeaderBlock)
+	binary.LittleEndian.PutUint32(shb[4:], uint32(len(shb)))
+	binary.LittleEndian.PutUint32(shb[8:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[12:], 1) // major version
+	binary.LittleEndian.PutUint16(shb[14:], 0) // minor version
+	binary.LittleEndian.PutUint64(shb[16:], ^uint64(0))
+	binary.LittleEndian.PutUint32(shb[24:], uint32(len(shb)))
+
+	idb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(idb[0:], pcapngInterfaceBlock)
+	binary.LittleEndian.PutUint32(idb[4:], uint32(len(idb)))
+	binary.LittleEndian.PutUint16(idb[8:], pcapngLinkTypeRaw)
+	binary.LittleEndian.PutUint32(idb[12:], 0) // no snap length
+	binary.LittleEndian.PutUint32(idb[16:], uint32(len(idb)))
+
+	if _, err := w.Write(append(shb, idb...)); err != nil {
+		return nil, err
+	}
+	return &PcapngWriter{w: w}, nil
+}
+
+// WritePacket writes payload at t in a UDP datagram from src to dst.
+func (p *PcapngWriter) WritePacket(t time.Time, src, dst UDPEndpoint, payload []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	size := ipv4HeaderSize + udpHeaderSize + len(payload)
+	padded := (size + 3) &^ 3
+	blockSize := pcapngEnhancedPacketHeader + padded + pcapngEnhancedPacketTrailer
+	buf := make([]byte, blockSize)
+
+	ts := uint64(t.UnixMicro())
+	binary.LittleEndian.PutUint32(buf[0:], pcapngEnhancedPacketBlock)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(blockSize))
+	binary.LittleEndian.PutUint32(buf[8:], 0) // interface
+	binary.LittleEndian.PutUint32(buf[12:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(buf[16:], uint32(ts))
+	binary.LittleEndian.PutUint32(buf[20:], uint32(size))
+	binary.LittleEndian.PutUint32(buf[24:], uint32(size))
+	binary.LittleEndian.PutUint32(buf[blockSize-4:], uint32(blockSize))
+
+	ip := buf[pcapngEnhancedPacketHeader:]
+	p.id++
+	ip[0] = 0x45 // IPv4, 20 byte header
+	binary.BigEndian.PutUint16(ip[2:], uint16(size))
+	binary.BigEndian.PutUint16(ip[4:], p.id)
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64
+	ip[9] = ipProtocolUDP
+	copy(ip[12:16], src.IP.To4())
+	copy(ip[16:20], dst.IP.To4())
+	binary.BigEndian.PutUint16(ip[10:], ipv4Checksum(ip[:ipv4HeaderSize]))
+
+	udp := ip[ipv4HeaderSize:]
+	binary.BigEndian.PutUint16(udp[0:], src.Port)
+	binary.BigEndian.PutUint16(udp[2:], dst.Port)
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpHeaderSize+len(payload)))
+	// the UDP checksum is optional for IPv4 and left zero
+	copy(udp[udpHeaderSize:], payload)
+
+	_, err := p.w.Write(buf)
+	return err
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
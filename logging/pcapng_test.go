@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPcapngWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewPcapngWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := UDPEndpoint{IP: net.IPv4(10, 0, 0, 1), Port: 5004}
+	dst := UDPEndpoint{IP: net.IPv4(10, 0, 0, 2), Port: 5006}
+	now := time.UnixMicro(1_650_000_000_123_456)
+	payload := []byte{1, 2, 3}
+	if err := w.WritePacket(now, src, dst, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if binary.LittleEndian.Uint32(b) != pcapngSectionHeaderBlock {
+		t.Fatalf("got block type %#x, want section header", binary.LittleEndian.Uint32(b))
+	}
+	b = b[binary.LittleEndian.Uint32(b[4:]):]
+	if binary.LittleEndian.Uint32(b) != pcapngInterfaceBlock {
+		t.Fatalf("got block type %#x, want interface description", binary.LittleEndian.Uint32(b))
+	}
+	b = b[binary.LittleEndian.Uint32(b[4:]):]
+	if binary.LittleEndian.Uint32(b) != pcapngEnhancedPacketBlock {
+		t.Fatalf("got block type %#x, want enhanced packet", binary.LittleEndian.Uint32(b))
+	}
+	size := binary.LittleEndian.Uint32(b[4:])
+	if size%4 != 0 || int(size) != len(b) || binary.LittleEndian.Uint32(b[size-4:]) != size {
+		t.Fatalf("invalid enhanced packet block of %v bytes", size)
+	}
+	ts := uint64(binary.LittleEndian.Uint32(b[12:]))<<32 | uint64(binary.LittleEndian.Uint32(b[16:]))
+	if ts != uint64(now.UnixMicro()) {
+		t.Errorf("got timestamp %v, want %v", ts, now.UnixMicro())
+	}
+	ip := b[pcapngEnhancedPacketHeader : pcapngEnhancedPacketHeader+binary.LittleEndian.Uint32(b[20:])]
+	if ipv4Checksum(ip[:ipv4HeaderSize]) != 0 {
+		t.Errorf("invalid IPv4 header checksum")
+	}
+	if !net.IP(ip[12:16]).Equal(src.IP) || !net.IP(ip[16:20]).Equal(dst.IP) {
+		t.Errorf("got addresses %v -> %v, want %v -> %v", net.IP(ip[12:16]), net.IP(ip[16:20]), src.IP, dst.IP)
+	}
+	udp := ip[ipv4HeaderSize:]
+	if binary.BigEndian.Uint16(udp) != src.Port || binary.BigEndian.Uint16(udp[2:]) != dst.Port {
+		t.Errorf("got ports %v -> %v, want %v -> %v", binary.BigEndian.Uint16(udp), binary.BigEndian.Uint16(udp[2:]), src.Port, dst.Port)
+	}
+	if !bytes.Equal(udp[udpHeaderSize:], payload) {
+		t.Errorf("got payload %x, want %x", udp[udpHeaderSize:], payload)
+	}
+}
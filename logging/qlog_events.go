@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Names of the application events added to the qlog traces. They use the
+// 'roq' category, following the qlog extension mechanism for custom events.
+const (
+	QLOGFlowCreated          = "roq:flow_created"
+	QLOGDatagramDropped      = "roq:datagram_dropped"
+	QLOGFrameDeadlineExpired = "roq:frame_deadline_expired"
+	QLOGTargetUpdated        = "roq:cc_target_updated"
+)
+
+var (
+	qlogLock    sync.Mutex
+	qlogWriters = map[*qlogWriter]struct{}{}
+)
+
+// QLOGEvent adds an application event to the qlog traces of all open QUIC
+// connections, interleaved with the events logged by quic-go. It does
+// nothing if no qlog is written.
+func QLOGEvent(name string, data map[string]interface{}) {
+	qlogLock.Lock()
+	defer qlogLock.Unlock()
+	if len(qlogWriters) == 0 {
+		return
+	}
+	e := qlogEvent{
+		Time: float64(time.Now().UnixNano()) / float64(time.Millisecond),
+		Name: name,
+		Data: data,
+	}
+	for w := range qlogWriters {
+		w.event(e)
+	}
+}
+
+// qlogWriter passes the JSON-SEQ records written by quic-go to w and inserts
+// application events between them. quic-go writes through a bufio.Writer,
+// so a Write may end within a record. Events are queued until the pending
+// record is complete.
+type qlogWriter struct {
+	lock          sync.Mutex
+	w             io.WriteCloser
+	pending       []byte
+	queue         []qlogEvent
+	referenceTime float64
+	header        bool
+}
+
+func newQLOGWriter(w io.WriteCloser) *qlogWriter {
+	q := &qlogWriter{w: w}
+	qlogLock.Lock()
+	qlogWriters[q] = struct{}{}
+	qlogLock.Unlock()
+	return q
+}
+
+func (q *qlogWriter) Write(p []byte) (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.pending = append(q.pending, p...)
+	end := bytes.LastIndexByte(q.pending, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	complete := q.pending[:end+1]
+	if !q.header {
+		q.readHeader(complete)
+	}
+	if _, err := q.w.Write(complete); err != nil {
+		return 0, err
+	}
+	q.pending = append(q.pending[:0], q.pending[end+1:]...)
+	if err := q.flushQueue(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readHeader reads the reference time of the trace from the first record,
+// event times are relative to it.
+func (q *qlogWriter) readHeader(records []byte) {
+	q.header = true
+	record := records
+	if i := bytes.IndexByte(records, '\n'); i >= 0 {
+		record = records[:i]
+	}
+	var h struct {
+		Trace *qlogTrace `json:"trace"`
+	}
+	if err := json.Unmarshal(bytes.Trim(record, "\x1e\n "), &h); err != nil || h.Trace == nil {
+		log.Printf("failed to read qlog header, application events use absolute times: %v", err)
+		return
+	}
+	if ref, ok := h.Trace.CommonFields["reference_time"].(float64); ok {
+		q.referenceTime = ref
+	}
+}
+
+func (q *qlogWriter) event(e qlogEvent) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.queue = append(q.queue, e)
+	if q.header && len(q.pending) == 0 {
+		if err := q.flushQueue(); err != nil {
+			log.Printf("failed to write qlog event: %v", err)
+		}
+	}
+}
+
+func (q *qlogWriter) flushQueue() error {
+	if !q.header {
+		return nil
+	}
+	for _, e := range q.queue {
+		e.Time -= q.referenceTime
+		buf, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		record := append([]byte{recordSeparator}, buf...)
+		if _, err := q.w.Write(append(record, '\n')); err != nil {
+			return err
+		}
+	}
+	q.queue = q.queue[:0]
+	return nil
+}
+
+func (q *qlogWriter) Close() error {
+	qlogLock.Lock()
+	delete(qlogWriters, q)
+	qlogLock.Unlock()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.pending) > 0 {
+		if _, err := q.w.Write(q.pending); err != nil {
+			log.Printf("failed to write qlog: %v", err)
+		}
+		q.pending = nil
+	}
+	if err := q.flushQueue(); err != nil {
+		log.Printf("failed to write qlog events: %v", err)
+	}
+	return q.w.Close()
+}
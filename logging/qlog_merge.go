@@ -0,0 +1,272 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const recordSeparator = 0x1e
+
+var errInvalidQLOG = errors.New("invalid qlog file")
+
+type qlogTrace struct {
+	VantagePoint  map[string]interface{} `json:"vantage_point"`
+	Title         string                 `json:"title,omitempty"`
+	CommonFields  map[string]interface{} `json:"common_fields"`
+	Events        []qlogEvent            `json:"events"`
+	referenceTime float64
+}
+
+type qlogEvent struct {
+	Time float64                `json:"time"`
+	Name string                 `json:"name"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+type qlogFile struct {
+	QLOGVersion string       `json:"qlog_version"`
+	QLOGFormat  string       `json:"qlog_format"`
+	Title       string       `json:"title"`
+	Traces      []*qlogTrace `json:"traces"`
+}
+
+// MergeQLOGs merges the client and server qlog files of one session into a
+// single JSON qlog file which can be loaded into qvis. If rtpLog or ccLog are
+// set, the RTP packets and congestion control updates logged by the sender are
+// added as custom events to the client trace. If trim is set, all events
+// outside of the media phase are removed.
+func MergeQLOGs(client, server, rtpLog, ccLog, output string, trim bool) error {
+	traces := []*qlogTrace{}
+	for _, file := range []string{client, server} {
+		if len(file) == 0 {
+			continue
+		}
+		t, err := readQLOGTrace(file)
+		if err != nil {
+			return fmt.Errorf("failed to read qlog file %v: %w", file, err)
+		}
+		traces = append(traces, t)
+	}
+	if len(traces) == 0 {
+		return fmt.Errorf("%w: no input files", errInvalidQLOG)
+	}
+
+	rtpEvents, err := readCustomEvents(rtpLog, "rtp:packet_sent", []string{
+		"payload_type", "ssrc", "sequence_number", "timestamp", "marker", "size", "twcc_sequence_number", "unwrapped_sequence_number",
+	})
+	if err != nil {
+		return err
+	}
+	ccEvents, err := readCustomEvents(ccLog, "cc:metrics_updated", []string{
+		"target_bitrate", "queue_delay", "smoothed_rtt", "cwnd", "bytes_in_flight", "rate_lost", "rate_transmitted", "rate_acked", "highest_seq_acked", "in_fast_start",
+	})
+	if err != nil {
+		return err
+	}
+	traces[0].Events = append(traces[0].Events, rtpEvents...)
+	traces[0].Events = append(traces[0].Events, ccEvents...)
+
+	start, end := 0.0, 0.0
+	if trim {
+		start, end = mediaPhase(traces, rtpEvents)
+	}
+	reference := start
+	if reference == 0 {
+		reference = traces[0].referenceTime
+		for _, t := range traces {
+			if t.referenceTime < reference {
+				reference = t.referenceTime
+			}
+		}
+	}
+
+	for _, t := range traces {
+		events := make([]qlogEvent, 0, len(t.Events))
+		for _, e := range t.Events {
+			if trim && (e.Time < start || e.Time > end) {
+				continue
+			}
+			e.Time -= reference
+			events = append(events, e)
+		}
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].Time < events[j].Time
+		})
+		t.Events = events
+		t.CommonFields["reference_time"] = reference
+		t.CommonFields["time_format"] = "relative"
+	}
+
+	buf, err := json.Marshal(qlogFile{
+		QLOGVersion: "draft-02",
+		QLOGFormat:  "JSON",
+		Title:       "rtp-over-quic merged qlog",
+		Traces:      traces,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, buf, 0o644)
+}
+
+// readQLOGTrace reads a qlog file in either the JSON-SEQ format written by
+// quic-go or the JSON format and returns the first trace with all event
+// timestamps converted to absolute milliseconds.
+func readQLOGTrace(file string) (*qlogTrace, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	records := bytes.Split(content, []byte{recordSeparator})
+	header := bytes.TrimSpace(records[0])
+	if len(header) == 0 && len(records) > 1 {
+		header = bytes.TrimSpace(records[1])
+		records = records[1:]
+	}
+
+	var trace *qlogTrace
+	if len(records) == 1 {
+		var f qlogFile
+		if err := json.Unmarshal(header, &f); err != nil {
+			return nil, err
+		}
+		if len(f.Traces) == 0 {
+			return nil, fmt.Errorf("%w: no traces", errInvalidQLOG)
+		}
+		trace = f.Traces[0]
+	} else {
+		var h struct {
+			Trace *qlogTrace `json:"trace"`
+		}
+		if err := json.Unmarshal(header, &h); err != nil {
+			return nil, err
+		}
+		if h.Trace == nil {
+			return nil, fmt.Errorf("%w: missing trace header", errInvalidQLOG)
+		}
+		trace = h.Trace
+		for _, record := range records[1:] {
+			record = bytes.TrimSpace(record)
+			if len(record) == 0 {
+				continue
+			}
+			var e qlogEvent
+			if err := json.Unmarshal(record, &e); err != nil {
+				return nil, err
+			}
+			trace.Events = append(trace.Events, e)
+		}
+	}
+	if trace.CommonFields == nil {
+		trace.CommonFields = map[string]interface{}{}
+	}
+	if ref, ok := trace.CommonFields["reference_time"].(float64); ok {
+		trace.referenceTime = ref
+	}
+	if trace.CommonFields["time_format"] != "absolute" {
+		for i := range trace.Events {
+			trace.Events[i].Time += trace.referenceTime
+		}
+	}
+	return trace, nil
+}
+
+// readCustomEvents converts a comma separated log file whose first column is a
+// unix timestamp in milliseconds to qlog events with the given name. The
+// remaining columns are stored in the event data using the given keys.
+func readCustomEvents(file, name string, keys []string) ([]qlogEvent, error) {
+	if len(file) == 0 {
+		return nil, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []qlogEvent{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 2 {
+			continue
+		}
+		ts, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+		if err != nil {
+			continue
+		}
+		data := map[string]interface{}{}
+		for i, v := range fields[1:] {
+			if i >= len(keys) {
+				break
+			}
+			data[keys[i]] = strings.TrimSpace(v)
+		}
+		events = append(events, qlogEvent{
+			Time: ts,
+			Name: name,
+			Data: data,
+		})
+	}
+	return events, scanner.Err()
+}
+
+// mediaPhase returns the absolute start and end time of the media phase. It
+// uses the RTP events if available and otherwise falls back to the first and
+// last packet carrying a datagram or stream frame.
+func mediaPhase(traces []*qlogTrace, rtpEvents []qlogEvent) (float64, float64) {
+	if len(rtpEvents) > 0 {
+		start, end := rtpEvents[0].Time, rtpEvents[0].Time
+		for _, e := range rtpEvents {
+			if e.Time < start {
+				start = e.Time
+			}
+			if e.Time > end {
+				end = e.Time
+			}
+		}
+		return start, end
+	}
+	start, end := 0.0, 0.0
+	for _, t := range traces {
+		for _, e := range t.Events {
+			if !carriesMedia(e) {
+				continue
+			}
+			if start == 0 || e.Time < start {
+				start = e.Time
+			}
+			if e.Time > end {
+				end = e.Time
+			}
+		}
+	}
+	return start, end
+}
+
+func carriesMedia(e qlogEvent) bool {
+	if e.Name != "transport:packet_sent" && e.Name != "transport:packet_received" {
+		return false
+	}
+	frames, ok := e.Data["frames"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, frame := range frames {
+		f, ok := frame.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if f["frame_type"] == "datagram" || f["frame_type"] == "stream" {
+			return true
+		}
+	}
+	return false
+}
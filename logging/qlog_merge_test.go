@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeQLOG(t *testing.T, name string, records ...string) string {
+	t.Helper()
+	content := ""
+	for _, r := range records {
+		content += string(rune(recordSeparator)) + r + "\n"
+	}
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadQLOGTrace(t *testing.T) {
+	file := writeQLOG(t, "client.qlog",
+		`{"qlog_version":"draft-02","trace":{"common_fields":{"reference_time":1000,"time_format":"relative"}}}`,
+		`{"time":1,"name":"transport:packet_sent"}`,
+		`{"time":2.5,"name":"transport:packet_received"}`,
+	)
+	trace, err := readQLOGTrace(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Events) != 2 || trace.Events[0].Time != 1001 || trace.Events[1].Time != 1002.5 {
+		t.Fatalf("got events %+v, want absolute times 1001 and 1002.5", trace.Events)
+	}
+
+	_, err = readQLOGTrace(writeQLOG(t, "invalid.qlog", `{"qlog_version":"draft-02"}`))
+	if !errors.Is(err, errInvalidQLOG) {
+		t.Fatalf("got error %v, want %v", err, errInvalidQLOG)
+	}
+}
+
+func TestMergeQLOGs(t *testing.T) {
+	client := writeQLOG(t, "client.qlog",
+		`{"qlog_version":"draft-02","trace":{"vantage_point":{"type":"client"},"common_fields":{"reference_time":1000}}}`,
+		`{"time":1,"name":"transport:packet_sent"}`,
+		`{"time":3,"name":"transport:packet_sent","data":{"frames":[{"frame_type":"datagram"}]}}`,
+	)
+	server := writeQLOG(t, "server.qlog",
+		`{"qlog_version":"draft-02","trace":{"vantage_point":{"type":"server"},"common_fields":{"reference_time":1005}}}`,
+		`{"time":0,"name":"transport:packet_received","data":{"frames":[{"frame_type":"datagram"}]}}`,
+	)
+	output := filepath.Join(t.TempDir(), "merged.qlog")
+	if err := MergeQLOGs(client, server, "", "", output, true); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f qlogFile
+	if err := json.Unmarshal(buf, &f); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Traces) != 2 {
+		t.Fatalf("got %v traces, want 2", len(f.Traces))
+	}
+	// trimming keeps the events from the first datagram on
+	if n := len(f.Traces[0].Events); n != 1 {
+		t.Errorf("got %v client events, want 1", n)
+	}
+	if n := len(f.Traces[1].Events); n != 1 {
+		t.Errorf("got %v server events, want 1", n)
+	}
+}
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Willi-42/rtp-over-quic/histogram"
+)
+
+// Names of the recorded sizes
+const (
+	SizePacket = "packet"
+	SizeFrame  = "frame"
+)
+
+var (
+	sizeLock sync.Mutex
+	sizes    = map[string]*histogram.Histogram{}
+)
+
+// Size returns the histogram of the size name, which records bytes.
+func Size(name string) *histogram.Histogram {
+	sizeLock.Lock()
+	defer sizeLock.Unlock()
+	h, ok := sizes[name]
+	if !ok {
+		h = histogram.New()
+		sizes[name] = h
+	}
+	return h
+}
+
+// RecordSize records size bytes in the histogram of the size name.
+func RecordSize(name string, size int) {
+	Size(name).Record(int64(size))
+}
+
+// SizeNames returns the names of the recorded sizes in alphabetical order.
+func SizeNames() []string {
+	sizeLock.Lock()
+	defer sizeLock.Unlock()
+	names := make([]string, 0, len(sizes))
+	for name := range sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
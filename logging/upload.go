@@ -0,0 +1,194 @@
+package logging
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	s3DefaultRegion = "us-east-1"
+	s3Service       = "s3"
+	awsAlgorithm    = "AWS4-HMAC-SHA256"
+	awsTimeFormat   = "20060102T150405Z"
+)
+
+var (
+	errInvalidUploadTarget = errors.New("invalid upload target")
+	errUploadFailed        = errors.New("upload failed")
+)
+
+// Upload uploads file to target, which is either an HTTP(S) URL the file is
+// PUT to or an S3 URL of the form 's3://<bucket>/<key>'. If the URL ends with
+// a '/', the name of file is appended. S3 credentials and the region are
+// read from the environment variables AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION. AWS_ENDPOINT_URL
+// selects an S3 compatible service, which is addressed path-style.
+func Upload(ctx context.Context, file, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidUploadTarget, err)
+	}
+	if len(u.Path) == 0 || strings.HasSuffix(u.Path, "/") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + filepath.Base(file)
+	}
+	fd, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	switch u.Scheme {
+	case "http", "https":
+		req, err = http.NewRequestWithContext(ctx, http.MethodPut, u.String(), fd)
+		if err != nil {
+			return err
+		}
+	case "s3":
+		req, err = newS3Request(ctx, u.Host, strings.TrimPrefix(u.Path, "/"), fd)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unsupported scheme %q", errInvalidUploadTarget, u.Scheme)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/gzip")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%w: %v: %v", errUploadFailed, res.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// newS3Request creates a PUT request of key in bucket signed with AWS
+// signature version 4. The payload is hashed before the request is sent, so
+// it has to be read twice.
+func newS3Request(ctx context.Context, bucket, key string, payload io.ReadSeeker) (*http.Request, error) {
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if len(accessKey) == 0 || len(secretKey) == 0 {
+		return nil, fmt.Errorf("%w: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for S3 uploads", errInvalidUploadTarget)
+	}
+	if len(bucket) == 0 {
+		return nil, fmt.Errorf("%w: missing S3 bucket", errInvalidUploadTarget)
+	}
+	region := os.Getenv("AWS_REGION")
+	if len(region) == 0 {
+		region = s3DefaultRegion
+	}
+
+	var endpoint *url.URL
+	path := "/" + awsURIEncode(key)
+	if e := os.Getenv("AWS_ENDPOINT_URL"); len(e) > 0 {
+		var err error
+		endpoint, err = url.Parse(e)
+		if err != nil {
+			return nil, fmt.Errorf("%w: AWS_ENDPOINT_URL: %v", errInvalidUploadTarget, err)
+		}
+		path = strings.TrimRight(endpoint.Path, "/") + "/" + awsURIEncode(bucket) + path
+	} else {
+		endpoint = &url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%v.s3.%v.amazonaws.com", bucket, region),
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, payload); err != nil {
+		return nil, err
+	}
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	payloadHash := hex.EncodeToString(hash.Sum(nil))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint.Scheme+"://"+endpoint.Host+path, payload)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format(awsTimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%v\nx-amz-content-sha256:%v\nx-amz-date:%v\n", endpoint.Host, payloadHash, amzDate)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); len(token) > 0 {
+		req.Header.Set("X-Amz-Security-Token", token)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += fmt.Sprintf("x-amz-security-token:%v\n", token)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodPut,
+		path,
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%v/%v/%v/aws4_request", date, region, s3Service)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		awsAlgorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, s3Service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%v Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		awsAlgorithm, accessKey, scope, signedHeaders, signature,
+	))
+	return req, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsURIEncode percent-encodes all bytes of path except unreserved
+// characters and '/' as required for canonical S3 request URIs.
+func awsURIEncode(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,155 @@
+package media
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// adaptationDefaultWidth, adaptationDefaultHeight and
+	// adaptationDefaultFramerate are the top level of the adaptation if
+	// Resolution or Framerate are not set.
+	adaptationDefaultWidth     = 1280
+	adaptationDefaultHeight    = 720
+	adaptationDefaultFramerate = 30
+
+	// adaptationMinBitsPerPixel is the number of bits per pixel and frame
+	// below which the next lower level is used. Above the level has to be
+	// reached with adaptationUpBitsPerPixel for adaptationUpDelay before
+	// switching up, which keeps the adaptation from oscillating around a
+	// threshold.
+	adaptationMinBitsPerPixel = 0.03
+	adaptationUpBitsPerPixel  = 0.06
+	adaptationUpDelay         = 3 * time.Second
+	// adaptationMinInterval is the minimum time between two switches, which
+	// gives the encoder time to settle after renegotiating the caps.
+	adaptationMinInterval = time.Second
+)
+
+// adaptationLevel is a resolution and frame rate, relative to the top level.
+type adaptationLevel struct {
+	// scale is the numerator of the scale factor of width and height in
+	// quarters.
+	scale int
+	// rateDivisor divides the frame rate.
+	rateDivisor int
+}
+
+// adaptationLevels are the levels from the highest to the lowest quality.
+// Resolution is reduced first, since a high frame rate matters more for
+// interactive video than sharpness.
+var adaptationLevels = []adaptationLevel{
+	{scale: 4, rateDivisor: 1},
+	{scale: 3, rateDivisor: 1},
+	{scale: 2, rateDivisor: 1},
+	{scale: 2, rateDivisor: 2},
+	{scale: 1, rateDivisor: 2},
+}
+
+// videoFormat is the resolution and frame rate of a video.
+type videoFormat struct {
+	width, height, framerate uint
+}
+
+func (f videoFormat) String() string {
+	return fmt.Sprintf("%vx%v@%v", f.width, f.height, f.framerate)
+}
+
+// caps returns the raw video caps of f.
+func (f videoFormat) caps() string {
+	return fmt.Sprintf("video/x-raw,width=%v,height=%v,framerate=%v/1", f.width, f.height, f.framerate)
+}
+
+// bitsPerPixel returns the bits per pixel and frame of f at bitrate.
+func (f videoFormat) bitsPerPixel(bitrate uint) float64 {
+	return float64(bitrate) / float64(f.width*f.height*f.framerate)
+}
+
+// adaptation selects the resolution and frame rate of a video for the target
+// bitrate of the congestion controller. It steps down a level as soon as the
+// target falls below adaptationMinBitsPerPixel at the current level and steps
+// up once the target reached adaptationUpBitsPerPixel at the next higher
+// level for adaptationUpDelay.
+type adaptation struct {
+	top     videoFormat
+	level   int
+	changed time.Time
+	// upSince is the time since when the target allows the next higher
+	// level, zero if it doesn't.
+	upSince time.Time
+}
+
+func newAdaptation(c *Config) *adaptation {
+	top := videoFormat{
+		width:     c.width,
+		height:    c.height,
+		framerate: c.framerate,
+	}
+	if top.width == 0 || top.height == 0 {
+		top.width, top.height = adaptationDefaultWidth, adaptationDefaultHeight
+	}
+	if top.framerate == 0 {
+		top.framerate = adaptationDefaultFramerate
+	}
+	a := &adaptation{
+		top: top,
+	}
+	// start at the level the initial target bitrate allows
+	for a.level < len(adaptationLevels)-1 && a.format(a.level).bitsPerPixel(c.targetBitrate) < adaptationMinBitsPerPixel {
+		a.level++
+	}
+	return a
+}
+
+// current returns the format of the current level.
+func (a *adaptation) current() videoFormat {
+	return a.format(a.level)
+}
+
+// format returns the format of level.
+func (a *adaptation) format(level int) videoFormat {
+	l := adaptationLevels[level]
+	f := videoFormat{
+		// even dimensions for the chroma subsampling of the encoders
+		width:     a.top.width * uint(l.scale) / 4 &^ 1,
+		height:    a.top.height * uint(l.scale) / 4 &^ 1,
+		framerate: a.top.framerate / uint(l.rateDivisor),
+	}
+	if f.framerate == 0 {
+		f.framerate = 1
+	}
+	return f
+}
+
+// update returns the format for the target bitrate at now and whether it
+// changed.
+func (a *adaptation) update(bitrate uint, now time.Time) (videoFormat, bool) {
+	current := a.current()
+	if now.Sub(a.changed) < adaptationMinInterval {
+		return current, false
+	}
+	next := a.level
+	if a.level < len(adaptationLevels)-1 && current.bitsPerPixel(bitrate) < adaptationMinBitsPerPixel {
+		next = a.level + 1
+		a.upSince = time.Time{}
+	} else if a.level > 0 && a.format(a.level-1).bitsPerPixel(bitrate) >= adaptationUpBitsPerPixel {
+		if a.upSince.IsZero() {
+			a.upSince = now
+		}
+		if now.Sub(a.upSince) >= adaptationUpDelay {
+			next = a.level - 1
+			a.upSince = time.Time{}
+		}
+	} else {
+		a.upSince = time.Time{}
+	}
+	if next == a.level {
+		return current, false
+	}
+	a.level = next
+	a.changed = now
+	f := a.format(next)
+	log.Printf("adaptation: switching from %v to %v at target bitrate %v bit/s", current, f, bitrate)
+	return f, true
+}
@@ -0,0 +1,300 @@
+package media
+
+import (
+	"errors"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/pkg/obu"
+)
+
+// OBU types (AV1 bitstream specification, section 6.2.2).
+const (
+	obuSequenceHeader    = 1
+	obuTemporalDelimiter = 2
+	obuTileList          = 8
+	obuPadding           = 15
+)
+
+var errInvalidOBU = errors.New("invalid AV1 OBU")
+
+// av1OBU is an OBU without its size field.
+type av1OBU struct {
+	typ byte
+	// element is the header, with the has_size_field bit cleared, followed
+	// by the payload of the OBU.
+	element []byte
+}
+
+// parseOBUs splits the temporal unit tu of the low overhead bitstream format
+// into its OBUs.
+func parseOBUs(tu []byte) ([]av1OBU, error) {
+	var obus []av1OBU
+	for len(tu) > 0 {
+		header := tu[0]
+		if header&0x80 != 0 {
+			return nil, errInvalidOBU
+		}
+		headerLen := 1
+		if header&0x04 != 0 {
+			headerLen = 2
+		}
+		if len(tu) < headerLen {
+			return nil, errInvalidOBU
+		}
+		payload := tu[headerLen:]
+		rest := []byte{}
+		if header&0x02 != 0 {
+			size, n, err := obu.ReadLeb128(payload)
+			if err != nil || uint(len(payload)) < n+size {
+				return nil, errInvalidOBU
+			}
+			rest = payload[n+size:]
+			payload = payload[n : n+size]
+		}
+		element := make([]byte, 0, headerLen+len(payload))
+		element = append(element, header&^0x02)
+		element = append(element, tu[1:headerLen]...)
+		element = append(element, payload...)
+		obus = append(obus, av1OBU{
+			typ:     header >> 3 & 0x0f,
+			element: element,
+		})
+		tu = rest
+	}
+	return obus, nil
+}
+
+func appendLEB128(buf []byte, v uint) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if v == 0 {
+			return buf
+		}
+	}
+}
+
+func leb128Size(v uint) int {
+	n := 1
+	for v >>= 7; v != 0; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// av1Payloader packetizes AV1 temporal units following the RTP payload
+// format for AV1. Unlike codecs.AV1Payloader, which sends the temporal unit
+// as a single OBU element, it splits the temporal unit into its OBUs, drops
+// temporal delimiters, tile lists and padding, strips the OBU size fields and
+// sets the N bit for temporal units starting a coded video sequence. All OBU
+// elements carry a length field (W = 0).
+type av1Payloader struct{}
+
+func (p *av1Payloader) Payload(mtu uint, tu []byte) [][]byte {
+	obus, err := parseOBUs(tu)
+	if err != nil || mtu < 4 {
+		return nil
+	}
+	newSequence := false
+	elements := make([][]byte, 0, len(obus))
+	for _, o := range obus {
+		switch o.typ {
+		case obuTemporalDelimiter, obuTileList, obuPadding:
+			continue
+		case obuSequenceHeader:
+			newSequence = true
+		}
+		elements = append(elements, o.element)
+	}
+
+	var payloads [][]byte
+	cur := []byte{0}
+	// continued is set if the first element of cur continues the last
+	// element of the previous packet
+	continued := false
+	flush := func(fragmented bool) {
+		if len(cur) == 1 {
+			return
+		}
+		var header byte
+		if continued {
+			header |= 0x80
+		}
+		if fragmented {
+			header |= 0x40
+		}
+		if newSequence && len(payloads) == 0 {
+			header |= 0x08
+		}
+		cur[0] = header
+		payloads = append(payloads, cur)
+		cur = []byte{0}
+		continued = fragmented
+	}
+	for _, e := range elements {
+		for len(e) > 0 {
+			avail := int(mtu) - len(cur)
+			n := len(e)
+			if n > avail-1 {
+				n = avail - 1
+			}
+			for n > 0 && leb128Size(uint(n))+n > avail {
+				n--
+			}
+			if n <= 0 {
+				flush(false)
+				continue
+			}
+			cur = appendLEB128(cur, uint(n))
+			cur = append(cur, e[:n]...)
+			e = e[n:]
+			if len(e) > 0 {
+				flush(true)
+			}
+		}
+	}
+	flush(false)
+	return payloads
+}
+
+// av1Depacketizer reassembles the temporal units of an AV1 RTP stream in the
+// low overhead bitstream format. After a lost packet, temporal units are
+// dropped until the next one starting a coded video sequence.
+type av1Depacketizer struct {
+	init     bool
+	lastSeq  uint16
+	waitKey  bool
+	obus     []byte
+	keyFrame bool
+	fragment []byte
+	// fragmented is set while the last element of the previous packet
+	// continues in the next packet
+	fragmented bool
+}
+
+// push adds pkt and returns the temporal unit completed by pkt, if any, and
+// whether it starts a coded video sequence.
+func (d *av1Depacketizer) push(pkt *rtp.Packet) ([]byte, bool, bool) {
+	if d.init && pkt.SequenceNumber != d.lastSeq+1 {
+		d.reset()
+		d.waitKey = true
+	}
+	d.init = true
+	d.lastSeq = pkt.SequenceNumber
+
+	if err := d.parse(pkt.Payload); err != nil {
+		d.reset()
+		d.waitKey = true
+	}
+	if !pkt.Marker {
+		return nil, false, false
+	}
+	obus, keyFrame := d.obus, d.keyFrame
+	d.reset()
+	if d.waitKey && !keyFrame {
+		return nil, false, false
+	}
+	d.waitKey = false
+	if len(obus) == 0 {
+		return nil, false, false
+	}
+	tu := make([]byte, 0, len(obus)+2)
+	tu = append(tu, obuTemporalDelimiter<<3|0x02, 0)
+	return append(tu, obus...), keyFrame, true
+}
+
+func (d *av1Depacketizer) reset() {
+	d.obus = nil
+	d.keyFrame = false
+	d.fragment = nil
+	d.fragmented = false
+}
+
+func (d *av1Depacketizer) parse(payload []byte) error {
+	if len(payload) < 1 {
+		return errInvalidOBU
+	}
+	z := payload[0]&0x80 != 0
+	y := payload[0]&0x40 != 0
+	w := int(payload[0] >> 4 & 0x03)
+	if z != d.fragmented {
+		return errInvalidOBU
+	}
+	i := 1
+	for index := 0; i < len(payload); index++ {
+		size := len(payload) - i
+		if w == 0 || index < w-1 {
+			v, n, err := obu.ReadLeb128(payload[i:])
+			if err != nil || uint(len(payload)-i) < n+v {
+				return errInvalidOBU
+			}
+			i += int(n)
+			size = int(v)
+		}
+		data := payload[i : i+size]
+		i += size
+		last := i >= len(payload) || (w > 0 && index == w-1)
+
+		element := data
+		if index == 0 && z {
+			element = append(d.fragment, data...)
+			d.fragment = nil
+		}
+		if last && y {
+			d.fragment = append([]byte(nil), element...)
+			break
+		}
+		if err := d.appendOBU(element); err != nil {
+			return err
+		}
+		if last {
+			break
+		}
+	}
+	d.fragmented = y
+	return nil
+}
+
+// appendOBU appends the OBU element with a size field.
+func (d *av1Depacketizer) appendOBU(element []byte) error {
+	if len(element) == 0 {
+		return errInvalidOBU
+	}
+	headerLen := 1
+	if element[0]&0x04 != 0 {
+		headerLen = 2
+	}
+	if len(element) < headerLen {
+		return errInvalidOBU
+	}
+	switch element[0] >> 3 & 0x0f {
+	case obuTemporalDelimiter, obuTileList, obuPadding:
+		return nil
+	case obuSequenceHeader:
+		d.keyFrame = true
+	}
+	d.obus = append(d.obus, element[0]|0x02)
+	d.obus = append(d.obus, element[1:headerLen]...)
+	d.obus = appendLEB128(d.obus, uint(len(element)-headerLen))
+	d.obus = append(d.obus, element[headerLen:]...)
+	return nil
+}
+
+// isAV1KeyFrame returns whether the temporal unit tu starts a coded video
+// sequence, which encoders do with each key frame.
+func isAV1KeyFrame(tu []byte) bool {
+	obus, err := parseOBUs(tu)
+	if err != nil {
+		return false
+	}
+	for _, o := range obus {
+		if o.typ == obuSequenceHeader {
+			return true
+		}
+	}
+	return false
+}
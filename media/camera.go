@@ -0,0 +1,24 @@
+package media
+
+import "strings"
+
+// CameraPrefix marks camera sources, e.g., 'camera:/dev/video0'.
+const CameraPrefix = "camera:"
+
+// defaultCameraDevice is the device of the source 'camera:'.
+const defaultCameraDevice = "/dev/video0"
+
+// IsCamera returns whether src is a camera source.
+func IsCamera(src string) bool {
+	return strings.HasPrefix(src, CameraPrefix)
+}
+
+// CameraDevice returns the V4L2 device of the camera source src,
+// /dev/video0 if src names no device.
+func CameraDevice(src string) string {
+	device := strings.TrimPrefix(src, CameraPrefix)
+	if len(device) == 0 {
+		return defaultCameraDevice
+	}
+	return device
+}
@@ -0,0 +1,144 @@
+package media
+
+import (
+	"time"
+)
+
+const (
+	clockDriftBucket  = time.Second
+	clockDriftBuckets = 30
+	// clockDriftMinBuckets is the number of buckets required before the
+	// drift is estimated.
+	clockDriftMinBuckets = 5
+	// clockDriftMaxPPM bounds the estimate, larger deviations are more
+	// likely measurement errors than clock errors.
+	clockDriftMaxPPM = 1000
+	// clockDriftGain smooths the estimate, so that the playout schedule
+	// slews instead of jumping.
+	clockDriftGain = 0.1
+)
+
+type clockDriftSample struct {
+	at        time.Duration
+	minOffset time.Duration
+}
+
+// ClockDrift estimates the actual media clock rate of a sender from RTP
+// timestamps and arrival times. The offset between arrival time and media
+// time grows or shrinks linearly with the drift, queueing delay only adds to
+// it. The estimator takes the minimum offset per bucket of one second to
+// remove the queueing delay and fits a line through the minima of the last
+// 30 buckets.
+type ClockDrift struct {
+	nominal float64
+
+	init          bool
+	start         time.Time
+	lastTimestamp uint32
+	timestamp     int64
+
+	bucket     clockDriftSample
+	bucketInit bool
+	samples    []clockDriftSample
+
+	drift float64
+}
+
+// NewClockDrift creates an estimator for a sender with the nominal clock
+// rate clockRate.
+func NewClockDrift(clockRate uint32) *ClockDrift {
+	return &ClockDrift{
+		nominal: float64(clockRate),
+	}
+}
+
+// OnPacket adds a packet with the RTP timestamp ts which arrived at arrival.
+// It returns true if the estimate was updated.
+func (d *ClockDrift) OnPacket(arrival time.Time, ts uint32) bool {
+	if !d.init {
+		d.init = true
+		d.start = arrival
+		d.lastTimestamp = ts
+	}
+	// keep the highest timestamp, so that reordered packets don't move
+	// the reference
+	if delta := int64(int32(ts - d.lastTimestamp)); delta > 0 {
+		d.timestamp += delta
+		d.lastTimestamp = ts
+	}
+
+	at := arrival.Sub(d.start)
+	timestamp := d.timestamp + int64(int32(ts-d.lastTimestamp))
+	media := time.Duration(float64(timestamp) / d.nominal * float64(time.Second))
+	offset := at - media
+
+	updated := false
+	if d.bucketInit && at-d.bucket.at >= clockDriftBucket {
+		d.samples = append(d.samples, d.bucket)
+		if len(d.samples) > clockDriftBuckets {
+			d.samples = d.samples[1:]
+		}
+		d.bucketInit = false
+		updated = d.estimate()
+	}
+	if !d.bucketInit {
+		d.bucketInit = true
+		d.bucket = clockDriftSample{at: at, minOffset: offset}
+	}
+	if offset < d.bucket.minOffset {
+		d.bucket.minOffset = offset
+	}
+	return updated
+}
+
+// estimate fits a line through the minimum offsets, its slope is the
+// relative drift of the media clock.
+func (d *ClockDrift) estimate() bool {
+	n := float64(len(d.samples))
+	if len(d.samples) < clockDriftMinBuckets {
+		return false
+	}
+	var sx, sy, sxx, sxy float64
+	for _, s := range d.samples {
+		x := s.at.Seconds()
+		y := s.minOffset.Seconds()
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	denominator := n*sxx - sx*sx
+	if denominator == 0 {
+		return false
+	}
+	// the offset grows if the media clock is slow
+	slope := (n*sxy - sx*sy) / denominator
+	max := float64(clockDriftMaxPPM) / 1e6
+	if slope > max {
+		slope = max
+	}
+	if slope < -max {
+		slope = -max
+	}
+	d.drift += clockDriftGain * (-slope - d.drift)
+	return true
+}
+
+// DriftPPM returns the estimated deviation of the media clock from its
+// nominal rate in parts per million. Positive values mean the sender clock
+// runs fast.
+func (d *ClockDrift) DriftPPM() float64 {
+	return d.drift * 1e6
+}
+
+// ClockRate returns the estimated actual clock rate of the sender.
+func (d *ClockDrift) ClockRate() float64 {
+	return d.nominal * (1 + d.drift)
+}
+
+// MediaTime returns the media time of ts relative to the first packet using
+// the estimated clock rate.
+func (d *ClockDrift) MediaTime(ts uint32) time.Duration {
+	timestamp := d.timestamp + int64(int32(ts-d.lastTimestamp))
+	return time.Duration(float64(timestamp) / d.ClockRate() * float64(time.Second))
+}
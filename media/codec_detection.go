@@ -0,0 +1,256 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/pkg/obu"
+)
+
+// maxDetectionPackets is the number of packets buffered while waiting for a
+// packet which reveals the codec.
+const maxDetectionPackets = 1000
+
+var errInvalidCodecMap = errors.New("invalid codec map")
+
+// ParseCodecMap parses a comma separated list of payload type to codec
+// mappings, e.g., "96=h264,97=vp8".
+func ParseCodecMap(s string) (map[uint8]string, error) {
+	m := map[uint8]string{}
+	if len(strings.TrimSpace(s)) == 0 {
+		return m, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%w: expected <payload type>=<codec>, got %q", errInvalidCodecMap, entry)
+		}
+		pt, err := strconv.ParseUint(kv[0], 10, 7)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid payload type %q: %v", errInvalidCodecMap, kv[0], err)
+		}
+		m[uint8(pt)] = strings.ToLower(kv[1])
+	}
+	return m, nil
+}
+
+// DetectCodec inspects an RTP payload and returns the codec if the payload
+// unambiguously identifies it. Only packets starting a key frame of H.264,
+// H.265, VP8 or AV1 are recognized, all other packets return false.
+func DetectCodec(payload []byte) (string, bool) {
+	if isH264ParameterSet(payload) {
+		return "h264", true
+	}
+	if isVP8KeyFrameStart(payload) {
+		return "vp8", true
+	}
+	if isH265ParameterSet(payload) {
+		return "h265", true
+	}
+	if isAV1SequenceStart(payload) {
+		return "av1", true
+	}
+	return "", false
+}
+
+// isH264ParameterSet returns true for single NAL unit, STAP-A and FU-A
+// packets (RFC 6184) carrying or starting an SPS.
+func isH264ParameterSet(payload []byte) bool {
+	const (
+		naluSPS  = 7
+		naluSTAP = 24
+		naluFUA  = 28
+	)
+	if len(payload) < 2 || payload[0]&0x80 != 0 {
+		return false
+	}
+	switch payload[0] & 0x1f {
+	case naluSPS:
+		return true
+	case naluSTAP:
+		if len(payload) < 4 {
+			return false
+		}
+		size := int(payload[1])<<8 | int(payload[2])
+		return size > 0 && 3+size <= len(payload) && payload[3]&0x1f == naluSPS
+	case naluFUA:
+		return payload[1]&0x80 != 0 && payload[1]&0x1f == naluSPS
+	}
+	return false
+}
+
+// isAV1SequenceStart returns true for the first packet of a coded video
+// sequence (N bit set) whose first OBU element is a sequence header.
+func isAV1SequenceStart(payload []byte) bool {
+	// Z clear, N set and the reserved bits 0
+	if len(payload) < 3 || payload[0]&0x80 != 0 || payload[0]&0x08 == 0 || payload[0]&0x07 != 0 {
+		return false
+	}
+	i := 1
+	if payload[0]&0x30 != 0x10 {
+		// the first element has a length field unless it is the only one
+		_, n, err := obu.ReadLeb128(payload[1:])
+		if err != nil {
+			return false
+		}
+		i += int(n)
+	}
+	return i < len(payload) && payload[i]&0x80 == 0 && payload[i]>>3&0x0f == obuSequenceHeader
+}
+
+// isVP8KeyFrameStart returns true for the first packet of a VP8 key frame
+// (RFC 7741), which contains the key frame start code.
+func isVP8KeyFrameStart(payload []byte) bool {
+	if len(payload) < 1 || payload[0]&0x48 != 0 {
+		return false
+	}
+	// only the first partition of a frame starts the VP8 payload header
+	if payload[0]&0x10 == 0 || payload[0]&0x07 != 0 {
+		return false
+	}
+	i := 1
+	if payload[0]&0x80 != 0 {
+		if len(payload) < 2 {
+			return false
+		}
+		x := payload[1]
+		i++
+		if x&0x80 != 0 { // I: picture ID
+			if len(payload) <= i {
+				return false
+			}
+			if payload[i]&0x80 != 0 {
+				i++
+			}
+			i++
+		}
+		if x&0x40 != 0 { // L: TL0PICIDX
+			i++
+		}
+		if x&0x30 != 0 { // T or K: TID/Y/KEYIDX
+			i++
+		}
+	}
+	if len(payload) < i+6 {
+		return false
+	}
+	// P bit of the frame tag is 0 for key frames, followed by the start code
+	// after the 3 byte frame tag.
+	return payload[i]&0x01 == 0 && payload[i+3] == 0x9d && payload[i+4] == 0x01 && payload[i+5] == 0x2a
+}
+
+// AutoCodecSink defers creating the sink until the codec of the received
+// stream is known. The codec is looked up by payload type in the codec map
+// and, if inspection is enabled, detected from the payload. Packets are
+// buffered until the codec is known.
+type AutoCodecSink struct {
+	lock     sync.Mutex
+	dst      string
+	opts     []ConfigOption
+	codecs   map[uint8]string
+	inspect  bool
+	fallback string
+
+	buffered [][]byte
+	sink     Sink
+	playing  bool
+	closed   bool
+}
+
+// NewAutoCodecSink creates a sink for dst. If the codec can't be determined
+// after maxDetectionPackets packets, fallback is used.
+func NewAutoCodecSink(dst string, codecs map[uint8]string, inspect bool, fallback string, opts ...ConfigOption) *AutoCodecSink {
+	return &AutoCodecSink{
+		dst:      dst,
+		opts:     opts,
+		codecs:   codecs,
+		inspect:  inspect,
+		fallback: fallback,
+	}
+}
+
+func (s *AutoCodecSink) Write(buf []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.sink != nil {
+		return s.sink.Write(buf)
+	}
+	if s.closed {
+		return len(buf), nil
+	}
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(buf); err != nil {
+		return 0, err
+	}
+	pktCopy := make([]byte, len(buf))
+	copy(pktCopy, buf)
+	s.buffered = append(s.buffered, pktCopy)
+
+	codec, ok := s.codecs[pkt.PayloadType]
+	if ok {
+		log.Printf("using codec %v for payload type %v", codec, pkt.PayloadType)
+	} else if s.inspect {
+		if codec, ok = DetectCodec(pkt.Payload); ok {
+			log.Printf("detected codec %v from payload of packet with payload type %v", codec, pkt.PayloadType)
+		}
+	}
+	if !ok {
+		if len(s.buffered) < maxDetectionPackets {
+			return len(buf), nil
+		}
+		codec = s.fallback
+		log.Printf("failed to detect codec after %v packets, using %v", len(s.buffered), codec)
+	}
+	if err := s.createSink(codec, pkt.PayloadType); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (s *AutoCodecSink) createSink(codec string, pt uint8) error {
+	opts := append(append([]ConfigOption{}, s.opts...), Codec(codec), PayloadType(pt))
+	sink, err := NewSink(s.dst, opts...)
+	if err != nil {
+		return err
+	}
+	s.sink = sink
+	if s.playing {
+		if err := sink.Play(); err != nil {
+			return err
+		}
+	}
+	for _, b := range s.buffered {
+		if _, err := sink.Write(b); err != nil {
+			return err
+		}
+	}
+	s.buffered = nil
+	return nil
+}
+
+func (s *AutoCodecSink) Play() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.playing = true
+	if s.sink != nil {
+		return s.sink.Play()
+	}
+	return nil
+}
+
+func (s *AutoCodecSink) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	s.buffered = nil
+	if s.sink != nil {
+		return s.sink.Stop()
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package media
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/codecs"
@@ -9,6 +11,64 @@ import (
 
 type ConfigOption func(*Config) error
 
+const (
+	// Opus is the audio codec.
+	Opus = "opus"
+	// OpusClockRate is the RTP clock rate of Opus.
+	OpusClockRate = 48000
+	// OpusPayloadType is the default payload type of audio streams sent
+	// alongside video.
+	OpusPayloadType = 111
+	// Bitrates in bit/s supported by the Opus encoder.
+	minOpusBitrate = 6_000
+	maxOpusBitrate = 510_000
+)
+
+func clampOpusBitrate(r uint) uint {
+	if r < minOpusBitrate {
+		return minOpusBitrate
+	}
+	if r > maxOpusBitrate {
+		return maxOpusBitrate
+	}
+	return r
+}
+
+// Media backends creating the pipelines of sources and sinks.
+const (
+	BackendGstreamer = "gstreamer"
+	BackendFFmpeg    = "ffmpeg"
+)
+
+// Backends are the known media backends.
+var Backends = []string{BackendGstreamer, BackendFFmpeg}
+
+// Content hints of video sources, which select the encoder presets.
+const (
+	ContentCamera = "camera"
+	// ContentScreen is screen content such as text and slides, encoded with
+	// presets keeping sharp edges at low latency.
+	ContentScreen = "screen"
+)
+
+// Video encoders. The hardware encoders support H.264 and H.265.
+const (
+	EncoderSoftware = "software"
+	// EncoderVAAPI encodes on Intel and AMD GPUs using VA-API.
+	EncoderVAAPI = "vaapi"
+	// EncoderNVENC encodes on NVIDIA GPUs.
+	EncoderNVENC = "nvenc"
+)
+
+// Encoders are the known video encoders.
+var Encoders = []string{EncoderSoftware, EncoderVAAPI, EncoderNVENC}
+
+// Screen capture sources.
+const (
+	ScreenSourceX11      = "ximagesrc"
+	ScreenSourcePipewire = "pipewiresrc"
+)
+
 type Config struct {
 	targetBitrate uint
 	ssrc          uint32
@@ -16,6 +76,17 @@ type Config struct {
 	payloadType   uint8
 	clockRate     uint32
 	codec         string
+	width         uint
+	height        uint
+	framerate     uint
+	rtspLatency   time.Duration
+	rtspTCP       bool
+	backend       string
+	content       string
+	encoder       string
+	adapt         bool
+	syncodec      SyncodecModel
+	quality       *QualityScorer
 }
 
 func newConfig(opts ...ConfigOption) (*Config, error) {
@@ -26,6 +97,11 @@ func newConfig(opts ...ConfigOption) (*Config, error) {
 		payloadType:   96,
 		clockRate:     90000,
 		codec:         "h264",
+		rtspLatency:   200 * time.Millisecond,
+		backend:       BackendGstreamer,
+		content:       ContentCamera,
+		encoder:       EncoderSoftware,
+		syncodec:      DefaultSyncodecModel(),
 	}
 	for _, opt := range opts {
 		if err := opt(c); err != nil {
@@ -70,26 +146,181 @@ func ClockRate(r uint32) ConfigOption {
 	}
 }
 
+// Codec sets the codec and the RTP clock rate of the codec.
 func Codec(codec string) ConfigOption {
 	return func(c *Config) error {
 		c.codec = codec
+		c.clockRate = CodecClockRate(codec)
+		return nil
+	}
+}
+
+// CodecClockRate returns the RTP clock rate of codec, OpusClockRate for Opus
+// and 90 kHz for video.
+func CodecClockRate(codec string) uint32 {
+	if codec == Opus {
+		return OpusClockRate
+	}
+	return 90000
+}
+
+// Resolution scales the video to width x height before encoding. The
+// resolution of the source is kept if either is 0.
+func Resolution(width, height uint) ConfigOption {
+	return func(c *Config) error {
+		c.width = width
+		c.height = height
+		return nil
+	}
+}
+
+// Framerate converts the video to fps frames per second before encoding. The
+// frame rate of the source is kept if fps is 0.
+func Framerate(fps uint) ConfigOption {
+	return func(c *Config) error {
+		c.framerate = fps
+		return nil
+	}
+}
+
+// Adaptation lets the target bitrate select the resolution and frame rate of
+// the video, starting at Resolution and Framerate or 1280x720 at 30 fps if
+// they are not set. Only Gstreamer sources support adaptation.
+func Adaptation(enabled bool) ConfigOption {
+	return func(c *Config) error {
+		c.adapt = enabled
+		return nil
+	}
+}
+
+// Quality scores the decoded video of Gstreamer sinks with s.
+func Quality(s *QualityScorer) ConfigOption {
+	return func(c *Config) error {
+		c.quality = s
 		return nil
 	}
 }
 
+// RTSP configures RTSP sources: latency is the buffer of the received stream,
+// tcp interleaves RTP in the RTSP connection instead of using UDP, e.g., to
+// pass firewalls.
+func RTSP(latency time.Duration, tcp bool) ConfigOption {
+	return func(c *Config) error {
+		if latency < 0 {
+			return fmt.Errorf("negative RTSP latency %v", latency)
+		}
+		c.rtspLatency = latency
+		c.rtspTCP = tcp
+		return nil
+	}
+}
+
+// Backend selects the media backend of sinks created by NewSink and
+// AutoCodecSink.
+func Backend(name string) ConfigOption {
+	return func(c *Config) error {
+		switch name {
+		case BackendGstreamer, BackendFFmpeg:
+			c.backend = name
+			return nil
+		}
+		return fmt.Errorf("unknown media backend %v, expected one of %v", name, Backends)
+	}
+}
+
+// Content sets the content hint of the video, ContentCamera or
+// ContentScreen.
+func Content(hint string) ConfigOption {
+	return func(c *Config) error {
+		switch hint {
+		case ContentCamera, ContentScreen:
+			c.content = hint
+			return nil
+		}
+		return fmt.Errorf("unknown content hint %v, expected %v or %v", hint, ContentCamera, ContentScreen)
+	}
+}
+
+// Encoder selects the video encoder, EncoderSoftware or one of the hardware
+// encoders EncoderVAAPI and EncoderNVENC for H.264 and H.265.
+func Encoder(name string) ConfigOption {
+	return func(c *Config) error {
+		switch name {
+		case EncoderSoftware, EncoderVAAPI, EncoderNVENC:
+			c.encoder = name
+			return nil
+		}
+		return fmt.Errorf("unknown encoder %v, expected one of %v", name, Encoders)
+	}
+}
+
+// checkHardwareEncoder returns an error if the hardware encoder of c does not
+// support the codec of c.
+func (c *Config) checkHardwareEncoder() error {
+	if c.encoder == EncoderSoftware {
+		return nil
+	}
+	if c.codec != "h264" && c.codec != "h265" {
+		return fmt.Errorf("the %v encoder supports the codecs h264 and h265 only, got %v", c.encoder, c.codec)
+	}
+	return nil
+}
+
+// IsScreenCapture returns whether src captures the screen, 'ximagesrc' on
+// X11 or 'pipewiresrc' on Wayland.
+func IsScreenCapture(src string) bool {
+	return src == ScreenSourceX11 || src == ScreenSourcePipewire
+}
+
+// IsRTSP returns whether src is the URL of an RTSP stream.
+func IsRTSP(src string) bool {
+	return strings.HasPrefix(src, "rtsp://") || strings.HasPrefix(src, "rtsps://")
+}
+
 func payloaderForCodec(codec string) (rtp.Payloader, error) {
 	switch codec {
 	case "h264":
-		return &codecs.H264Payloader{}, nil
+		return &h264Payloader{}, nil
 	case "vp8":
 		return &codecs.VP8Payloader{
 			EnablePictureID: true,
 		}, nil
+	case "h265":
+		return &h265Payloader{}, nil
 	case "vp9":
 		return &codecs.VP9Payloader{}, nil
 	case "av1":
-		return &codecs.AV1Payloader{}, nil
+		return &av1Payloader{}, nil
+	case Opus:
+		return &opusPayloader{}, nil
 	default:
 		return nil, fmt.Errorf("the requested codec %v does not have a payloader", codec)
 	}
 }
+
+// h264Payloader copies frames carrying an SPS or PPS before passing them to
+// codecs.H264Payloader, which keeps references to parameter sets until the
+// next IDR slice, while the sources reuse the frame memory.
+type h264Payloader struct {
+	codecs.H264Payloader
+}
+
+func (p *h264Payloader) Payload(mtu uint, frame []byte) [][]byte {
+	for _, nalu := range splitAnnexB(frame) {
+		if len(nalu) > 0 && (nalu[0]&0x1f == 7 || nalu[0]&0x1f == 8) {
+			frame = append([]byte(nil), frame...)
+			break
+		}
+	}
+	return p.H264Payloader.Payload(mtu, frame)
+}
+
+// opusPayloader adapts codecs.OpusPayloader, which takes the MTU as uint16,
+// to rtp.Payloader.
+type opusPayloader struct {
+	codecs.OpusPayloader
+}
+
+func (p *opusPayloader) Payload(mtu uint, payload []byte) [][]byte {
+	return p.OpusPayloader.Payload(uint16(mtu), payload)
+}
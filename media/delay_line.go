@@ -0,0 +1,94 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"time"
+)
+
+// delayLineCapacity is the number of packets a DelayLine holds, about a
+// second of high bitrate video.
+const delayLineCapacity = 4096
+
+type delayedPacket struct {
+	buf     []byte
+	release time.Time
+}
+
+// DelayLine holds back RTP packets before writing them to a sink, e.g., to
+// play a stream in sync with another stream arriving later. The delay of
+// each packet is looked up by its SSRC when it is written. Packets keep
+// their order when the delay decreases. Packets are dropped if the line is
+// full.
+type DelayLine struct {
+	writer  io.Writer
+	delay   func(ssrc uint32) time.Duration
+	packets chan delayedPacket
+	last    time.Time
+	close   chan struct{}
+	done    chan struct{}
+}
+
+func NewDelayLine(writer io.Writer, delay func(ssrc uint32) time.Duration) *DelayLine {
+	l := &DelayLine{
+		writer:  writer,
+		delay:   delay,
+		packets: make(chan delayedPacket, delayLineCapacity),
+		close:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.loop()
+	return l
+}
+
+// Write queues a copy of the RTP packet buf. It must not be called
+// concurrently.
+func (l *DelayLine) Write(buf []byte) (int, error) {
+	var delay time.Duration
+	if len(buf) >= 12 {
+		delay = l.delay(binary.BigEndian.Uint32(buf[8:12]))
+	}
+	release := time.Now().Add(delay)
+	if release.Before(l.last) {
+		release = l.last
+	}
+	l.last = release
+	pkt := delayedPacket{
+		buf:     append([]byte(nil), buf...),
+		release: release,
+	}
+	select {
+	case l.packets <- pkt:
+	default:
+		log.Printf("delay line full, dropping packet")
+	}
+	return len(buf), nil
+}
+
+func (l *DelayLine) loop() {
+	defer close(l.done)
+	for {
+		select {
+		case <-l.close:
+			return
+		case pkt := <-l.packets:
+			if wait := time.Until(pkt.release); wait > 0 {
+				select {
+				case <-l.close:
+					return
+				case <-time.After(wait):
+				}
+			}
+			if _, err := l.writer.Write(pkt.buf); err != nil {
+				log.Printf("failed to write delayed packet: %v", err)
+			}
+		}
+	}
+}
+
+func (l *DelayLine) Close() error {
+	close(l.close)
+	<-l.done
+	return nil
+}
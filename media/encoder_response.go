@@ -0,0 +1,84 @@
+package media
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// responseSettleTime is the time an encoder gets to adapt its output to
+	// a new target bitrate before the output rate is measured.
+	responseSettleTime = 500 * time.Millisecond
+	// responseWindow is the time the output rate is averaged over.
+	responseWindow = time.Second
+	// responseTolerance is the relative deviation of the output rate from
+	// the target bitrate up to which the encoder is considered to follow
+	// the target.
+	responseTolerance = 0.5
+	// responseMinChange is the relative change of the target bitrate that
+	// starts a new measurement, smaller changes are within the noise of the
+	// output rate.
+	responseMinChange = 0.1
+)
+
+// bitrateResponse verifies that the output of an encoder follows changes of
+// the target bitrate. It measures the output rate in a window after each
+// significant change and logs encoders that don't respond. Changes arriving
+// before a measurement completed restart it.
+type bitrateResponse struct {
+	encoder string
+
+	lock     sync.Mutex
+	target   uint
+	changed  time.Time
+	measured bool
+	bytes    int
+}
+
+func newBitrateResponse(encoder string, target uint) *bitrateResponse {
+	return &bitrateResponse{
+		encoder:  encoder,
+		target:   target,
+		measured: true,
+	}
+}
+
+// setTarget records the change of the target bitrate to bitrate at now.
+func (r *bitrateResponse) setTarget(bitrate uint, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	change := float64(bitrate) - float64(r.target)
+	if change < 0 {
+		change = -change
+	}
+	if r.target > 0 && change < responseMinChange*float64(r.target) {
+		return
+	}
+	r.target = bitrate
+	r.changed = now
+	r.measured = false
+	r.bytes = 0
+}
+
+// onFrame adds size bytes of encoder output at now.
+func (r *bitrateResponse) onFrame(size int, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.measured {
+		return
+	}
+	start := r.changed.Add(responseSettleTime)
+	if now.Before(start) {
+		return
+	}
+	if now.Before(start.Add(responseWindow)) {
+		r.bytes += size
+		return
+	}
+	r.measured = true
+	rate := float64(r.bytes*8) / responseWindow.Seconds()
+	if rate < (1-responseTolerance)*float64(r.target) || rate > (1+responseTolerance)*float64(r.target) {
+		log.Printf("%v encoder does not follow the target bitrate: %.0f bit/s output %v after the change to %v bit/s", r.encoder, rate, responseSettleTime+responseWindow, r.target)
+	}
+}
@@ -0,0 +1,725 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/pion/interceptor"
+	pionrtp "github.com/pion/rtp"
+)
+
+// The FFmpeg backend runs the ffmpeg and ffplay binaries, which have to be
+// in the PATH. Sources decode the input to raw video in one process and
+// encode it in another one. FFmpeg can't change the bitrate of a running
+// encoder, so the encoder process is restarted with the new target bitrate,
+// at most once per ffmpegRestartInterval and only if the target changed by
+// ffmpegRestartThreshold.
+const (
+	ffmpegFramerate        = 30
+	ffmpegDefaultWidth     = 1280
+	ffmpegDefaultHeight    = 720
+	ffmpegRestartInterval  = time.Second
+	ffmpegRestartThreshold = 0.1
+)
+
+// vaapiDevice is the DRM render node of the VA-API encoders.
+const vaapiDevice = "/dev/dri/renderD128"
+
+var errEncoderExited = errors.New("ffmpeg encoder exited")
+
+// FFmpegSource encodes 'videotestsrc', the X11 screen ('ximagesrc'), a
+// camera, an RTSP URL or a file using FFmpeg and packetizes the frames in Go.
+// The video is scaled to the resolution given by Resolution, 1280x720 by
+// default, at the frame rate given by Framerate, 30 fps by default.
+type FFmpegSource struct {
+	Config
+	src       string
+	rtpWriter interceptor.RTPWriter
+
+	lock        sync.Mutex
+	target      uint
+	encoderRate uint
+	restarted   time.Time
+
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+func NewFFmpegSource(rtpWriter interceptor.RTPWriter, src string, opts ...ConfigOption) (*FFmpegSource, error) {
+	if len(src) == 0 {
+		return nil, fmt.Errorf("invalid source string: %v, use 'videotestsrc', an RTSP URL or a valid filename instead", src)
+	}
+	c, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.codec == Opus {
+		return nil, fmt.Errorf("the ffmpeg backend supports video sources only, got codec %v", c.codec)
+	}
+	if src == ScreenSourcePipewire {
+		return nil, fmt.Errorf("the ffmpeg backend captures the screen with %v only", ScreenSourceX11)
+	}
+	if _, err := payloaderForCodec(c.codec); err != nil {
+		return nil, err
+	}
+	if err := c.checkHardwareEncoder(); err != nil {
+		return nil, err
+	}
+	if c.adapt {
+		return nil, fmt.Errorf("the ffmpeg backend does not support resolution and frame rate adaptation")
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, err
+	}
+	if c.width == 0 || c.height == 0 {
+		c.width, c.height = ffmpegDefaultWidth, ffmpegDefaultHeight
+	}
+	if c.framerate == 0 {
+		c.framerate = ffmpegFramerate
+	}
+	return &FFmpegSource{
+		Config:    *c,
+		src:       src,
+		rtpWriter: rtpWriter,
+		target:    c.targetBitrate,
+		close:     make(chan struct{}),
+	}, nil
+}
+
+// captureArgs are the arguments of the process decoding the source to raw
+// frames on Stdout.
+func (s *FFmpegSource) captureArgs() []string {
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	switch {
+	case s.src == "videotestsrc":
+		args = append(args, "-re", "-f", "lavfi", "-i", fmt.Sprintf("testsrc=size=%vx%v:rate=%v", s.width, s.height, s.framerate))
+	case s.src == ScreenSourceX11:
+		display := os.Getenv("DISPLAY")
+		if len(display) == 0 {
+			display = ":0"
+		}
+		args = append(args, "-f", "x11grab", "-framerate", strconv.Itoa(int(s.framerate)), "-draw_mouse", "1", "-i", display)
+	case IsCamera(s.src):
+		args = append(args, "-f", "v4l2", "-framerate", strconv.Itoa(int(s.framerate)), "-i", CameraDevice(s.src))
+	case IsRTSP(s.src):
+		transport := "udp"
+		if s.rtspTCP {
+			transport = "tcp"
+		}
+		args = append(args,
+			"-rtsp_transport", transport,
+			"-max_delay", strconv.FormatInt(s.rtspLatency.Microseconds(), 10),
+			"-i", s.src,
+		)
+	default:
+		args = append(args, "-re", "-i", FilePath(s.src))
+	}
+	return append(args,
+		"-an",
+		"-vf", fmt.Sprintf("scale=%v:%v,fps=%v", s.width, s.height, s.framerate),
+		"-pix_fmt", "yuv420p",
+		"-f", "rawvideo",
+		"pipe:1",
+	)
+}
+
+// encoderArgs are the arguments of the process encoding raw frames read from
+// Stdin at bitrate.
+func (s *FFmpegSource) encoderArgs(bitrate uint) []string {
+	rate := strconv.FormatUint(uint64(bitrate), 10)
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-f", "rawvideo",
+		"-pix_fmt", "yuv420p",
+		"-s", fmt.Sprintf("%vx%v", s.width, s.height),
+		"-r", strconv.Itoa(int(s.framerate)),
+		"-i", "pipe:0",
+	}
+	if s.encoder != EncoderSoftware {
+		return append(s.hardwareEncoderArgs(args, rate), "pipe:1")
+	}
+	switch s.codec {
+	case "h264":
+		preset, tune := "veryfast", "zerolatency"
+		if s.content == ContentScreen {
+			preset, tune = "ultrafast", "stillimage,zerolatency"
+		}
+		// Access unit delimiters separate the frames of the Annex B stream.
+		args = append(args,
+			"-c:v", "libx264",
+			"-preset", preset,
+			"-tune", tune,
+			"-bf", "0",
+			"-x264-params", "aud=1",
+			"-b:v", rate, "-maxrate", rate, "-bufsize", rate,
+			"-f", "h264",
+		)
+	case "h265":
+		preset := "veryfast"
+		if s.content == ContentScreen {
+			preset = "ultrafast"
+		}
+		// parameter sets are repeated with each key frame, so that the
+		// receiver can join at any key frame
+		args = append(args,
+			"-c:v", "libx265",
+			"-preset", preset,
+			"-tune", "zerolatency",
+			"-x265-params", "aud=1:repeat-headers=1:bframes=0:log-level=error",
+			"-b:v", rate, "-maxrate", rate, "-bufsize", rate,
+			"-f", "hevc",
+		)
+	case "vp8", "vp9":
+		encoder := "libvpx"
+		if s.codec == "vp9" {
+			encoder = "libvpx-vp9"
+		}
+		cpuUsed := "4"
+		if s.content == ContentScreen {
+			cpuUsed = "8"
+		}
+		args = append(args,
+			"-c:v", encoder,
+			"-deadline", "realtime",
+			"-cpu-used", cpuUsed,
+			"-error-resilient", "1",
+			"-lag-in-frames", "0",
+			"-b:v", rate,
+		)
+		if s.content == ContentScreen {
+			if s.codec == "vp9" {
+				args = append(args, "-tune-content", "screen")
+			} else {
+				args = append(args, "-screen-content-mode", "1")
+			}
+		}
+		args = append(args, "-f", "ivf")
+	case "av1":
+		args = append(args,
+			"-c:v", "libaom-av1",
+			"-usage", "realtime",
+			"-cpu-used", "8",
+			"-lag-in-frames", "0",
+			"-b:v", rate,
+			"-f", "ivf",
+		)
+	}
+	return append(args, "pipe:1")
+}
+
+// hardwareEncoderArgs appends the arguments of the hardware encoder of s at
+// rate to the input arguments args. Like libx264 and libx265, the encoders
+// write access unit delimiters and no B-frames.
+func (s *FFmpegSource) hardwareEncoderArgs(args []string, rate string) []string {
+	format := "h264"
+	if s.codec == "h265" {
+		format = "hevc"
+	}
+	if s.encoder == EncoderVAAPI {
+		// the device is a global option and has to precede the input
+		args = append([]string{"-vaapi_device", vaapiDevice}, args...)
+		return append(args,
+			"-vf", "format=nv12,hwupload",
+			"-c:v", format+"_vaapi",
+			"-rc_mode", "CBR",
+			"-bf", "0",
+			"-aud", "1",
+			"-b:v", rate, "-maxrate", rate,
+			"-f", format,
+		)
+	}
+	return append(args,
+		"-c:v", format+"_nvenc",
+		"-preset", "p1",
+		"-tune", "ull",
+		"-zerolatency", "1",
+		"-rc", "cbr",
+		"-bf", "0",
+		"-aud", "1",
+		"-b:v", rate, "-maxrate", rate, "-bufsize", rate,
+		"-f", format,
+	)
+}
+
+func (s *FFmpegSource) Play() error {
+	payloader, err := payloaderForCodec(s.codec)
+	if err != nil {
+		return err
+	}
+	packetizer := pionrtp.NewPacketizer(s.payloadType, s.ssrc, payloader, pionrtp.NewFixedSequencer(0), s.clockRate)
+
+	capture := exec.Command("ffmpeg", s.captureArgs()...)
+	capture.Stderr = os.Stderr
+	raw, err := capture.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	log.Printf("src: ffmpeg %v", capture.Args[1:])
+	if err := capture.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.close:
+		case <-done:
+		}
+		if err := capture.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			log.Printf("failed to stop ffmpeg: %v", err)
+		}
+	}()
+	var enc *ffmpegEncoder
+	defer func() {
+		if enc != nil {
+			if err := enc.close(); err != nil {
+				log.Printf("ffmpeg encoder failed: %v", err)
+			}
+		}
+		// exits by the kill above
+		_ = capture.Wait()
+	}()
+	frame := make([]byte, s.width*s.height*3/2)
+	for {
+		if _, err := io.ReadFull(raw, frame); err != nil {
+			select {
+			case <-s.close:
+				return nil
+			default:
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+		if rate, restart := s.encoderBitrate(enc == nil); restart {
+			if enc != nil {
+				if err := enc.close(); err != nil {
+					return err
+				}
+			}
+			enc, err = s.startEncoder(rate, packetizer)
+			if err != nil {
+				return err
+			}
+		}
+		if err := enc.write(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// encoderBitrate returns the bitrate to restart the encoder with and whether
+// to restart it. The encoder is always started if force is set.
+func (s *FFmpegSource) encoderBitrate(force bool) (uint, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !force {
+		if s.target == s.encoderRate || time.Since(s.restarted) < ffmpegRestartInterval {
+			return 0, false
+		}
+		diff := float64(s.target) - float64(s.encoderRate)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < ffmpegRestartThreshold*float64(s.encoderRate) {
+			return 0, false
+		}
+	}
+	s.encoderRate = s.target
+	s.restarted = time.Now()
+	return s.encoderRate, true
+}
+
+func (s *FFmpegSource) startEncoder(bitrate uint, packetizer pionrtp.Packetizer) (*ffmpegEncoder, error) {
+	cmd := exec.Command("ffmpeg", s.encoderArgs(bitrate)...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	e := &ffmpegEncoder{
+		cmd:   cmd,
+		stdin: stdin,
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(e.done)
+		first := true
+		read := readIVFFrames
+		switch s.codec {
+		case "h264":
+			read = readAccessUnits(audStartCode)
+		case "h265":
+			read = readAccessUnits(h265AUDStartCode)
+		}
+		e.err = read(stdout, func(frame []byte) error {
+			keyFrame := first || isKeyFrame(s.codec, frame)
+			first = false
+			return s.writeFrame(packetizer, frame, keyFrame)
+		})
+		if e.err != nil {
+			// unblock writes of frames the encoder doesn't read anymore
+			_ = cmd.Process.Kill()
+		}
+	}()
+	return e, nil
+}
+
+// writeFrame packetizes frame and writes the packets.
+func (s *FFmpegSource) writeFrame(packetizer pionrtp.Packetizer, frame []byte, keyFrame bool) error {
+	attributes := interceptor.Attributes{
+		rtp.FRAME: rtp.FrameInfo{
+			Duration: time.Second / time.Duration(s.framerate),
+			KeyFrame: keyFrame,
+			Size:     len(frame),
+			Capture:  time.Now(),
+		},
+	}
+	attributes.Set(rtp.RELIABILITY, rtp.NOT_REQUIRED)
+	if keyFrame {
+		attributes.Set(rtp.RELIABILITY, rtp.REQUIRED)
+	}
+	for _, pkt := range packetizer.Packetize(s.mtu, frame, s.clockRate/uint32(s.framerate)) {
+		if _, err := s.rtpWriter.Write(&pkt.Header, pkt.Payload, attributes); err != nil {
+			log.Printf("rtpWriter.Write error: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FFmpegSource) Stop() error {
+	s.closeOnce.Do(func() {
+		close(s.close)
+	})
+	return nil
+}
+
+func (s *FFmpegSource) SetTargetBitsPerSecond(bitrate uint) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.target = bitrate
+}
+
+// GetTargetBitsPerSecond returns the bitrate the running encoder was started
+// with.
+func (s *FFmpegSource) GetTargetBitsPerSecond() uint {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.encoderRate
+}
+
+// ffmpegEncoder is one encoder process of an FFmpegSource.
+type ffmpegEncoder struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	done  chan struct{}
+	// err is the error of the output, valid once done is closed.
+	err error
+}
+
+func (e *ffmpegEncoder) write(frame []byte) error {
+	select {
+	case <-e.done:
+		if e.err != nil {
+			return e.err
+		}
+		return errEncoderExited
+	default:
+	}
+	_, err := e.stdin.Write(frame)
+	return err
+}
+
+// close ends the input of the encoder and waits until the encoded frames
+// are written.
+func (e *ffmpegEncoder) close() error {
+	if err := e.stdin.Close(); err != nil {
+		return err
+	}
+	<-e.done
+	if err := e.cmd.Wait(); err != nil && e.err == nil {
+		return err
+	}
+	return e.err
+}
+
+// readIVFFrames passes the frames of the IVF stream r to emit.
+func readIVFFrames(r io.Reader, emit func([]byte) error) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 32)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	if string(header[:4]) != "DKIF" {
+		return fmt.Errorf("invalid IVF signature %q", header[:4])
+	}
+	frameHeader := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(br, frameHeader); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		frame := make([]byte, binary.LittleEndian.Uint32(frameHeader[:4]))
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return err
+		}
+		if err := emit(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// audStartCode and h265AUDStartCode are the start code followed by the
+// header of an H.264 and H.265 access unit delimiter NAL unit.
+var (
+	audStartCode     = []byte{0, 0, 1, 9}
+	h265AUDStartCode = []byte{0, 0, 1, h265NALUAUD << 1, 1}
+)
+
+// readAccessUnits returns a reader passing the access units of the Annex B
+// stream r, each starting with the access unit delimiter delimiter, to emit.
+func readAccessUnits(delimiter []byte) func(r io.Reader, emit func([]byte) error) error {
+	return func(r io.Reader, emit func([]byte) error) error {
+		var buf []byte
+		chunk := make([]byte, 64*1024)
+		for {
+			n, err := r.Read(chunk)
+			buf = append(buf, chunk[:n]...)
+			for {
+				// skip the delimiter of the access unit in buf
+				i := bytes.Index(buf[1:], delimiter)
+				if i < 0 {
+					break
+				}
+				au := make([]byte, i+1)
+				copy(au, buf)
+				buf = buf[i+1:]
+				if err := emit(au); err != nil {
+					return err
+				}
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					if len(buf) > 0 {
+						return emit(buf)
+					}
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+// isKeyFrame returns whether frame is a key frame of codec.
+func isKeyFrame(codec string, frame []byte) bool {
+	if len(frame) == 0 {
+		return false
+	}
+	switch codec {
+	case "h264":
+		for i := 0; i+3 < len(frame); i++ {
+			if frame[i] == 0 && frame[i+1] == 0 && frame[i+2] == 1 && frame[i+3]&0x1f == 5 {
+				return true
+			}
+		}
+	case "h265":
+		return isH265KeyFrame(frame)
+	case "av1":
+		return isAV1KeyFrame(frame)
+	case "vp8":
+		return frame[0]&0x01 == 0
+	case "vp9":
+		// frame_marker, profile_low_bit and profile_high_bit, followed by a
+		// reserved bit for profile 3, show_existing_frame and frame_type.
+		b := frame[0]
+		profile := (b>>5)&1 | (b>>4)&1<<1
+		pos := 3
+		if profile == 3 {
+			pos = 2
+		}
+		return b>>pos&1 == 0 && b>>(pos-1)&1 == 0
+	}
+	return false
+}
+
+// FFmpegSink receives the RTP packets with ffplay for 'autovideosink' or
+// writes them to a file using ffmpeg otherwise, see FilePrefix. The packets
+// are passed to the process as UDP on localhost, described by an SDP file.
+type FFmpegSink struct {
+	Config
+	cmd     *exec.Cmd
+	conn    net.PacketConn
+	dst     net.Addr
+	sdpFile string
+}
+
+func NewFFmpegSink(dst string, opts ...ConfigOption) (*FFmpegSink, error) {
+	c, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	var encoding string
+	switch c.codec {
+	case "h264":
+		encoding = "H264"
+	case "h265":
+		encoding = "H265"
+	case "vp8":
+		encoding = "VP8"
+	case "vp9":
+		encoding = "VP9"
+	case "av1":
+		encoding = "AV1"
+	case Opus:
+		encoding = "opus"
+	default:
+		return nil, fmt.Errorf("the requested codec %v is not supported by the ffmpeg sink", c.codec)
+	}
+	bin := "ffmpeg"
+	format := ""
+	if isPlaybackSink(dst) {
+		bin = "ffplay"
+	} else if format, err = sinkFileFormat(dst, c.codec); err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath(bin); err != nil {
+		return nil, err
+	}
+	port, err := freeUDPPort()
+	if err != nil {
+		return nil, err
+	}
+	kind := "video"
+	if c.codec == Opus {
+		kind = "audio"
+		encoding += fmt.Sprintf("/%v/2", c.clockRate)
+	} else {
+		encoding += fmt.Sprintf("/%v", c.clockRate)
+	}
+	sdp := fmt.Sprintf(
+		"v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=roq\r\nc=IN IP4 127.0.0.1\r\nt=0 0\r\nm=%v %v RTP/AVP %v\r\na=rtpmap:%v %v\r\n",
+		kind, port, c.payloadType, c.payloadType, encoding,
+	)
+	if c.codec == "h264" {
+		sdp += fmt.Sprintf("a=fmtp:%v packetization-mode=1\r\n", c.payloadType)
+	}
+	f, err := os.CreateTemp("", "roq-*.sdp")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(sdp); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-protocol_whitelist", "file,udp,rtp",
+		"-fflags", "nobuffer",
+		"-i", f.Name(),
+	}
+	switch format {
+	case "":
+		args = append(args, "-window_title", "roq")
+		if c.codec == Opus {
+			args = append(args, "-nodisp")
+		}
+	case FormatIVF, FormatOGG:
+		args = append(args, "-c", "copy", "-f", format, "-y", FilePath(dst))
+	case FormatMP4:
+		// fragmented, so that the file is valid if ffmpeg is killed
+		args = append(args, "-c", "copy", "-movflags", "frag_keyframe+empty_moov", "-f", "mp4", "-y", FilePath(dst))
+	default:
+		args = append(args, "-pix_fmt", "yuv420p", "-f", "yuv4mpegpipe", "-y", FilePath(dst))
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Stderr = os.Stderr
+	log.Printf("sink: %v %v", bin, args)
+	return &FFmpegSink{
+		Config:  *c,
+		cmd:     cmd,
+		conn:    conn,
+		dst:     &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+		sdpFile: f.Name(),
+	}, nil
+}
+
+// freeUDPPort returns a UDP port on localhost which is currently not in use.
+func freeUDPPort() (int, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
+
+func (s *FFmpegSink) Write(pkt []byte) (int, error) {
+	return s.conn.WriteTo(pkt, s.dst)
+}
+
+func (s *FFmpegSink) Play() error {
+	if err := s.cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %v: %w", s.cmd.Path, err)
+	}
+	return nil
+}
+
+// Stop interrupts the process, so that ffmpeg finishes the file, and
+// removes the SDP file.
+func (s *FFmpegSink) Stop() error {
+	defer os.Remove(s.sdpFile)
+	defer s.conn.Close()
+	if s.cmd.Process == nil {
+		return nil
+	}
+	if err := s.cmd.Process.Signal(os.Interrupt); err != nil {
+		if err := s.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return err
+		}
+	}
+	if err := s.cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return err
+		}
+	}
+	return nil
+}
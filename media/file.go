@@ -0,0 +1,64 @@
+package media
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FilePrefix marks file sources and sinks, e.g., 'file:foreman.y4m'. The
+// format of sinks is selected by the extension of the file.
+const FilePrefix = "file:"
+
+// Formats of file sinks. Y4M files contain the decoded video, e.g., for
+// scoring against a reference, IVF, MP4 and Ogg files the received stream as
+// is.
+const (
+	FormatY4M = "y4m"
+	FormatIVF = "ivf"
+	FormatMP4 = "mp4"
+	FormatOGG = "ogg"
+)
+
+// isPlaybackSink returns whether dst plays the media instead of writing it
+// to a file.
+func isPlaybackSink(dst string) bool {
+	return dst == "autovideosink" || dst == "autoaudiosink"
+}
+
+// FilePath returns the path of the file source or sink name, which is either
+// prefixed by FilePrefix or a plain path.
+func FilePath(name string) string {
+	return strings.TrimPrefix(name, FilePrefix)
+}
+
+// sinkFileFormat returns the format of the file sink dst for codec. Plain
+// paths are written as Y4M, or Ogg for Opus.
+func sinkFileFormat(dst, codec string) (string, error) {
+	if !strings.HasPrefix(dst, FilePrefix) {
+		if codec == Opus {
+			return FormatOGG, nil
+		}
+		return FormatY4M, nil
+	}
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(dst), "."))
+	var codecs []string
+	switch format {
+	case FormatY4M:
+		codecs = []string{"h264", "h265", "vp8", "vp9", "av1"}
+	case FormatIVF:
+		codecs = []string{"vp8", "vp9", "av1"}
+	case FormatMP4:
+		codecs = []string{"h264", "h265", "vp9", "av1", Opus}
+	case FormatOGG:
+		codecs = []string{Opus}
+	default:
+		return "", fmt.Errorf("unknown format of file sink %v, use the extension %v, %v, %v or %v", dst, FormatY4M, FormatIVF, FormatMP4, FormatOGG)
+	}
+	for _, c := range codecs {
+		if c == codec {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("%v files can't contain codec %v, supported are %v", format, codec, codecs)
+}
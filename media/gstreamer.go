@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 package media
 
 import (
@@ -7,114 +10,321 @@ import (
 	"math"
 	"time"
 
-	"github.com/mengelbart/gst-go/gstreamer"
+	"github.com/Willi-42/rtp-over-quic/gst"
 	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/Willi-42/rtp-over-quic/tracing"
 	"github.com/pion/interceptor"
 	pionrtp "github.com/pion/rtp"
-	"github.com/pion/webrtc/v3/pkg/media/h264reader"
 )
 
 type mtuGetter interface {
-	getMTU(gstreamer.Buffer) uint
+	getMTU(gst.Frame) uint
 }
 
 // TODO: If usefule, make this configurable?
 const teeLiveVideo = false // if set, displays source video in autovideosink
 
+// screenFramerate is the frame rate of X11 screen capture.
+const screenFramerate = 30
+
 type GstreamerSource struct {
 	Config
 	src              string
-	pipeline         *gstreamer.Pipeline
+	pipeline         *gst.Pipeline
 	rtpWriter        interceptor.RTPWriter
 	useGstPacketizer bool
 	close            chan struct{}
+
+	// response is set for hardware encoders, whose rate control differs
+	// between drivers.
+	response *bitrateResponse
+	// adapt is set if the target bitrate selects the resolution and frame
+	// rate.
+	adapt *adaptation
 }
 
 func NewGstreamerSource(rtpWriter interceptor.RTPWriter, src string, useGstPacketizer bool, opts ...ConfigOption) (*GstreamerSource, error) {
 	if len(src) == 0 {
-		return nil, fmt.Errorf("invalid source string: %v, use 'videotestsrc' or a valid filename instead", src)
+		return nil, fmt.Errorf("invalid source string: %v, use 'videotestsrc', 'ximagesrc', 'pipewiresrc', a camera, an RTSP URL or a valid filename instead", src)
 	}
 
 	c, err := newConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
-	builder := gstreamer.Elements{}
+	if c.codec == Opus {
+		builder, err := opusSourceElements(src, c, useGstPacketizer)
+		if err != nil {
+			return nil, err
+		}
+		return newGstreamerSource(rtpWriter, src, useGstPacketizer, c, builder)
+	}
+	builder := gst.Elements{}
 
 	if src == "videotestsrc" {
 		builder = append(builder,
-			gstreamer.NewElement("videotestsrc"),
+			gst.NewElement("videotestsrc"),
+		)
+	} else if src == ScreenSourceX11 {
+		// damage events would only save work on static screens, but make
+		// the frame rate irregular
+		builder = append(builder,
+			gst.NewElement(ScreenSourceX11, gst.Set("use-damage", false), gst.Set("show-pointer", true)),
+			gst.NewElement(fmt.Sprintf("video/x-raw,framerate=%v/1", screenFramerate)),
+			gst.NewElement("videoconvert"),
+		)
+	} else if src == ScreenSourcePipewire {
+		builder = append(builder,
+			gst.NewElement(ScreenSourcePipewire, gst.Set("do-timestamp", true)),
+			gst.NewElement("videoconvert"),
+		)
+	} else if IsCamera(src) {
+		// decodebin negotiates raw and MJPEG output of the camera, the
+		// timestamps are taken on capture
+		builder = append(builder,
+			gst.NewElement("v4l2src", gst.Set("device", CameraDevice(src)), gst.Set("do-timestamp", true)),
+			gst.NewElement("decodebin"),
+			gst.NewElement("videoconvert"),
+		)
+	} else if IsRTSP(src) {
+		// The stream of the camera is decoded and encoded again, so that
+		// the congestion controller can set the bitrate.
+		rtspSettings := []gst.ElementOption{
+			// quoted, since URLs may contain characters such as '&'
+			gst.Set("location", fmt.Sprintf("%q", src)),
+			gst.Set("latency", c.rtspLatency.Milliseconds()),
+		}
+		if c.rtspTCP {
+			rtspSettings = append(rtspSettings, gst.Set("protocols", "tcp"))
+		}
+		builder = append(builder,
+			gst.NewElement("rtspsrc", rtspSettings...),
+			gst.NewElement("decodebin"),
+			gst.NewElement("videoconvert"),
 		)
 	} else {
 		builder = append(builder,
-			gstreamer.NewElement("filesrc", gstreamer.Set("location", src)),
-			gstreamer.NewElement("decodebin"),
+			gst.NewElement("filesrc", gst.Set("location", FilePath(src))),
+			gst.NewElement("decodebin"),
 		)
 	}
 	builder = append(builder,
-		gstreamer.NewElement("clocksync"),
+		gst.NewElement("clocksync"),
 	)
+	if c.adapt {
+		// the caps of the named filter are renegotiated when the target
+		// bitrate selects another resolution or frame rate
+		builder = append(builder,
+			gst.NewElement("videoscale"),
+			gst.NewElement("videorate"),
+			gst.NewElement("capsfilter",
+				gst.Set("name", "adaptation"),
+				gst.Set("caps", fmt.Sprintf("%q", newAdaptation(c).current().caps())),
+			),
+		)
+	} else if c.width > 0 && c.height > 0 {
+		builder = append(builder,
+			gst.NewElement("videoscale"),
+			gst.NewElement(fmt.Sprintf("video/x-raw,width=%v,height=%v", c.width, c.height)),
+		)
+	}
+	if c.framerate > 0 && !c.adapt {
+		builder = append(builder,
+			gst.NewElement("videorate"),
+			gst.NewElement(fmt.Sprintf("video/x-raw,framerate=%v/1", c.framerate)),
+		)
+	}
 
 	if teeLiveVideo {
 		builder = append(builder,
-			gstreamer.NewElement("tee", gstreamer.Set("name", "t")),
-			gstreamer.NewElement("queue"),
-			gstreamer.NewElement("autovideosink t."),
-			gstreamer.NewElement("queue"),
+			gst.NewElement("tee", gst.Set("name", "t")),
+			gst.NewElement("queue"),
+			gst.NewElement("autovideosink t."),
+			gst.NewElement("queue"),
 		)
 	}
 
-	payloaderSettings := []gstreamer.ElementOption{
-		gstreamer.Set("name", "payloader"),
-		gstreamer.Set("mtu", c.mtu),
-		gstreamer.Set("seqnum-offset", 0),
-		gstreamer.Set("ssrc", c.ssrc),
+	payloaderSettings := []gst.ElementOption{
+		gst.Set("name", "payloader"),
+		gst.Set("mtu", c.mtu),
+		gst.Set("seqnum-offset", 0),
+		gst.Set("ssrc", c.ssrc),
+	}
+	if err := c.checkHardwareEncoder(); err != nil {
+		return nil, err
+	}
+	if c.encoder != EncoderSoftware {
+		builder = append(builder, hardwareEncoderElements(c)...)
+		if useGstPacketizer {
+			builder = append(builder, gst.NewElement(fmt.Sprintf("rtp%vpay", c.codec), payloaderSettings...))
+		}
+		return newGstreamerSource(rtpWriter, src, useGstPacketizer, c, builder)
 	}
 	// TODO: Set encoder options including init target bitrate
 	switch c.codec {
 	case "vp8", "vp9":
-		builder = append(builder, gstreamer.NewElement(fmt.Sprintf("%venc", c.codec),
-			gstreamer.Set("name", "encoder"),
-			gstreamer.Set("error-resilient", "default"),
-			gstreamer.Set("cpu-used", 4),
-			gstreamer.Set("deadline", 1),
-			gstreamer.Set("target-bitrate", c.targetBitrate),
-		))
+		encoderSettings := []gst.ElementOption{
+			gst.Set("name", "encoder"),
+			gst.Set("error-resilient", "default"),
+			gst.Set("cpu-used", 4),
+			gst.Set("deadline", 1),
+			gst.Set("target-bitrate", c.targetBitrate),
+		}
+		if c.content == ContentScreen {
+			// the fastest preset keeps up with desktop resolutions, static
+			// blocks are skipped
+			encoderSettings = append(encoderSettings,
+				gst.Set("cpu-used", 8),
+				gst.Set("static-threshold", 100),
+			)
+		}
+		builder = append(builder, gst.NewElement(fmt.Sprintf("%venc", c.codec), encoderSettings...))
 		if useGstPacketizer {
-			builder = append(builder, gstreamer.NewElement(fmt.Sprintf("rtp%vpay", c.codec), payloaderSettings...))
+			builder = append(builder, gst.NewElement(fmt.Sprintf("rtp%vpay", c.codec), payloaderSettings...))
 		}
 	case "h264":
-		builder = append(builder, gstreamer.NewElement("x264enc",
-			gstreamer.Set("name", "encoder"),
-			gstreamer.Set("pass", 5),
-			gstreamer.Set("speed-preset", 4),
-			gstreamer.Set("tune", 4),
-			gstreamer.Set("bitrate", c.targetBitrate/1000),
-			// gstreamer.Set("key-int-max", 10),
-		))
+		encoderSettings := []gst.ElementOption{
+			gst.Set("name", "encoder"),
+			gst.Set("pass", 5),
+			gst.Set("speed-preset", 4),
+			gst.Set("tune", 4),
+			gst.Set("bitrate", c.targetBitrate/1000),
+			// gst.Set("key-int-max", 10),
+		}
+		if c.content == ContentScreen {
+			// ultrafast, tuned for still images and zero latency
+			encoderSettings = append(encoderSettings,
+				gst.Set("speed-preset", 1),
+				gst.Set("tune", 5),
+			)
+		}
+		builder = append(builder, gst.NewElement("x264enc", encoderSettings...))
 		if useGstPacketizer {
-			builder = append(builder, gstreamer.NewElement("rtph264pay", payloaderSettings...))
+			builder = append(builder, gst.NewElement("rtph264pay", payloaderSettings...))
 		}
 	case "h265":
-		builder = append(builder, gstreamer.NewElement("x265enc"))
+		encoderSettings := []gst.ElementOption{
+			gst.Set("name", "encoder"),
+			gst.Set("speed-preset", 3),
+			gst.Set("tune", 4),
+			gst.Set("bitrate", c.targetBitrate/1000),
+			// parameter sets with each key frame, so that the receiver
+			// can join at any key frame
+			gst.Set("option-string", "repeat-headers=1:bframes=0"),
+		}
+		if c.content == ContentScreen {
+			encoderSettings = append(encoderSettings, gst.Set("speed-preset", 1))
+		}
+		builder = append(builder,
+			gst.NewElement("x265enc", encoderSettings...),
+			gst.NewElement("video/x-h265,stream-format=byte-stream,alignment=au"),
+		)
 		if useGstPacketizer {
-			builder = append(builder, gstreamer.NewElement("rtph265pay", payloaderSettings...))
+			builder = append(builder, gst.NewElement("rtph265pay", payloaderSettings...))
 		}
 	case "av1":
-		panic("rtpav1pay is not yet implemented in Gstreamer")
-		//builder = append(builder, gstreamer.NewElement("av1enc"))
-		//if useGstPacketizer {
-		//builder = append(builder, gstreamer.NewElement("rtpav1pay", payloaderSettings...))
-		//}
+		// rtpav1pay is not part of the Gstreamer core plugins, AV1 is
+		// always packetized in Go
+		useGstPacketizer = false
+		builder = append(builder,
+			gst.NewElement("av1enc",
+				gst.Set("name", "encoder"),
+				gst.Set("usage-profile", "realtime"),
+				gst.Set("end-usage", "cbr"),
+				gst.Set("cpu-used", 8),
+				gst.Set("lag-in-frames", 0),
+				gst.Set("target-bitrate", c.targetBitrate/1000),
+			),
+			gst.NewElement("video/x-av1,stream-format=obu-stream,alignment=tu"),
+		)
+	default:
+		return nil, fmt.Errorf("the requested codec %v is not supported by the gstreamer source", c.codec)
+	}
+	return newGstreamerSource(rtpWriter, src, useGstPacketizer, c, builder)
+}
+
+// hardwareEncoderElements returns the elements encoding H.264 or H.265 with
+// the hardware encoder of c. Both encoders are set up for low latency:
+// constant bitrate, no B-frames and, for NVENC, no lookahead.
+func hardwareEncoderElements(c *Config) gst.Elements {
+	var encoder *gst.Element
+	switch c.encoder {
+	case EncoderVAAPI:
+		encoder = gst.NewElement(fmt.Sprintf("vaapi%venc", c.codec),
+			gst.Set("name", "encoder"),
+			gst.Set("rate-control", "cbr"),
+			gst.Set("max-bframes", 0),
+			gst.Set("bitrate", c.targetBitrate/1000),
+		)
+	case EncoderNVENC:
+		encoder = gst.NewElement(fmt.Sprintf("nv%venc", c.codec),
+			gst.Set("name", "encoder"),
+			gst.Set("preset", "low-latency-hq"),
+			gst.Set("rc-mode", "cbr"),
+			gst.Set("zerolatency", true),
+			gst.Set("bframes", 0),
+			gst.Set("bitrate", c.targetBitrate/1000),
+		)
+	}
+	return gst.Elements{
+		// both encoders take NV12 frames from system memory
+		gst.NewElement("videoconvert"),
+		gst.NewElement("video/x-raw,format=NV12"),
+		encoder,
+		gst.NewElement(fmt.Sprintf("video/x-%v,stream-format=byte-stream,alignment=au", c.codec)),
 	}
+}
 
+// opusSourceElements returns the elements capturing and encoding the audio
+// source src: 'audiotestsrc', 'autoaudiosrc', 'pulsesrc', 'alsasrc' or a
+// file.
+func opusSourceElements(src string, c *Config, useGstPacketizer bool) (gst.Elements, error) {
+	if IsCamera(src) {
+		return nil, fmt.Errorf("invalid source %v for codec %v, use 'audiotestsrc', 'autoaudiosrc', 'pulsesrc', 'alsasrc' or a file instead", src, c.codec)
+	}
+	builder := gst.Elements{}
+	switch src {
+	case "audiotestsrc":
+		builder = append(builder, gst.NewElement("audiotestsrc", gst.Set("is-live", true)))
+	case "autoaudiosrc", "pulsesrc", "alsasrc":
+		builder = append(builder, gst.NewElement(src))
+	case "videotestsrc", ScreenSourceX11, ScreenSourcePipewire:
+		return nil, fmt.Errorf("invalid source %v for codec %v, use 'audiotestsrc', 'autoaudiosrc', 'pulsesrc', 'alsasrc' or a file instead", src, c.codec)
+	default:
+		builder = append(builder,
+			gst.NewElement("filesrc", gst.Set("location", FilePath(src))),
+			gst.NewElement("decodebin"),
+			gst.NewElement("clocksync"),
+		)
+	}
 	builder = append(builder,
-		gstreamer.NewElement("appsink", gstreamer.Set("name", "appsink")),
+		gst.NewElement("audioconvert"),
+		gst.NewElement("audioresample"),
+		gst.NewElement("opusenc",
+			gst.Set("name", "encoder"),
+			gst.Set("bitrate", clampOpusBitrate(c.targetBitrate)),
+			gst.Set("frame-size", 20),
+		),
+	)
+	if useGstPacketizer {
+		builder = append(builder, gst.NewElement("rtpopuspay",
+			gst.Set("name", "payloader"),
+			gst.Set("mtu", c.mtu),
+			gst.Set("seqnum-offset", 0),
+			gst.Set("ssrc", c.ssrc),
+		))
+	}
+	return builder, nil
+}
+
+func newGstreamerSource(rtpWriter interceptor.RTPWriter, src string, useGstPacketizer bool, c *Config, builder gst.Elements) (*GstreamerSource, error) {
+	builder = append(builder,
+		gst.NewElement("appsink", gst.Set("name", "appsink")),
 	)
 	pipelineStr := builder.Build()
 	log.Printf("src pipeline: %v", pipelineStr)
 
-	pipeline, err := gstreamer.NewPipeline(pipelineStr)
+	pipeline, err := gst.NewPipeline(pipelineStr)
 	if err != nil {
 		return nil, err
 	}
@@ -126,20 +336,33 @@ func NewGstreamerSource(rtpWriter interceptor.RTPWriter, src string, useGstPacke
 		useGstPacketizer: useGstPacketizer,
 		close:            make(chan struct{}),
 	}
+	if c.encoder != EncoderSoftware {
+		s.response = newBitrateResponse(c.encoder, c.targetBitrate)
+	}
+	if c.adapt && c.codec != Opus {
+		s.adapt = newAdaptation(c)
+		log.Printf("adaptation: starting at %v at target bitrate %v bit/s", s.adapt.current(), c.targetBitrate)
+	}
 	return s, nil
 }
 
 func (s *GstreamerSource) Play() error {
-	bufferCh := make(chan gstreamer.Buffer)
-	s.pipeline.SetBufferHandler(func(b gstreamer.Buffer) {
-		bufferCh <- b
+	frameCh := make(chan capturedFrame)
+	errCh := make(chan error, 1)
+	s.pipeline.SetFrameHandler(func(f gst.Frame) {
+		select {
+		case frameCh <- capturedFrame{Frame: f, at: time.Now()}:
+		case <-s.close:
+		}
 	})
 	s.pipeline.SetEOSHandler(func() {
-		close(bufferCh)
+		close(frameCh)
 	})
 	s.pipeline.SetErrorHandler(func(err error) {
-		panic(fmt.Errorf("ERROR: %w", err))
-		// TODO
+		select {
+		case errCh <- err:
+		default:
+		}
 	})
 
 	var packetizer pionrtp.Packetizer
@@ -148,88 +371,140 @@ func (s *GstreamerSource) Play() error {
 		if err != nil {
 			return err
 		}
-		packetizer = pionrtp.NewPacketizer(s.payloadType, s.ssrc, payloader, pionrtp.NewFixedSequencer(0), 90_000)
+		packetizer = pionrtp.NewPacketizer(s.payloadType, s.ssrc, payloader, pionrtp.NewFixedSequencer(0), s.clockRate)
 	}
 
+	// the PTS of live sources is the running time of the pipeline at
+	// capture, which starts about now
+	started := time.Now()
 	go s.pipeline.Start()
+	lastPTS := gst.NoTimestamp
 	for {
 		select {
 		case <-s.close:
 			return nil
-		case buffer, ok := <-bufferCh:
+		case err := <-errCh:
+			return err
+		case captured, ok := <-frameCh:
 			if !ok {
 				return nil
 			}
+			frame := captured.Frame
+			span := tracing.StartAt("frame", captured.at)
+			span.SetAttribute("pts", frame.PTS)
+			span.SetAttribute("keyframe", frame.KeyFrame)
+			span.SetAttribute("bytes", len(frame.Bytes))
+			span.StartAt("capture", captured.at).End()
+			if s.response != nil {
+				s.response.onFrame(len(frame.Bytes), captured.at)
+			}
+
+			info := rtp.FrameInfo{
+				PTS:      frame.PTS,
+				DTS:      frame.DTS,
+				Duration: frame.Duration,
+				KeyFrame: frame.KeyFrame,
+				Capture:  captureTime(started, frame.PTS, captured.at),
+			}
 			if !s.useGstPacketizer {
-				samples := uint32((time.Duration(buffer.Duration).Seconds()) * float64(s.clockRate))
+				// the appsink gets the whole encoded frame
+				info.Size = len(frame.Bytes)
+			}
+			attributes := interceptor.Attributes{
+				rtp.FRAME: info,
+			}
+			if !s.useGstPacketizer {
+				samples := s.samples(frame, lastPTS)
+				lastPTS = frame.PTS
 
-				attributes := interceptor.Attributes{
-					rtp.RELIABILITY: rtp.NOT_REQUIRED,
-				}
+				attributes.Set(rtp.RELIABILITY, rtp.NOT_REQUIRED)
 				mtu := s.mtu
-				if isKeyFrame(s.codec, buffer.Bytes) {
+				if frame.KeyFrame {
 					attributes.Set(rtp.RELIABILITY, rtp.REQUIRED)
 					mtu = math.MaxUint16
 				}
 
-				pkts := packetizer.Packetize(mtu, buffer.Bytes, samples)
+				packetize := span.Start("packetize")
+				pkts := packetizer.Packetize(mtu, frame.Bytes, samples)
+				packetize.End()
+				var err error
 				for _, pkt := range pkts {
-					_, err := s.rtpWriter.Write(&pkt.Header, pkt.Payload, attributes)
-					if err != nil {
-						log.Printf("rtpWriter.Write error: %v", err)
-						return err
+					if err = s.writePacket(span, &pkt.Header, pkt.Payload, attributes); err != nil {
+						break
 					}
 				}
+				// packets may reference the frame until they are written,
+				// payloaders copy what they keep for later frames
+				frame.Release()
+				if err != nil {
+					log.Printf("rtpWriter.Write error: %v", err)
+					return err
+				}
 			} else {
 				var pkt pionrtp.Packet
-				err := pkt.Unmarshal(buffer.Bytes)
+				err := pkt.Unmarshal(frame.Bytes)
 				if err != nil {
 					return err
 				}
-				_, err = s.rtpWriter.Write(&pkt.Header, pkt.Payload, nil)
+				err = s.writePacket(span, &pkt.Header, pkt.Payload, attributes)
+				// the interceptors and transports copy what they keep
+				frame.Release()
 				if err != nil {
 					log.Printf("rtpWriter.Write error: %v", err)
 					return err
 				}
 			}
+			span.End()
 		}
 	}
 }
 
-func isKeyFrame(codec string, buffer []byte) bool {
-	switch codec {
-	case "vp8":
-		return buffer[0]&0x1 == 0
-	case "h264":
-		r, w := io.Pipe()
-		hr, err := h264reader.NewReader(r)
-		if err != nil {
-			panic(err)
-		}
-		go func() {
-			_, verr := w.Write(buffer)
-			if verr != nil {
-				panic(verr)
-			}
-			r.Close()
-		}()
-		hasIDR := false
-		nal, err := hr.NextNAL()
-		for nal != nil && err != io.EOF {
-			if err != nil {
-				panic(err)
-			}
-			nal, err = hr.NextNAL()
-			if nal != nil {
-				if nal.UnitType == h264reader.NalUnitTypeCodedSliceIdr {
-					hasIDR = true
-				}
-			}
-		}
+// capturedFrame is a frame and the time the pipeline handed it over.
+type capturedFrame struct {
+	gst.Frame
+	at time.Time
+}
 
-		return hasIDR
+// captureTime returns the wall clock time a frame with pts was captured by a
+// pipeline started at started. Timestamps of files or unset timestamps, which
+// don't map to the time the frame left the pipeline at, fall back to at.
+func captureTime(started time.Time, pts time.Duration, at time.Time) time.Time {
+	if pts == gst.NoTimestamp {
+		return at
 	}
-	return false
+	capture := started.Add(pts)
+	if capture.After(at) || at.Sub(capture) > time.Second {
+		return at
+	}
+	return capture
+}
+
+// writePacket writes an RTP packet of the frame traced by span, which may be
+// nil.
+func (s *GstreamerSource) writePacket(span *tracing.Span, header *pionrtp.Header, payload []byte, attributes interceptor.Attributes) error {
+	packet := span.Start("packet")
+	defer packet.End()
+	if packet != nil {
+		packet.SetAttribute("ssrc", header.SSRC)
+		packet.SetAttribute("seq", header.SequenceNumber)
+		attributes.Set(rtp.TRACE, packet)
+	}
+	_, err := s.rtpWriter.Write(header, payload, attributes)
+	return err
+}
+
+// samples returns the number of RTP timestamp units the frame lasts. It uses
+// the frame duration if set and falls back to the PTS difference to the
+// previous frame.
+func (s *GstreamerSource) samples(frame gst.Frame, lastPTS time.Duration) uint32 {
+	d := frame.Duration
+	if d == gst.NoTimestamp {
+		if frame.PTS == gst.NoTimestamp || lastPTS == gst.NoTimestamp || frame.PTS < lastPTS {
+			return 0
+		}
+		d = frame.PTS - lastPTS
+	}
+	return uint32(d.Seconds() * float64(s.clockRate))
 }
 
 func (s *GstreamerSource) Stop() error {
@@ -243,15 +518,42 @@ func (s *GstreamerSource) SetTargetBitsPerSecond(bitrate uint) {
 	switch s.codec {
 	case "vp8", "vp9":
 		prop = "target-bitrate"
-	case "h264":
+	case "h264", "h265":
 		value = value / 1000
+	case "av1":
+		prop = "target-bitrate"
+		value = value / 1000
+	case Opus:
+		value = clampOpusBitrate(value)
 	}
 	s.pipeline.SetPropertyUint("encoder", prop, value)
+	if s.adapt != nil {
+		if f, changed := s.adapt.update(bitrate, time.Now()); changed {
+			if err := s.pipeline.SetCaps("adaptation", f.caps()); err != nil {
+				log.Printf("adaptation: %v", err)
+			}
+		}
+	}
+	if s.response != nil {
+		if got := s.pipeline.GetPropertyUint("encoder", prop); got != value {
+			log.Printf("%v encoder rejected bitrate %v kbit/s, still at %v kbit/s", s.encoder, value, got)
+		}
+		s.response.setTarget(bitrate, time.Now())
+	}
+}
+
+// ForceKeyframe makes the encoder encode the next frame as keyframe, e.g.,
+// after a receiver lost the reference frames.
+func (s *GstreamerSource) ForceKeyframe() error {
+	if s.codec == Opus {
+		return nil
+	}
+	return s.pipeline.ForceKeyUnit()
 }
 
 func (s *GstreamerSource) GetTargetBitsPerSecond() uint {
 	prop := "bitrate"
-	if s.codec == "vp8" || s.codec == "vp9" {
+	if s.codec == "vp8" || s.codec == "vp9" || s.codec == "av1" {
 		prop = "target-bitrate"
 	}
 	return s.pipeline.GetPropertyUint("encoder", prop)
@@ -260,7 +562,8 @@ func (s *GstreamerSource) GetTargetBitsPerSecond() uint {
 type GstreamerSink struct {
 	Config
 	io.Writer
-	pipeline *gstreamer.Pipeline
+	pipeline *gst.Pipeline
+	failed   chan error
 }
 
 func NewGstreamerSink(dst string, opts ...ConfigOption) (*GstreamerSink, error) {
@@ -268,77 +571,136 @@ func NewGstreamerSink(dst string, opts ...ConfigOption) (*GstreamerSink, error)
 	if err != nil {
 		return nil, err
 	}
+	format := ""
+	if !isPlaybackSink(dst) {
+		if format, err = sinkFileFormat(dst, c.codec); err != nil {
+			return nil, err
+		}
+	}
 
-	builder := gstreamer.Elements{
-		gstreamer.NewElement("appsrc", gstreamer.Set("name", "src")),
+	builder := gst.Elements{
+		gst.NewElement("appsrc",
+			gst.Set("name", "src"),
+			gst.Set("is-live", true),
+			gst.Set("format", "time"),
+			// stamp packets with their arrival time for rtpjitterbuffer
+			gst.Set("do-timestamp", true),
+		),
 	}
 
-	jitterBufferSettings := []gstreamer.ElementOption{}
+	jitterBufferSettings := []gst.ElementOption{}
 
 	switch c.codec {
 	case "vp8":
 		builder = append(builder,
-			gstreamer.NewElement("application/x-rtp, encoding-name=VP8-DRAFT-IETF-01"),
-			gstreamer.NewElement("rtpjitterbuffer", jitterBufferSettings...),
-			gstreamer.NewElement("rtpvp8depay"),
+			gst.NewElement("application/x-rtp, encoding-name=VP8-DRAFT-IETF-01"),
+			gst.NewElement("rtpjitterbuffer", jitterBufferSettings...),
+			gst.NewElement("rtpvp8depay"),
 		)
 	case "vp9":
 		builder = append(builder,
-			gstreamer.NewElement("application/x-rtp, encoding-name=VP9-DRAFT-IETF-01"),
-			gstreamer.NewElement("rtpjitterbuffer", jitterBufferSettings...),
-			gstreamer.NewElement("rtpvp9depay"),
+			gst.NewElement("application/x-rtp, encoding-name=VP9-DRAFT-IETF-01"),
+			gst.NewElement("rtpjitterbuffer", jitterBufferSettings...),
+			gst.NewElement("rtpvp9depay"),
 		)
 	case "h264":
 		builder = append(builder,
-			gstreamer.NewElement("application/x-rtp"),
-			gstreamer.NewElement("rtpjitterbuffer", jitterBufferSettings...),
-			gstreamer.NewElement("rtph264depay"),
+			gst.NewElement("application/x-rtp"),
+			gst.NewElement("rtpjitterbuffer", jitterBufferSettings...),
+			gst.NewElement("rtph264depay"),
 		)
 	case "h265":
 		builder = append(builder,
-			gstreamer.NewElement("application/x-rtp"),
-			gstreamer.NewElement("rtpjitterbuffer", jitterBufferSettings...),
-			gstreamer.NewElement("rtph265depay"),
+			gst.NewElement("application/x-rtp"),
+			gst.NewElement("rtpjitterbuffer", jitterBufferSettings...),
+			gst.NewElement("rtph265depay"),
+		)
+	case Opus:
+		builder = append(builder,
+			gst.NewElement(fmt.Sprintf("application/x-rtp, media=audio, clock-rate=%v, encoding-name=OPUS", OpusClockRate)),
+			gst.NewElement("rtpjitterbuffer", jitterBufferSettings...),
+			gst.NewElement("rtpopusdepay"),
 		)
 	case "av1":
-		panic("rtpav1depay is not yet implemented in Gstreamer")
-		//builder = append(builder,
-		//	gstreamer.NewElement("rtpjitterbuffer", jitterBufferSettings...),
-		//	gstreamer.NewElement("rtpav1depay"),
-		//)
+		// rtpav1depay is not part of the Gstreamer core plugins, the
+		// temporal units are reassembled in Go, see av1SinkWriter
+		builder = append(builder,
+			gst.NewElement("video/x-av1,stream-format=obu-stream,alignment=tu"),
+			gst.NewElement("av1parse"),
+		)
+	default:
+		return nil, fmt.Errorf("the requested codec %v is not supported by the gstreamer sink", c.codec)
+	}
+
+	switch format {
+	case FormatIVF:
+		return newGstreamerFileSink(c, append(builder, gst.NewElement("avmux_ivf")), dst)
+	case FormatOGG:
+		return newGstreamerFileSink(c, append(builder, gst.NewElement("opusparse"), gst.NewElement("oggmux")), dst)
+	case FormatMP4:
+		switch c.codec {
+		case "h264", "h265", Opus:
+			builder = append(builder, gst.NewElement(fmt.Sprintf("%vparse", c.codec)))
+		}
+		// fragmented, so that the file is valid when the pipeline is
+		// stopped without EOS
+		return newGstreamerFileSink(c, append(builder, gst.NewElement("mp4mux", gst.Set("fragment-duration", 1000))), dst)
+	}
+	if c.codec == Opus {
+		return newGstreamerSink(c, append(builder,
+			gst.NewElement("opusdec"),
+			gst.NewElement("audioconvert"),
+			gst.NewElement("audioresample"),
+			gst.NewElement("autoaudiosink"),
+		))
 	}
 
 	builder = append(builder,
-		gstreamer.NewElement("decodebin"),
-		gstreamer.NewElement("videoconvert"),
-		gstreamer.NewElement("clocksync"),
-		gstreamer.NewElement("videorate"),
+		gst.NewElement("decodebin"),
+		gst.NewElement("videoconvert"),
+		gst.NewElement("clocksync"),
+		gst.NewElement("videorate"),
 	)
 
 	if teeLiveVideo {
 		builder = append(builder,
-			gstreamer.NewElement("tee", gstreamer.Set("name", "t")),
-			gstreamer.NewElement("queue"),
-			gstreamer.NewElement("autovideosink t."),
-			gstreamer.NewElement("queue"),
+			gst.NewElement("tee", gst.Set("name", "t")),
+			gst.NewElement("queue"),
+			gst.NewElement("autovideosink t."),
+			gst.NewElement("queue"),
 		)
 	}
-
-	if dst == "autovideosink" {
+	if c.quality != nil {
+		// the decoded frames are passed to the scorer in the format of the
+		// reference
 		builder = append(builder,
-			gstreamer.NewElement("autovideosink"),
-		)
-	} else {
-		builder = append(builder,
-			gstreamer.NewElement("y4menc"),
-			gstreamer.NewElement(fmt.Sprintf("filesink location=%v", dst)),
+			gst.NewElement("tee", gst.Set("name", "quality")),
+			gst.NewElement("queue"),
+			gst.NewElement("videoscale"),
+			gst.NewElement("videoconvert"),
+			gst.NewElement(c.quality.caps()),
+			gst.NewElement("appsink name=appsink sync=false quality."),
+			gst.NewElement("queue"),
 		)
 	}
 
+	if format == FormatY4M {
+		return newGstreamerFileSink(c, append(builder, gst.NewElement("y4menc")), dst)
+	}
+	return newGstreamerSink(c, append(builder, gst.NewElement("autovideosink")))
+}
+
+// newGstreamerFileSink creates the sink of builder writing to the file sink
+// dst.
+func newGstreamerFileSink(c *Config, builder gst.Elements, dst string) (*GstreamerSink, error) {
+	return newGstreamerSink(c, append(builder, gst.NewElement("filesink", gst.Set("location", FilePath(dst)))))
+}
+
+func newGstreamerSink(c *Config, builder gst.Elements) (*GstreamerSink, error) {
 	pipelineStr := builder.Build()
 	log.Printf("sink pipeline: %v", pipelineStr)
 
-	pipeline, err := gstreamer.NewPipeline(pipelineStr)
+	pipeline, err := gst.NewPipeline(pipelineStr)
 	if err != nil {
 		return nil, err
 	}
@@ -346,15 +708,66 @@ func NewGstreamerSink(dst string, opts ...ConfigOption) (*GstreamerSink, error)
 		Config:   *c,
 		Writer:   pipeline,
 		pipeline: pipeline,
+		failed:   make(chan error, 1),
+	}
+	if c.codec == "av1" {
+		s.Writer = &av1SinkWriter{pipeline: pipeline}
+	}
+	if c.quality != nil {
+		pipeline.SetFrameHandler(func(f gst.Frame) {
+			c.quality.onFrame(f.Bytes, time.Now())
+			f.Release()
+		})
 	}
+	pipeline.SetErrorHandler(func(err error) {
+		log.Printf("sink pipeline failed: %v", err)
+		select {
+		case s.failed <- err:
+		default:
+		}
+	})
 	return s, nil
 }
 
+// av1SinkWriter reassembles the temporal units of the received AV1 packets
+// and pushes them to the pipeline.
+type av1SinkWriter struct {
+	pipeline     *gst.Pipeline
+	depacketizer av1Depacketizer
+}
+
+func (w *av1SinkWriter) Write(buf []byte) (int, error) {
+	var pkt pionrtp.Packet
+	if err := pkt.Unmarshal(buf); err != nil {
+		return 0, err
+	}
+	tu, keyFrame, ok := w.depacketizer.push(&pkt)
+	if !ok {
+		return len(buf), nil
+	}
+	if err := w.pipeline.WriteFrame(gst.Frame{
+		Bytes:    tu,
+		PTS:      gst.NoTimestamp,
+		DTS:      gst.NoTimestamp,
+		Duration: gst.NoTimestamp,
+		KeyFrame: keyFrame,
+	}); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
 func (s *GstreamerSink) Play() error {
 	go s.pipeline.Start()
 	return nil
 }
 
+// Failed returns a channel receiving the first error of the pipeline, e.g.,
+// if the decoder fails. The pipeline does not play anymore after an error.
+func (s *GstreamerSink) Failed() <-chan error {
+	return s.failed
+}
+
 func (s *GstreamerSink) Stop() error {
 	return s.pipeline.Close()
 }
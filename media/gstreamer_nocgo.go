@@ -0,0 +1,41 @@
+//go:build !cgo
+// +build !cgo
+
+package media
+
+import (
+	"errors"
+
+	"github.com/pion/interceptor"
+)
+
+// errNoGstreamer is returned by the Gstreamer sources and sinks of binaries
+// built without CGo. Use the FFmpeg backend, the syncodec source or
+// TestSource instead.
+var errNoGstreamer = errors.New("built without CGo, Gstreamer is not available")
+
+type GstreamerSource struct {
+	Config
+}
+
+func NewGstreamerSource(interceptor.RTPWriter, string, bool, ...ConfigOption) (*GstreamerSource, error) {
+	return nil, errNoGstreamer
+}
+
+func (s *GstreamerSource) Play() error                  { return errNoGstreamer }
+func (s *GstreamerSource) Stop() error                  { return nil }
+func (s *GstreamerSource) SetTargetBitsPerSecond(uint)  {}
+func (s *GstreamerSource) GetTargetBitsPerSecond() uint { return 0 }
+func (s *GstreamerSource) ForceKeyframe() error         { return errNoGstreamer }
+
+type GstreamerSink struct {
+	Config
+}
+
+func NewGstreamerSink(string, ...ConfigOption) (*GstreamerSink, error) {
+	return nil, errNoGstreamer
+}
+
+func (s *GstreamerSink) Write(b []byte) (int, error) { return 0, errNoGstreamer }
+func (s *GstreamerSink) Play() error                 { return errNoGstreamer }
+func (s *GstreamerSink) Stop() error                 { return nil }
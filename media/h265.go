@@ -0,0 +1,162 @@
+package media
+
+import "bytes"
+
+// H.265 NAL unit types (RFC 7798).
+const (
+	h265NALUBLAWLP = 16
+	h265NALUCRA    = 21
+	h265NALUVPS    = 32
+	h265NALUSPS    = 33
+	h265NALUAUD    = 35
+	h265NALUAP     = 48
+	h265NALUFU     = 49
+)
+
+func h265NALUType(nalu []byte) byte {
+	return nalu[0] >> 1 & 0x3f
+}
+
+// splitAnnexB returns the NAL units of the Annex B byte stream frame without
+// their start codes.
+func splitAnnexB(frame []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	for i := 0; i+2 < len(frame); {
+		if frame[i] != 0 || frame[i+1] != 0 || frame[i+2] != 1 {
+			i++
+			continue
+		}
+		if start >= 0 {
+			nalus = append(nalus, bytes.TrimRight(frame[start:i], "\x00"))
+		}
+		i += 3
+		start = i
+	}
+	if start >= 0 && start < len(frame) {
+		nalus = append(nalus, frame[start:])
+	}
+	return nalus
+}
+
+// h265Payloader packetizes H.265 access units in the Annex B format (RFC
+// 7798). NAL units fitting into a packet are sent as single NAL unit packets
+// or, if consecutive ones fit together, in aggregation packets, larger ones
+// in fragmentation units. Access unit delimiters are dropped.
+type h265Payloader struct{}
+
+func (p *h265Payloader) Payload(mtu uint, frame []byte) [][]byte {
+	var payloads [][]byte
+	var aggregated [][]byte
+	aggregatedSize := 2
+	flush := func() {
+		switch len(aggregated) {
+		case 0:
+			return
+		case 1:
+			payloads = append(payloads, aggregated[0])
+		default:
+			// the header of the aggregation packet has the lowest layer ID
+			// and temporal ID of the aggregated NAL units
+			layerID, tid := byte(0x3f), byte(0x07)
+			forbidden := byte(0)
+			for _, n := range aggregated {
+				forbidden |= n[0] & 0x80
+				if l := (n[0]&0x01)<<5 | n[1]>>3; l < layerID {
+					layerID = l
+				}
+				if t := n[1] & 0x07; t < tid {
+					tid = t
+				}
+			}
+			ap := make([]byte, 0, aggregatedSize)
+			ap = append(ap, forbidden|h265NALUAP<<1|layerID>>5, (layerID&0x1f)<<3|tid)
+			for _, n := range aggregated {
+				ap = append(ap, byte(len(n)>>8), byte(len(n)))
+				ap = append(ap, n...)
+			}
+			payloads = append(payloads, ap)
+		}
+		aggregated = nil
+		aggregatedSize = 2
+	}
+	for _, nalu := range splitAnnexB(frame) {
+		if len(nalu) < 2 || h265NALUType(nalu) == h265NALUAUD {
+			continue
+		}
+		if len(nalu) <= int(mtu) {
+			if aggregatedSize+2+len(nalu) > int(mtu) {
+				flush()
+			}
+			aggregated = append(aggregated, nalu)
+			aggregatedSize += 2 + len(nalu)
+			continue
+		}
+		flush()
+		// fragmentation units: payload header, FU header and a fragment of
+		// the NAL unit payload
+		maxFragment := int(mtu) - 3
+		if maxFragment <= 0 {
+			return nil
+		}
+		typ := h265NALUType(nalu)
+		header := [2]byte{nalu[0]&0x81 | h265NALUFU<<1, nalu[1]}
+		data := nalu[2:]
+		for first := true; len(data) > 0; first = false {
+			n := len(data)
+			if n > maxFragment {
+				n = maxFragment
+			}
+			fu := typ
+			if first {
+				fu |= 0x80
+			}
+			if n == len(data) {
+				fu |= 0x40
+			}
+			out := make([]byte, 0, 3+n)
+			out = append(out, header[0], header[1], fu)
+			out = append(out, data[:n]...)
+			payloads = append(payloads, out)
+			data = data[n:]
+		}
+	}
+	flush()
+	return payloads
+}
+
+// isH265KeyFrame returns whether the Annex B access unit frame contains an
+// IRAP picture.
+func isH265KeyFrame(frame []byte) bool {
+	for _, nalu := range splitAnnexB(frame) {
+		if len(nalu) < 2 {
+			continue
+		}
+		if typ := h265NALUType(nalu); typ >= h265NALUBLAWLP && typ <= h265NALUCRA {
+			return true
+		}
+	}
+	return false
+}
+
+// isH265ParameterSet returns true for single NAL unit, aggregation and
+// fragmentation unit packets (RFC 7798) carrying or starting a VPS or SPS.
+// The second byte of the NAL unit header, layer ID 0 and temporal ID 1,
+// tells the packets apart from H.264 packets with the same first byte.
+func isH265ParameterSet(payload []byte) bool {
+	if len(payload) < 3 || payload[0]&0x80 != 0 || payload[1] != 0x01 {
+		return false
+	}
+	isParameterSet := func(typ byte) bool {
+		return typ == h265NALUVPS || typ == h265NALUSPS
+	}
+	switch typ := h265NALUType(payload); typ {
+	case h265NALUVPS, h265NALUSPS:
+		return payload[0]&0x01 == 0
+	case h265NALUAP:
+		return len(payload) >= 6 && payload[5] == 0x01 && isParameterSet(h265NALUType(payload[4:]))
+	case h265NALUFU:
+		return payload[2]&0x80 != 0 && isParameterSet(payload[2]&0x3f)
+	}
+	return false
+}
@@ -0,0 +1,354 @@
+package media
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/pion/rtp"
+)
+
+const (
+	jitterBufferTick = 2 * time.Millisecond
+	// playoutAnchorWindow is the interval after which the anchor of the
+	// playout schedule may move up again.
+	playoutAnchorWindow = 10 * time.Second
+)
+
+type JitterBufferOption func(*JitterBuffer) error
+
+// JitterBufferDelay sets the time a packet is held back at most while waiting
+// for missing predecessors. In adaptive mode, this is the minimum delay.
+func JitterBufferDelay(d time.Duration) JitterBufferOption {
+	return func(b *JitterBuffer) error {
+		b.minDelay = d
+		b.delay = d
+		return nil
+	}
+}
+
+// JitterBufferMaxDelay sets the upper bound of the delay in adaptive mode.
+func JitterBufferMaxDelay(d time.Duration) JitterBufferOption {
+	return func(b *JitterBuffer) error {
+		b.maxDelay = d
+		return nil
+	}
+}
+
+// JitterBufferAdaptive enables adapting the delay to the measured
+// interarrival jitter.
+func JitterBufferAdaptive(enabled bool) JitterBufferOption {
+	return func(b *JitterBuffer) error {
+		b.adaptive = enabled
+		return nil
+	}
+}
+
+func JitterBufferClockRate(r uint32) JitterBufferOption {
+	return func(b *JitterBuffer) error {
+		b.clockRate = r
+		return nil
+	}
+}
+
+// JitterBufferClockDrift schedules the playout of packets by their RTP
+// timestamps instead of their arrival, using the clock rate of the sender
+// estimated from the timestamps and arrival times. Packets are released
+// delay after the earliest arrival relative to their media time. Following
+// the estimated clock rate slews the schedule, so that a drifting sender
+// clock neither fills the buffer nor makes packets arrive too late. The
+// estimated drift is logged to logfile.
+func JitterBufferClockDrift(enabled bool, logfile string) JitterBufferOption {
+	return func(b *JitterBuffer) error {
+		if !enabled {
+			return nil
+		}
+		f, err := logging.GetLogFile(logfile)
+		if err != nil {
+			return err
+		}
+		b.driftLog = f
+		return nil
+	}
+}
+
+type jitterBufferPacket struct {
+	seqNr   int64
+	arrival time.Time
+	playout time.Time
+	buffer  []byte
+}
+
+type packetHeap []*jitterBufferPacket
+
+func (h packetHeap) Len() int            { return len(h) }
+func (h packetHeap) Less(i, j int) bool  { return h[i].seqNr < h[j].seqNr }
+func (h packetHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *packetHeap) Push(x interface{}) { *h = append(*h, x.(*jitterBufferPacket)) }
+func (h *packetHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	p := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return p
+}
+
+// JitterBuffer reorders incoming RTP packets by sequence number before
+// writing them to the underlying writer. Packets are passed on as soon as all
+// predecessors have been written, or after they waited for the configured
+// delay. With clock drift estimation, packets are released at their playout
+// time instead.
+type JitterBuffer struct {
+	writer io.Writer
+
+	delay     time.Duration
+	minDelay  time.Duration
+	maxDelay  time.Duration
+	adaptive  bool
+	clockRate uint32
+
+	lock        sync.Mutex
+	packets     packetHeap
+	init        bool
+	lastSeqNr   int64
+	nextSeqNr   int64
+	jitter      float64
+	lastArrival time.Time
+	lastRTPTS   uint32
+	ssrc        uint32
+
+	// drift is set if packets are scheduled by their media time
+	drift    *ClockDrift
+	driftLog io.WriteCloser
+	start    time.Time
+	// anchor is the smallest offset between arrival and media time in the
+	// current and the last window
+	anchor          time.Duration
+	nextAnchor      time.Duration
+	anchorWindowEnd time.Time
+
+	close chan struct{}
+	done  chan struct{}
+}
+
+func NewJitterBuffer(writer io.Writer, opts ...JitterBufferOption) (*JitterBuffer, error) {
+	b := &JitterBuffer{
+		writer:    writer,
+		delay:     50 * time.Millisecond,
+		minDelay:  50 * time.Millisecond,
+		maxDelay:  500 * time.Millisecond,
+		adaptive:  false,
+		clockRate: 90000,
+		packets:   packetHeap{},
+		close:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+	if b.driftLog != nil {
+		b.drift = NewClockDrift(b.clockRate)
+	}
+	go b.loop()
+	return b, nil
+}
+
+func (b *JitterBuffer) unwrap(seqNr uint16) int64 {
+	if !b.init {
+		b.init = true
+		b.lastSeqNr = int64(seqNr)
+		b.nextSeqNr = b.lastSeqNr
+		return b.lastSeqNr
+	}
+	unwrapped := b.lastSeqNr + int64(int16(seqNr-uint16(b.lastSeqNr)))
+	if unwrapped > b.lastSeqNr {
+		b.lastSeqNr = unwrapped
+	}
+	return unwrapped
+}
+
+// updateJitter computes the interarrival jitter as defined in RFC 3550 and
+// adapts the delay if adaptive mode is enabled.
+func (b *JitterBuffer) updateJitter(arrival time.Time, ts uint32) {
+	clockRate := float64(b.clockRate)
+	if b.drift != nil {
+		clockRate = b.drift.ClockRate()
+	}
+	if !b.lastArrival.IsZero() {
+		transit := arrival.Sub(b.lastArrival).Seconds() - float64(int32(ts-b.lastRTPTS))/clockRate
+		b.jitter += (math.Abs(transit) - b.jitter) / 16
+	}
+	b.lastArrival = arrival
+	b.lastRTPTS = ts
+
+	if b.adaptive {
+		d := time.Duration(4 * b.jitter * float64(time.Second))
+		if d < b.minDelay {
+			d = b.minDelay
+		}
+		if d > b.maxDelay {
+			d = b.maxDelay
+		}
+		b.delay = d
+	}
+}
+
+// Write adds a packet to the buffer. Packets older than the last packet
+// written to the underlying writer and duplicates are dropped.
+func (b *JitterBuffer) Write(buf []byte) (int, error) {
+	var header rtp.Header
+	if _, err := header.Unmarshal(buf); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.ssrc = header.SSRC
+	seqNr := b.unwrap(header.SequenceNumber)
+	if seqNr < b.nextSeqNr {
+		logging.Drop(logging.DropLate, "jitter buffer got seqNr=%v, expected>=%v", header.SequenceNumber, b.nextSeqNr)
+		logging.QLOGEvent(logging.QLOGFrameDeadlineExpired, map[string]interface{}{
+			"ssrc":            header.SSRC,
+			"sequence_number": header.SequenceNumber,
+			"timestamp":       header.Timestamp,
+		})
+		return len(buf), nil
+	}
+	for _, p := range b.packets {
+		if p.seqNr == seqNr {
+			logging.Drop(logging.DropDuplicate, "jitter buffer got seqNr=%v twice", header.SequenceNumber)
+			return len(buf), nil
+		}
+	}
+	if b.drift != nil && b.drift.OnPacket(now, header.Timestamp) {
+		fmt.Fprintf(b.driftLog, "%v, %v, %v\n", now.UnixMilli(), b.drift.DriftPPM(), b.drift.ClockRate())
+	}
+	if seqNr == b.lastSeqNr {
+		b.updateJitter(now, header.Timestamp)
+	}
+	pkt := make([]byte, len(buf))
+	copy(pkt, buf)
+	heap.Push(&b.packets, &jitterBufferPacket{
+		seqNr:   seqNr,
+		arrival: now,
+		playout: b.playoutTime(now, header.Timestamp),
+		buffer:  pkt,
+	})
+	return len(buf), nil
+}
+
+// playoutTime returns the time at which a packet with timestamp ts which
+// arrived at arrival is released if drift estimation is enabled.
+func (b *JitterBuffer) playoutTime(arrival time.Time, ts uint32) time.Time {
+	if b.drift == nil {
+		return time.Time{}
+	}
+	media := b.drift.MediaTime(ts)
+	if b.start.IsZero() {
+		b.start = arrival
+		b.anchor = -media
+		b.nextAnchor = b.anchor
+		b.anchorWindowEnd = arrival.Add(playoutAnchorWindow)
+	}
+	offset := arrival.Sub(b.start) - media
+	if arrival.After(b.anchorWindowEnd) {
+		// let the anchor move up to the smallest offset of the last
+		// window to compensate for errors of the drift estimate
+		b.anchor = b.nextAnchor
+		b.nextAnchor = offset
+		b.anchorWindowEnd = arrival.Add(playoutAnchorWindow)
+	}
+	if offset < b.anchor {
+		b.anchor = offset
+	}
+	if offset < b.nextAnchor {
+		b.nextAnchor = offset
+	}
+	return b.start.Add(b.anchor + media + b.delay)
+}
+
+// QueueDepths returns the number of packets held back by the buffer.
+func (b *JitterBuffer) QueueDepths() map[uint32]int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.init {
+		return nil
+	}
+	return map[uint32]int{b.ssrc: len(b.packets)}
+}
+
+// DriftPPM returns the estimated deviation of the sender clock from its
+// nominal rate in parts per million or 0 if drift estimation is disabled.
+func (b *JitterBuffer) DriftPPM() float64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.drift == nil {
+		return 0
+	}
+	return b.drift.DriftPPM()
+}
+
+// Delay returns the current delay of the buffer.
+func (b *JitterBuffer) Delay() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.delay
+}
+
+func (b *JitterBuffer) release(now time.Time) [][]byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	res := [][]byte{}
+	for len(b.packets) > 0 {
+		head := b.packets[0]
+		if b.drift != nil {
+			if now.Before(head.playout) {
+				break
+			}
+		} else if head.seqNr != b.nextSeqNr && now.Sub(head.arrival) < b.delay {
+			break
+		}
+		heap.Pop(&b.packets)
+		b.nextSeqNr = head.seqNr + 1
+		res = append(res, head.buffer)
+	}
+	return res
+}
+
+func (b *JitterBuffer) loop() {
+	defer close(b.done)
+	ticker := time.NewTicker(jitterBufferTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, pkt := range b.release(now) {
+				if _, err := b.writer.Write(pkt); err != nil {
+					log.Printf("jitter buffer failed to write packet: %v", err)
+				}
+			}
+		case <-b.close:
+			return
+		}
+	}
+}
+
+func (b *JitterBuffer) Close() error {
+	close(b.close)
+	<-b.done
+	if b.driftLog != nil {
+		return b.driftLog.Close()
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package media
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/logging"
+)
+
+const (
+	// qualityWindow is the interval the scores are averaged over, the
+	// interval of the per-second rates of the stats logs.
+	qualityWindow = time.Second
+	// qualityMaxPSNR is the PSNR of identical frames.
+	qualityMaxPSNR = 100
+	// ssimWindow and ssimStep are the size and distance of the windows the
+	// SSIM of the luma plane is averaged over.
+	ssimWindow = 8
+	ssimStep   = 4
+)
+
+var errInvalidReference = errors.New("invalid quality reference")
+
+// y4mReference reads the frames of a Y4M reference video in 4:2:0 chroma
+// subsampling.
+type y4mReference struct {
+	file      *os.File
+	reader    *bufio.Reader
+	width     int
+	height    int
+	framerate float64
+	// next is the index of the next frame in the file
+	next  int
+	frame []byte
+}
+
+func openY4M(path string) (*y4mReference, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &y4mReference{
+		file:   f,
+		reader: bufio.NewReader(f),
+	}
+	if err := r.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %v: %v", errInvalidReference, path, err)
+	}
+	return r, nil
+}
+
+func (r *y4mReference) readHeader() error {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "YUV4MPEG2" {
+		return errors.New("not a Y4M file")
+	}
+	for _, field := range fields[1:] {
+		value := field[1:]
+		switch field[0] {
+		case 'W':
+			r.width, err = strconv.Atoi(value)
+		case 'H':
+			r.height, err = strconv.Atoi(value)
+		case 'F':
+			var num, den int
+			if _, err = fmt.Sscanf(value, "%d:%d", &num, &den); err == nil && den > 0 {
+				r.framerate = float64(num) / float64(den)
+			}
+		case 'C':
+			if !strings.HasPrefix(value, "420") {
+				err = fmt.Errorf("unsupported chroma subsampling %v, only 4:2:0 is supported", value)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if r.width <= 0 || r.height <= 0 || r.framerate <= 0 {
+		return errors.New("missing width, height or frame rate")
+	}
+	return nil
+}
+
+// size returns the number of bytes of a frame.
+func (r *y4mReference) size() int {
+	cw, ch := (r.width+1)/2, (r.height+1)/2
+	return r.width*r.height + 2*cw*ch
+}
+
+// frameAt returns the frame index, which must not be before the frame
+// returned last. It returns io.EOF after the last frame.
+func (r *y4mReference) frameAt(index int) ([]byte, error) {
+	for r.next <= index || r.frame == nil {
+		// the frame header may carry parameters, but no frame data
+		if _, err := r.reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		if r.frame == nil {
+			r.frame = make([]byte, r.size())
+		}
+		if _, err := io.ReadFull(r.reader, r.frame); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		r.next++
+	}
+	return r.frame, nil
+}
+
+func (r *y4mReference) Close() error {
+	return r.file.Close()
+}
+
+// QualityScorer compares the decoded frames of a media sink with the frames
+// of a reference video, e.g., the file sent by the sender, and logs the
+// average PSNR of all planes and the SSIM of the luma plane once per second
+// in the format 'unix-ms, frames, psnr, ssim'. The decoded frame at time t
+// after the first is compared with the reference frame at t, so that frozen
+// or skipped frames lower the scores like they lower the quality for a
+// viewer. Sender and receiver have to start the video at about the same
+// frame, the reference is not searched for the best matching frame.
+type QualityScorer struct {
+	reference *y4mReference
+	log       io.WriteCloser
+
+	lock        sync.Mutex
+	first       time.Time
+	windowStart time.Time
+	frames      int
+	psnr        float64
+	ssim        float64
+	ended       bool
+	logged      bool
+}
+
+// NewQualityScorer creates a scorer comparing with the Y4M file reference
+// and logging to file.
+func NewQualityScorer(reference, file string) (*QualityScorer, error) {
+	r, err := openY4M(reference)
+	if err != nil {
+		return nil, err
+	}
+	w, err := logging.GetLogFile(file)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &QualityScorer{
+		reference: r,
+		log:       w,
+	}, nil
+}
+
+// caps returns the caps the decoded frames are converted to, the format and
+// resolution of the reference.
+func (s *QualityScorer) caps() string {
+	return fmt.Sprintf("video/x-raw,format=I420,width=%v,height=%v", s.reference.width, s.reference.height)
+}
+
+// onFrame scores the decoded I420 frame b in the memory layout of Gstreamer
+// arriving at now.
+func (s *QualityScorer) onFrame(b []byte, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended {
+		return
+	}
+	if s.first.IsZero() {
+		s.first = now
+		s.windowStart = now
+	}
+	if now.Sub(s.windowStart) >= qualityWindow {
+		s.flush(now)
+	}
+	decoded, ok := packI420(b, s.reference.width, s.reference.height)
+	if !ok {
+		if !s.logged {
+			log.Printf("quality: decoded frame of %v bytes doesn't match the %vx%v reference, not scoring", len(b), s.reference.width, s.reference.height)
+			s.logged = true
+		}
+		return
+	}
+	index := int(now.Sub(s.first).Seconds() * s.reference.framerate)
+	ref, err := s.reference.frameAt(index)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			log.Printf("quality: reference ended after %v frames, not scoring anymore", s.reference.next)
+		} else {
+			log.Printf("quality: failed to read reference: %v", err)
+		}
+		s.flush(now)
+		s.ended = true
+		return
+	}
+	s.frames++
+	s.psnr += psnr(ref, decoded)
+	s.ssim += ssim(ref, decoded, s.reference.width, s.reference.height)
+}
+
+// flush logs the scores of the current window and starts the next at now.
+func (s *QualityScorer) flush(now time.Time) {
+	if s.frames > 0 {
+		fmt.Fprintf(s.log, "%v, %v, %.3f, %.4f\n", now.UnixMilli(), s.frames, s.psnr/float64(s.frames), s.ssim/float64(s.frames))
+	}
+	s.windowStart = now
+	s.frames = 0
+	s.psnr = 0
+	s.ssim = 0
+}
+
+// Close logs the scores of the last window and closes the files.
+func (s *QualityScorer) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.ended {
+		s.flush(time.Now())
+		s.ended = true
+	}
+	if err := s.reference.Close(); err != nil {
+		s.log.Close()
+		return err
+	}
+	return s.log.Close()
+}
+
+// packI420 returns the I420 frame b of Gstreamer, whose rows are aligned to
+// 4 bytes, without the padding of the rows.
+func packI420(b []byte, width, height int) ([]byte, bool) {
+	cw, ch := (width+1)/2, (height+1)/2
+	yStride := (width + 3) &^ 3
+	cStride := (cw + 3) &^ 3
+	if len(b) < yStride*height+2*cStride*ch {
+		return nil, false
+	}
+	if yStride == width && cStride == cw {
+		return b[:width*height+2*cw*ch], true
+	}
+	packed := make([]byte, 0, width*height+2*cw*ch)
+	for y := 0; y < height; y++ {
+		packed = append(packed, b[y*yStride:y*yStride+width]...)
+	}
+	for _, offset := range []int{yStride * height, yStride*height + cStride*ch} {
+		for y := 0; y < ch; y++ {
+			packed = append(packed, b[offset+y*cStride:offset+y*cStride+cw]...)
+		}
+	}
+	return packed, true
+}
+
+// psnr returns the PSNR of all samples of b compared with a.
+func psnr(a, b []byte) float64 {
+	var sum float64
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+	if sum == 0 {
+		return qualityMaxPSNR
+	}
+	mse := sum / float64(len(a))
+	return math.Min(qualityMaxPSNR, 10*math.Log10(255*255/mse))
+}
+
+// ssim returns the mean SSIM of the luma planes of a and b over windows of
+// ssimWindow samples every ssimStep samples.
+func ssim(a, b []byte, width, height int) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+		n  = ssimWindow * ssimWindow
+	)
+	var total float64
+	windows := 0
+	for y := 0; y+ssimWindow <= height; y += ssimStep {
+		for x := 0; x+ssimWindow <= width; x += ssimStep {
+			var sa, sb, saa, sbb, sab float64
+			for wy := 0; wy < ssimWindow; wy++ {
+				row := (y+wy)*width + x
+				for wx := 0; wx < ssimWindow; wx++ {
+					va, vb := float64(a[row+wx]), float64(b[row+wx])
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+			ma, mb := sa/n, sb/n
+			va := saa/n - ma*ma
+			vb := sbb/n - mb*mb
+			cov := sab/n - ma*mb
+			total += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
@@ -0,0 +1,106 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+var errInvalidRateControl = errors.New("invalid encoder rate control configuration")
+
+type TargetBitrateSetter interface {
+	SetTargetBitsPerSecond(uint)
+}
+
+type RateControllerOption func(*RateController) error
+
+// MinTargetBitrate sets the lowest bitrate the encoder is configured with.
+func MinTargetBitrate(r uint) RateControllerOption {
+	return func(c *RateController) error {
+		c.min = r
+		return nil
+	}
+}
+
+// MaxTargetBitrate sets the highest bitrate the encoder is configured with,
+// 0 means no limit.
+func MaxTargetBitrate(r uint) RateControllerOption {
+	return func(c *RateController) error {
+		c.max = r
+		return nil
+	}
+}
+
+// Headroom sets the fraction of the congestion control target bitrate which
+// is not passed to the encoder, leaving room for encoder overshoot, RTP
+// overhead and retransmissions.
+func Headroom(h float64) RateControllerOption {
+	return func(c *RateController) error {
+		if h < 0 || h >= 1 {
+			return fmt.Errorf("%w: headroom must be in [0, 1), got %v", errInvalidRateControl, h)
+		}
+		c.headroom = h
+		return nil
+	}
+}
+
+// RateController passes the target bitrate of the congestion controller to
+// the encoder after subtracting the headroom and limiting it to the
+// configured range.
+type RateController struct {
+	encoder TargetBitrateSetter
+
+	min      uint
+	max      uint
+	headroom float64
+
+	lock    sync.Mutex
+	current uint
+}
+
+func NewRateController(encoder TargetBitrateSetter, opts ...RateControllerOption) (*RateController, error) {
+	c := &RateController{
+		encoder:  encoder,
+		min:      0,
+		max:      0,
+		headroom: 0,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.max > 0 && c.max < c.min {
+		return nil, fmt.Errorf("%w: max bitrate %v is lower than min bitrate %v", errInvalidRateControl, c.max, c.min)
+	}
+	return c, nil
+}
+
+// EncoderBitrate returns the encoder bitrate for the congestion control
+// target bitrate r.
+func (c *RateController) EncoderBitrate(r uint) uint {
+	rate := uint(float64(r) * (1 - c.headroom))
+	if rate < c.min {
+		rate = c.min
+	}
+	if c.max > 0 && rate > c.max {
+		rate = c.max
+	}
+	return rate
+}
+
+// SetTargetBitsPerSecond sets the encoder bitrate for the congestion control
+// target bitrate r and logs the change.
+func (c *RateController) SetTargetBitsPerSecond(r uint) {
+	rate := c.EncoderBitrate(r)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if rate == c.current {
+		return
+	}
+	log.Printf("encoder target bitrate changed from %v to %v bit/s (congestion control target %v bit/s)", c.current, rate, r)
+	c.current = rate
+	c.encoder.SetTargetBitsPerSecond(rate)
+}
@@ -0,0 +1,30 @@
+package media
+
+import "io"
+
+// Sink plays or records the RTP packets written to it.
+type Sink interface {
+	io.Writer
+	Play() error
+	Stop() error
+}
+
+// NewSink creates a sink for dst using the backend selected by Backend.
+func NewSink(dst string, opts ...ConfigOption) (Sink, error) {
+	c, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.backend == BackendFFmpeg {
+		s, err := NewFFmpegSink(dst, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	s, err := NewGstreamerSink(dst, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
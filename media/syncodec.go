@@ -1,11 +1,18 @@
 package media
 
 import (
+	"fmt"
+	"io"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/rtp"
 	"github.com/mengelbart/syncodec"
 	"github.com/pion/interceptor"
-	"github.com/pion/rtp"
+	pionrtp "github.com/pion/rtp"
 )
 
 const (
@@ -14,13 +21,108 @@ const (
 	VP9  = "vp9"
 )
 
+// syncodecKeyFrameScale is the size of key frames relative to the mean frame
+// size at the target bitrate.
+const syncodecKeyFrameScale = 5
+
+// SyncodecModel is the traffic model of a SyncodecSource, which emulates the
+// frame sizes and timing of an encoder.
+type SyncodecModel struct {
+	// FrameInterval is the mean time between two frames, 1/30 s if 0.
+	FrameInterval time.Duration
+	// Burstiness is the scale of the Laplacian noise of the frame sizes and
+	// intervals relative to their means, 0 sends frames of constant size at
+	// a constant interval.
+	Burstiness float64
+	// KeyFrameInterval is the number of frames from one key frame to the
+	// next, 0 disables key frames. Key frames are syncodecKeyFrameScale
+	// times the mean frame size, the frames in between are smaller so that
+	// the bitrate stays at the target.
+	KeyFrameInterval uint
+	// AdaptationDelay is the time the encoder takes to apply a new target
+	// bitrate.
+	AdaptationDelay time.Duration
+	// Stats logs the frames, disabled if nil.
+	Stats *FrameStats
+}
+
+// DefaultSyncodecModel returns the model of the syncodec statistical
+// encoder: 30 fps with a burstiness of 0.15, without key frames and
+// adaptation delay.
+func DefaultSyncodecModel() SyncodecModel {
+	return SyncodecModel{
+		FrameInterval: time.Second / 30,
+		Burstiness:    0.15,
+	}
+}
+
+// Syncodec sets the traffic model of syncodec sources.
+func Syncodec(m SyncodecModel) ConfigOption {
+	return func(c *Config) error {
+		if m.FrameInterval == 0 {
+			m.FrameInterval = time.Second / 30
+		}
+		if m.FrameInterval < 0 || m.FrameInterval > time.Second {
+			return fmt.Errorf("invalid syncodec frame interval %v, expected up to 1s", m.FrameInterval)
+		}
+		if m.Burstiness < 0 {
+			return fmt.Errorf("invalid syncodec burstiness %v, expected at least 0", m.Burstiness)
+		}
+		if m.AdaptationDelay < 0 {
+			return fmt.Errorf("invalid syncodec adaptation delay %v", m.AdaptationDelay)
+		}
+		c.syncodec = m
+		return nil
+	}
+}
+
+// FrameStats logs one line per frame of syncodec sources and sinks to a file
+// shared by all streams. Sources log the time in ms, SSRC, frame number,
+// size in bytes, duration in ms, whether it is a key frame and the target
+// bitrate. Sinks log the time in ms, SSRC, RTP timestamp, size in bytes and
+// the numbers of received and lost packets of the frame.
+type FrameStats struct {
+	lock sync.Mutex
+	file io.WriteCloser
+}
+
+func NewFrameStats(file string) (*FrameStats, error) {
+	f, err := logging.GetLogFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return &FrameStats{
+		file: f,
+	}, nil
+}
+
+func (s *FrameStats) log(format string, args ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fmt.Fprintf(s.file, format, args...)
+}
+
+func (s *FrameStats) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
 type SyncodecSource struct {
 	Config
 
-	targetBitrate uint
-	codec         syncodec.Codec
-	rtpWriter     interceptor.RTPWriter
-	packetizer    rtp.Packetizer
+	codec      syncodec.Codec
+	rtpWriter  interceptor.RTPWriter
+	packetizer pionrtp.Packetizer
+	frames     uint64
+	// forceKeyFrame is 1 if the next frame is a key frame
+	forceKeyFrame int32
+
+	lock   sync.Mutex
+	closed bool
 }
 
 func NewSyncodecSource(rtpWriter interceptor.RTPWriter, opts ...ConfigOption) (*SyncodecSource, error) {
@@ -32,21 +134,26 @@ func NewSyncodecSource(rtpWriter interceptor.RTPWriter, opts ...ConfigOption) (*
 	if err != nil {
 		return nil, err
 	}
-	packetizer := rtp.NewPacketizer(
+	packetizer := pionrtp.NewPacketizer(
 		c.payloadType,
 		c.ssrc,
 		payloader,
-		rtp.NewRandomSequencer(),
+		pionrtp.NewRandomSequencer(),
 		c.clockRate,
 	)
 	s := &SyncodecSource{
-		Config:        *c,
-		targetBitrate: 0,
-		codec:         nil,
-		rtpWriter:     rtpWriter,
-		packetizer:    packetizer,
+		Config:     *c,
+		codec:      nil,
+		rtpWriter:  rtpWriter,
+		packetizer: packetizer,
 	}
-	codec, err := syncodec.NewStatisticalEncoder(s, syncodec.WithInitialTargetBitrate(int(s.targetBitrate)))
+	fps := int(time.Second / c.syncodec.FrameInterval)
+	codec, err := syncodec.NewStatisticalEncoder(s,
+		syncodec.WithInitialTargetBitrate(int(c.targetBitrate)),
+		syncodec.WithFramesPerSecond(fps),
+		syncodec.WithScaleB(c.syncodec.Burstiness),
+		syncodec.WithScaleT(c.syncodec.Burstiness),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -55,38 +162,139 @@ func NewSyncodecSource(rtpWriter interceptor.RTPWriter, opts ...ConfigOption) (*
 }
 
 func (e *SyncodecSource) WriteFrame(frame syncodec.Frame) {
+	n := e.frames
+	e.frames++
+	size := len(frame.Content)
+	k := uint64(e.syncodec.KeyFrameInterval)
+	keyFrame := atomic.SwapInt32(&e.forceKeyFrame, 0) == 1 || (k > 0 && n%k == 0)
+	if keyFrame || k > 0 {
+		if keyFrame {
+			size *= syncodecKeyFrameScale
+		} else if k > syncodecKeyFrameScale {
+			size = size * int(k-syncodecKeyFrameScale) / int(k-1)
+		}
+		if size < 1 {
+			size = 1
+		}
+		frame.Content = make([]byte, size)
+	}
+	e.syncodec.Stats.log("%v, %v, %v, %v, %v, %v, %v\n", time.Now().UnixMilli(), e.ssrc, n, size, frame.Duration.Milliseconds(), keyFrame, e.codec.GetTargetBitrate())
+
+	attributes := interceptor.Attributes{
+		rtp.FRAME: rtp.FrameInfo{
+			PTS:      -1,
+			DTS:      -1,
+			Duration: frame.Duration,
+			KeyFrame: keyFrame,
+			Size:     size,
+			Capture:  time.Now(),
+		},
+	}
+	attributes.Set(rtp.RELIABILITY, rtp.NOT_REQUIRED)
+	if keyFrame {
+		attributes.Set(rtp.RELIABILITY, rtp.REQUIRED)
+	}
 	samples := uint32(frame.Duration.Seconds() * float64(e.clockRate))
 	pkts := e.packetizer.Packetize(e.mtu, frame.Content, samples)
 	for _, pkt := range pkts {
-		if _, err := e.rtpWriter.Write(&pkt.Header, pkt.Payload, nil); err != nil {
+		if _, err := e.rtpWriter.Write(&pkt.Header, pkt.Payload, attributes); err != nil {
 			log.Printf("WARNING: failed to write RTP packet: %v", err)
 		}
 	}
 }
 
+// Play runs the encoder until Stop is called.
 func (s *SyncodecSource) Play() error {
-	go s.codec.Start()
+	s.codec.Start()
 	return nil
 }
 
 func (s *SyncodecSource) Stop() error {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
 	return s.codec.Close()
 }
 
+// SetTargetBitsPerSecond sets the target bitrate after the adaptation delay
+// of the model.
 func (s *SyncodecSource) SetTargetBitsPerSecond(r uint) {
-	s.codec.SetTargetBitrate(int(r))
+	if s.syncodec.AdaptationDelay == 0 {
+		s.codec.SetTargetBitrate(int(r))
+		return
+	}
+	time.AfterFunc(s.syncodec.AdaptationDelay, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if !s.closed {
+			s.codec.SetTargetBitrate(int(r))
+		}
+	})
+}
+
+// ForceKeyframe makes the next frame a key frame.
+func (s *SyncodecSource) ForceKeyframe() error {
+	atomic.StoreInt32(&s.forceKeyFrame, 1)
+	return nil
 }
 
-type SyncodecSink struct{}
+// SyncodecSink discards the media of syncodec sources after logging the
+// received frames to its FrameStats, if any.
+type SyncodecSink struct {
+	stats *FrameStats
+
+	// the frame currently received
+	ssrc      uint32
+	timestamp uint32
+	firstSeq  uint16
+	lastSeq   uint16
+	packets   int
+	bytes     int
+}
 
-func NewSyncodecSink() (*SyncodecSink, error) {
-	return &SyncodecSink{}, nil
+func NewSyncodecSink(stats *FrameStats) (*SyncodecSink, error) {
+	return &SyncodecSink{
+		stats: stats,
+	}, nil
 }
 
+// Write records the RTP packet b. It must not be called concurrently.
 func (s *SyncodecSink) Write(b []byte) (int, error) {
+	if s.stats == nil {
+		return len(b), nil
+	}
+	var header pionrtp.Header
+	n, err := header.Unmarshal(b)
+	if err != nil {
+		return len(b), nil
+	}
+	if s.packets > 0 && (header.SSRC != s.ssrc || header.Timestamp != s.timestamp) {
+		s.logFrame()
+	}
+	if s.packets == 0 {
+		s.ssrc = header.SSRC
+		s.timestamp = header.Timestamp
+		s.firstSeq = header.SequenceNumber
+	}
+	s.lastSeq = header.SequenceNumber
+	s.packets++
+	s.bytes += len(b) - n
+	if header.Marker {
+		s.logFrame()
+	}
 	return len(b), nil
 }
 
+func (s *SyncodecSink) logFrame() {
+	lost := int(s.lastSeq-s.firstSeq) + 1 - s.packets
+	if lost < 0 {
+		lost = 0
+	}
+	s.stats.log("%v, %v, %v, %v, %v, %v\n", time.Now().UnixMilli(), s.ssrc, s.timestamp, s.bytes, s.packets, lost)
+	s.packets = 0
+	s.bytes = 0
+}
+
 func (s *SyncodecSink) Stop() error {
 	return nil
 }
@@ -0,0 +1,197 @@
+package media
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/pion/interceptor"
+	pionrtp "github.com/pion/rtp"
+)
+
+// TestSourceName is the source name of TestSource.
+const TestSourceName = "gotestsrc"
+
+const (
+	testSourceFramerate = 30
+	// testSourceGOP is the number of frames from one key frame to the next.
+	testSourceGOP = 2 * testSourceFramerate
+	// testSourceKeyFrameWeight is the size of a key frame relative to a
+	// delta frame.
+	testSourceKeyFrameWeight = 6
+	// testSourceSizeDeviation is the standard deviation of the log-normal
+	// distribution of the frame sizes.
+	testSourceSizeDeviation = 0.25
+	testSourceMinFrameSize  = 32
+)
+
+// Parameter sets of the H.264 key frames of TestSource. The slices are
+// not decodable, but the stream is well-formed for payload inspection and
+// packetization.
+var (
+	testSourceSPS = []byte{0x67, 0x42, 0xc0, 0x1f, 0x8c, 0x8d, 0x40, 0x50, 0x1e, 0xd0, 0x0f, 0x08, 0x84, 0x6a}
+	testSourcePPS = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+// TestSource generates frames of a fake VP8 or H.264 bitstream in Go, without
+// an encoder. Frames are sent at 30 fps with a key frame every 2 seconds,
+// key frames are larger than delta frames and the sizes vary around the
+// target bitrate as with a real encoder. The payload is a pattern derived
+// from the frame number, so that it can be used without Gstreamer, e.g., in
+// CI, but the receiver has to discard the media, e.g., with the sink 'none'.
+type TestSource struct {
+	Config
+	rtpWriter  interceptor.RTPWriter
+	packetizer pionrtp.Packetizer
+	rand       *rand.Rand
+
+	lock   sync.Mutex
+	target uint
+
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+func NewTestSource(rtpWriter interceptor.RTPWriter, opts ...ConfigOption) (*TestSource, error) {
+	c, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.codec != H264 && c.codec != VP8 {
+		return nil, fmt.Errorf("the test source supports the codecs %v and %v only, got %v", H264, VP8, c.codec)
+	}
+	payloader, err := payloaderForCodec(c.codec)
+	if err != nil {
+		return nil, err
+	}
+	return &TestSource{
+		Config:     *c,
+		rtpWriter:  rtpWriter,
+		packetizer: pionrtp.NewPacketizer(c.payloadType, c.ssrc, payloader, pionrtp.NewFixedSequencer(0), c.clockRate),
+		rand:       rand.New(rand.NewSource(int64(c.ssrc))),
+		target:     c.targetBitrate,
+		close:      make(chan struct{}),
+	}, nil
+}
+
+// Play sends frames until Stop is called.
+func (s *TestSource) Play() error {
+	ticker := time.NewTicker(time.Second / testSourceFramerate)
+	defer ticker.Stop()
+	for n := 0; ; n++ {
+		keyFrame := n%testSourceGOP == 0
+		if err := s.writeFrame(s.frame(n, keyFrame), keyFrame); err != nil {
+			return err
+		}
+		select {
+		case <-s.close:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// frameSize returns the size of the next frame in bytes, so that a group of
+// pictures on average matches the target bitrate.
+func (s *TestSource) frameSize(keyFrame bool) int {
+	s.lock.Lock()
+	target := s.target
+	s.lock.Unlock()
+
+	gopBytes := float64(target) / 8 * testSourceGOP / testSourceFramerate
+	size := gopBytes / (testSourceGOP - 1 + testSourceKeyFrameWeight)
+	if keyFrame {
+		size *= testSourceKeyFrameWeight
+	}
+	// log-normal with mean 1
+	size *= math.Exp(testSourceSizeDeviation*s.rand.NormFloat64() - testSourceSizeDeviation*testSourceSizeDeviation/2)
+	if size < testSourceMinFrameSize {
+		return testSourceMinFrameSize
+	}
+	return int(size)
+}
+
+// frame returns the bitstream of frame n. The pattern bytes are never 0, so
+// that they don't contain H.264 start codes.
+func (s *TestSource) frame(n int, keyFrame bool) []byte {
+	size := s.frameSize(keyFrame)
+	frame := make([]byte, 0, size+len(testSourceSPS)+len(testSourcePPS)+16)
+	switch s.codec {
+	case H264:
+		startCode := []byte{0, 0, 0, 1}
+		if keyFrame {
+			frame = append(frame, startCode...)
+			frame = append(frame, testSourceSPS...)
+			frame = append(frame, startCode...)
+			frame = append(frame, testSourcePPS...)
+			frame = append(frame, startCode...)
+			frame = append(frame, 0x65) // IDR slice
+		} else {
+			frame = append(frame, startCode...)
+			frame = append(frame, 0x41) // non-IDR slice
+		}
+	case VP8:
+		// frame tag with show_frame set, the P bit is 0 for key frames,
+		// which are followed by the start code and the dimensions.
+		tag := byte(0x10)
+		if !keyFrame {
+			tag |= 0x01
+		}
+		frame = append(frame, tag, 0, 0)
+		if keyFrame {
+			width, height := s.width, s.height
+			if width == 0 || height == 0 {
+				width, height = 640, 480
+			}
+			frame = append(frame, 0x9d, 0x01, 0x2a)
+			frame = append(frame, byte(width), byte(width>>8), byte(height), byte(height>>8))
+		}
+	}
+	for i := len(frame); i < size; i++ {
+		frame = append(frame, byte(1+(n+i)%255))
+	}
+	return frame
+}
+
+func (s *TestSource) writeFrame(frame []byte, keyFrame bool) error {
+	attributes := interceptor.Attributes{
+		rtp.FRAME: rtp.FrameInfo{
+			Duration: time.Second / testSourceFramerate,
+			KeyFrame: keyFrame,
+			Size:     len(frame),
+			Capture:  time.Now(),
+		},
+	}
+	attributes.Set(rtp.RELIABILITY, rtp.NOT_REQUIRED)
+	if keyFrame {
+		attributes.Set(rtp.RELIABILITY, rtp.REQUIRED)
+	}
+	for _, pkt := range s.packetizer.Packetize(s.mtu, frame, s.clockRate/testSourceFramerate) {
+		if _, err := s.rtpWriter.Write(&pkt.Header, pkt.Payload, attributes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TestSource) Stop() error {
+	s.closeOnce.Do(func() {
+		close(s.close)
+	})
+	return nil
+}
+
+func (s *TestSource) SetTargetBitsPerSecond(bitrate uint) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.target = bitrate
+}
+
+func (s *TestSource) GetTargetBitsPerSecond() uint {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.target
+}
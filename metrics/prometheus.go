@@ -0,0 +1,216 @@
+// Package metrics serves live metrics of senders and receivers in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/histogram"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+)
+
+const namespace = "rtp_over_quic"
+
+// Exporter collects the metrics of congestion controllers, RTP flows,
+// dropped packets and the latency and size histograms of the logging package
+// on every scrape. Bitrates are exported as byte counters, use rate() to get
+// the bitrate.
+type Exporter struct {
+	lock    sync.Mutex
+	sources map[string]cc.MetricsSource
+	traffic *rtp.TrafficCounter
+}
+
+func NewExporter(traffic *rtp.TrafficCounter) *Exporter {
+	return &Exporter{
+		sources: map[string]cc.MetricsSource{},
+		traffic: traffic,
+	}
+}
+
+// AddSource adds a congestion controller exported with the label
+// source=name.
+func (e *Exporter) AddSource(name string, s cc.MetricsSource) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.sources[name] = s
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	e.write(w)
+}
+
+// Serve serves the metrics at addr under /metrics until ctx is done.
+func (e *Exporter) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		if err := server.Close(); err != nil {
+			log.Printf("failed to close metrics server: %v", err)
+		}
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+type sample struct {
+	// suffix is appended to the name of the family, e.g., '_bucket' for
+	// the samples of histograms.
+	suffix string
+	labels string
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	samples []sample
+}
+
+func (e *Exporter) write(w io.Writer) {
+	for _, f := range e.collect() {
+		if len(f.samples) == 0 {
+			continue
+		}
+		name := namespace + "_" + f.name
+		fmt.Fprintf(w, "# HELP %v %v\n", name, f.help)
+		fmt.Fprintf(w, "# TYPE %v %v\n", name, f.kind)
+		for _, s := range f.samples {
+			fmt.Fprintf(w, "%v%v{%v} %v\n", name, s.suffix, s.labels, s.value)
+		}
+	}
+}
+
+func (e *Exporter) collect() []*family {
+	target := &family{name: "cc_target_bitrate_bits", help: "Target bitrate of the congestion controller.", kind: "gauge"}
+	pacing := &family{name: "cc_pacing_rate_bits", help: "Pacing rate of the congestion controller.", kind: "gauge"}
+	cwnd := &family{name: "cc_cwnd_bytes", help: "Congestion window.", kind: "gauge"}
+	rtt := &family{name: "rtt_seconds", help: "Round-trip time measured by the congestion controller.", kind: "gauge"}
+	queueDelay := &family{name: "queue_delay_seconds", help: "Queueing delay estimated by the congestion controller.", kind: "gauge"}
+	loss := &family{name: "loss_rate", help: "Loss rate measured by the congestion controller.", kind: "gauge"}
+
+	e.lock.Lock()
+	names := make([]string, 0, len(e.sources))
+	for name := range e.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := e.sources[name].Metrics()
+		l := labels("source", name)
+		target.samples = append(target.samples, sample{"", l, float64(m.TargetBitrate)})
+		pacing.samples = append(pacing.samples, sample{"", l, float64(m.PacingRate)})
+		cwnd.samples = append(cwnd.samples, sample{"", l, float64(m.Cwnd)})
+		rtt.samples = append(rtt.samples, sample{"", l, m.RTT.Seconds()})
+		queueDelay.samples = append(queueDelay.samples, sample{"", l, m.QueueDelay.Seconds()})
+		loss.samples = append(loss.samples, sample{"", l, m.LossRate})
+	}
+	e.lock.Unlock()
+
+	packets := &family{name: "rtp_packets_total", help: "RTP packets per flow.", kind: "counter"}
+	bytes := &family{name: "rtp_bytes_total", help: "RTP bytes per flow.", kind: "counter"}
+	lost := &family{name: "rtp_lost_packets_total", help: "RTP packets missing in the sequence numbers of received flows.", kind: "counter"}
+	if e.traffic != nil {
+		for _, f := range e.traffic.Flows() {
+			l := labels("ssrc", fmt.Sprint(f.SSRC), "direction", string(f.Direction))
+			packets.samples = append(packets.samples, sample{"", l, float64(f.Packets)})
+			bytes.samples = append(bytes.samples, sample{"", l, float64(f.Bytes)})
+			if f.Direction == rtp.Received {
+				lost.samples = append(lost.samples, sample{"", l, float64(f.Lost)})
+			}
+		}
+	}
+
+	drops := &family{name: "dropped_packets_total", help: "Dropped packets per reason, stream resets are counted as 'stream-reset'.", kind: "counter"}
+	counts := logging.DropCounts()
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		drops.samples = append(drops.samples, sample{"", labels("reason", reason), float64(counts[logging.DropReason(reason)])})
+	}
+
+	latency := &family{name: "latency_seconds", help: "Latencies recorded by name, e.g., 'owd' or 'frame-completion'.", kind: "histogram"}
+	for _, name := range logging.LatencyNames() {
+		// the histograms record microseconds
+		latency.samples = append(latency.samples, histogramSamples(logging.Latency(name), name, latencyBuckets, 1e-6)...)
+	}
+	size := &family{name: "size_bytes", help: "Sizes of received packets and frames.", kind: "histogram"}
+	for _, name := range logging.SizeNames() {
+		size.samples = append(size.samples, histogramSamples(logging.Size(name), name, sizeBuckets, 1)...)
+	}
+
+	return []*family{target, pacing, cwnd, rtt, queueDelay, loss, packets, bytes, lost, drops, latency, size}
+}
+
+// latencyBuckets are the upper bounds of the exported latency buckets in
+// microseconds.
+var latencyBuckets = []int64{
+	1_000, 2_500, 5_000, 10_000, 25_000, 50_000, 100_000, 250_000, 500_000,
+	1_000_000, 2_500_000, 5_000_000,
+}
+
+// sizeBuckets are the upper bounds of the exported size buckets in bytes.
+var sizeBuckets = []int64{
+	64, 128, 256, 512, 1_024, 1_500, 4_096, 16_384, 65_536, 262_144, 1_048_576,
+}
+
+// histogramSamples returns the '_bucket', '_sum' and '_count' samples of h
+// labeled with name. Bounds and the sum are multiplied by scale to convert
+// them to the base unit of the family.
+func histogramSamples(h *histogram.Histogram, name string, bounds []int64, scale float64) []sample {
+	// the last bound counts all values, so that the buckets are consistent
+	// with the count while values are recorded
+	counts := h.CumulativeCounts(append(bounds[:len(bounds):len(bounds)], math.MaxInt64))
+	count := counts[len(bounds)]
+	if count == 0 {
+		return nil
+	}
+	samples := make([]sample, 0, len(bounds)+3)
+	for i, c := range counts[:len(bounds)] {
+		le := strconv.FormatFloat(float64(bounds[i])*scale, 'g', -1, 64)
+		samples = append(samples, sample{"_bucket", labels("name", name, "le", le), float64(c)})
+	}
+	l := labels("name", name)
+	return append(samples,
+		sample{"_bucket", labels("name", name, "le", "+Inf"), float64(count)},
+		sample{"_sum", l, h.Sum() * scale},
+		sample{"_count", l, float64(count)},
+	)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats pairs of label names and values.
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%v="%v"`, pairs[i], labelValueEscaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+)
+
+// Formats of the stats written by WriteStats.
+const (
+	StatsCSV    = "csv"
+	StatsNDJSON = "ndjson"
+)
+
+var errInvalidStatsFormat = errors.New("invalid stats format")
+
+type column struct {
+	name  string
+	value float64
+}
+
+// statsWriter writes one row per interval. The columns are fixed when the
+// writer is created, so that every row has the same columns.
+type statsWriter struct {
+	w         io.Writer
+	format    string
+	names     []string
+	sources   map[string]cc.MetricsSource
+	traffic   *rtp.TrafficCounter
+	direction rtp.Direction
+	start     time.Time
+
+	lastBytes   uint64
+	lastPackets uint64
+	lastLost    uint64
+	lastSample  time.Time
+}
+
+// WriteStats writes a row every interval to file until ctx is done. A row
+// has the time in seconds since the start, the bitrate, packets and lost
+// packets of all flows of direction in the interval, and the target
+// bitrate, pacing rate, cwnd, RTT, queue delay and loss rate of every
+// source, prefixed with the source name. Rows are written as CSV with a
+// header or as newline delimited JSON objects, depending on format.
+func WriteStats(ctx context.Context, file, format string, interval time.Duration, sources map[string]cc.MetricsSource, traffic *rtp.TrafficCounter, direction rtp.Direction) error {
+	if format != StatsCSV && format != StatsNDJSON {
+		return fmt.Errorf("%w: %v", errInvalidStatsFormat, format)
+	}
+	w, err := logging.GetLogFile(file)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	s := &statsWriter{
+		w:          w,
+		format:     format,
+		names:      names,
+		sources:    sources,
+		traffic:    traffic,
+		direction:  direction,
+		start:      now,
+		lastSample: now,
+	}
+	if format == StatsCSV {
+		header := []string{}
+		for _, c := range s.sample(now) {
+			header = append(header, c.name)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(header, ",")); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := s.write(s.sample(now)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *statsWriter) sample(now time.Time) []column {
+	var bytes, packets, lost uint64
+	if s.traffic != nil {
+		for _, f := range s.traffic.Flows() {
+			if f.Direction != s.direction {
+				continue
+			}
+			bytes += f.Bytes
+			packets += f.Packets
+			lost += f.Lost
+		}
+	}
+	bitrate := 0.0
+	if d := now.Sub(s.lastSample).Seconds(); d > 0 {
+		bitrate = float64(bytes-s.lastBytes) * 8 / d
+	}
+	columns := []column{
+		{"time_s", now.Sub(s.start).Seconds()},
+		{"bitrate_bps", bitrate},
+		{"packets", float64(packets - s.lastPackets)},
+		{"lost", float64(lost - s.lastLost)},
+	}
+	s.lastBytes, s.lastPackets, s.lastLost = bytes, packets, lost
+	s.lastSample = now
+
+	for _, name := range s.names {
+		m := s.sources[name].Metrics()
+		columns = append(columns,
+			column{name + "_target_bps", float64(m.TargetBitrate)},
+			column{name + "_pacing_bps", float64(m.PacingRate)},
+			column{name + "_cwnd_bytes", float64(m.Cwnd)},
+			column{name + "_rtt_ms", float64(m.RTT) / float64(time.Millisecond)},
+			column{name + "_queue_delay_ms", float64(m.QueueDelay) / float64(time.Millisecond)},
+			column{name + "_loss_rate", m.LossRate},
+		)
+	}
+	return columns
+}
+
+func (s *statsWriter) write(columns []column) error {
+	var b strings.Builder
+	if s.format == StatsNDJSON {
+		b.WriteByte('{')
+	}
+	for i, c := range columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if s.format == StatsNDJSON {
+			b.WriteString(strconv.Quote(c.name))
+			b.WriteByte(':')
+		}
+		b.WriteString(strconv.FormatFloat(c.value, 'f', -1, 64))
+	}
+	if s.format == StatsNDJSON {
+		b.WriteByte('}')
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(s.w, b.String())
+	return err
+}
@@ -0,0 +1,191 @@
+// Package nada provides an interceptor implementing NADA congestion control
+// (RFC 8698) based on RFC 8888 feedback
+package nada
+
+import (
+	"math"
+	"time"
+)
+
+// Default parameters as given in RFC 8698, Figure 3.
+const (
+	prio      = 1.0
+	xRef      = 10 * time.Millisecond
+	kappa     = 0.5
+	eta       = 2.0
+	tau       = 500 * time.Millisecond
+	delta     = 100 * time.Millisecond
+	logWin    = 500 * time.Millisecond
+	qEps      = 10 * time.Millisecond
+	dFilt     = 120 * time.Millisecond
+	gammaMax  = 0.5
+	qBound    = 50 * time.Millisecond
+	dLoss     = 10 * time.Millisecond
+	dMark     = 2 * time.Millisecond
+	plrRef    = 0.01
+	pmrRef    = 0.01
+	lossAlpha = 0.1
+
+	minFilterLength = 15
+)
+
+type mode int
+
+const (
+	accelerated mode = iota
+	gradual
+)
+
+func (m mode) String() string {
+	if m == accelerated {
+		return "accelerated"
+	}
+	return "gradual"
+}
+
+// sample is a packet reported in a feedback report. sent is the send time in
+// the sender clock, arrival the arrival time in the receiver clock, both
+// relative to an arbitrary but fixed epoch.
+type sample struct {
+	sent     time.Duration
+	arrival  time.Duration
+	size     int
+	received bool
+	marked   bool
+}
+
+type rateSample struct {
+	at    time.Time
+	bytes int
+}
+
+// controller implements the sender side reference rate calculation of NADA.
+// The receiver side congestion signal is calculated at the sender from the
+// RFC 8888 feedback, as allowed by RFC 8698, Section 4.4.
+type controller struct {
+	minRate float64
+	maxRate float64
+	refRate float64
+
+	lastUpdate    time.Time
+	lastCongested time.Time
+	mode          mode
+
+	baseDelay  time.Duration
+	fwdDelays  []time.Duration
+	queueDelay time.Duration
+	rtt        time.Duration
+	lossRatio  float64
+	markRatio  float64
+	prevSignal time.Duration
+	signal     time.Duration
+
+	received []rateSample
+	recvRate float64
+}
+
+func newController(minRate, initialRate, maxRate float64) *controller {
+	return &controller{
+		minRate:   minRate,
+		maxRate:   maxRate,
+		refRate:   initialRate,
+		mode:      accelerated,
+		baseDelay: time.Duration(math.MaxInt64),
+	}
+}
+
+// onFeedback updates the reference rate with the samples of one feedback
+// report received at now.
+func (c *controller) onFeedback(now time.Time, samples []sample, rtt time.Duration) {
+	if rtt > 0 {
+		c.rtt = rtt
+	}
+	lost, marked, received := 0, 0, 0
+	for _, s := range samples {
+		if !s.received {
+			lost++
+			continue
+		}
+		received++
+		c.received = append(c.received, rateSample{at: now, bytes: s.size})
+		if s.marked {
+			marked++
+		}
+		fwd := s.arrival - s.sent
+		if fwd < c.baseDelay {
+			c.baseDelay = fwd
+		}
+		c.fwdDelays = append(c.fwdDelays, fwd)
+		if len(c.fwdDelays) > minFilterLength {
+			c.fwdDelays = c.fwdDelays[1:]
+		}
+	}
+	if lost+received == 0 {
+		return
+	}
+	c.updateQueueDelay()
+	c.lossRatio += lossAlpha * (float64(lost)/float64(lost+received) - c.lossRatio)
+	if received > 0 {
+		c.markRatio += lossAlpha * (float64(marked)/float64(received) - c.markRatio)
+	}
+	c.updateReceiveRate(now)
+
+	if lost > 0 || marked > 0 || c.queueDelay >= qEps {
+		c.lastCongested = now
+	}
+	c.mode = gradual
+	if c.lastCongested.IsZero() || now.Sub(c.lastCongested) >= logWin {
+		c.mode = accelerated
+	}
+
+	c.signal = c.queueDelay +
+		time.Duration(float64(dLoss)*math.Pow(c.lossRatio/plrRef, 2)) +
+		time.Duration(float64(dMark)*math.Pow(c.markRatio/pmrRef, 2))
+
+	interval := delta
+	if !c.lastUpdate.IsZero() {
+		interval = now.Sub(c.lastUpdate)
+	}
+	c.lastUpdate = now
+
+	switch c.mode {
+	case accelerated:
+		gamma := math.Min(gammaMax, qBound.Seconds()/(c.rtt+delta+dFilt).Seconds())
+		c.refRate = math.Max(c.refRate, (1+gamma)*c.recvRate)
+	case gradual:
+		offset := c.signal.Seconds() - prio*xRef.Seconds()*c.maxRate/c.refRate
+		diff := (c.signal - c.prevSignal).Seconds()
+		c.refRate -= kappa * (interval.Seconds() / tau.Seconds()) * (offset / tau.Seconds()) * c.refRate
+		c.refRate -= kappa * eta * (diff / tau.Seconds()) * c.refRate
+	}
+	c.prevSignal = c.signal
+	c.refRate = math.Max(c.minRate, math.Min(c.maxRate, c.refRate))
+}
+
+// updateQueueDelay applies a minimum filter to the recent forward delays to
+// remove outliers and subtracts the base delay.
+func (c *controller) updateQueueDelay() {
+	if len(c.fwdDelays) == 0 {
+		return
+	}
+	min := c.fwdDelays[0]
+	for _, d := range c.fwdDelays[1:] {
+		if d < min {
+			min = d
+		}
+	}
+	c.queueDelay = min - c.baseDelay
+}
+
+func (c *controller) updateReceiveRate(now time.Time) {
+	i := 0
+	for i < len(c.received) && now.Sub(c.received[i].at) > logWin {
+		i++
+	}
+	c.received = c.received[i:]
+	bytes := 0
+	for _, s := range c.received {
+		bytes += s.bytes
+	}
+	c.recvRate = 8 * float64(bytes) / logWin.Seconds()
+}
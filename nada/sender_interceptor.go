@@ -0,0 +1,256 @@
+package nada
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const (
+	historySize = 1 << 12
+
+	// arrivalTimeOffsetOverrange is used by RFC 8888 for packets which
+	// arrived too long before the report was generated.
+	arrivalTimeOffsetOverrange = 0x1FFF
+)
+
+type BandwidthEstimator interface {
+	GetTargetBitrate() int
+	GetStats() map[string]interface{}
+	Metrics() cc.Metrics
+}
+
+type NewPeerConnectionCallback func(id string, estimator BandwidthEstimator)
+
+type sentPacket struct {
+	valid    bool
+	reported bool
+	lost     bool
+	seqNr    uint16
+	sent     time.Duration
+	size     int
+}
+
+type history [historySize]sentPacket
+
+type SenderInterceptorFactory struct {
+	opts              []SenderOption
+	addPeerConnection NewPeerConnectionCallback
+}
+
+func NewSenderInterceptor(opts ...SenderOption) (*SenderInterceptorFactory, error) {
+	return &SenderInterceptorFactory{
+		opts: opts,
+	}, nil
+}
+
+func (f *SenderInterceptorFactory) OnNewPeerConnection(cb NewPeerConnectionCallback) {
+	f.addPeerConnection = cb
+}
+
+func (f *SenderInterceptorFactory) NewInterceptor(id string) (interceptor.Interceptor, error) {
+	s := &SenderInterceptor{
+		NoOp:           interceptor.NoOp{},
+		log:            logging.NewDefaultLoggerFactory().NewLogger("nada_sender"),
+		histories:      map[uint32]*history{},
+		minBitrate:     100_000,
+		initialBitrate: 500_000,
+		maxBitrate:     100_000_000,
+	}
+	for _, opt := range f.opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	s.controller = newController(s.minBitrate, s.initialBitrate, s.maxBitrate)
+	if f.addPeerConnection != nil {
+		f.addPeerConnection(id, s)
+	}
+	return s, nil
+}
+
+// SenderInterceptor performs NADA congestion control. It does not pace
+// packets, the media source is expected to follow the target bitrate.
+type SenderInterceptor struct {
+	interceptor.NoOp
+	m   sync.Mutex
+	log logging.LeveledLogger
+
+	histories  map[uint32]*history
+	controller *controller
+
+	minBitrate     float64
+	initialBitrate float64
+	maxBitrate     float64
+}
+
+// BindLocalStream records the send time of all outgoing packets.
+func (s *SenderInterceptor) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		sent := compactNTP(ntpTime32(time.Now()))
+		s.m.Lock()
+		h, ok := s.histories[header.SSRC]
+		if !ok {
+			h = &history{}
+			s.histories[header.SSRC] = h
+		}
+		h[header.SequenceNumber%historySize] = sentPacket{
+			valid: true,
+			seqNr: header.SequenceNumber,
+			sent:  sent,
+			size:  header.MarshalSize() + len(payload),
+		}
+		s.m.Unlock()
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// BindRTCPReader feeds incoming RFC 8888 feedback reports to the controller.
+func (s *SenderInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return 0, nil, err
+		}
+		now := time.Now()
+		if ts, ok := a["timestamp"]; ok {
+			if t, ok := ts.(time.Time); ok {
+				now = t
+			}
+		}
+		buf := make([]byte, n)
+		copy(buf, b)
+		pkts, err := rtcp.Unmarshal(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, pkt := range pkts {
+			var report *rtcp.CCFeedbackReport
+			switch p := pkt.(type) {
+			case *rtcp.CCFeedbackReport:
+				report = p
+			case *rtcp.RawPacket:
+				report = &rtcp.CCFeedbackReport{}
+				if err := report.Unmarshal(*p); err != nil {
+					s.log.Infof("got incorrect packet type, skipping feedback: %v", err)
+					continue
+				}
+			default:
+				continue
+			}
+			s.onFeedback(now, report)
+		}
+		return n, attr, nil
+	})
+}
+
+func (s *SenderInterceptor) onFeedback(now time.Time, report *rtcp.CCFeedbackReport) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	reportTime := compactNTP(report.ReportTimestamp)
+	nowNTP := compactNTP(ntpTime32(now))
+	rtt := time.Duration(0)
+	samples := []sample{}
+	for _, block := range report.ReportBlocks {
+		h, ok := s.histories[block.MediaSSRC]
+		if !ok {
+			continue
+		}
+		for i, metric := range block.MetricBlocks {
+			seqNr := block.BeginSequence + uint16(i)
+			p := &h[seqNr%historySize]
+			if !p.valid || p.seqNr != seqNr || p.reported {
+				continue
+			}
+			if !metric.Received {
+				if p.lost {
+					continue
+				}
+				p.lost = true
+				samples = append(samples, sample{
+					sent: p.sent,
+					size: p.size,
+				})
+				continue
+			}
+			p.reported = true
+			if metric.ArrivalTimeOffset == arrivalTimeOffsetOverrange {
+				continue
+			}
+			offset := time.Duration(metric.ArrivalTimeOffset) * time.Second / 1024
+			samples = append(samples, sample{
+				sent:     p.sent,
+				arrival:  reportTime - offset,
+				size:     p.size,
+				received: true,
+				marked:   metric.ECN == rtcp.ECNCE,
+			})
+			// The time the packet spent at the receiver before the report
+			// was generated does not count towards the RTT.
+			if r := nowNTP - p.sent - offset; r > 0 {
+				rtt = r
+			}
+		}
+	}
+	s.controller.onFeedback(now, samples, rtt)
+}
+
+// GetTargetBitrate returns the reference rate calculated by NADA in bps.
+func (s *SenderInterceptor) GetTargetBitrate() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return int(s.controller.refRate)
+}
+
+func (s *SenderInterceptor) GetStats() map[string]interface{} {
+	s.m.Lock()
+	defer s.m.Unlock()
+	c := s.controller
+	return map[string]interface{}{
+		"refRate":    int(c.refRate),
+		"recvRate":   int(c.recvRate),
+		"queueDelay": c.queueDelay.Milliseconds(),
+		"rtt":        c.rtt.Milliseconds(),
+		"lossRatio":  c.lossRatio,
+		"markRatio":  c.markRatio,
+		"signal":     c.signal.Milliseconds(),
+		"mode":       c.mode.String(),
+	}
+}
+
+// Metrics returns the reference rate, RTT, queuing delay and loss ratio
+// calculated by NADA.
+func (s *SenderInterceptor) Metrics() cc.Metrics {
+	s.m.Lock()
+	defer s.m.Unlock()
+	c := s.controller
+	return cc.Metrics{
+		TargetBitrate: int(c.refRate),
+		RTT:           c.rtt,
+		QueueDelay:    c.queueDelay,
+		LossRate:      c.lossRatio,
+	}
+}
+
+func ntpTime32(t time.Time) uint32 {
+	// seconds since 1st January 1900
+	s := (float64(t.UnixNano()) / 1000000000.0) + 2208988800
+
+	integerPart := uint32(s)
+	fractionalPart := uint32((s - float64(integerPart)) * 0xFFFFFFFF)
+
+	// higher 32 bits are the integer part, lower 32 bits are the fractional part
+	return uint32(((uint64(integerPart)<<32 | uint64(fractionalPart)) >> 16) & 0xFFFFFFFF)
+}
+
+// compactNTP converts a 32 bit NTP timestamp with 16 bit fraction to a
+// duration since the NTP epoch modulo 2^16 seconds.
+func compactNTP(t uint32) time.Duration {
+	return time.Duration(uint64(t) * uint64(time.Second) >> 16)
+}
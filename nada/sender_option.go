@@ -0,0 +1,25 @@
+package nada
+
+// SenderOption can be used to configure SenderInterceptor.
+type SenderOption func(r *SenderInterceptor) error
+
+func MinBitrate(rate float64) SenderOption {
+	return func(s *SenderInterceptor) error {
+		s.minBitrate = rate
+		return nil
+	}
+}
+
+func InitialBitrate(rate float64) SenderOption {
+	return func(s *SenderInterceptor) error {
+		s.initialBitrate = rate
+		return nil
+	}
+}
+
+func MaxBitrate(rate float64) SenderOption {
+	return func(s *SenderInterceptor) error {
+		s.maxBitrate = rate
+		return nil
+	}
+}
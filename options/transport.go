@@ -0,0 +1,376 @@
+// Package options validates the transport configuration of senders and
+// receivers as a whole and builds the options of the transport packages
+// from it. The transport packages only validate their own options, settings
+// which are silently ignored by the selected transport are reported here,
+// before any socket is opened.
+package options
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/Willi-42/rtp-over-quic/experimental"
+	"github.com/Willi-42/rtp-over-quic/quic"
+	"github.com/Willi-42/rtp-over-quic/tcp"
+	"github.com/Willi-42/rtp-over-quic/udp"
+)
+
+var (
+	ErrIncompatibleOptions = errors.New("incompatible transport options")
+	errWrongTransport      = errors.New("options built for wrong transport")
+)
+
+// Transport collects the settings of all transports. Zero values are the
+// defaults of the transports.
+type Transport struct {
+	// Transport is one of 'quic', 'quic-dgram', 'quic-stream', 'quic-prio',
+	// 'moq', 'udp', 'tcp' or 'auto'. 'moq' is experimental.
+	Transport string
+	Addr      string
+	// Events receives the events of the transport if set, only QUIC
+	// publishes events.
+	Events *events.Bus
+	// Hooks are called on connection and flow lifecycle events if set, UDP
+	// does not support hooks.
+	Hooks *events.Hooks
+
+	// UDP only
+	ECN bool
+	// UDPBatch is the number of packets sent or received per system call,
+	// 0 or 1 disables batching.
+	UDPBatch int
+	// UDPMTU is the size of the receive buffers of the UDP server, larger
+	// packets are truncated. 0 uses 1500 bytes.
+	UDPMTU int
+	// UDPGSO lets the kernel segment batches of packets of the same size.
+	UDPGSO bool
+
+	// QUIC only
+	BackupAddr      string
+	FailoverTimeout time.Duration
+	QLOGDir         string
+	KeyLogFile      string
+	QUICCC          string
+	LocalRFC8888    bool
+	DataStream      bool
+	FECGroupSize    int
+	// AggregationDelay is the maximum delay added by aggregating small
+	// datagrams, 0 disables aggregation.
+	AggregationDelay time.Duration
+	// SendQueueBudget is the maximum time packets wait in the send queue
+	// before they are dropped according to SendQueuePolicy, 0 disables the
+	// queue.
+	SendQueueBudget time.Duration
+	SendQueuePolicy string
+	// BackpressureTimeout is the maximum time a packet is held back by the
+	// pacer, the congestion controller or the flow control before it is
+	// dropped, 0 blocks until it is sent.
+	BackpressureTimeout time.Duration
+	Pacer               *quic.Pacer
+	PathCache           *quic.PathCache
+	// Reliability is set if single packets may require reliable
+	// transmission, which requires 'quic' or 'quic-prio'.
+	Reliability bool
+	// MaxConnections limits the connections of a server, 0 means
+	// unlimited.
+	MaxConnections int
+	// Reconnect reconnects lost connections, waiting ReconnectBackoff
+	// doubled after each failed attempt up to ReconnectMaxBackoff and giving
+	// up after ReconnectAttempts, 0 means never.
+	Reconnect           bool
+	ReconnectAttempts   int
+	ReconnectBackoff    time.Duration
+	ReconnectMaxBackoff time.Duration
+
+	// TCP only
+	TCPCC string
+}
+
+// Auto selects the first of AutoTransports which connects.
+const Auto = "auto"
+
+// AutoTransports are the transports tried in order by Auto.
+var AutoTransports = []string{"quic-dgram", "quic-stream", "tcp"}
+
+// IsQUIC returns whether transport is one of the QUIC transports.
+func IsQUIC(transport string) bool {
+	switch transport {
+	case "quic", "quic-dgram", "quic-stream", "quic-prio", "moq":
+		return true
+	}
+	return false
+}
+
+// Validate returns an error describing all settings which the transport
+// does not support. Auto is valid if any of AutoTransports supports the
+// settings.
+func (t *Transport) Validate() error {
+	if t.Transport == Auto {
+		return t.validateAuto()
+	}
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch t.QUICCC {
+	case "", "none", "newreno", "reno", "bbr", "copa":
+	default:
+		fail("unknown QUIC congestion control algorithm %v", t.QUICCC)
+	}
+	switch t.TCPCC {
+	case "", "reno", "cubic", "bbr":
+	default:
+		fail("unknown TCP congestion control algorithm %v", t.TCPCC)
+	}
+
+	if t.ECN && t.Transport != "udp" {
+		// quic-go neither sets the ECN field nor reports CE marks to the
+		// application, the TCP kernel stack handles ECN itself
+		fail("ECN requires transport 'udp', got %v", t.Transport)
+	}
+	if t.UDPBatch < 0 {
+		fail("negative UDP batch size %v", t.UDPBatch)
+	}
+	if t.UDPMTU < 0 {
+		fail("negative UDP MTU %v", t.UDPMTU)
+	}
+	if (t.UDPBatch > 1 || t.UDPGSO || t.UDPMTU > 0) && t.Transport != "udp" {
+		// quic-go reads and writes its socket itself
+		fail("UDP batching, GSO and MTU require transport 'udp', got %v", t.Transport)
+	}
+	if t.Hooks != nil && t.Transport == "udp" {
+		fail("lifecycle hooks require a QUIC or TCP transport, got %v", t.Transport)
+	}
+	if t.Transport != "tcp" && len(t.TCPCC) > 0 && t.TCPCC != "reno" {
+		fail("TCP congestion control %v requires transport 'tcp', got %v", t.TCPCC, t.Transport)
+	}
+	switch {
+	case IsQUIC(t.Transport):
+		if t.Reliability && t.Transport != "quic" && t.Transport != "quic-prio" {
+			fail("per packet reliability requires transport 'quic' or 'quic-prio', got %v", t.Transport)
+		}
+		if t.FECGroupSize > 0 && t.Transport == "quic-stream" {
+			fail("FEC only protects datagrams and can't be used with transport 'quic-stream'")
+		}
+		if t.AggregationDelay > 0 && t.Transport == "quic-stream" {
+			fail("aggregation only applies to datagrams and can't be used with transport 'quic-stream'")
+		}
+		if t.BackpressureTimeout < 0 {
+			fail("backpressure timeout must not be negative, got %v", t.BackpressureTimeout)
+		}
+		if t.SendQueueBudget < 0 {
+			fail("send queue budget must not be negative, got %v", t.SendQueueBudget)
+		}
+		if t.SendQueueBudget > 0 && t.SendQueuePolicy != quic.SendQueueDropPackets && t.SendQueuePolicy != quic.SendQueueDropFrames {
+			fail("unknown send queue policy %q, expected one of %v", t.SendQueuePolicy, quic.SendQueuePolicies)
+		}
+		if t.Transport == "moq" {
+			if !experimental.Enabled(experimental.MoQ) {
+				fail("transport 'moq' is experimental and requires the experimental feature %v", experimental.MoQ)
+			}
+			if t.Reliability {
+				fail("per packet reliability can't be used with transport 'moq', which sends all packets on streams")
+			}
+			if t.FECGroupSize > 0 {
+				fail("FEC only protects datagrams and can't be used with transport 'moq'")
+			}
+			if t.AggregationDelay > 0 {
+				fail("aggregation only applies to datagrams and can't be used with transport 'moq'")
+			}
+			if t.LocalRFC8888 {
+				fail("local RFC 8888 feedback can't be used with transport 'moq'")
+			}
+			if t.BackpressureTimeout > 0 {
+				fail("backpressure can't be used with transport 'moq', which writes frames to the streams of groups")
+			}
+		}
+		if t.Reconnect && t.ReconnectAttempts < 0 {
+			fail("negative number of reconnection attempts %v", t.ReconnectAttempts)
+		}
+		if t.Reconnect && (t.ReconnectBackoff <= 0 || t.ReconnectMaxBackoff < t.ReconnectBackoff) {
+			fail("reconnection backoff must be positive and at most the maximum backoff, got %v and %v", t.ReconnectBackoff, t.ReconnectMaxBackoff)
+		}
+	case t.Transport == "udp" || t.Transport == "tcp":
+		quicOnly := []struct {
+			name string
+			set  bool
+		}{
+			{"backup address", len(t.BackupAddr) > 0},
+			{"reconnection", t.Reconnect},
+			{"QLOG", len(t.QLOGDir) > 0},
+			{"TLS key log", len(t.KeyLogFile) > 0},
+			{"QUIC congestion control", len(t.QUICCC) > 0 && t.QUICCC != "none"},
+			{"local RFC 8888 feedback", t.LocalRFC8888},
+			{"data stream", t.DataStream},
+			{"FEC", t.FECGroupSize > 0},
+			{"datagram aggregation", t.AggregationDelay > 0},
+			{"send queue", t.SendQueueBudget > 0},
+			{"backpressure", t.BackpressureTimeout > 0},
+			{"pacer", t.Pacer != nil},
+			{"path cache", t.PathCache != nil},
+			{"per packet reliability", t.Reliability},
+			{"connection limit", t.MaxConnections > 0},
+		}
+		for _, o := range quicOnly {
+			if o.set {
+				fail("%v requires a QUIC transport, got %v", o.name, t.Transport)
+			}
+		}
+	default:
+		fail("unknown transport %v", t.Transport)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrIncompatibleOptions, strings.Join(problems, "; "))
+}
+
+// WithTransport returns a copy of t using transport.
+func (t *Transport) WithTransport(transport string) *Transport {
+	c := *t
+	c.Transport = transport
+	return &c
+}
+
+func (t *Transport) validateAuto() error {
+	var problems []string
+	for _, candidate := range AutoTransports {
+		err := t.WithTransport(candidate).Validate()
+		if err == nil {
+			return nil
+		}
+		problems = append(problems, fmt.Sprintf("%v: %v", candidate, err))
+	}
+	return fmt.Errorf("%w: no transport of %v supports the settings: %v", ErrIncompatibleOptions, Auto, strings.Join(problems, "; "))
+}
+
+// validateFor validates t for building the options of transport, 'quic'
+// matches all QUIC transports.
+func (t *Transport) validateFor(transport string) error {
+	matches := t.Transport == transport
+	if transport == "quic" {
+		matches = IsQUIC(t.Transport)
+	}
+	if !matches {
+		return fmt.Errorf("%w: %v", errWrongTransport, t.Transport)
+	}
+	return t.Validate()
+}
+
+func (t *Transport) quicCC() cc.Algorithm {
+	switch t.QUICCC {
+	case "", "none":
+		return cc.NONE
+	case "newreno":
+		return cc.Reno
+	}
+	return cc.AlgorithmFromString(t.QUICCC)
+}
+
+// QUICSenderOptions validates t and returns the options of a QUIC sender.
+func (t *Transport) QUICSenderOptions() ([]quic.SenderOption, error) {
+	if err := t.validateFor("quic"); err != nil {
+		return nil, err
+	}
+	opts := []quic.SenderOption{
+		quic.RemoteAddress(t.Addr),
+		quic.SetTransportMode(quic.TransportModeFromString(t.Transport)),
+		quic.BackupAddress(t.BackupAddr),
+		quic.SetSenderQLOGDirName(t.QLOGDir),
+		quic.SetSenderSSLKeyLogFileName(t.KeyLogFile),
+		quic.SetSenderQUICCongestionControlAlgorithm(t.quicCC()),
+		quic.SetLocalRFC8888(t.LocalRFC8888),
+		quic.SetFEC(t.FECGroupSize),
+		quic.SetAggregation(t.AggregationDelay),
+		quic.SetPacer(t.Pacer),
+		quic.SetPathCache(t.PathCache),
+		quic.SetSenderEventBus(t.Events),
+		quic.SetSenderHooks(t.Hooks),
+	}
+	if t.FailoverTimeout > 0 {
+		opts = append(opts, quic.FailoverTimeout(t.FailoverTimeout))
+	}
+	if t.SendQueueBudget > 0 {
+		opts = append(opts, quic.SetSendQueue(t.SendQueueBudget, t.SendQueuePolicy))
+	}
+	if t.BackpressureTimeout > 0 {
+		opts = append(opts, quic.SetBackpressure(t.BackpressureTimeout))
+	}
+	if t.Reconnect {
+		opts = append(opts, quic.Reconnect(t.ReconnectAttempts, t.ReconnectBackoff, t.ReconnectMaxBackoff))
+	}
+	return opts, nil
+}
+
+// QUICServerOptions validates t and returns the options of a QUIC server.
+func (t *Transport) QUICServerOptions() ([]quic.ServerOption, error) {
+	if err := t.validateFor("quic"); err != nil {
+		return nil, err
+	}
+	return []quic.ServerOption{
+		quic.LocalAddress(t.Addr),
+		quic.SetServerQLOGDirName(t.QLOGDir),
+		quic.SetServerSSLKeyLogFileName(t.KeyLogFile),
+		quic.SetServerEventBus(t.Events),
+		quic.SetServerHooks(t.Hooks),
+		quic.SetMaxConnections(t.MaxConnections),
+	}, nil
+}
+
+// TCPSenderOptions validates t and returns the options of a TCP sender.
+func (t *Transport) TCPSenderOptions() ([]tcp.SenderOption, error) {
+	if err := t.validateFor("tcp"); err != nil {
+		return nil, err
+	}
+	opts := []tcp.SenderOption{
+		tcp.RemoteAddress(t.Addr),
+		tcp.SetSenderHooks(t.Hooks),
+	}
+	if len(t.TCPCC) > 0 {
+		opts = append(opts, tcp.SetTCPCongestionControlAlgorithm(cc.AlgorithmFromString(t.TCPCC)))
+	}
+	return opts, nil
+}
+
+// TCPServerOptions validates t and returns the options of a TCP server.
+func (t *Transport) TCPServerOptions() ([]tcp.ServerOption, error) {
+	if err := t.validateFor("tcp"); err != nil {
+		return nil, err
+	}
+	return []tcp.ServerOption{
+		tcp.LocalAddress(t.Addr),
+		tcp.SetServerHooks(t.Hooks),
+	}, nil
+}
+
+// UDPSenderOptions validates t and returns the options of a UDP sender.
+func (t *Transport) UDPSenderOptions() ([]udp.SenderOption, error) {
+	if err := t.validateFor("udp"); err != nil {
+		return nil, err
+	}
+	return []udp.SenderOption{
+		udp.RemoteAddress(t.Addr),
+		udp.SetSenderECN(t.ECN),
+		udp.SetBatch(t.UDPBatch),
+		udp.SetGSO(t.UDPGSO),
+	}, nil
+}
+
+// UDPServerOptions validates t and returns the options of a UDP server.
+func (t *Transport) UDPServerOptions() ([]udp.ServerOption, error) {
+	if err := t.validateFor("udp"); err != nil {
+		return nil, err
+	}
+	return []udp.ServerOption{
+		udp.LocalAddress(t.Addr),
+		udp.SetServerECN(t.ECN),
+		udp.SetBatchRead(t.UDPBatch),
+		udp.SetServerMTU(t.UDPMTU),
+	}, nil
+}
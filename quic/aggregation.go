@@ -0,0 +1,152 @@
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/buffers"
+)
+
+// aggregateFlowID is reserved for datagrams carrying multiple length
+// prefixed datagrams, each of which starts with its own flow ID.
+const aggregateFlowID = 1<<62 - 3
+
+var errInvalidAggregate = errors.New("invalid aggregated datagram")
+
+// aggregator collects small datagrams and sends them as one datagram, so
+// that small packets, e.g., audio or FEC repair packets, share the overhead
+// of a QUIC packet. Datagrams are held back for at most maxDelay.
+type aggregator struct {
+	maxSize  int
+	maxDelay time.Duration
+	flowID   []byte
+	send     func([]byte, func(bool, uint64)) (int, error)
+
+	lock    sync.Mutex
+	pending []*buffers.Buffer
+	cbs     []func(bool, uint64)
+	size    int
+	timer   *time.Timer
+}
+
+func newAggregator(maxSize int, maxDelay time.Duration, send func([]byte, func(bool, uint64)) (int, error)) *aggregator {
+	flowID := appendFlowID(nil, aggregateFlowID)
+	return &aggregator{
+		maxSize:  maxSize,
+		maxDelay: maxDelay,
+		flowID:   flowID,
+		send:     send,
+		size:     len(flowID),
+	}
+}
+
+// write aggregates a copy of dgram if at least one more datagram of the same
+// size fits into an aggregated datagram and sends it directly otherwise. cb
+// is called when the datagram which carries dgram is acknowledged or lost.
+func (a *aggregator) write(dgram []byte, cb func(bool, uint64)) (int, error) {
+	if len(a.flowID)+2*(2+len(dgram)) > a.maxSize {
+		// keep the order of the datagrams
+		if err := a.flush(); err != nil {
+			return 0, err
+		}
+		return a.send(dgram, cb)
+	}
+
+	a.lock.Lock()
+	var full []byte
+	var fullCB func(bool, uint64)
+	var release func()
+	if a.size+2+len(dgram) > a.maxSize {
+		full, fullCB, release = a.take()
+	}
+	a.pending = append(a.pending, buffers.Copy(dgram))
+	a.cbs = append(a.cbs, cb)
+	a.size += 2 + len(dgram)
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.maxDelay, func() {
+			if err := a.flush(); err != nil {
+				log.Printf("failed to send aggregated datagram: %v", err)
+			}
+		})
+	}
+	a.lock.Unlock()
+
+	if full != nil {
+		_, err := a.send(full, fullCB)
+		release()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(dgram), nil
+}
+
+func (a *aggregator) flush() error {
+	a.lock.Lock()
+	dgram, cb, release := a.take()
+	a.lock.Unlock()
+	if dgram == nil {
+		return nil
+	}
+	_, err := a.send(dgram, cb)
+	release()
+	return err
+}
+
+// take returns the pending datagrams as one datagram, a callback calling
+// the callbacks of all of them and a function releasing the datagram once it
+// is sent. A single datagram is returned as is. Must be called with the lock
+// held.
+func (a *aggregator) take() ([]byte, func(bool, uint64), func()) {
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	pending, cbs := a.pending, a.cbs
+	a.pending, a.cbs = nil, nil
+	size := a.size
+	a.size = len(a.flowID)
+
+	switch len(pending) {
+	case 0:
+		return nil, nil, nil
+	case 1:
+		return pending[0].B, cbs[0], pending[0].Release
+	}
+	b := buffers.Get(size)
+	dgram := append(b.B[:0], a.flowID...)
+	for _, p := range pending {
+		dgram = appendUint16(dgram, uint16(len(p.B)))
+		dgram = append(dgram, p.B...)
+		p.Release()
+	}
+	return dgram, func(acked bool, owd uint64) {
+		for _, cb := range cbs {
+			if cb != nil {
+				cb(acked, owd)
+			}
+		}
+	}, b.Release
+}
+
+// splitAggregate returns the datagrams contained in the payload of an
+// aggregated datagram.
+func splitAggregate(buf []byte) ([][]byte, error) {
+	var dgrams [][]byte
+	for len(buf) > 0 {
+		if len(buf) < 2 {
+			return dgrams, errInvalidAggregate
+		}
+		length := int(binary.BigEndian.Uint16(buf))
+		buf = buf[2:]
+		if length > len(buf) {
+			return dgrams, errInvalidAggregate
+		}
+		dgrams = append(dgrams, buf[:length])
+		buf = buf[length:]
+	}
+	return dgrams, nil
+}
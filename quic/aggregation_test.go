@@ -0,0 +1,60 @@
+package quic
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitAggregate(t *testing.T) {
+	dgrams, err := splitAggregate([]byte{0x00, 0x01, 0x01, 0x00, 0x00, 0x00, 0x02, 0x02, 0x02})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{{0x01}, {}, {0x02, 0x02}}; !reflect.DeepEqual(dgrams, want) {
+		t.Fatalf("got %x, want %x", dgrams, want)
+	}
+	if _, err := splitAggregate([]byte{0x00, 0x03, 0x01}); !errors.Is(err, errInvalidAggregate) {
+		t.Fatalf("got error %v for truncated datagram, want %v", err, errInvalidAggregate)
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	var sent [][]byte
+	a := newAggregator(60, time.Hour, func(dgram []byte, _ func(bool, uint64)) (int, error) {
+		sent = append(sent, append([]byte(nil), dgram...))
+		return len(dgram), nil
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := a.write(bytes.Repeat([]byte{byte(i)}, 20), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the third datagram doesn't fit next to the first two
+	if len(sent) != 1 {
+		t.Fatalf("got %v datagrams sent before flush, want 1", len(sent))
+	}
+	if err := a.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("got %v datagrams sent after flush, want 2", len(sent))
+	}
+	id := appendFlowID(nil, aggregateFlowID)
+	if !bytes.HasPrefix(sent[0], id) {
+		t.Fatalf("got %x, want aggregated datagram", sent[0])
+	}
+	dgrams, err := splitAggregate(sent[0][len(id):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dgrams) != 2 || !bytes.Equal(dgrams[1], bytes.Repeat([]byte{1}, 20)) {
+		t.Errorf("got aggregated datagrams %x, want the first two", dgrams)
+	}
+	// a single pending datagram is sent as is
+	if !bytes.Equal(sent[1], bytes.Repeat([]byte{2}, 20)) {
+		t.Errorf("got %x, want the third datagram", sent[1])
+	}
+}
@@ -0,0 +1,50 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/lucas-clemente/quic-go"
+)
+
+// writeContext returns the context bounding the time a packet may wait for
+// the pacer, the congestion controller and the flow control, which is not
+// bounded if backpressure is disabled.
+func (s *Sender) writeContext() (context.Context, context.CancelFunc) {
+	if s.backpressureTimeout <= 0 {
+		return context.Background(), func() {}
+	}
+	return context.WithTimeout(context.Background(), s.backpressureTimeout)
+}
+
+// backpressured returns whether err is caused by the backpressure timeout.
+func (s *Sender) backpressured(err error) bool {
+	if s.backpressureTimeout <= 0 || err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var idle *quic.IdleTimeoutError
+	if errors.As(err, &idle) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// backpressure drops a packet of size bytes which wasn't sent within the
+// backpressure timeout, so that the media source can skip it instead of
+// blocking, and publishes the backpressure for the rate adaptation.
+func (s *Sender) backpressure(transport string, size int, err error) {
+	logging.Drop(logging.DropBackpressure, "%v packet of %v bytes: %v", transport, size, err)
+	s.events.Publish(events.Backpressure{
+		Time:      time.Now(),
+		Transport: transport,
+		Size:      size,
+	})
+}
@@ -0,0 +1,472 @@
+package quic
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+)
+
+// BBRv2 parameters as described in draft-cardwell-iccrg-bbr-congestion-control-02.
+const (
+	bbrMaxDatagramSize = 1252
+	bbrMinCwnd         = 4 * bbrMaxDatagramSize
+	bbrInitialCwnd     = 10 * bbrMaxDatagramSize
+	bbrInitialRTT      = 100 * time.Millisecond
+
+	bbrStartupPacingGain = 2.885
+	bbrStartupCwndGain   = 2.0
+	bbrDrainPacingGain   = 1 / 2.885
+	bbrCwndGain          = 2.0
+	bbrPacingMarginRatio = 0.99
+
+	bbrProbeDownPacingGain = 0.75
+	bbrProbeUpPacingGain   = 1.25
+	bbrProbeUpCwndGain     = 2.25
+
+	bbrFullBWThreshold = 1.25
+	bbrFullBWCount     = 3
+	bbrMaxBWFilterLen  = 2
+
+	bbrLossThreshold = 0.02
+	bbrBeta          = 0.7
+	bbrHeadroom      = 0.85
+
+	bbrMinRTTFilterLen   = 10 * time.Second
+	bbrProbeRTTInterval  = 5 * time.Second
+	bbrProbeRTTDuration  = 200 * time.Millisecond
+	bbrProbeRTTCwndGain  = 0.5
+	bbrMinProbeBWWait    = 2 * time.Second
+	bbrProbeBWWaitJitter = time.Second
+)
+
+type bbrState int
+
+const (
+	bbrStartup bbrState = iota
+	bbrDrain
+	bbrProbeBWDown
+	bbrProbeBWCruise
+	bbrProbeBWRefill
+	bbrProbeBWUp
+	bbrProbeRTT
+)
+
+func (s bbrState) String() string {
+	switch s {
+	case bbrStartup:
+		return "startup"
+	case bbrDrain:
+		return "drain"
+	case bbrProbeBWDown:
+		return "probe_bw_down"
+	case bbrProbeBWCruise:
+		return "probe_bw_cruise"
+	case bbrProbeBWRefill:
+		return "probe_bw_refill"
+	case bbrProbeBWUp:
+		return "probe_bw_up"
+	case bbrProbeRTT:
+		return "probe_rtt"
+	default:
+		return "unknown"
+	}
+}
+
+type bbrPacket struct {
+	size          int
+	delivered     int
+	deliveredTime time.Time
+	appLimited    bool
+}
+
+// bbr is a BBRv2 congestion controller running on top of QUIC connections
+// with disabled congestion control.
+type bbr struct {
+	window
+
+	state   bbrState
+	packets map[int64]*bbrPacket
+
+	delivered     int
+	deliveredTime time.Time
+	lost          int
+	appLimitedEnd int
+
+	roundCount         int
+	nextRoundDelivered int
+	roundStart         bool
+
+	maxBW       [bbrMaxBWFilterLen]float64
+	cycleCount  int
+	bwLo        float64
+	inflightHi  float64
+	inflightLo  float64
+	fullBW      float64
+	fullBWCount int
+	filledPipe  bool
+
+	roundLostStart      int
+	roundDeliveredStart int
+
+	minRTT          time.Duration
+	minRTTStamp     time.Time
+	probeRTTDoneAt  time.Time
+	probeRTTRoundOK bool
+	priorCwnd       float64
+
+	sampleAppLimited bool
+
+	cycleStamp    time.Time
+	probeWait     time.Duration
+	roundsInPhase int
+	pacingGain    float64
+	cwndGain      float64
+}
+
+func newBBR() *bbr {
+	b := &bbr{
+		window:  newWindow(),
+		packets: map[int64]*bbrPacket{},
+	}
+	b.reset()
+	return b
+}
+
+// reset drops all state of the previous connection, e.g., after failing over
+// to another receiver.
+func (b *bbr) reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.state = bbrStartup
+	b.packets = map[int64]*bbrPacket{}
+	b.inflight = 0
+	b.appLimitedEnd = b.delivered
+	b.nextRoundDelivered = b.delivered
+	b.maxBW = [bbrMaxBWFilterLen]float64{}
+	b.bwLo = math.Inf(1)
+	b.inflightHi = math.Inf(1)
+	b.inflightLo = math.Inf(1)
+	b.fullBW = 0
+	b.fullBWCount = 0
+	b.filledPipe = false
+	b.minRTT = 0
+	b.minRTTStamp = time.Time{}
+	b.pacingGain = bbrStartupPacingGain
+	b.cwndGain = bbrStartupCwndGain
+	b.cwnd = bbrInitialCwnd
+	b.pacingRate = bbrStartupPacingGain * 8 * bbrInitialCwnd / bbrInitialRTT.Seconds()
+	b.signal()
+}
+
+func (b *bbr) onPacketSent(pn int64, size int, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.inflight == 0 {
+		b.deliveredTime = now
+	}
+	// Nobody is waiting for the window, so the sender did not saturate
+	// the path and the sample must not lower the bandwidth estimate.
+	if b.waiting == 0 && float64(b.inflight+size) < b.cwnd {
+		b.appLimitedEnd = b.delivered + b.inflight + size
+	}
+	b.packets[pn] = &bbrPacket{
+		size:          size,
+		delivered:     b.delivered,
+		deliveredTime: b.deliveredTime,
+		appLimited:    b.appLimitedEnd > b.delivered,
+	}
+	b.inflight += size
+}
+
+func (b *bbr) onPacketLost(pn int64, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	p, ok := b.packets[pn]
+	if !ok {
+		return
+	}
+	delete(b.packets, pn)
+	b.inflight -= p.size
+	b.lost += p.size
+	if b.isInflightTooHigh() {
+		b.handleInflightTooHigh(now)
+	}
+	b.signal()
+}
+
+func (b *bbr) onPacketAcked(pn int64, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	p, ok := b.packets[pn]
+	if !ok {
+		return
+	}
+	delete(b.packets, pn)
+	b.inflight -= p.size
+	b.delivered += p.size
+	b.deliveredTime = now
+
+	b.updateRound(p)
+	b.updateBW(p, now)
+	b.updateLossResponse(now)
+	b.updateState(now)
+	b.updateControlParameters()
+	b.signal()
+}
+
+func (b *bbr) onRTTSample(rtt time.Duration, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if rtt <= 0 {
+		return
+	}
+	expired := now.Sub(b.minRTTStamp) > bbrMinRTTFilterLen
+	if b.minRTT == 0 || rtt <= b.minRTT || expired {
+		b.minRTT = rtt
+		b.minRTTStamp = now
+	}
+}
+
+func (b *bbr) updateRound(p *bbrPacket) {
+	b.roundStart = false
+	if p.delivered >= b.nextRoundDelivered {
+		b.nextRoundDelivered = b.delivered
+		b.roundCount++
+		b.roundsInPhase++
+		b.roundStart = true
+	}
+}
+
+func (b *bbr) updateBW(p *bbrPacket, now time.Time) {
+	interval := now.Sub(p.deliveredTime)
+	if interval <= 0 {
+		return
+	}
+	rate := 8 * float64(b.delivered-p.delivered) / interval.Seconds()
+	b.sampleAppLimited = p.appLimited
+	// App-limited samples only underestimate the bandwidth, so like all
+	// other samples they can only raise the max filter.
+	if slot := b.cycleCount % bbrMaxBWFilterLen; rate > b.maxBW[slot] {
+		b.maxBW[slot] = rate
+	}
+}
+
+func (b *bbr) bw() float64 {
+	max := 0.0
+	for _, bw := range b.maxBW {
+		if bw > max {
+			max = bw
+		}
+	}
+	return math.Min(max, b.bwLo)
+}
+
+// bdp returns the bandwidth delay product in bytes scaled by gain.
+func (b *bbr) bdp(gain float64) float64 {
+	if b.minRTT == 0 {
+		return bbrInitialCwnd
+	}
+	return gain * b.bw() / 8 * b.minRTT.Seconds()
+}
+
+// isInflightTooHigh returns true if the loss rate of the current round
+// exceeds the threshold.
+func (b *bbr) isInflightTooHigh() bool {
+	lost := b.lost - b.roundLostStart
+	delivered := b.delivered - b.roundDeliveredStart
+	if lost+delivered == 0 {
+		return false
+	}
+	return float64(lost)/float64(lost+delivered) > bbrLossThreshold
+}
+
+func (b *bbr) handleInflightTooHigh(now time.Time) {
+	inflight := math.Max(float64(b.inflight), bbrMinCwnd)
+	switch b.state {
+	case bbrStartup:
+		b.inflightHi = inflight
+		b.filledPipe = true
+	case bbrProbeBWUp:
+		b.inflightHi = math.Max(inflight, bbrBeta*b.bdp(1))
+		b.enterProbeBWDown(now)
+	default:
+		// Outside of probing, reduce the short term bounds.
+		b.bwLo = math.Max(bbrBeta*math.Min(b.bwLo, b.bw()), 8*bbrMinCwnd/bbrInitialRTT.Seconds())
+		b.inflightLo = math.Max(bbrBeta*math.Min(b.inflightLo, b.cwnd), bbrMinCwnd)
+	}
+	b.roundLostStart = b.lost
+	b.roundDeliveredStart = b.delivered
+}
+
+func (b *bbr) updateLossResponse(now time.Time) {
+	if !b.roundStart {
+		return
+	}
+	if b.isInflightTooHigh() {
+		b.handleInflightTooHigh(now)
+	}
+	b.roundLostStart = b.lost
+	b.roundDeliveredStart = b.delivered
+}
+
+func (b *bbr) checkFullPipe() {
+	if b.filledPipe || !b.roundStart || b.sampleAppLimited {
+		return
+	}
+	bw := b.bw()
+	if bw >= b.fullBW*bbrFullBWThreshold {
+		b.fullBW = bw
+		b.fullBWCount = 0
+		return
+	}
+	b.fullBWCount++
+	if b.fullBWCount >= bbrFullBWCount {
+		b.filledPipe = true
+	}
+}
+
+func (b *bbr) updateState(now time.Time) {
+	switch b.state {
+	case bbrStartup:
+		b.checkFullPipe()
+		if b.filledPipe {
+			b.state = bbrDrain
+		}
+	case bbrDrain:
+		if float64(b.inflight) <= b.bdp(1) {
+			b.enterProbeBWDown(now)
+		}
+	case bbrProbeBWDown:
+		if float64(b.inflight) <= math.Min(b.bdp(1), bbrHeadroom*b.inflightHi) {
+			b.state = bbrProbeBWCruise
+		}
+		b.checkProbeBWWait(now)
+	case bbrProbeBWCruise:
+		b.checkProbeBWWait(now)
+	case bbrProbeBWRefill:
+		if b.roundStart {
+			b.state = bbrProbeBWUp
+			b.roundsInPhase = 0
+		}
+	case bbrProbeBWUp:
+		if b.roundsInPhase > 0 && float64(b.inflight) > b.bdp(bbrProbeUpPacingGain) {
+			b.inflightHi = math.Max(b.inflightHi, float64(b.inflight))
+			b.enterProbeBWDown(now)
+		}
+	case bbrProbeRTT:
+		if b.probeRTTDoneAt.IsZero() && float64(b.inflight) <= b.bdp(bbrProbeRTTCwndGain) {
+			b.probeRTTDoneAt = now.Add(bbrProbeRTTDuration)
+			b.probeRTTRoundOK = false
+			b.nextRoundDelivered = b.delivered
+		} else if !b.probeRTTDoneAt.IsZero() {
+			if b.roundStart {
+				b.probeRTTRoundOK = true
+			}
+			if b.probeRTTRoundOK && now.After(b.probeRTTDoneAt) {
+				b.minRTTStamp = now
+				b.cwnd = math.Max(b.cwnd, b.priorCwnd)
+				if b.filledPipe {
+					b.enterProbeBWDown(now)
+				} else {
+					b.state = bbrStartup
+				}
+			}
+		}
+	}
+
+	if b.state != bbrProbeRTT && !b.minRTTStamp.IsZero() && now.Sub(b.minRTTStamp) > bbrProbeRTTInterval {
+		b.priorCwnd = b.cwnd
+		b.probeRTTDoneAt = time.Time{}
+		b.state = bbrProbeRTT
+	}
+}
+
+func (b *bbr) enterProbeBWDown(now time.Time) {
+	b.state = bbrProbeBWDown
+	b.cycleStamp = now
+	b.cycleCount++
+	b.maxBW[b.cycleCount%bbrMaxBWFilterLen] = 0
+	b.roundsInPhase = 0
+	b.probeWait = bbrMinProbeBWWait + time.Duration(rand.Int63n(int64(bbrProbeBWWaitJitter)))
+}
+
+// checkProbeBWWait starts probing for more bandwidth after the randomized
+// wait time expired.
+func (b *bbr) checkProbeBWWait(now time.Time) {
+	if now.Sub(b.cycleStamp) < b.probeWait {
+		return
+	}
+	b.state = bbrProbeBWRefill
+	b.roundsInPhase = 0
+	b.bwLo = math.Inf(1)
+	b.inflightLo = math.Inf(1)
+	b.nextRoundDelivered = b.delivered
+}
+
+func (b *bbr) updateControlParameters() {
+	switch b.state {
+	case bbrStartup:
+		b.pacingGain, b.cwndGain = bbrStartupPacingGain, bbrStartupCwndGain
+	case bbrDrain:
+		b.pacingGain, b.cwndGain = bbrDrainPacingGain, bbrStartupCwndGain
+	case bbrProbeBWDown:
+		b.pacingGain, b.cwndGain = bbrProbeDownPacingGain, bbrCwndGain
+	case bbrProbeBWCruise, bbrProbeBWRefill:
+		b.pacingGain, b.cwndGain = 1, bbrCwndGain
+	case bbrProbeBWUp:
+		b.pacingGain, b.cwndGain = bbrProbeUpPacingGain, bbrProbeUpCwndGain
+	case bbrProbeRTT:
+		b.pacingGain, b.cwndGain = 1, bbrProbeRTTCwndGain
+	}
+
+	bw := b.bw()
+	if rate := bbrPacingMarginRatio * b.pacingGain * bw; bw > 0 && (b.filledPipe || rate > b.pacingRate) {
+		b.pacingRate = rate
+	}
+
+	cwnd := b.bdp(b.cwndGain)
+	if b.state == bbrProbeBWCruise || b.state == bbrProbeBWDown {
+		cwnd = math.Min(cwnd, bbrHeadroom*b.inflightHi)
+	} else if b.state != bbrProbeBWUp {
+		cwnd = math.Min(cwnd, b.inflightHi)
+	}
+	cwnd = math.Min(cwnd, b.inflightLo)
+	if !b.filledPipe && b.state == bbrStartup {
+		// Do not shrink the window before the first bandwidth estimate.
+		cwnd = math.Max(cwnd, b.cwnd)
+	}
+	b.cwnd = math.Max(cwnd, bbrMinCwnd)
+}
+
+// targetRate returns the estimated bottleneck bandwidth in bits per second.
+func (b *bbr) targetRate() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.rate()
+}
+
+func (b *bbr) rate() int {
+	if bw := b.bw(); !math.IsInf(bw, 1) {
+		return int(bw)
+	}
+	return 0
+}
+
+func (b *bbr) metrics() cc.Metrics {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	m := b.windowMetrics()
+	m.TargetBitrate = b.rate()
+	m.RTT = b.minRTT
+	if total := b.lost + b.delivered; total > 0 {
+		m.LossRate = float64(b.lost) / float64(total)
+	}
+	return m
+}
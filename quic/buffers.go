@@ -0,0 +1,19 @@
+package quic
+
+import (
+	pionrtp "github.com/pion/rtp"
+)
+
+// marshalPacket writes id followed by the header and the payload to buf,
+// which must be large enough, and returns the written part of buf, so that
+// sending a packet copies it once.
+func marshalPacket(buf, id []byte, header *pionrtp.Header, payload []byte) ([]byte, error) {
+	n := copy(buf, id)
+	m, err := header.MarshalTo(buf[n:])
+	if err != nil {
+		return nil, err
+	}
+	n += m
+	n += copy(buf[n:], payload)
+	return buf[:n], nil
+}
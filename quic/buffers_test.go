@@ -0,0 +1,93 @@
+package quic
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/Willi-42/rtp-over-quic/buffers"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/pion/interceptor"
+	pionrtp "github.com/pion/rtp"
+)
+
+// discardConnection drops the datagrams sent on it, other methods of
+// quic.Connection are not implemented.
+type discardConnection struct {
+	quic.Connection
+}
+
+func (discardConnection) SendMessage([]byte, func(bool, uint64)) error {
+	return nil
+}
+
+func (discardConnection) ConnectionState() quic.ConnectionState {
+	return quic.ConnectionState{SupportsDatagrams: true}
+}
+
+func (discardConnection) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4243}
+}
+
+func (discardConnection) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4242}
+}
+
+func TestMarshalPacket(t *testing.T) {
+	header := &pionrtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 1, SSRC: 1}
+	id := []byte{0x40, 0x80}
+	payload := []byte{1, 2, 3}
+	pl, err := marshalPacket(make([]byte, 1500), id, header, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pkt pionrtp.Packet
+	if err := pkt.Unmarshal(pl[len(id):]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(pl, id) || pkt.SequenceNumber != 1 || !bytes.Equal(pkt.Payload, payload) {
+		t.Fatalf("got %x, want flow ID %x, sequence number 1 and payload %x", pl, id, payload)
+	}
+	if _, err := marshalPacket(make([]byte, 4), id, header, payload); err == nil {
+		t.Fatal("marshalled packet into too small buffer")
+	}
+}
+
+func BenchmarkMarshalPacket(b *testing.B) {
+	id := []byte{0x01}
+	header := &pionrtp.Header{Version: 2, PayloadType: 96, SSRC: 1}
+	payload := make([]byte, 1200)
+	size := len(id) + header.MarshalSize() + len(payload)
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	for i := 0; i < b.N; i++ {
+		buf := buffers.Get(size)
+		if _, err := marshalPacket(buf.B, id, header, payload); err != nil {
+			b.Fatal(err)
+		}
+		buf.Release()
+	}
+}
+
+func BenchmarkSenderWrite(b *testing.B) {
+	s, err := NewSender(&interceptor.Registry{}, SetTransportMode(DGRAM))
+	if err != nil {
+		b.Fatal(err)
+	}
+	s.conn = discardConnection{}
+	s.interceptor = &interceptor.NoOp{}
+	w, err := s.NewMediaStream()
+	if err != nil {
+		b.Fatal(err)
+	}
+	header := &pionrtp.Header{Version: 2, PayloadType: 96, SSRC: 1}
+	payload := make([]byte, 1200)
+	b.ReportAllocs()
+	b.SetBytes(int64(header.MarshalSize() + len(payload)))
+	for i := 0; i < b.N; i++ {
+		header.SequenceNumber = uint16(i)
+		if _, err := w.Write(header, payload, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
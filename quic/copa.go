@@ -0,0 +1,234 @@
+package quic
+
+import (
+	"math"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+)
+
+// Copa parameters as described in "Copa: Practical Delay-Based Congestion
+// Control for the Internet" (Arun and Balakrishnan, NSDI 2018).
+const (
+	copaMaxDatagramSize = 1252
+	copaMinCwnd         = 4 * copaMaxDatagramSize
+	copaInitialCwnd     = 10 * copaMaxDatagramSize
+	copaInitialRTT      = 100 * time.Millisecond
+
+	// copaDelta is the latency vs. throughput tradeoff of the default
+	// mode, smaller values favor throughput.
+	copaDelta = 0.5
+
+	copaMinRTTWindow      = 10 * time.Second
+	copaMaxVelocity       = 1 << 16
+	copaVelocityThreshold = 3
+)
+
+type copaRTTSample struct {
+	rtt time.Duration
+	at  time.Time
+}
+
+// copa is the default mode of the Copa congestion controller. It adjusts the
+// congestion window towards the target rate 1/(delta*dq), where dq is the
+// queueing delay estimated from the standing RTT and the minimum RTT.
+type copa struct {
+	window
+
+	packets map[int64]int
+
+	slowStart bool
+	velocity  float64
+	direction int
+	sameDir   int
+
+	lastDirectionCheck time.Time
+	cwndAtCheck        float64
+
+	srtt        time.Duration
+	minRTT      time.Duration
+	minRTTStamp time.Time
+	samples     []copaRTTSample
+	standingRTT time.Duration
+}
+
+func newCopa() *copa {
+	c := &copa{
+		window:  newWindow(),
+		packets: map[int64]int{},
+	}
+	c.reset()
+	return c
+}
+
+func (c *copa) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.packets = map[int64]int{}
+	c.inflight = 0
+	c.cwnd = copaInitialCwnd
+	c.pacingRate = 2 * 8 * copaInitialCwnd / copaInitialRTT.Seconds()
+	c.slowStart = true
+	c.velocity = 1
+	c.direction = 0
+	c.sameDir = 0
+	c.lastDirectionCheck = time.Time{}
+	c.srtt = 0
+	c.minRTT = 0
+	c.minRTTStamp = time.Time{}
+	c.samples = nil
+	c.standingRTT = 0
+	c.signal()
+}
+
+func (c *copa) onPacketSent(pn int64, size int, _ time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.packets[pn] = size
+	c.inflight += size
+}
+
+func (c *copa) onPacketLost(pn int64, _ time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	size, ok := c.packets[pn]
+	if !ok {
+		return
+	}
+	delete(c.packets, pn)
+	c.inflight -= size
+	c.signal()
+}
+
+func (c *copa) onRTTSample(rtt time.Duration, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if rtt <= 0 {
+		return
+	}
+	if c.srtt == 0 {
+		c.srtt = rtt
+	} else {
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	if c.minRTT == 0 || rtt <= c.minRTT || now.Sub(c.minRTTStamp) > copaMinRTTWindow {
+		c.minRTT = rtt
+		c.minRTTStamp = now
+	}
+
+	// The standing RTT is the minimum RTT over the last srtt/2, which
+	// filters out ACK compression and delayed ACKs.
+	c.samples = append(c.samples, copaRTTSample{rtt: rtt, at: now})
+	i := 0
+	for i < len(c.samples)-1 && now.Sub(c.samples[i].at) > c.srtt/2 {
+		i++
+	}
+	c.samples = c.samples[i:]
+	c.standingRTT = c.samples[0].rtt
+	for _, s := range c.samples[1:] {
+		if s.rtt < c.standingRTT {
+			c.standingRTT = s.rtt
+		}
+	}
+}
+
+func (c *copa) onPacketAcked(pn int64, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	size, ok := c.packets[pn]
+	if !ok {
+		return
+	}
+	delete(c.packets, pn)
+	c.inflight -= size
+	defer c.signal()
+
+	if c.standingRTT == 0 {
+		return
+	}
+
+	// current rate and target rate in bytes per second
+	rate := c.cwnd / c.standingRTT.Seconds()
+	target := math.Inf(1)
+	if dq := c.standingRTT - c.minRTT; dq > 0 {
+		target = copaMaxDatagramSize / (copaDelta * dq.Seconds())
+	}
+
+	if c.slowStart {
+		if rate <= target {
+			c.cwnd += float64(size)
+		} else {
+			c.slowStart = false
+		}
+	}
+	if !c.slowStart {
+		c.updateVelocity(now)
+		step := c.velocity * copaMaxDatagramSize * float64(size) / (copaDelta * c.cwnd)
+		if rate <= target {
+			c.cwnd += step
+		} else {
+			c.cwnd -= step
+		}
+	}
+	c.cwnd = math.Max(c.cwnd, copaMinCwnd)
+	c.pacingRate = 2 * 8 * c.cwnd / c.standingRTT.Seconds()
+}
+
+// updateVelocity doubles the velocity once the window moved in the same
+// direction for copaVelocityThreshold RTTs and resets it when the direction
+// changes.
+func (c *copa) updateVelocity(now time.Time) {
+	if c.lastDirectionCheck.IsZero() {
+		c.lastDirectionCheck = now
+		c.cwndAtCheck = c.cwnd
+		return
+	}
+	if now.Sub(c.lastDirectionCheck) < c.srtt {
+		return
+	}
+	direction := 1
+	if c.cwnd < c.cwndAtCheck {
+		direction = -1
+	}
+	if direction == c.direction {
+		c.sameDir++
+		if c.sameDir >= copaVelocityThreshold {
+			c.velocity = math.Min(2*c.velocity, copaMaxVelocity)
+		}
+	} else {
+		c.direction = direction
+		c.sameDir = 0
+		c.velocity = 1
+	}
+	c.lastDirectionCheck = now
+	c.cwndAtCheck = c.cwnd
+}
+
+// targetRate returns cwnd/RTTstanding in bits per second.
+func (c *copa) targetRate() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.rate()
+}
+
+func (c *copa) rate() int {
+	if c.standingRTT == 0 {
+		return 0
+	}
+	return int(8 * c.cwnd / c.standingRTT.Seconds())
+}
+
+func (c *copa) metrics() cc.Metrics {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	m := c.windowMetrics()
+	m.TargetBitrate = c.rate()
+	m.RTT = c.srtt
+	if c.standingRTT > c.minRTT {
+		m.QueueDelay = c.standingRTT - c.minRTT
+	}
+	return m
+}
@@ -0,0 +1,62 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// Application error codes of RTP over QUIC, which close connections.
+const (
+	// ErrorCodeNoError closes a connection on shutdown or after an RTCP BYE.
+	ErrorCodeNoError quic.ApplicationErrorCode = 0x00
+	// ErrorCodeGeneralError closes a connection which the peer does not
+	// accept, e.g., beyond the connection limit.
+	ErrorCodeGeneralError quic.ApplicationErrorCode = 0x01
+	// ErrorCodeInternalError closes a connection for which the media
+	// pipeline could not be set up.
+	ErrorCodeInternalError quic.ApplicationErrorCode = 0x02
+	// ErrorCodePacketError closes a connection carrying malformed packets.
+	ErrorCodePacketError quic.ApplicationErrorCode = 0x03
+	// ErrorCodeStreamCreationError closes a connection on which a stream
+	// could not be opened.
+	ErrorCodeStreamCreationError quic.ApplicationErrorCode = 0x04
+	// ErrorCodeFrameCancelled resets a stream carrying a frame which is no
+	// longer needed.
+	ErrorCodeFrameCancelled quic.ApplicationErrorCode = 0x05
+	// ErrorCodeUnknownFlowID closes a connection carrying an unknown flow.
+	ErrorCodeUnknownFlowID quic.ApplicationErrorCode = 0x06
+	// ErrorCodeExpectationUnmet closes a connection whose peer does not
+	// support what is required, e.g., datagrams.
+	ErrorCodeExpectationUnmet quic.ApplicationErrorCode = 0x07
+)
+
+var errorCodeNames = map[quic.ApplicationErrorCode]string{
+	ErrorCodeNoError:             "ROQ_NO_ERROR",
+	ErrorCodeGeneralError:        "ROQ_GENERAL_ERROR",
+	ErrorCodeInternalError:       "ROQ_INTERNAL_ERROR",
+	ErrorCodePacketError:         "ROQ_PACKET_ERROR",
+	ErrorCodeStreamCreationError: "ROQ_STREAM_CREATION_ERROR",
+	ErrorCodeFrameCancelled:      "ROQ_FRAME_CANCELLED",
+	ErrorCodeUnknownFlowID:       "ROQ_UNKNOWN_FLOW_ID",
+	ErrorCodeExpectationUnmet:    "ROQ_EXPECTATION_UNMET",
+}
+
+// ErrorCodeName returns the name of code, e.g., 'ROQ_NO_ERROR'.
+func ErrorCodeName(code quic.ApplicationErrorCode) string {
+	if name, ok := errorCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown error code %#x", uint64(code))
+}
+
+// closedByPeer returns the application error with which the peer closed the
+// connection, if err is one.
+func closedByPeer(err error) (*quic.ApplicationError, bool) {
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) && appErr.Remote {
+		return appErr, true
+	}
+	return nil, false
+}
@@ -0,0 +1,221 @@
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/Willi-42/rtp-over-quic/logging"
+)
+
+// Flow IDs reserved for transport level FEC. Source datagrams are prefixed
+// with fecSourceFlowID and a FEC sequence number, the prefixed datagram
+// contains the flow ID of the media flow. Repair datagrams are sent on
+// fecRepairFlowID.
+const (
+	fecSourceFlowID = 1<<62 - 1
+	fecRepairFlowID = 1<<62 - 2
+
+	// fecSourceOverhead is the number of bytes added to source datagrams.
+	fecSourceOverhead = 8 + 2
+
+	fecHistorySize       = 1024
+	fecMaxPendingRepairs = 64
+)
+
+var errInvalidFECDatagram = errors.New("invalid FEC datagram")
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+// xorInto XORs the length prefixed datagram into dst, which has to be large
+// enough.
+func xorInto(dst []byte, dgram []byte) {
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(dgram)))
+	dst[0] ^= length[0]
+	dst[1] ^= length[1]
+	for i, b := range dgram {
+		dst[2+i] ^= b
+	}
+}
+
+// fecEncoder protects groups of n datagrams with one repair datagram, which
+// is the XOR of the length prefixed datagrams of the group. Any single lost
+// datagram of a group can be recovered.
+type fecEncoder struct {
+	lock     sync.Mutex
+	n        int
+	seqNr    uint16
+	first    uint16
+	count    int
+	repair   []byte
+	sourceID []byte
+	repairID []byte
+}
+
+func newFECEncoder(n int) *fecEncoder {
+	return &fecEncoder{
+		n:        n,
+		sourceID: appendFlowID(nil, fecSourceFlowID),
+		repairID: appendFlowID(nil, fecRepairFlowID),
+	}
+}
+
+// protect returns the source datagram to send instead of dgram and a repair
+// datagram once the group is complete, nil otherwise.
+func (e *fecEncoder) protect(dgram []byte) ([]byte, []byte) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	source := make([]byte, 0, len(e.sourceID)+2+len(dgram))
+	source = append(source, e.sourceID...)
+	source = appendUint16(source, e.seqNr)
+	source = append(source, dgram...)
+
+	if e.count == 0 {
+		e.first = e.seqNr
+		e.repair = e.repair[:0]
+	}
+	if missing := 2 + len(dgram) - len(e.repair); missing > 0 {
+		e.repair = append(e.repair, make([]byte, missing)...)
+	}
+	xorInto(e.repair, dgram)
+	e.seqNr++
+	e.count++
+	if e.count < e.n {
+		return source, nil
+	}
+	e.count = 0
+
+	repair := make([]byte, 0, len(e.repairID)+3+len(e.repair))
+	repair = append(repair, e.repairID...)
+	repair = appendUint16(repair, e.first)
+	repair = append(repair, uint8(e.n))
+	repair = append(repair, e.repair...)
+	return source, repair
+}
+
+type fecSource struct {
+	valid bool
+	seqNr uint16
+	dgram []byte
+}
+
+type fecRepair struct {
+	first uint16
+	n     int
+	xor   []byte
+}
+
+// fecDecoder recovers single lost datagrams of a group from the repair
+// datagram of the group. It is not safe for concurrent use.
+type fecDecoder struct {
+	history [fecHistorySize]fecSource
+	pending []fecRepair
+}
+
+func newFECDecoder() *fecDecoder {
+	return &fecDecoder{}
+}
+
+// onSource processes a datagram received on fecSourceFlowID and returns the
+// contained datagram and datagrams recovered using it.
+func (d *fecDecoder) onSource(buf []byte) ([][]byte, error) {
+	if len(buf) < 2 {
+		return nil, errInvalidFECDatagram
+	}
+	seqNr := binary.BigEndian.Uint16(buf)
+	dgram := buf[2:]
+
+	if s := d.history[seqNr%fecHistorySize]; s.valid && s.seqNr == seqNr {
+		logging.Drop(logging.DropDuplicate, "FEC source datagram %v was already recovered", seqNr)
+		return nil, nil
+	}
+	d.store(seqNr, dgram)
+	res := [][]byte{dgram}
+
+	pending := d.pending[:0]
+	for _, r := range d.pending {
+		if uint16(seqNr-r.first) >= uint16(r.n) {
+			pending = append(pending, r)
+			continue
+		}
+		recovered, done := d.recover(r)
+		if recovered != nil {
+			res = append(res, recovered)
+		}
+		if !done {
+			pending = append(pending, r)
+		}
+	}
+	d.pending = pending
+	return res, nil
+}
+
+// onRepair processes a datagram received on fecRepairFlowID and returns the
+// recovered datagram, if any.
+func (d *fecDecoder) onRepair(buf []byte) ([]byte, error) {
+	if len(buf) < 5 {
+		return nil, errInvalidFECDatagram
+	}
+	r := fecRepair{
+		first: binary.BigEndian.Uint16(buf),
+		n:     int(buf[2]),
+		xor:   append([]byte{}, buf[3:]...),
+	}
+
+	recovered, done := d.recover(r)
+	if !done {
+		d.pending = append(d.pending, r)
+		if len(d.pending) > fecMaxPendingRepairs {
+			d.pending = d.pending[1:]
+		}
+	}
+	return recovered, nil
+}
+
+func (d *fecDecoder) store(seqNr uint16, dgram []byte) {
+	d.history[seqNr%fecHistorySize] = fecSource{
+		valid: true,
+		seqNr: seqNr,
+		dgram: dgram,
+	}
+}
+
+// recover returns the missing datagram if exactly one datagram of the group
+// is missing. done is true if no more datagrams of the group can be
+// recovered.
+func (d *fecDecoder) recover(r fecRepair) (recovered []byte, done bool) {
+	missing := -1
+	xor := append([]byte{}, r.xor...)
+	for i := 0; i < r.n; i++ {
+		seqNr := r.first + uint16(i)
+		s := d.history[seqNr%fecHistorySize]
+		if !s.valid || s.seqNr != seqNr {
+			if missing >= 0 {
+				return nil, false
+			}
+			missing = i
+			continue
+		}
+		if 2+len(s.dgram) > len(xor) {
+			logging.Drop(logging.DropParseError, "FEC source datagram %v is longer than the repair datagram", seqNr)
+			return nil, true
+		}
+		xorInto(xor, s.dgram)
+	}
+	if missing < 0 {
+		return nil, true
+	}
+	length := int(binary.BigEndian.Uint16(xor))
+	if 2+length > len(xor) {
+		logging.Drop(logging.DropParseError, "invalid length of recovered FEC datagram")
+		return nil, true
+	}
+	seqNr := r.first + uint16(missing)
+	dgram := xor[2 : 2+length]
+	d.store(seqNr, dgram)
+	return dgram, true
+}
@@ -0,0 +1,63 @@
+package quic
+
+import (
+	"bytes"
+	"testing"
+)
+
+// stripFlowID removes the flow ID written by a fecEncoder.
+func stripFlowID(t *testing.T, id uint64, dgram []byte) []byte {
+	t.Helper()
+	prefix := appendFlowID(nil, id)
+	if !bytes.HasPrefix(dgram, prefix) {
+		t.Fatalf("got datagram %x, want flow ID %v", dgram, id)
+	}
+	return dgram[len(prefix):]
+}
+
+func TestFEC(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		lost map[int]bool
+		want []int
+	}{
+		{name: "no loss", want: []int{0, 1, 2, 3, 4, 5}},
+		{name: "one loss per group", lost: map[int]bool{1: true, 3: true}, want: []int{0, 2, 1, 4, 5, 3}},
+		{name: "two losses in a group", lost: map[int]bool{0: true, 1: true}, want: []int{2, 3, 4, 5}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newFECEncoder(3)
+			d := newFECDecoder()
+			var dgrams, got [][]byte
+			for i := 0; i < 6; i++ {
+				dgram := bytes.Repeat([]byte{byte(i)}, 10*(i+1))
+				dgrams = append(dgrams, dgram)
+				source, repair := e.protect(dgram)
+				if !tc.lost[i] {
+					out, err := d.onSource(stripFlowID(t, fecSourceFlowID, source))
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, out...)
+				}
+				if repair != nil {
+					out, err := d.onRepair(stripFlowID(t, fecRepairFlowID, repair))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if out != nil {
+						got = append(got, out)
+					}
+				}
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v datagrams, want %v", len(got), len(tc.want))
+			}
+			for i, j := range tc.want {
+				if !bytes.Equal(got[i], dgrams[j]) {
+					t.Errorf("datagram %v: got %x, want %x", i, got[i], dgrams[j])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package quic
+
+import (
+	"bytes"
+
+	"github.com/lucas-clemente/quic-go/quicvarint"
+)
+
+// appendFlowID appends id as variable-length integer to buf, the prefix of
+// the datagrams and streams of a flow.
+func appendFlowID(buf []byte, id uint64) []byte {
+	b := bytes.NewBuffer(buf)
+	quicvarint.Write(quicvarint.NewWriter(b), id)
+	return b.Bytes()
+}
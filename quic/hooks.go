@@ -0,0 +1,18 @@
+package quic
+
+import (
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/lucas-clemente/quic-go"
+)
+
+// connectionInfo describes conn for the lifecycle hooks.
+func connectionInfo(conn quic.Connection) events.ConnectionInfo {
+	state := conn.ConnectionState()
+	return events.ConnectionInfo{
+		Transport:  "quic",
+		LocalAddr:  conn.LocalAddr().String(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		ALPN:       state.TLS.NegotiatedProtocol,
+		Datagrams:  state.SupportsDatagrams,
+	}
+}
@@ -2,8 +2,10 @@ package quic
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
+	"github.com/Willi-42/rtp-over-quic/logging"
 	"github.com/Willi-42/rtp-over-quic/rtp"
 	screamcgo "github.com/mengelbart/scream-go"
 )
@@ -43,7 +45,23 @@ func getTimeBetweenNTP(t0 float64, tx time.Time) uint64 {
 	return ntp
 }
 
+const (
+	// ackQueueSize bounds the acknowledgments waiting for the feedback
+	// generator.
+	ackQueueSize = 1000
+	// maxAcksPerReport bounds the acknowledgments drained from the queue
+	// for one report.
+	maxAcksPerReport = 64
+)
+
+// localRFC8888Generator creates RFC 8888 feedback from the acknowledgments
+// of quic-go. The acknowledgments are queued for the generator, so that a
+// slow generator or report consumer never blocks the ack processing of
+// quic-go, which calls ack on its run loop.
 type localRFC8888Generator struct {
+	// dropped is accessed atomically and first for 64-bit alignment.
+	dropped uint64
+
 	rx        *screamcgo.Rx
 	m         Metricer
 	reportCB  func(rtp.RTCPFeedback)
@@ -56,7 +74,7 @@ func newLocalRFC8888Generator(ssrc uint32, m Metricer, reportCB func(rtp.RTCPFee
 		rx:        screamcgo.NewRx(0),
 		m:         m,
 		reportCB:  reportCB,
-		ackedPkts: make(chan ackedPkt, 1000),
+		ackedPkts: make(chan ackedPkt, ackQueueSize),
 		t0:        getNTPT0(),
 	}
 }
@@ -65,8 +83,21 @@ func (f *localRFC8888Generator) ntpTime(t time.Time) uint64 {
 	return getTimeBetweenNTP(f.t0, t)
 }
 
+// ack queues pkt for the generator or drops it if the queue is full. It may
+// be called concurrently.
 func (f *localRFC8888Generator) ack(pkt ackedPkt) {
-	f.ackedPkts <- pkt
+	select {
+	case f.ackedPkts <- pkt:
+	default:
+		atomic.AddUint64(&f.dropped, 1)
+		logging.Drop(logging.DropQueueOverflow, "local RFC 8888 ack queue full, SSRC %v, seqNr=%v", pkt.ssrc, pkt.seqNr)
+	}
+}
+
+// Dropped returns the number of acknowledgments dropped because the queue
+// was full.
+func (f *localRFC8888Generator) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
 }
 
 func (f *localRFC8888Generator) run(ctx context.Context) {
@@ -75,10 +106,18 @@ func (f *localRFC8888Generator) run(ctx context.Context) {
 		case pkt := <-f.ackedPkts:
 			t := time.Now()
 
-			var lastTS uint64
-			recivedTS := pkt.sentTS.Add(time.Duration(pkt.owd) * time.Microsecond)
-			lastTS = f.ntpTime(recivedTS)
-			f.rx.Receive(lastTS, pkt.ssrc, pkt.size, pkt.seqNr, 0)
+			lastTS := f.receive(pkt)
+			// drain queued acknowledgments into one report, so that the
+			// generator catches up after a burst
+		drain:
+			for i := 1; i < maxAcksPerReport; i++ {
+				select {
+				case pkt := <-f.ackedPkts:
+					lastTS = f.receive(pkt)
+				default:
+					break drain
+				}
+			}
 
 			if ok, fb := f.rx.CreateStandardizedFeedback(lastTS, true); ok {
 				f.reportCB(rtp.RTCPFeedback{
@@ -92,3 +131,12 @@ func (f *localRFC8888Generator) run(ctx context.Context) {
 		}
 	}
 }
+
+// receive passes pkt to the feedback generator and returns the NTP time of
+// its arrival.
+func (f *localRFC8888Generator) receive(pkt ackedPkt) uint64 {
+	recivedTS := pkt.sentTS.Add(time.Duration(pkt.owd) * time.Microsecond)
+	ts := f.ntpTime(recivedTS)
+	f.rx.Receive(ts, pkt.ssrc, pkt.size, pkt.seqNr, 0)
+	return ts
+}
@@ -0,0 +1,202 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/quicvarint"
+	"github.com/pion/interceptor"
+	pionrtp "github.com/pion/rtp"
+)
+
+// Media over QUIC maps frames to objects and groups of pictures to groups,
+// using one stream per group as in draft-ietf-moq-transport-01. There is no
+// control stream: the track alias is the flow ID and the subscribe ID is 0.
+// Each object carries the RTP packets of one frame, each prefixed by its
+// length as varint, so that the RTP interceptors and congestion control work
+// as with the other transports. RTCP is sent as datagrams.
+const (
+	moqALPN = "moq-00"
+	// moqStreamHeaderGroup is the type of streams carrying the objects of
+	// one group.
+	moqStreamHeaderGroup = 0x51
+	// maxMoQObjectSize limits the memory allocated for an object.
+	maxMoQObjectSize = 16 << 20
+)
+
+var errMoQStreamType = errors.New("unexpected MoQ stream type")
+
+// moqTrack sends the packets of one flow as MoQ objects. Packets are
+// collected until the frame is complete, i.e., the marker bit is set or the
+// timestamp changes. A new group starts with each key frame, or with each
+// frame if the media source does not set the FRAME attribute.
+type moqTrack struct {
+	sender *Sender
+	alias  uint64
+
+	lock    sync.Mutex
+	stream  quic.SendStream
+	group   uint64
+	object  uint64
+	frame   bytes.Buffer
+	ts      uint32
+	pending bool
+}
+
+func (t *moqTrack) write(header *pionrtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+	headerBuf, err := header.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	n := len(headerBuf) + len(payload)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.pending && header.Timestamp != t.ts {
+		if err := t.flush(); err != nil {
+			return 0, err
+		}
+	}
+	if !t.pending {
+		keyFrame := true
+		if f, ok := attributes.Get(rtp.FRAME).(rtp.FrameInfo); ok {
+			keyFrame = f.KeyFrame
+		}
+		if keyFrame || t.stream == nil {
+			if err := t.newGroup(); err != nil {
+				return 0, err
+			}
+		}
+		t.ts = header.Timestamp
+		t.pending = true
+	}
+	quicvarint.Write(quicvarint.NewWriter(&t.frame), uint64(n))
+	t.frame.Write(headerBuf)
+	t.frame.Write(payload)
+	if header.Marker {
+		if err := t.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// newGroup finishes the stream of the current group and opens the stream of
+// the next one.
+func (t *moqTrack) newGroup() error {
+	if t.stream != nil {
+		if err := t.stream.Close(); err != nil {
+			return err
+		}
+		t.group++
+	}
+	stream, err := t.sender.connection().OpenUniStreamSync(context.Background())
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	w := quicvarint.NewWriter(&buf)
+	quicvarint.Write(w, moqStreamHeaderGroup)
+	quicvarint.Write(w, 0) // subscribe ID
+	quicvarint.Write(w, t.alias)
+	quicvarint.Write(w, t.group)
+	quicvarint.Write(w, t.group) // send order
+	if _, err := stream.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	logging.QLOGEvent(logging.QLOGFlowCreated, map[string]interface{}{"flow_id": t.alias, "moq_group": t.group})
+	t.stream = stream
+	t.object = 0
+	return nil
+}
+
+// flush writes the collected packets as the next object of the group.
+func (t *moqTrack) flush() error {
+	var buf bytes.Buffer
+	w := quicvarint.NewWriter(&buf)
+	quicvarint.Write(w, t.object)
+	quicvarint.Write(w, uint64(t.frame.Len()))
+	buf.Write(t.frame.Bytes())
+	t.frame.Reset()
+	t.pending = false
+	t.object++
+	if err := t.sender.pace(context.Background(), buf.Len()); err != nil {
+		return err
+	}
+	if _, err := t.stream.Write(buf.Bytes()); err != nil {
+		if t.sender.failingOver() {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// readGroup passes the RTP packets of the objects of a MoQ group stream to
+// pktChan as they arrive.
+func (h *Handler) readGroup(stream quic.ReceiveStream, pktChan chan<- pkt) {
+	r := quicvarint.NewReader(stream)
+	header := make([]uint64, 5)
+	for i := range header {
+		v, err := quicvarint.Read(r)
+		if err != nil {
+			logging.Drop(logging.DropParseError, "failed to read MoQ stream header: %v", err)
+			return
+		}
+		header[i] = v
+	}
+	if header[0] != moqStreamHeaderGroup {
+		logging.Drop(logging.DropParseError, "%v", fmt.Errorf("%w: %#x", errMoQStreamType, header[0]))
+		stream.CancelRead(quic.StreamErrorCode(ErrorCodePacketError))
+		return
+	}
+	alias := header[2]
+	for {
+		if _, err := quicvarint.Read(r); err != nil {
+			if !errors.Is(err, io.EOF) {
+				h.streamFailed(stream, err)
+			}
+			return
+		}
+		size, err := quicvarint.Read(r)
+		if err != nil {
+			h.streamFailed(stream, err)
+			return
+		}
+		if size > maxMoQObjectSize {
+			logging.Drop(logging.DropParseError, "MoQ object of %v bytes in group %v of track %v exceeds the limit", size, header[3], alias)
+			stream.CancelRead(quic.StreamErrorCode(ErrorCodePacketError))
+			return
+		}
+		object := make([]byte, size)
+		if _, err := io.ReadFull(r, object); err != nil {
+			h.streamFailed(stream, err)
+			return
+		}
+		arrival := time.Now()
+		or := bytes.NewReader(object)
+		for or.Len() > 0 {
+			n, err := quicvarint.Read(or)
+			if err != nil || n > uint64(or.Len()) {
+				logging.Drop(logging.DropParseError, "malformed MoQ object in group %v of track %v", header[3], alias)
+				break
+			}
+			p := make([]byte, n)
+			or.Read(p)
+			h.deliver(pktChan, pkt{
+				flowID:    alias,
+				transport: MOQ,
+				buffer:    p,
+				arrival:   arrival,
+			})
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// pacingGain lets the pacer send faster than the target bitrate, so
+	// that the queue built up by an encoder burst drains before the next
+	// frame.
+	pacingGain = 1.25
+	// sendAheadGain is used while the playout buffer of the receiver runs
+	// low, relaxedGain while it is well filled.
+	sendAheadGain = 2
+	relaxedGain   = 1
+)
+
+var errInvalidPacerConfig = errors.New("invalid pacer configuration")
+
+// Pacer is a token bucket releasing packets of all flows of a sender at
+// the pacing rate instead of in the bursts produced by encoders. Tokens are
+// added in steps of interval and capped at burst bytes. The rate follows
+// the target bitrate set by a congestion controller, a pacer without target
+// does not delay packets.
+type Pacer struct {
+	lock     sync.Mutex
+	interval time.Duration
+	burst    float64
+	target   float64
+	gain     float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewPacer creates a pacer which releases at most burst bytes every
+// interval at the initial target bitrate.
+func NewPacer(interval time.Duration, burst int, initialTarget uint) (*Pacer, error) {
+	if interval <= 0 || burst <= 0 {
+		return nil, fmt.Errorf("%w: interval and burst must be positive, got %v and %v", errInvalidPacerConfig, interval, burst)
+	}
+	return &Pacer{
+		interval: interval,
+		burst:    float64(burst),
+		target:   float64(initialTarget) / 8,
+		gain:     pacingGain,
+		rate:     pacingGain * float64(initialTarget) / 8,
+		tokens:   float64(burst),
+	}, nil
+}
+
+// SetTargetBitsPerSecond sets the target bitrate of the congestion
+// controller driving the pacer.
+func (p *Pacer) SetTargetBitsPerSecond(r uint) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.refill(time.Now())
+	p.target = float64(r) / 8
+	p.rate = p.gain * p.target
+}
+
+// OnBufferHealth sends ahead of the target bitrate while the estimated
+// playout buffer of the receiver holds less than half of target and relaxes
+// to the target bitrate while it holds more than target.
+func (p *Pacer) OnBufferHealth(occupancy, target time.Duration) {
+	gain := pacingGain
+	switch {
+	case occupancy < target/2:
+		gain = sendAheadGain
+	case occupancy > target:
+		gain = relaxedGain
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if gain == p.gain {
+		return
+	}
+	p.refill(time.Now())
+	p.gain = gain
+	p.rate = p.gain * p.target
+}
+
+// refill adds the tokens of all intervals completed since the last refill.
+// Must be called with the lock held.
+func (p *Pacer) refill(now time.Time) {
+	if p.last.IsZero() {
+		p.last = now
+		return
+	}
+	intervals := now.Sub(p.last) / p.interval
+	if intervals <= 0 {
+		return
+	}
+	p.last = p.last.Add(intervals * p.interval)
+	p.tokens += p.rate * (intervals * p.interval).Seconds()
+	if p.tokens > p.burst {
+		p.tokens = p.burst
+	}
+}
+
+// wait blocks until size bytes may be sent. Packets larger than the burst
+// are sent once the bucket is full and leave the bucket in debt.
+func (p *Pacer) wait(ctx context.Context, size int) error {
+	for {
+		p.lock.Lock()
+		now := time.Now()
+		p.refill(now)
+		need := float64(size)
+		if need > p.burst {
+			need = p.burst
+		}
+		if p.rate <= 0 || p.tokens >= need {
+			p.tokens -= float64(size)
+			p.lock.Unlock()
+			return nil
+		}
+		d := time.Duration((need - p.tokens) / p.rate * float64(time.Second))
+		// tokens are only added at the end of an interval
+		d = p.last.Add(p.interval).Sub(now) + d/p.interval*p.interval
+		p.lock.Unlock()
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
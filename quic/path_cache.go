@@ -0,0 +1,91 @@
+package quic
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// clientSessionCache is shared by all senders of the process, so that
+// reconnects to the same server can resume the TLS session. The session
+// state of the TLS stack used by quic-go cannot be serialized, which is why
+// tickets are not stored in the PathCache.
+var clientSessionCache = tls.NewLRUClientSessionCache(64)
+
+// PathProperties are the properties of the path to a server measured during
+// a previous run.
+type PathProperties struct {
+	LastSeen      time.Time     `json:"last_seen"`
+	MinRTT        time.Duration `json:"min_rtt"`
+	SmoothedRTT   time.Duration `json:"smoothed_rtt"`
+	RTTVar        time.Duration `json:"rtt_var"`
+	TargetBitrate uint          `json:"target_bitrate"`
+
+	MaxIdleTimeout       time.Duration `json:"max_idle_timeout"`
+	MaxAckDelay          time.Duration `json:"max_ack_delay"`
+	MaxUDPPayloadSize    int64         `json:"max_udp_payload_size"`
+	MaxDatagramFrameSize int64         `json:"max_datagram_frame_size"`
+	InitialMaxData       int64         `json:"initial_max_data"`
+}
+
+// PathCache stores PathProperties per server address on disk.
+type PathCache struct {
+	lock    sync.Mutex
+	file    string
+	entries map[string]PathProperties
+}
+
+// LoadPathCache reads the cache from file. A missing file results in an empty
+// cache which will be created on the first call to Save.
+func LoadPathCache(file string) (*PathCache, error) {
+	c := &PathCache{
+		file:    file,
+		entries: map[string]PathProperties{},
+	}
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &c.entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *PathCache) Get(addr string) (PathProperties, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p, ok := c.entries[addr]
+	return p, ok
+}
+
+// Update applies f to the properties stored for addr.
+func (c *PathCache) Update(addr string, f func(*PathProperties)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p := c.entries[addr]
+	f(&p)
+	p.LastSeen = time.Now()
+	c.entries[addr] = p
+}
+
+func (c *PathCache) Save() error {
+	c.lock.Lock()
+	buf, err := json.MarshalIndent(c.entries, "", "  ")
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	rqlogging "github.com/Willi-42/rtp-over-quic/logging"
 	"github.com/lucas-clemente/quic-go/logging"
 )
 
@@ -16,6 +17,20 @@ type RTTTracer struct {
 	SmoothedRTT time.Duration
 	RTTVar      time.Duration
 	LatestRTT   time.Duration
+
+	transportParameters *logging.TransportParameters
+
+	// cc is notified about sent, acknowledged and lost 1-RTT packets if
+	// set.
+	cc congestionController
+	// streamAcks is notified about sent, acknowledged and lost 1-RTT
+	// packets if set.
+	streamAcks *streamAckTracker
+
+	// streamPackets are the sent 1-RTT packets carrying stream data which
+	// are neither acknowledged nor lost yet.
+	streamPackets map[int64]struct{}
+	retransmits   uint64
 }
 
 func (q *RTTTracer) Metrics() RTTStats {
@@ -53,8 +68,57 @@ func (q *RTTTracer) updateLatestRTT(rttvar time.Duration) {
 	q.LatestRTT = rttvar
 }
 
+func (q *RTTTracer) updateTransportParameters(parameters *logging.TransportParameters) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.transportParameters = parameters
+}
+
+// TransportParameters returns the transport parameters received from the
+// peer or nil if none were received yet.
+func (q *RTTTracer) TransportParameters() *logging.TransportParameters {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.transportParameters
+}
+
 func NewTracer() *RTTTracer {
-	return &RTTTracer{}
+	return &RTTTracer{
+		streamPackets: map[int64]struct{}{},
+	}
+}
+
+// Retransmits returns the number of lost packets carrying stream data.
+func (q *RTTTracer) Retransmits() uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.retransmits
+}
+
+func (q *RTTTracer) onPacketSent(pn int64, frames []logging.Frame) {
+	for _, f := range frames {
+		if _, ok := f.(*logging.StreamFrame); ok {
+			q.lock.Lock()
+			q.streamPackets[pn] = struct{}{}
+			q.lock.Unlock()
+			return
+		}
+	}
+}
+
+func (q *RTTTracer) onPacketAcked(pn int64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.streamPackets, pn)
+}
+
+func (q *RTTTracer) onPacketLost(pn int64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, ok := q.streamPackets[pn]; ok {
+		delete(q.streamPackets, pn)
+		q.retransmits++
+	}
 }
 
 func (q *RTTTracer) TracerForConnection(ctx context.Context, p logging.Perspective, odcid logging.ConnectionID) logging.ConnectionTracer {
@@ -77,6 +141,18 @@ type ConnectionRTTTracer struct {
 }
 
 func (c *ConnectionRTTTracer) SentPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, ack *logging.AckFrame, frames []logging.Frame) {
+	// Packets without ack-eliciting frames are never acknowledged and
+	// don't count towards the bytes in flight.
+	if len(frames) == 0 || logging.PacketTypeFromHeader(&hdr.Header) != logging.PacketType1RTT {
+		return
+	}
+	if c.t.cc != nil {
+		c.t.cc.onPacketSent(int64(hdr.PacketNumber), int(size), time.Now())
+	}
+	if c.t.streamAcks != nil {
+		c.t.streamAcks.onPacketSent(int64(hdr.PacketNumber), frames)
+	}
+	c.t.onPacketSent(int64(hdr.PacketNumber), frames)
 }
 
 func (c *ConnectionRTTTracer) ReceivedPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, frames []logging.Frame) {
@@ -110,6 +186,7 @@ func (c ConnectionRTTTracer) SentTransportParameters(parameters *logging.Transpo
 }
 
 func (c ConnectionRTTTracer) ReceivedTransportParameters(parameters *logging.TransportParameters) {
+	c.t.updateTransportParameters(parameters)
 }
 
 func (c ConnectionRTTTracer) BufferedPacket(packetType logging.PacketType) {
@@ -134,16 +211,40 @@ func (c *ConnectionRTTTracer) UpdatedMetrics(rttStats *logging.RTTStats, cwnd, b
 	}
 	if latestRTT != 0 {
 		c.t.updateLatestRTT(latestRTT)
+		rqlogging.RecordLatency(rqlogging.LatencyRTT, latestRTT)
+		if c.t.cc != nil {
+			c.t.cc.onRTTSample(latestRTT, time.Now())
+		}
 	}
 }
 
 func (c ConnectionRTTTracer) AcknowledgedPacket(level logging.EncryptionLevel, number logging.PacketNumber) {
+	if level != logging.Encryption1RTT {
+		return
+	}
+	if c.t.cc != nil {
+		c.t.cc.onPacketAcked(int64(number), time.Now())
+	}
+	if c.t.streamAcks != nil {
+		c.t.streamAcks.onPacketAcked(int64(number), time.Now())
+	}
+	c.t.onPacketAcked(int64(number))
 }
 
 func (c ConnectionRTTTracer) NewOneWayDelay(owd uint64) {
 }
 
 func (c ConnectionRTTTracer) LostPacket(level logging.EncryptionLevel, number logging.PacketNumber, reason logging.PacketLossReason) {
+	if level != logging.Encryption1RTT {
+		return
+	}
+	if c.t.cc != nil {
+		c.t.cc.onPacketLost(int64(number), time.Now())
+	}
+	if c.t.streamAcks != nil {
+		c.t.streamAcks.onPacketLost(int64(number))
+	}
+	c.t.onPacketLost(int64(number))
 }
 
 func (c ConnectionRTTTracer) UpdatedCongestionState(state logging.CongestionState) {
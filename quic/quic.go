@@ -16,6 +16,8 @@ func TransportModeFromString(s string) TransportMode {
 		return DGRAM
 	case "quic-stream":
 		return STREAM
+	case "moq":
+		return MOQ
 	default:
 		return ANY
 	}
@@ -25,6 +27,8 @@ const (
 	ANY TransportMode = iota
 	DGRAM
 	STREAM
+	// MOQ sends frames as Media over QUIC objects on a stream per group.
+	MOQ
 )
 
 func listen(
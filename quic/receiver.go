@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/lucas-clemente/quic-go/quicvarint"
 	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/rtp"
 	"github.com/pion/interceptor"
 	"github.com/pion/rtcp"
 )
@@ -39,6 +44,36 @@ func SetServerSSLKeyLogFileName(file string) ServerOption {
 	}
 }
 
+// SetServerEventBus publishes stream resets and the closing of connections
+// to bus.
+func SetServerEventBus(bus *events.Bus) ServerOption {
+	return func(sc *ServerConfig) error {
+		sc.events = bus
+		return nil
+	}
+}
+
+// SetServerHooks sets the hooks called when connections are accepted and
+// closed and when flows are opened.
+func SetServerHooks(h *events.Hooks) ServerOption {
+	return func(sc *ServerConfig) error {
+		sc.hooks = h
+		return nil
+	}
+}
+
+// SetMaxConnections limits the number of concurrent connections, further
+// connections are closed right away. 0 means no limit.
+func SetMaxConnections(n int) ServerOption {
+	return func(sc *ServerConfig) error {
+		if n < 0 {
+			return fmt.Errorf("%w: negative connection limit %v", errInvalidConfig, n)
+		}
+		sc.maxConnections = n
+		return nil
+	}
+}
+
 func SetServerQUICCongestionControlAlgorithm(algorithm cc.Algorithm) ServerOption {
 	return func(sc *ServerConfig) error {
 		sc.cc = algorithm
@@ -51,11 +86,14 @@ type ServerConfig struct {
 	cc                cc.Algorithm
 	qlogDirectoryName string
 	sslKeyLogFileName string
+	events            *events.Bus
+	hooks             *events.Hooks
+	maxConnections    int
 }
 
 type Server struct {
 	*ServerConfig
-	onNewHandler func(*Handler)
+	onNewHandler func(*Handler) error
 }
 
 func NewServer(opts ...ServerOption) (*Server, error) {
@@ -65,6 +103,7 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 			cc:                0,
 			qlogDirectoryName: "",
 			sslKeyLogFileName: "",
+			events:            nil,
 		},
 	}
 	for _, opt := range opts {
@@ -72,6 +111,10 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 			return nil, err
 		}
 	}
+	// BBR and Copa run on top of the tracer of the sender
+	if s.cc != cc.Reno && s.cc != cc.NONE {
+		return nil, fmt.Errorf("%w: QUIC congestion control %v is not supported by the server", errInvalidConfig, s.cc)
+	}
 	return s, nil
 }
 
@@ -83,6 +126,12 @@ func (s *Server) Start(ctx context.Context) error {
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
+	// slots holds one element per open connection if the connections are
+	// limited
+	var slots chan struct{}
+	if s.maxConnections > 0 {
+		slots = make(chan struct{}, s.maxConnections)
+	}
 	for {
 		conn, err := listener.Accept(ctx)
 		if err != nil {
@@ -91,22 +140,68 @@ func (s *Server) Start(ctx context.Context) error {
 			}
 			return err
 		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			default:
+				log.Printf("rejecting connection from %v, limit of %v connections reached", conn.RemoteAddr(), s.maxConnections)
+				if err := conn.CloseWithError(ErrorCodeGeneralError, "connection limit reached"); err != nil {
+					log.Printf("failed to close connection: %v", err)
+				}
+				continue
+			}
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			info := connectionInfo(conn)
+			if err := s.hooks.Connect(info); err != nil {
+				log.Printf("rejecting connection from %v: %v", conn.RemoteAddr(), err)
+				if err := conn.CloseWithError(ErrorCodeGeneralError, "connection rejected"); err != nil {
+					log.Printf("failed to close connection: %v", err)
+				}
+				return
+			}
 			h := Handler{
 				reader: nil,
 				conn:   conn,
+				info:   info,
+				events: s.events,
+				hooks:  s.hooks,
+				moq:    conn.ConnectionState().TLS.NegotiatedProtocol == moqALPN,
+			}
+			if err := s.onNewHandler(&h); err != nil {
+				log.Printf("failed to set up handler for connection from %v, closing it: %v", conn.RemoteAddr(), err)
+				if err := conn.CloseWithError(ErrorCodeInternalError, "failed to set up receiver"); err != nil {
+					log.Printf("failed to close connection: %v", err)
+				}
+				s.hooks.Disconnect(info, nil)
+				return
 			}
-			s.onNewHandler(&h)
-			if err = h.handle(ctx, conn); err != nil {
+			if err := h.handle(ctx, conn); err != nil {
 				log.Printf("error on handling connection: %v", err)
 			}
+			if conn.Context().Err() == nil {
+				if err := conn.CloseWithError(ErrorCodeNoError, "server stopped"); err != nil {
+					log.Printf("failed to close connection: %v", err)
+				}
+			}
+			if h.onClose != nil {
+				h.onClose()
+			}
+			s.hooks.Disconnect(info, h.closeError())
+			log.Printf("closed connection from %v", conn.RemoteAddr())
 		}()
 	}
 }
 
-func (s *Server) OnNewHandler(f func(*Handler)) {
+// OnNewHandler sets the function called for the handler of each new
+// connection. If it returns an error, the connection is closed and the
+// server keeps accepting other connections.
+func (s *Server) OnNewHandler(f func(*Handler) error) {
 	s.onNewHandler = f
 }
 
@@ -114,19 +209,42 @@ type pkt struct {
 	flowID    uint64
 	transport TransportMode
 	buffer    []byte
+	arrival   time.Time
 }
 
 type Handler struct {
-	reader interceptor.RTPReader
-	conn   quic.Connection
+	reader  interceptor.RTPReader
+	conn    quic.Connection
+	info    events.ConnectionInfo
+	events  *events.Bus
+	hooks   *events.Hooks
+	onClose func()
+	// moq is set if the sender uses Media over QUIC.
+	moq bool
+
+	closeLock sync.Mutex
+	closeErr  error
 }
 
 func (h *Handler) SetRTPReader(r interceptor.RTPReader) {
 	h.reader = r
 }
 
+// Close closes the connection of the handler with ErrorCodeNoError, e.g.,
+// after the sender said goodbye.
+func (h *Handler) Close(reason string) error {
+	return h.conn.CloseWithError(ErrorCodeNoError, reason)
+}
+
+// OnClose sets a function called after the connection of the handler was
+// closed and no more packets are read.
+func (h *Handler) OnClose(f func()) {
+	h.onClose = f
+}
+
 func (h *Handler) handle(ctx context.Context, conn quic.Connection) error {
 	pktChan := make(chan pkt)
+	flows := map[uint64]struct{}{}
 
 	var wg sync.WaitGroup
 	defer wg.Wait()
@@ -137,15 +255,28 @@ func (h *Handler) handle(ctx context.Context, conn quic.Connection) error {
 	for {
 		select {
 		case p := <-pktChan:
-			if h.reader != nil {
-				if _, _, err := h.reader.Read(p.buffer, interceptor.Attributes{
-					"flow-id":   p.flowID,
-					"transport": p.transport,
-				}); err != nil {
-					log.Printf("failed to process incoming packet: %v", err)
-				}
+			if _, ok := flows[p.flowID]; !ok {
+				flows[p.flowID] = struct{}{}
+				logging.QLOGEvent(logging.QLOGFlowCreated, map[string]interface{}{"flow_id": p.flowID})
+				h.hooks.StreamOpen(events.FlowInfo{
+					Connection: h.info,
+					FlowID:     p.flowID,
+				})
+			}
+			if h.reader == nil {
+				logging.Drop(logging.DropUnknownFlow, "no reader for flow %v", p.flowID)
+				continue
+			}
+			if _, _, err := h.reader.Read(p.buffer, interceptor.Attributes{
+				"flow-id":   p.flowID,
+				"transport": p.transport,
+				"arrival":   p.arrival,
+			}); err != nil {
+				logging.Drop(logging.DropParseError, "failed to process incoming packet: %v", err)
 			}
 
+		case <-conn.Context().Done():
+			return nil
 		case <-ctx.Done():
 			return nil
 		}
@@ -153,33 +284,103 @@ func (h *Handler) handle(ctx context.Context, conn quic.Connection) error {
 }
 
 func (h *Handler) receiveDgrams(pktChan chan<- pkt) {
+	fec := newFECDecoder()
 	for {
 		msg, err := h.conn.ReceiveMessage()
 		if err != nil {
+			if e, ok := closedByPeer(err); ok {
+				log.Printf("sender closed the connection: %v (%v)", ErrorCodeName(e.ErrorCode), e.ErrorMessage)
+				h.publishClosed(err)
+				return
+			}
 			if e, ok := err.(*quic.ApplicationError); ok && e.ErrorCode == 0 {
 				log.Printf("QUIC received application error, exiting datagram receiver routine: %v", err)
+				h.publishClosed(err)
 				return
 			}
 			if ne, ok := err.(net.Error); ok && ne.Timeout() {
 				log.Printf("QUIC connection timed out, exiting datagram receiver routine: %v", err)
+				h.publishClosed(err)
+				return
+			}
+			if h.conn.Context().Err() != nil {
+				log.Printf("QUIC connection closed, exiting datagram receiver routine: %v", err)
+				h.publishClosed(err)
 				return
 			}
 			log.Printf("failed to receive QUIC datagram: %T", err)
 			continue
 		}
-		id, err := quicvarint.Read(bytes.NewReader(msg))
+		h.handleDgram(msg, fec, pktChan)
+	}
+}
+
+func (h *Handler) publishClosed(err error) {
+	h.closeLock.Lock()
+	h.closeErr = err
+	h.closeLock.Unlock()
+	h.events.Publish(events.ConnectionClosed{
+		Time:       time.Now(),
+		RemoteAddr: h.conn.RemoteAddr().String(),
+		Err:        err,
+	})
+}
+
+// closeError returns the error which stopped the datagram receiver, nil if
+// it is still running.
+func (h *Handler) closeError() error {
+	h.closeLock.Lock()
+	defer h.closeLock.Unlock()
+	return h.closeErr
+}
+
+// handleDgram passes the RTP packet of msg to pktChan. FEC source datagrams
+// and aggregated datagrams are unwrapped and datagrams recovered by FEC are
+// passed on, too.
+func (h *Handler) handleDgram(msg []byte, fec *fecDecoder, pktChan chan<- pkt) {
+	id, err := quicvarint.Read(bytes.NewReader(msg))
+	if err != nil {
+		logging.Drop(logging.DropParseError, "failed to read flow ID of datagram: %v", err)
+		return
+	}
+	offset := quicvarint.Len(id)
+	switch id {
+	case fecSourceFlowID:
+		dgrams, err := fec.onSource(msg[offset:])
 		if err != nil {
-			log.Printf("failed to read flow ID: %v, dropping datagram", err)
-			continue
+			logging.Drop(logging.DropParseError, "failed to read FEC source datagram: %v", err)
+			return
 		}
-		offset := quicvarint.Len(id)
-		pktChan <- pkt{
-			flowID:    id,
-			transport: DGRAM,
-			buffer:    msg[offset:],
+		for _, dgram := range dgrams {
+			h.handleDgram(dgram, fec, pktChan)
 		}
-
+		return
+	case fecRepairFlowID:
+		dgram, err := fec.onRepair(msg[offset:])
+		if err != nil {
+			logging.Drop(logging.DropParseError, "failed to read FEC repair datagram: %v", err)
+			return
+		}
+		if dgram != nil {
+			h.handleDgram(dgram, fec, pktChan)
+		}
+		return
+	case aggregateFlowID:
+		dgrams, err := splitAggregate(msg[offset:])
+		if err != nil {
+			logging.Drop(logging.DropParseError, "failed to read aggregated datagram: %v", err)
+		}
+		for _, dgram := range dgrams {
+			h.handleDgram(dgram, fec, pktChan)
+		}
+		return
 	}
+	h.deliver(pktChan, pkt{
+		flowID:    id,
+		transport: DGRAM,
+		buffer:    msg[offset:],
+		arrival:   time.Now(),
+	})
 }
 
 func (h *Handler) acceptStreams(ctx context.Context, pktChan chan<- pkt) {
@@ -194,9 +395,16 @@ func (h *Handler) acceptStreams(ctx context.Context, pktChan chan<- pkt) {
 				log.Printf("QUIC connection timed out, exiting stream accepting routine: %v", err)
 				return
 			}
+			if ctx.Err() != nil || h.conn.Context().Err() != nil {
+				return
+			}
 			log.Printf("failed to receive from QUIC stream: %v", err)
 			continue
 		}
+		if h.moq {
+			go h.readGroup(stream, pktChan)
+			continue
+		}
 		go h.readStream(stream, pktChan)
 	}
 }
@@ -205,29 +413,53 @@ func (h *Handler) readStream(stream quic.ReceiveStream, pktChan chan<- pkt) {
 	varintReader := quicvarint.NewReader(stream)
 	id, err := quicvarint.Read(varintReader)
 	if err != nil {
-		log.Printf("failed to read flow ID: %v, dropping stream", err)
+		logging.Drop(logging.DropParseError, "failed to read flow ID of stream: %v", err)
 		return
 	}
 	buf, err := io.ReadAll(stream)
 	if err != nil {
-		if e, ok := err.(*quic.ApplicationError); ok && e.ErrorCode == 0 {
-			log.Printf("QUIC received application error, exiting stream receiver routine: %v", err)
-			return
-		}
-		if ne, ok := err.(net.Error); ok && ne.Timeout() {
-			log.Printf("QUIC connection timed out, exiting stream reader routine: %v", err)
-			return
-		}
-		if errors.Is(err, io.EOF) {
-			return
-		}
-		log.Printf("failed to receive from QUIC stream: %v", err)
+		h.streamFailed(stream, err)
 		return
 	}
-	pktChan <- pkt{
+	h.deliver(pktChan, pkt{
 		flowID:    id,
 		transport: STREAM,
 		buffer:    buf,
+		arrival:   time.Now(),
+	})
+}
+
+// streamFailed logs the error which stopped reading stream.
+func (h *Handler) streamFailed(stream quic.ReceiveStream, err error) {
+	if e, ok := err.(*quic.ApplicationError); ok && e.ErrorCode == 0 {
+		log.Printf("QUIC received application error, exiting stream receiver routine: %v", err)
+		return
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		log.Printf("QUIC connection timed out, exiting stream reader routine: %v", err)
+		return
+	}
+	if errors.Is(err, io.EOF) {
+		return
+	}
+	var streamErr *quic.StreamError
+	if errors.As(err, &streamErr) {
+		logging.Drop(logging.DropStreamReset, "stream %v reset by sender: %v", stream.StreamID(), err)
+		h.events.Publish(events.StreamReset{
+			Time:     time.Now(),
+			StreamID: int64(stream.StreamID()),
+			Err:      err,
+		})
+		return
+	}
+	log.Printf("failed to receive from QUIC stream: %v", err)
+}
+
+// deliver passes p to the handling loop unless the connection is closed.
+func (h *Handler) deliver(pktChan chan<- pkt, p pkt) {
+	select {
+	case pktChan <- p:
+	case <-h.conn.Context().Done():
 	}
 }
 
@@ -240,9 +472,14 @@ func (h *Handler) WriteRTCP(pkts []rtcp.Packet, attributes interceptor.Attribute
 	if i := attributes.Get("flow-id"); i != nil {
 		id = i.(uint64)
 	}
-	var idBuf bytes.Buffer
-	idWriter := quicvarint.NewWriter(&idBuf)
-	quicvarint.Write(idWriter, id)
-	msg := append(idBuf.Bytes(), buf...)
-	return len(buf), h.conn.SendMessage(msg, nil)
+	msg := append(appendFlowID(nil, id), buf...)
+	var cb func(bool, uint64)
+	if acked, ok := attributes.Get(rtp.FEEDBACK_ACKED).(rtp.FeedbackAckedCallback); ok {
+		cb = func(b bool, _ uint64) {
+			if b {
+				acked()
+			}
+		}
+	}
+	return len(buf), h.conn.SendMessage(msg, cb)
 }
@@ -0,0 +1,251 @@
+package quic
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/buffers"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/pion/interceptor"
+	pionrtp "github.com/pion/rtp"
+)
+
+// Policies of the send queue, selecting what is dropped when packets exceed
+// the delay budget.
+const (
+	// SendQueueDropPackets drops single packets, which keeps the other
+	// packets of their frames.
+	SendQueueDropPackets = "packets"
+	// SendQueueDropFrames drops the queued and the following packets of
+	// the frames of late packets, so that the receiver doesn't waste
+	// capacity on frames it can't decode.
+	SendQueueDropFrames = "frames"
+)
+
+// SendQueuePolicies are the known send queue policies.
+var SendQueuePolicies = []string{SendQueueDropPackets, SendQueueDropFrames}
+
+// sendQueueCapacity bounds the number of packets of a send queue. When it is
+// full, the oldest packet is dropped, even if it belongs to a key frame.
+const sendQueueCapacity = 4096
+
+// sendQueueFlushTimeout limits how long closing a send queue waits for the
+// queued packets to be written.
+const sendQueueFlushTimeout = time.Second
+
+var errSendQueueClosed = errors.New("send queue closed")
+
+type queuedPacket struct {
+	header     pionrtp.Header
+	payload    *buffers.Buffer
+	attributes interceptor.Attributes
+	keyFrame   bool
+	enqueued   time.Time
+}
+
+// frameKey identifies the frame of a packet.
+type frameKey struct {
+	ssrc      uint32
+	timestamp uint32
+}
+
+// sendQueue decouples the interceptors from the flow writer, which blocks
+// while the pacer or the congestion controller hold packets back. Packets
+// waiting longer than budget are dropped according to policy instead of
+// adding latency, except for the packets of key frames, which the receiver
+// needs to decode the following frames.
+type sendQueue struct {
+	budget time.Duration
+	policy string
+	writer interceptor.RTPWriter
+
+	lock    sync.Mutex
+	closed  bool
+	packets []*queuedPacket
+	// dropping is the frame dropped last per SSRC with SendQueueDropFrames,
+	// whose packets are dropped when they arrive
+	dropping map[uint32]uint32
+	signal   chan struct{}
+	// closing is closed by close, done when run has written the last
+	// packet.
+	closing chan struct{}
+	done    chan struct{}
+}
+
+func newSendQueue(budget time.Duration, policy string, writer interceptor.RTPWriter) *sendQueue {
+	q := &sendQueue{
+		budget:   budget,
+		policy:   policy,
+		writer:   writer,
+		dropping: map[uint32]uint32{},
+		signal:   make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Write queues a copy of the packet, the caller may reuse the buffers. It
+// fails once the queue is closed.
+func (q *sendQueue) Write(header *pionrtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+	p := &queuedPacket{
+		header:     *header,
+		payload:    buffers.Copy(payload),
+		attributes: interceptor.Attributes{},
+		enqueued:   time.Now(),
+	}
+	p.header.Extensions = append([]pionrtp.Extension(nil), header.Extensions...)
+	// sources reuse the attributes for all packets of a frame
+	for k, v := range attributes {
+		p.attributes[k] = v
+	}
+	if info, ok := attributes.Get(rtp.FRAME).(rtp.FrameInfo); ok {
+		p.keyFrame = info.KeyFrame
+	}
+	n := header.MarshalSize() + len(payload)
+
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		p.payload.Release()
+		return 0, errSendQueueClosed
+	}
+	if ts, ok := q.dropping[header.SSRC]; ok && q.policy == SendQueueDropFrames {
+		if ts == header.Timestamp && !p.keyFrame {
+			q.lock.Unlock()
+			p.payload.Release()
+			logging.Drop(logging.DropSendQueueDelay, "rest of late frame of SSRC %v, seqNr=%v", header.SSRC, header.SequenceNumber)
+			return n, nil
+		}
+		delete(q.dropping, header.SSRC)
+	}
+	if len(q.packets) >= sendQueueCapacity {
+		dropped := q.packets[0]
+		q.packets = q.packets[1:]
+		dropped.payload.Release()
+		logging.Drop(logging.DropQueueOverflow, "send queue full, SSRC %v, seqNr=%v", dropped.header.SSRC, dropped.header.SequenceNumber)
+	}
+	q.packets = append(q.packets, p)
+	q.lock.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return n, nil
+}
+
+// run writes the queued packets until the queue is closed and the last
+// packet is written.
+func (q *sendQueue) run() {
+	defer close(q.done)
+	for {
+		closing := false
+		select {
+		case <-q.signal:
+		case <-q.closing:
+			closing = true
+		}
+		for {
+			p := q.next(time.Now())
+			if p == nil {
+				break
+			}
+			_, err := q.writer.Write(&p.header, p.payload.B, p.attributes)
+			p.payload.Release()
+			if err != nil {
+				log.Printf("failed to write queued packet: %v", err)
+			}
+		}
+		if closing {
+			return
+		}
+	}
+}
+
+// close stops accepting packets and waits until the queued packets are
+// written or the deadline passed, then the remaining packets are dropped.
+func (q *sendQueue) close(deadline time.Time) {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return
+	}
+	q.closed = true
+	q.lock.Unlock()
+	close(q.closing)
+
+	select {
+	case <-q.done:
+		return
+	case <-time.After(time.Until(deadline)):
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, p := range q.packets {
+		p.payload.Release()
+		logging.Drop(logging.DropSendQueueDelay, "SSRC %v, seqNr=%v not written before close", p.header.SSRC, p.header.SequenceNumber)
+	}
+	q.packets = nil
+}
+
+// next drops the packets exceeding the budget at now and returns the oldest
+// remaining packet, nil if there is none.
+func (q *sendQueue) next(now time.Time) *queuedPacket {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.dropLate(now)
+	if len(q.packets) == 0 {
+		return nil
+	}
+	p := q.packets[0]
+	q.packets[0] = nil
+	q.packets = q.packets[1:]
+	return p
+}
+
+// dropLate drops the packets of frames other than key frames which waited
+// longer than the budget and, with SendQueueDropFrames, the other queued
+// packets of their frames.
+func (q *sendQueue) dropLate(now time.Time) {
+	late := map[frameKey]bool{}
+	for _, p := range q.packets {
+		if now.Sub(p.enqueued) <= q.budget {
+			// the packets are queued in order
+			break
+		}
+		if !p.keyFrame {
+			late[frameKey{p.header.SSRC, p.header.Timestamp}] = true
+		}
+	}
+	if len(late) == 0 {
+		return
+	}
+	kept := q.packets[:0]
+	for _, p := range q.packets {
+		key := frameKey{p.header.SSRC, p.header.Timestamp}
+		drop := !p.keyFrame && now.Sub(p.enqueued) > q.budget
+		if q.policy == SendQueueDropFrames {
+			drop = late[key] && !p.keyFrame
+		}
+		if !drop {
+			kept = append(kept, p)
+			continue
+		}
+		p.payload.Release()
+		logging.Drop(logging.DropSendQueueDelay, "SSRC %v, seqNr=%v waited %v", p.header.SSRC, p.header.SequenceNumber, now.Sub(p.enqueued))
+	}
+	for i := len(kept); i < len(q.packets); i++ {
+		q.packets[i] = nil
+	}
+	q.packets = kept
+	if q.policy == SendQueueDropFrames {
+		for key := range late {
+			q.dropping[key.ssrc] = key.timestamp
+		}
+	}
+}
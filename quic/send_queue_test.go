@@ -0,0 +1,44 @@
+package quic
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	pionrtp "github.com/pion/rtp"
+)
+
+func TestSendQueueClose(t *testing.T) {
+	var lock sync.Mutex
+	written := []uint16{}
+	q := newSendQueue(time.Hour, SendQueueDropPackets, interceptor.RTPWriterFunc(func(header *pionrtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		// a writer held back by the pacer
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		defer lock.Unlock()
+		written = append(written, header.SequenceNumber)
+		return header.MarshalSize() + len(payload), nil
+	}))
+	for i := 0; i < 50; i++ {
+		if _, err := q.Write(&pionrtp.Header{SSRC: 1, SequenceNumber: uint16(i)}, []byte{1, 2, 3}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.close(time.Now().Add(time.Second))
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(written) != 50 {
+		t.Fatalf("got %v packets written before close returned, want 50", len(written))
+	}
+	for i, seqNr := range written {
+		if seqNr != uint16(i) {
+			t.Fatalf("got packet %v written at %v, want in order", seqNr, i)
+		}
+	}
+	if _, err := q.Write(&pionrtp.Header{SSRC: 1}, nil, nil); !errors.Is(err, errSendQueueClosed) {
+		t.Fatalf("got error %v writing to closed queue, want %v", err, errSendQueueClosed)
+	}
+}
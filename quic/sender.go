@@ -5,23 +5,41 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Willi-42/rtp-over-quic/buffers"
 	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/events"
 	"github.com/Willi-42/rtp-over-quic/logging"
 	"github.com/Willi-42/rtp-over-quic/rtp"
 	"github.com/lucas-clemente/quic-go"
 	quiclogging "github.com/lucas-clemente/quic-go/logging"
 	"github.com/lucas-clemente/quic-go/quicvarint"
 	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
 	pionrtp "github.com/pion/rtp"
 )
 
-const rtpOverQUICALPN = "rtp-mux-quic"
+const (
+	rtpOverQUICALPN   = "rtp-mux-quic"
+	pathCacheInterval = 5 * time.Second
+)
+
+var (
+	errInvalidFECConfig = errors.New("invalid FEC configuration")
+	errInvalidConfig    = errors.New("invalid configuration")
+	errRejected         = errors.New("connection rejected")
+	errReconnectFailed  = errors.New("failed to reconnect")
+	errFlowIDInUse      = errors.New("flow ID already in use")
+	errReservedFlowID   = errors.New("flow ID reserved for FEC and aggregation")
+)
 
 type SenderOption func(*SenderConfig) error
 
@@ -60,6 +78,137 @@ func SetLocalRFC8888(enabled bool) SenderOption {
 	}
 }
 
+// BackupAddress sets the address of a backup receiver. If the connection to the
+// current receiver fails, the sender fails over to the other receiver.
+func BackupAddress(addr string) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.backupAddr = addr
+		return nil
+	}
+}
+
+// FailoverTimeout sets the time after which a connection without any traffic
+// from the receiver is considered failed. It is also used as the timeout for
+// connecting to the backup receiver.
+func FailoverTimeout(timeout time.Duration) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.failoverTimeout = timeout
+		return nil
+	}
+}
+
+// Reconnect makes the sender reconnect to the receiver if the connection is
+// lost, waiting backoff after the first failed attempt and doubling the wait
+// up to maxBackoff after every further one. It gives up after attempts
+// failed attempts, 0 means never. The interceptors and flows are kept.
+func Reconnect(attempts int, backoff, maxBackoff time.Duration) SenderOption {
+	return func(sc *SenderConfig) error {
+		if attempts < 0 {
+			return fmt.Errorf("%w: negative number of reconnection attempts %v", errInvalidConfig, attempts)
+		}
+		if backoff <= 0 || maxBackoff < backoff {
+			return fmt.Errorf("%w: reconnection backoff must be positive and at most the maximum backoff, got %v and %v", errInvalidConfig, backoff, maxBackoff)
+		}
+		sc.reconnect = true
+		sc.reconnectAttempts = attempts
+		sc.reconnectBackoff = backoff
+		sc.reconnectMaxBackoff = maxBackoff
+		return nil
+	}
+}
+
+// SetPathCache enables storing the measured path properties of the
+// connection in c.
+func SetPathCache(c *PathCache) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.pathCache = c
+		return nil
+	}
+}
+
+// SetSenderEventBus publishes acknowledged and lost datagrams and the
+// closing of the connection to bus.
+func SetSenderEventBus(bus *events.Bus) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.events = bus
+		return nil
+	}
+}
+
+// SetSenderHooks sets the hooks called when connections are established and
+// closed and when flows are opened.
+func SetSenderHooks(h *events.Hooks) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.hooks = h
+		return nil
+	}
+}
+
+// SetFEC protects groups of n datagrams with an XOR repair datagram, which
+// allows the receiver to recover one lost datagram per group. 0 disables
+// FEC.
+func SetFEC(n int) SenderOption {
+	return func(sc *SenderConfig) error {
+		if n < 0 || n > math.MaxUint8 {
+			return fmt.Errorf("%w: FEC group size must be in [0, %v], got %v", errInvalidFECConfig, math.MaxUint8, n)
+		}
+		sc.fecGroupSize = n
+		return nil
+	}
+}
+
+// SetAggregation sends small datagrams, e.g., audio or FEC repair packets,
+// together in one datagram, if they arrive within maxDelay. 0 disables
+// aggregation.
+func SetAggregation(maxDelay time.Duration) SenderOption {
+	return func(sc *SenderConfig) error {
+		if maxDelay < 0 {
+			return fmt.Errorf("%w: aggregation delay must not be negative, got %v", errInvalidConfig, maxDelay)
+		}
+		sc.aggregationDelay = maxDelay
+		return nil
+	}
+}
+
+// SetPacer paces all packets of the sender using p.
+func SetPacer(p *Pacer) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.pacer = p
+		return nil
+	}
+}
+
+// SetSendQueue queues the packets of each flow before writing them and drops
+// packets which waited longer than budget according to policy, one of
+// SendQueuePolicies. 0 disables the queue.
+func SetSendQueue(budget time.Duration, policy string) SenderOption {
+	return func(sc *SenderConfig) error {
+		if budget < 0 {
+			return fmt.Errorf("%w: send queue budget must not be negative, got %v", errInvalidConfig, budget)
+		}
+		if policy != SendQueueDropPackets && policy != SendQueueDropFrames {
+			return fmt.Errorf("%w: unknown send queue policy %q, expected one of %v", errInvalidConfig, policy, SendQueuePolicies)
+		}
+		sc.sendQueueBudget = budget
+		sc.sendQueuePolicy = policy
+		return nil
+	}
+}
+
+// SetBackpressure drops packets which the pacer, the congestion controller
+// or the flow control of streams hold back longer than timeout instead of
+// blocking the writer and publishes events.Backpressure for each of them. 0
+// disables the timeout.
+func SetBackpressure(timeout time.Duration) SenderOption {
+	return func(sc *SenderConfig) error {
+		if timeout < 0 {
+			return fmt.Errorf("%w: backpressure timeout must not be negative, got %v", errInvalidConfig, timeout)
+		}
+		sc.backpressureTimeout = timeout
+		return nil
+	}
+}
+
 func SetTransportMode(mode TransportMode) SenderOption {
 	return func(sc *SenderConfig) error {
 		sc.transportMode = mode
@@ -69,6 +218,8 @@ func SetTransportMode(mode TransportMode) SenderOption {
 
 type SenderConfig struct {
 	remoteAddr        string
+	backupAddr        string
+	failoverTimeout   time.Duration
 	qlogDirectoryName string
 	sslKeyLogFileName string
 
@@ -76,47 +227,145 @@ type SenderConfig struct {
 	localRFC8888  bool
 	maxMTU        uint
 	transportMode TransportMode
+	pathCache     *PathCache
+	fecGroupSize  int
+	pacer         *Pacer
+
+	aggregationDelay time.Duration
+
+	sendQueueBudget time.Duration
+	sendQueuePolicy string
+
+	backpressureTimeout time.Duration
+
+	events *events.Bus
+	hooks  *events.Hooks
+
+	reconnect           bool
+	reconnectAttempts   int
+	reconnectBackoff    time.Duration
+	reconnectMaxBackoff time.Duration
 }
 
 type Sender struct {
+	// lostDatagrams is accessed atomically and first for 64-bit alignment.
+	lostDatagrams uint64
+
 	*SenderConfig
 
+	connLock            sync.RWMutex
 	conn                quic.Connection
+	currentAddr         string
+	tlsConf             *tls.Config
+	quicConf            *quic.Config
 	metricsTracer       *RTTTracer
 	interceptorRegistry *interceptor.Registry
 	interceptor         interceptor.Interceptor
+	rtcpWriter          interceptor.RTCPWriter
 	localFeedback       *localRFC8888Generator
+	controller          congestionController
+	fec                 *fecEncoder
+	aggregator          *aggregator
 
-	flowIDs map[uint64]struct{}
+	// flowIDsLock guards flowIDs, flows may be opened concurrently.
+	flowIDsLock sync.Mutex
+	flowIDs     map[uint64]struct{}
+	sources     *rtp.Sources
+	// queues are the send queues of the media flows, flushed by Close.
+	queuesLock sync.Mutex
+	queues     []*sendQueue
+
+	// closed is closed by Close, so that the connection is not failed over.
+	closed    chan struct{}
+	closeOnce sync.Once
+	// lost is closed when the receiver closed the connection or when
+	// reconnecting or failing over gave up.
+	lost     chan struct{}
+	lostOnce sync.Once
 }
 
 func NewSender(r *interceptor.Registry, opts ...SenderOption) (*Sender, error) {
 	s := &Sender{
 		SenderConfig: &SenderConfig{
 			remoteAddr:        ":4242",
+			backupAddr:        "",
+			failoverTimeout:   2 * time.Second,
 			qlogDirectoryName: "",
 			sslKeyLogFileName: "",
 			cc:                cc.Reno,
 			localRFC8888:      false,
 			maxMTU:            1300,
 			transportMode:     ANY,
+			pathCache:         nil,
+			fecGroupSize:      0,
+			pacer:             nil,
+			aggregationDelay:  0,
+			sendQueueBudget:   0,
+			sendQueuePolicy:   SendQueueDropFrames,
+			events:            nil,
 		},
+		connLock:            sync.RWMutex{},
 		conn:                nil,
+		currentAddr:         "",
+		tlsConf:             nil,
+		quicConf:            nil,
 		metricsTracer:       nil,
 		interceptorRegistry: r,
 		localFeedback:       nil,
+		controller:          nil,
+		fec:                 nil,
+		aggregator:          nil,
 		flowIDs:             make(map[uint64]struct{}),
+		sources:             rtp.NewSources(),
+		closed:              make(chan struct{}),
+		lost:                make(chan struct{}),
 	}
 	for _, opt := range opts {
 		if err := opt(s.SenderConfig); err != nil {
 			return nil, err
 		}
 	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	if s.fecGroupSize > 0 {
+		s.fec = newFECEncoder(s.fecGroupSize)
+	}
+	if s.aggregationDelay > 0 {
+		s.aggregator = newAggregator(int(s.maxMTU), s.aggregationDelay, s.transmitDgram)
+	}
 	return s, nil
 }
 
+func (sc *SenderConfig) validate() error {
+	if len(sc.backupAddr) > 0 {
+		if sc.backupAddr == sc.remoteAddr {
+			return fmt.Errorf("%w: backup address %v equals the remote address", errInvalidConfig, sc.backupAddr)
+		}
+		if sc.failoverTimeout <= 0 {
+			return fmt.Errorf("%w: failover timeout must be positive, got %v", errInvalidConfig, sc.failoverTimeout)
+		}
+	}
+	if sc.fecGroupSize > 0 && sc.transportMode == STREAM {
+		return fmt.Errorf("%w: FEC can't be used in stream transport mode", errInvalidFECConfig)
+	}
+	if sc.fecGroupSize > 0 && sc.transportMode == MOQ {
+		return fmt.Errorf("%w: FEC can't be used with Media over QUIC", errInvalidFECConfig)
+	}
+	if sc.aggregationDelay > 0 && sc.transportMode == MOQ {
+		return fmt.Errorf("%w: aggregation can't be used with Media over QUIC", errInvalidConfig)
+	}
+	if sc.aggregationDelay > 0 && sc.transportMode == STREAM {
+		return fmt.Errorf("%w: aggregation can't be used in stream transport mode", errInvalidConfig)
+	}
+	return nil
+}
+
 func (s *Sender) newFlowID() (uint64, error) {
-	for i := uint64(0); i < math.MaxUint64; i++ {
+	s.flowIDsLock.Lock()
+	defer s.flowIDsLock.Unlock()
+	// the highest IDs are reserved for FEC and aggregation
+	for i := uint64(0); i < aggregateFlowID; i++ {
 		if _, ok := s.flowIDs[i]; !ok {
 			s.flowIDs[i] = struct{}{}
 			return i, nil
@@ -125,6 +374,22 @@ func (s *Sender) newFlowID() (uint64, error) {
 	return 0, errors.New("too many flows, no unused IDs left")
 }
 
+// reserveFlowID marks id as used, so that newFlowID doesn't return a flow ID
+// chosen by the caller. It fails if id is already used by another flow or is
+// one of the flow IDs of FEC and aggregated datagrams.
+func (s *Sender) reserveFlowID(id uint64) error {
+	if id >= aggregateFlowID {
+		return fmt.Errorf("%w: %v", errReservedFlowID, id)
+	}
+	s.flowIDsLock.Lock()
+	defer s.flowIDsLock.Unlock()
+	if _, ok := s.flowIDs[id]; ok {
+		return fmt.Errorf("%w: %v", errFlowIDInUse, id)
+	}
+	s.flowIDs[id] = struct{}{}
+	return nil
+}
+
 func (s *Sender) Connect(ctx context.Context) error {
 	qlogWriter, err := logging.GetQLOGTracer(s.qlogDirectoryName)
 	if err != nil {
@@ -134,18 +399,32 @@ func (s *Sender) Connect(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	tlsConf := &tls.Config{
+	s.tlsConf = &tls.Config{
 		KeyLogWriter:       keyLogger,
 		InsecureSkipVerify: true,
 		NextProtos:         []string{rtpOverQUICALPN},
+		ClientSessionCache: clientSessionCache,
+	}
+	if s.transportMode == MOQ {
+		s.tlsConf.NextProtos = []string{moqALPN}
 	}
 	s.metricsTracer = NewTracer()
+	switch s.cc {
+	case cc.BBR:
+		s.controller = newBBR()
+	case cc.Copa:
+		s.controller = newCopa()
+	}
+	s.metricsTracer.cc = s.controller
+	if s.localRFC8888 {
+		s.metricsTracer.streamAcks = newStreamAckTracker()
+	}
 	tracers := []quiclogging.Tracer{s.metricsTracer}
 	if qlogWriter != nil {
 		tracers = append(tracers, qlogWriter)
 	}
 	tracer := quiclogging.NewMultiplexedTracer(tracers...)
-	quicConf := &quic.Config{
+	s.quicConf = &quic.Config{
 		EnableDatagrams:       true,
 		HandshakeIdleTimeout:  15 * time.Second,
 		Tracer:                tracer,
@@ -153,11 +432,20 @@ func (s *Sender) Connect(ctx context.Context) error {
 		MaxIncomingStreams:    1 << 60,
 		MaxIncomingUniStreams: 1 << 60,
 	}
-	conn, err := quic.DialAddrContext(ctx, s.remoteAddr, tlsConf, quicConf)
+	if len(s.backupAddr) > 0 {
+		s.quicConf.HandshakeIdleTimeout = s.failoverTimeout
+		s.quicConf.MaxIdleTimeout = s.failoverTimeout
+	}
+	conn, err := s.dial(ctx, s.remoteAddr)
 	if err != nil {
-		return err
+		if len(s.backupAddr) == 0 {
+			return err
+		}
+		log.Printf("failed to connect to primary receiver %v: %v, trying backup receiver %v", s.remoteAddr, err, s.backupAddr)
+		if conn, err = s.dial(ctx, s.backupAddr); err != nil {
+			return err
+		}
 	}
-	s.conn = conn
 
 	i, err := s.interceptorRegistry.Build("")
 	if err != nil {
@@ -170,10 +458,15 @@ func (s *Sender) Connect(ctx context.Context) error {
 			return len(b), a, nil
 		}),
 	)
+	s.rtcpWriter = s.interceptor.BindRTCPWriter(interceptor.RTCPWriterFunc(s.writeRTCP))
 
 	rtcpChan := make(chan rtp.RTCPFeedback)
 	go rtp.ReadRTCP(ctx, rtcpReader, rtcpChan)
-	go s.readFromNetwork(ctx, rtcpChan)
+	go s.readFromNetwork(ctx, conn, rtcpChan)
+
+	if len(s.backupAddr) > 0 || s.reconnect {
+		go s.watchConnection(ctx, rtcpChan)
+	}
 
 	if s.localRFC8888 {
 		s.localFeedback = newLocalRFC8888Generator(0, s.metricsTracer, func(r rtp.RTCPFeedback) {
@@ -182,19 +475,209 @@ func (s *Sender) Connect(ctx context.Context) error {
 		go s.localFeedback.run(ctx)
 	}
 
+	if s.pathCache != nil {
+		go s.updatePathCache(ctx)
+	}
+
 	return nil
 }
 
-func (s *Sender) readFromNetwork(ctx context.Context, rtcpChan chan rtp.RTCPFeedback) {
+// updatePathCache periodically stores the current path properties in the
+// path cache. It saves periodically instead of on exit, because the process is
+// usually stopped by a signal.
+func (s *Sender) updatePathCache(ctx context.Context) {
+	ticker := time.NewTicker(pathCacheInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			metrics := s.metricsTracer.Metrics()
+			params := s.metricsTracer.TransportParameters()
+			s.pathCache.Update(s.address(), func(p *PathProperties) {
+				p.MinRTT = metrics.MinRTT
+				p.SmoothedRTT = metrics.SmoothedRTT
+				p.RTTVar = metrics.RTTVar
+				if params != nil {
+					p.MaxIdleTimeout = params.MaxIdleTimeout
+					p.MaxAckDelay = params.MaxAckDelay
+					p.MaxUDPPayloadSize = int64(params.MaxUDPPayloadSize)
+					p.MaxDatagramFrameSize = int64(params.MaxDatagramFrameSize)
+					p.InitialMaxData = int64(params.InitialMaxData)
+				}
+			})
+			if err := s.pathCache.Save(); err != nil {
+				log.Printf("failed to save path cache: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Sender) dial(ctx context.Context, addr string) (quic.Connection, error) {
+	if s.controller != nil {
+		s.controller.reset()
+	}
+	conn, err := quic.DialAddrContext(ctx, addr, s.tlsConf, s.quicConf)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hooks.Connect(connectionInfo(conn)); err != nil {
+		if err := conn.CloseWithError(ErrorCodeGeneralError, "connection rejected"); err != nil {
+			log.Printf("failed to close connection: %v", err)
+		}
+		return nil, fmt.Errorf("%w: %v", errRejected, err)
+	}
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	s.conn = conn
+	s.currentAddr = addr
+	return conn, nil
+}
+
+func (s *Sender) connection() quic.Connection {
+	s.connLock.RLock()
+	defer s.connLock.RUnlock()
+	return s.conn
+}
+
+func (s *Sender) address() string {
+	s.connLock.RLock()
+	defer s.connLock.RUnlock()
+	return s.currentAddr
+}
+
+// failingOver returns true if the current connection is closed and the
+// sender is trying to connect to another receiver or to reconnect. Packets
+// written meanwhile are dropped.
+func (s *Sender) failingOver() bool {
+	if len(s.backupAddr) == 0 && !s.reconnect {
+		return false
+	}
+	select {
+	case <-s.lost:
+		return false
+	default:
+	}
+	return s.connection().Context().Err() != nil
+}
+
+// Lost returns a channel which is closed when the receiver closed the
+// connection and the sender neither reconnects nor fails over, or when it gave
+// up reconnecting or failing over.
+func (s *Sender) Lost() <-chan struct{} {
+	return s.lost
+}
+
+func (s *Sender) giveUp() {
+	s.lostOnce.Do(func() {
+		close(s.lost)
+	})
+}
+
+// watchConnection waits for the current connection to fail and then
+// reconnects or switches between primary and backup receiver until a new
+// connection is established. Flows and the interceptor chain are kept, so
+// that SSRCs and flow IDs are preserved across the failover.
+func (s *Sender) watchConnection(ctx context.Context, rtcpChan chan rtp.RTCPFeedback) {
 	for {
-		buf, err := s.conn.ReceiveMessage()
+		select {
+		case <-s.connection().Context().Done():
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-s.closed:
+			return
+		default:
+		}
+		failed := s.address()
+		log.Printf("failover: connection to %v failed", failed)
+		conn, err := s.redial(ctx, failed)
 		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("failover: giving up: %v", err)
+				s.giveUp()
+			}
+			return
+		}
+		go s.readFromNetwork(ctx, conn, rtcpChan)
+	}
+}
+
+// redial connects to the backup receiver if failed is the primary receiver
+// and vice versa, or to the receiver again if there is no backup receiver.
+// With reconnection enabled, it waits with exponential backoff between the
+// attempts and gives up after the configured number of attempts.
+func (s *Sender) redial(ctx context.Context, failed string) (quic.Connection, error) {
+	start := time.Now()
+	next := s.remoteAddr
+	if len(s.backupAddr) > 0 && failed != s.backupAddr {
+		next = s.backupAddr
+	}
+	backoff := s.reconnectBackoff
+	for attempt := 1; ; attempt++ {
+		dialCtx, cancel := context.WithTimeout(ctx, s.failoverTimeout)
+		conn, err := s.dial(dialCtx, next)
+		cancel()
+		if err == nil {
+			log.Printf("failover: switched from %v to %v after %v and %v attempts", failed, next, time.Since(start), attempt)
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("failover: failed to connect to %v: %v", next, err)
+		if s.reconnect && s.reconnectAttempts > 0 && attempt >= s.reconnectAttempts {
+			return nil, fmt.Errorf("%w: %v attempts failed", errReconnectFailed, attempt)
+		}
+		if len(s.backupAddr) > 0 {
+			if next == s.backupAddr {
+				next = s.remoteAddr
+			} else {
+				next = s.backupAddr
+			}
+		}
+		if !s.reconnect {
+			continue
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+		if backoff > s.reconnectMaxBackoff {
+			backoff = s.reconnectMaxBackoff
+		}
+	}
+}
+
+func (s *Sender) readFromNetwork(ctx context.Context, conn quic.Connection, rtcpChan chan rtp.RTCPFeedback) {
+	for {
+		buf, err := conn.ReceiveMessage()
+		if err != nil {
+			if e, ok := closedByPeer(err); ok {
+				log.Printf("receiver closed the connection: %v (%v)", ErrorCodeName(e.ErrorCode), e.ErrorMessage)
+				s.publishClosed(conn, err)
+				if len(s.backupAddr) == 0 && !s.reconnect {
+					s.giveUp()
+				}
+				return
+			}
 			if e, ok := err.(*quic.ApplicationError); ok && e.ErrorCode == 0 {
 				log.Printf("QUIC received application error, exiting reader routine: %v", err)
+				s.publishClosed(conn, err)
 				return
 			}
 			if ne, ok := err.(net.Error); ok && ne.Timeout() {
 				log.Printf("QUIC connection timed out, exiting datagram receiver routine: %v", err)
+				s.publishClosed(conn, err)
+				return
+			}
+			if conn.Context().Err() != nil {
+				log.Printf("QUIC connection closed, exiting datagram receiver routine: %v", err)
+				s.publishClosed(conn, err)
 				return
 			}
 			log.Printf("failed to receive QUIC datagram: %v", err)
@@ -203,7 +686,7 @@ func (s *Sender) readFromNetwork(ctx context.Context, rtcpChan chan rtp.RTCPFeed
 		// TODO: If multiple RTCP flows are required, demultiplex on id here
 		id, err := quicvarint.Read(bytes.NewReader(buf))
 		if err != nil {
-			log.Printf("failed to read flow ID: %v, dropping datagram", err)
+			logging.Drop(logging.DropParseError, "failed to read flow ID of datagram: %v", err)
 			continue
 		}
 		rtcpChan <- rtp.RTCPFeedback{
@@ -213,66 +696,341 @@ func (s *Sender) readFromNetwork(ctx context.Context, rtcpChan chan rtp.RTCPFeed
 	}
 }
 
+func (s *Sender) publishClosed(conn quic.Connection, err error) {
+	s.events.Publish(events.ConnectionClosed{
+		Time:       time.Now(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		Err:        err,
+	})
+	s.hooks.Disconnect(connectionInfo(conn), err)
+}
+
+// streamOpened calls the OnStreamOpen hook for the flow id.
+func (s *Sender) streamOpened(id uint64) {
+	s.hooks.StreamOpen(events.FlowInfo{
+		Connection: connectionInfo(s.connection()),
+		FlowID:     id,
+	})
+}
+
+// pace blocks until the pacer and the congestion controller allow sending
+// size bytes. The congestion controller does not delay packets if the QUIC
+// congestion control of quic-go is used.
+func (s *Sender) pace(ctx context.Context, size int) error {
+	if s.pacer != nil {
+		if err := s.pacer.wait(ctx, size); err != nil {
+			return err
+		}
+	}
+	if s.controller == nil {
+		return nil
+	}
+	return s.controller.wait(ctx, size)
+}
+
+// TargetBitrate returns the sending rate in bits per second derived from the
+// QUIC level congestion controller or 0 if there is none or no estimate yet.
+func (s *Sender) TargetBitrate() int {
+	if s.controller == nil {
+		return 0
+	}
+	return s.controller.targetRate()
+}
+
+// SenderStats are the losses of a sender.
+type SenderStats struct {
+	// LostDatagrams counts datagrams carrying RTP packets which were
+	// declared lost.
+	LostDatagrams uint64
+	// Retransmits counts lost QUIC packets carrying stream data, which is
+	// retransmitted.
+	Retransmits uint64
+	// DroppedAcks counts acknowledgments which the local RFC 8888 feedback
+	// generator didn't keep up with.
+	DroppedAcks uint64
+}
+
+// Stats returns the losses since the sender connected.
+func (s *Sender) Stats() SenderStats {
+	stats := SenderStats{
+		LostDatagrams: atomic.LoadUint64(&s.lostDatagrams),
+	}
+	if s.metricsTracer != nil {
+		stats.Retransmits = s.metricsTracer.Retransmits()
+	}
+	if s.localFeedback != nil {
+		stats.DroppedAcks = s.localFeedback.Dropped()
+	}
+	return stats
+}
+
+// Close writes the packets of the send queues, waiting at most
+// sendQueueFlushTimeout, sends pending aggregated datagrams and an RTCP BYE
+// for all sent SSRCs, closes the interceptors and then the connection with
+// ErrorCodeNoError, which also completes the qlog file. The media has to be
+// stopped before, the flows don't accept packets afterwards.
+func (s *Sender) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.queuesLock.Lock()
+		queues := s.queues
+		s.queuesLock.Unlock()
+		deadline := time.Now().Add(sendQueueFlushTimeout)
+		for _, q := range queues {
+			q.close(deadline)
+		}
+		close(s.closed)
+		if s.aggregator != nil {
+			if err := s.aggregator.flush(); err != nil {
+				log.Printf("failed to send aggregated datagram: %v", err)
+			}
+		}
+		if s.connection() == nil {
+			return
+		}
+		if bye := s.sources.Goodbye("sender shutdown"); bye != nil {
+			if _, err := s.writeRTCP(bye, nil); err != nil {
+				log.Printf("failed to send RTCP BYE: %v", err)
+			}
+		}
+		if s.interceptor != nil {
+			if err = s.interceptor.Close(); err != nil {
+				err = fmt.Errorf("failed to close interceptors: %w", err)
+			}
+		}
+		if cerr := s.connection().CloseWithError(ErrorCodeNoError, "sender shutdown"); cerr != nil && err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+// Metrics returns the state of the QUIC level congestion controller. If
+// quic-go's congestion control is used, only the RTT is known.
+func (s *Sender) Metrics() cc.Metrics {
+	var m cc.Metrics
+	if s.controller != nil {
+		m = s.controller.metrics()
+	}
+	if m.RTT == 0 && s.metricsTracer != nil {
+		m.RTT = s.metricsTracer.Metrics().SmoothedRTT
+	}
+	return m
+}
+
 func (s *Sender) writeDgram(buf []byte, cb func(bool, uint64)) (int, error) {
-	return len(buf), s.conn.SendMessage(buf, cb)
+	if s.fec == nil {
+		return s.sendDgram(buf, cb)
+	}
+	source, repair := s.fec.protect(buf)
+	if _, err := s.sendDgram(source, cb); err != nil {
+		return 0, err
+	}
+	if repair != nil {
+		if _, err := s.sendDgram(repair, nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(buf), nil
+}
+
+// sendDgram sends buf through the aggregator, if aggregation is enabled.
+func (s *Sender) sendDgram(buf []byte, cb func(bool, uint64)) (int, error) {
+	if s.aggregator != nil {
+		return s.aggregator.write(buf, cb)
+	}
+	return s.transmitDgram(buf, cb)
+}
+
+func (s *Sender) transmitDgram(buf []byte, cb func(bool, uint64)) (int, error) {
+	ctx, cancel := s.writeContext()
+	defer cancel()
+	if err := s.pace(ctx, len(buf)); err != nil {
+		if s.backpressured(err) {
+			s.backpressure("datagram", len(buf), err)
+			return len(buf), nil
+		}
+		return 0, err
+	}
+	if err := s.connection().SendMessage(buf, cb); err != nil {
+		if s.failingOver() {
+			// drop packets until the connection to the other receiver is
+			// established
+			return len(buf), nil
+		}
+		if uint(len(buf)) > s.maxMTU {
+			logging.QLOGEvent(logging.QLOGDatagramDropped, map[string]interface{}{
+				"length":     len(buf),
+				"max_length": s.maxMTU,
+				"trigger":    "too_large",
+			})
+		}
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// writeRTCP sends RTCP packets to the receiver as a datagram on the flow ID
+// given by the 'flow-id' attribute or flow 0.
+func (s *Sender) writeRTCP(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+	buf, err := rtcp.Marshal(pkts)
+	if err != nil {
+		return 0, err
+	}
+	var id uint64
+	if i := attributes.Get("flow-id"); i != nil {
+		id = i.(uint64)
+	}
+	if err := s.connection().SendMessage(append(appendFlowID(nil, id), buf...), nil); err != nil {
+		if s.failingOver() {
+			return len(buf), nil
+		}
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// WriteRTCP sends pkts to the receiver through the interceptors, e.g., the
+// sender reports a relay forwards. It must not be called before Connect.
+func (s *Sender) WriteRTCP(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+	return s.rtcpWriter.Write(pkts, attributes)
 }
 
-func (s *Sender) writeStream(buf []byte) (int, error) {
-	stream, err := s.conn.OpenUniStreamSync(context.Background())
+// writeStream sends buf on a new stream. cb is called once all data of the
+// stream is acknowledged, if it is not nil.
+func (s *Sender) writeStream(buf []byte, cb func(time.Time)) (int, error) {
+	ctx, cancel := s.writeContext()
+	defer cancel()
+	stream, err := s.connection().OpenUniStreamSync(ctx)
 	if err != nil {
+		if s.failingOver() {
+			return len(buf), nil
+		}
+		if s.backpressured(err) {
+			s.backpressure("stream", len(buf), err)
+			return len(buf), nil
+		}
 		return 0, err
 	}
 	defer stream.Close()
-	return stream.Write(buf)
+	if err := s.pace(ctx, len(buf)); err != nil {
+		if s.backpressured(err) {
+			stream.CancelWrite(quic.StreamErrorCode(ErrorCodeFrameCancelled))
+			s.backpressure("stream", len(buf), err)
+			return len(buf), nil
+		}
+		return 0, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := stream.SetWriteDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+	tracked := cb != nil && s.metricsTracer.streamAcks != nil
+	if tracked {
+		s.metricsTracer.streamAcks.track(stream.StreamID(), len(buf), cb)
+	}
+	n, err := stream.Write(buf)
+	if s.backpressured(err) {
+		stream.CancelWrite(quic.StreamErrorCode(ErrorCodeFrameCancelled))
+		if tracked {
+			s.metricsTracer.streamAcks.untrack(stream.StreamID())
+		}
+		s.backpressure("stream", len(buf), err)
+		return len(buf), nil
+	}
+	return n, err
+}
+
+// queued returns w behind a send queue if the queue is enabled.
+func (s *Sender) queued(w interceptor.RTPWriter) interceptor.RTPWriter {
+	if s.sendQueueBudget <= 0 {
+		return w
+	}
+	q := newSendQueue(s.sendQueueBudget, s.sendQueuePolicy, w)
+	s.queuesLock.Lock()
+	defer s.queuesLock.Unlock()
+	s.queues = append(s.queues, q)
+	return q
 }
 
-func (s *Sender) NewMediaStreamWithFlowID(id uint64) interceptor.RTPWriter {
-	var idBuffer bytes.Buffer
-	idWriter := quicvarint.NewWriter(&idBuffer)
-	quicvarint.Write(idWriter, id)
-	idBytes := idBuffer.Bytes()
-	return s.interceptor.BindLocalStream(&interceptor.StreamInfo{}, interceptor.RTPWriterFunc(
+// NewMediaStreamWithFlowID returns the writer of a new media flow with the
+// flow ID id, which must not be used by another flow.
+func (s *Sender) NewMediaStreamWithFlowID(id uint64) (interceptor.RTPWriter, error) {
+	if err := s.reserveFlowID(id); err != nil {
+		return nil, err
+	}
+	return s.newMediaStream(id), nil
+}
+
+// newMediaStream returns the writer of the media flow with the reserved flow
+// ID id.
+func (s *Sender) newMediaStream(id uint64) interceptor.RTPWriter {
+	if s.transportMode == MOQ {
+		s.streamOpened(id)
+		t := &moqTrack{sender: s, alias: id}
+		return s.interceptor.BindLocalStream(rtp.NewLocalStreamInfo(), s.queued(rtp.TraceTransport("moq", interceptor.RTPWriterFunc(
+			func(header *pionrtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+				s.sources.Add(header.SSRC)
+				return t.write(header, payload, attributes)
+			},
+		))))
+	}
+	// idBytes is shared by all, possibly concurrent, writes of the flow and
+	// must only be read
+	idBytes := appendFlowID(nil, id)
+	logging.QLOGEvent(logging.QLOGFlowCreated, map[string]interface{}{"flow_id": id})
+	s.streamOpened(id)
+	return s.interceptor.BindLocalStream(rtp.NewLocalStreamInfo(), s.queued(rtp.TraceTransport("quic", interceptor.RTPWriterFunc(
 		func(header *pionrtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
-			headerBuf, err := header.Marshal()
+			s.sources.Add(header.SSRC)
+			// the aggregator, the FEC encoder and the streams copy what they
+			// keep and quic-go packs datagrams before SendMessage returns
+			buf := buffers.Get(len(idBytes) + header.MarshalSize() + len(payload))
+			defer buf.Release()
+			pl, err := marshalPacket(buf.B, idBytes, header, payload)
 			if err != nil {
 				return 0, err
 			}
-			pl := append(idBytes, headerBuf...)
-			pl = append(pl, payload...)
+			return s.writeMedia(pl, header, attributes)
+		},
+	))))
+}
 
-			if s.transportMode == DGRAM {
-				// log.Printf("send dgram with ACK callback due to DGRAM transportMode")
-				return s.writeDgram(pl, s.ackCallback(time.Now(), header.SSRC, header.MarshalSize()+len(pl), header.SequenceNumber))
-			}
+// writeMedia sends the RTP packet pl prefixed by its flow ID on a stream or
+// in a datagram depending on the transport mode, its size and reliability.
+func (s *Sender) writeMedia(pl []byte, header *pionrtp.Header, attributes interceptor.Attributes) (int, error) {
+	if s.transportMode == DGRAM {
+		// log.Printf("send dgram with ACK callback due to DGRAM transportMode")
+		return s.writeDgram(pl, s.ackCallback(time.Now(), header.SSRC, header.MarshalSize()+len(pl), header.SequenceNumber))
+	}
 
-			if s.transportMode == STREAM {
-				// log.Printf("send stream due to STREAM transportMode")
-				return s.writeStream(pl)
-			}
+	if s.transportMode == STREAM {
+		// log.Printf("send stream due to STREAM transportMode")
+		return s.writeStream(pl, s.streamAckCallback(time.Now(), header.SSRC, header.MarshalSize()+len(pl), header.SequenceNumber))
+	}
 
-			mtu := uint(len(pl))
-			if mtu > s.maxMTU {
-				if s.localRFC8888 {
-					log.Println("WARNING: Sending on stream due to too large MTU, but local CC FB (RFC8888) generation was requested, which is currently not implemented for QUIC streams")
-				}
-				// log.Printf("send stream due to mtu>s.maxMTU")
-				return s.writeStream(pl)
-			}
+	mtu := uint(len(pl))
+	if s.fec != nil {
+		mtu += fecSourceOverhead
+	}
+	if mtu > s.maxMTU {
+		// log.Printf("send stream due to mtu>s.maxMTU")
+		return s.writeStream(pl, s.streamAckCallback(time.Now(), header.SSRC, header.MarshalSize()+len(pl), header.SequenceNumber))
+	}
 
-			if attributes == nil {
-				// log.Printf("send dgram with ACK callback due to nil attributes")
-				return s.writeDgram(pl, s.ackCallback(time.Now(), header.SSRC, header.MarshalSize()+len(pl), header.SequenceNumber))
-			}
+	if attributes == nil {
+		// log.Printf("send dgram with ACK callback due to nil attributes")
+		return s.writeDgram(pl, s.ackCallback(time.Now(), header.SSRC, header.MarshalSize()+len(pl), header.SequenceNumber))
+	}
 
-			reliability := attributes.Get(rtp.RELIABILITY)
-			if reliability != nil && reliability.(rtp.Reliability) == rtp.REQUIRED {
-				// log.Printf("send stream due reliability == REQUIRED")
-				return s.writeStream(pl)
-			}
-			// log.Printf("send dgram due reliability != REQUIRED")
-			return s.writeDgram(pl, s.ackCallback(time.Now(), header.SSRC, header.MarshalSize()+len(pl), header.SequenceNumber))
-		},
-	))
+	reliability := attributes.Get(rtp.RELIABILITY)
+	if reliability != nil && reliability.(rtp.Reliability) == rtp.REQUIRED {
+		// log.Printf("send stream due reliability == REQUIRED")
+		return s.writeStream(pl, s.streamAckCallback(time.Now(), header.SSRC, header.MarshalSize()+len(pl), header.SequenceNumber))
+	}
+	// log.Printf("send dgram due reliability != REQUIRED")
+	return s.writeDgram(pl, s.ackCallback(time.Now(), header.SSRC, header.MarshalSize()+len(pl), header.SequenceNumber))
 }
 
 func (s *Sender) NewMediaStream() (interceptor.RTPWriter, error) {
@@ -280,56 +1038,137 @@ func (s *Sender) NewMediaStream() (interceptor.RTPWriter, error) {
 	if err != nil {
 		return nil, err
 	}
-	return s.NewMediaStreamWithFlowID(id), nil
+	return s.newMediaStream(id), nil
 }
 
+// ackCallback records the one-way delay of acknowledged datagrams, which
+// quic-go reports in microseconds, feeds the local RFC 8888 feedback
+// generator if enabled and publishes the acknowledgment or loss.
 func (s *Sender) ackCallback(sent time.Time, ssrc uint32, size int, seqNr uint16) func(bool, uint64) {
-	if s.localRFC8888 {
-		return func(b bool, owd uint64) {
-			if b {
-				s.localFeedback.ack(ackedPkt{
-					sentTS: sent,
-					ssrc:   ssrc,
-					size:   size,
-					seqNr:  seqNr,
-					owd:    owd,
-				})
-			}
+	return func(b bool, owd uint64) {
+		if !b {
+			atomic.AddUint64(&s.lostDatagrams, 1)
+			s.events.Publish(events.PacketLost{
+				Time:           time.Now(),
+				SSRC:           ssrc,
+				SequenceNumber: seqNr,
+				Size:           size,
+			})
+			return
+		}
+		s.events.Publish(events.PacketAcked{
+			Time:           time.Now(),
+			SSRC:           ssrc,
+			SequenceNumber: seqNr,
+			Size:           size,
+			OneWayDelay:    time.Duration(owd) * time.Microsecond,
+		})
+		if owd > 0 {
+			logging.RecordLatency(logging.LatencyOWD, time.Duration(owd)*time.Microsecond)
+		}
+		if s.localRFC8888 {
+			s.localFeedback.ack(ackedPkt{
+				sentTS: sent,
+				ssrc:   ssrc,
+				size:   size,
+				seqNr:  seqNr,
+				owd:    owd,
+			})
 		}
 	}
-	return nil
+}
+
+// streamAckCallback feeds the local RFC 8888 feedback generator with
+// packets sent on streams or returns nil if it is disabled. quic-go does not
+// report the one-way delay of stream data, it is estimated as half of the
+// time until the acknowledgment.
+func (s *Sender) streamAckCallback(sent time.Time, ssrc uint32, size int, seqNr uint16) func(time.Time) {
+	if !s.localRFC8888 {
+		return nil
+	}
+	return func(acked time.Time) {
+		s.localFeedback.ack(ackedPkt{
+			sentTS: sent,
+			ssrc:   ssrc,
+			size:   size,
+			seqNr:  seqNr,
+			owd:    uint64(acked.Sub(sent).Microseconds() / 2),
+		})
+	}
 }
 
 type DataStreamWriter struct {
 	io.Writer
+	lock sync.Mutex
+}
+
+// Write writes buf to the stream. Concurrent writes are serialized, so that
+// their data is not interleaved.
+func (w *DataStreamWriter) Write(buf []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.Writer.Write(buf)
+}
+
+// pacedWriter applies the congestion window and pacing rate to writes
+// on a data stream.
+type pacedWriter struct {
+	io.Writer
+	ctx    context.Context
+	sender *Sender
+}
+
+func (w *pacedWriter) Write(buf []byte) (int, error) {
+	if err := w.sender.pace(w.ctx, len(buf)); err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(buf)
+}
+
+func (s *Sender) newDataStreamWriter(ctx context.Context, stream io.Writer) *DataStreamWriter {
+	if s.controller == nil {
+		return &DataStreamWriter{
+			Writer: stream,
+		}
+	}
+	return &DataStreamWriter{
+		Writer: &pacedWriter{
+			Writer: stream,
+			ctx:    ctx,
+			sender: s,
+		},
+	}
 }
 
+// NewDataStreamWithFlowID opens a data stream with the flow ID id, which
+// must not be used by another flow.
 func (s *Sender) NewDataStreamWithFlowID(ctx context.Context, id uint64) (io.Writer, error) {
-	stream, err := s.conn.OpenUniStreamSync(ctx)
+	if err := s.reserveFlowID(id); err != nil {
+		return nil, err
+	}
+	return s.newDataStream(ctx, id)
+}
+
+// newDataStream opens a data stream with the reserved flow ID id.
+func (s *Sender) newDataStream(ctx context.Context, id uint64) (io.Writer, error) {
+	stream, err := s.connection().OpenUniStreamSync(ctx)
 	if err != nil {
 		return nil, err
 	}
-	var idBuffer bytes.Buffer
-	idWriter := quicvarint.NewWriter(&idBuffer)
-	quicvarint.Write(idWriter, id)
-	idBytes := idBuffer.Bytes()
-	_, err = stream.Write(idBytes)
+	_, err = stream.Write(appendFlowID(nil, id))
 	if err != nil {
 		return nil, err
 	}
-	return &DataStreamWriter{
-		Writer: stream,
-	}, nil
+	s.streamOpened(id)
+	return s.newDataStreamWriter(ctx, stream), nil
 }
 
 func (s *Sender) NewDataStreamWithoutFlowID(ctx context.Context) (io.Writer, error) {
-	stream, err := s.conn.OpenUniStreamSync(ctx)
+	stream, err := s.connection().OpenUniStreamSync(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &DataStreamWriter{
-		Writer: stream,
-	}, nil
+	return s.newDataStreamWriter(ctx, stream), nil
 }
 
 func (s *Sender) NewDataStreamWithDefaultFlowID(ctx context.Context) (io.Writer, error) {
@@ -337,5 +1176,5 @@ func (s *Sender) NewDataStreamWithDefaultFlowID(ctx context.Context) (io.Writer,
 	if err != nil {
 		return nil, err
 	}
-	return s.NewDataStreamWithFlowID(ctx, id)
+	return s.newDataStream(ctx, id)
 }
@@ -0,0 +1,117 @@
+package quic
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/lucas-clemente/quic-go/quicvarint"
+	"github.com/pion/interceptor"
+	pionrtp "github.com/pion/rtp"
+)
+
+// fakeConnection records the datagrams sent on it.
+type fakeConnection struct {
+	discardConnection
+
+	lock   sync.Mutex
+	dgrams [][]byte
+}
+
+func (c *fakeConnection) SendMessage(b []byte, _ func(bool, uint64)) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dgrams = append(c.dgrams, append([]byte(nil), b...))
+	return nil
+}
+
+// newTestSender returns a datagram sender without interceptors which sends
+// on a fakeConnection.
+func newTestSender(t *testing.T) (*Sender, *fakeConnection) {
+	t.Helper()
+	s, err := NewSender(&interceptor.Registry{}, SetTransportMode(DGRAM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &fakeConnection{}
+	s.conn = conn
+	s.interceptor = &interceptor.NoOp{}
+	return s, conn
+}
+
+// TestSenderConcurrentMediaStreams opens media flows and writes to them
+// concurrently, run it with -race.
+func TestSenderConcurrentMediaStreams(t *testing.T) {
+	const streams = 8
+	const packets = 100
+
+	s, conn := newTestSender(t)
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(ssrc uint32) {
+			defer wg.Done()
+			w, err := s.NewMediaStream()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for j := 0; j < packets; j++ {
+				header := &pionrtp.Header{Version: 2, SSRC: ssrc, SequenceNumber: uint16(j)}
+				if _, err := w.Write(header, []byte{1, 2, 3}, nil); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(uint32(i))
+	}
+	wg.Wait()
+
+	if len(conn.dgrams) != streams*packets {
+		t.Fatalf("got %v datagrams, want %v", len(conn.dgrams), streams*packets)
+	}
+	flows := map[uint64]uint32{}
+	for _, d := range conn.dgrams {
+		r := bytes.NewReader(d)
+		id, err := quicvarint.Read(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pkt pionrtp.Packet
+		if err := pkt.Unmarshal(d[len(d)-r.Len():]); err != nil {
+			t.Fatal(err)
+		}
+		if ssrc, ok := flows[id]; ok && ssrc != pkt.SSRC {
+			t.Fatalf("flow %v carries SSRCs %v and %v", id, ssrc, pkt.SSRC)
+		}
+		flows[id] = pkt.SSRC
+	}
+	if len(flows) != streams {
+		t.Fatalf("got %v flows, want %v", len(flows), streams)
+	}
+}
+
+func TestSenderFlowIDs(t *testing.T) {
+	s, _ := newTestSender(t)
+	if _, err := s.NewMediaStreamWithFlowID(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewMediaStreamWithFlowID(1); !errors.Is(err, errFlowIDInUse) {
+		t.Fatalf("got error %v for flow ID in use, want %v", err, errFlowIDInUse)
+	}
+	for _, id := range []uint64{aggregateFlowID, fecRepairFlowID, fecSourceFlowID} {
+		if _, err := s.NewMediaStreamWithFlowID(id); !errors.Is(err, errReservedFlowID) {
+			t.Fatalf("got error %v for flow ID %v, want %v", err, id, errReservedFlowID)
+		}
+	}
+	for _, want := range []uint64{0, 2} {
+		id, err := s.newFlowID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Fatalf("got flow ID %v, want %v", id, want)
+		}
+	}
+}
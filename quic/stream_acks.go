@@ -0,0 +1,134 @@
+package quic
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
+)
+
+type byteRange struct {
+	start, end int64
+}
+
+type streamFrameRange struct {
+	id quic.StreamID
+	byteRange
+}
+
+type trackedStream struct {
+	size  int64
+	acked []byteRange
+	cb    func(time.Time)
+}
+
+// ack adds r to the acknowledged ranges and returns whether all bytes of
+// the stream are acknowledged.
+func (s *trackedStream) ack(r byteRange) bool {
+	merged := make([]byteRange, 0, len(s.acked)+1)
+	for _, a := range s.acked {
+		if a.end < r.start || r.end < a.start {
+			merged = append(merged, a)
+			continue
+		}
+		if a.start < r.start {
+			r.start = a.start
+		}
+		if a.end > r.end {
+			r.end = a.end
+		}
+	}
+	s.acked = append(merged, r)
+	return len(s.acked) == 1 && s.acked[0].start == 0 && s.acked[0].end >= s.size
+}
+
+// streamAckTracker calls a callback once all bytes written to a stream are
+// acknowledged. quic-go only reports acknowledgments of datagrams to the
+// application, so acknowledgments of stream data are derived from the stream
+// frames of the sent and acknowledged packets traced by the RTTTracer.
+type streamAckTracker struct {
+	lock    sync.Mutex
+	streams map[quic.StreamID]*trackedStream
+	packets map[int64][]streamFrameRange
+}
+
+func newStreamAckTracker() *streamAckTracker {
+	return &streamAckTracker{
+		streams: map[quic.StreamID]*trackedStream{},
+		packets: map[int64][]streamFrameRange{},
+	}
+}
+
+// track registers cb to be called with the time of the acknowledgment once
+// all size bytes of stream id are acknowledged. It has to be called before
+// writing to the stream.
+func (t *streamAckTracker) track(id quic.StreamID, size int, cb func(time.Time)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.streams[id] = &trackedStream{
+		size: int64(size),
+		cb:   cb,
+	}
+}
+
+// untrack forgets stream id, e.g., because it was reset before all bytes
+// were sent.
+func (t *streamAckTracker) untrack(id quic.StreamID) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.streams, id)
+}
+
+func (t *streamAckTracker) onPacketSent(pn int64, frames []logging.Frame) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, f := range frames {
+		sf, ok := f.(*logging.StreamFrame)
+		if !ok {
+			continue
+		}
+		if _, ok := t.streams[sf.StreamID]; !ok {
+			continue
+		}
+		t.packets[pn] = append(t.packets[pn], streamFrameRange{
+			id: sf.StreamID,
+			byteRange: byteRange{
+				start: int64(sf.Offset),
+				end:   int64(sf.Offset + sf.Length),
+			},
+		})
+	}
+}
+
+func (t *streamAckTracker) onPacketAcked(pn int64, now time.Time) {
+	t.lock.Lock()
+	ranges, ok := t.packets[pn]
+	delete(t.packets, pn)
+	var done []func(time.Time)
+	if ok {
+		for _, r := range ranges {
+			s, ok := t.streams[r.id]
+			if !ok {
+				continue
+			}
+			if s.ack(r.byteRange) {
+				done = append(done, s.cb)
+				delete(t.streams, r.id)
+			}
+		}
+	}
+	t.lock.Unlock()
+
+	for _, cb := range done {
+		cb(now)
+	}
+}
+
+// onPacketLost forgets the frames of a lost packet, the data is traced
+// again when it is retransmitted.
+func (t *streamAckTracker) onPacketLost(pn int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.packets, pn)
+}
@@ -31,6 +31,6 @@ func generateTLSConfig(keyLogWriter io.Writer) *tls.Config {
 	return &tls.Config{
 		KeyLogWriter: keyLogWriter,
 		Certificates: []tls.Certificate{tlsCert},
-		NextProtos:   []string{rtpOverQUICALPN},
+		NextProtos:   []string{rtpOverQUICALPN, moqALPN},
 	}
 }
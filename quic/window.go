@@ -0,0 +1,112 @@
+package quic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+)
+
+// maxBlockTime bounds the time a writer waits for the window to open to make
+// sure writers do not block forever if an ACK is never traced.
+const maxBlockTime = 50 * time.Millisecond
+
+// congestionController is a congestion controller running on top of QUIC
+// connections with disabled congestion control. quic-go does not allow
+// plugging in congestion controllers, so controllers observe sent,
+// acknowledged and lost 1-RTT packets through the connection tracer and
+// apply their decisions by blocking writers in wait.
+type congestionController interface {
+	wait(ctx context.Context, size int) error
+	reset()
+	onPacketSent(pn int64, size int, now time.Time)
+	onPacketAcked(pn int64, now time.Time)
+	onPacketLost(pn int64, now time.Time)
+	onRTTSample(rtt time.Duration, now time.Time)
+
+	// targetRate returns the rate in bits per second the application
+	// should send at or 0 if unknown.
+	targetRate() int
+
+	metrics() cc.Metrics
+}
+
+// window holds the congestion window and pacing state shared by all
+// congestionControllers. The lock protects the embedding controller as
+// well.
+type window struct {
+	lock  sync.Mutex
+	ready chan struct{}
+
+	inflight     int
+	waiting      int
+	cwnd         float64
+	pacingRate   float64
+	nextSendTime time.Time
+}
+
+func newWindow() window {
+	return window{
+		ready: make(chan struct{}),
+	}
+}
+
+// wait blocks until size bytes may be sent according to the congestion
+// window and the pacing rate.
+func (w *window) wait(ctx context.Context, size int) error {
+	deadline := time.Now().Add(maxBlockTime)
+	for {
+		w.lock.Lock()
+		now := time.Now()
+		if float64(w.inflight+size) <= w.cwnd || now.After(deadline) {
+			if d := w.nextSendTime.Sub(now); d > 0 {
+				w.lock.Unlock()
+				select {
+				case <-time.After(d):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				w.lock.Lock()
+				now = time.Now()
+			}
+			if w.nextSendTime.Before(now) {
+				w.nextSendTime = now
+			}
+			w.nextSendTime = w.nextSendTime.Add(time.Duration(float64(8*size) / w.pacingRate * float64(time.Second)))
+			w.lock.Unlock()
+			return nil
+		}
+		w.waiting++
+		ready := w.ready
+		w.lock.Unlock()
+
+		select {
+		case <-ready:
+		case <-time.After(time.Until(deadline)):
+		case <-ctx.Done():
+			w.lock.Lock()
+			w.waiting--
+			w.lock.Unlock()
+			return ctx.Err()
+		}
+		w.lock.Lock()
+		w.waiting--
+		w.lock.Unlock()
+	}
+}
+
+// windowMetrics returns the congestion window and the pacing rate. Must be
+// called with the lock held.
+func (w *window) windowMetrics() cc.Metrics {
+	return cc.Metrics{
+		PacingRate: int(w.pacingRate),
+		Cwnd:       int(w.cwnd),
+	}
+}
+
+// signal wakes up all waiting writers. Must be called with the lock held.
+func (w *window) signal() {
+	close(w.ready)
+	w.ready = make(chan struct{})
+}
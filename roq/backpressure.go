@@ -0,0 +1,60 @@
+package roq
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/events"
+)
+
+const (
+	// backpressureFactor is the factor the bitrate of the media is reduced
+	// by, at most once per backpressureInterval while the transport drops
+	// packets.
+	backpressureFactor   = 0.7
+	backpressureInterval = 200 * time.Millisecond
+	// backpressureHold is the time without backpressure after which the
+	// bitrate of the media follows the congestion controller again.
+	backpressureHold = 2 * time.Second
+)
+
+// runBackpressure throttles the media while the transport drops packets
+// which it couldn't send within the backpressure timeout. The dropped
+// packets skip their frames, the throttle keeps the encoder from producing
+// more than the transport can take until the congestion controller catches
+// up.
+func (s *Sender) runBackpressure(ctx context.Context) {
+	c, cancel := s.events.Subscribe(1024)
+	defer cancel()
+	ticker := time.NewTicker(backpressureInterval)
+	defer ticker.Stop()
+
+	var last, throttled time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-c:
+			if _, ok := e.(events.Backpressure); !ok {
+				continue
+			}
+			last = e.At()
+			if last.Sub(throttled) < backpressureInterval {
+				continue
+			}
+			if rate := s.rateCap.Throttle(backpressureFactor); rate > 0 {
+				if throttled.IsZero() {
+					log.Printf("transport backpressure, throttling the target bitrate to %v bit/s", rate)
+				}
+				throttled = last
+			}
+		case now := <-ticker.C:
+			if !throttled.IsZero() && now.Sub(last) >= backpressureHold {
+				s.rateCap.Release()
+				throttled = time.Time{}
+				log.Printf("transport backpressure resolved, releasing the target bitrate")
+			}
+		}
+	}
+}
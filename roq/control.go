@@ -0,0 +1,157 @@
+package roq
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Willi-42/rtp-over-quic/rtp"
+)
+
+var errInvalidCommand = errors.New("invalid control command")
+
+var errUnsupportedCommand = errors.New("unsupported control command")
+
+// controller executes control commands of a running sender or receiver.
+// Commands of components which are nil are not supported.
+type controller struct {
+	flowPause *rtp.FlowPause
+	layers    *rtp.LayerSubscription
+	rateCap   *rateCap
+	// switchCC, forceKeyframe and stats are the sender functions behind the
+	// commands of the same name.
+	switchCC      func(algorithm string) error
+	forceKeyframe func() error
+	stats         func() controlStats
+}
+
+// run executes one command per line read from r until r is closed or ctx is
+// done. Invalid commands are logged and ignored.
+func (c *controller) run(ctx context.Context, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := c.execute(scanner.Text()); err != nil {
+			log.Printf("control: %v", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("control: failed to read commands: %v", err)
+	}
+}
+
+// execute runs a command:
+//
+//	pause [ssrc]   stop sending the flow ssrc or all flows
+//	resume [ssrc]  continue sending the flow ssrc or all flows
+//	subscribe <ssrc> <spatial> <temporal> [max-height] [max-fps]
+//	               receive the layers of the flow ssrc up to the spatial and
+//	               temporal layer, 'all' selects all layers
+func (c *controller) execute(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch fields[0] {
+	case "subscribe":
+		if c.layers == nil {
+			return fmt.Errorf("%w: %v", errUnsupportedCommand, fields[0])
+		}
+		ssrc, layers, err := parseSubscription(fields[1:])
+		if err != nil {
+			return err
+		}
+		return c.layers.Subscribe(ssrc, layers)
+	case "pause", "resume":
+		ssrc, err := parseSSRCArg(fields[1:])
+		if err != nil {
+			return err
+		}
+		if fields[0] == "pause" {
+			return c.pause(ssrc)
+		}
+		return c.resume(ssrc)
+	}
+	return fmt.Errorf("%w: unknown command %v", errInvalidCommand, fields[0])
+}
+
+// parseSSRCArg parses an optional SSRC argument, 0 selects all flows.
+func parseSSRCArg(args []string) (uint32, error) {
+	switch len(args) {
+	case 0:
+		return 0, nil
+	case 1:
+		ssrc, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid SSRC %v: %v", errInvalidCommand, args[0], err)
+		}
+		return uint32(ssrc), nil
+	}
+	return 0, fmt.Errorf("%w: too many arguments: %v", errInvalidCommand, args)
+}
+
+// parseSubscription parses the arguments of a subscribe command.
+func parseSubscription(args []string) (uint32, rtp.Layers, error) {
+	var layers rtp.Layers
+	if len(args) < 3 || len(args) > 5 {
+		return 0, layers, fmt.Errorf("%w: subscribe requires 3 to 5 arguments, got %v", errInvalidCommand, len(args))
+	}
+	ssrc, err := parseSSRCArg(args[:1])
+	if err != nil {
+		return 0, layers, err
+	}
+	values := make([]uint64, len(args)-1)
+	limits := []int{8, 8, 16, 8}
+	for i, arg := range args[1:] {
+		if i < 2 && arg == "all" {
+			values[i] = rtp.AllLayers
+			continue
+		}
+		values[i], err = strconv.ParseUint(arg, 10, limits[i])
+		if err != nil {
+			return 0, layers, fmt.Errorf("%w: invalid subscribe argument %v: %v", errInvalidCommand, arg, err)
+		}
+	}
+	layers.Spatial = uint8(values[0])
+	layers.Temporal = uint8(values[1])
+	if len(values) > 2 {
+		layers.MaxHeight = uint16(values[2])
+	}
+	if len(values) > 3 {
+		layers.MaxFrameRate = uint8(values[3])
+	}
+	return ssrc, layers, nil
+}
+
+// pause stops sending the flow ssrc, 0 selects all flows.
+func (c *controller) pause(ssrc uint32) error {
+	if c.flowPause == nil {
+		return fmt.Errorf("%w: pause", errUnsupportedCommand)
+	}
+	return c.flowPause.Pause(ssrc)
+}
+
+// resume continues sending the flow ssrc, 0 selects all flows.
+func (c *controller) resume(ssrc uint32) error {
+	if c.flowPause == nil {
+		return fmt.Errorf("%w: resume", errUnsupportedCommand)
+	}
+	return c.flowPause.Resume(ssrc)
+}
+
+// setBitrateCap caps the target bitrate of the media, 0 removes the cap.
+func (c *controller) setBitrateCap(bitrate uint) error {
+	if c.rateCap == nil {
+		return fmt.Errorf("%w: set-bitrate-cap", errUnsupportedCommand)
+	}
+	c.rateCap.SetCap(bitrate)
+	log.Printf("control: capped target bitrate at %v bit/s", bitrate)
+	return nil
+}
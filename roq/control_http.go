@@ -0,0 +1,107 @@
+package roq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxControlRequestSize limits the size of requests to the HTTP control
+// service.
+const maxControlRequestSize = 4096
+
+// controlRequest is the body of the POST requests of the HTTP control
+// service. SSRC 0 selects all flows.
+type controlRequest struct {
+	SSRC    uint32 `json:"ssrc"`
+	Bitrate uint   `json:"bitrate"`
+}
+
+type controlReply struct {
+	Status string `json:"status"`
+}
+
+// serveHTTP serves the control service on addr until ctx is done. Requests
+// and replies are JSON:
+//
+//	POST /start-stream  {"ssrc": 0}         resume sending a flow
+//	POST /stop-stream   {"ssrc": 0}         pause sending a flow
+//	POST /set-bitrate   {"bitrate": 500000} cap the target bitrate, 0 removes the cap
+//	GET  /stats                             the result of 'dump-stats' of the control socket
+//
+// Commands of components the sender or receiver doesn't have are answered
+// with 501 Not Implemented.
+func (c *controller) serveHTTP(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/start-stream", c.controlHandler(func(req *controlRequest) error {
+		return c.resume(req.SSRC)
+	}))
+	mux.Handle("/stop-stream", c.controlHandler(func(req *controlRequest) error {
+		return c.pause(req.SSRC)
+	}))
+	mux.Handle("/set-bitrate", c.controlHandler(func(req *controlRequest) error {
+		return c.setBitrateCap(req.Bitrate)
+	}))
+	mux.HandleFunc("/stats", c.handleStats)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Printf("control: serving HTTP on %v", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// controlHandler decodes POSTed control requests and runs them with cmd.
+func (c *controller) controlHandler(cmd func(*controlRequest) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expected POST", http.StatusMethodNotAllowed)
+			return
+		}
+		req := &controlRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxControlRequestSize)).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := cmd(req)
+		switch {
+		case errors.Is(err, errUnsupportedCommand):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeControlReply(w, &controlReply{Status: "ok"})
+	})
+}
+
+func (c *controller) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "expected GET", http.StatusMethodNotAllowed)
+		return
+	}
+	if c.stats == nil {
+		http.Error(w, errUnsupportedCommand.Error(), http.StatusNotImplemented)
+		return
+	}
+	writeControlReply(w, c.stats())
+}
+
+func writeControlReply(w http.ResponseWriter, reply interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		log.Printf("control: failed to write reply: %v", err)
+	}
+}
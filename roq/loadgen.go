@@ -0,0 +1,150 @@
+package roq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/options"
+)
+
+var (
+	errInvalidLoadGen = errors.New("invalid load generator configuration")
+	errLoadFailed     = errors.New("load generation failed")
+)
+
+// LoadGenConfig configures a LoadGenerator. SenderConfig is the configuration
+// of each connection, but the media source is always 'syncodec' and the
+// outputs which can exist only once per process, i.e., metrics, stats,
+// dumps, control interfaces and the dashboard, are disabled.
+type LoadGenConfig struct {
+	SenderConfig
+
+	// Connections is the number of QUIC connections opened to the receiver.
+	Connections int
+	// Ramp is the delay between opening two connections, 0 opens all
+	// connections at once.
+	Ramp time.Duration
+}
+
+// Validate returns the first problem of the configuration reported by
+// Problems.
+func (c *LoadGenConfig) Validate() error {
+	if errs := c.Problems(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Problems returns all problems of the configuration which can be found
+// without opening sockets.
+func (c *LoadGenConfig) Problems() []error {
+	sc := c.connection(0)
+	errs := sc.Problems()
+	if !options.IsQUIC(c.Transport) {
+		errs = append(errs, fmt.Errorf("%w: load generation requires a QUIC transport, got %v", errInvalidLoadGen, c.Transport))
+	}
+	if c.Connections < 1 {
+		errs = append(errs, fmt.Errorf("%w: at least one connection required, got %v", errInvalidLoadGen, c.Connections))
+	}
+	if c.Ramp < 0 {
+		errs = append(errs, fmt.Errorf("%w: negative ramp %v", errInvalidLoadGen, c.Ramp))
+	}
+	return errs
+}
+
+// connection returns the sender configuration of the i-th connection. The
+// SSRCs of the connections follow each other so that every stream has its
+// own SSRC at the receiver.
+func (c *LoadGenConfig) connection(i int) SenderConfig {
+	sc := c.SenderConfig
+	sc.Source = "syncodec"
+	sc.StreamSources = nil
+	sc.SSRC += uint32(i * sc.Streams)
+	sc.Duration = 0
+
+	sc.KeyLogFile = ""
+	sc.RTPDumpFile = ""
+	sc.RTCPDumpFile = ""
+	sc.PcapFile = ""
+	sc.MetricsAddr = ""
+	sc.StatsFile = ""
+	sc.InfluxURL = ""
+	sc.Dashboard = false
+	sc.Control = nil
+	sc.ControlAddr = ""
+	sc.Hooks = nil
+	sc.CCDump = ""
+	sc.MetricsLog = ""
+	sc.BufferHealthLog = ""
+	sc.SyncodecStatsLog = ""
+	sc.PathCacheFile = ""
+	sc.BWEEvalLog = ""
+	sc.ControlSocket = ""
+	sc.SignalingURL = ""
+	return sc
+}
+
+// LoadGenerator stress-tests a receiver with many senders of synthetic
+// media, each on its own QUIC connection with its own congestion control.
+type LoadGenerator struct {
+	config LoadGenConfig
+}
+
+// NewLoadGenerator creates a LoadGenerator for c.
+func NewLoadGenerator(c LoadGenConfig) (*LoadGenerator, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &LoadGenerator{config: c}, nil
+}
+
+// Start opens the connections and sends until ctx is done or Duration
+// elapsed. Connections which fail are logged and not restarted, Start
+// returns an error if all of them failed.
+func (l *LoadGenerator) Start(ctx context.Context) error {
+	ctx, cancel := l.config.withDuration(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	failed := 0
+	started := 0
+	start := time.Now()
+	for i := 0; i < l.config.Connections; i++ {
+		if i > 0 && l.config.Ramp > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(l.config.Ramp):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		s, err := NewSender(SetSenderConfig(l.config.connection(i)))
+		if err != nil {
+			return err
+		}
+		started++
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.Start(ctx); err != nil {
+				log.Printf("loadgen: connection %v failed: %v", i, err)
+				lock.Lock()
+				failed++
+				lock.Unlock()
+			}
+		}(i)
+	}
+	log.Printf("loadgen: opened %v connections to %v in %v", started, l.config.Addr, time.Since(start).Round(time.Millisecond))
+	wg.Wait()
+	log.Printf("loadgen: %v of %v connections failed after %v", failed, started, time.Since(start).Round(time.Millisecond))
+	if started > 0 && failed == started {
+		return fmt.Errorf("%w: all %v connections failed", errLoadFailed, started)
+	}
+	return nil
+}
@@ -0,0 +1,911 @@
+package roq
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/dashboard"
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/Willi-42/rtp-over-quic/gateway"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/media"
+	"github.com/Willi-42/rtp-over-quic/metrics"
+	"github.com/Willi-42/rtp-over-quic/options"
+	"github.com/Willi-42/rtp-over-quic/quic"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/Willi-42/rtp-over-quic/signaling"
+	"github.com/Willi-42/rtp-over-quic/tcp"
+	"github.com/Willi-42/rtp-over-quic/tracing"
+	"github.com/Willi-42/rtp-over-quic/udp"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	pionrtp "github.com/pion/rtp"
+)
+
+type RTCPFeedback int
+
+const (
+	RTCP_NONE RTCPFeedback = iota
+	RTCP_RFC8888
+	RTCP_RFC8888_PION
+	RTCP_TWCC
+)
+
+type ReceiverOption func(*ReceiverConfig) error
+
+// SetReceiverConfig replaces the whole configuration by c.
+func SetReceiverConfig(c ReceiverConfig) ReceiverOption {
+	return func(rc *ReceiverConfig) error {
+		*rc = c
+		return nil
+	}
+}
+
+// SetReceiverTransport sets the transport protocol, see Config.Transport.
+func SetReceiverTransport(transport string) ReceiverOption {
+	return func(rc *ReceiverConfig) error {
+		rc.Transport = transport
+		return nil
+	}
+}
+
+func SetListenAddress(addr string) ReceiverOption {
+	return func(rc *ReceiverConfig) error {
+		rc.Addr = addr
+		return nil
+	}
+}
+
+func SetSink(sink string) ReceiverOption {
+	return func(rc *ReceiverConfig) error {
+		rc.Sink = sink
+		return nil
+	}
+}
+
+func SetRTCPFeedback(feedback RTCPFeedback) ReceiverOption {
+	return func(rc *ReceiverConfig) error {
+		rc.RTCPFeedback = feedback
+		return nil
+	}
+}
+
+// ReceiverConfig configures a Receiver. NewReceiver starts from the defaults
+// of the rtp-over-quic receive command.
+type ReceiverConfig struct {
+	Config
+
+	// Sink is the media sink: 'autovideosink', a file or 'none' to discard
+	// the media, e.g., if it is only forwarded to WebRTC viewers. See
+	// media.FilePrefix for the formats of files. 'syncodec' discards the
+	// media of syncodec sources after logging the received frames to
+	// SyncodecStatsLog, if set.
+	Sink             string
+	SyncodecStatsLog string
+	// QualityReference is a Y4M file, e.g., the file sent by the sender,
+	// the decoded video is compared with to log per-second PSNR and SSIM
+	// scores to QualityLog, see media.QualityScorer. Disabled if empty.
+	QualityReference string
+	QualityLog       string
+	// AudioSink plays or records the Opus stream with AudioPayloadType sent
+	// alongside the video, e.g., 'autoaudiosink' or 'file:<path>.ogg'. The
+	// audio is discarded if empty.
+	AudioSink string
+	// RTCPFeedback is the congestion control feedback sent to the sender.
+	RTCPFeedback RTCPFeedback
+	// FeedbackSuppression coalesces RTCP feedback while the feedback path
+	// is congested, flushing it in intervals starting at this duration. 0
+	// disables suppression.
+	FeedbackSuppression time.Duration
+
+	// CodecMap maps payload types to codecs with Codec 'auto', e.g.,
+	// '96=h264,97=vp8'. DetectCodec detects the codec of other payload
+	// types from the payload.
+	CodecMap    string
+	DetectCodec bool
+
+	// JitterBufferDelay is the maximum time packets are held back for
+	// reordering, 0 disables the jitter buffer.
+	JitterBufferDelay time.Duration
+	// JitterBufferMaxDelay bounds the delay of the adaptive jitter buffer.
+	JitterBufferMaxDelay time.Duration
+	// JitterBufferAdaptive adapts the delay to the interarrival jitter.
+	JitterBufferAdaptive bool
+	// JitterBufferDrift schedules the playout by RTP timestamps at the
+	// estimated sender clock rate, logged to ClockDriftLog.
+	JitterBufferDrift bool
+	ClockDriftLog     string
+
+	// MaxConnections limits the number of senders connected at the same
+	// time to a QUIC receiver, 0 means unlimited.
+	MaxConnections int
+	// SignalingAddr is the address offers of senders are answered on at
+	// '/offer'. With Codec 'auto', the offered codecs are added to the
+	// codec map. Disabled if empty.
+	SignalingAddr string
+	// WebRTCAddr is the address WebRTC clients publish media to by WHIP at
+	// '/whip' and play the received media by WHEP at '/whep'. Publishers
+	// are handled like senders. Disabled if empty.
+	WebRTCAddr string
+	// ICEServers are the STUN and TURN URLs passed to WebRTC clients.
+	ICEServers []string
+}
+
+// Validate returns the first problem of the configuration reported by
+// Problems.
+func (c *ReceiverConfig) Validate() error {
+	if errs := c.Problems(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Problems returns all problems of the configuration which can be found
+// without opening files or sockets.
+func (c *ReceiverConfig) Problems() []error {
+	errs := c.Config.validate()
+	if err := c.transportOptions().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := media.ParseCodecMap(c.CodecMap); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("%w: negative connection limit %v", errInvalidConnectionLimit, c.MaxConnections))
+	}
+	if err := c.validateQuality(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// validateQuality checks that the sink decodes the video, which quality
+// scoring requires.
+func (c *ReceiverConfig) validateQuality() error {
+	if len(c.QualityReference) == 0 {
+		return nil
+	}
+	if c.MediaBackend != media.BackendGstreamer {
+		return fmt.Errorf("%w: requires the %v backend", errInvalidQualityScoring, media.BackendGstreamer)
+	}
+	if c.Codec == media.Opus {
+		return fmt.Errorf("%w: the codec %v is not a video codec", errInvalidQualityScoring, c.Codec)
+	}
+	if c.Sink == "none" || c.Sink == "syncodec" {
+		return fmt.Errorf("%w: the sink %v doesn't decode the video", errInvalidQualityScoring, c.Sink)
+	}
+	return nil
+}
+
+type handler interface {
+	WriteRTCP(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error)
+	SetRTPReader(r interceptor.RTPReader)
+}
+
+// closeNotifier is implemented by handlers which report when their
+// connection closed.
+type closeNotifier interface {
+	OnClose(f func())
+}
+
+// closer is implemented by handlers which can close their connection.
+type closer interface {
+	Close(reason string) error
+}
+
+type MediaSink interface {
+	io.Writer
+	Play() error
+	Stop() error
+}
+
+// Receiver receives media from senders. The control command read from
+// Config.Control is 'subscribe <ssrc> <spatial> <temporal> [max-height]
+// [max-fps]', which selects the layers the sender sends of a flow.
+type Receiver struct {
+	config ReceiverConfig
+
+	mediaOptions []media.ConfigOption
+	srtpOptions  []rtp.Option
+	codecsLock   sync.Mutex
+	codecs       map[uint8]string
+	traffic      *rtp.TrafficCounter
+	layers       *rtp.LayerSubscription
+	pcap         *rtp.PcapDump
+	frameStats   *media.FrameStats
+	quality      *media.QualityScorer
+	dashboard    *dashboard.Dashboard
+	events       *events.Bus
+	hub          *gateway.Hub
+}
+
+// NewReceiver creates a receiver from the defaults modified by opts. It
+// returns an error if the configuration is invalid.
+func NewReceiver(opts ...ReceiverOption) (*Receiver, error) {
+	c := ReceiverConfig{
+		Config:               defaultConfig(),
+		Sink:                 "autovideosink",
+		RTCPFeedback:         RTCP_NONE,
+		JitterBufferMaxDelay: 500 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	codecs, err := media.ParseCodecMap(c.CodecMap)
+	if err != nil {
+		return nil, err
+	}
+	return &Receiver{
+		config: c,
+		mediaOptions: []media.ConfigOption{
+			media.Codec(c.Codec),
+			media.PayloadType(uint8(c.PayloadType)),
+			media.Backend(c.MediaBackend),
+		},
+		codecs: codecs,
+		events: events.NewBus(),
+	}, nil
+}
+
+// Events returns the bus publishing the stream resets and closed
+// connections of the receiver.
+func (r *Receiver) Events() *events.Bus {
+	return r.events
+}
+
+// setupInterceptor creates the interceptors shared by the streams of all
+// senders: the packet dump, the traffic counter and the layer subscription.
+func (r *Receiver) setupInterceptor() error {
+	srtp, err := r.config.srtpOptions()
+	if err != nil {
+		return err
+	}
+	r.srtpOptions = srtp
+	_, pcapDump, err := r.config.pcapOptions(false)
+	if err != nil {
+		return err
+	}
+	r.pcap = pcapDump
+	if r.config.Sink == "syncodec" && len(r.config.SyncodecStatsLog) > 0 {
+		r.frameStats, err = media.NewFrameStats(r.config.SyncodecStatsLog)
+		if err != nil {
+			return err
+		}
+	}
+	if len(r.config.QualityReference) > 0 {
+		r.quality, err = media.NewQualityScorer(r.config.QualityReference, r.config.QualityLog)
+		if err != nil {
+			return err
+		}
+		r.mediaOptions = append(r.mediaOptions, media.Quality(r.quality))
+	}
+	// the traffic is counted for the summary on shutdown, the metrics
+	// endpoint, the stats, the line protocol export and the dashboard
+	r.traffic = rtp.NewTrafficCounter()
+	r.layers = rtp.NewLayerSubscription()
+	return nil
+}
+
+// rtpOptions returns the options of the interceptor chain of a new sender.
+// Apart from the interceptors created by setupInterceptor, each sender gets
+// interceptors of its own.
+func (r *Receiver) rtpOptions() []rtp.Option {
+	rtpOptions := append([]rtp.Option{}, r.srtpOptions...)
+	if r.pcap != nil {
+		rtpOptions = append(rtpOptions, rtp.RegisterPcapDump(r.pcap))
+	}
+	rtpOptions = append(rtpOptions, rtp.RegisterTrafficCounter(r.traffic))
+	rtpOptions = append(rtpOptions, rtp.RegisterReceiverPacketLog(r.config.RTPDumpFile, r.config.RTCPDumpFile, r.config.DumpFormat))
+	// logs pauses announced by the sender, the media sink keeps showing
+	// the last frame meanwhile
+	rtpOptions = append(rtpOptions, rtp.RegisterFlowPause(rtp.NewFlowPause()))
+	rtpOptions = append(rtpOptions, rtp.RegisterLayerSubscription(r.layers))
+	if r.config.FeedbackSuppression > 0 {
+		rtpOptions = append(rtpOptions, rtp.RegisterFeedbackThrottle(r.config.FeedbackSuppression))
+	}
+	switch r.config.RTCPFeedback {
+	case RTCP_RFC8888:
+		rtpOptions = append(rtpOptions, rtp.RegisterRFC8888())
+	case RTCP_RFC8888_PION:
+		rtpOptions = append(rtpOptions, rtp.RegisterRFC8888Pion())
+	case RTCP_TWCC:
+		rtpOptions = append(rtpOptions, rtp.RegisterTWCC())
+	}
+	return rtpOptions
+}
+
+// Start listens for senders and receives their media until ctx is done.
+func (r *Receiver) Start(ctx context.Context) error {
+	ctx, cancel := r.config.withDuration(ctx)
+	defer cancel()
+	t := r.config.transportOptions()
+	t.Events = r.events
+	t.MaxConnections = r.config.MaxConnections
+	if err := r.setupInterceptor(); err != nil {
+		return err
+	}
+	if r.pcap != nil {
+		defer r.pcap.CloseFile()
+	}
+	if r.frameStats != nil {
+		defer r.frameStats.Close()
+	}
+	if r.quality != nil {
+		defer r.quality.Close()
+	}
+	if len(r.config.MetricsAddr) > 0 {
+		go r.config.serveMetrics(ctx, metrics.NewExporter(r.traffic))
+	}
+	if len(r.config.StatsFile) > 0 {
+		go r.config.writeStats(ctx, nil, r.traffic, rtp.Received)
+	}
+	if len(r.config.InfluxURL) > 0 {
+		go r.config.pushInflux(ctx, "receiver", r.config.Transport, "", nil, r.traffic)
+	}
+	if r.config.LatencyInterval > 0 {
+		go logging.LogLatencyPercentiles(ctx, r.config.LatencyInterval)
+	}
+	ctrl := &controller{
+		layers: r.layers,
+		stats: func() controlStats {
+			return controlStats{
+				Time:  time.Now(),
+				Flows: newControlFlowStats(r.traffic.Flows()),
+			}
+		},
+	}
+	if r.config.Control != nil {
+		go ctrl.run(ctx, r.config.Control)
+	}
+	if len(r.config.SignalingAddr) > 0 {
+		go func() {
+			if err := signaling.Serve(ctx, r.config.SignalingAddr, r.answer); err != nil {
+				log.Printf("failed to serve signaling: %v", err)
+			}
+		}()
+	}
+	if len(r.config.ControlAddr) > 0 {
+		go func() {
+			if err := ctrl.serveHTTP(ctx, r.config.ControlAddr); err != nil {
+				log.Printf("failed to serve HTTP control service: %v", err)
+			}
+		}()
+	}
+	if len(r.config.WebRTCAddr) > 0 {
+		if err := r.serveWebRTC(ctx); err != nil {
+			return err
+		}
+	}
+	if r.config.Dashboard {
+		r.dashboard = dashboard.New(os.Stdout, fmt.Sprintf("rtp-over-quic receiver (%v on %v)", r.config.Transport, r.config.Addr), r.traffic)
+		go runDashboard(ctx, r.dashboard)
+	}
+
+	start := time.Now()
+	err := r.serve(ctx, t)
+	logSummary(r.traffic.Flows(), rtp.Received, time.Since(start))
+	return err
+}
+
+// serve runs the server of the transport until ctx is done. The servers
+// close the connections of all senders before they return.
+func (r *Receiver) serve(ctx context.Context, t *options.Transport) error {
+	switch r.config.Transport {
+	case "quic", "quic-dgram", "quic-stream", "quic-prio", "moq":
+		return r.startQUIC(ctx, t)
+	case "udp":
+		return r.startUDP(ctx, t)
+	case "tcp":
+		return r.startTCP(ctx, t)
+	case options.Auto:
+		return r.startAuto(ctx, t)
+	}
+	return fmt.Errorf("%w: %v", errInvalidTransport, r.config.Transport)
+}
+
+// startAuto listens on QUIC and TCP, so that senders using Transport 'auto'
+// can connect using any of the transports they try. Transports which don't
+// support the settings are skipped. It returns when one of the servers
+// stops.
+func (r *Receiver) startAuto(ctx context.Context, t *options.Transport) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	servers := []struct {
+		transport string
+		start     func(context.Context, *options.Transport) error
+	}{
+		{"quic", r.startQUIC},
+		{"tcp", r.startTCP},
+	}
+	errs := make(chan error, len(servers))
+	started := 0
+	for _, s := range servers {
+		st := t.WithTransport(s.transport)
+		if err := st.Validate(); err != nil {
+			log.Printf("not listening on %v: %v", s.transport, err)
+			continue
+		}
+		log.Printf("listening on %v", s.transport)
+		started++
+		go func(start func(context.Context, *options.Transport) error) {
+			errs <- start(ctx, st)
+		}(s.start)
+	}
+	if started == 0 {
+		return fmt.Errorf("%w: no transport of %v supports the settings", errInvalidTransport, options.Auto)
+	}
+	return <-errs
+}
+
+func (r *Receiver) startTCP(ctx context.Context, t *options.Transport) error {
+	opts, err := t.TCPServerOptions()
+	if err != nil {
+		return err
+	}
+	server, err := tcp.NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	server.OnNewHandler(func(h *tcp.Handler) error {
+		return r.handle(h)
+	})
+	return server.Start(ctx)
+}
+
+func (r *Receiver) startQUIC(ctx context.Context, t *options.Transport) error {
+	opts, err := t.QUICServerOptions()
+	if err != nil {
+		return err
+	}
+	server, err := quic.NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	server.OnNewHandler(func(h *quic.Handler) error {
+		return r.handle(h)
+	})
+	return server.Start(ctx)
+}
+
+func (r *Receiver) startUDP(ctx context.Context, t *options.Transport) error {
+	opts, err := t.UDPServerOptions()
+	if err != nil {
+		return err
+	}
+	server, err := udp.NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	server.OnNewHandler(func(h *udp.Handler) error {
+		return r.handle(h)
+	})
+	return server.Start(ctx)
+}
+
+// serveWebRTC serves WHIP publishers, which are handled like senders, and
+// WHEP viewers, which get the packets passed to the media sinks.
+func (r *Receiver) serveWebRTC(ctx context.Context) error {
+	r.hub = gateway.NewHub()
+	g, err := gateway.NewServer(gateway.Config{
+		Codec:       r.config.Codec,
+		PayloadType: uint8(r.config.PayloadType),
+		ICEServers:  r.config.ICEServers,
+		Publish: func(s *gateway.Session) error {
+			return r.handle(s)
+		},
+		Hub: r.hub,
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := g.Serve(ctx, r.config.WebRTCAddr); err != nil {
+			log.Printf("failed to serve WHIP and WHEP: %v", err)
+		}
+	}()
+	return nil
+}
+
+// handle sets up the media pipeline of a new sender and passes the packets
+// read by h to it. If h reports when its connection closed, the pipeline is
+// torn down then. When the sender says goodbye by RTCP BYE, the connection is
+// closed.
+func (r *Receiver) handle(h handler) error {
+	c, err := r.addStream(interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+		return h.WriteRTCP(pkts, attributes)
+	}))
+	if err != nil {
+		return err
+	}
+	if r.hub != nil {
+		// Keyframe requests of WebRTC viewers bypass the interceptors.
+		remove := r.hub.AddUpstream(interceptor.RTCPWriterFunc(h.WriteRTCP))
+		stop := c.stop
+		c.stop = func() {
+			remove()
+			stop()
+		}
+	}
+	if n, ok := h.(closeNotifier); ok {
+		n.OnClose(c.close)
+	}
+
+	h.SetRTPReader(interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		// TODO: Demultiplex flow ID or otherwise use attributes?
+		if rtp.IsRTCP(b) {
+			byes := rtp.Goodbyes(b)
+			for _, bye := range byes {
+				log.Printf("sender of SSRCs %v left: %v", bye.Sources, bye.Reason)
+			}
+			n, a, err := c.rtcpReader.Read(b, a)
+			if len(byes) > 0 {
+				if cl, ok := h.(closer); ok {
+					if err := cl.Close("BYE received"); err != nil {
+						log.Printf("failed to close connection after BYE: %v", err)
+					}
+				}
+			}
+			return n, a, err
+		}
+		span := tracePacket(a)
+		defer span.End()
+		return c.rtpReader.Read(b, a)
+	}))
+	return nil
+}
+
+// connection is the pipeline of one sender: its interceptor chain, jitter
+// buffer and media sink.
+type connection struct {
+	rtpReader   interceptor.RTPReader
+	rtcpReader  interceptor.RTCPReader
+	interceptor interceptor.Interceptor
+	// stop closes the jitter buffer and stops the media sink.
+	stop func()
+	once sync.Once
+}
+
+// close tears down the pipeline. It may be called more than once.
+func (c *connection) close() {
+	c.once.Do(func() {
+		if err := c.interceptor.Close(); err != nil {
+			log.Printf("failed to close interceptors: %v", err)
+		}
+		c.stop()
+	})
+}
+
+// tracePacket starts the span of a received packet at its arrival, if the
+// transport knows it, and records the time it waited to be processed. The
+// span is attached to a as TRACE attribute.
+func tracePacket(a interceptor.Attributes) *tracing.Span {
+	if !tracing.Enabled() {
+		return nil
+	}
+	now := time.Now()
+	arrival, ok := a.Get("arrival").(time.Time)
+	if !ok {
+		arrival = now
+	}
+	span := tracing.StartAt("receive", arrival)
+	span.StartAt("demux", arrival).EndAt(now)
+	a.Set(rtp.TRACE, span)
+	return span
+}
+
+// addStream sets up the pipeline of a sender. If the pipeline can't be set
+// up, the parts created so far are stopped again.
+func (r *Receiver) addStream(rtcpWriter interceptor.RTCPWriter) (*connection, error) {
+	// setup media pipeline
+	var ms MediaSink
+	if r.config.Sink == "none" {
+		ms = discardSink{}
+	} else if r.config.Sink == "syncodec" {
+		s, err := media.NewSyncodecSink(r.frameStats)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create media sink: %w", err)
+		}
+		ms = s
+	} else if r.config.Codec == "auto" {
+		ms = media.NewAutoCodecSink(r.config.Sink, r.codecMap(), r.config.DetectCodec, "h264", r.mediaOptions...)
+	} else if r.config.Sink == "autovideosink" && r.config.PipelineRestarts > 0 {
+		// only playback is restarted, a restart would truncate recordings
+		s, err := newSupervisedSink("media sink", r.config.PipelineRestarts, r.events, func() (MediaSink, error) {
+			return media.NewSink(r.config.Sink, r.mediaOptions...)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create media sink: %w", err)
+		}
+		ms = s
+	} else {
+		s, err := media.NewSink(r.config.Sink, r.mediaOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create media sink: %w", err)
+		}
+		ms = s
+	}
+	var audio media.Sink
+	if len(r.config.AudioSink) > 0 && r.config.Codec != media.Opus {
+		var err error
+		audio, err = media.NewSink(r.config.AudioSink, media.Codec(media.Opus), media.PayloadType(uint8(r.config.AudioPayloadType)), media.Backend(r.config.MediaBackend))
+		if err != nil {
+			if err := ms.Stop(); err != nil {
+				log.Printf("failed to stop media sink: %v", err)
+			}
+			return nil, fmt.Errorf("failed to create audio sink: %w", err)
+		}
+	}
+	stopSink := func() {
+		if err := ms.Stop(); err != nil {
+			log.Printf("failed to stop media sink: %v", err)
+		}
+		if audio != nil {
+			if err := audio.Stop(); err != nil {
+				log.Printf("failed to stop audio sink: %v", err)
+			}
+		}
+	}
+	var playWriter io.Writer = ms
+	if r.config.RecordLatency {
+		playWriter = &glassToGlassRecorder{writer: ms}
+	}
+	// with audio, the stream arriving earlier is delayed to play in sync
+	// with the other
+	videoWriter := playWriter
+	var audioWriter io.Writer = audio
+	var lipSync *rtp.LipSync
+	var delayLines []*media.DelayLine
+	if audio != nil {
+		lipSync = rtp.NewLipSync(r.config.clockRate)
+		videoDelay := media.NewDelayLine(playWriter, lipSync.Delay)
+		audioDelay := media.NewDelayLine(audio, lipSync.Delay)
+		delayLines = append(delayLines, videoDelay, audioDelay)
+		videoWriter = videoDelay
+		audioWriter = audioDelay
+	}
+	closeDelayLines := func() {
+		for _, l := range delayLines {
+			if err := l.Close(); err != nil {
+				log.Printf("failed to close delay line: %v", err)
+			}
+		}
+	}
+	sinkWriter := videoWriter
+	var jb *media.JitterBuffer
+	if r.config.JitterBufferDelay > 0 {
+		var err error
+		jb, err = media.NewJitterBuffer(
+			videoWriter,
+			media.JitterBufferDelay(r.config.JitterBufferDelay),
+			media.JitterBufferClockRate(media.CodecClockRate(r.config.Codec)),
+			media.JitterBufferMaxDelay(r.config.JitterBufferMaxDelay),
+			media.JitterBufferAdaptive(r.config.JitterBufferAdaptive),
+			media.JitterBufferClockDrift(r.config.JitterBufferDrift, r.config.ClockDriftLog),
+		)
+		if err != nil {
+			closeDelayLines()
+			stopSink()
+			return nil, fmt.Errorf("failed to create jitter buffer: %w", err)
+		}
+		sinkWriter = jb
+	}
+	stop := func() {
+		if jb != nil {
+			if err := jb.Close(); err != nil {
+				log.Printf("failed to close jitter buffer: %v", err)
+			}
+		}
+		closeDelayLines()
+		stopSink()
+	}
+	// build interceptor
+	rtpOptions := r.rtpOptions()
+	if lipSync != nil {
+		rtpOptions = append(rtpOptions, rtp.RegisterLipSync(lipSync))
+	}
+	ir, err := rtp.New(rtpOptions...)
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("failed to create interceptors: %w", err)
+	}
+	i, err := ir.Build("")
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("failed to build interceptors: %w", err)
+	}
+	if jb != nil && r.dashboard != nil {
+		r.dashboard.AddQueue(jb)
+	}
+
+	go func() {
+		if err := ms.Play(); err != nil {
+			log.Printf("media sink failed to play: %v", err)
+		}
+	}()
+	if audio != nil {
+		go func() {
+			if err := audio.Play(); err != nil {
+				log.Printf("audio sink failed to play: %v", err)
+			}
+		}()
+	}
+
+	i.BindRTCPWriter(rtcpWriter)
+	rtcpReader := i.BindRTCPReader(interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		return len(b), a, nil
+	}))
+
+	red := rtp.NewREDDecoder(uint8(r.config.REDPayloadType))
+	frameCompletion := rtp.NewFrameCompletion()
+	filter := &sinkFilter{}
+
+	reader := i.BindRemoteStream(&interceptor.StreamInfo{
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: rtp.TransportCCURI, ID: 1}},
+		RTCPFeedback:        []interceptor.RTCPFeedback{{Type: "ack", Parameter: "ccfb"}},
+	}, interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		span := rtp.PacketSpan(a)
+		span.StartAt("interceptor", span.StartTime()).End()
+		sinkSpan := span.Start("sink")
+		defer sinkSpan.End()
+
+		// the audio sent alongside the video has a sink of its own
+		if r.config.Codec != media.Opus && len(b) > 1 && uint(b[1]&0x7f) == r.config.AudioPayloadType {
+			if audio != nil {
+				if _, err := audioWriter.Write(b); err != nil {
+					return 0, nil, err
+				}
+			}
+			return len(b), a, nil
+		}
+		if !filter.pass(b) {
+			return len(b), a, nil
+		}
+		pkts, err := red.Decode(b)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, pkt := range pkts {
+			if r.config.RecordLatency {
+				recordFrameCompletion(frameCompletion, pkt)
+			}
+			if _, err := sinkWriter.Write(pkt); err != nil {
+				return 0, nil, err
+			}
+			if r.hub != nil {
+				if _, err := r.hub.Write(pkt); err != nil {
+					log.Printf("failed to forward packet to WebRTC viewers: %v", err)
+				}
+			}
+		}
+
+		return len(b), a, nil
+	}))
+	return &connection{
+		rtpReader:   reader,
+		rtcpReader:  rtcpReader,
+		interceptor: i,
+		stop:        stop,
+	}, nil
+}
+
+// discardSink is the media sink 'none'.
+type discardSink struct{}
+
+func (discardSink) Write(b []byte) (int, error) { return len(b), nil }
+func (discardSink) Play() error                 { return nil }
+func (discardSink) Stop() error                 { return nil }
+
+// sinkFilter passes the packets of the first SSRC received on a connection
+// to the media sink, which plays one stream only. The packets of further
+// streams, e.g., sent using SenderConfig.Streams, are only passed through the
+// interceptors, which count them and generate feedback.
+type sinkFilter struct {
+	lock    sync.Mutex
+	ssrc    uint32
+	set     bool
+	skipped map[uint32]bool
+}
+
+func (f *sinkFilter) pass(pkt []byte) bool {
+	if len(pkt) < 12 {
+		return true
+	}
+	ssrc := binary.BigEndian.Uint32(pkt[8:12])
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.set {
+		f.ssrc = ssrc
+		f.set = true
+	}
+	if ssrc == f.ssrc {
+		return true
+	}
+	if !f.skipped[ssrc] {
+		if f.skipped == nil {
+			f.skipped = map[uint32]bool{}
+		}
+		f.skipped[ssrc] = true
+		log.Printf("not playing stream with SSRC %v, the media sink plays SSRC %v", ssrc, f.ssrc)
+	}
+	return false
+}
+
+func recordFrameCompletion(c *rtp.FrameCompletion, pkt []byte) {
+	var header pionrtp.Header
+	if _, err := header.Unmarshal(pkt); err != nil {
+		return
+	}
+	logging.RecordSize(logging.SizePacket, len(pkt))
+	if d, size, ok := c.OnPacket(time.Now(), &header, len(pkt)); ok {
+		logging.RecordLatency(logging.LatencyFrameCompletion, d)
+		logging.RecordSize(logging.SizeFrame, size)
+	}
+}
+
+// glassToGlassRecorder records the latency from the capture of a frame,
+// stamped by a sender with SenderConfig.AbsCaptureTime, to the handover of
+// its last packet to the media sink, after the jitter buffer and lip sync.
+// Decoding and rendering in the sink are not included.
+type glassToGlassRecorder struct {
+	writer io.Writer
+}
+
+func (g *glassToGlassRecorder) Write(pkt []byte) (int, error) {
+	n, err := g.writer.Write(pkt)
+	if err != nil {
+		return n, err
+	}
+	var header pionrtp.Header
+	if _, err := header.Unmarshal(pkt); err != nil || !header.Marker {
+		return n, nil
+	}
+	if capture, ok := rtp.CaptureTime(&header); ok {
+		logging.RecordLatency(logging.LatencyGlassToGlass, time.Since(capture))
+	}
+	return n, nil
+}
+
+func (f RTCPFeedback) String() string {
+	switch f {
+	case RTCP_NONE:
+		return "none"
+	case RTCP_RFC8888:
+		return "rfc8888"
+	case RTCP_RFC8888_PION:
+		return "rfc8888-pion"
+	case RTCP_TWCC:
+		return "twcc"
+	default:
+		log.Printf("WARNING: unknown RTCP Congestion Control Feedback type: %v, using default ('none')\n", int(f))
+		return "none"
+	}
+}
+
+// ParseRTCPFeedback returns the feedback named choice, 'none', 'rfc8888',
+// 'rfc8888-pion' or 'twcc'. Unknown names select RTCP_NONE.
+func ParseRTCPFeedback(choice string) RTCPFeedback {
+	switch choice {
+	case "none":
+		return RTCP_NONE
+	case "rfc8888":
+		return RTCP_RFC8888
+	case "rfc8888-pion":
+		return RTCP_RFC8888_PION
+	case "twcc":
+		return RTCP_TWCC
+	default:
+		log.Printf("WARNING: unknown RTCP Congestion Control Feedback type: %v, using default ('none')\n", choice)
+		return RTCP_NONE
+	}
+}
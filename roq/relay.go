@@ -0,0 +1,617 @@
+package roq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/media"
+	"github.com/Willi-42/rtp-over-quic/metrics"
+	"github.com/Willi-42/rtp-over-quic/options"
+	"github.com/Willi-42/rtp-over-quic/quic"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	pionrtp "github.com/pion/rtp"
+)
+
+var errInvalidRelay = errors.New("invalid relay configuration")
+
+// rembInterval is the interval at which a relay announces the aggregated
+// bitrate of its receivers to the sender.
+const rembInterval = time.Second
+
+// relayQueueSize is the number of packets queued per receiver. Packets
+// exceeding it are dropped, so that a slow receiver doesn't stall the others.
+const relayQueueSize = 1024
+
+// Downstream is a receiver a relay forwards to. Layers limits the forwarded
+// layers of VP8 and VP9 streams, nil forwards all layers.
+type Downstream struct {
+	Addr   string
+	Layers *rtp.Layers
+}
+
+// ParseDownstream parses a downstream receiver of the form
+// '<addr>[@<spatial>:<temporal>]'.
+func ParseDownstream(s string) (Downstream, error) {
+	addr, layers, ok := strings.Cut(s, "@")
+	if len(addr) == 0 {
+		return Downstream{}, fmt.Errorf("%w: empty downstream address in %q", errInvalidRelay, s)
+	}
+	d := Downstream{Addr: addr}
+	if !ok {
+		return d, nil
+	}
+	spatial, temporal, ok := strings.Cut(layers, ":")
+	if !ok {
+		return Downstream{}, fmt.Errorf("%w: expected '<spatial>:<temporal>' layers in %q", errInvalidRelay, s)
+	}
+	sl, err := strconv.ParseUint(spatial, 10, 8)
+	if err != nil {
+		return Downstream{}, fmt.Errorf("%w: invalid spatial layer in %q: %v", errInvalidRelay, s, err)
+	}
+	tl, err := strconv.ParseUint(temporal, 10, 8)
+	if err != nil {
+		return Downstream{}, fmt.Errorf("%w: invalid temporal layer in %q: %v", errInvalidRelay, s, err)
+	}
+	d.Layers = &rtp.Layers{Spatial: uint8(sl), Temporal: uint8(tl)}
+	return d, nil
+}
+
+// RelayConfig configures a Relay. Config configures the side of the sender,
+// which has to use a QUIC transport. The receivers are connected using the
+// same transport settings.
+type RelayConfig struct {
+	Config
+
+	// Downstreams are the receivers the packets of the sender are forwarded
+	// to.
+	Downstreams []Downstream
+	// RTCPFeedback is the congestion control feedback sent to the sender.
+	RTCPFeedback RTCPFeedback
+	// RTPCC is the congestion controller estimating the bitrate to each
+	// receiver, using the feedback of the receiver. Without one, the
+	// sender is not capped.
+	RTPCC        string
+	StartBitrate uint
+	MinBitrate   uint
+	MaxBitrate   uint
+	// LayerDropping caps the sender at the estimate of the fastest receiver
+	// instead of the slowest one. Each receiver gets the frames fitting its
+	// own estimate, see rtp.FrameDropper, which drops more frames for slower
+	// receivers.
+	LayerDropping bool
+}
+
+// Validate returns the first problem of the configuration reported by
+// Problems.
+func (c *RelayConfig) Validate() error {
+	if errs := c.Problems(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Problems returns all problems of the configuration which can be found
+// without opening sockets.
+func (c *RelayConfig) Problems() []error {
+	errs := c.Config.validate()
+	if err := c.transportOptions().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if !options.IsQUIC(c.Transport) {
+		errs = append(errs, fmt.Errorf("%w: relay requires a QUIC transport, got %v", errInvalidRelay, c.Transport))
+	}
+	if len(c.Downstreams) == 0 {
+		errs = append(errs, fmt.Errorf("%w: no downstream receivers", errInvalidRelay))
+	}
+	if c.RTPCC != cc.NONE.String() && !isRTPCCAlgorithm(c.RTPCC) {
+		errs = append(errs, fmt.Errorf("%w: unknown congestion control algorithm %v", errInvalidCCConfig, c.RTPCC))
+	}
+	for _, d := range c.Downstreams {
+		if d.Layers != nil && c.Codec != "vp8" && c.Codec != "vp9" {
+			errs = append(errs, fmt.Errorf("%w: layers of receiver %v require codec 'vp8' or 'vp9', got %v", errInvalidRelay, d.Addr, c.Codec))
+			break
+		}
+	}
+	if c.LayerDropping && c.RTPCC == cc.NONE.String() {
+		errs = append(errs, fmt.Errorf("%w: layer dropping requires an RTP congestion controller", errInvalidRelay))
+	}
+	return errs
+}
+
+// Relay accepts one sender at a time and forwards its RTP packets to several
+// receivers. The receivers send congestion control feedback to the relay,
+// which estimates the bitrate to each of them, drops the frames exceeding
+// the estimate of each receiver and announces the lowest estimate, or the
+// highest with LayerDropping, to the sender by RTCP REMB. Packets are
+// written to each receiver from a queue of its own, so that a slow receiver
+// doesn't stall the others.
+// Keyframe requests of the receivers, e.g., when a viewer joins a receiver's
+// gateway, are forwarded to the sender.
+//
+// The sequence numbers and timestamps of each stream are rebased per
+// receiver, so that they start at zero when the receiver gets the first
+// packet and stay continuous when frames are dropped for the receiver. The
+// sender reports of the sender are adjusted accordingly, and the sequence
+// numbers of NACKs are mapped back before they are forwarded to the sender.
+type Relay struct {
+	config  RelayConfig
+	traffic *rtp.TrafficCounter
+
+	downstreams []*downstream
+
+	lock  sync.Mutex
+	ssrcs map[uint32]struct{}
+	// upstream writes RTCP to the connected sender, nil if there is none
+	upstream interceptor.RTCPWriter
+}
+
+// NewRelay creates a relay for c. It returns an error if the configuration
+// is invalid.
+func NewRelay(c RelayConfig) (*Relay, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &Relay{
+		config: c,
+		ssrcs:  map[uint32]struct{}{},
+	}, nil
+}
+
+// relayedPacket is a packet queued for a receiver. The payload is shared by
+// all receivers and must only be read.
+type relayedPacket struct {
+	header     pionrtp.Header
+	payload    []byte
+	attributes interceptor.Attributes
+}
+
+// downstream is the connection to one receiver of a relay.
+type downstream struct {
+	addr   string
+	sender *quic.Sender
+	writer interceptor.RTPWriter
+	// queue holds the packets to be written to the receiver by run.
+	queue chan relayedPacket
+	// dropFrames drops the frames of video streams exceeding the estimate
+	// of the congestion controller.
+	dropFrames bool
+	// startBitrate is split between the frame droppers until the
+	// congestion controller has an estimate.
+	startBitrate uint
+	// layers are the layers of the VP8 and VP9 streams the receiver gets.
+	layers *rtp.LayerSubscription
+
+	lock   sync.Mutex
+	target uint
+	// droppers are the frame droppers of the video streams by SSRC, which
+	// share the target bitrate.
+	droppers map[uint32]*rtp.FrameDropper
+	// rebasers rebase the streams by SSRC, so that they start at zero for
+	// the receiver.
+	rebasers map[uint32]*rtp.Rebaser
+}
+
+// SetTargetBitsPerSecond records the estimate of the congestion controller
+// and splits it between the frame droppers.
+func (d *downstream) SetTargetBitsPerSecond(rate uint) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.target = rate
+	d.splitTarget()
+}
+
+// splitTarget passes an equal share of the target bitrate to each frame
+// dropper. The lock must be held.
+func (d *downstream) splitTarget() {
+	rate := d.target
+	if rate == 0 {
+		rate = d.startBitrate
+	}
+	for _, dropper := range d.droppers {
+		dropper.SetTargetBitsPerSecond(rate / uint(len(d.droppers)))
+	}
+}
+
+// forward returns whether the packet of a frame of the video stream ssrc
+// fits the estimate of the receiver.
+func (d *downstream) forward(now time.Time, header *pionrtp.Header, size int, keyFrame bool) bool {
+	if !d.dropFrames {
+		return true
+	}
+	d.lock.Lock()
+	dropper, ok := d.droppers[header.SSRC]
+	if !ok {
+		dropper = rtp.NewFrameDropper(0)
+		d.droppers[header.SSRC] = dropper
+		d.splitTarget()
+	}
+	d.lock.Unlock()
+	return dropper.Forward(now, header, size, keyFrame)
+}
+
+// rebaser returns the Rebaser of the stream ssrc.
+func (d *downstream) rebaser(ssrc uint32) *rtp.Rebaser {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	rebaser, ok := d.rebasers[ssrc]
+	if !ok {
+		rebaser = rtp.NewRebaser()
+		d.rebasers[ssrc] = rebaser
+	}
+	return rebaser
+}
+
+// enqueue queues p for the receiver or drops it if the queue is full.
+func (d *downstream) enqueue(p relayedPacket) {
+	select {
+	case d.queue <- p:
+	default:
+		logging.Drop(logging.DropQueueOverflow, "relay queue of receiver %v is full, dropping packet %v of flow %v", d.addr, p.header.SequenceNumber, p.header.SSRC)
+	}
+}
+
+// run writes the queued packets to the receiver until ctx is done.
+func (d *downstream) run(ctx context.Context) {
+	for {
+		select {
+		case p := <-d.queue:
+			if _, err := d.writer.Write(&p.header, p.payload, p.attributes); err != nil {
+				log.Printf("relay: failed to forward packet to %v: %v", d.addr, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *downstream) Target() uint {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.target
+}
+
+// Start connects to the receivers and forwards the packets of senders until
+// ctx is done.
+func (r *Relay) Start(ctx context.Context) error {
+	ctx, cancel := r.config.withDuration(ctx)
+	defer cancel()
+	r.traffic = rtp.NewTrafficCounter()
+	for _, d := range r.config.Downstreams {
+		ds, err := r.connect(ctx, d)
+		if err != nil {
+			return fmt.Errorf("failed to connect to receiver %v: %w", d.Addr, err)
+		}
+		defer func() {
+			if err := ds.sender.Close(); err != nil {
+				log.Printf("failed to close connection to receiver %v: %v", ds.addr, err)
+			}
+		}()
+		r.downstreams = append(r.downstreams, ds)
+	}
+	if len(r.config.MetricsAddr) > 0 {
+		go r.config.serveMetrics(ctx, metrics.NewExporter(r.traffic))
+	}
+	if len(r.config.StatsFile) > 0 {
+		go r.config.writeStats(ctx, nil, r.traffic, rtp.Received)
+	}
+
+	t := r.config.transportOptions()
+	t.MaxConnections = 1
+	opts, err := t.QUICServerOptions()
+	if err != nil {
+		return err
+	}
+	server, err := quic.NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	server.OnNewHandler(r.handle)
+	start := time.Now()
+	err = server.Start(ctx)
+	logSummary(r.traffic.Flows(), rtp.Received, time.Since(start))
+	return err
+}
+
+// connect opens the connection to the receiver d with a congestion
+// controller and the layer subscription of its own.
+func (r *Relay) connect(ctx context.Context, d Downstream) (*downstream, error) {
+	ds := &downstream{
+		addr:         d.Addr,
+		queue:        make(chan relayedPacket, relayQueueSize),
+		startBitrate: r.config.StartBitrate,
+		droppers:     map[uint32]*rtp.FrameDropper{},
+		rebasers:     map[uint32]*rtp.Rebaser{},
+	}
+	var rtpOptions []rtp.Option
+	if r.config.RTPCC != cc.NONE.String() {
+		ds.dropFrames = r.config.Codec != media.Opus
+		bwe, err := rtp.NewBandwidthEstimator("")
+		if err != nil {
+			return nil, err
+		}
+		bwe.SetMedia(ds)
+		bwe.SetBitrateLimits(int(r.config.MinBitrate), int(r.config.MaxBitrate))
+		go func() {
+			if err := bwe.Run(ctx); err != nil {
+				log.Printf("bandwidth estimator of receiver %v failed: %v", d.Addr, err)
+			}
+		}()
+		opts, err := ccOptions(r.config.RTPCC, bwe, int(r.config.StartBitrate), int(r.config.MinBitrate), int(r.config.MaxBitrate))
+		if err != nil {
+			return nil, err
+		}
+		rtpOptions = append(rtpOptions, opts...)
+	}
+	ds.layers = rtp.NewLayerSubscription()
+	if d.Layers != nil {
+		ds.layers.SetDefault(*d.Layers)
+	}
+	// receives the subscriptions of the receiver, the packets are filtered
+	// by forward
+	rtpOptions = append(rtpOptions, rtp.RegisterLayerSubscription(ds.layers))
+	rtpOptions = append(rtpOptions, rtp.RegisterKeyframeRequestReader(rtp.NewKeyframeRequestReader(func(ssrc uint32) {
+		r.requestKeyframe(d.Addr, ssrc)
+	})))
+	rtpOptions = append(rtpOptions, rtp.RegisterNACKReader(rtp.NewNACKReader(func(ssrc uint32, seqNrs []uint16) {
+		r.forwardNACK(ds, ssrc, seqNrs)
+	})))
+	registry, err := rtp.New(rtpOptions...)
+	if err != nil {
+		return nil, err
+	}
+	t := r.config.transportOptions()
+	t.Addr = d.Addr
+	opts, err := t.QUICSenderOptions()
+	if err != nil {
+		return nil, err
+	}
+	ds.sender, err = quic.NewSender(registry, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.sender.Connect(ctx); err != nil {
+		return nil, err
+	}
+	ds.writer, err = ds.sender.NewMediaStream()
+	if err != nil {
+		return nil, err
+	}
+	go ds.run(ctx)
+	log.Printf("relaying to receiver %v", d.Addr)
+	return ds, nil
+}
+
+// upstreamOptions returns the interceptors of the connection of a sender.
+func (r *Relay) upstreamOptions() []rtp.Option {
+	opts := []rtp.Option{rtp.RegisterTrafficCounter(r.traffic)}
+	switch r.config.RTCPFeedback {
+	case RTCP_RFC8888:
+		opts = append(opts, rtp.RegisterRFC8888())
+	case RTCP_RFC8888_PION:
+		opts = append(opts, rtp.RegisterRFC8888Pion())
+	case RTCP_TWCC:
+		opts = append(opts, rtp.RegisterTWCC())
+	}
+	return opts
+}
+
+// handle forwards the packets of the sender of h to the receivers.
+func (r *Relay) handle(h *quic.Handler) error {
+	registry, err := rtp.New(r.upstreamOptions()...)
+	if err != nil {
+		return err
+	}
+	i, err := registry.Build("")
+	if err != nil {
+		return err
+	}
+	i.BindRTCPWriter(interceptor.RTCPWriterFunc(h.WriteRTCP))
+	rtcpReader := i.BindRTCPReader(interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		r.forwardSenderReports(b)
+		return len(b), a, nil
+	}))
+	reader := i.BindRemoteStream(&interceptor.StreamInfo{
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: rtp.TransportCCURI, ID: 1}},
+		RTCPFeedback:        []interceptor.RTCPFeedback{{Type: "ack", Parameter: "ccfb"}},
+	}, interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		r.forward(b)
+		return len(b), a, nil
+	}))
+
+	r.lock.Lock()
+	r.upstream = interceptor.RTCPWriterFunc(h.WriteRTCP)
+	r.lock.Unlock()
+	done := make(chan struct{})
+	h.OnClose(func() {
+		r.lock.Lock()
+		r.upstream = nil
+		r.lock.Unlock()
+		close(done)
+		if err := i.Close(); err != nil {
+			log.Printf("failed to close interceptors: %v", err)
+		}
+	})
+	if r.config.RTPCC != cc.NONE.String() {
+		go r.announce(done, h)
+	}
+	h.SetRTPReader(interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		if rtp.IsRTCP(b) {
+			return rtcpReader.Read(b, a)
+		}
+		return reader.Read(b, a)
+	}))
+	return nil
+}
+
+// forward queues the RTP packet b for all receivers. Packets of VP8 and VP9
+// layers a receiver did not subscribe to and the frames of video packets
+// exceeding the estimate of a receiver are dropped for that receiver before
+// the stream is rebased, so that the receiver doesn't see a gap.
+func (r *Relay) forward(b []byte) {
+	// the queued packets outlive the read buffer b
+	b = append([]byte(nil), b...)
+	p := &pionrtp.Packet{}
+	if err := p.Unmarshal(b); err != nil {
+		log.Printf("relay: dropping invalid RTP packet: %v", err)
+		return
+	}
+	r.lock.Lock()
+	r.ssrcs[p.SSRC] = struct{}{}
+	r.lock.Unlock()
+	now := time.Now()
+	video := uint(p.PayloadType) != r.config.AudioPayloadType
+	info, hasLayer := rtp.LayerFromPayload(r.config.Codec, p.Payload)
+	keyFrame := rtp.KeyFrameFromPayload(r.config.Codec, p.Payload)
+	for _, d := range r.downstreams {
+		rebaser := d.rebaser(p.SSRC)
+		if hasLayer && !d.layers.Forward(p.SSRC, info) {
+			rebaser.Drop()
+			continue
+		}
+		if video && !d.forward(now, &p.Header, len(b), keyFrame) {
+			rebaser.Drop()
+			logging.Drop(logging.DropRelayCongestion, "packet %v of flow %v exceeds the estimate of receiver %v", p.SequenceNumber, p.SSRC, d.addr)
+			continue
+		}
+		// the interceptors of each receiver modify the header
+		header := p.Header
+		header.Extensions = append([]pionrtp.Extension(nil), p.Extensions...)
+		if !rebaser.RebasePacket(&header, len(p.Payload)) {
+			continue
+		}
+		d.enqueue(relayedPacket{header: header, payload: p.Payload, attributes: interceptor.Attributes{}})
+	}
+}
+
+// forwardSenderReports forwards the RTCP sender reports in b to the
+// receivers, adjusted to the rebased streams of each receiver.
+func (r *Relay) forwardSenderReports(b []byte) {
+	pkts, err := rtcp.Unmarshal(b)
+	if err != nil {
+		return
+	}
+	for _, d := range r.downstreams {
+		reports := []rtcp.Packet{}
+		for _, pkt := range pkts {
+			sr, ok := pkt.(*rtcp.SenderReport)
+			if !ok {
+				continue
+			}
+			rebased := *sr
+			// the reception reports are about the streams the sender
+			// receives, not about the relayed streams
+			rebased.Reports = nil
+			if d.rebaser(sr.SSRC).RebaseSenderReport(&rebased) {
+				reports = append(reports, &rebased)
+			}
+		}
+		if len(reports) == 0 {
+			continue
+		}
+		if _, err := d.sender.WriteRTCP(reports, interceptor.Attributes{}); err != nil {
+			log.Printf("relay: failed to forward sender reports to %v: %v", d.addr, err)
+		}
+	}
+}
+
+// forwardNACK forwards the NACK of the receiver d for ssrc to the sender,
+// with the sequence numbers mapped back to the stream of the sender.
+func (r *Relay) forwardNACK(d *downstream, ssrc uint32, seqNrs []uint16) {
+	r.lock.Lock()
+	upstream := r.upstream
+	r.lock.Unlock()
+	if upstream == nil {
+		return
+	}
+	rebaser := d.rebaser(ssrc)
+	upstreamSeqNrs := make([]uint16, 0, len(seqNrs))
+	for _, seqNr := range seqNrs {
+		upstreamSeqNrs = append(upstreamSeqNrs, rebaser.UpstreamSequenceNumber(seqNr))
+	}
+	nack := []rtcp.Packet{&rtcp.TransportLayerNack{
+		MediaSSRC: ssrc,
+		Nacks:     rtcp.NackPairsFromSequenceNumbers(upstreamSeqNrs),
+	}}
+	if _, err := upstream.Write(nack, interceptor.Attributes{}); err != nil {
+		log.Printf("relay: failed to forward NACK of %v: %v", d.addr, err)
+	}
+}
+
+// requestKeyframe forwards the keyframe request of the receiver addr for
+// ssrc to the sender as PLI.
+func (r *Relay) requestKeyframe(addr string, ssrc uint32) {
+	r.lock.Lock()
+	upstream := r.upstream
+	r.lock.Unlock()
+	if upstream == nil {
+		return
+	}
+	pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}}
+	if _, err := upstream.Write(pli, interceptor.Attributes{}); err != nil {
+		log.Printf("relay: failed to forward keyframe request of %v: %v", addr, err)
+	}
+}
+
+// announce sends the aggregated bitrate of the receivers to the sender of h
+// until done is closed.
+func (r *Relay) announce(done <-chan struct{}, h *quic.Handler) {
+	ticker := time.NewTicker(rembInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rate := r.aggregateTarget()
+			ssrcs := r.sources()
+			if rate == 0 || len(ssrcs) == 0 {
+				continue
+			}
+			remb := &rtcp.ReceiverEstimatedMaximumBitrate{
+				Bitrate: float32(rate),
+				SSRCs:   ssrcs,
+			}
+			if _, err := h.WriteRTCP([]rtcp.Packet{remb}, interceptor.Attributes{}); err != nil {
+				log.Printf("relay: failed to send REMB: %v", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// aggregateTarget returns the lowest estimate of the receivers, or the
+// highest with LayerDropping, 0 if there is no estimate yet.
+func (r *Relay) aggregateTarget() uint {
+	var rate uint
+	for _, d := range r.downstreams {
+		t := d.Target()
+		if t == 0 {
+			continue
+		}
+		if rate == 0 || (r.config.LayerDropping && t > rate) || (!r.config.LayerDropping && t < rate) {
+			rate = t
+		}
+	}
+	return rate
+}
+
+func (r *Relay) sources() []uint32 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ssrcs := make([]uint32, 0, len(r.ssrcs))
+	for ssrc := range r.ssrcs {
+		ssrcs = append(ssrcs, ssrc)
+	}
+	sort.Slice(ssrcs, func(i, j int) bool { return ssrcs[i] < ssrcs[j] })
+	return ssrcs
+}
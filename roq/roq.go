@@ -0,0 +1,343 @@
+// Package roq runs RTP senders and receivers over QUIC, UDP or TCP. It wires
+// the media pipelines, interceptors, congestion controllers and transports
+// together and is used by the rtp-over-quic command, but can be imported by
+// other programs as well:
+//
+//	s, err := roq.NewSender(roq.SetRemoteAddress("localhost:4242"), roq.SetRTPCongestionControl("scream"))
+//	if err != nil {
+//		return err
+//	}
+//	return s.Start(ctx)
+//
+// Settings which have no option of their own are set by any function
+// modifying the documented fields of SenderConfig or ReceiverConfig.
+package roq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/dashboard"
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/media"
+	"github.com/Willi-42/rtp-over-quic/metrics"
+	"github.com/Willi-42/rtp-over-quic/options"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+)
+
+var (
+	errInvalidTransport   = errors.New("unknown transport protocol")
+	errNoTransport        = errors.New("no transport connected")
+	errConnectTimeout     = errors.New("connect timed out")
+	errInvalidPayloadType = errors.New("invalid payload type")
+
+	errInvalidBWEEvaluation = errors.New("invalid bandwidth estimation evaluation")
+	errInvalidCCConfig      = errors.New("invalid congestion control configuration")
+
+	errInvalidConnectionLimit  = errors.New("invalid connection limit")
+	errInvalidContentHint      = errors.New("invalid content hint")
+	errInvalidDuration         = errors.New("invalid duration")
+	errInvalidEncoder          = errors.New("invalid encoder")
+	errInvalidMediaBackend     = errors.New("unknown media backend")
+	errInvalidPacketizer       = errors.New("invalid packetizer")
+	errInvalidPipelineRestarts = errors.New("invalid number of pipeline restarts")
+	errInvalidQualityScoring   = errors.New("invalid quality scoring")
+	errInvalidStreams          = errors.New("invalid streams")
+)
+
+// videoClockRate is the RTP clock rate of all supported video codecs.
+const videoClockRate = 90000
+
+// senderReportInterval is the interval of the RTCP sender reports which map
+// the RTP timestamps of audio and video to the wallclock of the sender.
+const senderReportInterval = time.Second
+
+// Config holds the settings shared by senders and receivers.
+type Config struct {
+	// Transport is one of 'quic', 'quic-dgram', 'quic-stream', 'quic-prio',
+	// 'udp', 'tcp' or 'auto'. With 'auto', the sender tries the transports
+	// in options.AutoTransports in order and the receiver listens on QUIC
+	// and TCP.
+	Transport string
+	// Addr is the address of the receiver, which the receiver listens on.
+	Addr string
+	// ECN marks sent packets as ECN capable and reports CE marks in RFC
+	// 8888 feedback (UDP only).
+	ECN bool
+	// UDPBatch is the number of packets sent with sendmmsg or received with
+	// recvmmsg per system call, 0 or 1 disables batching (UDP only).
+	UDPBatch int
+	// UDPMTU is the size of the buffers packets are received into, larger
+	// packets are truncated. 0 uses 1500 bytes (UDP only).
+	UDPMTU int
+	// QLOGDir is the directory of the qlog files of QUIC connections,
+	// 'stdout' for Stdout. Disabled if empty.
+	QLOGDir string
+	// KeyLogFile is the file the TLS keys are written to.
+	KeyLogFile string
+	// QUICCC is the QUIC congestion control algorithm: 'none', 'newreno',
+	// 'bbr' or 'copa'.
+	QUICCC string
+	// TCPCC is the TCP congestion control algorithm of the TCP transport.
+	TCPCC string
+
+	// Codec is the media codec, 'auto' on the receiver selects the codec by
+	// payload type.
+	Codec string
+	// PayloadType is the RTP payload type of the media.
+	PayloadType uint
+	// REDPayloadType is the RTP payload type of RED (RFC 2198) packets.
+	REDPayloadType uint
+	// AudioPayloadType is the RTP payload type of the Opus stream sent
+	// alongside the video, see SenderConfig.AudioSource and
+	// ReceiverConfig.AudioSink.
+	AudioPayloadType uint
+	// MediaBackend creates the media sources and sinks, one of
+	// media.Backends.
+	MediaBackend string
+	// PipelineRestarts is the number of consecutive attempts to rebuild a
+	// failed or stalled media pipeline, without dropping the connection,
+	// before giving up. 0 disables the supervision.
+	PipelineRestarts int
+	// SRTPKey is the hex encoded pre-shared SRTP master key and salt. SRTP
+	// is disabled if empty.
+	SRTPKey string
+
+	// RTPDumpFile and RTCPDumpFile are the packet logs, 'stdout' for
+	// Stdout. Disabled if empty.
+	RTPDumpFile  string
+	RTCPDumpFile string
+	// DumpFormat is the format of the packet logs, rtp.PacketLogText or
+	// rtp.PacketLogRTPDump.
+	DumpFormat string
+	// PcapFile is the pcapng file the plaintext packets are written to.
+	// Disabled if empty.
+	PcapFile string
+
+	// MetricsAddr is the address the Prometheus metrics are served on.
+	// Disabled if empty.
+	MetricsAddr string
+	// StatsFile is the file periodic stats are written to in StatsFormat
+	// every StatsInterval. Disabled if empty.
+	StatsFile     string
+	StatsFormat   string
+	StatsInterval time.Duration
+	// InfluxURL is the line protocol endpoint metrics are pushed to every
+	// InfluxInterval, tagged with Experiment. Disabled if empty.
+	InfluxURL      string
+	InfluxInterval time.Duration
+	Experiment     string
+	// RecordLatency records the latency histograms of the logging package.
+	RecordLatency bool
+	// LatencyInterval is the interval at which the latency percentiles are
+	// logged, 0 disables logging.
+	LatencyInterval time.Duration
+	// Dashboard shows a live view of the flows on Stdout. Log output is
+	// shown in the dashboard meanwhile.
+	Dashboard bool
+	// Control is read for control commands, one per line, if set. See
+	// Sender and Receiver for the supported commands.
+	Control io.Reader
+	// ControlAddr is the address the HTTP control service is served on.
+	// Disabled if empty.
+	ControlAddr string
+	// Hooks are called on connection and flow lifecycle events of the QUIC
+	// and TCP transports if set.
+	Hooks *events.Hooks
+	// Duration stops the session gracefully after the given time as if the
+	// context passed to Start was done. 0 runs until the context is done.
+	Duration time.Duration
+}
+
+func defaultConfig() Config {
+	return Config{
+		Transport:        "quic",
+		Addr:             ":4242",
+		QUICCC:           "none",
+		TCPCC:            "reno",
+		Codec:            "h264",
+		PayloadType:      96,
+		REDPayloadType:   63,
+		AudioPayloadType: media.OpusPayloadType,
+		MediaBackend:     media.BackendGstreamer,
+		PipelineRestarts: 5,
+		DumpFormat:       rtp.PacketLogText,
+		StatsFormat:      metrics.StatsCSV,
+		StatsInterval:    100 * time.Millisecond,
+		InfluxInterval:   time.Second,
+	}
+}
+
+// validate checks the settings which can be checked without opening files
+// or sockets.
+func (c *Config) validate() []error {
+	errs := []error{}
+	if err := c.validatePayloadTypes(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.srtpOptions(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.validateMediaBackend(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.PipelineRestarts < 0 {
+		errs = append(errs, fmt.Errorf("%w: %v", errInvalidPipelineRestarts, c.PipelineRestarts))
+	}
+	if c.Duration < 0 {
+		errs = append(errs, fmt.Errorf("%w: %v", errInvalidDuration, c.Duration))
+	}
+	return errs
+}
+
+// withDuration returns a context which is done after Duration, if set.
+func (c *Config) withDuration(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Duration > 0 {
+		return context.WithTimeout(ctx, c.Duration)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (c *Config) validatePayloadTypes() error {
+	if c.PayloadType > 127 {
+		return fmt.Errorf("%w: %v", errInvalidPayloadType, c.PayloadType)
+	}
+	if c.REDPayloadType > 127 {
+		return fmt.Errorf("%w: %v", errInvalidPayloadType, c.REDPayloadType)
+	}
+	if c.PayloadType == c.REDPayloadType {
+		return fmt.Errorf("%w: media and RED payload type must differ, got %v", errInvalidPayloadType, c.PayloadType)
+	}
+	if c.AudioPayloadType > 127 {
+		return fmt.Errorf("%w: %v", errInvalidPayloadType, c.AudioPayloadType)
+	}
+	if c.Codec != media.Opus && (c.AudioPayloadType == c.PayloadType || c.AudioPayloadType == c.REDPayloadType) {
+		return fmt.Errorf("%w: audio payload type %v must differ from the media and RED payload type", errInvalidPayloadType, c.AudioPayloadType)
+	}
+	return nil
+}
+
+func (c *Config) validateMediaBackend() error {
+	for _, b := range media.Backends {
+		if c.MediaBackend == b {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v, expected one of %v", errInvalidMediaBackend, c.MediaBackend, media.Backends)
+}
+
+func (c *Config) srtpOptions() ([]rtp.Option, error) {
+	if len(c.SRTPKey) == 0 {
+		return nil, nil
+	}
+	key, err := rtp.ParseSRTPKey(c.SRTPKey)
+	if err != nil {
+		return nil, err
+	}
+	return []rtp.Option{rtp.RegisterSRTP(key)}, nil
+}
+
+// pcapOptions returns the option registering a dump of the packets to
+// PcapFile, if set. The dump has to be closed after the session.
+func (c *Config) pcapOptions(sender bool) ([]rtp.Option, *rtp.PcapDump, error) {
+	if len(c.PcapFile) == 0 {
+		return nil, nil, nil
+	}
+	d, err := rtp.NewPcapDump(c.PcapFile, sender)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []rtp.Option{rtp.RegisterPcapDump(d)}, d, nil
+}
+
+// transportOptions returns the transport options common to sender and
+// receiver.
+func (c *Config) transportOptions() *options.Transport {
+	return &options.Transport{
+		Transport:  c.Transport,
+		Addr:       c.Addr,
+		ECN:        c.ECN,
+		UDPBatch:   c.UDPBatch,
+		UDPMTU:     c.UDPMTU,
+		QLOGDir:    c.QLOGDir,
+		KeyLogFile: c.KeyLogFile,
+		QUICCC:     c.QUICCC,
+		TCPCC:      c.TCPCC,
+		Hooks:      c.Hooks,
+	}
+}
+
+// serveMetrics serves the Prometheus metrics of e at MetricsAddr until ctx is
+// done.
+func (c *Config) serveMetrics(ctx context.Context, e *metrics.Exporter) {
+	if err := e.Serve(ctx, c.MetricsAddr); err != nil {
+		log.Printf("failed to serve metrics: %v", err)
+	}
+}
+
+// pushInflux pushes the metrics of sources and traffic to InfluxURL until
+// ctx is done. Points are tagged with the experiment, the role, the
+// transport and ccAlgorithm.
+func (c *Config) pushInflux(ctx context.Context, role, transport, ccAlgorithm string, sources map[string]cc.MetricsSource, traffic *rtp.TrafficCounter) {
+	e := logging.NewInfluxExporter(c.InfluxURL, map[string]string{
+		"experiment": c.Experiment,
+		"role":       role,
+		"transport":  transport,
+		"cc":         ccAlgorithm,
+	})
+	for name, source := range sources {
+		e.AddSource(name, source)
+	}
+	if traffic != nil {
+		e.AddCollector(func() []logging.Point {
+			points := []logging.Point{}
+			for _, f := range traffic.Flows() {
+				points = append(points, logging.Point{
+					Measurement: "flow",
+					Tags: map[string]string{
+						"ssrc":      fmt.Sprint(f.SSRC),
+						"direction": string(f.Direction),
+					},
+					Fields: map[string]float64{
+						"packets": float64(f.Packets),
+						"bytes":   float64(f.Bytes),
+						"lost":    float64(f.Lost),
+					},
+				})
+			}
+			return points
+		})
+	}
+	e.Run(ctx, c.InfluxInterval)
+}
+
+// writeStats writes the StatsFile until ctx is done.
+func (c *Config) writeStats(ctx context.Context, sources map[string]cc.MetricsSource, traffic *rtp.TrafficCounter, direction rtp.Direction) {
+	if err := metrics.WriteStats(ctx, c.StatsFile, c.StatsFormat, c.StatsInterval, sources, traffic, direction); err != nil {
+		log.Printf("failed to write stats: %v", err)
+	}
+}
+
+// runDashboard shows d until ctx is done. Log output is shown in the
+// dashboard meanwhile.
+func runDashboard(ctx context.Context, d *dashboard.Dashboard) {
+	log.SetOutput(d)
+	defer log.SetOutput(os.Stderr)
+	d.Run(ctx)
+}
+
+// clockRate returns the RTP clock rate of payloadType, which is the rate of
+// Opus for the audio sent alongside the video.
+func (c *Config) clockRate(payloadType uint8) uint32 {
+	if uint(payloadType) == c.AudioPayloadType {
+		return media.OpusClockRate
+	}
+	return media.CodecClockRate(c.Codec)
+}
@@ -0,0 +1,217 @@
+package roq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// controlStats is the result of the dump-stats command.
+type controlStats struct {
+	Time              time.Time             `json:"time"`
+	CongestionControl string                `json:"congestion_control"`
+	BitrateCap        uint                  `json:"bitrate_cap"`
+	Flows             []controlFlowStats    `json:"flows"`
+	Controllers       map[string]cc.Metrics `json:"controllers"`
+}
+
+type controlFlowStats struct {
+	SSRC      uint32 `json:"ssrc"`
+	Direction string `json:"direction"`
+	Packets   uint64 `json:"packets"`
+	Bytes     uint64 `json:"bytes"`
+	Lost      uint64 `json:"lost"`
+}
+
+func newControlFlowStats(flows []rtp.FlowStats) []controlFlowStats {
+	res := make([]controlFlowStats, 0, len(flows))
+	for _, f := range flows {
+		res = append(res, controlFlowStats{
+			SSRC:      f.SSRC,
+			Direction: string(f.Direction),
+			Packets:   f.Packets,
+			Bytes:     f.Bytes,
+			Lost:      f.Lost,
+		})
+	}
+	return res
+}
+
+// listenControl listens on addr, a Unix socket if it starts with 'unix:' and
+// a TCP address otherwise.
+func listenControl(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		return net.Listen("unix", strings.TrimPrefix(addr, "unix:"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serveRPC serves JSON-RPC 2.0 requests, one JSON object per request, on
+// addr until ctx is done:
+//
+//	{"jsonrpc": "2.0", "id": 1, "method": "set-bitrate-cap", "params": {"bitrate": 500000}}
+//
+// The methods are 'pause' and 'resume' with an optional 'ssrc',
+// 'set-bitrate-cap' with 'bitrate' in bit/s (0 removes the cap),
+// 'force-keyframe', 'switch-cc' with 'algorithm' and 'dump-stats'.
+func (c *controller) serveRPC(ctx context.Context, addr string) error {
+	l, err := listenControl(addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("control: listening on %v", addr)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.handleRPC(ctx, conn)
+		}()
+	}
+}
+
+func (c *controller) handleRPC(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req rpcRequest
+		if err := dec.Decode(&req); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				if err := enc.Encode(rpcResponse{
+					JSONRPC: "2.0",
+					ID:      json.RawMessage("null"),
+					Error:   &rpcError{Code: rpcParseError, Message: err.Error()},
+				}); err != nil {
+					log.Printf("control: failed to write response: %v", err)
+				}
+			}
+			return
+		}
+		res := c.call(req)
+		if len(req.ID) == 0 {
+			// notifications are not answered
+			continue
+		}
+		res.ID = req.ID
+		if err := enc.Encode(res); err != nil {
+			log.Printf("control: failed to write response: %v", err)
+			return
+		}
+	}
+}
+
+// call executes a request and returns the response without ID.
+func (c *controller) call(req rpcRequest) rpcResponse {
+	res := rpcResponse{JSONRPC: "2.0"}
+	if req.JSONRPC != "2.0" || len(req.Method) == 0 {
+		res.Error = &rpcError{Code: rpcInvalidRequest, Message: "expected a JSON-RPC 2.0 request"}
+		return res
+	}
+	var params struct {
+		SSRC      uint32 `json:"ssrc"`
+		Bitrate   uint   `json:"bitrate"`
+		Algorithm string `json:"algorithm"`
+	}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			res.Error = &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+			return res
+		}
+	}
+	var err error
+	switch req.Method {
+	case "pause":
+		err = c.pause(params.SSRC)
+	case "resume":
+		err = c.resume(params.SSRC)
+	case "set-bitrate-cap":
+		err = c.setBitrateCap(params.Bitrate)
+	case "force-keyframe":
+		if c.forceKeyframe == nil {
+			err = fmt.Errorf("%w: %v", errUnsupportedCommand, req.Method)
+			break
+		}
+		err = c.forceKeyframe()
+	case "switch-cc":
+		if c.switchCC == nil {
+			err = fmt.Errorf("%w: %v", errUnsupportedCommand, req.Method)
+			break
+		}
+		if len(params.Algorithm) == 0 {
+			res.Error = &rpcError{Code: rpcInvalidParams, Message: "switch-cc requires an algorithm"}
+			return res
+		}
+		err = c.switchCC(params.Algorithm)
+	case "dump-stats":
+		if c.stats == nil {
+			err = fmt.Errorf("%w: %v", errUnsupportedCommand, req.Method)
+			break
+		}
+		res.Result = c.stats()
+		return res
+	default:
+		res.Error = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %v", req.Method)}
+		return res
+	}
+	if err != nil {
+		res.Error = &rpcError{Code: rpcServerError, Message: err.Error()}
+		return res
+	}
+	res.Result = "ok"
+	return res
+}
@@ -0,0 +1,1562 @@
+package roq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/dashboard"
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/Willi-42/rtp-over-quic/fse"
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/media"
+	"github.com/Willi-42/rtp-over-quic/metrics"
+	"github.com/Willi-42/rtp-over-quic/options"
+	"github.com/Willi-42/rtp-over-quic/quic"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/Willi-42/rtp-over-quic/tcp"
+	"github.com/Willi-42/rtp-over-quic/udp"
+	"github.com/pion/interceptor"
+)
+
+// appLimitedThreshold is the fraction of the target bitrate below which the
+// media is considered application limited.
+const appLimitedThreshold = 0.8
+
+// keyframeRequestInterval is the minimum time between two keyframes forced
+// by RTCP PLI or FIR, so that receivers requesting a keyframe for every loss
+// don't flood the path with keyframes.
+const keyframeRequestInterval = 500 * time.Millisecond
+
+type SenderOption func(*SenderConfig) error
+
+// SetSenderConfig replaces the whole configuration by c.
+func SetSenderConfig(c SenderConfig) SenderOption {
+	return func(sc *SenderConfig) error {
+		*sc = c
+		return nil
+	}
+}
+
+// SetSenderTransport sets the transport protocol, see Config.Transport.
+func SetSenderTransport(transport string) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.Transport = transport
+		return nil
+	}
+}
+
+func SetRemoteAddress(addr string) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.Addr = addr
+		return nil
+	}
+}
+
+func SetSource(source string) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.Source = source
+		return nil
+	}
+}
+
+func SetRTPCongestionControl(algorithm string) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.RTPCC = algorithm
+		return nil
+	}
+}
+
+// SetMediaSourceFactory creates the media source of the stream using f
+// instead of Source.
+func SetMediaSourceFactory(f MediaSourceFactory) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.SourceFactory = f
+		return nil
+	}
+}
+
+// SetBitrates sets the start, minimum and maximum target bitrate in bit/s.
+func SetBitrates(start, min, max uint) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.StartBitrate = start
+		sc.MinBitrate = min
+		sc.MaxBitrate = max
+		return nil
+	}
+}
+
+// CCSwitch switches the RTP congestion controller to Algorithm After the
+// start of the session.
+type CCSwitch struct {
+	After     time.Duration
+	Algorithm string
+}
+
+// ParseCCSwitches parses entries of the form '<duration>=<algorithm>'.
+func ParseCCSwitches(entries []string) ([]CCSwitch, error) {
+	switches := make([]CCSwitch, 0, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: invalid congestion control switch %q, expected '<duration>=<algorithm>'", errInvalidCCConfig, entry)
+		}
+		after, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid congestion control switch %q: %v", errInvalidCCConfig, entry, err)
+		}
+		switches = append(switches, CCSwitch{After: after, Algorithm: parts[1]})
+	}
+	return switches, nil
+}
+
+// SenderConfig configures a Sender. NewSender starts from the defaults of
+// the rtp-over-quic send command.
+type SenderConfig struct {
+	Config
+
+	// Source is the media source: 'videotestsrc', 'syncodec', 'gotestsrc'
+	// (fake H.264 or VP8 frames generated in Go, see media.TestSource),
+	// 'ximagesrc' or 'pipewiresrc' capturing the screen, a V4L2 camera, e.g.,
+	// 'camera:/dev/video0', the URL of an RTSP stream, e.g., of an IP camera,
+	// or a video file. It is ignored if SourceFactory is set.
+	Source string
+	// RTSPLatency is the buffer of RTSP sources, RTSPOverTCP interleaves
+	// their RTP in the RTSP connection instead of using UDP.
+	RTSPLatency time.Duration
+	RTSPOverTCP bool
+	// SourceFactory creates the media source of the stream, if set.
+	SourceFactory MediaSourceFactory
+	// SSRC of the media stream.
+	SSRC uint32
+	// Width and Height of the encoded video, the resolution of the source
+	// is kept if either is 0.
+	Width  uint
+	Height uint
+	// Framerate of the encoded video, the frame rate of the source is kept
+	// if 0.
+	Framerate uint
+	// ContentHint is the content of the video: 'camera', 'screen' or
+	// 'auto' (or empty), which is 'screen' for screen capture sources, see
+	// media.IsScreenCapture. Screen content is encoded with low-latency
+	// screen presets, all its packets are sent reliably unless Reliability
+	// selects a policy, and the target bitrate is frozen while the mostly
+	// static screen leaves the media application limited.
+	ContentHint string
+	// Encoder is the video encoder, 'software' (or empty) or one of the
+	// hardware encoders 'vaapi' and 'nvenc', which support the codecs h264
+	// and h265 and reach higher resolutions at high bitrates.
+	Encoder string
+	// Packetizer packetizes the frames of Gstreamer sources: 'gstreamer',
+	// 'go' or 'auto' (or empty), which is 'go' for the transport
+	// 'quic-prio' and 'gstreamer' otherwise. Go packetization takes the
+	// encoded frames from the pipeline, so that the packets carry the frame
+	// boundaries, sizes and key frame flags, see rtp.FrameInfo. The other
+	// sources always packetize in Go.
+	Packetizer string
+	// Adaptation lets the target bitrate of the congestion controller
+	// select the resolution and frame rate of Gstreamer sources, starting
+	// at Width, Height and Framerate, see media.Adaptation.
+	Adaptation bool
+	// Syncodec is the traffic model of the 'syncodec' source: frame
+	// interval, burstiness, key frame interval and the delay until a new
+	// target bitrate is applied. The frames are logged to SyncodecStatsLog,
+	// disabled if empty.
+	Syncodec         media.SyncodecModel
+	SyncodecStatsLog string
+	// Streams is the number of media streams sent on the connection, each
+	// with its own SSRC (SSRC plus the index of the stream) and flow ID. The
+	// target bitrate is shared equally between the streams.
+	Streams int
+	// StreamSources are the sources of the streams in order, streams
+	// without an entry or with an empty entry use Source.
+	StreamSources []string
+	// AudioSource is the source of an Opus stream sent alongside the
+	// streams, e.g., 'autoaudiosrc', with the next SSRC after the streams,
+	// its own flow ID and AudioPayloadType. It is encoded at AudioBitrate,
+	// which is not adapted by congestion control. Disabled if empty.
+	AudioSource  string
+	AudioBitrate uint
+	// SignalingURL is the URL of the signaling endpoint of the receiver,
+	// e.g., 'http://receiver:8080/offer', the sender offers its streams to
+	// before connecting. Disabled if empty.
+	SignalingURL string
+	// ControlSocket is the address of the JSON-RPC control socket,
+	// 'unix:<path>' for a Unix socket or a TCP address. Disabled if empty.
+	ControlSocket string
+
+	// RTPCC is the RTP congestion control algorithm: 'none', 'scream',
+	// 'gcc', 'nada' or an algorithm added using cc.Register.
+	RTPCC string
+	// CCSwitches switch the RTP congestion controller during the session,
+	// the new algorithm starts at the current target bitrate. Requires
+	// RTPCC.
+	CCSwitches []CCSwitch
+	// CCDump is the log file of the RTP congestion controller.
+	CCDump string
+	// QUICCCTarget uses the rate of the QUIC congestion controller ('bbr'
+	// or 'copa') as media target bitrate, requires RTPCC 'none'.
+	QUICCCTarget bool
+	// StartBitrate, MinBitrate and MaxBitrate bound the target bitrate in
+	// bit/s of the congestion controller and the media.
+	StartBitrate uint
+	MinBitrate   uint
+	MaxBitrate   uint
+	// EncoderMinBitrate, EncoderMaxBitrate and EncoderHeadroom configure
+	// the encoder bitrate derived from the target bitrate. A maximum of 0
+	// means no limit.
+	EncoderMinBitrate uint
+	EncoderMaxBitrate uint
+	EncoderHeadroom   float64
+	// Priority of the media when sharing the rate with the other streams of
+	// the sender (RFC 8699).
+	Priority float64
+	// Probe probes for capacity using RTP padding while the media is
+	// application limited, requires RTPCC.
+	Probe bool
+	// FreezeAppLimited keeps the target bitrate from increasing while the
+	// media is application limited, requires RTPCC.
+	FreezeAppLimited bool
+	// LocalRFC8888 generates RFC 8888 feedback from QUIC acknowledgments.
+	LocalRFC8888 bool
+
+	// MetricsLog is the log file the congestion control metrics are
+	// sampled to every MetricsInterval. Disabled if empty.
+	MetricsLog      string
+	MetricsInterval time.Duration
+
+	// REDDistance is the number of previous payloads repeated in RED
+	// packets of the Opus stream, 0 disables RED. RED requires Codec 'opus'
+	// or an AudioSource.
+	REDDistance uint
+	// Reliability is the policy selecting packets sent on QUIC streams:
+	// 'none', 'keyframes' or 'h264-headers'.
+	Reliability string
+	// FECGroupSize is the number of QUIC datagrams protected by one repair
+	// datagram, 0 disables FEC.
+	FECGroupSize int
+	// AggregationDelay is the maximum time small QUIC datagrams are held
+	// back for aggregation, 0 disables aggregation.
+	AggregationDelay time.Duration
+	// SendQueueBudget is the maximum time packets wait in the QUIC send
+	// queue before they are dropped according to SendQueuePolicy, 'packets'
+	// or 'frames'. 0 disables the queue.
+	SendQueueBudget time.Duration
+	SendQueuePolicy string
+	// BackpressureTimeout is the maximum time the QUIC transport holds a
+	// packet back before it drops it and throttles the media, 0 blocks the
+	// media until the packet is sent.
+	BackpressureTimeout time.Duration
+	// UDPGSO lets the kernel segment batches of packets of the same size
+	// (UDP GSO, Linux and transport 'udp' only).
+	UDPGSO bool
+	// PacingInterval is the interval of the QUIC pacer releasing at most
+	// PacingBurst bytes at once, 0 disables the pacer.
+	PacingInterval time.Duration
+	PacingBurst    int
+	// PlayoutDelay of the receiver used to estimate its buffer occupancy,
+	// logged to BufferHealthLog. 0 disables the estimation.
+	PlayoutDelay    time.Duration
+	BufferHealthLog string
+	// AbsCaptureTime stamps the capture time of each frame into its last
+	// packet, from which the receiver measures the glass-to-glass latency.
+	AbsCaptureTime bool
+	// DataStream sends random data on a QUIC stream.
+	DataStream bool
+
+	// BackupAddr is the receiver to fail over to after FailoverTimeout
+	// without traffic from the receiver (QUIC only).
+	BackupAddr      string
+	FailoverTimeout time.Duration
+	// Reconnect reconnects to the receiver if the connection is lost,
+	// keeping the media source running (QUIC only). The wait between
+	// attempts starts at ReconnectBackoff and doubles up to
+	// ReconnectMaxBackoff. The sender stops after ReconnectAttempts failed
+	// attempts, 0 means never.
+	Reconnect           bool
+	ReconnectAttempts   int
+	ReconnectBackoff    time.Duration
+	ReconnectMaxBackoff time.Duration
+	// AutoTimeout is the time each transport tried with Transport 'auto'
+	// gets to connect.
+	AutoTimeout time.Duration
+
+	// PathCacheFile caches path properties per receiver address. Disabled
+	// if empty. ReusePathEstimates starts at the cached target bitrate.
+	PathCacheFile      string
+	ReusePathEstimates bool
+
+	// BWEEvalCapacity or BWEEvalTrace is the known bottleneck capacity the
+	// bandwidth estimation is evaluated against, logged to BWEEvalLog.
+	BWEEvalCapacity uint
+	BWEEvalTrace    string
+	BWEEvalLog      string
+}
+
+// Validate returns the first problem of the configuration reported by
+// Problems.
+func (c *SenderConfig) Validate() error {
+	if errs := c.Problems(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Problems returns all problems of the configuration which can be found
+// without opening files or sockets.
+func (c *SenderConfig) Problems() []error {
+	errs := c.Config.validate()
+	for _, validate := range []func() error{
+		c.validateRTPCC,
+		c.validateBitrates,
+		c.validateCCSwitches,
+		c.validateBWEEvaluation,
+		c.validateQUICCCTarget,
+		c.validateStreams,
+		c.validateContentHint,
+		c.validateEncoder,
+		c.validatePacketizer,
+		c.validateAdaptation,
+		c.validateRED,
+		c.transportOptions(nil, nil, nil).Validate,
+	} {
+		if err := validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// isRTPCCAlgorithm returns whether algorithm is a built-in RTP congestion
+// controller or was added using cc.Register.
+func isRTPCCAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case cc.SCReAM.String(), cc.GCC.String(), cc.NADA.String():
+		return true
+	}
+	_, ok := cc.Lookup(algorithm)
+	return ok
+}
+
+func (c *SenderConfig) validateRTPCC() error {
+	if c.RTPCC == cc.NONE.String() || isRTPCCAlgorithm(c.RTPCC) {
+		return nil
+	}
+	return fmt.Errorf("%w: unknown RTP congestion control %v, registered algorithms: %v", errInvalidCCConfig, c.RTPCC, cc.Registered())
+}
+
+// validateBitrates checks that the start bitrate is within the bounds.
+func (c *SenderConfig) validateBitrates() error {
+	if c.MinBitrate > c.MaxBitrate {
+		return fmt.Errorf("%w: minimum bitrate %v is higher than maximum bitrate %v", errInvalidCCConfig, c.MinBitrate, c.MaxBitrate)
+	}
+	if c.StartBitrate < c.MinBitrate || c.StartBitrate > c.MaxBitrate {
+		return fmt.Errorf("%w: start bitrate %v is not in [%v, %v]", errInvalidCCConfig, c.StartBitrate, c.MinBitrate, c.MaxBitrate)
+	}
+	return nil
+}
+
+func (c *SenderConfig) validateCCSwitches() error {
+	for _, s := range c.CCSwitches {
+		if !isRTPCCAlgorithm(s.Algorithm) {
+			return fmt.Errorf("%w: unknown algorithm %v in congestion control switch", errInvalidCCConfig, s.Algorithm)
+		}
+	}
+	if len(c.CCSwitches) > 0 && c.RTPCC == cc.NONE.String() {
+		return fmt.Errorf("%w: switching the congestion controller requires an RTP congestion controller", errInvalidCCConfig)
+	}
+	return nil
+}
+
+func (c *SenderConfig) validateBWEEvaluation() error {
+	if c.BWEEvalCapacity == 0 && len(c.BWEEvalTrace) == 0 {
+		return nil
+	}
+	if c.RTPCC == cc.NONE.String() && !c.QUICCCTarget {
+		return fmt.Errorf("%w: bandwidth estimation evaluation requires an RTP congestion controller or QUICCCTarget", errInvalidBWEEvaluation)
+	}
+	return nil
+}
+
+// validateQUICCCTarget checks the requirements of QUICCCTarget.
+func (c *SenderConfig) validateQUICCCTarget() error {
+	if !c.QUICCCTarget {
+		return nil
+	}
+	algorithm := cc.AlgorithmFromString(c.QUICCC)
+	if algorithm != cc.BBR && algorithm != cc.Copa {
+		return fmt.Errorf("%w: QUICCCTarget requires QUIC congestion control 'bbr' or 'copa', got %v", errInvalidCCConfig, c.QUICCC)
+	}
+	if c.RTPCC != cc.NONE.String() {
+		return fmt.Errorf("%w: QUICCCTarget can't be combined with RTP congestion control %v", errInvalidCCConfig, c.RTPCC)
+	}
+	return nil
+}
+
+func (c *SenderConfig) validateStreams() error {
+	if c.Streams < 1 {
+		return fmt.Errorf("%w: at least one stream required, got %v", errInvalidStreams, c.Streams)
+	}
+	if len(c.StreamSources) > c.Streams {
+		return fmt.Errorf("%w: %v sources given for %v streams", errInvalidStreams, len(c.StreamSources), c.Streams)
+	}
+	if len(c.AudioSource) > 0 && c.Codec == media.Opus {
+		return fmt.Errorf("%w: an audio stream can only be added to video streams, got codec %v", errInvalidStreams, c.Codec)
+	}
+	return nil
+}
+
+func (c *SenderConfig) validateRED() error {
+	if _, ok := c.opusPayloadType(); c.REDDistance > 0 && !ok {
+		return fmt.Errorf("%w: RED requires codec %v or an audio source", errInvalidStreams, media.Opus)
+	}
+	return nil
+}
+
+// opusPayloadType returns the payload type of the Opus stream, if there is
+// one.
+func (c *SenderConfig) opusPayloadType() (uint8, bool) {
+	if c.Codec == media.Opus {
+		return uint8(c.PayloadType), true
+	}
+	if len(c.AudioSource) > 0 {
+		return uint8(c.AudioPayloadType), true
+	}
+	return 0, false
+}
+
+func (c *SenderConfig) validateContentHint() error {
+	switch c.ContentHint {
+	case "", "auto", media.ContentCamera, media.ContentScreen:
+		return nil
+	}
+	return fmt.Errorf("%w: %v, expected 'auto', '%v' or '%v'", errInvalidContentHint, c.ContentHint, media.ContentCamera, media.ContentScreen)
+}
+
+func (c *SenderConfig) validateEncoder() error {
+	switch c.Encoder {
+	case "", media.EncoderSoftware:
+		return nil
+	case media.EncoderVAAPI, media.EncoderNVENC:
+		if c.Codec != "h264" && c.Codec != "h265" {
+			return fmt.Errorf("%w: %v supports the codecs h264 and h265 only, got %v", errInvalidEncoder, c.Encoder, c.Codec)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %v, expected one of %v", errInvalidEncoder, c.Encoder, media.Encoders)
+}
+
+func (c *SenderConfig) validatePacketizer() error {
+	switch c.Packetizer {
+	case "", "auto", "go", "gstreamer":
+		return nil
+	}
+	return fmt.Errorf("%w: %v, expected 'auto', 'go' or 'gstreamer'", errInvalidPacketizer, c.Packetizer)
+}
+
+func (c *SenderConfig) validateAdaptation() error {
+	if c.Adaptation && c.MediaBackend == media.BackendFFmpeg {
+		return fmt.Errorf("%w: resolution and frame rate adaptation requires the %v backend", errInvalidMediaBackend, media.BackendGstreamer)
+	}
+	return nil
+}
+
+// content returns the content hint of the video read from source.
+func (c *SenderConfig) content(source string) string {
+	if c.ContentHint == media.ContentCamera || c.ContentHint == media.ContentScreen {
+		return c.ContentHint
+	}
+	if media.IsScreenCapture(source) {
+		return media.ContentScreen
+	}
+	return media.ContentCamera
+}
+
+// screenContent returns whether the sender is tuned for screen content,
+// i.e., whether the first stream is screen content.
+func (c *SenderConfig) screenContent() bool {
+	return c.content(c.streamSource(0)) == media.ContentScreen
+}
+
+// streamSource returns the source of the stream with index i.
+func (c *SenderConfig) streamSource(i int) string {
+	if i < len(c.StreamSources) && len(c.StreamSources[i]) > 0 {
+		return c.StreamSources[i]
+	}
+	return c.Source
+}
+
+// streamCount returns the number of streams including the audio stream.
+func (c *SenderConfig) streamCount() int {
+	if len(c.AudioSource) > 0 {
+		return c.Streams + 1
+	}
+	return c.Streams
+}
+
+// transportOptions returns the transport options of the sender.
+func (c *SenderConfig) transportOptions(pacer *quic.Pacer, pathCache *quic.PathCache, bus *events.Bus) *options.Transport {
+	t := c.Config.transportOptions()
+	t.BackupAddr = c.BackupAddr
+	t.FailoverTimeout = c.FailoverTimeout
+	t.Reconnect = c.Reconnect
+	t.ReconnectAttempts = c.ReconnectAttempts
+	t.ReconnectBackoff = c.ReconnectBackoff
+	t.ReconnectMaxBackoff = c.ReconnectMaxBackoff
+	t.LocalRFC8888 = c.LocalRFC8888
+	t.DataStream = c.DataStream
+	t.FECGroupSize = c.FECGroupSize
+	t.AggregationDelay = c.AggregationDelay
+	t.SendQueueBudget = c.SendQueueBudget
+	t.SendQueuePolicy = c.SendQueuePolicy
+	t.BackpressureTimeout = c.BackpressureTimeout
+	t.UDPGSO = c.UDPGSO
+	t.Pacer = pacer
+	t.PathCache = pathCache
+	t.Reliability = c.Reliability != "none" || c.screenContent()
+	t.Events = bus
+	return t
+}
+
+type BandwidthEstimator interface {
+	SetMedia(rtp.Media)
+}
+
+// Sender sends media to a receiver. The control commands read from
+// Config.Control are 'pause [ssrc]' and 'resume [ssrc]', which stop and
+// continue sending a flow or all flows.
+type Sender struct {
+	config SenderConfig
+
+	wg        sync.WaitGroup
+	bwe       BandwidthEstimator
+	evaluator *rtp.BWEEvaluator
+	pathCache *quic.PathCache
+
+	// fse couples the rates of all media streams of the sender.
+	fse *fse.FSE
+
+	// metrics are the congestion controllers sampled for MetricsLog and
+	// MetricsAddr.
+	metrics map[string]cc.MetricsSource
+
+	ccSwitchLock sync.Mutex
+	ccSwitch     *rtp.CCSwitch
+	currentCC    string
+
+	rateCap      *rateCap
+	sourcesLock  sync.Mutex
+	sources      []MediaSource
+	lastKeyframe time.Time
+	bufferHealth *rtp.BufferHealth
+	pacer        *quic.Pacer
+	traffic      *rtp.TrafficCounter
+	flowPause    *rtp.FlowPause
+	pcap         *rtp.PcapDump
+	layers       *rtp.LayerSubscription
+	frameStats   *media.FrameStats
+
+	transport *options.Transport
+	events    *events.Bus
+}
+
+// NewSender creates a sender from the defaults modified by opts. It returns
+// an error if the configuration is invalid.
+func NewSender(opts ...SenderOption) (*Sender, error) {
+	c := SenderConfig{
+		Config:          defaultConfig(),
+		Source:          "videotestsrc",
+		RTSPLatency:     200 * time.Millisecond,
+		RTPCC:           cc.NONE.String(),
+		StartBitrate:    100_000,
+		MinBitrate:      100_000,
+		MaxBitrate:      100_000_000,
+		Priority:        1,
+		Streams:         1,
+		AudioBitrate:    32_000,
+		ContentHint:     "auto",
+		Encoder:         media.EncoderSoftware,
+		Packetizer:      "auto",
+		Syncodec:        media.DefaultSyncodecModel(),
+		MetricsInterval: 100 * time.Millisecond,
+		Reliability:     "none",
+		PacingBurst:     4800,
+		SendQueuePolicy: quic.SendQueueDropFrames,
+		FailoverTimeout: 2 * time.Second,
+		AutoTimeout:     3 * time.Second,
+
+		ReconnectBackoff:    500 * time.Millisecond,
+		ReconnectMaxBackoff: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &Sender{
+		config:    c,
+		fse:       fse.New(),
+		rateCap:   &rateCap{},
+		flowPause: rtp.NewFlowPause(),
+		events:    events.NewBus(),
+	}, nil
+}
+
+// Events returns the bus publishing the rate changes, acknowledged and lost
+// packets and the closing of the connection of the sender.
+func (s *Sender) Events() *events.Bus {
+	return s.events
+}
+
+// newBWEEvaluator returns an evaluator if a ground truth capacity was
+// configured and nil otherwise.
+func (s *Sender) newBWEEvaluator() (*rtp.BWEEvaluator, error) {
+	var capacity *rtp.CapacityTrace
+	switch {
+	case len(s.config.BWEEvalTrace) > 0:
+		trace, err := rtp.LoadCapacityTrace(s.config.BWEEvalTrace)
+		if err != nil {
+			return nil, err
+		}
+		capacity = trace
+	case s.config.BWEEvalCapacity > 0:
+		capacity = rtp.ConstantCapacity(s.config.BWEEvalCapacity)
+	default:
+		return nil, nil
+	}
+	return rtp.NewBWEEvaluator(capacity, s.config.BWEEvalLog)
+}
+
+// newBandwidthEstimator creates the estimator which passes the target bitrate
+// of the congestion controller to the media source.
+func (s *Sender) newBandwidthEstimator() (*rtp.BandwidthEstimator, error) {
+	bwe, err := rtp.NewBandwidthEstimator(s.config.CCDump)
+	if err != nil {
+		return nil, err
+	}
+	bwe.SetEvaluator(s.evaluator)
+	bwe.SetBitrateLimits(int(s.config.MinBitrate), int(s.config.MaxBitrate))
+	bwe.SetEventBus(s.events)
+	bwe.JoinFSE(s.fse, s.config.Priority, int(s.config.StartBitrate))
+	s.bwe = bwe
+	return bwe, nil
+}
+
+func (s *Sender) setupInterceptor(ctx context.Context) (*interceptor.Registry, error) {
+	rtpOptions, err := s.config.srtpOptions()
+	if err != nil {
+		return nil, err
+	}
+	pcap, pcapDump, err := s.config.pcapOptions(true)
+	if err != nil {
+		return nil, err
+	}
+	s.pcap = pcapDump
+	rtpOptions = append(rtpOptions, pcap...)
+	if len(s.config.SyncodecStatsLog) > 0 {
+		s.frameStats, err = media.NewFrameStats(s.config.SyncodecStatsLog)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the traffic is counted for the summary on shutdown, the metrics
+	// endpoint, the stats, the line protocol export and the dashboard
+	s.traffic = rtp.NewTrafficCounter()
+	rtpOptions = append(rtpOptions, rtp.RegisterTrafficCounter(s.traffic))
+	rtpOptions = append(rtpOptions, rtp.RegisterSenderPacketLog(s.config.RTPDumpFile, s.config.RTCPDumpFile, s.config.DumpFormat))
+	if len(s.config.AudioSource) > 0 {
+		// the receiver needs the sender reports to play audio and video in
+		// sync
+		rtpOptions = append(rtpOptions, rtp.RegisterSenderReports(senderReportInterval, s.config.clockRate))
+	}
+	if s.config.AbsCaptureTime {
+		rtpOptions = append(rtpOptions, rtp.RegisterAbsCaptureTime())
+	}
+	if s.config.PlayoutDelay > 0 {
+		// the estimator needs the sequence numbers of the packets on the
+		// wire, so it is registered before interceptors renumbering packets
+		s.bufferHealth, err = rtp.NewBufferHealth(s.config.PlayoutDelay, videoClockRate, s.config.BufferHealthLog)
+		if err != nil {
+			return nil, err
+		}
+		rtpOptions = append(rtpOptions, rtp.RegisterBufferHealth(s.bufferHealth))
+	}
+
+	s.evaluator, err = s.newBWEEvaluator()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.RTPCC != cc.NONE.String() {
+		if err := s.setupCongestionController(ctx, &rtpOptions); err != nil {
+			return nil, err
+		}
+	}
+	freezeAppLimited := s.config.FreezeAppLimited
+	if s.config.screenContent() && s.config.RTPCC != cc.NONE.String() {
+		// a static screen leaves the media application limited, the
+		// target must not grow beyond what the path was tested with
+		log.Printf("screen content: freezing the target bitrate while application limited")
+		freezeAppLimited = true
+	}
+	if freezeAppLimited {
+		bwe, ok := s.bwe.(*rtp.BandwidthEstimator)
+		if !ok {
+			return nil, fmt.Errorf("%w: FreezeAppLimited requires an RTP congestion controller", errInvalidCCConfig)
+		}
+		detector := rtp.NewAppLimitedDetector(appLimitedThreshold)
+		bwe.SetAppLimitedDetector(detector)
+		rtpOptions = append(rtpOptions, rtp.RegisterAppLimitedDetector(detector))
+	}
+	if s.config.Probe {
+		if err := s.registerProber(&rtpOptions); err != nil {
+			return nil, err
+		}
+	}
+	if opusPayloadType, ok := s.config.opusPayloadType(); ok && s.config.REDDistance > 0 {
+		// Register after the congestion controller so that RED
+		// encapsulation happens before congestion control and the redundant
+		// data is accounted for in the send rate. Only the small Opus
+		// packets are encapsulated, video packets with redundant blocks
+		// would exceed the datagram size.
+		rtpOptions = append(rtpOptions, rtp.RegisterRED(uint8(s.config.REDPayloadType), opusPayloadType, int(s.config.REDDistance)))
+	}
+	policy, err := rtp.ReliabilityPolicyFromString(s.config.Reliability)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		rtpOptions = append(rtpOptions, rtp.RegisterPrioritizer(policy))
+	} else if s.config.screenContent() {
+		log.Printf("screen content: sending all packets reliably")
+		rtpOptions = append(rtpOptions, rtp.RegisterScreenContentPrioritizer())
+	}
+	// Register last, so that the congestion controller and the prober
+	// don't see the packets of paused flows and unsubscribed layers.
+	s.layers = rtp.NewLayerSubscription()
+	rtpOptions = append(rtpOptions, rtp.RegisterFlowPause(s.flowPause), rtp.RegisterLayerSubscription(s.layers))
+	rtpOptions = append(rtpOptions, rtp.RegisterREMBReader(rtp.NewREMBReader(s.rateCap.SetRemoteCap)))
+	rtpOptions = append(rtpOptions, rtp.RegisterKeyframeRequestReader(rtp.NewKeyframeRequestReader(s.onKeyframeRequest)))
+	return rtp.New(rtpOptions...)
+}
+
+// ccOptions returns the options registering the congestion controller
+// algorithm, which reports its estimator to bwe.
+func (s *Sender) ccOptions(algorithm string, bwe *rtp.BandwidthEstimator, initialBitrate int) ([]rtp.Option, error) {
+	return ccOptions(algorithm, bwe, initialBitrate, int(s.config.MinBitrate), int(s.config.MaxBitrate))
+}
+
+// ccOptions returns the options registering the congestion controller
+// algorithm with the given bitrates, which reports its estimator to bwe.
+func ccOptions(algorithm string, bwe *rtp.BandwidthEstimator, initialBitrate, minBitrate, maxBitrate int) ([]rtp.Option, error) {
+	switch algorithm {
+	case cc.SCReAM.String():
+		return []rtp.Option{rtp.RegisterSCReAM(bwe.OnNewSCReAMEstimator, initialBitrate, minBitrate, maxBitrate)}, nil
+	case cc.GCC.String():
+		// The header extension interceptor has to be registered after GCC,
+		// so that packets carry the transport-wide sequence number when
+		// GCC records them as sent.
+		return []rtp.Option{
+			rtp.RegisterGCC(bwe.OnNewGCCEstimator, initialBitrate, minBitrate, maxBitrate),
+			rtp.RegisterTWCCHeaderExtension(),
+		}, nil
+	case cc.NADA.String():
+		return []rtp.Option{rtp.RegisterNADA(bwe.OnNewNADAEstimator, initialBitrate, minBitrate, maxBitrate)}, nil
+	}
+	factory, ok := cc.Lookup(algorithm)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown congestion control algorithm %v", errInvalidCCConfig, algorithm)
+	}
+	return []rtp.Option{rtp.RegisterCongestionController(factory, cc.Config{
+		InitialBitrate: initialBitrate,
+		MinBitrate:     minBitrate,
+		MaxBitrate:     maxBitrate,
+		OnNewEstimator: bwe.OnNewEstimator,
+	})}, nil
+}
+
+// setupCongestionController starts the bandwidth estimator and adds the
+// congestion controller selected by RTPCC to rtpOptions. The congestion
+// controller is wrapped in a CCSwitch, so that it can be switched by the
+// scheduled CCSwitches and by SwitchCC.
+func (s *Sender) setupCongestionController(ctx context.Context, rtpOptions *[]rtp.Option) error {
+	bwe, err := s.newBandwidthEstimator()
+	if err != nil {
+		return err
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := bwe.Run(ctx); err != nil {
+			log.Printf("bwe.Run returned error: %v", err)
+		}
+	}()
+	opts, err := s.ccOptions(s.config.RTPCC, bwe, int(s.config.StartBitrate))
+	if err != nil {
+		return err
+	}
+	s.addMetricsSource("rtp", bwe)
+	s.currentCC = s.config.RTPCC
+	s.ccSwitch, err = rtp.NewCCSwitch(opts...)
+	if err != nil {
+		return err
+	}
+	*rtpOptions = append(*rtpOptions, rtp.RegisterCCSwitch(s.ccSwitch))
+	if len(s.config.CCSwitches) == 0 {
+		return nil
+	}
+	switches := make([]CCSwitch, len(s.config.CCSwitches))
+	copy(switches, s.config.CCSwitches)
+	sort.Slice(switches, func(i, j int) bool {
+		return switches[i].After < switches[j].After
+	})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.runCCSwitches(ctx, switches)
+	}()
+	return nil
+}
+
+// SwitchCC replaces the running congestion controller by algorithm. The new
+// congestion controller starts at the last target bitrate of the old one.
+// It requires an RTP congestion controller.
+func (s *Sender) SwitchCC(algorithm string) error {
+	s.ccSwitchLock.Lock()
+	defer s.ccSwitchLock.Unlock()
+
+	if s.ccSwitch == nil {
+		return fmt.Errorf("%w: switching the congestion controller requires an RTP congestion controller", errInvalidCCConfig)
+	}
+	bwe := s.bwe.(*rtp.BandwidthEstimator)
+	initial := bwe.Target()
+	if initial <= 0 {
+		initial = int(s.config.StartBitrate)
+	}
+	opts, err := s.ccOptions(algorithm, bwe, initial)
+	if err != nil {
+		return err
+	}
+	if err := s.ccSwitch.Switch(opts...); err != nil {
+		return err
+	}
+	log.Printf("switched congestion control from %v to %v at %v bit/s", s.currentCC, algorithm, initial)
+	s.currentCC = algorithm
+	return nil
+}
+
+func (s *Sender) runCCSwitches(ctx context.Context, switches []CCSwitch) {
+	start := time.Now()
+	for _, sw := range switches {
+		select {
+		case <-time.After(time.Until(start.Add(sw.After))):
+			if err := s.SwitchCC(sw.Algorithm); err != nil {
+				log.Printf("failed to switch congestion control to %v: %v", sw.Algorithm, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// registerProber adds a prober after the congestion controller, so that the
+// congestion controller accounts for the padding.
+func (s *Sender) registerProber(rtpOptions *[]rtp.Option) error {
+	bwe, ok := s.bwe.(*rtp.BandwidthEstimator)
+	if !ok {
+		return fmt.Errorf("%w: Probe requires an RTP congestion controller", errInvalidCCConfig)
+	}
+	prober, err := rtp.NewProber()
+	if err != nil {
+		return err
+	}
+	bwe.SetProber(prober)
+	*rtpOptions = append(*rtpOptions, rtp.RegisterProber(prober))
+	return nil
+}
+
+// Start connects to the receiver and sends media until ctx is done or the
+// media source stops.
+func (s *Sender) Start(ctx context.Context) error {
+	ctx, cancel := s.config.withDuration(ctx)
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+	if err := s.loadPathCache(); err != nil {
+		return err
+	}
+	if s.config.PacingInterval > 0 {
+		var err error
+		s.pacer, err = quic.NewPacer(s.config.PacingInterval, s.config.PacingBurst, s.config.StartBitrate)
+		if err != nil {
+			return err
+		}
+	}
+	s.transport = s.config.transportOptions(s.pacer, s.pathCache, s.events)
+	if err := s.transport.Validate(); err != nil {
+		return err
+	}
+	if len(s.config.SignalingURL) > 0 {
+		if err := s.negotiate(ctx); err != nil {
+			return err
+		}
+	}
+	in, err := s.setupInterceptor(ctx)
+	if err != nil {
+		return err
+	}
+	if s.pcap != nil {
+		defer s.pcap.CloseFile()
+	}
+	if s.frameStats != nil {
+		defer s.frameStats.Close()
+	}
+	var sender interceptor.RTPWriter
+	var conn io.Closer
+	if s.transport.Transport == options.Auto {
+		sender, conn, err = s.startAutoSender(ctx, in)
+	} else {
+		var senderFactory func(context.Context, *interceptor.Registry) (interceptor.RTPWriter, io.Closer, error)
+		senderFactory, err = s.transportFactory(s.transport.Transport)
+		if err == nil {
+			sender, conn, err = senderFactory(ctx, in)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	writers, err := s.openStreams(sender, conn)
+	if err != nil {
+		if cerr := conn.Close(); cerr != nil {
+			log.Printf("failed to close transport: %v", cerr)
+		}
+		return err
+	}
+	if q, ok := conn.(*quic.Sender); ok {
+		go func() {
+			select {
+			case <-q.Lost():
+				log.Printf("connection lost, stopping the media")
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	s.startObservers(ctx)
+	start := time.Now()
+	err = s.startMedia(ctx, writers)
+	s.shutdown(conn, time.Since(start))
+	return err
+}
+
+// openStreams opens the media streams after the first one, which was opened
+// when connecting, and returns the writers of all streams.
+func (s *Sender) openStreams(first interceptor.RTPWriter, conn io.Closer) ([]interceptor.RTPWriter, error) {
+	writers := []interceptor.RTPWriter{first}
+	for len(writers) < s.config.streamCount() {
+		var w interceptor.RTPWriter
+		switch c := conn.(type) {
+		case *quic.Sender:
+			var err error
+			w, err = c.NewMediaStream()
+			if err != nil {
+				return nil, err
+			}
+		case *tcp.Sender:
+			w = c.NewMediaStream()
+		case *udp.Sender:
+			w = c.NewMediaStream()
+		default:
+			return nil, fmt.Errorf("%w: transport %v can't open more streams", errInvalidStreams, s.transport.Transport)
+		}
+		writers = append(writers, w)
+	}
+	if s.evaluator != nil {
+		for i, w := range writers {
+			writers[i] = s.evaluator.Writer(w)
+		}
+	}
+	return writers, nil
+}
+
+// shutdown closes the transport once the media source stopped, which sends
+// the remaining packets and an RTCP BYE, and logs a summary of the session.
+func (s *Sender) shutdown(conn io.Closer, d time.Duration) {
+	if err := conn.Close(); err != nil {
+		log.Printf("failed to close transport: %v", err)
+	}
+	logSummary(s.traffic.Flows(), rtp.Sent, d)
+	if qs, ok := conn.(*quic.Sender); ok {
+		stats := qs.Stats()
+		log.Printf("summary: %v datagrams lost, %v QUIC packets carrying stream data retransmitted", stats.LostDatagrams, stats.Retransmits)
+		if stats.DroppedAcks > 0 {
+			log.Printf("summary: %v acknowledgments dropped by the local RFC 8888 feedback generator", stats.DroppedAcks)
+		}
+	}
+}
+
+// startObservers starts the logs, exports and the dashboard of the sender
+// and reading control commands.
+func (s *Sender) startObservers(ctx context.Context) {
+	if len(s.config.MetricsLog) > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := logging.LogMetrics(ctx, s.config.MetricsLog, s.config.MetricsInterval, s.metrics); err != nil {
+				log.Printf("failed to log congestion control metrics: %v", err)
+			}
+		}()
+	}
+	if len(s.config.StatsFile) > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.config.writeStats(ctx, s.metrics, s.traffic, rtp.Sent)
+		}()
+	}
+	if len(s.config.InfluxURL) > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.config.pushInflux(ctx, "sender", s.transport.Transport, s.config.RTPCC, s.metrics, s.traffic)
+		}()
+	}
+	if s.config.LatencyInterval > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			logging.LogLatencyPercentiles(ctx, s.config.LatencyInterval)
+		}()
+	}
+	if s.config.BackpressureTimeout > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runBackpressure(ctx)
+		}()
+	}
+	if len(s.config.MetricsAddr) > 0 {
+		e := metrics.NewExporter(s.traffic)
+		for name, source := range s.metrics {
+			e.AddSource(name, source)
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.config.serveMetrics(ctx, e)
+		}()
+	}
+	ctrl := s.controller()
+	if s.config.Control != nil {
+		// not waited for, reading blocks until the next line
+		go ctrl.run(ctx, s.config.Control)
+	}
+	if len(s.config.ControlAddr) > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := ctrl.serveHTTP(ctx, s.config.ControlAddr); err != nil {
+				log.Printf("failed to serve HTTP control service: %v", err)
+			}
+		}()
+	}
+	if len(s.config.ControlSocket) > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := ctrl.serveRPC(ctx, s.config.ControlSocket); err != nil {
+				log.Printf("failed to serve control socket: %v", err)
+			}
+		}()
+	}
+	if s.config.Dashboard {
+		d := dashboard.New(os.Stdout, fmt.Sprintf("rtp-over-quic sender (%v to %v)", s.transport.Transport, s.config.Addr), s.traffic)
+		for name, source := range s.metrics {
+			d.AddSource(name, source)
+		}
+		if bwe, ok := s.bwe.(*rtp.BandwidthEstimator); ok {
+			d.AddQueue(bwe)
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			runDashboard(ctx, d)
+		}()
+	}
+}
+
+// controller returns the controller executing the control commands of the
+// sender.
+func (s *Sender) controller() *controller {
+	return &controller{
+		flowPause:     s.flowPause,
+		rateCap:       s.rateCap,
+		switchCC:      s.SwitchCC,
+		forceKeyframe: s.ForceKeyframe,
+		stats: func() controlStats {
+			stats := controlStats{
+				Time:        time.Now(),
+				BitrateCap:  s.rateCap.Cap(),
+				Flows:       newControlFlowStats(s.traffic.Flows()),
+				Controllers: map[string]cc.Metrics{},
+			}
+			s.ccSwitchLock.Lock()
+			stats.CongestionControl = s.currentCC
+			s.ccSwitchLock.Unlock()
+			for name, source := range s.metrics {
+				stats.Controllers[name] = source.Metrics()
+			}
+			return stats
+		},
+	}
+}
+
+// SetBitrateCap caps the target bitrate passed to the media sources, 0
+// removes the cap. The congestion controller is not affected.
+func (s *Sender) SetBitrateCap(bitrate uint) {
+	s.rateCap.SetCap(bitrate)
+}
+
+// BitrateCap returns the cap set by SetBitrateCap, 0 if the target bitrate is
+// not capped.
+func (s *Sender) BitrateCap() uint {
+	return s.rateCap.Cap()
+}
+
+// TargetBitrate returns the target bitrate of the media before capping.
+func (s *Sender) TargetBitrate() uint {
+	return s.rateCap.Target()
+}
+
+// Pause stops sending the flow ssrc, 0 pauses all flows. The receiver is
+// notified by RTCP APP packets.
+func (s *Sender) Pause(ssrc uint32) error {
+	return s.flowPause.Pause(ssrc)
+}
+
+// Resume continues sending the flow ssrc, 0 resumes all flows.
+func (s *Sender) Resume(ssrc uint32) error {
+	return s.flowPause.Resume(ssrc)
+}
+
+// ForceKeyframe requests a keyframe from all media sources which support
+// it.
+func (s *Sender) ForceKeyframe() error {
+	s.sourcesLock.Lock()
+	defer s.sourcesLock.Unlock()
+	forced := false
+	for _, ms := range s.sources {
+		if f, ok := ms.(keyframeForcer); ok {
+			err := f.ForceKeyframe()
+			if errors.Is(err, errUnsupportedCommand) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			forced = true
+		}
+	}
+	if !forced {
+		return fmt.Errorf("%w: the media sources can't force keyframes", errUnsupportedCommand)
+	}
+	return nil
+}
+
+// onKeyframeRequest forces a keyframe for a PLI or FIR of a receiver, at
+// most once per keyframeRequestInterval. A relay sends them when a receiver
+// joins or requests a keyframe itself.
+func (s *Sender) onKeyframeRequest(ssrc uint32) {
+	s.sourcesLock.Lock()
+	now := time.Now()
+	if now.Sub(s.lastKeyframe) < keyframeRequestInterval {
+		s.sourcesLock.Unlock()
+		return
+	}
+	s.lastKeyframe = now
+	s.sourcesLock.Unlock()
+	if err := s.ForceKeyframe(); err != nil {
+		log.Printf("failed to force keyframe requested for SSRC %v: %v", ssrc, err)
+	}
+}
+
+// addMetricsSource adds a congestion controller sampled for MetricsLog and
+// MetricsAddr.
+func (s *Sender) addMetricsSource(name string, source cc.MetricsSource) {
+	if s.metrics == nil {
+		s.metrics = map[string]cc.MetricsSource{}
+	}
+	s.metrics[name] = source
+}
+
+func (s *Sender) loadPathCache() error {
+	if len(s.config.PathCacheFile) == 0 {
+		return nil
+	}
+	pathCache, err := quic.LoadPathCache(s.config.PathCacheFile)
+	if err != nil {
+		return err
+	}
+	s.pathCache = pathCache
+	addr := s.config.Addr
+	if p, ok := pathCache.Get(addr); ok {
+		log.Printf("found cached path properties for %v from %v: minRTT=%v, sRTT=%v, target=%v", addr, p.LastSeen, p.MinRTT, p.SmoothedRTT, p.TargetBitrate)
+		if s.config.ReusePathEstimates && p.TargetBitrate > 0 {
+			// the cached estimate may be outside of the configured bounds
+			s.config.StartBitrate = p.TargetBitrate
+			if s.config.StartBitrate < s.config.MinBitrate {
+				s.config.StartBitrate = s.config.MinBitrate
+			}
+			if s.config.StartBitrate > s.config.MaxBitrate {
+				s.config.StartBitrate = s.config.MaxBitrate
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Sender) transportFactory(transport string) (func(context.Context, *interceptor.Registry) (interceptor.RTPWriter, io.Closer, error), error) {
+	switch transport {
+	case "quic", "quic-dgram", "quic-stream", "quic-prio", "moq":
+		return s.startQUICSender, nil
+	case "udp":
+		return s.startUDPSender, nil
+	case "tcp":
+		return s.startTCPSender, nil
+	}
+	return nil, fmt.Errorf("%w: %v", errInvalidTransport, transport)
+}
+
+// startAutoSender connects using the first of options.AutoTransports which
+// supports the settings and connects within AutoTimeout.
+func (s *Sender) startAutoSender(ctx context.Context, ir *interceptor.Registry) (interceptor.RTPWriter, io.Closer, error) {
+	auto := s.transport
+	for _, candidate := range options.AutoTransports {
+		t := auto.WithTransport(candidate)
+		if err := t.Validate(); err != nil {
+			log.Printf("skipping transport %v: %v", candidate, err)
+			continue
+		}
+		s.transport = t
+		sender, conn, err := s.tryTransport(ctx, ir, candidate)
+		if err != nil {
+			log.Printf("failed to connect using transport %v: %v", candidate, err)
+			continue
+		}
+		log.Printf("using transport %v", candidate)
+		return sender, conn, nil
+	}
+	s.transport = auto
+	return nil, nil, fmt.Errorf("%w: tried %v", errNoTransport, strings.Join(options.AutoTransports, ", "))
+}
+
+// tryTransport starts a sender using transport and gives up after
+// AutoTimeout. The sender keeps running until ctx is done.
+func (s *Sender) tryTransport(ctx context.Context, ir *interceptor.Registry, transport string) (interceptor.RTPWriter, io.Closer, error) {
+	senderFactory, err := s.transportFactory(transport)
+	if err != nil {
+		return nil, nil, err
+	}
+	attemptCtx, cancel := context.WithCancel(ctx)
+	type result struct {
+		writer interceptor.RTPWriter
+		conn   io.Closer
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		writer, conn, err := senderFactory(attemptCtx, ir)
+		done <- result{writer, conn, err}
+	}()
+	timer := time.NewTimer(s.config.AutoTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			cancel()
+			return nil, nil, r.err
+		}
+		go func() {
+			<-ctx.Done()
+			cancel()
+		}()
+		return r.writer, r.conn, nil
+	case <-timer.C:
+		// the sender stops connecting when attemptCtx is cancelled
+		cancel()
+		return nil, nil, fmt.Errorf("%w: no connection after %v", errConnectTimeout, s.config.AutoTimeout)
+	}
+}
+
+func (s *Sender) startQUICSender(ctx context.Context, ir *interceptor.Registry) (interceptor.RTPWriter, io.Closer, error) {
+	opts, err := s.transport.QUICSenderOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	sender, err := quic.NewSender(ir, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := sender.Connect(ctx); err != nil {
+		return nil, nil, err
+	}
+	s.addMetricsSource("quic", sender)
+	if s.config.QUICCCTarget {
+		if err := s.runTransportRate(ctx, sender.TargetBitrate); err != nil {
+			return nil, nil, err
+		}
+	}
+	if bwe, ok := s.bwe.(*rtp.BandwidthEstimator); ok && s.pacer != nil {
+		bwe.SetPacer(s.pacer)
+	}
+	if s.bufferHealth != nil && s.pacer != nil {
+		s.bufferHealth.SetScheduler(s.pacer)
+	}
+	if s.config.DataStream {
+		ds, err := sender.NewDataStreamWithDefaultFlowID(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		go func() {
+			rand.Seed(time.Now().UnixNano())
+			buf := make([]byte, 1200)
+			for {
+				_, err := rand.Read(buf)
+				if err != nil {
+					log.Printf("failed to read random data, exiting data stream sender: %v", err)
+					return
+				}
+				_, err = ds.Write(buf)
+				if err != nil {
+					log.Printf("failed to send random data, exiting data stream sender: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	w, err := sender.NewMediaStream()
+	if err != nil {
+		return nil, nil, err
+	}
+	return w, sender, nil
+}
+
+// runTransportRate sets the media target bitrate to the rate of the QUIC
+// level congestion controller.
+func (s *Sender) runTransportRate(ctx context.Context, rate func() int) error {
+	bwe, err := s.newBandwidthEstimator()
+	if err != nil {
+		return err
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := bwe.RunTransport(ctx, rate); err != nil {
+			log.Printf("bwe.RunTransport returned error: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (s *Sender) startUDPSender(ctx context.Context, ir *interceptor.Registry) (interceptor.RTPWriter, io.Closer, error) {
+	opts, err := s.transport.UDPSenderOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	sender, err := udp.NewSender(ir, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := sender.Connect(ctx); err != nil {
+		return nil, nil, err
+	}
+	return sender.NewMediaStream(), sender, nil
+}
+
+func (s *Sender) startTCPSender(ctx context.Context, ir *interceptor.Registry) (interceptor.RTPWriter, io.Closer, error) {
+	opts, err := s.transport.TCPSenderOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	sender, err := tcp.NewSender(ir, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := sender.Connect(ctx); err != nil {
+		return nil, nil, err
+	}
+	return sender.NewMediaStream(), sender, nil
+}
+
+func (s *Sender) startMedia(ctx context.Context, writers []interceptor.RTPWriter) error {
+	sources := make([]MediaSource, 0, len(writers))
+	group := make(mediaGroup, 0, len(writers))
+	stopAll := func() {
+		for _, ms := range sources {
+			if err := ms.Stop(); err != nil {
+				log.Printf("failed to stop media source: %v", err)
+			}
+		}
+	}
+	startBitrate := s.config.StartBitrate / uint(s.config.Streams)
+	for i, w := range writers[:s.config.Streams] {
+		i := i
+		ms, err := s.supervise(fmt.Sprintf("media source %v", i), w, func(w interceptor.RTPWriter) (MediaSource, error) {
+			return s.newMediaSource(w, i, startBitrate)
+		})
+		if err != nil {
+			stopAll()
+			return err
+		}
+		sources = append(sources, ms)
+		rc, err := media.NewRateController(
+			ms,
+			media.MinTargetBitrate(s.config.EncoderMinBitrate),
+			media.MaxTargetBitrate(s.config.EncoderMaxBitrate),
+			media.Headroom(s.config.EncoderHeadroom),
+		)
+		if err != nil {
+			stopAll()
+			return err
+		}
+		group = append(group, rc)
+	}
+	if len(s.config.AudioSource) > 0 {
+		ms, err := s.supervise("audio source", writers[s.config.Streams], s.newAudioSource)
+		if err != nil {
+			stopAll()
+			return err
+		}
+		sources = append(sources, ms)
+	}
+	s.sourcesLock.Lock()
+	s.sources = sources
+	s.sourcesLock.Unlock()
+	s.rateCap.setMedia(group)
+	s.rateCap.SetTargetBitsPerSecond(s.config.StartBitrate)
+	var target rtp.Media = s.rateCap
+	if s.pathCache != nil {
+		target = &pathCacheMedia{
+			Media:     s.rateCap,
+			pathCache: s.pathCache,
+			addr:      s.config.Addr,
+		}
+	}
+	if s.bwe != nil {
+		s.bwe.SetMedia(target)
+	}
+	errCh := make(chan error, len(sources))
+	for _, ms := range sources {
+		go func(ms MediaSource) {
+			errCh <- ms.Play()
+		}(ms)
+	}
+	// the session ends when ctx is done or the first source stops
+	var err error
+	pending := len(sources)
+	select {
+	case err = <-errCh:
+		pending--
+	case <-ctx.Done():
+	}
+	stopAll()
+	for ; pending > 0; pending-- {
+		if e := <-errCh; err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// supervise creates a source writing to w with create, which is rebuilt if
+// it fails unless PipelineRestarts is 0.
+func (s *Sender) supervise(name string, w interceptor.RTPWriter, create func(w interceptor.RTPWriter) (MediaSource, error)) (MediaSource, error) {
+	if s.config.PipelineRestarts == 0 {
+		return create(w)
+	}
+	return newSupervisedSource(name, s.config.PipelineRestarts, s.events, w, create)
+}
+
+// gstPacketizer returns whether Gstreamer sources packetize the media in the
+// pipeline instead of Go.
+func (s *Sender) gstPacketizer() bool {
+	switch s.config.Packetizer {
+	case "go":
+		return false
+	case "gstreamer":
+		return true
+	}
+	return s.transport.Transport != "quic-prio"
+}
+
+// newMediaSource creates the source of the stream with index i.
+func (s *Sender) newMediaSource(w interceptor.RTPWriter, i int, targetBitrate uint) (MediaSource, error) {
+	source := s.config.streamSource(i)
+	factory := s.config.SourceFactory
+	if factory == nil {
+		model := s.config.Syncodec
+		model.Stats = s.frameStats
+		factory = sourceFactory{
+			source:        source,
+			gstPacketizer: s.gstPacketizer(),
+			rtspLatency:   s.config.RTSPLatency,
+			rtspTCP:       s.config.RTSPOverTCP,
+			backend:       s.config.MediaBackend,
+			syncodec:      model,
+		}
+	}
+	return factory.NewMediaSource(w, SourceParams{
+		Codec:         s.config.Codec,
+		PayloadType:   uint8(s.config.PayloadType),
+		SSRC:          s.config.SSRC + uint32(i),
+		Width:         s.config.Width,
+		Height:        s.config.Height,
+		Framerate:     s.config.Framerate,
+		TargetBitrate: targetBitrate,
+		Content:       s.config.content(source),
+		Encoder:       s.config.Encoder,
+		Adaptation:    s.config.Adaptation,
+	})
+}
+
+// newAudioSource creates the source of the Opus stream sent alongside the
+// video.
+func (s *Sender) newAudioSource(w interceptor.RTPWriter) (MediaSource, error) {
+	factory := sourceFactory{
+		source:        s.config.AudioSource,
+		gstPacketizer: s.gstPacketizer(),
+	}
+	return factory.NewMediaSource(w, SourceParams{
+		Codec:         media.Opus,
+		PayloadType:   uint8(s.config.AudioPayloadType),
+		SSRC:          s.config.SSRC + uint32(s.config.Streams),
+		TargetBitrate: s.config.AudioBitrate,
+	})
+}
+
+// pathCacheMedia records the latest target bitrate in the path cache.
+type pathCacheMedia struct {
+	rtp.Media
+	pathCache *quic.PathCache
+	addr      string
+}
+
+func (m *pathCacheMedia) SetTargetBitsPerSecond(r uint) {
+	m.pathCache.Update(m.addr, func(p *quic.PathProperties) {
+		p.TargetBitrate = r
+	})
+	m.Media.SetTargetBitsPerSecond(r)
+}
@@ -0,0 +1,185 @@
+package roq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/media"
+	"github.com/Willi-42/rtp-over-quic/options"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/Willi-42/rtp-over-quic/signaling"
+)
+
+var errSignaling = errors.New("signaling failed")
+
+// offer describes the streams of the sender: the video streams in a video
+// section and the Opus stream with RED in an audio section. The flow IDs are
+// the ones the QUIC sender assigns in the order the streams are opened, after
+// the data stream, if any.
+func (s *Sender) offer() *signaling.Description {
+	d := &signaling.Description{
+		Transport:  s.transport.Transport,
+		Direction:  signaling.SendOnly,
+		Extensions: []signaling.Extension{{ID: 1, URI: rtp.TransportCCURI}},
+		FlowIDs:    map[uint32]uint64{},
+	}
+	if s.config.AbsCaptureTime {
+		d.Extensions = append(d.Extensions, signaling.Extension{ID: 2, URI: rtp.AbsCaptureTimeURI})
+	}
+	opus := signaling.Codec{
+		PayloadType: uint8(s.config.AudioPayloadType),
+		Name:        media.Opus,
+		ClockRate:   media.OpusClockRate,
+		Channels:    2,
+	}
+	audio := signaling.MediaSection{Kind: signaling.Audio}
+	if s.config.Codec == media.Opus {
+		opus.PayloadType = uint8(s.config.PayloadType)
+		audio.Codecs = []signaling.Codec{opus}
+		for i := 0; i < s.config.Streams; i++ {
+			audio.SSRCs = append(audio.SSRCs, s.config.SSRC+uint32(i))
+		}
+	} else {
+		video := signaling.MediaSection{
+			Kind: signaling.Video,
+			Codecs: []signaling.Codec{{
+				PayloadType: uint8(s.config.PayloadType),
+				Name:        s.config.Codec,
+				ClockRate:   media.CodecClockRate(s.config.Codec),
+			}},
+		}
+		for i := 0; i < s.config.Streams; i++ {
+			video.SSRCs = append(video.SSRCs, s.config.SSRC+uint32(i))
+		}
+		d.Media = append(d.Media, video)
+		if len(s.config.AudioSource) > 0 {
+			audio.Codecs = []signaling.Codec{opus}
+			audio.SSRCs = []uint32{s.config.SSRC + uint32(s.config.Streams)}
+		}
+	}
+	if len(audio.Codecs) > 0 {
+		if s.config.REDDistance > 0 {
+			audio.Codecs = append(audio.Codecs, signaling.Codec{
+				PayloadType: uint8(s.config.REDPayloadType),
+				Name:        "red",
+				ClockRate:   media.OpusClockRate,
+				Channels:    2,
+			})
+		}
+		d.Media = append(d.Media, audio)
+	}
+	if strings.HasPrefix(s.transport.Transport, "quic") {
+		firstFlowID := uint64(0)
+		if s.config.DataStream {
+			firstFlowID = 1
+		}
+		for i := 0; i < s.config.streamCount(); i++ {
+			d.FlowIDs[s.config.SSRC+uint32(i)] = firstFlowID + uint64(i)
+		}
+	}
+	return d
+}
+
+// negotiate sends the offer of the sender to SignalingURL and checks that the
+// receiver accepted it and sends the feedback the congestion controller
+// needs.
+func (s *Sender) negotiate(ctx context.Context) error {
+	answer, err := signaling.Offer(ctx, s.config.SignalingURL, s.offer())
+	if err != nil {
+		return fmt.Errorf("%w: %v", errSignaling, err)
+	}
+	if _, ok := answer.MediaCodec(); !ok {
+		return fmt.Errorf("%w: receiver accepted no codec", errSignaling)
+	}
+	if s.config.RTPCC != cc.NONE.String() && !s.config.LocalRFC8888 && len(answer.Feedback) == 0 {
+		return fmt.Errorf("%w: RTP congestion control %v requires feedback, but the receiver sends none", errSignaling, s.config.RTPCC)
+	}
+	log.Printf("signaling: receiver accepted the offer, feedback: %v", answer.Feedback)
+	return nil
+}
+
+// transportFamily returns the server a transport connects to.
+func transportFamily(transport string) string {
+	if strings.HasPrefix(transport, "quic") {
+		return "quic"
+	}
+	return transport
+}
+
+// answer checks the offer of a sender against the configuration of the
+// receiver. With Codec 'auto', the offered payload types are added to the
+// codec map.
+func (r *Receiver) answer(offer *signaling.Description) (*signaling.Description, error) {
+	family := transportFamily(offer.Transport)
+	switch r.config.Transport {
+	case options.Auto:
+		if family != "quic" && family != "tcp" {
+			return nil, fmt.Errorf("receiver listens on QUIC and TCP, offered %v", offer.Transport)
+		}
+	default:
+		if family != transportFamily(r.config.Transport) {
+			return nil, fmt.Errorf("receiver listens on %v, offered %v", r.config.Transport, offer.Transport)
+		}
+	}
+	codec, ok := offer.MediaCodec()
+	if !ok {
+		return nil, errors.New("no media codec offered")
+	}
+	if r.config.Codec == "auto" {
+		r.codecsLock.Lock()
+		r.codecs[codec.PayloadType] = codec.Name
+		r.codecsLock.Unlock()
+	} else if codec.Name != strings.ToLower(r.config.Codec) || uint(codec.PayloadType) != r.config.PayloadType {
+		return nil, fmt.Errorf("receiver expects %v with payload type %v, offered %v with payload type %v", r.config.Codec, r.config.PayloadType, codec.Name, codec.PayloadType)
+	}
+	answer := &signaling.Description{
+		Transport:  offer.Transport,
+		Direction:  signaling.RecvOnly,
+		Extensions: offer.Extensions,
+		FlowIDs:    offer.FlowIDs,
+	}
+	for _, m := range offer.Media {
+		accepted := signaling.MediaSection{Kind: m.Kind, SSRCs: m.SSRCs}
+		for _, c := range m.Codecs {
+			switch {
+			case c == codec:
+			case c.Name == "red":
+				if uint(c.PayloadType) != r.config.REDPayloadType {
+					return nil, fmt.Errorf("receiver expects RED with payload type %v, offered %v", r.config.REDPayloadType, c.PayloadType)
+				}
+			case c.Name == media.Opus && m.Kind == signaling.Audio:
+				if uint(c.PayloadType) != r.config.AudioPayloadType {
+					return nil, fmt.Errorf("receiver expects audio with payload type %v, offered %v", r.config.AudioPayloadType, c.PayloadType)
+				}
+			default:
+				continue
+			}
+			accepted.Codecs = append(accepted.Codecs, c)
+		}
+		answer.Media = append(answer.Media, accepted)
+	}
+	switch r.config.RTCPFeedback {
+	case RTCP_RFC8888, RTCP_RFC8888_PION:
+		answer.Feedback = []string{"ack ccfb"}
+	case RTCP_TWCC:
+		answer.Feedback = []string{"transport-cc"}
+	}
+	log.Printf("signaling: accepted %v with payload type %v for SSRCs %v over %v", codec.Name, codec.PayloadType, offer.SSRCs(), offer.Transport)
+	return answer, nil
+}
+
+// codecMap returns a copy of the payload type to codec mapping for a new
+// sender.
+func (r *Receiver) codecMap() map[uint8]string {
+	r.codecsLock.Lock()
+	defer r.codecsLock.Unlock()
+	m := make(map[uint8]string, len(r.codecs))
+	for pt, c := range r.codecs {
+		m[pt] = c
+	}
+	return m
+}
@@ -0,0 +1,248 @@
+package roq
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/media"
+	"github.com/Willi-42/rtp-over-quic/rtp"
+	"github.com/pion/interceptor"
+)
+
+type MediaSource interface {
+	Play() error
+	Stop() error
+	SetTargetBitsPerSecond(uint)
+}
+
+// SourceParams are the parameters of one media stream of a sender.
+type SourceParams struct {
+	Codec       string
+	PayloadType uint8
+	SSRC        uint32
+	// Width and Height of the encoded video, the resolution of the source
+	// is kept if either is 0.
+	Width  uint
+	Height uint
+	// Framerate of the encoded video, the frame rate of the source is kept
+	// if 0.
+	Framerate uint
+	// TargetBitrate is the initial target bitrate in bit/s, the rate
+	// controller updates it by SetTargetBitsPerSecond.
+	TargetBitrate uint
+	// Content is the content hint of the video, media.ContentCamera or
+	// media.ContentScreen, empty for audio.
+	Content string
+	// Encoder is the video encoder, see media.Encoder, empty for the
+	// default software encoder.
+	Encoder string
+	// Adaptation lets the target bitrate select the resolution and frame
+	// rate of the video.
+	Adaptation bool
+}
+
+func (p SourceParams) mediaOptions() []media.ConfigOption {
+	opts := []media.ConfigOption{
+		media.Codec(p.Codec),
+		media.PayloadType(p.PayloadType),
+		media.SSRC(p.SSRC),
+		media.Resolution(p.Width, p.Height),
+		media.Framerate(p.Framerate),
+		media.InitialTargetBitrate(p.TargetBitrate),
+		media.Adaptation(p.Adaptation),
+	}
+	if len(p.Content) > 0 {
+		opts = append(opts, media.Content(p.Content))
+	}
+	if len(p.Encoder) > 0 {
+		opts = append(opts, media.Encoder(p.Encoder))
+	}
+	return opts
+}
+
+// MediaSourceFactory creates the source of a media stream which writes its
+// RTP packets to w.
+type MediaSourceFactory interface {
+	NewMediaSource(w interceptor.RTPWriter, p SourceParams) (MediaSource, error)
+}
+
+// MediaSourceFactoryFunc adapts a function to a MediaSourceFactory.
+type MediaSourceFactoryFunc func(w interceptor.RTPWriter, p SourceParams) (MediaSource, error)
+
+func (f MediaSourceFactoryFunc) NewMediaSource(w interceptor.RTPWriter, p SourceParams) (MediaSource, error) {
+	return f(w, p)
+}
+
+// mediaGroup shares the target bitrate equally between the streams of a
+// sender.
+type mediaGroup []rtp.Media
+
+func (g mediaGroup) SetTargetBitsPerSecond(rate uint) {
+	for _, m := range g {
+		m.SetTargetBitsPerSecond(rate / uint(len(g)))
+	}
+}
+
+// keyframeForcer is implemented by media sources which can encode the next
+// frame as keyframe.
+type keyframeForcer interface {
+	ForceKeyframe() error
+}
+
+// rateCap limits the target bitrate passed to the media, e.g., to disturb
+// the congestion controller during experiments. The receiver may cap the
+// bitrate, too, by RTCP REMB.
+type rateCap struct {
+	lock   sync.Mutex
+	media  rtp.Media
+	target uint
+	cap    uint
+	remote uint
+	// throttle is the temporary cap set on transport backpressure
+	throttle uint
+}
+
+func (c *rateCap) setMedia(m rtp.Media) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.media = m
+}
+
+func (c *rateCap) SetTargetBitsPerSecond(rate uint) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.target = rate
+	c.apply()
+}
+
+// SetCap sets the highest target bitrate passed to the media, 0 removes the
+// cap.
+func (c *rateCap) SetCap(rate uint) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cap = rate
+	if c.target > 0 {
+		c.apply()
+	}
+}
+
+// SetRemoteCap sets the highest target bitrate announced by the receiver,
+// e.g., a relay forwarding to receivers with less capacity.
+func (c *rateCap) SetRemoteCap(rate uint) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.remote == 0 && rate > 0 {
+		log.Printf("receiver caps the target bitrate at %v bit/s", rate)
+	}
+	c.remote = rate
+	if c.target > 0 {
+		c.apply()
+	}
+}
+
+// Target returns the last target bitrate before capping.
+func (c *rateCap) Target() uint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.target
+}
+
+// Cap returns the current cap, 0 if the target bitrate is not capped.
+func (c *rateCap) Cap() uint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.cap
+}
+
+// Throttle temporarily caps the target bitrate at factor times the current
+// rate passed to the media until Release is called and returns the new
+// rate, 0 if there is no target bitrate yet.
+func (c *rateCap) Throttle(factor float64) uint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.target == 0 {
+		return 0
+	}
+	c.throttle = uint(float64(c.rate()) * factor)
+	if c.throttle == 0 {
+		c.throttle = 1
+	}
+	c.apply()
+	return c.throttle
+}
+
+// Release removes the cap set by Throttle.
+func (c *rateCap) Release() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.throttle = 0
+	if c.target > 0 {
+		c.apply()
+	}
+}
+
+// rate returns the target bitrate after applying the caps.
+func (c *rateCap) rate() uint {
+	rate := c.target
+	for _, limit := range []uint{c.cap, c.remote, c.throttle} {
+		if limit > 0 && rate > limit {
+			rate = limit
+		}
+	}
+	return rate
+}
+
+func (c *rateCap) apply() {
+	if c.media == nil {
+		return
+	}
+	c.media.SetTargetBitsPerSecond(c.rate())
+}
+
+// sourceFactory creates the source named by SenderConfig.Source, a
+// syncodec source for 'syncodec', a media.TestSource for 'gotestsrc' and a
+// Gstreamer or FFmpeg pipeline reading 'videotestsrc', the screen, a camera,
+// an RTSP URL or a file otherwise.
+type sourceFactory struct {
+	source string
+	// gstPacketizer packetizes the media in Gstreamer instead of Go.
+	gstPacketizer bool
+	// rtspLatency and rtspTCP configure RTSP sources.
+	rtspLatency time.Duration
+	rtspTCP     bool
+	// backend is the media backend of the pipeline.
+	backend string
+	// syncodec is the traffic model of syncodec sources.
+	syncodec media.SyncodecModel
+}
+
+func (f sourceFactory) NewMediaSource(w interceptor.RTPWriter, p SourceParams) (MediaSource, error) {
+	if f.source == "syncodec" {
+		ms, err := media.NewSyncodecSource(w, append(p.mediaOptions(), media.Syncodec(f.syncodec))...)
+		if err != nil {
+			return nil, err
+		}
+		return ms, nil
+	}
+	if f.source == media.TestSourceName {
+		ms, err := media.NewTestSource(w, p.mediaOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		return ms, nil
+	}
+	opts := append(p.mediaOptions(), media.RTSP(f.rtspLatency, f.rtspTCP))
+	if f.backend == media.BackendFFmpeg {
+		ms, err := media.NewFFmpegSource(w, f.source, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return ms, nil
+	}
+	ms, err := media.NewGstreamerSource(w, f.source, f.gstPacketizer, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
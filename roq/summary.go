@@ -0,0 +1,33 @@
+package roq
+
+import (
+	"log"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/rtp"
+)
+
+// logSummary logs the packets, bytes and average bitrate of the flows in
+// direction over a session of duration d. Losses are only known for received
+// flows.
+func logSummary(flows []rtp.FlowStats, direction rtp.Direction, d time.Duration) {
+	n := 0
+	for _, f := range flows {
+		if f.Direction != direction {
+			continue
+		}
+		n++
+		var bitrate float64
+		if d > 0 {
+			bitrate = float64(8*f.Bytes) / d.Seconds()
+		}
+		if direction == rtp.Received {
+			log.Printf("summary: SSRC %v: %v packets, %v bytes %v in %v, average bitrate %.0f bit/s, %v packets lost", f.SSRC, f.Packets, f.Bytes, direction, d.Round(time.Millisecond), bitrate, f.Lost)
+			continue
+		}
+		log.Printf("summary: SSRC %v: %v packets, %v bytes %v in %v, average bitrate %.0f bit/s", f.SSRC, f.Packets, f.Bytes, direction, d.Round(time.Millisecond), bitrate)
+	}
+	if n == 0 {
+		log.Printf("summary: no RTP packets %v in %v", direction, d.Round(time.Millisecond))
+	}
+}
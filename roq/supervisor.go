@@ -0,0 +1,372 @@
+package roq
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	// pipelineRestartBackoff is the delay before the first restart of a
+	// failed pipeline, doubled for each further consecutive restart up to
+	// pipelineRestartMaxBackoff.
+	pipelineRestartBackoff    = 500 * time.Millisecond
+	pipelineRestartMaxBackoff = 10 * time.Second
+	// pipelineHealthyAfter is the time after which a restarted pipeline
+	// counts as recovered, so that later failures start over with the
+	// first attempt.
+	pipelineHealthyAfter = 30 * time.Second
+	// pipelineStallTimeout is the time a source may not write any packet
+	// before it counts as stalled, e.g., after its device disappeared
+	// without an error.
+	pipelineStallTimeout = 5 * time.Second
+)
+
+var (
+	errPipelineStalled = errors.New("media pipeline stalled")
+	errPipelineFailed  = errors.New("media pipeline failed")
+)
+
+// restartBackoff returns the delay before restart attempt.
+func restartBackoff(attempt int) time.Duration {
+	backoff := pipelineRestartBackoff
+	for i := 1; i < attempt && backoff < pipelineRestartMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > pipelineRestartMaxBackoff {
+		backoff = pipelineRestartMaxBackoff
+	}
+	return backoff
+}
+
+// supervisedSource rebuilds a media source if it fails or stalls, without
+// touching the connection the packets are sent on. The new source writes to
+// the same writer and starts at the last target bitrate. After restarts
+// consecutive failed attempts, Play returns the error of the source.
+type supervisedSource struct {
+	name     string
+	create   func(w interceptor.RTPWriter) (MediaSource, error)
+	writer   interceptor.RTPWriter
+	restarts int
+	events   *events.Bus
+
+	// lastWrite is the time of the last written packet in Unix nanoseconds
+	lastWrite int64
+
+	lock sync.Mutex
+	// current is nil while the source is rebuilt
+	current MediaSource
+	target  uint
+	stopped bool
+	stop    chan struct{}
+}
+
+func newSupervisedSource(name string, restarts int, bus *events.Bus, w interceptor.RTPWriter, create func(w interceptor.RTPWriter) (MediaSource, error)) (*supervisedSource, error) {
+	s := &supervisedSource{
+		name:     name,
+		create:   create,
+		restarts: restarts,
+		events:   bus,
+		stop:     make(chan struct{}),
+	}
+	s.writer = interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		atomic.StoreInt64(&s.lastWrite, time.Now().UnixNano())
+		return w.Write(header, payload, attributes)
+	})
+	ms, err := create(s.writer)
+	if err != nil {
+		return nil, err
+	}
+	s.current = ms
+	return s, nil
+}
+
+func (s *supervisedSource) Play() error {
+	s.lock.Lock()
+	ms := s.current
+	s.lock.Unlock()
+	attempt := 0
+	for {
+		started := time.Now()
+		err := s.play(ms)
+		if s.isStopped() || err == nil {
+			// stopped or, e.g., the end of a file
+			return err
+		}
+		if time.Since(started) >= pipelineHealthyAfter {
+			attempt = 0
+		}
+		s.stopCurrent()
+		for {
+			if attempt >= s.restarts {
+				log.Printf("%v failed, giving up after %v restarts: %v", s.name, attempt, err)
+				return fmt.Errorf("%w: %v: %v", errPipelineFailed, s.name, err)
+			}
+			attempt++
+			backoff := restartBackoff(attempt)
+			log.Printf("%v failed: %v, restarting in %v (attempt %v of %v)", s.name, err, backoff, attempt, s.restarts)
+			s.events.Publish(events.PipelineRestarted{
+				Time:     time.Now(),
+				Pipeline: s.name,
+				Err:      err,
+				Attempt:  attempt,
+			})
+			select {
+			case <-s.stop:
+				return nil
+			case <-time.After(backoff):
+			}
+			var next MediaSource
+			next, err = s.create(s.writer)
+			if err != nil {
+				continue
+			}
+			s.lock.Lock()
+			if s.stopped {
+				s.lock.Unlock()
+				return next.Stop()
+			}
+			s.current = next
+			next.SetTargetBitsPerSecond(s.target)
+			s.lock.Unlock()
+			ms = next
+			log.Printf("%v restarted", s.name)
+			break
+		}
+	}
+}
+
+// play plays ms until it returns or stalls, in which case it is stopped.
+func (s *supervisedSource) play(ms MediaSource) error {
+	atomic.StoreInt64(&s.lastWrite, time.Now().UnixNano())
+	done := make(chan error, 1)
+	go func() {
+		done <- ms.Play()
+	}()
+	ticker := time.NewTicker(pipelineStallTimeout / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case now := <-ticker.C:
+			idle := now.Sub(time.Unix(0, atomic.LoadInt64(&s.lastWrite)))
+			if idle < pipelineStallTimeout || s.isStopped() {
+				continue
+			}
+			s.stopCurrent()
+			<-done
+			return fmt.Errorf("%w: no packets for %v", errPipelineStalled, idle.Round(time.Millisecond))
+		}
+	}
+}
+
+// stopCurrent tears down the current source, if any.
+func (s *supervisedSource) stopCurrent() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current == nil {
+		return
+	}
+	if err := s.current.Stop(); err != nil {
+		log.Printf("failed to stop %v: %v", s.name, err)
+	}
+	s.current = nil
+}
+
+func (s *supervisedSource) isStopped() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stopped
+}
+
+func (s *supervisedSource) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+	close(s.stop)
+	if s.current == nil {
+		return nil
+	}
+	return s.current.Stop()
+}
+
+func (s *supervisedSource) SetTargetBitsPerSecond(r uint) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.target = r
+	if s.current != nil {
+		s.current.SetTargetBitsPerSecond(r)
+	}
+}
+
+func (s *supervisedSource) ForceKeyframe() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current == nil {
+		// the rebuilt source starts with a keyframe
+		return nil
+	}
+	f, ok := s.current.(keyframeForcer)
+	if !ok {
+		return fmt.Errorf("%w: %v can't force keyframes", errUnsupportedCommand, s.name)
+	}
+	return f.ForceKeyframe()
+}
+
+// failer is implemented by media sinks which report pipeline errors while
+// playing.
+type failer interface {
+	Failed() <-chan error
+}
+
+// supervisedSink rebuilds a media sink after its pipeline failed. Packets
+// written while the sink is rebuilt are dropped, the decoder recovers with
+// the next keyframe. After restarts consecutive failed attempts, all further
+// packets are dropped.
+type supervisedSink struct {
+	name     string
+	create   func() (MediaSink, error)
+	restarts int
+	events   *events.Bus
+
+	lock sync.Mutex
+	// current is nil while the sink is rebuilt
+	current MediaSink
+	stopped bool
+	stop    chan struct{}
+}
+
+func newSupervisedSink(name string, restarts int, bus *events.Bus, create func() (MediaSink, error)) (*supervisedSink, error) {
+	ms, err := create()
+	if err != nil {
+		return nil, err
+	}
+	return &supervisedSink{
+		name:     name,
+		create:   create,
+		restarts: restarts,
+		events:   bus,
+		current:  ms,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+func (s *supervisedSink) Write(b []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current == nil {
+		return len(b), nil
+	}
+	return s.current.Write(b)
+}
+
+func (s *supervisedSink) Play() error {
+	s.lock.Lock()
+	ms := s.current
+	s.lock.Unlock()
+	if err := ms.Play(); err != nil {
+		return err
+	}
+	go s.supervise(ms)
+	return nil
+}
+
+// supervise waits for ms to fail and replaces it by a new sink.
+func (s *supervisedSink) supervise(ms MediaSink) {
+	attempt := 0
+	for {
+		f, ok := ms.(failer)
+		if !ok {
+			return
+		}
+		started := time.Now()
+		var err error
+		select {
+		case <-s.stop:
+			return
+		case err = <-f.Failed():
+		}
+		if time.Since(started) >= pipelineHealthyAfter {
+			attempt = 0
+		}
+		s.lock.Lock()
+		if s.stopped {
+			s.lock.Unlock()
+			return
+		}
+		s.current = nil
+		s.lock.Unlock()
+		if err := ms.Stop(); err != nil {
+			log.Printf("failed to stop %v: %v", s.name, err)
+		}
+		ms = nil
+		for ms == nil {
+			if attempt >= s.restarts {
+				log.Printf("%v failed, giving up after %v restarts and dropping all packets: %v", s.name, attempt, err)
+				return
+			}
+			attempt++
+			backoff := restartBackoff(attempt)
+			log.Printf("%v failed: %v, restarting in %v (attempt %v of %v)", s.name, err, backoff, attempt, s.restarts)
+			s.events.Publish(events.PipelineRestarted{
+				Time:     time.Now(),
+				Pipeline: s.name,
+				Err:      err,
+				Attempt:  attempt,
+			})
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(backoff):
+			}
+			var next MediaSink
+			next, err = s.create()
+			if err != nil {
+				continue
+			}
+			if err = next.Play(); err != nil {
+				if err := next.Stop(); err != nil {
+					log.Printf("failed to stop %v: %v", s.name, err)
+				}
+				continue
+			}
+			ms = next
+		}
+		s.lock.Lock()
+		if s.stopped {
+			s.lock.Unlock()
+			if err := ms.Stop(); err != nil {
+				log.Printf("failed to stop %v: %v", s.name, err)
+			}
+			return
+		}
+		s.current = ms
+		s.lock.Unlock()
+		log.Printf("%v restarted", s.name)
+	}
+}
+
+func (s *supervisedSink) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+	close(s.stop)
+	if s.current == nil {
+		return nil
+	}
+	return s.current.Stop()
+}
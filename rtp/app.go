@@ -0,0 +1,49 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	rtcpTypeAPP = 204
+
+	// rtcpAPPHeaderSize is the size of an APP packet without application
+	// dependent data: header, SSRC and name.
+	rtcpAPPHeaderSize = 12
+)
+
+var errInvalidAPPPacket = errors.New("invalid RTCP APP packet")
+
+// IsRTCP returns whether buf is an RTCP packet, using the payload type
+// ranges of RFC 5761 to demultiplex RTP and RTCP.
+func IsRTCP(buf []byte) bool {
+	return len(buf) >= 2 && buf[1] >= 192 && buf[1] <= 223
+}
+
+// newAPPPacket returns an RTCP APP packet (RFC 3550) with the application
+// dependent data, whose length must be a multiple of 4 bytes.
+func newAPPPacket(ssrc uint32, name [4]byte, data []byte) *rtcp.RawPacket {
+	buf := make([]byte, rtcpAPPHeaderSize+len(data))
+	buf[0] = 2 << 6 // version 2, no padding, subtype 0
+	buf[1] = rtcpTypeAPP
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)/4-1))
+	binary.BigEndian.PutUint32(buf[4:], ssrc)
+	copy(buf[8:], name[:])
+	copy(buf[rtcpAPPHeaderSize:], data)
+	raw := rtcp.RawPacket(buf)
+	return &raw
+}
+
+// parseAPPPacket returns the SSRC, name and application dependent data of an
+// RTCP APP packet.
+func parseAPPPacket(buf []byte) (uint32, [4]byte, []byte, error) {
+	var name [4]byte
+	if len(buf) < rtcpAPPHeaderSize || buf[1] != rtcpTypeAPP {
+		return 0, name, nil, errInvalidAPPPacket
+	}
+	copy(name[:], buf[8:12])
+	return binary.BigEndian.Uint32(buf[4:]), name, buf[rtcpAPPHeaderSize:], nil
+}
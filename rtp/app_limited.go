@@ -0,0 +1,98 @@
+package rtp
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const appLimitedRateWindow = 500 * time.Millisecond
+
+// AppLimitedDetector tracks intervals in which the media is application
+// limited, i.e., sends considerably less than the target bitrate. Delay
+// based congestion controllers can't observe the capacity of the path in
+// these intervals and increase their estimate without evidence, which is
+// why the BandwidthEstimator freezes the target bitrate while the stream is
+// application limited. The detector has to be registered after the
+// congestion controller and before a Prober, so that it sees padding.
+type AppLimitedDetector struct {
+	interceptor.NoOp
+
+	threshold float64
+
+	lock    sync.Mutex
+	first   time.Time
+	sent    []sentBytes
+	bytes   int
+	limited bool
+	since   time.Time
+}
+
+// NewAppLimitedDetector creates a detector which considers the stream
+// application limited if it sends less than threshold times the target
+// bitrate.
+func NewAppLimitedDetector(threshold float64) *AppLimitedDetector {
+	return &AppLimitedDetector{
+		threshold: threshold,
+	}
+}
+
+func (d *AppLimitedDetector) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return d, nil
+}
+
+func (d *AppLimitedDetector) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		d.onSent(time.Now(), header.MarshalSize()+len(payload))
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (d *AppLimitedDetector) onSent(now time.Time, size int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.first.IsZero() {
+		d.first = now
+	}
+	d.sent = append(d.sent, sentBytes{at: now, size: size})
+	d.bytes += size
+	d.prune(now)
+}
+
+func (d *AppLimitedDetector) prune(now time.Time) {
+	i := 0
+	for ; i < len(d.sent) && now.Sub(d.sent[i].at) > appLimitedRateWindow; i++ {
+		d.bytes -= d.sent[i].size
+	}
+	d.sent = d.sent[i:]
+}
+
+// Update compares the send rate to target and returns whether the stream is
+// application limited. Transitions are logged with the length of the
+// application limited interval.
+func (d *AppLimitedDetector) Update(now time.Time, target int) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// the rate is not known before the first window is complete
+	if d.first.IsZero() || now.Sub(d.first) < appLimitedRateWindow || target <= 0 {
+		return false
+	}
+	d.prune(now)
+	rate := 8 * float64(d.bytes) / appLimitedRateWindow.Seconds()
+	limited := rate < d.threshold*float64(target)
+	if limited != d.limited {
+		if limited {
+			log.Printf("application limited: send rate %v bit/s, target %v bit/s", int(rate), target)
+		} else {
+			log.Printf("no longer application limited after %v", now.Sub(d.since))
+		}
+		d.limited = limited
+		d.since = now
+	}
+	return limited
+}
@@ -1,9 +1,20 @@
 package rtp
 
+import (
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/tracing"
+	"github.com/pion/interceptor"
+)
+
 type AttributeKey int
 
 const (
 	RELIABILITY AttributeKey = iota
+	FRAME
+	FEEDBACK_ACKED
+	TRACE
+	LAYER
 )
 
 type Reliability bool
@@ -12,3 +23,41 @@ const (
 	REQUIRED     Reliability = true
 	NOT_REQUIRED Reliability = false
 )
+
+// FrameInfo is attached as FRAME attribute to RTP packets by media sources
+// which know the frame a packet belongs to. Timestamps and duration are -1 if
+// unknown. Size is the number of bytes of the encoded frame, 0 if unknown,
+// e.g., if the frame was packetized by Gstreamer. Capture is the wall clock
+// time the frame was captured, or encoded if the source can't tell, zero if
+// unknown.
+type FrameInfo struct {
+	PTS      time.Duration
+	DTS      time.Duration
+	Duration time.Duration
+	KeyFrame bool
+	Size     int
+	Capture  time.Time
+}
+
+// LayerInfo is attached as LAYER attribute to RTP packets by media sources
+// which send simulcast or scalable streams. Height and FrameRate describe the
+// layer the packet belongs to including the layers it depends on, 0 if
+// unknown.
+type LayerInfo struct {
+	Spatial   uint8
+	Temporal  uint8
+	Height    uint16
+	FrameRate uint8
+}
+
+// FeedbackAckedCallback is attached as FEEDBACK_ACKED attribute to outgoing
+// RTCP packets by a FeedbackThrottle. Transports which learn about the
+// delivery of RTCP packets call it once the packets were acknowledged.
+type FeedbackAckedCallback func()
+
+// PacketSpan returns the *tracing.Span attached as TRACE attribute to an RTP
+// packet by the media source or transport, or nil.
+func PacketSpan(attributes interceptor.Attributes) *tracing.Span {
+	span, _ := attributes.Get(TRACE).(*tracing.Span)
+	return span
+}
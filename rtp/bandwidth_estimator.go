@@ -4,9 +4,16 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	rqcc "github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/Willi-42/rtp-over-quic/fse"
 	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/nada"
 	"github.com/Willi-42/rtp-over-quic/scream"
 	"github.com/pion/interceptor/pkg/cc"
 )
@@ -16,20 +23,34 @@ type Media interface {
 }
 
 type BandwidthEstimator struct {
-	media Media
+	media     Media
+	evaluator *BWEEvaluator
+	flow      *fse.Flow
+	prober    *Prober
+	pacer     Media
 
-	screamBWE chan scream.BandwidthEstimator
-	gccBWE    chan cc.BandwidthEstimator
+	appLimited *AppLimitedDetector
+	events     *events.Bus
+
+	minBitrate int
+	maxBitrate int
+
+	bwe chan estimator
+
+	// target is the last target bitrate of the congestion controller,
+	// current the estimator of the running congestion controller.
+	lock    sync.Mutex
+	target  int
+	current estimator
 
 	logFile string
 }
 
 func NewBandwidthEstimator(logfile string) (*BandwidthEstimator, error) {
 	return &BandwidthEstimator{
-		media:     nil,
-		screamBWE: make(chan scream.BandwidthEstimator),
-		gccBWE:    make(chan cc.BandwidthEstimator),
-		logFile:   logfile,
+		media:   nil,
+		bwe:     make(chan estimator),
+		logFile: logfile,
 	}, nil
 }
 
@@ -37,66 +58,368 @@ func (e *BandwidthEstimator) SetMedia(m Media) {
 	e.media = m
 }
 
+// SetEvaluator sets an evaluator which is fed all target bitrates and closed
+// when the estimator stops.
+func (e *BandwidthEstimator) SetEvaluator(ev *BWEEvaluator) {
+	e.evaluator = ev
+}
+
+// JoinFSE couples the estimator with the other flows registered at f. The
+// media target bitrate is then set to the share of the flow instead of the
+// rate calculated by the congestion controller.
+func (e *BandwidthEstimator) JoinFSE(f *fse.FSE, priority float64, initialRate int) {
+	e.flow = f.Register(priority, initialRate, 0, e.setMediaTarget)
+}
+
+// SetEventBus publishes all changes of the target bitrate to bus.
+func (e *BandwidthEstimator) SetEventBus(bus *events.Bus) {
+	e.events = bus
+}
+
+// SetProber sets a prober which is informed about all target bitrates.
+func (e *BandwidthEstimator) SetProber(p *Prober) {
+	e.prober = p
+}
+
+// SetPacer sets a pacer of the transport which is informed about all target
+// bitrates of the congestion controller.
+func (e *BandwidthEstimator) SetPacer(p Media) {
+	e.pacer = p
+}
+
+// SetBitrateLimits bounds the target bitrate passed to the media, so that the
+// media never gets a target outside of [min, max], e.g., when a congestion
+// controller ignores its configured bounds. A max of 0 means no limit.
+func (e *BandwidthEstimator) SetBitrateLimits(min, max int) {
+	e.minBitrate = min
+	e.maxBitrate = max
+}
+
+func (e *BandwidthEstimator) setMediaTarget(target int) {
+	if target < e.minBitrate {
+		target = e.minBitrate
+	}
+	if e.maxBitrate > 0 && target > e.maxBitrate {
+		target = e.maxBitrate
+	}
+	if e.media != nil {
+		e.media.SetTargetBitsPerSecond(uint(target))
+	}
+}
+
+// SetAppLimitedDetector enables freezing the target bitrate while the media
+// is application limited.
+func (e *BandwidthEstimator) SetAppLimitedDetector(d *AppLimitedDetector) {
+	e.appLimited = d
+}
+
+// freeze prevents target increases while the media is application limited.
+func (e *BandwidthEstimator) freeze(now time.Time, target int) int {
+	if e.appLimited == nil {
+		return target
+	}
+	last := e.Target()
+	if e.appLimited.Update(now, last) && last > 0 && target > last {
+		target = last
+	}
+	return target
+}
+
+// Metrics returns the metrics of the running congestion controller.
+func (e *BandwidthEstimator) Metrics() rqcc.Metrics {
+	e.lock.Lock()
+	current := e.current
+	e.lock.Unlock()
+	if current == nil {
+		return rqcc.Metrics{}
+	}
+	return current.Metrics()
+}
+
+func (e *BandwidthEstimator) setCurrent(bwe estimator) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.current = bwe
+}
+
+// Target returns the last target bitrate of the congestion controller or 0
+// if there is none yet.
+func (e *BandwidthEstimator) Target() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.target
+}
+
+func (e *BandwidthEstimator) onTarget(now time.Time, target int) {
+	target = e.freeze(now, target)
+	e.lock.Lock()
+	changed := e.target != target
+	e.target = target
+	e.lock.Unlock()
+	if changed {
+		logging.QLOGEvent(logging.QLOGTargetUpdated, map[string]interface{}{"target_bitrate": target})
+		e.events.Publish(events.RateChanged{Time: now, Bitrate: target})
+	}
+	if e.evaluator != nil {
+		e.evaluator.OnTarget(now, target)
+	}
+	if e.prober != nil {
+		e.prober.SetTargetBitsPerSecond(uint(target))
+	}
+	if e.pacer != nil {
+		e.pacer.SetTargetBitsPerSecond(uint(target))
+	}
+	if e.flow != nil {
+		target = e.flow.Update(target)
+	}
+	e.setMediaTarget(target)
+}
+
+func (e *BandwidthEstimator) close() {
+	if e.flow != nil {
+		e.flow.Leave()
+	}
+	if e.evaluator == nil {
+		return
+	}
+	if err := e.evaluator.Close(); err != nil {
+		log.Printf("failed to close bwe evaluator: %v", err)
+	}
+}
+
 func (e *BandwidthEstimator) OnNewSCReAMEstimator(_ string, bwe scream.BandwidthEstimator) {
-	e.screamBWE <- bwe
+	e.bwe <- screamEstimator{bwe}
 }
 
 func (e *BandwidthEstimator) OnNewGCCEstimator(_ string, bwe cc.BandwidthEstimator) {
-	e.gccBWE <- bwe
+	e.bwe <- gccEstimator{bwe}
+}
+
+func (e *BandwidthEstimator) OnNewNADAEstimator(_ string, bwe nada.BandwidthEstimator) {
+	e.bwe <- nadaEstimator{bwe}
+}
+
+// OnNewEstimator is the callback for congestion controllers registered using
+// cc.Register.
+func (e *BandwidthEstimator) OnNewEstimator(_ string, bwe rqcc.BandwidthEstimator) {
+	e.bwe <- customEstimator{bwe}
+}
+
+// estimator unifies the estimators of the congestion controllers.
+type estimator interface {
+	rqcc.MetricsSource
+	targetBitrate() (int, error)
+	// stats returns the statistics logged after the target bitrate.
+	stats() string
+}
+
+// QueueDepths returns the number of packets queued per SSRC by the running
+// congestion controller. Only SCReAM queues packets.
+func (e *BandwidthEstimator) QueueDepths() map[uint32]int {
+	e.lock.Lock()
+	current := e.current
+	e.lock.Unlock()
+	s, ok := current.(screamEstimator)
+	if !ok {
+		return nil
+	}
+	q, ok := s.BandwidthEstimator.(interface{ QueueDepths() map[uint32]int })
+	if !ok {
+		return nil
+	}
+	return q.QueueDepths()
+}
+
+type screamEstimator struct {
+	scream.BandwidthEstimator
+}
+
+func (b screamEstimator) targetBitrate() (int, error) {
+	return b.GetTargetBitrate(0)
+}
+
+func (b screamEstimator) stats() string {
+	stats := b.GetStats()
+	return formatValues(
+		stats["queueDelay"],
+		stats["sRTT"],
+		stats["cwnd"],
+		stats["bytesInFlightLog"],
+		stats["rateLostStream0"],
+		stats["rateTransmittedStream0"],
+		stats["rateAckedStream0"],
+		stats["hiSeqAckStream0"],
+		stats["isInFastStart"],
+	)
+}
+
+type gccEstimator struct {
+	cc.BandwidthEstimator
+}
+
+func (b gccEstimator) targetBitrate() (int, error) {
+	return b.GetTargetBitrate(), nil
+}
+
+// Metrics returns the target bitrate and the average loss. The delay
+// estimate of GCC is a filtered delay gradient, not a queuing delay, and is
+// not reported.
+func (b gccEstimator) Metrics() rqcc.Metrics {
+	m := rqcc.Metrics{
+		TargetBitrate: b.GetTargetBitrate(),
+	}
+	if loss, ok := b.GetStats()["averageLoss"].(float64); ok {
+		m.LossRate = loss
+	}
+	return m
+}
+
+func (b gccEstimator) stats() string {
+	stats := b.GetStats()
+	return formatValues(
+		stats["lossTargetBitrate"],
+		stats["averageLoss"],
+		stats["delayTargetBitrate"],
+		stats["delayMeasurement"],
+		stats["delayEstimate"],
+		stats["delayThreshold"],
+		stats["usage"],
+		stats["state"],
+	)
+}
+
+type nadaEstimator struct {
+	nada.BandwidthEstimator
+}
+
+func (b nadaEstimator) targetBitrate() (int, error) {
+	return b.GetTargetBitrate(), nil
+}
+
+func (b nadaEstimator) stats() string {
+	stats := b.GetStats()
+	return formatValues(
+		stats["recvRate"],
+		stats["queueDelay"],
+		stats["rtt"],
+		stats["lossRatio"],
+		stats["markRatio"],
+		stats["signal"],
+		stats["mode"],
+	)
 }
 
-func (e *BandwidthEstimator) RunGCC(ctx context.Context) error {
-	panic("TODO")
+// customEstimator wraps estimators of congestion controllers registered using
+// cc.Register. As the stats are not known in advance, they are logged as
+// key=value pairs sorted by key.
+type customEstimator struct {
+	rqcc.BandwidthEstimator
 }
 
-func (e *BandwidthEstimator) RunSCReAM(ctx context.Context) error {
+func (b customEstimator) targetBitrate() (int, error) {
+	return b.GetTargetBitrate(), nil
+}
+
+// Metrics returns the metrics of the estimator if it implements
+// cc.MetricsSource and only the target bitrate otherwise.
+func (b customEstimator) Metrics() rqcc.Metrics {
+	if source, ok := b.BandwidthEstimator.(rqcc.MetricsSource); ok {
+		return source.Metrics()
+	}
+	return rqcc.Metrics{
+		TargetBitrate: b.GetTargetBitrate(),
+	}
+}
+
+func (b customEstimator) stats() string {
+	stats := b.GetStats()
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var s strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&s, ", %v=%v", k, stats[k])
+	}
+	return s.String()
+}
+
+func formatValues(values ...interface{}) string {
+	var s strings.Builder
+	for _, v := range values {
+		fmt.Fprintf(&s, ", %v", v)
+	}
+	return s.String()
+}
+
+// Run polls the estimator of the congestion controller and passes the target
+// bitrate on. If the congestion controller is replaced, e.g., by a CCSwitch,
+// Run continues with the estimator of the new congestion controller.
+func (e *BandwidthEstimator) Run(ctx context.Context) error {
 	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
 
 	ccLogFile, err := logging.GetLogFile(e.logFile)
 	if err != nil {
 		return err
 	}
 	defer ccLogFile.Close()
+	defer e.close()
 
 	log.Printf("waiting for bwe")
-	var bwe scream.BandwidthEstimator
+	var bwe estimator
 	select {
-	case bwe = <-e.screamBWE:
+	case bwe = <-e.bwe:
+		e.setCurrent(bwe)
 	case <-ctx.Done():
 		return nil
 	}
 
 	for {
 		select {
-		case bwe = <-e.screamBWE:
+		case bwe = <-e.bwe:
+			e.setCurrent(bwe)
 		case now := <-ticker.C:
-			target, err := bwe.GetTargetBitrate(0)
+			target, err := bwe.targetBitrate()
 			if err != nil {
 				log.Printf("got error on bwe.GetTargetBitrate: %v", err)
 				continue
 			}
 			if target < 0 {
-				log.Printf("[SCReAM] got negative target bitrate: %v", target)
+				log.Printf("got negative target bitrate: %v", target)
 				continue
 			}
-			stats := bwe.GetStats()
-			fmt.Fprintf(
-				ccLogFile, "%v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v\n",
-				now.UnixMilli(),
-				target,
-				stats["queueDelay"],
-				stats["sRTT"],
-				stats["cwnd"],
-				stats["bytesInFlightLog"],
-				stats["rateLostStream0"],
-				stats["rateTransmittedStream0"],
-				stats["rateAckedStream0"],
-				stats["hiSeqAckStream0"],
-				stats["isInFastStart"],
-			)
-			if e.media != nil {
-				e.media.SetTargetBitsPerSecond(uint(target))
+			fmt.Fprintf(ccLogFile, "%v, %v%v\n", now.UnixMilli(), target, bwe.stats())
+			e.onTarget(now, target)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// RunTransport sets the media target bitrate to the rate returned by rate,
+// e.g., the rate of a congestion controller of the transport.
+func (e *BandwidthEstimator) RunTransport(ctx context.Context, rate func() int) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	ccLogFile, err := logging.GetLogFile(e.logFile)
+	if err != nil {
+		return err
+	}
+	defer ccLogFile.Close()
+	defer e.close()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			target := rate()
+			if target <= 0 {
+				continue
 			}
+			fmt.Fprintf(ccLogFile, "%v, %v\n", now.UnixMilli(), target)
+			e.onTarget(now, target)
 		case <-ctx.Done():
 			return nil
 		}
@@ -0,0 +1,200 @@
+package rtp
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const (
+	bufferHealthHistorySize = 4096
+	// atoOverrange is the arrival time offset RFC 8888 uses for offsets
+	// which can't be represented.
+	atoOverrange = 0x1fff
+)
+
+// BufferHealthScheduler adapts the sending of packets to the estimated
+// occupancy of the playout buffer of the receiver.
+type BufferHealthScheduler interface {
+	OnBufferHealth(occupancy, target time.Duration)
+}
+
+type sentTimestamp struct {
+	valid     bool
+	seqNr     uint16
+	timestamp uint32
+}
+
+type bufferHealthStream struct {
+	history [bufferHealthHistorySize]sentTimestamp
+
+	init          bool
+	baseTimestamp uint32
+	// minOffset is the smallest difference between the arrival time and
+	// the media time of a packet, which anchors the playout schedule.
+	minOffset time.Duration
+	maxMedia  time.Duration
+}
+
+// BufferHealth estimates the occupancy of the playout buffer of the receiver
+// from the arrival times reported in RFC 8888 feedback. It assumes a
+// receiver which plays out media with a fixed delay after the packet which
+// arrived earliest relative to its media time. The occupancy is the media
+// time received but not yet played out at the time of the report, a
+// negative occupancy means the receiver stalls. The estimator has to be
+// registered close to the network, so that it sees the sequence numbers of
+// the sent packets.
+type BufferHealth struct {
+	interceptor.NoOp
+
+	playoutDelay time.Duration
+	clockRate    uint32
+	logFile      io.WriteCloser
+
+	lock      sync.Mutex
+	streams   map[uint32]*bufferHealthStream
+	scheduler BufferHealthScheduler
+	occupancy time.Duration
+}
+
+// NewBufferHealth creates an estimator for a receiver with a playout delay
+// of playoutDelay. Estimates are logged to logfile.
+func NewBufferHealth(playoutDelay time.Duration, clockRate uint32, logfile string) (*BufferHealth, error) {
+	f, err := logging.GetLogFile(logfile)
+	if err != nil {
+		return nil, err
+	}
+	return &BufferHealth{
+		playoutDelay: playoutDelay,
+		clockRate:    clockRate,
+		logFile:      f,
+		streams:      map[uint32]*bufferHealthStream{},
+	}, nil
+}
+
+func (b *BufferHealth) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return b, nil
+}
+
+// SetScheduler sets a scheduler which is informed about every estimate.
+func (b *BufferHealth) SetScheduler(s BufferHealthScheduler) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.scheduler = s
+}
+
+// Occupancy returns the last estimated buffer occupancy.
+func (b *BufferHealth) Occupancy() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.occupancy
+}
+
+func (b *BufferHealth) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		b.lock.Lock()
+		s, ok := b.streams[header.SSRC]
+		if !ok {
+			s = &bufferHealthStream{}
+			b.streams[header.SSRC] = s
+		}
+		s.history[header.SequenceNumber%bufferHealthHistorySize] = sentTimestamp{
+			valid:     true,
+			seqNr:     header.SequenceNumber,
+			timestamp: header.Timestamp,
+		}
+		b.lock.Unlock()
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (b *BufferHealth) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(buf []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(buf, a)
+		if err != nil {
+			return 0, nil, err
+		}
+		pkts, err := rtcp.Unmarshal(buf[:n])
+		if err != nil {
+			// leave the handling of invalid packets to the other
+			// interceptors
+			return n, attr, nil
+		}
+		for _, pkt := range pkts {
+			if report, ok := pkt.(*rtcp.CCFeedbackReport); ok {
+				b.onReport(time.Now(), report)
+			}
+		}
+		return n, attr, nil
+	})
+}
+
+func (b *BufferHealth) onReport(now time.Time, report *rtcp.CCFeedbackReport) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	reportTime := time.Duration(report.ReportTimestamp) * time.Second / 65536
+	updated := false
+	var occupancy time.Duration
+	for _, block := range report.ReportBlocks {
+		s, ok := b.streams[block.MediaSSRC]
+		if !ok {
+			continue
+		}
+		for i, m := range block.MetricBlocks {
+			if !m.Received || m.ArrivalTimeOffset >= atoOverrange {
+				continue
+			}
+			seqNr := block.BeginSequence + uint16(i)
+			sent := s.history[seqNr%bufferHealthHistorySize]
+			if !sent.valid || sent.seqNr != seqNr {
+				continue
+			}
+			arrival := reportTime - time.Duration(m.ArrivalTimeOffset)*time.Second/1024
+			s.onArrival(arrival, sent.timestamp, b.clockRate)
+		}
+		if !s.init {
+			continue
+		}
+		// media received up to the report minus media played out until
+		// the report
+		o := s.maxMedia + s.minOffset + b.playoutDelay - reportTime
+		if !updated || o < occupancy {
+			occupancy = o
+			updated = true
+		}
+	}
+	if !updated {
+		return
+	}
+	b.occupancy = occupancy
+	fmt.Fprintf(b.logFile, "%v, %v\n", now.UnixMilli(), occupancy.Milliseconds())
+	if b.scheduler != nil {
+		b.scheduler.OnBufferHealth(occupancy, b.playoutDelay)
+	}
+}
+
+func (s *bufferHealthStream) onArrival(arrival time.Duration, timestamp, clockRate uint32) {
+	if !s.init {
+		s.init = true
+		s.baseTimestamp = timestamp
+		s.minOffset = arrival
+	}
+	media := time.Duration(int32(timestamp-s.baseTimestamp)) * time.Second / time.Duration(clockRate)
+	if offset := arrival - media; offset < s.minOffset {
+		s.minOffset = offset
+	}
+	if media > s.maxMedia {
+		s.maxMedia = media
+	}
+}
+
+func (b *BufferHealth) Close() error {
+	return b.logFile.Close()
+}
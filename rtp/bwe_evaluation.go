@@ -0,0 +1,257 @@
+package rtp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// convergenceTolerance is the maximum relative deviation of the target
+// bitrate from the capacity for the estimate to be considered converged.
+const convergenceTolerance = 0.1
+
+var errInvalidCapacityTrace = errors.New("invalid capacity trace")
+
+type capacityStep struct {
+	offset   time.Duration
+	capacity float64
+}
+
+// CapacityTrace describes the ground truth bottleneck capacity over time,
+// relative to the start of the evaluation.
+type CapacityTrace struct {
+	steps []capacityStep
+}
+
+// ConstantCapacity returns a trace with a fixed capacity in bits per second.
+func ConstantCapacity(bps uint) *CapacityTrace {
+	return &CapacityTrace{
+		steps: []capacityStep{{offset: 0, capacity: float64(bps)}},
+	}
+}
+
+// LoadCapacityTrace reads a capacity trace from file. Each line contains the
+// offset in milliseconds at which the capacity changes and the new capacity
+// in bits per second, separated by a comma. Empty lines and lines starting
+// with '#' are ignored.
+func LoadCapacityTrace(file string) (*CapacityTrace, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &CapacityTrace{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: line %v: expected 2 fields, got %v", errInvalidCapacityTrace, line, len(fields))
+		}
+		ms, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %v: %v", errInvalidCapacityTrace, line, err)
+		}
+		bps, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %v: %v", errInvalidCapacityTrace, line, err)
+		}
+		t.steps = append(t.steps, capacityStep{
+			offset:   time.Duration(ms) * time.Millisecond,
+			capacity: float64(bps),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.steps) == 0 {
+		return nil, fmt.Errorf("%w: no entries", errInvalidCapacityTrace)
+	}
+	sort.SliceStable(t.steps, func(i, j int) bool {
+		return t.steps[i].offset < t.steps[j].offset
+	})
+	return t, nil
+}
+
+// segment returns the index of the step active at offset d.
+func (t *CapacityTrace) segment(d time.Duration) int {
+	i := sort.Search(len(t.steps), func(i int) bool {
+		return t.steps[i].offset > d
+	})
+	if i == 0 {
+		return 0
+	}
+	return i - 1
+}
+
+// At returns the capacity in bits per second at offset d.
+func (t *CapacityTrace) At(d time.Duration) float64 {
+	return t.steps[t.segment(d)].capacity
+}
+
+// integral returns the number of bits which could have been transmitted in
+// the interval [0, d).
+func (t *CapacityTrace) integral(d time.Duration) float64 {
+	bits := 0.0
+	for i, s := range t.steps {
+		if s.offset >= d {
+			break
+		}
+		end := d
+		if i+1 < len(t.steps) && t.steps[i+1].offset < d {
+			end = t.steps[i+1].offset
+		}
+		bits += s.capacity * (end - s.offset).Seconds()
+	}
+	return bits
+}
+
+type segmentStats struct {
+	start     time.Duration
+	capacity  float64
+	converged bool
+	duration  time.Duration
+}
+
+// BWEEvaluator compares the target bitrates produced by a bandwidth estimator
+// against a known capacity trace. It logs the estimation error of every
+// sample and computes the convergence time, overshoot and link utilization
+// for the end of run summary.
+type BWEEvaluator struct {
+	capacity *CapacityTrace
+	log      io.WriteCloser
+
+	sentBytes uint64
+
+	lock     sync.Mutex
+	start    time.Time
+	last     time.Time
+	samples  int
+	sumAbs   float64
+	sumSq    float64
+	overshot int
+	sumOver  float64
+	maxOver  float64
+	segments []*segmentStats
+}
+
+func NewBWEEvaluator(capacity *CapacityTrace, logFile string) (*BWEEvaluator, error) {
+	f, err := logging.GetLogFile(logFile)
+	if err != nil {
+		return nil, err
+	}
+	return &BWEEvaluator{
+		capacity: capacity,
+		log:      f,
+	}, nil
+}
+
+// Writer wraps w to count the bytes sent for the utilization.
+func (e *BWEEvaluator) Writer(w interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		n, err := w.Write(header, payload, attributes)
+		if err == nil {
+			atomic.AddUint64(&e.sentBytes, uint64(header.MarshalSize()+len(payload)))
+		}
+		return n, err
+	})
+}
+
+// OnTarget records a new target bitrate in bits per second.
+func (e *BWEEvaluator) OnTarget(now time.Time, target int) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.start.IsZero() {
+		e.start = now
+	}
+	e.last = now
+	offset := now.Sub(e.start)
+	segment := e.capacity.segment(offset)
+	for len(e.segments) <= segment {
+		s := e.capacity.steps[len(e.segments)]
+		e.segments = append(e.segments, &segmentStats{
+			start:    s.offset,
+			capacity: s.capacity,
+		})
+	}
+
+	capacity := e.capacity.At(offset)
+	relErr := (float64(target) - capacity) / capacity
+	e.samples++
+	e.sumAbs += math.Abs(relErr)
+	e.sumSq += relErr * relErr
+	if relErr > 0 {
+		e.overshot++
+		e.sumOver += relErr
+		if relErr > e.maxOver {
+			e.maxOver = relErr
+		}
+	}
+	if s := e.segments[segment]; !s.converged && math.Abs(relErr) <= convergenceTolerance {
+		s.converged = true
+		s.duration = offset - s.start
+	}
+
+	fmt.Fprintf(e.log, "%v, %v, %v, %.4f\n", now.UnixMilli(), target, capacity, relErr)
+}
+
+// Summary returns a human readable summary of the evaluation.
+func (e *BWEEvaluator) Summary() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.samples == 0 {
+		return "bwe evaluation: no samples"
+	}
+	var b strings.Builder
+	duration := e.last.Sub(e.start)
+	fmt.Fprintf(&b, "bwe evaluation: duration=%v, samples=%v\n", duration, e.samples)
+	fmt.Fprintf(&b, "  mean absolute error: %.2f%%\n", 100*e.sumAbs/float64(e.samples))
+	fmt.Fprintf(&b, "  RMS error: %.2f%%\n", 100*math.Sqrt(e.sumSq/float64(e.samples)))
+	meanOver := 0.0
+	if e.overshot > 0 {
+		meanOver = e.sumOver / float64(e.overshot)
+	}
+	fmt.Fprintf(&b, "  overshoot: max=%.2f%%, mean=%.2f%%, time above capacity=%.2f%%\n", 100*e.maxOver, 100*meanOver, 100*float64(e.overshot)/float64(e.samples))
+	if capacity := e.capacity.integral(duration); capacity > 0 {
+		sent := float64(atomic.LoadUint64(&e.sentBytes)) * 8
+		fmt.Fprintf(&b, "  utilization: %.2f%%\n", 100*sent/capacity)
+	}
+	for i, s := range e.segments {
+		convergence := "not converged"
+		if s.converged {
+			convergence = s.duration.String()
+		}
+		fmt.Fprintf(&b, "  segment %v: start=%v, capacity=%v, convergence=%v\n", i, s.start, s.capacity, convergence)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// Close writes the summary to the log and the evaluation log file.
+func (e *BWEEvaluator) Close() error {
+	summary := e.Summary()
+	log.Print(summary)
+	for _, line := range strings.Split(summary, "\n") {
+		fmt.Fprintf(e.log, "# %v\n", line)
+	}
+	return e.log.Close()
+}
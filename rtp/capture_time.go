@@ -0,0 +1,61 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	// AbsCaptureTimeURI is the header extension carrying the NTP time a
+	// frame was captured at, see
+	// http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time.
+	AbsCaptureTimeURI = "http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time"
+	// absCaptureTimeID is the extension ID of AbsCaptureTimeURI, next to
+	// the ID 1 of the transport-wide congestion control extension.
+	absCaptureTimeID = 2
+)
+
+// AbsCaptureTime stamps the capture time of the FRAME attribute into the
+// abs-capture-time header extension of the last packet of each frame, i.e.,
+// the packet with the marker bit set. Packets without capture time are sent
+// unchanged.
+type AbsCaptureTime struct {
+	interceptor.NoOp
+}
+
+func (c *AbsCaptureTime) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return c, nil
+}
+
+func (c *AbsCaptureTime) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if !header.Marker {
+			return writer.Write(header, payload, attributes)
+		}
+		info, ok := attributes.Get(FRAME).(FrameInfo)
+		if !ok || info.Capture.IsZero() {
+			return writer.Write(header, payload, attributes)
+		}
+		ext := make([]byte, 8)
+		binary.BigEndian.PutUint64(ext, ntpTime(info.Capture))
+		if err := header.SetExtension(absCaptureTimeID, ext); err != nil {
+			return 0, err
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// CaptureTime returns the time of the abs-capture-time extension of header.
+// It is only comparable to the local clock if the clocks of sender and
+// receiver are synchronized, e.g., by NTP or PTP, or on the same host.
+func CaptureTime(header *rtp.Header) (time.Time, bool) {
+	ext := header.GetExtension(absCaptureTimeID)
+	// the optional estimated capture clock offset follows the timestamp
+	if len(ext) < 8 {
+		return time.Time{}, false
+	}
+	return ntpToTime(binary.BigEndian.Uint64(ext)), true
+}
@@ -0,0 +1,136 @@
+package rtp
+
+import (
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// CCSwitch wraps the interceptors of a congestion controller, so that the
+// congestion controller can be replaced during a session. The interceptors of
+// the new congestion controller are bound to all streams bound to the switch
+// before the old ones are closed. Like the Prober, a switch is its own
+// factory and can only be used by a single connection.
+type CCSwitch struct {
+	interceptor.NoOp
+
+	lock        sync.RWMutex
+	current     interceptor.Interceptor
+	rtcpReader  interceptor.RTCPReader
+	rtcpWriter  interceptor.RTCPWriter
+	boundReader interceptor.RTCPReader
+	boundWriter interceptor.RTCPWriter
+	// streams maps the bound streams to the writers passed to the switch,
+	// writers to the writers returned by the current congestion controller.
+	streams map[*interceptor.StreamInfo]interceptor.RTPWriter
+	writers map[*interceptor.StreamInfo]interceptor.RTPWriter
+}
+
+// NewCCSwitch creates a switch which starts with the congestion controller
+// registered by opts.
+func NewCCSwitch(opts ...Option) (*CCSwitch, error) {
+	current, err := buildCC(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &CCSwitch{
+		current: current,
+		streams: map[*interceptor.StreamInfo]interceptor.RTPWriter{},
+		writers: map[*interceptor.StreamInfo]interceptor.RTPWriter{},
+	}, nil
+}
+
+func buildCC(opts ...Option) (interceptor.Interceptor, error) {
+	r, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return r.Build("")
+}
+
+func (s *CCSwitch) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return s, nil
+}
+
+// Switch replaces the current congestion controller by the one registered by
+// opts.
+func (s *CCSwitch) Switch(opts ...Option) error {
+	next, err := buildCC(opts...)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	if s.rtcpReader != nil {
+		s.boundReader = next.BindRTCPReader(s.rtcpReader)
+	}
+	if s.rtcpWriter != nil {
+		s.boundWriter = next.BindRTCPWriter(s.rtcpWriter)
+	}
+	for info, writer := range s.streams {
+		s.writers[info] = next.BindLocalStream(info, writer)
+	}
+	prev := s.current
+	s.current = next
+	s.lock.Unlock()
+
+	return prev.Close()
+}
+
+func (s *CCSwitch) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	s.lock.Lock()
+	s.rtcpReader = reader
+	s.boundReader = s.current.BindRTCPReader(reader)
+	s.lock.Unlock()
+
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		s.lock.RLock()
+		r := s.boundReader
+		s.lock.RUnlock()
+		return r.Read(b, a)
+	})
+}
+
+func (s *CCSwitch) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	s.lock.Lock()
+	s.rtcpWriter = writer
+	s.boundWriter = s.current.BindRTCPWriter(writer)
+	s.lock.Unlock()
+
+	return interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, a interceptor.Attributes) (int, error) {
+		s.lock.RLock()
+		w := s.boundWriter
+		s.lock.RUnlock()
+		return w.Write(pkts, a)
+	})
+}
+
+func (s *CCSwitch) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	s.lock.Lock()
+	s.streams[info] = writer
+	s.writers[info] = s.current.BindLocalStream(info, writer)
+	s.lock.Unlock()
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+		s.lock.RLock()
+		w := s.writers[info]
+		s.lock.RUnlock()
+		return w.Write(header, payload, a)
+	})
+}
+
+func (s *CCSwitch) UnbindLocalStream(info *interceptor.StreamInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.streams, info)
+	delete(s.writers, info)
+	s.current.UnbindLocalStream(info)
+}
+
+func (s *CCSwitch) Close() error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.current.Close()
+}
@@ -0,0 +1,236 @@
+package rtp
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+)
+
+const (
+	feedbackMaxLevel = 3
+	// feedbackRecoveryTime is the time without signs of congestion after
+	// which the suppression level is decreased.
+	feedbackRecoveryTime = time.Second
+	// feedbackMaxWriteDelay is the time a write of RTCP packets may block
+	// before the RTCP flow is considered queued.
+	feedbackMaxWriteDelay = 10 * time.Millisecond
+	// feedbackMinRTTInflation is the minimum increase of the smoothed RTT of
+	// the feedback over its minimum considered congestion.
+	feedbackMinRTTInflation = 20 * time.Millisecond
+	// feedbackMaxBatchSize keeps coalesced RTCP packets within a single QUIC
+	// datagram.
+	feedbackMaxBatchSize = 1200
+)
+
+type feedbackThrottleFactory struct {
+	interval time.Duration
+}
+
+func (f *feedbackThrottleFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &feedbackThrottle{
+		interval: f.interval,
+	}, nil
+}
+
+// feedbackThrottle detects congestion on the path of the RTCP feedback and
+// reduces the number of feedback packets by coalescing the RTCP packets of
+// consecutive writes. Congestion is detected from inflation of the time it
+// takes until RTCP packets are acknowledged, which requires a transport
+// which calls the FEEDBACK_ACKED callback, from writes blocking on a queued
+// RTCP flow and from write errors. Each suppression level doubles the
+// interval in which coalesced packets are flushed. No feedback is dropped.
+type feedbackThrottle struct {
+	interceptor.NoOp
+
+	interval time.Duration
+
+	lock         sync.Mutex
+	writer       interceptor.RTCPWriter
+	level        int
+	levelChanged time.Time
+	congested    time.Time
+	coalesced    int
+	minRTT       time.Duration
+	smoothedRTT  time.Duration
+
+	pending     []rtcp.Packet
+	pendingSize int
+	attributes  interceptor.Attributes
+	lastFlush   time.Time
+	timer       *time.Timer
+}
+
+func (t *feedbackThrottle) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	t.lock.Lock()
+	t.writer = writer
+	t.lock.Unlock()
+
+	return interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+		now := time.Now()
+		t.lock.Lock()
+		t.relax(now)
+		if t.level == 0 {
+			t.lock.Unlock()
+			return t.write(pkts, attributes)
+		}
+		size := 0
+		for _, p := range pkts {
+			buf, err := p.Marshal()
+			if err != nil {
+				t.lock.Unlock()
+				return 0, err
+			}
+			size += len(buf)
+		}
+		var batch []rtcp.Packet
+		var batchAttributes interceptor.Attributes
+		if len(t.pending) > 0 && t.pendingSize+size > feedbackMaxBatchSize {
+			batch, batchAttributes = t.takePending(now)
+		}
+		t.pending = append(t.pending, pkts...)
+		t.pendingSize += size
+		t.attributes = attributes
+		t.coalesced++
+		if wait := t.flushInterval() - now.Sub(t.lastFlush); wait > 0 {
+			if t.timer == nil {
+				t.timer = time.AfterFunc(wait, t.flush)
+			}
+			t.lock.Unlock()
+			return t.writeBatch(batch, batchAttributes, size)
+		}
+		if batch != nil {
+			t.lock.Unlock()
+			if _, err := t.write(batch, batchAttributes); err != nil {
+				return 0, err
+			}
+			t.lock.Lock()
+		}
+		batch, batchAttributes = t.takePending(now)
+		t.lock.Unlock()
+		return t.writeBatch(batch, batchAttributes, size)
+	})
+}
+
+// writeBatch writes batch if it is not empty and returns n, the size of the
+// packets of the current write, on success.
+func (t *feedbackThrottle) writeBatch(batch []rtcp.Packet, attributes interceptor.Attributes, n int) (int, error) {
+	if len(batch) == 0 {
+		return n, nil
+	}
+	if _, err := t.write(batch, attributes); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (t *feedbackThrottle) flushInterval() time.Duration {
+	return t.interval << (t.level - 1)
+}
+
+// takePending returns the coalesced packets and resets the batch. t.lock has
+// to be held.
+func (t *feedbackThrottle) takePending(now time.Time) ([]rtcp.Packet, interceptor.Attributes) {
+	pkts, attributes := t.pending, t.attributes
+	t.pending = nil
+	t.pendingSize = 0
+	t.attributes = nil
+	t.lastFlush = now
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	return pkts, attributes
+}
+
+func (t *feedbackThrottle) flush() {
+	t.lock.Lock()
+	pkts, attributes := t.takePending(time.Now())
+	t.lock.Unlock()
+
+	if len(pkts) == 0 {
+		return
+	}
+	if _, err := t.write(pkts, attributes); err != nil {
+		log.Printf("failed to write coalesced RTCP feedback: %v", err)
+	}
+}
+
+func (t *feedbackThrottle) write(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+	a := make(interceptor.Attributes, len(attributes)+1)
+	for k, v := range attributes {
+		a[k] = v
+	}
+	sent := time.Now()
+	a.Set(FEEDBACK_ACKED, FeedbackAckedCallback(func() {
+		t.onAcked(time.Since(sent))
+	}))
+
+	t.lock.Lock()
+	writer := t.writer
+	t.lock.Unlock()
+
+	n, err := writer.Write(pkts, a)
+	now := time.Now()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err != nil {
+		t.onCongestion(now, "failed to write RTCP feedback: %v", err)
+	} else if d := now.Sub(sent); d > feedbackMaxWriteDelay {
+		t.onCongestion(now, "RTCP feedback queued for %v", d)
+	}
+	return n, err
+}
+
+func (t *feedbackThrottle) onAcked(rtt time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.minRTT == 0 || rtt < t.minRTT {
+		t.minRTT = rtt
+	}
+	if t.smoothedRTT == 0 {
+		t.smoothedRTT = rtt
+	} else {
+		t.smoothedRTT = (7*t.smoothedRTT + rtt) / 8
+	}
+	if t.smoothedRTT > 2*t.minRTT && t.smoothedRTT-t.minRTT > feedbackMinRTTInflation {
+		t.onCongestion(time.Now(), "RTCP feedback RTT inflated to %v, min RTT %v", t.smoothedRTT, t.minRTT)
+	}
+}
+
+// onCongestion increases the suppression level at most once per flush
+// interval of the current level. t.lock has to be held.
+func (t *feedbackThrottle) onCongestion(now time.Time, format string, args ...interface{}) {
+	t.congested = now
+	if t.level >= feedbackMaxLevel || (t.level > 0 && now.Sub(t.levelChanged) < t.flushInterval()) {
+		return
+	}
+	log.Printf(format, args...)
+	t.level++
+	t.levelChanged = now
+	log.Printf("feedback suppression level %v, flushing RTCP feedback every %v", t.level, t.flushInterval())
+}
+
+// relax decreases the suppression level after feedbackRecoveryTime without
+// congestion. t.lock has to be held.
+func (t *feedbackThrottle) relax(now time.Time) {
+	if t.level == 0 || now.Sub(t.congested) < feedbackRecoveryTime || now.Sub(t.levelChanged) < feedbackRecoveryTime {
+		return
+	}
+	t.level--
+	t.levelChanged = now
+	if t.level == 0 {
+		log.Printf("feedback suppression disabled, coalesced %v RTCP feedback writes", t.coalesced)
+		t.coalesced = 0
+		return
+	}
+	log.Printf("feedback suppression level %v, flushing RTCP feedback every %v", t.level, t.flushInterval())
+}
+
+func (t *feedbackThrottle) Close() error {
+	t.flush()
+	return nil
+}
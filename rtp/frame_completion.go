@@ -0,0 +1,50 @@
+package rtp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+type frameStart struct {
+	timestamp uint32
+	first     time.Time
+	size      int
+}
+
+// FrameCompletion measures the time from the arrival of the first packet of
+// a frame to the arrival of the packet completing the frame, i.e., the packet
+// with the marker bit set, and the size of the frame. Packets of a frame share
+// the RTP timestamp.
+type FrameCompletion struct {
+	lock   sync.Mutex
+	frames map[uint32]frameStart
+}
+
+func NewFrameCompletion() *FrameCompletion {
+	return &FrameCompletion{
+		frames: map[uint32]frameStart{},
+	}
+}
+
+// OnPacket returns the completion latency and the size in bytes of the
+// packets of the frame if the packet of size bytes completes it.
+func (c *FrameCompletion) OnPacket(now time.Time, header *rtp.Header, size int) (time.Duration, int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	start, ok := c.frames[header.SSRC]
+	if !ok || start.timestamp != header.Timestamp {
+		start = frameStart{
+			timestamp: header.Timestamp,
+			first:     now,
+		}
+	}
+	start.size += size
+	c.frames[header.SSRC] = start
+	if !header.Marker {
+		return 0, 0, false
+	}
+	return now.Sub(start.first), start.size, true
+}
@@ -0,0 +1,83 @@
+package rtp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// maxFrameDropperBurst limits how much unused rate a FrameDropper saves for
+// later frames.
+const maxFrameDropperBurst = 500 * time.Millisecond
+
+// FrameDropper decides which packets of a relayed stream are forwarded to a
+// downstream receiver. It forwards complete frames as long as the stream
+// stays within the target bitrate of the congestion controller of the
+// receiver and drops complete frames otherwise. After a dropped frame, all
+// frames up to the next key frame are dropped, because the receiver can't
+// decode them. A relay needs one FrameDropper per downstream receiver and
+// stream, it implements Media, so that it can be passed to the
+// BandwidthEstimator of the downstream connection.
+type FrameDropper struct {
+	lock sync.Mutex
+
+	target     float64
+	budget     float64
+	lastRefill time.Time
+
+	init            bool
+	timestamp       uint32
+	forwardFrame    bool
+	waitForKeyFrame bool
+}
+
+func NewFrameDropper(initialTarget uint) *FrameDropper {
+	return &FrameDropper{
+		target: float64(initialTarget),
+	}
+}
+
+// SetTargetBitsPerSecond sets the target bitrate of the downstream receiver.
+func (d *FrameDropper) SetTargetBitsPerSecond(r uint) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.target = float64(r)
+}
+
+// Forward returns whether the packet has to be forwarded. keyFrame has to be
+// true for the packets of frames which can be decoded independently. All
+// packets of a frame have to share the RTP timestamp.
+func (d *FrameDropper) Forward(now time.Time, header *rtp.Header, size int, keyFrame bool) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.init || header.Timestamp != d.timestamp {
+		d.init = true
+		d.timestamp = header.Timestamp
+		d.startFrame(now, keyFrame)
+	}
+	if d.forwardFrame {
+		d.budget -= float64(size)
+	}
+	return d.forwardFrame
+}
+
+func (d *FrameDropper) startFrame(now time.Time, keyFrame bool) {
+	if !d.lastRefill.IsZero() {
+		d.budget += d.target / 8 * now.Sub(d.lastRefill).Seconds()
+	}
+	d.lastRefill = now
+	if maxBudget := d.target / 8 * maxFrameDropperBurst.Seconds(); d.budget > maxBudget {
+		d.budget = maxBudget
+	}
+
+	if d.waitForKeyFrame && !keyFrame {
+		d.forwardFrame = false
+		return
+	}
+	// A frame is forwarded if the previous frames did not exceed the
+	// budget, so that a receiver always gets at least some frames.
+	d.forwardFrame = d.budget >= 0
+	d.waitForKeyFrame = !d.forwardFrame
+}
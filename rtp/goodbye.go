@@ -0,0 +1,62 @@
+package rtp
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pion/rtcp"
+)
+
+// Sources records the SSRCs of the sent RTP packets, so that a transport can
+// send an RTCP BYE for all of them when it shuts down.
+type Sources struct {
+	lock  sync.Mutex
+	ssrcs map[uint32]struct{}
+}
+
+func NewSources() *Sources {
+	return &Sources{
+		ssrcs: map[uint32]struct{}{},
+	}
+}
+
+// Add records ssrc.
+func (s *Sources) Add(ssrc uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ssrcs[ssrc] = struct{}{}
+}
+
+// Goodbye returns an RTCP BYE for all recorded SSRCs with reason, nil if no
+// packet was sent.
+func (s *Sources) Goodbye(reason string) []rtcp.Packet {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.ssrcs) == 0 {
+		return nil
+	}
+	ssrcs := make([]uint32, 0, len(s.ssrcs))
+	for ssrc := range s.ssrcs {
+		ssrcs = append(ssrcs, ssrc)
+	}
+	sort.Slice(ssrcs, func(i, j int) bool { return ssrcs[i] < ssrcs[j] })
+	return []rtcp.Packet{&rtcp.Goodbye{
+		Sources: ssrcs,
+		Reason:  reason,
+	}}
+}
+
+// Goodbyes returns the RTCP BYE packets in the compound RTCP packet buf.
+func Goodbyes(buf []byte) []*rtcp.Goodbye {
+	pkts, err := rtcp.Unmarshal(buf)
+	if err != nil {
+		return nil
+	}
+	var byes []*rtcp.Goodbye
+	for _, p := range pkts {
+		if bye, ok := p.(*rtcp.Goodbye); ok {
+			byes = append(byes, bye)
+		}
+	}
+	return byes
+}
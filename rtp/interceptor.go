@@ -1,10 +1,14 @@
 package rtp
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"time"
 
+	rqcc "github.com/Willi-42/rtp-over-quic/cc"
 	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/Willi-42/rtp-over-quic/nada"
 	"github.com/Willi-42/rtp-over-quic/scream"
 	"github.com/pion/interceptor"
 	"github.com/pion/interceptor/pkg/cc"
@@ -14,7 +18,23 @@ import (
 	"github.com/pion/interceptor/pkg/twcc"
 )
 
-const feedbackInterval = 10 * time.Millisecond
+const (
+	feedbackInterval = 10 * time.Millisecond
+
+	TransportCCURI = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+)
+
+// NewLocalStreamInfo returns the StreamInfo used to bind outgoing media
+// streams. It announces the transport-wide congestion control header
+// extension, which is stamped by the interceptor added by
+// RegisterTWCCHeaderExtension, and the abs-capture-time extension stamped by
+// RegisterAbsCaptureTime.
+func NewLocalStreamInfo() *interceptor.StreamInfo {
+	return &interceptor.StreamInfo{
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: TransportCCURI, ID: 1}, {URI: AbsCaptureTimeURI, ID: absCaptureTimeID}},
+		RTCPFeedback:        []interceptor.RTCPFeedback{{Type: "transport-cc"}},
+	}
+}
 
 type Option func(*interceptor.Registry) error
 
@@ -28,38 +48,82 @@ func New(options ...Option) (*interceptor.Registry, error) {
 	return &registry, nil
 }
 
-func RegisterSenderPacketLog(rtpLogFileName, rtcpLogFileName string) Option {
+// Formats of the packet logs.
+const (
+	PacketLogText    = "text"
+	PacketLogRTPDump = "rtpdump"
+)
+
+var errInvalidPacketLogFormat = errors.New("invalid packet log format")
+
+// RegisterSenderPacketLog logs sent RTP and received RTCP packets in format
+// to the given files.
+func RegisterSenderPacketLog(rtpLogFileName, rtcpLogFileName, format string) Option {
 	return func(r *interceptor.Registry) error {
-		rtpDumpFile, err := logging.GetLogFile(rtpLogFileName)
+		rtpDumpFile, rtcpDumpFile, err := packetLogFiles(rtpLogFileName, rtcpLogFileName)
 		if err != nil {
 			return err
 		}
-		rtcpDumpFile, err := logging.GetLogFile(rtcpLogFileName)
+		rtpFormat, rtcpFormat, err := packetLogFormatters(format, rtpDumpFile, rtcpDumpFile)
 		if err != nil {
 			return err
 		}
-		return registerRTPSenderDumper(r, rtpDumpFile, rtcpDumpFile)
+		return registerRTPSenderDumper(r, rtpDumpFile, rtcpDumpFile, rtpFormat, rtcpFormat)
 	}
 }
 
-func RegisterReceiverPacketLog(rtpLogFileName, rtcpLogFileName string) Option {
+// RegisterReceiverPacketLog logs received RTP and sent RTCP packets in
+// format to the given files.
+func RegisterReceiverPacketLog(rtpLogFileName, rtcpLogFileName, format string) Option {
 	return func(r *interceptor.Registry) error {
-		rtpDumpFile, err := logging.GetLogFile(rtpLogFileName)
+		rtpDumpFile, rtcpDumpFile, err := packetLogFiles(rtpLogFileName, rtcpLogFileName)
 		if err != nil {
 			return err
 		}
-		rtcpDumpFile, err := logging.GetLogFile(rtcpLogFileName)
+		rtpFormat, rtcpFormat, err := packetLogFormatters(format, rtpDumpFile, rtcpDumpFile)
 		if err != nil {
 			return err
 		}
-		return registerRTPReceiverDumper(r, rtpDumpFile, rtcpDumpFile)
+		return registerRTPReceiverDumper(r, rtpDumpFile, rtcpDumpFile, rtpFormat, rtcpFormat)
+	}
+}
+
+func packetLogFiles(rtpLogFileName, rtcpLogFileName string) (io.Writer, io.Writer, error) {
+	rtpDumpFile, err := logging.GetLogFile(rtpLogFileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	rtcpDumpFile, err := logging.GetLogFile(rtcpLogFileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rtpDumpFile, rtcpDumpFile, nil
+}
+
+// packetLogFormatters returns the formatters of format. The rtpdump format
+// requires a file header, which is written to the files.
+func packetLogFormatters(format string, rtpFile, rtcpFile io.Writer) (packetdump.RTPFormatCallback, packetdump.RTCPFormatCallback, error) {
+	switch format {
+	case "", PacketLogText:
+		rf := &rtpFormatter{}
+		return rf.rtpFormat, rtcpFormat, nil
+	case PacketLogRTPDump:
+		rtpDump, err := newRTPDumpFormatter(rtpFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		rtcpDump, err := newRTPDumpFormatter(rtcpFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return rtpDump.rtpFormat, rtcpDump.rtcpFormat, nil
 	}
+	return nil, nil, fmt.Errorf("%w: %v", errInvalidPacketLogFormat, format)
 }
 
-func registerRTPSenderDumper(r *interceptor.Registry, rtp, rtcp io.Writer) error {
-	rf := &rtpFormatter{}
+func registerRTPSenderDumper(r *interceptor.Registry, rtp, rtcp io.Writer, rtpFormat packetdump.RTPFormatCallback, rtcpFormat packetdump.RTCPFormatCallback) error {
 	rtpDumperInterceptor, err := packetdump.NewSenderInterceptor(
-		packetdump.RTPFormatter(rf.rtpFormat),
+		packetdump.RTPFormatter(rtpFormat),
 		packetdump.RTPWriter(rtp),
 	)
 	if err != nil {
@@ -78,7 +142,7 @@ func registerRTPSenderDumper(r *interceptor.Registry, rtp, rtcp io.Writer) error
 	return nil
 }
 
-func registerRTPReceiverDumper(r *interceptor.Registry, rtp, rtcp io.Writer) error {
+func registerRTPReceiverDumper(r *interceptor.Registry, rtp, rtcp io.Writer, rtpFormat packetdump.RTPFormatCallback, rtcpFormat packetdump.RTCPFormatCallback) error {
 	rtcpDumperInterceptor, err := packetdump.NewSenderInterceptor(
 		packetdump.RTCPFormatter(rtcpFormat),
 		packetdump.RTCPWriter(rtcp),
@@ -87,9 +151,8 @@ func registerRTPReceiverDumper(r *interceptor.Registry, rtp, rtcp io.Writer) err
 		return err
 	}
 
-	rf := &rtpFormatter{}
 	rtpDumperInterceptor, err := packetdump.NewReceiverInterceptor(
-		packetdump.RTPFormatter(rf.rtpFormat),
+		packetdump.RTPFormatter(rtpFormat),
 		packetdump.RTPWriter(rtp),
 	)
 	if err != nil {
@@ -122,10 +185,15 @@ func RegisterTWCCHeaderExtension() Option {
 	}
 }
 
-func RegisterGCC(cb cc.NewPeerConnectionCallback) Option {
+func RegisterGCC(cb cc.NewPeerConnectionCallback, initialBitrate, minBitrate, maxBitrate int) Option {
 	return func(r *interceptor.Registry) error {
 		fx := func() (cc.BandwidthEstimator, error) {
-			return gcc.NewSendSideBWE(gcc.SendSideBWEInitialBitrate(100_000), gcc.SendSideBWEPacer(gcc.NewLeakyBucketPacer(100_000)))
+			return gcc.NewSendSideBWE(
+				gcc.SendSideBWEInitialBitrate(initialBitrate),
+				gcc.SendSideBWEMinBitrate(minBitrate),
+				gcc.SendSideBWEMaxBitrate(maxBitrate),
+				gcc.SendSideBWEPacer(gcc.NewLeakyBucketPacer(initialBitrate)),
+			)
 		}
 		gccFactory, err := cc.NewInterceptor(fx)
 		if err != nil {
@@ -162,12 +230,13 @@ func RegisterRFC8888Pion() Option {
 	}
 }
 
-func RegisterSCReAM(cb scream.NewPeerConnectionCallback, initialBitrate int) Option {
+func RegisterSCReAM(cb scream.NewPeerConnectionCallback, initialBitrate, minBitrate, maxBitrate int) Option {
 	return func(r *interceptor.Registry) error {
 		var tx *scream.SenderInterceptorFactory
 		tx, err := scream.NewSenderInterceptor(
 			scream.InitialBitrate(float64(initialBitrate)),
-			scream.MinBitrate(100_000),
+			scream.MinBitrate(float64(minBitrate)),
+			scream.MaxBitrate(float64(maxBitrate)),
 		)
 		if err != nil {
 			return err
@@ -177,3 +246,223 @@ func RegisterSCReAM(cb scream.NewPeerConnectionCallback, initialBitrate int) Opt
 		return nil
 	}
 }
+
+func RegisterNADA(cb nada.NewPeerConnectionCallback, initialBitrate, minBitrate, maxBitrate int) Option {
+	return func(r *interceptor.Registry) error {
+		tx, err := nada.NewSenderInterceptor(
+			nada.InitialBitrate(float64(initialBitrate)),
+			nada.MinBitrate(float64(minBitrate)),
+			nada.MaxBitrate(float64(maxBitrate)),
+		)
+		if err != nil {
+			return err
+		}
+		tx.OnNewPeerConnection(cb)
+		r.Add(tx)
+		return nil
+	}
+}
+
+// RegisterCongestionController adds the interceptors created by a congestion
+// controller registered using cc.Register.
+func RegisterCongestionController(f rqcc.Factory, config rqcc.Config) Option {
+	return func(r *interceptor.Registry) error {
+		factories, err := f(config)
+		if err != nil {
+			return err
+		}
+		for _, factory := range factories {
+			r.Add(factory)
+		}
+		return nil
+	}
+}
+
+// RegisterCCSwitch adds the switch in place of the interceptors of the
+// congestion controller.
+func RegisterCCSwitch(s *CCSwitch) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(s)
+		return nil
+	}
+}
+
+// RegisterBufferHealth adds the estimator. It has to be registered before the
+// congestion controller and any interceptor changing sequence numbers.
+func RegisterBufferHealth(b *BufferHealth) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(b)
+		return nil
+	}
+}
+
+// RegisterTrafficCounter adds c. It counts the packets on the wire if it is
+// registered first.
+func RegisterTrafficCounter(c *TrafficCounter) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(c)
+		return nil
+	}
+}
+
+// RegisterPcapDump adds d. It has to be registered after SRTP.
+func RegisterPcapDump(d *PcapDump) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(d)
+		return nil
+	}
+}
+
+// RegisterFlowPause adds p. It has to be registered after the congestion
+// controller and a prober.
+func RegisterFlowPause(p *FlowPause) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(p)
+		return nil
+	}
+}
+
+// RegisterLayerSubscription adds s. On the sender, it has to be registered
+// after the congestion controller.
+func RegisterLayerSubscription(s *LayerSubscription) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(s)
+		return nil
+	}
+}
+
+// RegisterREMBReader adds r.
+func RegisterREMBReader(r *REMBReader) Option {
+	return func(reg *interceptor.Registry) error {
+		reg.Add(r)
+		return nil
+	}
+}
+
+// RegisterKeyframeRequestReader adds r.
+func RegisterKeyframeRequestReader(r *KeyframeRequestReader) Option {
+	return func(reg *interceptor.Registry) error {
+		reg.Add(r)
+		return nil
+	}
+}
+
+// RegisterNACKReader adds r.
+func RegisterNACKReader(r *NACKReader) Option {
+	return func(reg *interceptor.Registry) error {
+		reg.Add(r)
+		return nil
+	}
+}
+
+// RegisterAbsCaptureTime stamps the capture time of frames into their last
+// packet.
+func RegisterAbsCaptureTime() Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(&AbsCaptureTime{})
+		return nil
+	}
+}
+
+// RegisterSenderReports sends RTCP sender reports for all sent streams each
+// interval. clockRate returns the clock rate of a payload type. It has to be
+// registered early, so that the reports match the packets on the wire.
+func RegisterSenderReports(interval time.Duration, clockRate func(payloadType uint8) uint32) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(&senderReportsFactory{
+			interval:  interval,
+			clockRate: clockRate,
+		})
+		return nil
+	}
+}
+
+// RegisterLipSync adds l. It has to be registered after SRTP.
+func RegisterLipSync(l *LipSync) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(l)
+		return nil
+	}
+}
+
+// RegisterAppLimitedDetector adds the detector. It has to be registered after
+// the congestion controller and before a prober.
+func RegisterAppLimitedDetector(d *AppLimitedDetector) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(d)
+		return nil
+	}
+}
+
+// RegisterProber adds the prober. It has to be registered after the
+// congestion controller.
+func RegisterProber(p *Prober) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(p)
+		return nil
+	}
+}
+
+// RegisterRED encapsulates the packets of mediaPayloadType in RED packets
+// of payloadType, other packets are sent unchanged.
+func RegisterRED(payloadType, mediaPayloadType uint8, distance int) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(&redInterceptorFactory{
+			payloadType:      payloadType,
+			mediaPayloadType: mediaPayloadType,
+			distance:         distance,
+		})
+		return nil
+	}
+}
+
+// RegisterPrioritizer adds an interceptor which sets the RELIABILITY
+// attribute of outgoing packets according to policy. It has to be registered
+// last, so that the policy sees the packets as created by the media source.
+func RegisterPrioritizer(policy ReliabilityPolicy) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(&prioritizerFactory{
+			policy: policy,
+		})
+		return nil
+	}
+}
+
+// RegisterScreenContentPrioritizer adds a prioritizer which sends all packets
+// of screen content reliably, overriding the reliability set by the media
+// source. A lost delta frame of screen content leaves artifacts in static
+// text until the next key frame, which is rare with screen content, while
+// delta frames are small. It has to be registered last.
+func RegisterScreenContentPrioritizer() Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(&prioritizerFactory{
+			policy:   ReliableScreenContent,
+			override: true,
+		})
+		return nil
+	}
+}
+
+// RegisterFeedbackThrottle adds an interceptor which coalesces outgoing RTCP
+// feedback while the feedback path is congested, flushing it every interval
+// at the first suppression level. It has to be registered before the
+// interceptors generating the feedback.
+func RegisterFeedbackThrottle(interval time.Duration) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(&feedbackThrottleFactory{
+			interval: interval,
+		})
+		return nil
+	}
+}
+
+// RegisterSRTP adds SRTP protection using the given master key and salt. It
+// has to be the first option passed to New.
+func RegisterSRTP(key []byte) Option {
+	return func(r *interceptor.Registry) error {
+		r.Add(&srtpInterceptorFactory{
+			key: key,
+		})
+		return nil
+	}
+}
@@ -0,0 +1,108 @@
+package rtp
+
+// KeyFrameFromPayload returns whether an RTP packet of codec belongs to a key
+// frame, parsed from its payload, for forwarders which don't get the frame
+// type from a media source. VP8 key frames are only detected in the first
+// packet of a frame. Packets of codecs without inter-frame prediction, e.g.,
+// Opus, always belong to key frames.
+func KeyFrameFromPayload(codec string, payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	switch codec {
+	case "h264":
+		return h264KeyFrame(payload)
+	case "h265":
+		return h265KeyFrame(payload)
+	case "vp8":
+		return vp8KeyFrame(payload)
+	case "vp9":
+		// not inter-picture predicted and start of a frame (RFC 9628)
+		return payload[0]&0x40 == 0 && payload[0]&0x08 != 0
+	case "av1":
+		// N: first packet of a coded video sequence
+		return payload[0]&0x08 != 0
+	}
+	return true
+}
+
+// h264KeyFrame returns whether a single NAL unit, STAP-A or FU-A payload
+// (RFC 6184) carries an IDR slice or a sequence parameter set.
+func h264KeyFrame(payload []byte) bool {
+	isKey := func(nalType byte) bool {
+		return nalType == 5 || nalType == 7
+	}
+	switch nalType := payload[0] & 0x1f; nalType {
+	case 24: // STAP-A
+		for i := 1; i+2 < len(payload); {
+			size := int(payload[i])<<8 | int(payload[i+1])
+			if isKey(payload[i+2] & 0x1f) {
+				return true
+			}
+			i += 2 + size
+		}
+		return false
+	case 28: // FU-A
+		return len(payload) > 1 && isKey(payload[1]&0x1f)
+	default:
+		return isKey(nalType)
+	}
+}
+
+// h265KeyFrame returns whether a single NAL unit, aggregation or
+// fragmentation unit payload (RFC 7798) carries an IRAP picture or a
+// parameter set.
+func h265KeyFrame(payload []byte) bool {
+	isKey := func(nalType byte) bool {
+		return (nalType >= 16 && nalType <= 21) || (nalType >= 32 && nalType <= 34)
+	}
+	switch nalType := payload[0] >> 1 & 0x3f; nalType {
+	case 48: // AP
+		for i := 2; i+2 < len(payload); {
+			size := int(payload[i])<<8 | int(payload[i+1])
+			if isKey(payload[i+2] >> 1 & 0x3f) {
+				return true
+			}
+			i += 2 + size
+		}
+		return false
+	case 49: // FU
+		return len(payload) > 2 && isKey(payload[2]&0x3f)
+	default:
+		return isKey(nalType)
+	}
+}
+
+// vp8KeyFrame parses the P bit of the VP8 payload header following the
+// payload descriptor (RFC 7741), which is only present in the first packet
+// of a frame.
+func vp8KeyFrame(payload []byte) bool {
+	// S: start of partition, PID: partition 0
+	if payload[0]&0x10 == 0 || payload[0]&0x07 != 0 {
+		return false
+	}
+	i := 1
+	if payload[0]&0x80 != 0 { // X: extended control bits
+		if len(payload) < 2 {
+			return false
+		}
+		x := payload[1]
+		i++
+		if x&0x80 != 0 { // I: picture ID
+			if len(payload) <= i {
+				return false
+			}
+			if payload[i]&0x80 != 0 {
+				i++
+			}
+			i++
+		}
+		if x&0x40 != 0 { // L: TL0PICIDX
+			i++
+		}
+		if x&0x30 != 0 { // T or K: TID and KEYIDX
+			i++
+		}
+	}
+	return len(payload) > i && payload[i]&0x01 == 0
+}
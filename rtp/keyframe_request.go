@@ -0,0 +1,48 @@
+package rtp
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+)
+
+// KeyframeRequestReader calls a function with the media SSRC of each
+// received RTCP PLI and FIR, e.g., sent by a receiver which lost the
+// reference frames or just joined.
+type KeyframeRequestReader struct {
+	interceptor.NoOp
+	onRequest func(ssrc uint32)
+}
+
+func NewKeyframeRequestReader(onRequest func(ssrc uint32)) *KeyframeRequestReader {
+	return &KeyframeRequestReader{
+		onRequest: onRequest,
+	}
+}
+
+func (r *KeyframeRequestReader) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return r, nil
+}
+
+func (r *KeyframeRequestReader) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		pkts, err := rtcp.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, nil
+		}
+		for _, pkt := range pkts {
+			switch p := pkt.(type) {
+			case *rtcp.PictureLossIndication:
+				r.onRequest(p.MediaSSRC)
+			case *rtcp.FullIntraRequest:
+				for _, e := range p.FIR {
+					r.onRequest(e.SSRC)
+				}
+			}
+		}
+		return n, attr, nil
+	})
+}
@@ -0,0 +1,68 @@
+package rtp
+
+// LayerFromPayload returns the spatial and temporal layer of an RTP packet of
+// codec from its payload descriptor, for forwarders which don't get the LAYER
+// attribute from a media source. It returns false for codecs without layer
+// information in the payload and for packets without layer indices.
+func LayerFromPayload(codec string, payload []byte) (LayerInfo, bool) {
+	switch codec {
+	case "vp8":
+		return vp8Layer(payload)
+	case "vp9":
+		return vp9Layer(payload)
+	}
+	return LayerInfo{}, false
+}
+
+// vp8Layer parses the TID of the VP8 payload descriptor (RFC 7741).
+func vp8Layer(payload []byte) (LayerInfo, bool) {
+	if len(payload) < 1 || payload[0]&0x80 == 0 {
+		return LayerInfo{}, false
+	}
+	if len(payload) < 2 {
+		return LayerInfo{}, false
+	}
+	x := payload[1]
+	i := 2
+	if x&0x80 != 0 { // I: picture ID
+		if len(payload) <= i {
+			return LayerInfo{}, false
+		}
+		if payload[i]&0x80 != 0 {
+			i++
+		}
+		i++
+	}
+	if x&0x40 != 0 { // L: TL0PICIDX
+		i++
+	}
+	if x&0x20 == 0 || len(payload) <= i { // T: TID
+		return LayerInfo{}, false
+	}
+	return LayerInfo{Temporal: payload[i] >> 6}, true
+}
+
+// vp9Layer parses the layer indices of the VP9 payload descriptor (RFC
+// 9628).
+func vp9Layer(payload []byte) (LayerInfo, bool) {
+	if len(payload) < 1 || payload[0]&0x20 == 0 {
+		return LayerInfo{}, false
+	}
+	i := 1
+	if payload[0]&0x80 != 0 { // I: picture ID
+		if len(payload) <= i {
+			return LayerInfo{}, false
+		}
+		if payload[i]&0x80 != 0 {
+			i++
+		}
+		i++
+	}
+	if len(payload) <= i {
+		return LayerInfo{}, false
+	}
+	return LayerInfo{
+		Temporal: payload[i] >> 5,
+		Spatial:  payload[i] >> 1 & 0x07,
+	}, true
+}
@@ -0,0 +1,198 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+var errNoRTCPWriter = errors.New("no RTCP writer")
+
+// AllLayers subscribes to all spatial or temporal layers.
+const AllLayers = 0xff
+
+// layerSubscriptionSize is the size of the application dependent data of a
+// layer subscription: spatial and temporal layer, frame rate, a reserved
+// byte, height and two reserved bytes.
+const layerSubscriptionSize = 8
+
+// appNameSubscribe is the name of the RTCP APP packets carrying layer
+// subscriptions.
+var appNameSubscribe = [4]byte{'L', 'S', 'U', 'B'}
+
+// Layers are the layers of a stream a receiver subscribes to. The sender
+// forwards packets up to the spatial and temporal layer, and of layers not
+// exceeding MaxHeight and MaxFrameRate, if they are not 0.
+type Layers struct {
+	Spatial      uint8
+	Temporal     uint8
+	MaxHeight    uint16
+	MaxFrameRate uint8
+}
+
+func (l Layers) String() string {
+	return fmt.Sprintf("spatial %v, temporal %v, max height %v, max frame rate %v", l.Spatial, l.Temporal, l.MaxHeight, l.MaxFrameRate)
+}
+
+func (l Layers) forward(info LayerInfo) bool {
+	return (l.Spatial == AllLayers || info.Spatial <= l.Spatial) &&
+		(l.Temporal == AllLayers || info.Temporal <= l.Temporal) &&
+		(l.MaxHeight == 0 || info.Height == 0 || info.Height <= l.MaxHeight) &&
+		(l.MaxFrameRate == 0 || info.FrameRate == 0 || info.FrameRate <= l.MaxFrameRate)
+}
+
+func (l Layers) marshal() []byte {
+	buf := make([]byte, layerSubscriptionSize)
+	buf[0] = l.Spatial
+	buf[1] = l.Temporal
+	buf[2] = l.MaxFrameRate
+	binary.BigEndian.PutUint16(buf[4:], l.MaxHeight)
+	return buf
+}
+
+func unmarshalLayers(buf []byte) (Layers, error) {
+	if len(buf) < layerSubscriptionSize {
+		return Layers{}, fmt.Errorf("%w: layer subscription too short: %v bytes", errInvalidAPPPacket, len(buf))
+	}
+	return Layers{
+		Spatial:      buf[0],
+		Temporal:     buf[1],
+		MaxFrameRate: buf[2],
+		MaxHeight:    binary.BigEndian.Uint16(buf[4:]),
+	}, nil
+}
+
+// LayerSubscription lets receivers choose the layers of simulcast or
+// scalable streams they receive. Receivers call Subscribe, which sends the
+// subscription to the sender in an RTCP APP packet. Senders drop the packets
+// of layers above the subscription, using the LAYER attribute set by the
+// media source. Packets without the attribute are always sent. Sequence
+// numbers are not rewritten, a relay which hides the dropped packets from
+// its receivers filters with Forward before rebasing the streams. The same
+// LayerSubscription is used for all interceptor chains it is registered in,
+// on the sender it has to be registered after the congestion controller, so
+// that the controller only sees the packets which are sent.
+type LayerSubscription struct {
+	interceptor.NoOp
+
+	lock   sync.Mutex
+	flows  map[uint32]Layers
+	writer interceptor.RTCPWriter
+	// defaults are the layers of flows without a subscription, nil
+	// forwards all layers.
+	defaults *Layers
+}
+
+func NewLayerSubscription() *LayerSubscription {
+	return &LayerSubscription{
+		flows: map[uint32]Layers{},
+	}
+}
+
+func (s *LayerSubscription) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return s, nil
+}
+
+// Subscribe sends a subscription of layers of the stream ssrc to the
+// sender.
+func (s *LayerSubscription) Subscribe(ssrc uint32, layers Layers) error {
+	s.lock.Lock()
+	writer := s.writer
+	s.lock.Unlock()
+	if writer == nil {
+		return fmt.Errorf("%w: can't send the subscription of flow %v", errNoRTCPWriter, ssrc)
+	}
+	log.Printf("subscribing to %v of flow %v", layers, ssrc)
+	_, err := writer.Write([]rtcp.Packet{newAPPPacket(ssrc, appNameSubscribe, layers.marshal())}, interceptor.Attributes{})
+	return err
+}
+
+// SetDefault forwards layers of flows the receiver did not subscribe to,
+// e.g., on a relay which limits the layers per receiver.
+func (s *LayerSubscription) SetDefault(layers Layers) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.defaults = &layers
+}
+
+// Layers returns the layers of ssrc subscribed by the receiver.
+func (s *LayerSubscription) Layers(ssrc uint32) (Layers, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	layers, ok := s.flows[ssrc]
+	return layers, ok
+}
+
+// Forward returns whether a packet of the layer info of the stream ssrc is
+// sent to the receiver.
+func (s *LayerSubscription) Forward(ssrc uint32, info LayerInfo) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	layers, ok := s.flows[ssrc]
+	if !ok {
+		if s.defaults == nil {
+			return true
+		}
+		layers = *s.defaults
+	}
+	return layers.forward(info)
+}
+
+func (s *LayerSubscription) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.writer = writer
+	return writer
+}
+
+func (s *LayerSubscription) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if info, ok := attributes.Get(LAYER).(LayerInfo); ok && !s.Forward(header.SSRC, info) {
+			return header.MarshalSize() + len(payload), nil
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (s *LayerSubscription) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		pkts, err := rtcp.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, nil
+		}
+		for _, pkt := range pkts {
+			raw, ok := pkt.(*rtcp.RawPacket)
+			if !ok {
+				continue
+			}
+			ssrc, name, data, err := parseAPPPacket(*raw)
+			if err != nil || name != appNameSubscribe {
+				continue
+			}
+			layers, err := unmarshalLayers(data)
+			if err != nil {
+				log.Printf("invalid layer subscription for flow %v: %v", ssrc, err)
+				continue
+			}
+			s.onSubscribe(ssrc, layers)
+		}
+		return n, attr, nil
+	})
+}
+
+func (s *LayerSubscription) onSubscribe(ssrc uint32, layers Layers) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flows[ssrc] = layers
+	log.Printf("receiver subscribed to %v of flow %v", layers, ssrc)
+}
@@ -0,0 +1,179 @@
+package rtp
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const (
+	// lipSyncTolerance is the skew between two played streams up to which
+	// the delay of a stream is not changed. It is well below the 45 ms
+	// audio lead noticeable to viewers.
+	lipSyncTolerance = 15 * time.Millisecond
+	// lipSyncMaxDelay bounds the delay added to a stream, larger skews are
+	// not caused by the network and can't be fixed by waiting.
+	lipSyncMaxDelay = time.Second
+)
+
+type syncedStream struct {
+	clockRate uint32
+
+	// mapping of the last sender report
+	reported  bool
+	ntp       time.Time
+	timestamp uint32
+
+	// transit is the smoothed time from the sender wallclock of the
+	// packets of the stream to their arrival. It includes the offset of the
+	// clocks of sender and receiver, which is the same for all streams of a
+	// sender and cancels out.
+	transit time.Duration
+	played  bool
+	delay   time.Duration
+}
+
+// LipSync synchronizes the playout of the streams of one sender, e.g., audio
+// and video on different flows with different priorities. It maps the RTP
+// timestamps of the received packets to the wallclock of the sender with the
+// RTCP sender reports of each stream and measures the transit time of each
+// stream. Streams arriving earlier than the slowest played stream are
+// delayed by the difference, which the media sinks apply with Delay before
+// playout.
+type LipSync struct {
+	interceptor.NoOp
+
+	clockRate func(payloadType uint8) uint32
+
+	lock    sync.Mutex
+	streams map[uint32]*syncedStream
+}
+
+func NewLipSync(clockRate func(payloadType uint8) uint32) *LipSync {
+	return &LipSync{
+		clockRate: clockRate,
+		streams:   map[uint32]*syncedStream{},
+	}
+}
+
+func (l *LipSync) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return l, nil
+}
+
+func (l *LipSync) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		pkts, err := rtcp.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, nil
+		}
+		for _, pkt := range pkts {
+			if sr, ok := pkt.(*rtcp.SenderReport); ok {
+				l.onSenderReport(sr)
+			}
+		}
+		return n, attr, nil
+	})
+}
+
+func (l *LipSync) BindRemoteStream(_ *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		var header rtp.Header
+		if _, err := header.Unmarshal(b[:n]); err != nil {
+			return n, attr, nil
+		}
+		l.onPacket(&header, time.Now())
+		return n, attr, nil
+	})
+}
+
+func (l *LipSync) stream(ssrc uint32, payloadType uint8) *syncedStream {
+	s, ok := l.streams[ssrc]
+	if !ok {
+		s = &syncedStream{
+			clockRate: l.clockRate(payloadType),
+		}
+		l.streams[ssrc] = s
+	}
+	return s
+}
+
+func (l *LipSync) onSenderReport(sr *rtcp.SenderReport) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	s, ok := l.streams[sr.SSRC]
+	if !ok {
+		// the clock rate is known from the first packet of the stream
+		return
+	}
+	s.reported = true
+	s.ntp = ntpToTime(sr.NTPTime)
+	s.timestamp = sr.RTPTime
+}
+
+func (l *LipSync) onPacket(header *rtp.Header, arrival time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	s := l.stream(header.SSRC, header.PayloadType)
+	if !s.reported || s.clockRate == 0 {
+		return
+	}
+	elapsed := time.Duration(float64(int32(header.Timestamp-s.timestamp)) / float64(s.clockRate) * float64(time.Second))
+	transit := arrival.Sub(s.ntp.Add(elapsed))
+	if s.transit == 0 {
+		s.transit = transit
+	} else {
+		s.transit = (15*s.transit + transit) / 16
+	}
+}
+
+// Delay returns the time packets of ssrc have to be held back to play in
+// sync with the other played streams. Calling Delay marks the stream as
+// played, streams which are not played, e.g., because the sink plays another
+// stream, are not waited for.
+func (l *LipSync) Delay(ssrc uint32) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	s, ok := l.streams[ssrc]
+	if !ok {
+		return 0
+	}
+	s.played = true
+	if !s.reported {
+		return s.delay
+	}
+	var slowest time.Duration
+	synced := 0
+	for _, o := range l.streams {
+		if !o.played || !o.reported {
+			continue
+		}
+		synced++
+		if o.transit > slowest {
+			slowest = o.transit
+		}
+	}
+	if synced < 2 {
+		return s.delay
+	}
+	delay := slowest - s.transit
+	if delay > lipSyncMaxDelay {
+		delay = lipSyncMaxDelay
+	}
+	if d := delay - s.delay; d > lipSyncTolerance || d < -lipSyncTolerance {
+		log.Printf("lip sync: delaying stream %v by %v", ssrc, delay.Round(time.Millisecond))
+		s.delay = delay
+	}
+	return s.delay
+}
@@ -0,0 +1,49 @@
+package rtp
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+)
+
+// NACKReader calls a function with the media SSRC and the sequence numbers
+// of each received RTCP generic NACK, e.g., on a relay which forwards the
+// NACKs of its receivers to the sender.
+type NACKReader struct {
+	interceptor.NoOp
+	onNACK func(ssrc uint32, seqNrs []uint16)
+}
+
+func NewNACKReader(onNACK func(ssrc uint32, seqNrs []uint16)) *NACKReader {
+	return &NACKReader{
+		onNACK: onNACK,
+	}
+}
+
+func (r *NACKReader) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return r, nil
+}
+
+func (r *NACKReader) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		pkts, err := rtcp.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, nil
+		}
+		for _, pkt := range pkts {
+			nack, ok := pkt.(*rtcp.TransportLayerNack)
+			if !ok {
+				continue
+			}
+			seqNrs := []uint16{}
+			for _, pair := range nack.Nacks {
+				seqNrs = append(seqNrs, pair.PacketList()...)
+			}
+			r.onNACK(nack.MediaSSRC, seqNrs)
+		}
+		return n, attr, nil
+	})
+}
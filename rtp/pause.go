@@ -0,0 +1,206 @@
+package rtp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// Names of the RTCP APP packets announcing that a flow was paused or
+// resumed.
+var (
+	appNamePause  = [4]byte{'P', 'A', 'U', 'S'}
+	appNameResume = [4]byte{'R', 'S', 'U', 'M'}
+)
+
+var errUnknownSSRC = errors.New("unknown SSRC")
+
+type pausedFlow struct {
+	paused bool
+	// dropped is the number of packets not sent while paused, subtracted
+	// from the sequence numbers, so that a pause does not look like loss.
+	dropped uint16
+}
+
+// FlowPause pauses and resumes sending of individual flows. Packets of a
+// paused flow are dropped before the congestion controller sees them, so the
+// connection and the state of the controller are kept. With a prober, the
+// controller is kept warm by padding. Sequence numbers of the following
+// packets are shifted to close the gap. Pauses are announced to the receiver
+// with RTCP APP packets if the transport writes RTCP. The receiver keeps
+// showing the last frame while a flow is paused. The same FlowPause is used
+// for all interceptor chains it is registered in, it has to be registered
+// after the congestion controller and a prober.
+type FlowPause struct {
+	interceptor.NoOp
+
+	lock   sync.Mutex
+	flows  map[uint32]*pausedFlow
+	all    bool
+	writer interceptor.RTCPWriter
+
+	onChange func(ssrc uint32, paused bool)
+}
+
+func NewFlowPause() *FlowPause {
+	return &FlowPause{
+		flows: map[uint32]*pausedFlow{},
+	}
+}
+
+func (p *FlowPause) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return p, nil
+}
+
+// OnChange sets a callback which is called when a remote sender pauses or
+// resumes a flow.
+func (p *FlowPause) OnChange(f func(ssrc uint32, paused bool)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.onChange = f
+}
+
+// Pause stops sending the flow ssrc, 0 pauses all flows.
+func (p *FlowPause) Pause(ssrc uint32) error {
+	return p.set(ssrc, true)
+}
+
+// Resume continues sending the flow ssrc, 0 resumes all flows.
+func (p *FlowPause) Resume(ssrc uint32) error {
+	return p.set(ssrc, false)
+}
+
+func (p *FlowPause) set(ssrc uint32, paused bool) error {
+	p.lock.Lock()
+	var ssrcs []uint32
+	if ssrc == 0 {
+		p.all = paused
+		for s, f := range p.flows {
+			f.paused = paused
+			ssrcs = append(ssrcs, s)
+		}
+	} else {
+		f, ok := p.flows[ssrc]
+		if !ok {
+			p.lock.Unlock()
+			return fmt.Errorf("%w: %v", errUnknownSSRC, ssrc)
+		}
+		f.paused = paused
+		ssrcs = []uint32{ssrc}
+	}
+	writer := p.writer
+	p.lock.Unlock()
+
+	name := appNameResume
+	if paused {
+		name = appNamePause
+	}
+	for _, s := range ssrcs {
+		if paused {
+			log.Printf("pausing flow %v", s)
+		} else {
+			log.Printf("resuming flow %v", s)
+		}
+		if writer == nil {
+			continue
+		}
+		if _, err := writer.Write([]rtcp.Packet{newAPPPacket(s, name, nil)}, interceptor.Attributes{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Paused returns whether the flow ssrc is paused.
+func (p *FlowPause) Paused(ssrc uint32) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	f, ok := p.flows[ssrc]
+	return ok && f.paused
+}
+
+func (p *FlowPause) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.writer = writer
+	return writer
+}
+
+func (p *FlowPause) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		p.lock.Lock()
+		f, ok := p.flows[header.SSRC]
+		if !ok {
+			f = &pausedFlow{paused: p.all}
+			p.flows[header.SSRC] = f
+		}
+		if f.paused {
+			f.dropped++
+			p.lock.Unlock()
+			return header.MarshalSize() + len(payload), nil
+		}
+		header.SequenceNumber -= f.dropped
+		p.lock.Unlock()
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (p *FlowPause) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		pkts, err := rtcp.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, nil
+		}
+		for _, pkt := range pkts {
+			raw, ok := pkt.(*rtcp.RawPacket)
+			if !ok {
+				continue
+			}
+			ssrc, name, _, err := parseAPPPacket(*raw)
+			if err != nil {
+				continue
+			}
+			switch name {
+			case appNamePause:
+				p.onRemoteChange(ssrc, true)
+			case appNameResume:
+				p.onRemoteChange(ssrc, false)
+			}
+		}
+		return n, attr, nil
+	})
+}
+
+func (p *FlowPause) onRemoteChange(ssrc uint32, paused bool) {
+	p.lock.Lock()
+	f, ok := p.flows[ssrc]
+	if !ok {
+		f = &pausedFlow{}
+		p.flows[ssrc] = f
+	}
+	changed := f.paused != paused
+	f.paused = paused
+	onChange := p.onChange
+	p.lock.Unlock()
+
+	if !changed {
+		return
+	}
+	if paused {
+		log.Printf("flow %v paused by sender, showing last frame", ssrc)
+	} else {
+		log.Printf("flow %v resumed by sender", ssrc)
+	}
+	if onChange != nil {
+		onChange(ssrc, paused)
+	}
+}
@@ -0,0 +1,117 @@
+package rtp
+
+import (
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/logging"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// Fake endpoints of the packets written by a PcapDump. RTP and RTCP share
+// the port, Wireshark detects RTP with the rtp_udp heuristic.
+var (
+	pcapSender   = logging.UDPEndpoint{IP: net.IPv4(10, 0, 0, 1), Port: 5004}
+	pcapReceiver = logging.UDPEndpoint{IP: net.IPv4(10, 0, 0, 2), Port: 5004}
+)
+
+// PcapDump writes the plaintext RTP and RTCP packets of all interceptor
+// chains it is registered in to a pcapng file as if they were sent over
+// UDP between two fake addresses. It has to be registered after SRTP to see
+// plaintext packets.
+type PcapDump struct {
+	interceptor.NoOp
+
+	file   io.Closer
+	pcap   *logging.PcapngWriter
+	local  logging.UDPEndpoint
+	remote logging.UDPEndpoint
+}
+
+// NewPcapDump creates a dump written to file. sender selects whether
+// outgoing packets are written as sent by the sender or the receiver.
+func NewPcapDump(file string, sender bool) (*PcapDump, error) {
+	w, err := logging.GetLogFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pcap, err := logging.NewPcapngWriter(w)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	d := &PcapDump{
+		file:   w,
+		pcap:   pcap,
+		local:  pcapReceiver,
+		remote: pcapSender,
+	}
+	if sender {
+		d.local, d.remote = pcapSender, pcapReceiver
+	}
+	return d, nil
+}
+
+func (d *PcapDump) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return d, nil
+}
+
+// CloseFile closes the file. Closing an interceptor chain does not close
+// it, because other chains may share the dump.
+func (d *PcapDump) CloseFile() error {
+	return d.file.Close()
+}
+
+func (d *PcapDump) write(out bool, buf []byte) {
+	src, dst := d.remote, d.local
+	if out {
+		src, dst = d.local, d.remote
+	}
+	if err := d.pcap.WritePacket(time.Now(), src, dst, buf); err != nil {
+		log.Printf("failed to write pcap: %v", err)
+	}
+}
+
+func (d *PcapDump) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		buf, err := header.Marshal()
+		if err == nil {
+			d.write(true, append(buf, payload...))
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (d *PcapDump) BindRemoteStream(_ *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err == nil {
+			d.write(false, b[:n])
+		}
+		return n, attr, err
+	})
+}
+
+func (d *PcapDump) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	return interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+		buf, err := rtcp.Marshal(pkts)
+		if err == nil {
+			d.write(true, buf)
+		}
+		return writer.Write(pkts, attributes)
+	})
+}
+
+func (d *PcapDump) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err == nil {
+			d.write(false, b[:n])
+		}
+		return n, attr, err
+	})
+}
@@ -0,0 +1,52 @@
+package rtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+func TestPcapDump(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dump.pcapng")
+	d, err := NewPcapDump(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := &rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 1, SSRC: 1}
+	payload := []byte{1, 2, 3}
+	w := d.BindLocalStream(&interceptor.StreamInfo{}, interceptor.RTPWriterFunc(func(_ *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		return len(payload), nil
+	}))
+	if _, err := w.Write(header, payload, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CloseFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// skip the section header and interface description blocks
+	for i := 0; i < 2; i++ {
+		buf = buf[binary.LittleEndian.Uint32(buf[4:]):]
+	}
+	ip := buf[28 : 28+binary.LittleEndian.Uint32(buf[20:])]
+	if src := net.IP(ip[12:16]); !src.Equal(pcapSender.IP) {
+		t.Errorf("got source %v, want %v", src, pcapSender.IP)
+	}
+	want, err := (&rtp.Packet{Header: *header, Payload: payload}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ip[20+8:]; !bytes.Equal(got, want) {
+		t.Errorf("got packet %x, want %x", got, want)
+	}
+}
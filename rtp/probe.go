@@ -0,0 +1,222 @@
+package rtp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	// maxPaddingSize is the largest padding an RTP packet can carry, the
+	// padding length is encoded in a single byte.
+	maxPaddingSize = 255
+
+	probeTick       = 5 * time.Millisecond
+	probeRateWindow = 500 * time.Millisecond
+)
+
+type ProberOption func(*Prober) error
+
+// ProbeGain sets the rate probed for relative to the target bitrate.
+func ProbeGain(g float64) ProberOption {
+	return func(p *Prober) error {
+		p.gain = g
+		return nil
+	}
+}
+
+// ProbeInterval sets the time between the start of two probe bursts.
+func ProbeInterval(d time.Duration) ProberOption {
+	return func(p *Prober) error {
+		p.interval = d
+		return nil
+	}
+}
+
+// ProbeDuration sets the duration of a probe burst.
+func ProbeDuration(d time.Duration) ProberOption {
+	return func(p *Prober) error {
+		p.duration = d
+		return nil
+	}
+}
+
+type sentBytes struct {
+	at      time.Time
+	size    int
+	padding bool
+}
+
+// Prober probes for capacity above the current send rate by adding RTP
+// padding packets to the media stream while the media is application
+// limited, i.e., sends less than the target bitrate. Without probing, the
+// congestion controller can't ramp up after application limited periods,
+// because it never observes a higher rate. The prober renumbers all packets
+// of the stream to put the padding packets in the sequence number space of
+// the media. It has to be registered after the congestion controller, so
+// that the controller accounts for the padding.
+type Prober struct {
+	interceptor.NoOp
+
+	gain     float64
+	interval time.Duration
+	duration time.Duration
+
+	lock       sync.Mutex
+	writer     interceptor.RTPWriter
+	header     *rtp.Header
+	seqNr      uint16
+	target     int
+	sent       []sentBytes
+	mediaBytes int
+	totalBytes int
+	credit     float64
+
+	startOnce sync.Once
+	close     chan struct{}
+	done      chan struct{}
+}
+
+func NewProber(opts ...ProberOption) (*Prober, error) {
+	p := &Prober{
+		gain:     1.5,
+		interval: time.Second,
+		duration: 100 * time.Millisecond,
+		close:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// NewInterceptor returns the prober itself, a prober can only be used with a
+// single media stream.
+func (p *Prober) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return p, nil
+}
+
+// SetTargetBitsPerSecond sets the target bitrate of the congestion
+// controller.
+func (p *Prober) SetTargetBitsPerSecond(r uint) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.target = int(r)
+}
+
+func (p *Prober) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	p.lock.Lock()
+	p.writer = writer
+	p.lock.Unlock()
+
+	p.startOnce.Do(func() {
+		go p.loop()
+	})
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		header.SequenceNumber = p.seqNr
+		p.seqNr++
+		last := *header
+		p.header = &last
+		p.onSent(time.Now(), header.MarshalSize()+len(payload), false)
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (p *Prober) onSent(now time.Time, size int, padding bool) {
+	p.sent = append(p.sent, sentBytes{at: now, size: size, padding: padding})
+	p.totalBytes += size
+	if !padding {
+		p.mediaBytes += size
+	}
+	i := 0
+	for ; i < len(p.sent) && now.Sub(p.sent[i].at) > probeRateWindow; i++ {
+		p.totalBytes -= p.sent[i].size
+		if !p.sent[i].padding {
+			p.mediaBytes -= p.sent[i].size
+		}
+	}
+	p.sent = p.sent[i:]
+}
+
+// rate converts bytes sent in the rate window to bits per second.
+func rate(bytes int) float64 {
+	return 8 * float64(bytes) / probeRateWindow.Seconds()
+}
+
+func (p *Prober) loop() {
+	defer close(p.done)
+	ticker := time.NewTicker(probeTick)
+	defer ticker.Stop()
+	burstStart := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			if now.Sub(burstStart) >= p.interval {
+				burstStart = now
+			}
+			if now.Sub(burstStart) < p.duration {
+				p.probe(now)
+			}
+		case <-p.close:
+			return
+		}
+	}
+}
+
+// probe sends padding for one tick if the stream is application limited.
+func (p *Prober) probe(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// padding packets are sent using the header of the last media packet
+	if p.writer == nil || p.header == nil || p.target <= 0 {
+		return
+	}
+	probeRate := p.gain * float64(p.target)
+	sendRate := rate(p.totalBytes)
+	if rate(p.mediaBytes) >= float64(p.target) || sendRate >= probeRate {
+		p.credit = 0
+		return
+	}
+	p.credit += (probeRate - sendRate) / 8 * probeTick.Seconds()
+	for p.credit > 0 {
+		header := *p.header
+		header.SequenceNumber = p.seqNr
+		header.Marker = false
+		header.Padding = true
+		header.Extension = false
+		header.Extensions = nil
+		padding := make([]byte, maxPaddingSize)
+		padding[maxPaddingSize-1] = maxPaddingSize
+
+		p.seqNr++
+		size := header.MarshalSize() + len(padding)
+		p.credit -= float64(size)
+		p.onSent(now, size, true)
+		if _, err := p.writer.Write(&header, padding, interceptor.Attributes{}); err != nil {
+			return
+		}
+	}
+}
+
+func (p *Prober) Close() error {
+	select {
+	case <-p.close:
+		return nil
+	default:
+	}
+	close(p.close)
+	p.startOnce.Do(func() {
+		close(p.done)
+	})
+	<-p.done
+	return nil
+}
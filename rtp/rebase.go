@@ -0,0 +1,115 @@
+package rtp
+
+import (
+	"sync"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// rebaseHistorySize is the number of forwarded packets whose upstream
+// sequence numbers a Rebaser remembers for NACKs.
+const rebaseHistorySize = 1024
+
+// rebasedPacket maps the sequence number of a forwarded packet back to the
+// upstream stream.
+type rebasedPacket struct {
+	valid    bool
+	seqNr    uint16
+	upstream uint16
+}
+
+// Rebaser rewrites the sequence numbers and timestamps of a relayed stream
+// for one downstream receiver, so that the stream starts at zero for the
+// receiver regardless of when it joined. A relay needs one Rebaser per
+// downstream receiver and stream.
+type Rebaser struct {
+	lock sync.Mutex
+
+	init      bool
+	unwrapper unwrapper
+	firstSeq  int64
+	seqOffset uint16
+	tsOffset  uint32
+
+	packets uint32
+	octets  uint32
+
+	history [rebaseHistorySize]rebasedPacket
+}
+
+func NewRebaser() *Rebaser {
+	return &Rebaser{}
+}
+
+// RebasePacket rewrites the header of a packet forwarded to the receiver. It
+// returns false for packets sent before the first forwarded packet, which
+// have to be dropped.
+func (r *Rebaser) RebasePacket(h *rtp.Header, payloadLen int) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	seqNr := r.unwrapper.unwrap(h.SequenceNumber)
+	if !r.init {
+		r.init = true
+		r.firstSeq = seqNr
+		r.seqOffset = h.SequenceNumber
+		r.tsOffset = h.Timestamp
+	} else if seqNr < r.firstSeq {
+		return false
+	}
+	upstream := h.SequenceNumber
+	h.SequenceNumber -= r.seqOffset
+	h.Timestamp -= r.tsOffset
+	r.history[h.SequenceNumber%rebaseHistorySize] = rebasedPacket{
+		valid:    true,
+		seqNr:    h.SequenceNumber,
+		upstream: upstream,
+	}
+	r.packets++
+	r.octets += uint32(payloadLen)
+	return true
+}
+
+// RebaseSenderReport adjusts a sender report of the upstream sender to the
+// rebased stream. The RTP timestamp is shifted by the same offset as the
+// packets, so that the NTP to RTP time mapping stays valid, and the packet
+// and octet counts are replaced by the counts of the packets forwarded to
+// the receiver. It returns false if no packet was forwarded yet, in which
+// case the report has to be dropped.
+func (r *Rebaser) RebaseSenderReport(sr *rtcp.SenderReport) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.init {
+		return false
+	}
+	sr.RTPTime -= r.tsOffset
+	sr.PacketCount = r.packets
+	sr.OctetCount = r.octets
+	return true
+}
+
+// UpstreamSequenceNumber maps a rebased sequence number, e.g., from a NACK of
+// the receiver, back to the sequence number of the upstream stream. Sequence
+// numbers of recently forwarded packets are mapped as they were rebased,
+// older ones with the current offset.
+func (r *Rebaser) UpstreamSequenceNumber(seqNr uint16) uint16 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if p := r.history[seqNr%rebaseHistorySize]; p.valid && p.seqNr == seqNr {
+		return p.upstream
+	}
+	return seqNr + r.seqOffset
+}
+
+// Drop accounts for a packet which is not forwarded to the receiver, e.g.,
+// because a FrameDropper dropped its frame, so that the receiver does not see
+// a gap in the sequence numbers. Packets have to be dropped in order.
+func (r *Rebaser) Drop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.init {
+		r.seqOffset++
+	}
+}
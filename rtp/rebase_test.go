@@ -0,0 +1,41 @@
+package rtp
+
+import (
+	"testing"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+func TestRebaserDrop(t *testing.T) {
+	r := NewRebaser()
+	rebased := []uint16{}
+	for seqNr := uint16(100); seqNr < 106; seqNr++ {
+		// a relay drops the packets of a layer the receiver didn't
+		// subscribe to before rebasing
+		if seqNr%2 == 1 {
+			r.Drop()
+			continue
+		}
+		h := &rtp.Header{SequenceNumber: seqNr, Timestamp: 9000}
+		if !r.RebasePacket(h, 10) {
+			t.Fatalf("packet %v not rebased", seqNr)
+		}
+		rebased = append(rebased, h.SequenceNumber)
+	}
+	for i, seqNr := range rebased {
+		if seqNr != uint16(i) {
+			t.Fatalf("got rebased sequence numbers %v, want 0, 1, 2", rebased)
+		}
+		if upstream := r.UpstreamSequenceNumber(seqNr); upstream != 100+2*uint16(i) {
+			t.Errorf("got upstream sequence number %v for %v, want %v", upstream, seqNr, 100+2*i)
+		}
+	}
+	sr := &rtcp.SenderReport{PacketCount: 6, OctetCount: 60}
+	if !r.RebaseSenderReport(sr) {
+		t.Fatal("sender report not rebased")
+	}
+	if sr.PacketCount != 3 || sr.OctetCount != 30 {
+		t.Errorf("got %v packets and %v octets in sender report, want 3 and 30", sr.PacketCount, sr.OctetCount)
+	}
+}
@@ -0,0 +1,211 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	redMaxTimestampOffset = 1<<14 - 1
+	redMaxBlockLength     = 1<<10 - 1
+	redHistorySize        = 512
+)
+
+var errInvalidREDPacket = errors.New("invalid RED packet")
+
+type redBlock struct {
+	payloadType uint8
+	seqNr       uint16
+	timestamp   uint32
+	payload     []byte
+}
+
+type redInterceptorFactory struct {
+	payloadType      uint8
+	mediaPayloadType uint8
+	distance         int
+}
+
+func (f *redInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &redInterceptor{
+		payloadType:      f.payloadType,
+		mediaPayloadType: f.mediaPayloadType,
+		distance:         f.distance,
+	}, nil
+}
+
+// redInterceptor wraps every outgoing packet of mediaPayloadType in a RED
+// (RFC 2198) payload carrying the payloads of up to distance previous
+// packets. Packets of other payload types are written unchanged.
+type redInterceptor struct {
+	interceptor.NoOp
+	payloadType      uint8
+	mediaPayloadType uint8
+	distance         int
+}
+
+func (r *redInterceptor) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	var lock sync.Mutex
+	history := []redBlock{}
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if header.PayloadType != r.mediaPayloadType {
+			return writer.Write(header, payload, attributes)
+		}
+		lock.Lock()
+		buf := encodeRED(history, header, payload)
+
+		primary := make([]byte, len(payload))
+		copy(primary, payload)
+		history = append(history, redBlock{
+			payloadType: header.PayloadType,
+			seqNr:       header.SequenceNumber,
+			timestamp:   header.Timestamp,
+			payload:     primary,
+		})
+		if len(history) > r.distance {
+			history = history[len(history)-r.distance:]
+		}
+		lock.Unlock()
+
+		h := header.Clone()
+		h.PayloadType = r.payloadType
+		return writer.Write(&h, buf, attributes)
+	})
+}
+
+// encodeRED builds a RED payload from the primary payload and the longest run
+// of directly preceding packets in history that can be encoded as redundant
+// blocks.
+func encodeRED(history []redBlock, header *rtp.Header, payload []byte) []byte {
+	first := len(history)
+	for i := len(history) - 1; i >= 0; i-- {
+		block := history[i]
+		offset := header.Timestamp - block.timestamp
+		if block.seqNr != header.SequenceNumber-uint16(len(history)-i) ||
+			offset > redMaxTimestampOffset ||
+			len(block.payload) > redMaxBlockLength {
+			break
+		}
+		first = i
+	}
+	blocks := history[first:]
+
+	size := 1 + len(payload)
+	for _, block := range blocks {
+		size += 4 + len(block.payload)
+	}
+	buf := make([]byte, 0, size)
+	for _, block := range blocks {
+		offset := header.Timestamp - block.timestamp
+		buf = append(buf, 0x80|block.payloadType)
+		buf = append(buf, byte(offset>>6), byte(offset<<2)|byte(len(block.payload)>>8), byte(len(block.payload)))
+	}
+	buf = append(buf, header.PayloadType&0x7F)
+	for _, block := range blocks {
+		buf = append(buf, block.payload...)
+	}
+	return append(buf, payload...)
+}
+
+// REDDecoder unpacks RED (RFC 2198) packets into the primary packet and any
+// redundant packets which were not received before.
+type REDDecoder struct {
+	payloadType uint8
+
+	received [redHistorySize]uint16
+	valid    [redHistorySize]bool
+}
+
+func NewREDDecoder(payloadType uint8) *REDDecoder {
+	return &REDDecoder{
+		payloadType: payloadType,
+	}
+}
+
+func (d *REDDecoder) markReceived(seqNr uint16) bool {
+	i := seqNr % redHistorySize
+	if d.valid[i] && d.received[i] == seqNr {
+		return false
+	}
+	d.valid[i] = true
+	d.received[i] = seqNr
+	return true
+}
+
+// Decode returns the marshaled packets contained in buf in sequence number
+// order. Packets which do not use the RED payload type are returned
+// unchanged.
+func (d *REDDecoder) Decode(buf []byte) ([][]byte, error) {
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(buf); err != nil {
+		return nil, err
+	}
+	if pkt.PayloadType != d.payloadType {
+		d.markReceived(pkt.SequenceNumber)
+		return [][]byte{buf}, nil
+	}
+
+	blocks := []redBlock{}
+	payload := pkt.Payload
+	offset := 0
+	for {
+		if offset >= len(payload) {
+			return nil, errInvalidREDPacket
+		}
+		if payload[offset]&0x80 == 0 {
+			blocks = append(blocks, redBlock{
+				payloadType: payload[offset] & 0x7F,
+				seqNr:       pkt.SequenceNumber,
+				timestamp:   pkt.Timestamp,
+			})
+			offset++
+			break
+		}
+		if offset+4 > len(payload) {
+			return nil, errInvalidREDPacket
+		}
+		hdr := binary.BigEndian.Uint32(payload[offset:])
+		blocks = append(blocks, redBlock{
+			payloadType: uint8(hdr>>24) & 0x7F,
+			timestamp:   pkt.Timestamp - (hdr>>10)&redMaxTimestampOffset,
+			payload:     make([]byte, hdr&redMaxBlockLength),
+		})
+		offset += 4
+	}
+	redundant := len(blocks) - 1
+	for i := 0; i < redundant; i++ {
+		blocks[i].seqNr = pkt.SequenceNumber - uint16(redundant-i)
+		n := len(blocks[i].payload)
+		if offset+n > len(payload) {
+			return nil, errInvalidREDPacket
+		}
+		copy(blocks[i].payload, payload[offset:offset+n])
+		offset += n
+	}
+	blocks[redundant].payload = payload[offset:]
+
+	res := [][]byte{}
+	for i, block := range blocks {
+		if !d.markReceived(block.seqNr) {
+			continue
+		}
+		header := pkt.Header.Clone()
+		header.PayloadType = block.payloadType
+		header.SequenceNumber = block.seqNr
+		header.Timestamp = block.timestamp
+		if i < redundant {
+			header.Marker = false
+		}
+		out, err := (&rtp.Packet{Header: header, Payload: block.payload}).Marshal()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, out)
+	}
+	return res, nil
+}
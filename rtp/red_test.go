@@ -0,0 +1,235 @@
+package rtp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	testREDPayloadType  = 63
+	testOpusPayloadType = 111
+)
+
+// redPackets writes packets through a RED interceptor and returns the
+// marshaled packets it writes.
+func redPackets(t *testing.T, distance int, packets []*rtp.Packet) [][]byte {
+	t.Helper()
+	f := &redInterceptorFactory{
+		payloadType:      testREDPayloadType,
+		mediaPayloadType: testOpusPayloadType,
+		distance:         distance,
+	}
+	i, err := f.NewInterceptor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := [][]byte{}
+	w := i.BindLocalStream(&interceptor.StreamInfo{}, interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		buf, err := (&rtp.Packet{Header: *header, Payload: payload}).Marshal()
+		if err != nil {
+			return 0, err
+		}
+		out = append(out, buf)
+		return len(buf), nil
+	}))
+	for _, pkt := range packets {
+		if _, err := w.Write(&pkt.Header, pkt.Payload, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+func opusPacket(seqNr uint16, timestamp uint32, payload []byte) *rtp.Packet {
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    testOpusPayloadType,
+			SequenceNumber: seqNr,
+			Timestamp:      timestamp,
+			SSRC:           1,
+		},
+		Payload: payload,
+	}
+}
+
+func TestRED(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		distance int
+		packets  []*rtp.Packet
+		// lost are the indices of the packets written which are not
+		// decoded.
+		lost map[int]bool
+		// want are the sequence numbers decoded from each written packet.
+		want [][]uint16
+	}{
+		{
+			name:     "no loss",
+			distance: 2,
+			packets: []*rtp.Packet{
+				opusPacket(1, 960, []byte{1}),
+				opusPacket(2, 1920, []byte{2, 2}),
+				opusPacket(3, 2880, []byte{3, 3, 3}),
+			},
+			want: [][]uint16{{1}, {2}, {3}},
+		},
+		{
+			name:     "recovers lost packets within distance",
+			distance: 2,
+			packets: []*rtp.Packet{
+				opusPacket(1, 960, []byte{1}),
+				opusPacket(2, 1920, []byte{2, 2}),
+				opusPacket(3, 2880, []byte{3, 3, 3}),
+				opusPacket(4, 3840, []byte{4}),
+			},
+			lost: map[int]bool{1: true, 2: true},
+			want: [][]uint16{{1}, nil, nil, {2, 3, 4}},
+		},
+		{
+			name:     "loss beyond distance",
+			distance: 1,
+			packets: []*rtp.Packet{
+				opusPacket(1, 960, []byte{1}),
+				opusPacket(2, 1920, []byte{2, 2}),
+				opusPacket(3, 2880, []byte{3, 3, 3}),
+			},
+			lost: map[int]bool{0: true, 1: true},
+			want: [][]uint16{nil, nil, {2, 3}},
+		},
+		{
+			name:     "gap in sequence numbers",
+			distance: 2,
+			packets: []*rtp.Packet{
+				opusPacket(1, 960, []byte{1}),
+				opusPacket(3, 2880, []byte{3}),
+			},
+			lost: map[int]bool{0: true},
+			want: [][]uint16{nil, {3}},
+		},
+		{
+			name:     "timestamp offset too large",
+			distance: 1,
+			packets: []*rtp.Packet{
+				opusPacket(1, 0, []byte{1}),
+				opusPacket(2, redMaxTimestampOffset+1, []byte{2}),
+			},
+			lost: map[int]bool{0: true},
+			want: [][]uint16{nil, {2}},
+		},
+		{
+			name:     "sequence number wrap",
+			distance: 1,
+			packets: []*rtp.Packet{
+				opusPacket(65535, 960, []byte{1}),
+				opusPacket(0, 1920, []byte{2}),
+			},
+			lost: map[int]bool{0: true},
+			want: [][]uint16{nil, {65535, 0}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sent := redPackets(t, tc.distance, tc.packets)
+			if len(sent) != len(tc.packets) {
+				t.Fatalf("got %v packets, want %v", len(sent), len(tc.packets))
+			}
+			byseqNr := map[uint16]*rtp.Packet{}
+			for _, pkt := range tc.packets {
+				byseqNr[pkt.SequenceNumber] = pkt
+			}
+			d := NewREDDecoder(testREDPayloadType)
+			for i, buf := range sent {
+				if tc.lost[i] {
+					continue
+				}
+				decoded, err := d.Decode(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(decoded) != len(tc.want[i]) {
+					t.Fatalf("packet %v: got %v packets, want %v", i, len(decoded), len(tc.want[i]))
+				}
+				for j, out := range decoded {
+					var pkt rtp.Packet
+					if err := pkt.Unmarshal(out); err != nil {
+						t.Fatal(err)
+					}
+					want := byseqNr[tc.want[i][j]]
+					if pkt.SequenceNumber != want.SequenceNumber || pkt.Timestamp != want.Timestamp || pkt.PayloadType != testOpusPayloadType {
+						t.Errorf("packet %v: got header %v, want %v", i, pkt.Header, want.Header)
+					}
+					if !bytes.Equal(pkt.Payload, want.Payload) {
+						t.Errorf("packet %v: got payload %x, want %x", i, pkt.Payload, want.Payload)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestREDOtherPayloadTypes(t *testing.T) {
+	video := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 1, SSRC: 2},
+		Payload: []byte{1, 2, 3},
+	}
+	sent := redPackets(t, 2, []*rtp.Packet{video})
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(sent[0]); err != nil {
+		t.Fatal(err)
+	}
+	if pkt.PayloadType != 96 || !bytes.Equal(pkt.Payload, video.Payload) {
+		t.Fatalf("got %v, want %v unchanged", pkt, video)
+	}
+	decoded, err := NewREDDecoder(testREDPayloadType).Decode(sent[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || !bytes.Equal(decoded[0], sent[0]) {
+		t.Fatalf("got %x, want %x", decoded, sent[0])
+	}
+}
+
+func TestREDDecodeInvalid(t *testing.T) {
+	header := rtp.Header{Version: 2, PayloadType: testREDPayloadType, SequenceNumber: 2}
+	for _, tc := range []struct {
+		name    string
+		payload []byte
+	}{
+		{name: "empty", payload: []byte{}},
+		{name: "truncated block header", payload: []byte{0x80 | testOpusPayloadType, 0x00}},
+		{name: "missing primary header", payload: []byte{0x80 | testOpusPayloadType, 0x00, 0x04, 0x01}},
+		{name: "truncated redundant block", payload: []byte{0x80 | testOpusPayloadType, 0x00, 0x04, 0x04, testOpusPayloadType, 0x01}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := (&rtp.Packet{Header: header, Payload: tc.payload}).Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewREDDecoder(testREDPayloadType).Decode(buf); !errors.Is(err, errInvalidREDPacket) {
+				t.Fatalf("got error %v, want %v", err, errInvalidREDPacket)
+			}
+		})
+	}
+}
+
+func BenchmarkEncodeRED(b *testing.B) {
+	history := []redBlock{}
+	for i := 0; i < 2; i++ {
+		history = append(history, redBlock{
+			payloadType: testOpusPayloadType,
+			seqNr:       uint16(i),
+			timestamp:   uint32(i * 960),
+			payload:     make([]byte, 160),
+		})
+	}
+	header := &rtp.Header{PayloadType: testOpusPayloadType, SequenceNumber: 2, Timestamp: 1920}
+	payload := make([]byte, 160)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeRED(history, header, payload)
+	}
+}
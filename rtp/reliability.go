@@ -0,0 +1,106 @@
+package rtp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+var errUnknownReliabilityPolicy = errors.New("unknown reliability policy")
+
+// H.264 NAL unit types (RFC 6184)
+const (
+	naluTypeIDR   = 5
+	naluTypeSPS   = 7
+	naluTypePPS   = 8
+	naluTypeSTAPA = 24
+	naluTypeFUA   = 28
+)
+
+// ReliabilityPolicy decides whether a packet has to be sent reliably. QUIC
+// senders send packets which require reliability on a stream and all other
+// packets as datagrams.
+type ReliabilityPolicy func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) Reliability
+
+// ReliableKeyFrames requires reliability for all packets of key frames. It
+// requires the FRAME attribute set by the media source.
+func ReliableKeyFrames(_ *rtp.Header, _ []byte, attributes interceptor.Attributes) Reliability {
+	if frame, ok := attributes.Get(FRAME).(FrameInfo); ok && frame.KeyFrame {
+		return REQUIRED
+	}
+	return NOT_REQUIRED
+}
+
+// ReliableH264Headers requires reliability for H.264 parameter sets and the
+// first packet of IDR slices, all other packets are sent unreliably.
+func ReliableH264Headers(_ *rtp.Header, payload []byte, _ interceptor.Attributes) Reliability {
+	if len(payload) == 0 {
+		return NOT_REQUIRED
+	}
+	switch payload[0] & 0x1f {
+	case naluTypeSPS, naluTypePPS, naluTypeSTAPA, naluTypeIDR:
+		return REQUIRED
+	case naluTypeFUA:
+		if len(payload) > 1 && payload[1]&0x80 != 0 && payload[1]&0x1f == naluTypeIDR {
+			return REQUIRED
+		}
+	}
+	return NOT_REQUIRED
+}
+
+// ReliableScreenContent requires reliability for all packets.
+func ReliableScreenContent(_ *rtp.Header, _ []byte, _ interceptor.Attributes) Reliability {
+	return REQUIRED
+}
+
+// ReliabilityPolicyFromString returns the policy for name, nil for 'none'.
+func ReliabilityPolicyFromString(name string) (ReliabilityPolicy, error) {
+	switch name {
+	case "none":
+		return nil, nil
+	case "keyframes":
+		return ReliableKeyFrames, nil
+	case "h264-headers":
+		return ReliableH264Headers, nil
+	}
+	return nil, fmt.Errorf("%w: %v", errUnknownReliabilityPolicy, name)
+}
+
+type prioritizerFactory struct {
+	policy   ReliabilityPolicy
+	override bool
+}
+
+func (f *prioritizerFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &prioritizer{
+		policy:   f.policy,
+		override: f.override,
+	}, nil
+}
+
+// prioritizer sets the RELIABILITY attribute of each packet which does not
+// carry one already, so that the media source can override the policy for
+// individual packets. With override, the policy overrides the media source.
+type prioritizer struct {
+	interceptor.NoOp
+	policy   ReliabilityPolicy
+	override bool
+}
+
+func (p *prioritizer) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if !p.override && attributes.Get(RELIABILITY) != nil {
+			return writer.Write(header, payload, attributes)
+		}
+		// Sources may share the attributes between the packets of a frame,
+		// so the reliability of a single packet is set on a copy.
+		a := make(interceptor.Attributes, len(attributes)+1)
+		for k, v := range attributes {
+			a[k] = v
+		}
+		a.Set(RELIABILITY, p.policy(header, payload, attributes))
+		return writer.Write(header, payload, a)
+	})
+}
@@ -0,0 +1,42 @@
+package rtp
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+)
+
+// REMBReader calls a function with the bitrate of each received RTCP REMB,
+// e.g., the bitrate a relay can forward to its receivers.
+type REMBReader struct {
+	interceptor.NoOp
+	onREMB func(bitrate uint)
+}
+
+func NewREMBReader(onREMB func(bitrate uint)) *REMBReader {
+	return &REMBReader{
+		onREMB: onREMB,
+	}
+}
+
+func (r *REMBReader) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return r, nil
+}
+
+func (r *REMBReader) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		pkts, err := rtcp.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, nil
+		}
+		for _, pkt := range pkts {
+			if remb, ok := pkt.(*rtcp.ReceiverEstimatedMaximumBitrate); ok {
+				r.onREMB(uint(remb.Bitrate))
+			}
+		}
+		return n, attr, nil
+	})
+}
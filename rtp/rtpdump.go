@@ -0,0 +1,68 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const (
+	rtpdumpFileHeaderSize   = 16
+	rtpdumpPacketHeaderSize = 8
+)
+
+// rtpdumpFormatter formats packets as records of the binary rtpdump format
+// of rtptools, which rtpplay and Wireshark read. All packets are recorded as
+// sent from the fake sender address of the pcap dump.
+type rtpdumpFormatter struct {
+	start time.Time
+}
+
+// newRTPDumpFormatter writes the rtpdump file header to w. Offsets of the
+// packets are relative to the time of the header.
+func newRTPDumpFormatter(w io.Writer) (*rtpdumpFormatter, error) {
+	f := &rtpdumpFormatter{
+		start: time.Now(),
+	}
+	buf := []byte(fmt.Sprintf("#!rtpplay1.0 %v/%v\n", pcapSender.IP, pcapSender.Port))
+	header := make([]byte, rtpdumpFileHeaderSize)
+	binary.BigEndian.PutUint32(header[0:], uint32(f.start.Unix()))
+	binary.BigEndian.PutUint32(header[4:], uint32(f.start.Nanosecond()/1000))
+	copy(header[8:12], pcapSender.IP.To4())
+	binary.BigEndian.PutUint16(header[12:], pcapSender.Port)
+	if _, err := w.Write(append(buf, header...)); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// record returns a packet record. plen is the length of the packet for RTP
+// and 0 for RTCP packets.
+func (f *rtpdumpFormatter) record(pkt []byte, plen int) string {
+	buf := make([]byte, rtpdumpPacketHeaderSize, rtpdumpPacketHeaderSize+len(pkt))
+	binary.BigEndian.PutUint16(buf[0:], uint16(rtpdumpPacketHeaderSize+len(pkt)))
+	binary.BigEndian.PutUint16(buf[2:], uint16(plen))
+	binary.BigEndian.PutUint32(buf[4:], uint32(time.Since(f.start).Milliseconds()))
+	return string(append(buf, pkt...))
+}
+
+func (f *rtpdumpFormatter) rtpFormat(pkt *rtp.Packet, _ interceptor.Attributes) string {
+	buf, err := pkt.Marshal()
+	if err != nil {
+		return ""
+	}
+	return f.record(buf, len(buf))
+}
+
+func (f *rtpdumpFormatter) rtcpFormat(pkts []rtcp.Packet, _ interceptor.Attributes) string {
+	buf, err := rtcp.Marshal(pkts)
+	if err != nil {
+		return ""
+	}
+	return f.record(buf, 0)
+}
@@ -0,0 +1,50 @@
+package rtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+func TestRTPDumpFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	f, err := newRTPDumpFormatter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 7, SSRC: 1},
+		Payload: []byte{1, 2, 3},
+	}
+	buf.WriteString(f.rtpFormat(pkt, nil))
+	buf.WriteString(f.rtcpFormat([]rtcp.Packet{&rtcp.PictureLossIndication{SenderSSRC: 2, MediaSSRC: 1}}, nil))
+
+	b := buf.Bytes()
+	if !bytes.HasPrefix(b, []byte("#!rtpplay1.0 10.0.0.1/5004\n")) {
+		t.Fatalf("got header line %q", b)
+	}
+	b = b[bytes.IndexByte(b, '\n')+1+rtpdumpFileHeaderSize:]
+
+	want, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	length := binary.BigEndian.Uint16(b)
+	if plen := binary.BigEndian.Uint16(b[2:]); int(plen) != len(want) {
+		t.Errorf("got plen %v of RTP record, want %v", plen, len(want))
+	}
+	if got := b[rtpdumpPacketHeaderSize:length]; !bytes.Equal(got, want) {
+		t.Errorf("got RTP record %x, want %x", got, want)
+	}
+	b = b[length:]
+	// RTCP records have a plen of zero
+	if plen := binary.BigEndian.Uint16(b[2:]); plen != 0 {
+		t.Errorf("got plen %v of RTCP record, want 0", plen)
+	}
+	if int(binary.BigEndian.Uint16(b)) != len(b) {
+		t.Errorf("got RTCP record of %v bytes, want %v", binary.BigEndian.Uint16(b), len(b))
+	}
+}
@@ -0,0 +1,150 @@
+package rtp
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// ntpEpochOffset is the number of seconds from the NTP epoch, 1900, to the
+// Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// ntpTime returns t as 64 bit NTP timestamp.
+func ntpTime(t time.Time) uint64 {
+	nsec := uint64(t.UnixNano())
+	sec := nsec/uint64(time.Second) + ntpEpochOffset
+	frac := (nsec % uint64(time.Second)) << 32 / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+// ntpToTime returns the time of the 64 bit NTP timestamp ntp.
+func ntpToTime(ntp uint64) time.Time {
+	sec := int64(ntp>>32) - ntpEpochOffset
+	nsec := (ntp & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(sec, int64(nsec))
+}
+
+type senderReportsFactory struct {
+	interval  time.Duration
+	clockRate func(payloadType uint8) uint32
+}
+
+func (f *senderReportsFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &senderReports{
+		interval:  f.interval,
+		clockRate: f.clockRate,
+		streams:   map[uint32]*reportedStream{},
+		close:     make(chan struct{}),
+	}, nil
+}
+
+type reportedStream struct {
+	clockRate uint32
+	timestamp uint32
+	sent      time.Time
+	packets   uint32
+	octets    uint32
+}
+
+// senderReports sends an RTCP sender report for every sent stream each
+// interval. The reports map the wallclock of the sender to the RTP
+// timestamps of the streams, so that a receiver can synchronize streams with
+// different clock rates, e.g., audio and video. The RTP time of a report is
+// extrapolated from the last sent packet of the stream.
+type senderReports struct {
+	interceptor.NoOp
+
+	interval  time.Duration
+	clockRate func(payloadType uint8) uint32
+
+	lock    sync.Mutex
+	streams map[uint32]*reportedStream
+
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *senderReports) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	go s.loop(writer)
+	return writer
+}
+
+func (s *senderReports) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		n, err := writer.Write(header, payload, attributes)
+		if err != nil {
+			return n, err
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		stream, ok := s.streams[header.SSRC]
+		if !ok {
+			stream = &reportedStream{
+				clockRate: s.clockRate(header.PayloadType),
+			}
+			s.streams[header.SSRC] = stream
+		}
+		stream.timestamp = header.Timestamp
+		stream.sent = time.Now()
+		stream.packets++
+		stream.octets += uint32(len(payload))
+		return n, nil
+	})
+}
+
+func (s *senderReports) loop(writer interceptor.RTCPWriter) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.close:
+			return
+		case now := <-ticker.C:
+			pkts := s.reports(now)
+			if len(pkts) == 0 {
+				continue
+			}
+			if _, err := writer.Write(pkts, interceptor.Attributes{}); err != nil {
+				log.Printf("failed to write RTCP sender reports: %v", err)
+			}
+		}
+	}
+}
+
+// reports returns the sender reports of all streams at now.
+func (s *senderReports) reports(now time.Time) []rtcp.Packet {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ssrcs := make([]uint32, 0, len(s.streams))
+	for ssrc := range s.streams {
+		ssrcs = append(ssrcs, ssrc)
+	}
+	sort.Slice(ssrcs, func(i, j int) bool { return ssrcs[i] < ssrcs[j] })
+
+	pkts := make([]rtcp.Packet, 0, len(ssrcs))
+	for _, ssrc := range ssrcs {
+		stream := s.streams[ssrc]
+		elapsed := now.Sub(stream.sent)
+		pkts = append(pkts, &rtcp.SenderReport{
+			SSRC:        ssrc,
+			NTPTime:     ntpTime(now),
+			RTPTime:     stream.timestamp + uint32(elapsed.Seconds()*float64(stream.clockRate)),
+			PacketCount: stream.packets,
+			OctetCount:  stream.octets,
+		})
+	}
+	return pkts
+}
+
+func (s *senderReports) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.close)
+	})
+	return nil
+}
@@ -0,0 +1,111 @@
+package rtp
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v2"
+)
+
+const (
+	srtpMasterKeyLen  = 16
+	srtpMasterSaltLen = 14
+)
+
+// ParseSRTPKey parses a hex encoded SRTP master key followed by the master
+// salt as used by the AES_CM_128_HMAC_SHA1_80 protection profile.
+func ParseSRTPKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode SRTP key: %w", err)
+	}
+	if len(key) != srtpMasterKeyLen+srtpMasterSaltLen {
+		return nil, fmt.Errorf("invalid SRTP key length: got %v bytes, expected %v bytes", len(key), srtpMasterKeyLen+srtpMasterSaltLen)
+	}
+	return key, nil
+}
+
+type srtpInterceptorFactory struct {
+	key []byte
+}
+
+func (f *srtpInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	newContext := func() (*srtp.Context, error) {
+		return srtp.CreateContext(f.key[:srtpMasterKeyLen], f.key[srtpMasterKeyLen:], srtp.ProtectionProfileAes128CmHmacSha1_80)
+	}
+	local, err := newContext()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := newContext()
+	if err != nil {
+		return nil, err
+	}
+	return &srtpInterceptor{
+		local:  local,
+		remote: remote,
+	}, nil
+}
+
+// srtpInterceptor protects outgoing and unprotects incoming RTP and RTCP
+// packets. It has to be the first interceptor in the registry to make sure all
+// other interceptors see plaintext packets.
+type srtpInterceptor struct {
+	interceptor.NoOp
+	local  *srtp.Context
+	remote *srtp.Context
+}
+
+func (i *srtpInterceptor) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		headerBuf, err := header.Marshal()
+		if err != nil {
+			return 0, err
+		}
+		encrypted, err := i.local.EncryptRTP(nil, append(headerBuf, payload...), header)
+		if err != nil {
+			return 0, err
+		}
+		return writer.Write(header, encrypted[len(headerBuf):], attributes)
+	})
+}
+
+func (i *srtpInterceptor) BindRemoteStream(_ *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	// The packet is decrypted in place before it is passed on, because the
+	// base reader of the receiver is the media sink.
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		decrypted, err := i.remote.DecryptRTP(b, b, nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		return reader.Read(decrypted, a)
+	})
+}
+
+func (i *srtpInterceptor) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	return interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+		buf, err := rtcp.Marshal(pkts)
+		if err != nil {
+			return 0, err
+		}
+		encrypted, err := i.local.EncryptRTCP(nil, buf, nil)
+		if err != nil {
+			return 0, err
+		}
+		raw := rtcp.RawPacket(encrypted)
+		return writer.Write([]rtcp.Packet{&raw}, attributes)
+	})
+}
+
+func (i *srtpInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		decrypted, err := i.remote.DecryptRTCP(b, b, nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		return reader.Read(decrypted, a)
+	})
+}
@@ -0,0 +1,22 @@
+package rtp
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// TraceTransport wraps the writer of a transport. If the packet carries a
+// span, it records the time the packet spent in the interceptors since the
+// span started and the time the transport took to send it as span name.
+func TraceTransport(name string, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		span := PacketSpan(attributes)
+		if span == nil {
+			return writer.Write(header, payload, attributes)
+		}
+		span.StartAt("interceptor", span.StartTime()).End()
+		transport := span.Start(name)
+		defer transport.End()
+		return writer.Write(header, payload, attributes)
+	})
+}
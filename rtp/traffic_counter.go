@@ -0,0 +1,133 @@
+package rtp
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// Direction of a flow counted by a TrafficCounter.
+type Direction string
+
+const (
+	Sent     Direction = "sent"
+	Received Direction = "received"
+)
+
+// FlowStats are the packet and byte counts of one RTP stream. Lost is only
+// counted for received streams and derived from gaps in the sequence numbers.
+type FlowStats struct {
+	SSRC      uint32
+	Direction Direction
+	Packets   uint64
+	Bytes     uint64
+	Lost      uint64
+}
+
+type flowCounter struct {
+	FlowStats
+	unwrapper   unwrapper
+	highest     int64
+	first       int64
+	initialized bool
+}
+
+type flowKey struct {
+	ssrc      uint32
+	direction Direction
+}
+
+// TrafficCounter counts the RTP packets and bytes sent and received per
+// SSRC. The same counter is used for all interceptor chains it is
+// registered in.
+type TrafficCounter struct {
+	interceptor.NoOp
+
+	lock  sync.Mutex
+	flows map[flowKey]*flowCounter
+}
+
+func NewTrafficCounter() *TrafficCounter {
+	return &TrafficCounter{
+		flows: map[flowKey]*flowCounter{},
+	}
+}
+
+func (c *TrafficCounter) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return c, nil
+}
+
+func (c *TrafficCounter) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		n, err := writer.Write(header, payload, attributes)
+		if err == nil {
+			c.count(Sent, header, header.MarshalSize()+len(payload))
+		}
+		return n, err
+	})
+}
+
+func (c *TrafficCounter) BindRemoteStream(_ *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		var header rtp.Header
+		if _, err := header.Unmarshal(b[:n]); err == nil {
+			c.count(Received, &header, n)
+		}
+		return n, attr, nil
+	})
+}
+
+func (c *TrafficCounter) count(d Direction, header *rtp.Header, size int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := flowKey{ssrc: header.SSRC, direction: d}
+	f, ok := c.flows[key]
+	if !ok {
+		f = &flowCounter{FlowStats: FlowStats{SSRC: header.SSRC, Direction: d}}
+		c.flows[key] = f
+	}
+	f.Packets++
+	f.Bytes += uint64(size)
+	if d != Received {
+		return
+	}
+	seqNr := f.unwrapper.unwrap(header.SequenceNumber)
+	if !f.initialized {
+		f.initialized = true
+		f.first = seqNr
+		f.highest = seqNr
+	}
+	if seqNr > f.highest {
+		f.highest = seqNr
+	}
+	// duplicates and out of range sequence numbers can make the received
+	// count exceed the expected count
+	if expected := uint64(f.highest - f.first + 1); expected > f.Packets {
+		f.Lost = expected - f.Packets
+	} else {
+		f.Lost = 0
+	}
+}
+
+// Flows returns the counts of all flows ordered by direction and SSRC.
+func (c *TrafficCounter) Flows() []FlowStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	res := make([]FlowStats, 0, len(c.flows))
+	for _, f := range c.flows {
+		res = append(res, f.FlowStats)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Direction != res[j].Direction {
+			return res[i].Direction < res[j].Direction
+		}
+		return res[i].SSRC < res[j].SSRC
+	})
+	return res
+}
@@ -43,6 +43,7 @@ func NewReceiverInterceptor(opts ...ReceiverOption) (*ReceiverInterceptorFactory
 type packet struct {
 	rtp        *rtp.Packet
 	timestamp  time.Time
+	ecn        uint8
 	attributes interceptor.Attributes
 }
 
@@ -102,6 +103,14 @@ func (r *ReceiverInterceptor) BindRemoteStream(info *interceptor.StreamInfo, rea
 				timestamp = t
 			}
 		}
+		// ECN field of the IP header, reported in the feedback for
+		// transports which can read it
+		var ecn uint8
+		if e, ok := a["ecn"]; ok {
+			if v, ok := e.(uint8); ok {
+				ecn = v
+			}
+		}
 		i, attr, err := reader.Read(b, a)
 		if err != nil {
 			return 0, nil, err
@@ -116,6 +125,7 @@ func (r *ReceiverInterceptor) BindRemoteStream(info *interceptor.StreamInfo, rea
 		r.receive <- &packet{
 			rtp:       &pkt,
 			timestamp: timestamp,
+			ecn:       ecn,
 		}
 
 		return i, attr, nil
@@ -181,7 +191,7 @@ func (r *ReceiverInterceptor) loop(rtcpWriter interceptor.RTCPWriter) {
 		r.screamRxMu.Lock()
 		if rx, ok := r.screamRx[pkt.rtp.SSRC]; ok {
 			//fmt.Printf("receive pkt %v at t=%v\n", pkt.SequenceNumber, t)
-			rx.Receive(t, pkt.rtp.SSRC, pkt.rtp.MarshalSize(), pkt.rtp.SequenceNumber, 0)
+			rx.Receive(t, pkt.rtp.SSRC, pkt.rtp.MarshalSize(), pkt.rtp.SequenceNumber, pkt.ecn)
 		}
 		r.screamRxMu.Unlock()
 	}
@@ -198,7 +208,7 @@ func (r *ReceiverInterceptor) loop(rtcpWriter interceptor.RTCPWriter) {
 			r.screamRxMu.Lock()
 			if rx, ok := r.screamRx[pkt.rtp.SSRC]; ok {
 				//fmt.Printf("receive pkt %v at t=%v\n", pkt.SequenceNumber, t)
-				rx.Receive(t, pkt.rtp.SSRC, pkt.rtp.MarshalSize(), pkt.rtp.SequenceNumber, 0)
+				rx.Receive(t, pkt.rtp.SSRC, pkt.rtp.MarshalSize(), pkt.rtp.SequenceNumber, pkt.ecn)
 			}
 			r.screamRxMu.Unlock()
 
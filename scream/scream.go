@@ -1,4 +1,11 @@
-// Package scream provides interceptors to implement SCReAM congestion control via cgo
+// Package scream provides interceptors to implement SCReAM congestion control via cgo.
+// The interceptors drive the upstream SCReAM C++ reference implementation
+// (https://github.com/EricssonResearch/scream) through the scream-go bindings,
+// there is no Go port of the algorithm. The bindings construct the sender
+// with the defaults of the reference implementation and only expose the
+// priority and bitrate bounds of streams, so tuning parameters such as the
+// queue delay target, the bytes in flight headroom and the ramp up speed
+// can't be configured without extending the bindings.
 package scream
 
 import (
@@ -3,10 +3,12 @@ package scream
 import (
 	"encoding/binary"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Willi-42/rtp-over-quic/cc"
 	"github.com/mengelbart/scream-go"
 	"github.com/pion/interceptor"
 	"github.com/pion/logging"
@@ -17,6 +19,7 @@ import (
 type BandwidthEstimator interface {
 	GetTargetBitrate(ssrc uint32) (int, error)
 	GetStats() map[string]interface{}
+	Metrics() cc.Metrics
 }
 
 type NewPeerConnectionCallback func(id string, estimator BandwidthEstimator)
@@ -351,6 +354,17 @@ func (s *SenderInterceptor) GetTargetBitrate(ssrc uint32) (int, error) {
 	return int(s.tx.GetTargetBitrate(ssrc)), nil
 }
 
+// QueueDepths returns the number of packets in the RTP queue of each stream.
+func (s *SenderInterceptor) QueueDepths() map[uint32]int {
+	s.rtpStreamsMu.Lock()
+	defer s.rtpStreamsMu.Unlock()
+	res := make(map[uint32]int, len(s.rtpStreams))
+	for ssrc, stream := range s.rtpStreams {
+		res[ssrc] = stream.queue.SizeOfQueue()
+	}
+	return res
+}
+
 func (s *SenderInterceptor) GetStats() map[string]interface{} {
 	stats := s.tx.GetStatistics(s.getTimeNTP(time.Now())/65536.0, false)
 	statSlice := strings.Split(stats, ",")
@@ -387,6 +401,34 @@ func (s *SenderInterceptor) GetStats() map[string]interface{} {
 	return res
 }
 
+// Metrics returns the state of SCReAM parsed from its statistics. SCReAM
+// reports delays in seconds and rates in kbit/s, rates are reported for the
+// first stream only.
+func (s *SenderInterceptor) Metrics() cc.Metrics {
+	stats := s.GetStats()
+	value := func(key string) float64 {
+		v, ok := stats[key].(string)
+		if !ok {
+			return 0
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0
+		}
+		return f
+	}
+	m := cc.Metrics{
+		TargetBitrate: int(1000 * value("targetBitrateStream0")),
+		Cwnd:          int(value("cwnd")),
+		RTT:           time.Duration(value("sRTT") * float64(time.Second)),
+		QueueDelay:    time.Duration(value("queueDelay") * float64(time.Second)),
+	}
+	if transmitted := value("rateTransmittedStream0"); transmitted > 0 {
+		m.LossRate = value("rateLostStream0") / transmitted
+	}
+	return m
+}
+
 func (s *SenderInterceptor) isClosed() bool {
 	select {
 	case <-s.close:
@@ -0,0 +1,103 @@
+package signaling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ContentType is the media type of offers and answers.
+const ContentType = "application/sdp"
+
+// maxDescriptionSize limits the size of offers and answers.
+const maxDescriptionSize = 64 * 1024
+
+var errRejected = errors.New("offer rejected")
+
+// Handler answers offers POSTed as application/sdp using answer. Errors
+// returned by answer are sent as 406 Not Acceptable with the error as body.
+func Handler(answer func(offer *Description) (*Description, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expected POST", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxDescriptionSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		offer, err := Parse(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a, err := answer(offer)
+		if err != nil {
+			log.Printf("signaling: rejecting offer from %v: %v", r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusNotAcceptable)
+			return
+		}
+		b, err := a.Marshal()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		w.WriteHeader(http.StatusCreated)
+		if _, err := w.Write(b); err != nil {
+			log.Printf("signaling: failed to write answer: %v", err)
+		}
+	})
+}
+
+// Serve answers offers posted to '/offer' on addr until ctx is done.
+func Serve(ctx context.Context, addr string, answer func(offer *Description) (*Description, error)) error {
+	mux := http.NewServeMux()
+	mux.Handle("/offer", Handler(answer))
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Printf("signaling: listening on %v", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Offer posts offer to url and returns the answer of the receiver.
+func Offer(ctx context.Context, url string, offer *Description) (*Description, error) {
+	b, err := offer.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxDescriptionSize))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %v: %s", errRejected, res.Status, bytes.TrimSpace(body))
+	}
+	return Parse(body)
+}
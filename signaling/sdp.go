@@ -0,0 +1,290 @@
+// Package signaling exchanges SDP (RFC 8866) offers and answers describing
+// the RTP session of a sender over HTTP, so that the receiver doesn't have to
+// be started with flags matching the sender.
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+var errInvalidSDP = errors.New("invalid SDP")
+
+// Direction of the media of a description.
+const (
+	SendOnly = "sendonly"
+	RecvOnly = "recvonly"
+)
+
+// Kinds of media sections.
+const (
+	Video = "video"
+	Audio = "audio"
+)
+
+// Codec is an RTP payload format of a description.
+type Codec struct {
+	PayloadType uint8
+	// Name is the lower case encoding name, e.g., 'h264' or 'red'.
+	Name      string
+	ClockRate int
+	// Channels is the number of audio channels, e.g., 2 for Opus (RFC
+	// 7587), or 0 if not given.
+	Channels int
+}
+
+// Extension is an RTP header extension (RFC 8285).
+type Extension struct {
+	ID  int
+	URI string
+}
+
+// MediaSection is a media section ('m=' line) of a description.
+type MediaSection struct {
+	// Kind is Video or Audio.
+	Kind string
+	// Codecs are the payload formats, the media codec first.
+	Codecs []Codec
+	SSRCs  []uint32
+}
+
+// Description is the subset of an SDP offer or answer used by RoQ senders and
+// receivers: a video section and an audio section carrying Opus and RED, or
+// one of them.
+type Description struct {
+	// Transport is the transport of the sender, e.g., 'quic-dgram' or
+	// 'tcp'.
+	Transport string
+	Direction string
+	Media     []MediaSection
+	// Extensions and Feedback apply to all media sections.
+	Extensions []Extension
+	// Feedback are the RTCP feedback types, e.g., 'ack ccfb' for RFC 8888
+	// or 'transport-cc'.
+	Feedback []string
+	// FlowIDs maps SSRCs to the QUIC flow IDs they are sent with.
+	FlowIDs map[uint32]uint64
+}
+
+// protos returns the transport protocol of the media sections. pion/sdp only
+// accepts the protocols registered with IANA, which don't include QUIC, so
+// QUIC transports are only given by the roq-transport attribute.
+func protos(transport string) []string {
+	if transport == "tcp" {
+		return []string{"TCP", "RTP", "AVPF"}
+	}
+	return []string{"RTP", "AVPF"}
+}
+
+// Marshal encodes d as SDP.
+func (d *Description) Marshal() ([]byte, error) {
+	s := &sdp.SessionDescription{
+		Origin: sdp.Origin{
+			Username:       "-",
+			SessionVersion: 1,
+			NetworkType:    "IN",
+			AddressType:    "IP4",
+			UnicastAddress: "0.0.0.0",
+		},
+		SessionName:      "rtp-over-quic",
+		TimeDescriptions: []sdp.TimeDescription{{}},
+	}
+	s.WithValueAttribute("roq-transport", d.Transport)
+	for _, m := range d.Media {
+		md := &sdp.MediaDescription{
+			MediaName: sdp.MediaName{
+				Media:  m.Kind,
+				Port:   sdp.RangedPort{Value: 9},
+				Protos: protos(d.Transport),
+			},
+			ConnectionInformation: &sdp.ConnectionInformation{
+				NetworkType: "IN",
+				AddressType: "IP4",
+				Address:     &sdp.Address{Address: "0.0.0.0"},
+			},
+		}
+		if len(d.Direction) > 0 {
+			md.WithPropertyAttribute(d.Direction)
+		}
+		for _, c := range m.Codecs {
+			md.WithCodec(c.PayloadType, strings.ToUpper(c.Name), uint32(c.ClockRate), uint16(c.Channels), "")
+		}
+		for _, e := range d.Extensions {
+			md.WithValueAttribute("extmap", fmt.Sprintf("%v %v", e.ID, e.URI))
+		}
+		for _, f := range d.Feedback {
+			md.WithValueAttribute("rtcp-fb", "* "+f)
+		}
+		for _, ssrc := range m.SSRCs {
+			md.WithValueAttribute("ssrc", fmt.Sprintf("%v cname:rtp-over-quic", ssrc))
+		}
+		ssrcs := append([]uint32(nil), m.SSRCs...)
+		sort.Slice(ssrcs, func(i, j int) bool {
+			return ssrcs[i] < ssrcs[j]
+		})
+		for _, ssrc := range ssrcs {
+			if id, ok := d.FlowIDs[ssrc]; ok {
+				md.WithValueAttribute("roq-flow-id", fmt.Sprintf("%v %v", id, ssrc))
+			}
+		}
+		s.WithMedia(md)
+	}
+	return s.Marshal()
+}
+
+// Parse decodes the video and audio media sections of an SDP description.
+// Other media sections and unknown attributes are ignored.
+func Parse(b []byte) (*Description, error) {
+	var s sdp.SessionDescription
+	if err := s.Unmarshal(b); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidSDP, err)
+	}
+	d := &Description{
+		FlowIDs: map[uint32]uint64{},
+	}
+	d.Transport, _ = s.Attribute("roq-transport")
+	for i, md := range s.MediaDescriptions {
+		kind := md.MediaName.Media
+		if kind != Video && kind != Audio {
+			continue
+		}
+		m := MediaSection{Kind: kind}
+		for _, a := range md.Attributes {
+			if a.IsICECandidate() {
+				continue
+			}
+			if err := d.parseAttribute(&m, a); err != nil {
+				return nil, fmt.Errorf("%w: media section %v: %v", errInvalidSDP, i+1, err)
+			}
+		}
+		d.Media = append(d.Media, m)
+	}
+	if len(d.Media) == 0 {
+		return nil, fmt.Errorf("%w: no video or audio media section", errInvalidSDP)
+	}
+	return d, nil
+}
+
+func (d *Description) parseAttribute(m *MediaSection, a sdp.Attribute) error {
+	fields := strings.Fields(a.Value)
+	switch a.Key {
+	case SendOnly, RecvOnly:
+		d.Direction = a.Key
+	case "rtpmap":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected '<payload type> <encoding>/<clock rate>', got %q", a.Value)
+		}
+		pt, err := strconv.ParseUint(fields[0], 10, 7)
+		if err != nil {
+			return fmt.Errorf("invalid payload type %q: %v", fields[0], err)
+		}
+		encoding := strings.Split(fields[1], "/")
+		if len(encoding) < 2 {
+			return fmt.Errorf("missing clock rate in %q", fields[1])
+		}
+		rate, err := strconv.Atoi(encoding[1])
+		if err != nil {
+			return fmt.Errorf("invalid clock rate %q: %v", encoding[1], err)
+		}
+		c := Codec{
+			PayloadType: uint8(pt),
+			Name:        strings.ToLower(encoding[0]),
+			ClockRate:   rate,
+		}
+		if len(encoding) > 2 {
+			if c.Channels, err = strconv.Atoi(encoding[2]); err != nil {
+				return fmt.Errorf("invalid channels %q: %v", encoding[2], err)
+			}
+		}
+		m.Codecs = append(m.Codecs, c)
+	case "extmap":
+		if len(fields) < 2 {
+			return fmt.Errorf("expected '<id> <uri>', got %q", a.Value)
+		}
+		id, err := strconv.Atoi(strings.Split(fields[0], "/")[0])
+		if err != nil {
+			return fmt.Errorf("invalid extension ID %q: %v", fields[0], err)
+		}
+		e := Extension{ID: id, URI: fields[1]}
+		for _, x := range d.Extensions {
+			if x == e {
+				return nil
+			}
+		}
+		d.Extensions = append(d.Extensions, e)
+	case "rtcp-fb":
+		if len(fields) < 2 {
+			return fmt.Errorf("expected '<payload type> <feedback>', got %q", a.Value)
+		}
+		f := strings.Join(fields[1:], " ")
+		for _, x := range d.Feedback {
+			if x == f {
+				return nil
+			}
+		}
+		d.Feedback = append(d.Feedback, f)
+	case "ssrc":
+		ssrc, err := parseSSRC(fields)
+		if err != nil {
+			return err
+		}
+		for _, s := range m.SSRCs {
+			if s == ssrc {
+				return nil
+			}
+		}
+		m.SSRCs = append(m.SSRCs, ssrc)
+	case "roq-flow-id":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected '<flow ID> <ssrc>', got %q", a.Value)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 62)
+		if err != nil {
+			return fmt.Errorf("invalid flow ID %q: %v", fields[0], err)
+		}
+		ssrc, err := parseSSRC(fields[1:])
+		if err != nil {
+			return err
+		}
+		d.FlowIDs[ssrc] = id
+	}
+	return nil
+}
+
+func parseSSRC(fields []string) (uint32, error) {
+	if len(fields) == 0 {
+		return 0, errors.New("missing SSRC")
+	}
+	ssrc, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SSRC %q: %v", fields[0], err)
+	}
+	return uint32(ssrc), nil
+}
+
+// MediaCodec returns the first codec of the first media section which is not
+// RED, i.e., the video codec if there is a video section.
+func (d *Description) MediaCodec() (Codec, bool) {
+	for _, m := range d.Media {
+		for _, c := range m.Codecs {
+			if c.Name != "red" {
+				return c, true
+			}
+		}
+	}
+	return Codec{}, false
+}
+
+// SSRCs returns the SSRCs of all media sections.
+func (d *Description) SSRCs() []uint32 {
+	var ssrcs []uint32
+	for _, m := range d.Media {
+		ssrcs = append(ssrcs, m.SSRCs...)
+	}
+	return ssrcs
+}
@@ -0,0 +1,192 @@
+package signaling
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDescriptionMarshalParse(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		d    *Description
+	}{
+		{
+			name: "video and audio over QUIC",
+			d: &Description{
+				Transport: "quic-dgram",
+				Direction: SendOnly,
+				Media: []MediaSection{
+					{
+						Kind:   Video,
+						Codecs: []Codec{{PayloadType: 96, Name: "h264", ClockRate: 90000}},
+						SSRCs:  []uint32{1, 2},
+					},
+					{
+						Kind: Audio,
+						Codecs: []Codec{
+							{PayloadType: 111, Name: "opus", ClockRate: 48000, Channels: 2},
+							{PayloadType: 63, Name: "red", ClockRate: 48000, Channels: 2},
+						},
+						SSRCs: []uint32{3},
+					},
+				},
+				Extensions: []Extension{{ID: 1, URI: "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"}},
+				Feedback:   []string{"ack ccfb"},
+				FlowIDs:    map[uint32]uint64{1: 1, 2: 2, 3: 3},
+			},
+		},
+		{
+			name: "audio over TCP",
+			d: &Description{
+				Transport: "tcp",
+				Direction: RecvOnly,
+				Media: []MediaSection{{
+					Kind:   Audio,
+					Codecs: []Codec{{PayloadType: 111, Name: "opus", ClockRate: 48000, Channels: 2}},
+					SSRCs:  []uint32{1},
+				}},
+				Feedback: []string{"transport-cc"},
+				FlowIDs:  map[uint32]uint64{},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := tc.d.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := Parse(b)
+			if err != nil {
+				t.Fatalf("failed to parse %s: %v", b, err)
+			}
+			if !reflect.DeepEqual(d, tc.d) {
+				t.Fatalf("got %+v, want %+v", d, tc.d)
+			}
+		})
+	}
+}
+
+func TestMarshalAudioSection(t *testing.T) {
+	d := &Description{
+		Transport: "quic",
+		Media: []MediaSection{
+			{Kind: Video, Codecs: []Codec{{PayloadType: 96, Name: "vp8", ClockRate: 90000}}},
+			{Kind: Audio, Codecs: []Codec{{PayloadType: 111, Name: "opus", ClockRate: 48000, Channels: 2}}},
+		},
+	}
+	b, err := d.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"m=video 9 RTP/AVPF 96\r\n",
+		"a=rtpmap:96 VP8/90000\r\n",
+		"m=audio 9 RTP/AVPF 111\r\n",
+		"a=rtpmap:111 OPUS/48000/2\r\n",
+		"a=roq-transport:quic\r\n",
+	} {
+		if !strings.Contains(string(b), line) {
+			t.Errorf("missing %q in\n%s", line, b)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	const header = "v=0\r\no=- 0 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n"
+	for _, tc := range []struct {
+		name string
+		sdp  string
+		err  error
+		want *Description
+	}{
+		{
+			name: "skips other media",
+			sdp: header + "a=roq-transport:quic\r\n" +
+				"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+				"m=video 9 RTP/AVPF 96\r\na=rtpmap:96 H264/90000\r\na=ssrc:5 cname:x\r\na=roq-flow-id:2 5\r\n",
+			want: &Description{
+				Transport: "quic",
+				Media: []MediaSection{{
+					Kind:   Video,
+					Codecs: []Codec{{PayloadType: 96, Name: "h264", ClockRate: 90000}},
+					SSRCs:  []uint32{5},
+				}},
+				FlowIDs: map[uint32]uint64{5: 2},
+			},
+		},
+		{
+			name: "no media",
+			sdp:  header,
+			err:  errInvalidSDP,
+		},
+		{
+			name: "not SDP",
+			sdp:  "hello",
+			err:  errInvalidSDP,
+		},
+		{
+			name: "invalid payload type",
+			sdp:  header + "m=video 9 RTP/AVPF 96\r\na=rtpmap:200 H264/90000\r\n",
+			err:  errInvalidSDP,
+		},
+		{
+			name: "missing clock rate",
+			sdp:  header + "m=video 9 RTP/AVPF 96\r\na=rtpmap:96 H264\r\n",
+			err:  errInvalidSDP,
+		},
+		{
+			name: "invalid flow ID",
+			sdp:  header + "m=video 9 RTP/AVPF 96\r\na=roq-flow-id:x 1\r\n",
+			err:  errInvalidSDP,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := Parse([]byte(tc.sdp))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if !reflect.DeepEqual(d, tc.want) {
+				t.Fatalf("got %+v, want %+v", d, tc.want)
+			}
+		})
+	}
+}
+
+func TestDescriptionMediaCodec(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		media []MediaSection
+		want  Codec
+		ok    bool
+	}{
+		{name: "no media"},
+		{
+			name: "skips RED",
+			media: []MediaSection{{Kind: Audio, Codecs: []Codec{
+				{PayloadType: 63, Name: "red"},
+				{PayloadType: 111, Name: "opus"},
+			}}},
+			want: Codec{PayloadType: 111, Name: "opus"},
+			ok:   true,
+		},
+		{
+			name: "video first",
+			media: []MediaSection{
+				{Kind: Video, Codecs: []Codec{{PayloadType: 96, Name: "h264"}}},
+				{Kind: Audio, Codecs: []Codec{{PayloadType: 111, Name: "opus"}}},
+			},
+			want: Codec{PayloadType: 96, Name: "h264"},
+			ok:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &Description{Media: tc.media}
+			c, ok := d.MediaCodec()
+			if c != tc.want || ok != tc.ok {
+				t.Fatalf("got %v, %v, want %v, %v", c, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
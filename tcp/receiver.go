@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"sync"
 
+	"github.com/Willi-42/rtp-over-quic/events"
+	"github.com/Willi-42/rtp-over-quic/logging"
 	"github.com/pion/interceptor"
 	"github.com/pion/rtcp"
 )
@@ -22,13 +25,23 @@ func LocalAddress(addr string) ServerOption {
 	}
 }
 
+// SetServerHooks sets the hooks called when connections are accepted and
+// closed.
+func SetServerHooks(h *events.Hooks) ServerOption {
+	return func(sc *ServerConfig) error {
+		sc.hooks = h
+		return nil
+	}
+}
+
 type ServerConfig struct {
 	localAddr string
+	hooks     *events.Hooks
 }
 
 type Server struct {
 	*ServerConfig
-	onNewHandler func(*Handler)
+	onNewHandler func(*Handler) error
 }
 
 func NewServer(opts ...ServerOption) (*Server, error) {
@@ -46,7 +59,10 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 	return s, nil
 }
 
-func (s *Server) OnNewHandler(f func(*Handler)) {
+// OnNewHandler sets the function called for the handler of each new
+// connection. If it returns an error, the connection is closed and the
+// server keeps accepting other connections.
+func (s *Server) OnNewHandler(f func(*Handler) error) {
 	s.onNewHandler = f
 }
 
@@ -56,6 +72,12 @@ func (s *Server) Start(ctx context.Context) error {
 		return err
 	}
 	log.Printf("listening on %v...", listener.Addr())
+	go func() {
+		<-ctx.Done()
+		if err := listener.Close(); err != nil {
+			log.Printf("failed to close TCP listener: %v", err)
+		}
+	}()
 
 	var wg sync.WaitGroup
 	defer wg.Wait()
@@ -63,17 +85,41 @@ func (s *Server) Start(ctx context.Context) error {
 	for {
 		conn, err := listener.AcceptTCP()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			return err
 		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			defer func() {
+				if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+					log.Printf("failed to close TCP conn: %v", err)
+				}
+			}()
+			info := connectionInfo(conn)
+			if err := s.hooks.Connect(info); err != nil {
+				log.Printf("rejecting connection from %v: %v", conn.RemoteAddr(), err)
+				return
+			}
 			h := Handler{
 				reader: nil,
 				conn:   conn,
 			}
-			s.onNewHandler(&h)
-			h.handle(ctx)
+			if err := s.onNewHandler(&h); err != nil {
+				log.Printf("failed to set up handler for connection from %v, closing it: %v", conn.RemoteAddr(), err)
+				s.hooks.Disconnect(info, nil)
+				return
+			}
+			err := h.handle(ctx)
+			if err != nil {
+				log.Printf("error on handling connection: %v", err)
+			}
+			if h.onClose != nil {
+				h.onClose()
+			}
+			s.hooks.Disconnect(info, err)
 		}()
 	}
 }
@@ -83,57 +129,80 @@ type pkt struct {
 }
 
 type Handler struct {
-	reader interceptor.RTPReader
-	conn   *net.TCPConn
+	reader  interceptor.RTPReader
+	conn    *net.TCPConn
+	onClose func()
 }
 
 func (h *Handler) SetRTPReader(r interceptor.RTPReader) {
 	h.reader = r
 }
 
-func (h *Handler) handle(ctx context.Context) {
-	pktChan := make(chan pkt)
+// Close closes the connection of the handler, e.g., after the sender said
+// goodbye.
+func (h *Handler) Close(reason string) error {
+	return h.conn.Close()
+}
 
-	var wg sync.WaitGroup
-	defer wg.Wait()
+// OnClose sets a function called after the connection of the handler closed.
+func (h *Handler) OnClose(f func()) {
+	h.onClose = f
+}
+
+// handle passes the received packets to the reader until the connection is
+// closed or ctx is done. It returns the error which ended the connection, nil
+// if the sender closed it.
+func (h *Handler) handle(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pktChan := make(chan pkt)
+	errChan := make(chan error, 1)
 
-	go h.receive(pktChan)
+	go func() {
+		errChan <- h.receive(ctx, pktChan)
+	}()
 
 	for {
 		select {
 		case p := <-pktChan:
-			if h.reader != nil {
-				if _, _, err := h.reader.Read(p.buffer, interceptor.Attributes{}); err != nil {
-					log.Printf("failed to process incoming packet: %v", err)
-				}
+			if h.reader == nil {
+				logging.Drop(logging.DropUnknownFlow, "no reader for connection from %v", h.conn.RemoteAddr())
+				continue
+			}
+			if _, _, err := h.reader.Read(p.buffer, interceptor.Attributes{}); err != nil {
+				logging.Drop(logging.DropParseError, "failed to process incoming packet: %v", err)
 			}
+		case err := <-errChan:
+			return err
 		case <-ctx.Done():
-			return
+			return nil
 		}
 	}
 }
 
-func (h *Handler) receive(pktChan chan<- pkt) {
+// receive reads packets from the connection until it is closed or ctx is
+// done.
+func (h *Handler) receive(ctx context.Context, pktChan chan<- pkt) error {
 	prefix := make([]byte, 2)
 	for {
 		if _, err := io.ReadFull(h.conn, prefix); err != nil {
-			if errors.Is(err, io.EOF) {
-				return
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
 			}
-			log.Printf("failed to read length from TCP conn: %v, exiting", err)
-			continue
+			return fmt.Errorf("failed to read length from TCP conn: %w", err)
 		}
 		length := binary.BigEndian.Uint16(prefix)
 		buf := make([]byte, length)
 		if _, err := io.ReadFull(h.conn, buf); err != nil {
-			if errors.Is(err, io.EOF) {
-				return
+			if errors.Is(err, net.ErrClosed) {
+				return nil
 			}
-			log.Printf("failed to read complete frame from TCP conn: %v, exiting", err)
-			continue
+			return fmt.Errorf("failed to read complete frame from TCP conn: %w", err)
 		}
-		pktChan <- pkt{
-			buffer: buf,
+		select {
+		case pktChan <- pkt{buffer: buf}:
+		case <-ctx.Done():
+			return nil
 		}
 	}
 }
@@ -4,16 +4,24 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 
 	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/events"
 	"github.com/Willi-42/rtp-over-quic/rtp"
 	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
 	pionrtp "github.com/pion/rtp"
 )
 
+var (
+	errUnsupportedCC = errors.New("unsupported TCP congestion control algorithm")
+	errRejected      = errors.New("connection rejected")
+)
+
 type SenderOption func(*SenderConfig) error
 
 func RemoteAddress(addr string) SenderOption {
@@ -30,9 +38,19 @@ func SetTCPCongestionControlAlgorithm(algorithm cc.Algorithm) SenderOption {
 	}
 }
 
+// SetSenderHooks sets the hooks called when the connection is established
+// and closed.
+func SetSenderHooks(h *events.Hooks) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.hooks = h
+		return nil
+	}
+}
+
 type SenderConfig struct {
 	cc         cc.Algorithm
 	remoteAddr string
+	hooks      *events.Hooks
 }
 
 type Sender struct {
@@ -41,6 +59,7 @@ type Sender struct {
 	conn                *net.TCPConn
 	interceptorRegistry *interceptor.Registry
 	interceptor         interceptor.Interceptor
+	sources             *rtp.Sources
 }
 
 func NewSender(r *interceptor.Registry, opts ...SenderOption) (*Sender, error) {
@@ -48,12 +67,18 @@ func NewSender(r *interceptor.Registry, opts ...SenderOption) (*Sender, error) {
 		SenderConfig:        &SenderConfig{},
 		conn:                nil,
 		interceptorRegistry: r,
+		sources:             rtp.NewSources(),
 	}
 	for _, opt := range opts {
 		if err := opt(s.SenderConfig); err != nil {
 			return nil, err
 		}
 	}
+	switch s.cc {
+	case cc.Reno, cc.Cubic, cc.BBR:
+	default:
+		return nil, fmt.Errorf("%w: %v", errUnsupportedCC, s.cc)
+	}
 	return s, nil
 }
 
@@ -62,6 +87,12 @@ func (s *Sender) Connect(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if err := s.hooks.Connect(connectionInfo(conn)); err != nil {
+		if err := conn.Close(); err != nil {
+			log.Printf("failed to close TCP conn: %v", err)
+		}
+		return fmt.Errorf("%w: %v", errRejected, err)
+	}
 	s.conn = conn
 
 	i, err := s.interceptorRegistry.Build("")
@@ -75,6 +106,7 @@ func (s *Sender) Connect(ctx context.Context) error {
 			return len(b), a, nil
 		}),
 	)
+	s.interceptor.BindRTCPWriter(interceptor.RTCPWriterFunc(s.writeRTCP))
 	rtcpChan := make(chan rtp.RTCPFeedback)
 	go rtp.ReadRTCP(ctx, rtcpReader, rtcpChan)
 	go s.readFromNetwork(ctx, rtcpChan)
@@ -82,12 +114,51 @@ func (s *Sender) Connect(ctx context.Context) error {
 	return nil
 }
 
+func (s *Sender) writeRTCP(pkts []rtcp.Packet, _ interceptor.Attributes) (int, error) {
+	buf, err := rtcp.Marshal(pkts)
+	if err != nil {
+		return 0, err
+	}
+	prefix := make([]byte, 2)
+	binary.BigEndian.PutUint16(prefix, uint16(len(buf)))
+	return s.conn.Write(append(prefix, buf...))
+}
+
+// Close sends an RTCP BYE for all sent SSRCs, closes the interceptors and
+// then the connection. The media has to be stopped before.
+func (s *Sender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	if bye := s.sources.Goodbye("sender shutdown"); bye != nil {
+		if _, err := s.writeRTCP(bye, nil); err != nil {
+			log.Printf("failed to send RTCP BYE: %v", err)
+		}
+	}
+	var err error
+	if s.interceptor != nil {
+		if err = s.interceptor.Close(); err != nil {
+			err = fmt.Errorf("failed to close interceptors: %w", err)
+		}
+	}
+	if cerr := s.conn.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
 func (s *Sender) readFromNetwork(ctx context.Context, rtcpChan chan rtp.RTCPFeedback) {
 	buf := make([]byte, 1500) // TODO: Better MTU size?
 	for {
 		prefix := make([]byte, 2)
 		if _, err := io.ReadFull(s.conn, prefix); err != nil {
 			if errors.Is(err, net.ErrClosed) {
+				s.hooks.Disconnect(connectionInfo(s.conn), nil)
+				return
+			}
+			if errors.Is(err, io.EOF) {
+				log.Printf("TCP connection closed by receiver")
+				s.hooks.Disconnect(connectionInfo(s.conn), err)
 				return
 			}
 			log.Printf("failed to read length from TCP conn: %v, exiting", err)
@@ -97,6 +168,7 @@ func (s *Sender) readFromNetwork(ctx context.Context, rtcpChan chan rtp.RTCPFeed
 		tmp := make([]byte, length)
 		if _, err := io.ReadFull(s.conn, tmp); err != nil {
 			if errors.Is(err, net.ErrClosed) {
+				s.hooks.Disconnect(connectionInfo(s.conn), nil)
 				return
 			}
 			log.Printf("failed to read complete frame from TCP conn: %v, exiting", err)
@@ -114,8 +186,9 @@ func (s *Sender) readFromNetwork(ctx context.Context, rtcpChan chan rtp.RTCPFeed
 }
 
 func (s *Sender) NewMediaStream() interceptor.RTPWriter {
-	return s.interceptor.BindLocalStream(&interceptor.StreamInfo{}, interceptor.RTPWriterFunc(
+	return s.interceptor.BindLocalStream(rtp.NewLocalStreamInfo(), rtp.TraceTransport("tcp", interceptor.RTPWriterFunc(
 		func(header *pionrtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+			s.sources.Add(header.SSRC)
 			headerBuf, err := header.Marshal()
 			if err != nil {
 				return 0, err
@@ -125,5 +198,5 @@ func (s *Sender) NewMediaStream() interceptor.RTPWriter {
 			binary.BigEndian.PutUint16(buf[0:2], uint16(len(msg)))
 			return s.conn.Write(append(buf, msg...))
 		},
-	))
+	)))
 }
@@ -6,6 +6,7 @@ import (
 	"syscall"
 
 	"github.com/Willi-42/rtp-over-quic/cc"
+	"github.com/Willi-42/rtp-over-quic/events"
 )
 
 func connectTCP(addr string, cc cc.Algorithm) (*net.TCPConn, error) {
@@ -61,3 +62,12 @@ func listenTCP(addr string) (*net.TCPListener, error) {
 	}
 	return net.ListenTCP("tcp", tcpAddr)
 }
+
+// connectionInfo describes conn for the lifecycle hooks.
+func connectionInfo(conn *net.TCPConn) events.ConnectionInfo {
+	return events.ConnectionInfo{
+		Transport:  "tcp",
+		LocalAddr:  conn.LocalAddr().String(),
+		RemoteAddr: conn.RemoteAddr().String(),
+	}
+}
@@ -0,0 +1,292 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	exportInterval = 5 * time.Second
+	exportTimeout  = 10 * time.Second
+	// maxBatch is the number of queued spans which triggers an export
+	// before the next interval.
+	maxBatch = 2048
+	// maxQueue bounds the spans kept while the collector is slow or
+	// unreachable, further spans are dropped.
+	maxQueue = 65536
+
+	tracesPath = "/v1/traces"
+	scopeName  = "github.com/Willi-42/rtp-over-quic"
+)
+
+var errExportFailed = errors.New("OTLP export failed")
+
+// Tracer batches ended spans and exports them periodically to an OTLP/HTTP
+// endpoint.
+type Tracer struct {
+	url     string
+	service string
+	client  *http.Client
+
+	lock    sync.Mutex
+	rand    *rand.Rand
+	spans   []*Span
+	dropped uint64
+
+	flush chan struct{}
+	close chan struct{}
+	done  chan struct{}
+}
+
+// NewTracer creates a tracer which exports the spans of service to
+// endpoint, e.g., 'http://localhost:4318'. The path '/v1/traces' is appended
+// if endpoint does not end with it.
+func NewTracer(endpoint, service string) *Tracer {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, tracesPath) {
+		url += tracesPath
+	}
+	t := &Tracer{
+		url:     url,
+		service: service,
+		client:  &http.Client{Timeout: exportTimeout},
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		spans:   []*Span{},
+		flush:   make(chan struct{}, 1),
+		close:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Close exports the remaining spans and stops the tracer.
+func (t *Tracer) Close() error {
+	close(t.close)
+	<-t.done
+	t.lock.Lock()
+	dropped := t.dropped
+	t.lock.Unlock()
+	if dropped > 0 {
+		log.Printf("dropped %v spans because the OTLP endpoint was too slow", dropped)
+	}
+	return t.export()
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		case <-t.close:
+			return
+		}
+		if err := t.export(); err != nil {
+			log.Printf("failed to export spans: %v", err)
+		}
+	}
+}
+
+func (t *Tracer) newTraceID() [16]byte {
+	var id [16]byte
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.rand.Read(id[:])
+	return id
+}
+
+func (t *Tracer) newSpan(name string, traceID [16]byte, parent [8]byte, start time.Time) *Span {
+	s := &Span{
+		tracer:     t,
+		name:       name,
+		traceID:    traceID,
+		parent:     parent,
+		start:      start,
+		attributes: map[string]interface{}{},
+	}
+	t.lock.Lock()
+	t.rand.Read(s.spanID[:])
+	t.lock.Unlock()
+	return s
+}
+
+func (t *Tracer) queue(s *Span) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.spans) >= maxQueue {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, s)
+	if len(t.spans) == maxBatch {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) export() error {
+	t.lock.Lock()
+	spans := t.spans
+	t.spans = []*Span{}
+	t.lock.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%w: %v: %v", errExportFailed, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// OTLP JSON encoding of ExportTraceServiceRequest. Trace and span IDs are hex
+// encoded, 64 bit integers are encoded as strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// spanKindInternal is the OTLP span kind of all spans.
+const spanKindInternal = 1
+
+func (t *Tracer) encode(spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		s.lock.Lock()
+		for k, v := range s.attributes {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: k, Value: encodeValue(v)})
+		}
+		s.lock.Unlock()
+		encoded = append(encoded, span)
+	}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{{Key: "service.name", Value: encodeValue(t.service)}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: encoded,
+			}},
+		}},
+	}
+}
+
+func encodeValue(v interface{}) otlpValue {
+	var i int64
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	case float32:
+		f := float64(v)
+		return otlpValue{DoubleValue: &f}
+	case int:
+		i = int64(v)
+	case int64:
+		i = v
+	case uint8:
+		i = int64(v)
+	case uint16:
+		i = int64(v)
+	case uint32:
+		i = int64(v)
+	case uint64:
+		i = int64(v)
+	case time.Duration:
+		i = v.Microseconds()
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+	s := strconv.FormatInt(i, 10)
+	return otlpValue{IntValue: &s}
+}
@@ -0,0 +1,117 @@
+// Package tracing records spans of the stages frames and packets pass on the
+// send and receive path and exports them to an OpenTelemetry collector using
+// OTLP over HTTP with JSON encoding.
+package tracing
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	tracerLock sync.RWMutex
+	tracer     *Tracer
+)
+
+// SetTracer sets the tracer used by Start and StartAt, nil disables tracing.
+func SetTracer(t *Tracer) {
+	tracerLock.Lock()
+	defer tracerLock.Unlock()
+	tracer = t
+}
+
+// GetTracer returns the tracer set by SetTracer or nil.
+func GetTracer() *Tracer {
+	tracerLock.RLock()
+	defer tracerLock.RUnlock()
+	return tracer
+}
+
+// Enabled returns whether a tracer is set.
+func Enabled() bool {
+	return GetTracer() != nil
+}
+
+// Start starts a new trace with a root span name. It returns nil if tracing
+// is disabled, all methods of Span can be called on nil.
+func Start(name string) *Span {
+	return StartAt(name, time.Now())
+}
+
+// StartAt starts a new trace with a root span name which started at t.
+func StartAt(name string, t time.Time) *Span {
+	tr := GetTracer()
+	if tr == nil {
+		return nil
+	}
+	return tr.newSpan(name, tr.newTraceID(), [8]byte{}, t)
+}
+
+// Span is a stage of a frame or packet.
+type Span struct {
+	tracer  *Tracer
+	name    string
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	start   time.Time
+	end     time.Time
+
+	lock       sync.Mutex
+	attributes map[string]interface{}
+	ended      bool
+}
+
+// Start starts a child span name.
+func (s *Span) Start(name string) *Span {
+	return s.StartAt(name, time.Now())
+}
+
+// StartAt starts a child span name which started at t.
+func (s *Span) StartAt(name string, t time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.newSpan(name, s.traceID, s.spanID, t)
+}
+
+// StartTime returns the time the span started.
+func (s *Span) StartTime() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return s.start
+}
+
+// SetAttribute sets the attribute key to v, which should be a string, bool,
+// integer or float.
+func (s *Span) SetAttribute(key string, v interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attributes[key] = v
+}
+
+// End ends the span and queues it for export.
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt ends the span at t and queues it for export. Spans are only exported
+// once.
+func (s *Span) EndAt(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = t
+	s.lock.Unlock()
+	s.tracer.queue(s)
+}
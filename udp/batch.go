@@ -0,0 +1,152 @@
+package udp
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Willi-42/rtp-over-quic/buffers"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// batchDelay is the maximum time a packet waits for the batch to fill
+	// up if the frame it belongs to is not complete.
+	batchDelay = time.Millisecond
+	// maxGSOSegments and maxGSOSize are the limits of the kernel for the
+	// segments sent at once with GSO.
+	maxGSOSegments = 64
+	maxGSOSize     = 65507
+)
+
+var errGSOUnsupported = errors.New("UDP GSO not supported")
+
+// batchConn reads and writes multiple packets per system call, using
+// recvmmsg and sendmmsg on Linux. ipv4.Message and ipv6.Message are the same
+// type.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+func newBatchConn(conn *net.UDPConn) batchConn {
+	if a, ok := conn.LocalAddr().(*net.UDPAddr); ok && a.IP.To4() != nil {
+		return ipv4.NewPacketConn(conn)
+	}
+	return ipv6.NewPacketConn(conn)
+}
+
+// batcher collects the packets of the sender and writes up to size of them
+// with one system call at the end of each frame, when the batch is full or
+// after batchDelay. With GSO, runs of packets of the same size are passed to
+// the kernel as one message, which segments them.
+type batcher struct {
+	conn batchConn
+	size int
+	gso  bool
+
+	lock    sync.Mutex
+	pending []*buffers.Buffer
+	timer   *time.Timer
+	msgs    []ipv4.Message
+}
+
+func newBatcher(conn *net.UDPConn, size int, gso bool) *batcher {
+	return &batcher{
+		conn: newBatchConn(conn),
+		size: size,
+		gso:  gso,
+	}
+}
+
+// write queues pkt, which is released once it is sent, and sends the batch
+// if endOfFrame is set or the batch is full.
+func (b *batcher) write(pkt *buffers.Buffer, endOfFrame bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.pending = append(b.pending, pkt)
+	if endOfFrame || len(b.pending) >= b.size {
+		return b.flushLocked()
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(batchDelay, func() {
+			if err := b.flush(); err != nil {
+				log.Printf("failed to send UDP batch: %v", err)
+			}
+		})
+	}
+	return nil
+}
+
+func (b *batcher) flush() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.flushLocked()
+}
+
+// flushLocked sends the pending packets. Must be called with the lock held.
+func (b *batcher) flushLocked() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return nil
+	}
+	defer func() {
+		for i, p := range b.pending {
+			p.Release()
+			b.pending[i] = nil
+		}
+		b.pending = b.pending[:0]
+	}()
+
+	msgs := b.msgs[:0]
+	for i := 0; i < len(b.pending); {
+		n := 1
+		if b.gso {
+			n = gsoRun(b.pending[i:])
+		}
+		m := ipv4.Message{Buffers: make([][]byte, n)}
+		for j := range m.Buffers {
+			m.Buffers[j] = b.pending[i+j].B
+		}
+		if n > 1 {
+			m.OOB = gsoControlMessage(len(m.Buffers[0]))
+		}
+		msgs = append(msgs, m)
+		i += n
+	}
+	b.msgs = msgs
+	for len(msgs) > 0 {
+		n, err := b.conn.WriteBatch(msgs, 0)
+		if err != nil {
+			return err
+		}
+		msgs = msgs[n:]
+	}
+	return nil
+}
+
+// gsoRun returns the number of packets at the start of pkts which can be
+// sent as one GSO message: packets of the same size followed by at most one
+// smaller packet.
+func gsoRun(pkts []*buffers.Buffer) int {
+	segment := len(pkts[0].B)
+	total := segment
+	n := 1
+	for ; n < len(pkts) && n < maxGSOSegments; n++ {
+		size := len(pkts[n].B)
+		if size > segment || total+size > maxGSOSize {
+			break
+		}
+		total += size
+		if size < segment {
+			return n + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package udp
+
+import (
+	"fmt"
+	"net"
+)
+
+func enableECN(_ *net.UDPConn) error {
+	return fmt.Errorf("%w: ECN is only supported on Linux", errECNUnsupported)
+}
+
+func enableECNReport(_ *net.UDPConn) error {
+	return fmt.Errorf("%w: ECN is only supported on Linux", errECNUnsupported)
+}
+
+func parseECN(_ []byte) uint8 {
+	return 0
+}
@@ -0,0 +1,71 @@
+//go:build linux
+// +build linux
+
+package udp
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// enableECN marks all packets sent on conn as ECN capable.
+func enableECN(conn *net.UDPConn) error {
+	return setsockopt(conn, func(fd int) error {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, ecnECT0); err != nil {
+			return err
+		}
+		// fails on IPv4 only sockets
+		_ = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, ecnECT0)
+		return nil
+	})
+}
+
+// enableECNReport lets the kernel report the ECN field of received packets
+// in the control messages.
+func enableECNReport(conn *net.UDPConn) error {
+	return setsockopt(conn, func(fd int) error {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_RECVTOS, 1); err != nil {
+			return err
+		}
+		// fails on IPv4 only sockets
+		_ = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_RECVTCLASS, 1)
+		return nil
+	})
+}
+
+// parseECN returns the ECN field from the control messages of a received
+// packet.
+func parseECN(oob []byte) uint8 {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, msg := range msgs {
+		if len(msg.Data) == 0 {
+			continue
+		}
+		switch {
+		case msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_TOS,
+			msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_TCLASS:
+			// IP_TOS is a single byte, IPV6_TCLASS an int whose low
+			// byte comes first on little endian machines
+			return msg.Data[0] & ecnMask
+		}
+	}
+	return 0
+}
+
+func setsockopt(conn *net.UDPConn, f func(fd int) error) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rawConn.Control(func(fd uintptr) {
+		serr = f(int(fd))
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package udp
+
+import (
+	"fmt"
+	"net"
+)
+
+func checkGSO(_ *net.UDPConn) error {
+	return fmt.Errorf("%w: GSO is only supported on Linux", errGSOUnsupported)
+}
+
+func gsoControlMessage(_ int) []byte {
+	return nil
+}
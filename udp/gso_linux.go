@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+package udp
+
+import (
+	"fmt"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// udpSegment is UDP_SEGMENT of linux/udp.h, which golang.org/x/sys/unix
+// lacks.
+const udpSegment = 103
+
+// checkGSO returns an error if the kernel can't segment packets sent on
+// conn.
+func checkGSO(conn *net.UDPConn) error {
+	err := setsockopt(conn, func(fd int) error {
+		_, err := unix.GetsockoptInt(fd, unix.IPPROTO_UDP, udpSegment)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errGSOUnsupported, err)
+	}
+	return nil
+}
+
+// gsoControlMessage returns the control message letting the kernel split a
+// message into segments of size bytes.
+func gsoControlMessage(size int) []byte {
+	b := make([]byte, unix.CmsgSpace(2))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = udpSegment
+	h.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&b[unix.CmsgLen(0)])) = uint16(size)
+	return b
+}
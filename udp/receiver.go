@@ -3,29 +3,85 @@ package udp
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/netip"
 
+	"github.com/Willi-42/rtp-over-quic/buffers"
+	"github.com/Willi-42/rtp-over-quic/logging"
 	"github.com/pion/interceptor"
 	"github.com/pion/rtcp"
+	"golang.org/x/net/ipv4"
 )
 
 type ServerOption func(*ServerConfig) error
 
+func LocalAddress(addr string) ServerOption {
+	return func(sc *ServerConfig) error {
+		sc.localAddr = addr
+		return nil
+	}
+}
+
+// SetServerECN passes the ECN field of received packets to the interceptors
+// as 'ecn' attribute.
+func SetServerECN(enabled bool) ServerOption {
+	return func(sc *ServerConfig) error {
+		sc.ecn = enabled
+		return nil
+	}
+}
+
+// SetBatchRead reads up to size packets with one system call (recvmmsg on
+// Linux). 0 and 1 read each packet on its own.
+func SetBatchRead(size int) ServerOption {
+	return func(sc *ServerConfig) error {
+		if size < 0 {
+			return fmt.Errorf("%w: negative batch size %v", errInvalidConfig, size)
+		}
+		sc.batchSize = size
+		return nil
+	}
+}
+
+// SetServerMTU sets the size of the receive buffers, larger packets are
+// truncated. 0 uses defaultMTU.
+func SetServerMTU(mtu int) ServerOption {
+	return func(sc *ServerConfig) error {
+		if mtu < 0 {
+			return fmt.Errorf("%w: negative MTU %v", errInvalidConfig, mtu)
+		}
+		if mtu == 0 {
+			mtu = defaultMTU
+		}
+		sc.mtu = mtu
+		return nil
+	}
+}
+
+// defaultMTU is the size of the receive buffers if not set by SetServerMTU.
+const defaultMTU = 1500
+
 type ServerConfig struct {
 	localAddr string
+	ecn       bool
+	batchSize int
+	mtu       int
 }
 
 type Server struct {
 	*ServerConfig
-	onNewHandler func(*Handler)
+	onNewHandler func(*Handler) error
 }
 
 func NewServer(opts ...ServerOption) (*Server, error) {
 	s := &Server{
 		ServerConfig: &ServerConfig{
 			localAddr: ":4242",
+			ecn:       false,
+			batchSize: 0,
+			mtu:       defaultMTU,
 		},
 		onNewHandler: nil,
 	}
@@ -37,7 +93,10 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 	return s, nil
 }
 
-func (s *Server) OnNewHandler(f func(*Handler)) {
+// OnNewHandler sets the function called for the handler of each new
+// remote address. If it returns an error, the packets of the address are
+// dropped and the server keeps receiving from other addresses.
+func (s *Server) OnNewHandler(f func(*Handler) error) {
 	s.onNewHandler = f
 }
 
@@ -46,6 +105,11 @@ func (s *Server) Start(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if s.ecn {
+		if err := enableECNReport(conn); err != nil {
+			return err
+		}
+	}
 	go func() {
 		<-ctx.Done()
 		if err := conn.Close(); err != nil {
@@ -54,34 +118,96 @@ func (s *Server) Start(ctx context.Context) error {
 	}()
 
 	handlers := make(map[netip.AddrPort]*Handler)
+	if s.batchSize > 1 {
+		return s.readBatches(conn, handlers)
+	}
+	oob := make([]byte, 64)
 	for {
-		buf := make([]byte, 1500) // TODO: Better/dynamic MTU?
-		n, addr, err := conn.ReadFromUDP(buf)
+		buf := buffers.Get(s.mtu)
+		n, oobn, _, addr, err := conn.ReadMsgUDP(buf.B, oob)
 		if err != nil {
+			buf.Release()
 			if errors.Is(err, net.ErrClosed) {
 				return nil
 			}
 			log.Printf("ReadFromUDP error, exiting: %v", err)
 			return err
 		}
-		handler, ok := handlers[addr.AddrPort()]
-		if !ok {
-			handler = &Handler{
-				reader: nil,
-				addr:   addr,
-				conn:   conn,
+		s.dispatch(conn, handlers, addr, buf.B[:n], oob[:oobn])
+		buf.Release()
+	}
+}
+
+// readBatches reads up to batchSize packets per system call until conn is
+// closed.
+func (s *Server) readBatches(conn *net.UDPConn, handlers map[netip.AddrPort]*Handler) error {
+	bc := newBatchConn(conn)
+	msgs := make([]ipv4.Message, s.batchSize)
+	bufs := make([]*buffers.Buffer, s.batchSize)
+	for i := range msgs {
+		bufs[i] = buffers.Get(s.mtu)
+		msgs[i].Buffers = [][]byte{bufs[i].B}
+		msgs[i].OOB = make([]byte, 64)
+	}
+	defer func() {
+		for _, b := range bufs {
+			b.Release()
+		}
+	}()
+	for {
+		n, err := bc.ReadBatch(msgs, 0)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
 			}
-			handlers[addr.AddrPort()] = handler
-			s.onNewHandler(handler)
+			log.Printf("ReadBatch error, exiting: %v", err)
+			return err
+		}
+		for i := range msgs[:n] {
+			m := &msgs[i]
+			addr, ok := m.Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			s.dispatch(conn, handlers, addr, m.Buffers[0][:m.N], m.OOB[:m.NN])
+			bufs[i].Release()
+			bufs[i] = buffers.Get(s.mtu)
+			m.Buffers[0] = bufs[i].B
+		}
+	}
+}
+
+// dispatch passes a packet received from addr to the handler of addr,
+// creating it for the first packet.
+func (s *Server) dispatch(conn *net.UDPConn, handlers map[netip.AddrPort]*Handler, addr *net.UDPAddr, buf, oob []byte) {
+	handler, ok := handlers[addr.AddrPort()]
+	if !ok {
+		handler = &Handler{
+			reader: nil,
+			addr:   addr,
+			conn:   conn,
+		}
+		handlers[addr.AddrPort()] = handler
+		if err := s.onNewHandler(handler); err != nil {
+			// the handler is kept without reader, so that the
+			// remaining packets of the address are dropped
+			log.Printf("failed to set up handler for %v, dropping its packets: %v", addr, err)
+			handler.reader = nil
 		}
-		handler.receive(pkt{
-			buffer: buf[:n],
-		})
 	}
+	var ecn uint8
+	if s.ecn {
+		ecn = parseECN(oob)
+	}
+	handler.receive(pkt{
+		buffer: buf,
+		ecn:    ecn,
+	})
 }
 
 type pkt struct {
 	buffer []byte
+	ecn    uint8
 }
 
 type Handler struct {
@@ -90,13 +216,19 @@ type Handler struct {
 	conn   *net.UDPConn
 }
 
+// SetRTPReader sets the reader of the received packets. The packets are
+// only valid until Read returns.
 func (h *Handler) SetRTPReader(r interceptor.RTPReader) {
 	h.reader = r
 }
 
 func (h *Handler) receive(p pkt) {
-	if _, _, err := h.reader.Read(p.buffer, interceptor.Attributes{}); err != nil {
-		log.Printf("failed to process incoming packet: %v", err)
+	if h.reader == nil {
+		logging.Drop(logging.DropUnknownFlow, "no reader for packets from %v", h.addr)
+		return
+	}
+	if _, _, err := h.reader.Read(p.buffer, interceptor.Attributes{"ecn": p.ecn}); err != nil {
+		logging.Drop(logging.DropParseError, "failed to process incoming packet: %v", err)
 	}
 }
 
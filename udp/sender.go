@@ -3,11 +3,15 @@ package udp
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 
+	"github.com/Willi-42/rtp-over-quic/buffers"
+	"github.com/Willi-42/rtp-over-quic/logging"
 	"github.com/Willi-42/rtp-over-quic/rtp"
 	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
 	pionrtp "github.com/pion/rtp"
 )
 
@@ -20,23 +24,60 @@ func RemoteAddress(addr string) SenderOption {
 	}
 }
 
+// SetSenderECN marks the sent packets as ECN capable (ECT(0)).
+func SetSenderECN(enabled bool) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.ecn = enabled
+		return nil
+	}
+}
+
+// SetBatch sends up to size packets with one system call (sendmmsg on
+// Linux). Packets wait for the end of their frame, the batch to fill up or
+// at most a millisecond. 0 and 1 send each packet on its own.
+func SetBatch(size int) SenderOption {
+	return func(sc *SenderConfig) error {
+		if size < 0 {
+			return fmt.Errorf("%w: negative batch size %v", errInvalidConfig, size)
+		}
+		sc.batchSize = size
+		return nil
+	}
+}
+
+// SetGSO passes runs of packets of the same size in a batch to the kernel as
+// one message, which segments them (UDP GSO, Linux only). It batches up to
+// 64 packets if no batch size is set.
+func SetGSO(enabled bool) SenderOption {
+	return func(sc *SenderConfig) error {
+		sc.gso = enabled
+		return nil
+	}
+}
+
 type SenderConfig struct {
 	remoteAddr string
+	ecn        bool
+	batchSize  int
+	gso        bool
 }
 
 type Sender struct {
 	*SenderConfig
 
 	conn                *net.UDPConn
+	batcher             *batcher
 	interceptorRegistry *interceptor.Registry
 	interceptor         interceptor.Interceptor
+	sources             *rtp.Sources
 }
 
 func NewSender(i *interceptor.Registry, opts ...SenderOption) (*Sender, error) {
 	s := &Sender{
-		SenderConfig:        &SenderConfig{remoteAddr: ""},
+		SenderConfig:        &SenderConfig{remoteAddr: "", ecn: false, batchSize: 0, gso: false},
 		conn:                nil,
 		interceptorRegistry: i,
+		sources:             rtp.NewSources(),
 	}
 	for _, opt := range opts {
 		if err := opt(s.SenderConfig); err != nil {
@@ -52,6 +93,23 @@ func (s *Sender) Connect(ctx context.Context) error {
 		return err
 	}
 	s.conn = conn
+	if s.ecn {
+		if err := enableECN(conn); err != nil {
+			return err
+		}
+	}
+	if s.gso {
+		if err := checkGSO(conn); err != nil {
+			return err
+		}
+		size := s.batchSize
+		if size < 2 {
+			size = maxGSOSegments
+		}
+		s.batcher = newBatcher(conn, size, true)
+	} else if s.batchSize > 1 {
+		s.batcher = newBatcher(conn, s.batchSize, false)
+	}
 
 	i, err := s.interceptorRegistry.Build("")
 	if err != nil {
@@ -64,6 +122,7 @@ func (s *Sender) Connect(ctx context.Context) error {
 			return len(b), a, nil
 		}),
 	)
+	s.interceptor.BindRTCPWriter(interceptor.RTCPWriterFunc(s.writeRTCP))
 
 	rtcpChan := make(chan rtp.RTCPFeedback)
 	go rtp.ReadRTCP(ctx, rtcpReader, rtcpChan)
@@ -72,6 +131,42 @@ func (s *Sender) Connect(ctx context.Context) error {
 	return nil
 }
 
+func (s *Sender) writeRTCP(pkts []rtcp.Packet, _ interceptor.Attributes) (int, error) {
+	buf, err := rtcp.Marshal(pkts)
+	if err != nil {
+		return 0, err
+	}
+	return s.conn.Write(buf)
+}
+
+// Close sends an RTCP BYE for all sent SSRCs, closes the interceptors and
+// then the socket. The media has to be stopped before.
+func (s *Sender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	if s.batcher != nil {
+		if err := s.batcher.flush(); err != nil {
+			log.Printf("failed to send last UDP batch: %v", err)
+		}
+	}
+	if bye := s.sources.Goodbye("sender shutdown"); bye != nil {
+		if _, err := s.writeRTCP(bye, nil); err != nil {
+			log.Printf("failed to send RTCP BYE: %v", err)
+		}
+	}
+	var err error
+	if s.interceptor != nil {
+		if err = s.interceptor.Close(); err != nil {
+			err = fmt.Errorf("failed to close interceptors: %w", err)
+		}
+	}
+	if cerr := s.conn.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
 func (s *Sender) readFromNetwork(ctx context.Context, rtcpChan chan rtp.RTCPFeedback) {
 	buf := make([]byte, 1500) // TODO: Better MTU?
 	for {
@@ -91,20 +186,40 @@ func (s *Sender) readFromNetwork(ctx context.Context, rtcpChan chan rtp.RTCPFeed
 		}:
 		case <-ctx.Done():
 		default:
-			log.Println("RTCP buffer full, dropping packet")
+			logging.Drop(logging.DropQueueOverflow, "RTCP buffer full")
 		}
 	}
 }
 
 func (s *Sender) NewMediaStream() interceptor.RTPWriter {
-	return s.interceptor.BindLocalStream(&interceptor.StreamInfo{}, interceptor.RTPWriterFunc(
+	return s.interceptor.BindLocalStream(rtp.NewLocalStreamInfo(), rtp.TraceTransport("udp", interceptor.RTPWriterFunc(
 		func(header *pionrtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
-
+			s.sources.Add(header.SSRC)
+			if s.batcher != nil {
+				return s.writeBatched(header, payload)
+			}
 			headerBuf, err := header.Marshal()
 			if err != nil {
 				return 0, err
 			}
 			return s.conn.Write(append(headerBuf, payload...))
 		},
-	))
+	)))
+}
+
+// writeBatched adds the packet to the batch, which is sent at the end of the
+// frame.
+func (s *Sender) writeBatched(header *pionrtp.Header, payload []byte) (int, error) {
+	size := header.MarshalSize() + len(payload)
+	buf := buffers.Get(size)
+	n, err := header.MarshalTo(buf.B)
+	if err != nil {
+		buf.Release()
+		return 0, err
+	}
+	copy(buf.B[n:], payload)
+	if err := s.batcher.write(buf, header.Marker); err != nil {
+		return 0, err
+	}
+	return size, nil
 }
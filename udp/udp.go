@@ -13,6 +13,17 @@ import (
 
 const desiredReceiveBufferSize = (1 << 20) * 2 // 2 MB
 
+// ECN codepoints (RFC 3168)
+const (
+	ecnECT0 = 0x02
+	ecnMask = 0x03
+)
+
+var (
+	errECNUnsupported = errors.New("ECN not supported")
+	errInvalidConfig  = errors.New("invalid configuration")
+)
+
 func listenUDP(addr string) (*net.UDPConn, error) {
 	a, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {